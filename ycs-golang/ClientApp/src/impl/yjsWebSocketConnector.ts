@@ -15,11 +15,18 @@ interface YjsPendingReceivedMessage {
   readonly inReplyTo?: string;
 }
 
+interface YjsSessionInfo {
+  readonly sessionId: string;
+  readonly resumed: boolean;
+  readonly clientClock: number;
+}
+
 enum YjsMessageType {
   GetMissing = 'GetMissing',        // SyncStep1
   Update = 'Update',                // SyncStep2
   QueryAwareness = 'QueryAwareness',    // Other clients will broadcast their awareness info.
-  UpdateAwareness = 'UpdateAwareness'   // Broadcast awareness info.
+  UpdateAwareness = 'UpdateAwareness',  // Broadcast awareness info.
+  Session = 'Session'               // Sent by the server when the connection opens.
 }
 
 export class YjsWebSocketConnector {
@@ -32,8 +39,10 @@ export class YjsWebSocketConnector {
   private _connected: boolean = false;
 
   private _receiveQueue: YjsPendingReceivedMessage[] = [];
+  private _offlineUpdates: Uint8Array[] = [];
   private _clientClock: number = -1;
   private _serverClock: number = -1;
+  private _sessionId?: string = undefined;
   private _resyncInterval?: number = undefined;
   private _reconnectTimeout?: number = undefined;
 
@@ -67,12 +76,12 @@ export class YjsWebSocketConnector {
 
   private _connect(): void {
     try {
-      this._ws = new WebSocket(this._url);
-      
+      this._ws = new WebSocket(this._sessionUrl());
+
+      // The server sends the session info first; it tells whether we resume or sync again.
+      // Until it arrives, nothing is sent, as the client clock is not known yet.
       this._ws.onopen = () => {
         console.log('WebSocket connected');
-        this._connected = true;
-        this._resetConnectionAsync();
       };
 
       this._ws.onclose = () => {
@@ -100,6 +109,16 @@ export class YjsWebSocketConnector {
     }
   }
 
+  // Present the previous session and the last processed server clock to resume where we left off.
+  private _sessionUrl(): string {
+    if (!this._sessionId) {
+      return this._url;
+    }
+
+    const separator = this._url.includes('?') ? '&' : '?';
+    return `${this._url}${separator}session=${encodeURIComponent(this._sessionId)}&serverClock=${this._serverClock}`;
+  }
+
   private _attemptReconnect(): void {
     if (this._reconnectTimeout) {
       clearTimeout(this._reconnectTimeout);
@@ -112,7 +131,9 @@ export class YjsWebSocketConnector {
   }
 
   private _handleMessage(message: any): void {
-    if (message.type === YjsMessageType.GetMissing && message.data) {
+    if (message.type === YjsMessageType.Session && message.data) {
+      this._onSessionReceived(message.data);
+    } else if (message.type === YjsMessageType.GetMissing && message.data) {
       this._onGetMissingReceived(message.data);
     } else if (message.type === YjsMessageType.Update && message.data) {
       this._onUpdateReceived(message.data);
@@ -149,6 +170,12 @@ export class YjsWebSocketConnector {
 
   private _yDocUpdateV2Handler = (updateMessage: Uint8Array, origin: object | undefined): void => {
     if (origin !== this && origin !== 'websocket') {
+      if (!this.connected) {
+        // Sent once the session is resumed; a new session syncs them with the rest of the document.
+        this._offlineUpdates.push(updateMessage);
+        return;
+      }
+
       this._sendMessageAsync(YjsMessageType.Update, updateMessage, undefined);
     }
   };
@@ -173,6 +200,28 @@ export class YjsWebSocketConnector {
     }
   };
 
+  private _onSessionReceived = (data: YjsSessionInfo): void => {
+    this._sessionId = data.sessionId;
+    this._connected = true;
+
+    if (data.resumed && data.clientClock === this._clientClock) {
+      // The server processed everything we sent and replays what we missed, which it queued
+      // right after the session info. Edits made while we were offline follow the replay.
+      this._sendOfflineUpdatesAsync();
+      this._requestAndBroadcastAwareness();
+    } else if (data.resumed) {
+      // Some of our messages were lost; continue numbering after the last one the server processed.
+      // The server answers GetMissing with its own state vector, and our reply to that carries
+      // the lost messages together with the offline edits.
+      this._clientClock = data.clientClock;
+      this._offlineUpdates = [];
+      this._requestMissingAsync();
+      this._requestAndBroadcastAwareness();
+    } else {
+      this._resetConnectionAsync();
+    }
+  };
+
   private _onQueryAwareness = (): void => {
     const update = awarenessProtocol.encodeAwarenessUpdate(this._awareness, Array.from(this._awareness.getStates().keys()));
     this._sendMessageAsync(YjsMessageType.UpdateAwareness, update, undefined);
@@ -190,6 +239,7 @@ export class YjsWebSocketConnector {
     this._clientClock = -1;
     this._serverClock = -1;
     this._receiveQueue = [];
+    this._offlineUpdates = [];
 
     if (this.connected) {
       await this._requestMissingAsync();
@@ -212,6 +262,15 @@ export class YjsWebSocketConnector {
     await this._sendMessageAsync(YjsMessageType.GetMissing, stateVector, undefined);
   }
 
+  private async _sendOfflineUpdatesAsync(): Promise<void> {
+    const updates = this._offlineUpdates;
+    this._offlineUpdates = [];
+
+    for (const update of updates) {
+      await this._sendMessageAsync(YjsMessageType.Update, update, undefined);
+    }
+  }
+
   private async _requestAndBroadcastAwareness(): Promise<void> {
     if (!this.connected) {
       return;
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"ycs/protocols"
+
+	"github.com/gorilla/websocket"
+)
+
+// protocolBinary selects the binary y-protocol on /ws with ?protocol=binary
+const protocolBinary = "binary"
+
+// binaryTransport speaks the y-websocket binary protocol. The sync messages map to
+// the JSON commands: SyncStep1 is GetMissing, SyncStep2 is an Update in reply to
+// GetMissing. Messages are ordered by the connection, so no clocks are sent.
+// Sync messages of subdocuments are sent as MessageSubdocSync frames.
+type binaryTransport struct {
+	conn *websocket.Conn
+}
+
+func (t *binaryTransport) WriteMessage(command YjsCommandType, data interface{}) error {
+	frame, err := encodeBinaryMessage(command, data)
+	if err != nil || frame == nil {
+		return err
+	}
+
+	t.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return t.conn.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// encodeBinaryMessage encodes a message as a y-websocket frame, or returns nil for
+// messages that binary clients do not receive
+func encodeBinaryMessage(command YjsCommandType, data interface{}) ([]byte, error) {
+	switch v := data.(type) {
+	case SessionInfo:
+		// Binary clients have no sessions; they sync again after reconnecting
+		return nil, nil
+	case YjsMessage:
+		payload, err := base64.StdEncoding.DecodeString(v.Data)
+		if err != nil {
+			return nil, err
+		}
+		var syncType uint32
+		switch {
+		case command == GetMissing:
+			syncType = protocols.MessageYjsSyncStep1
+		case command == Update && v.InReplyTo != nil && *v.InReplyTo == GetMissing:
+			syncType = protocols.MessageYjsSyncStep2
+		case command == Update:
+			syncType = protocols.MessageYjsUpdate
+		default:
+			return nil, fmt.Errorf("unsupported binary command: %s", command)
+		}
+		if v.Guid != "" {
+			return protocols.EncodeSubdocSyncMessage(v.Guid, syncType, payload), nil
+		}
+		return protocols.EncodeSyncMessage(syncType, payload), nil
+	case string:
+		update, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, err
+		}
+		return protocols.EncodeAwarenessMessage(update), nil
+	default:
+		return nil, fmt.Errorf("unsupported binary message: %T", data)
+	}
+}
+
+func (t *binaryTransport) WriteClose(code int, reason string) error {
+	closeMessage := websocket.FormatCloseMessage(code, reason)
+	return t.conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(writeTimeout))
+}
+
+func (t *binaryTransport) Close() error {
+	return t.conn.Close()
+}
+
+// serveBinaryClient reads y-websocket frames from a connection until it closes
+func serveBinaryClient(ycsManager *YcsManager, conn *websocket.Conn) {
+	client, connection, _ := ycsManager.HandleClientConnected("", -1, &binaryTransport{conn: conn})
+	clientID := client.GetSessionID()
+	// Binary clients cannot resume, so the session ends with the connection
+	defer ycsManager.HandleClientDisconnected(clientID)
+	defer ycsManager.HandleClientDetached(client, connection)
+
+	// The connection orders the messages; number them as the JSON clients do
+	clock := int64(0)
+	for {
+		messageType, frame, err := conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				rejectedMessages.WithLabelValues(rejectTooLarge).Inc()
+			}
+			logDebugf("Error reading message: %v", err)
+			return
+		}
+		if messageType != websocket.BinaryMessage {
+			rejectedMessages.WithLabelValues(rejectMalformed).Inc()
+			logWarnf("Ignoring text message from binary client %s", clientID)
+			continue
+		}
+
+		message, err := protocols.DecodeWebSocketMessage(frame)
+		if err != nil {
+			rejectedMessages.WithLabelValues(rejectMalformed).Inc()
+			logWarnf("Invalid binary message: %v", err)
+			continue
+		}
+
+		data := base64.StdEncoding.EncodeToString(message.Payload)
+		switch message.Type {
+		case protocols.MessageSync, protocols.MessageSubdocSync:
+			messageToProcess := &MessageToProcess{Command: Update, Data: data, Guid: message.Guid}
+			switch message.SyncType {
+			case protocols.MessageYjsSyncStep1:
+				messageToProcess.Command = GetMissing
+			case protocols.MessageYjsSyncStep2:
+				getMissingType := GetMissing
+				messageToProcess.InReplyTo = &getMissingType
+			}
+
+			logDebugf("Received %s message with clock %d from %s", messageToProcess.Command, clock, clientID)
+			if err := ycsManager.ProcessMessage(clientID, clock, messageToProcess); err != nil {
+				logErrorf("Error processing message: %v", err)
+			}
+			clock++
+		case protocols.MessageAwareness, protocols.MessageQueryAwareness:
+			command := UpdateAwareness
+			if message.Type == protocols.MessageQueryAwareness {
+				command = QueryAwareness
+			}
+
+			logDebugf("Received %s message from %s", command, clientID)
+			if err := ycsManager.ProcessAwareness(clientID, command, data); err != nil {
+				logErrorf("Error processing awareness message: %v", err)
+			}
+		default:
+			rejectedMessages.WithLabelValues(rejectUnknownType).Inc()
+			logWarnf("Unsupported binary message type %d from %s", message.Type, clientID)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package bus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"ycs/lib0"
+)
+
+// MessageType represents the kind of message relayed between replicas
+type MessageType uint32
+
+const (
+	// MessageUpdate carries a document update produced on the sending replica
+	MessageUpdate MessageType = 0
+	// MessageSyncStep1 carries the sender's state vector and asks peers for missing structs
+	MessageSyncStep1 MessageType = 1
+	// MessageSyncStep2 carries the structs missing from the state vector of a SyncStep1
+	MessageSyncStep2 MessageType = 2
+	// MessageResync is delivered by the bus itself, in order with the messages of the room,
+	// when messages may have been lost; subscribers that keep state should sync again
+	MessageResync MessageType = 3
+)
+
+// String returns string representation of MessageType
+func (t MessageType) String() string {
+	switch t {
+	case MessageUpdate:
+		return "Update"
+	case MessageSyncStep1:
+		return "SyncStep1"
+	case MessageSyncStep2:
+		return "SyncStep2"
+	case MessageResync:
+		return "Resync"
+	default:
+		return "Unknown"
+	}
+}
+
+// ErrClosed is returned when publishing to or subscribing on a closed bus
+var ErrClosed = errors.New("bus is closed")
+
+// Message represents a message relayed between replicas through a Bus
+type Message struct {
+	Type MessageType
+	// Replica is the ID of the replica that sent the message
+	Replica string
+	// Target is the ID of the replica the message is addressed to, or empty for all replicas
+	Target string
+	// Data holds the encoded state vector or update
+	Data []byte
+}
+
+// Encode encodes the message into its wire format
+func (m Message) Encode() []byte {
+	buf := &bytes.Buffer{}
+	lib0.WriteVarUint(buf, uint32(m.Type))
+	lib0.WriteVarString(buf, m.Replica)
+	lib0.WriteVarString(buf, m.Target)
+	lib0.WriteVarUint8Array(buf, m.Data)
+	return buf.Bytes()
+}
+
+// DecodeMessage decodes a message from its wire format
+func DecodeMessage(data []byte) (Message, error) {
+	reader := bytes.NewReader(data)
+
+	messageType, err := lib0.ReadVarUint(reader)
+	if err != nil {
+		return Message{}, fmt.Errorf("reading message type: %w", err)
+	}
+
+	replica, err := lib0.ReadVarString(reader)
+	if err != nil {
+		return Message{}, fmt.Errorf("reading replica: %w", err)
+	}
+
+	target, err := lib0.ReadVarString(reader)
+	if err != nil {
+		return Message{}, fmt.Errorf("reading target: %w", err)
+	}
+
+	payload, err := lib0.ReadVarUint8Array(reader)
+	if err != nil {
+		return Message{}, fmt.Errorf("reading payload: %w", err)
+	}
+
+	return Message{
+		Type:    MessageType(messageType),
+		Replica: replica,
+		Target:  target,
+		Data:    payload,
+	}, nil
+}
+
+// Handler is called for every message published to a subscribed room
+type Handler func(Message)
+
+// Bus relays messages about room documents between server replicas.
+// Messages published to a room are delivered to every subscriber of that room,
+// including subscribers on the publishing replica; receivers filter by Message.Replica.
+// A subscriber that falls too far behind, or a bus that loses its connection, drops
+// messages and delivers a MessageResync instead.
+type Bus interface {
+	// Publish sends a message to all subscribers of the room
+	Publish(room string, msg Message) error
+	// Subscribe registers a handler for the room and returns a function that removes it
+	Subscribe(room string, handler Handler) (func(), error)
+	// Close releases the bus resources; no messages are delivered afterwards
+	Close() error
+}
+
+// Origin is the transaction origin of updates applied from a peer replica.
+// Updates with this origin must not be published again to avoid echo loops.
+type Origin struct {
+	Replica string
+}
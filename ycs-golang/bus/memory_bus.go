@@ -0,0 +1,89 @@
+package bus
+
+import (
+	"sync"
+)
+
+// MemoryBus is an in-process Bus, useful for tests and for running several
+// replicas inside one process. Handlers are called asynchronously, one
+// goroutine per subscription, in the order messages were published.
+type MemoryBus struct {
+	rooms  map[string]map[*subscription]struct{}
+	closed bool
+	mutex  sync.RWMutex
+}
+
+// NewMemoryBus creates a new MemoryBus
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		rooms: make(map[string]map[*subscription]struct{}),
+	}
+}
+
+// Publish sends a message to all subscribers of the room
+func (b *MemoryBus) Publish(room string, msg Message) error {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	// Copy the payload so that subscribers never share memory with the publisher
+	data := make([]byte, len(msg.Data))
+	copy(data, msg.Data)
+	msg.Data = data
+
+	for sub := range b.rooms[room] {
+		sub.enqueue(msg)
+	}
+	return nil
+}
+
+// Subscribe registers a handler for the room and returns a function that removes it
+func (b *MemoryBus) Subscribe(room string, handler Handler) (func(), error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	sub := newSubscription(handler)
+	if b.rooms[room] == nil {
+		b.rooms[room] = make(map[*subscription]struct{})
+	}
+	b.rooms[room][sub] = struct{}{}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			delete(b.rooms[room], sub)
+			if len(b.rooms[room]) == 0 {
+				delete(b.rooms, room)
+			}
+			b.mutex.Unlock()
+			sub.close()
+		})
+	}, nil
+}
+
+// Close stops delivering messages to all subscribers
+func (b *MemoryBus) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	for _, subs := range b.rooms {
+		for sub := range subs {
+			sub.close()
+		}
+	}
+	b.rooms = make(map[string]map[*subscription]struct{})
+	return nil
+}
@@ -0,0 +1,134 @@
+package bus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// receive returns the next message of ch, failing the test after a second
+func receive(t *testing.T, ch <-chan Message) Message {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+		return Message{}
+	}
+}
+
+func TestMessageEncodingRoundTrip(t *testing.T) {
+	msg := Message{Type: MessageSyncStep2, Replica: "a", Target: "b", Data: []byte{1, 2, 3}}
+	decoded, err := DecodeMessage(msg.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Type != msg.Type || decoded.Replica != msg.Replica || decoded.Target != msg.Target || !bytes.Equal(decoded.Data, msg.Data) {
+		t.Fatalf("got %+v, want %+v", decoded, msg)
+	}
+
+	if _, err := DecodeMessage(msg.Encode()[:3]); err == nil {
+		t.Fatal("no error for a truncated message")
+	}
+}
+
+func TestMemoryBusDeliversInOrder(t *testing.T) {
+	b := NewMemoryBus()
+	defer b.Close()
+
+	received := make(chan Message, 10)
+	unsubscribe, err := b.Subscribe("room", func(msg Message) { received <- msg })
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := make(chan Message, 10)
+	if _, err := b.Subscribe("other", func(msg Message) { other <- msg }); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte{1}
+	for i := 0; i < 5; i++ {
+		data[0] = byte(i)
+		if err := b.Publish("room", Message{Replica: "a", Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		// Every message has its own copy of the payload
+		if msg := receive(t, received); msg.Data[0] != byte(i) {
+			t.Fatalf("message %d has data %v", i, msg.Data)
+		}
+	}
+	if len(other) != 0 {
+		t.Fatal("message delivered to another room")
+	}
+
+	unsubscribe()
+	unsubscribe()
+	b.Publish("room", Message{Replica: "a"})
+	select {
+	case msg := <-received:
+		t.Fatalf("received %+v after unsubscribing", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryBusClosed(t *testing.T) {
+	b := NewMemoryBus()
+	b.Close()
+	if err := b.Publish("room", Message{}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Publish: got %v, want %v", err, ErrClosed)
+	}
+	if _, err := b.Subscribe("room", func(Message) {}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Subscribe: got %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestSlowSubscriberIsAskedToResync(t *testing.T) {
+	b := NewMemoryBus()
+	defer b.Close()
+
+	blocked := make(chan struct{}, 1)
+	release := make(chan struct{})
+	received := make(chan Message, maxSubscriptionQueue+10)
+	if _, err := b.Subscribe("room", func(msg Message) {
+		select {
+		case blocked <- struct{}{}:
+		default:
+		}
+		<-release
+		received <- msg
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first message blocks the handler, the others fill the queue until it overflows
+	publish := func(i int) {
+		if err := b.Publish("room", Message{Replica: "a", Data: []byte{byte(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish(0)
+	<-blocked
+	for i := 1; i < maxSubscriptionQueue+2; i++ {
+		publish(i)
+	}
+	close(release)
+
+	if msg := receive(t, received); msg.Type != MessageUpdate || msg.Data[0] != 0 {
+		t.Fatalf("first message %+v", msg)
+	}
+	if msg := receive(t, received); msg.Type != MessageResync {
+		t.Fatalf("got %+v instead of a resync", msg)
+	}
+	if msg := receive(t, received); msg.Data[0] != byte((maxSubscriptionQueue+1)%256) {
+		t.Fatalf("got %+v after the resync", msg)
+	}
+	select {
+	case msg := <-received:
+		t.Fatalf("received dropped message %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
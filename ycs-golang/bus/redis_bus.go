@@ -0,0 +1,466 @@
+package bus
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redisDialTimeout     = 5 * time.Second
+	redisMinReconnect    = 100 * time.Millisecond
+	redisMaxReconnect    = 5 * time.Second
+	defaultChannelPrefix = "ycs:"
+)
+
+// RedisOptions represents options for RedisBus
+type RedisOptions struct {
+	// Addr is the host:port of the Redis server
+	Addr string
+	// Password is sent with AUTH when not empty
+	Password string
+	// ChannelPrefix is prepended to room names to build channel names, defaults to "ycs:"
+	ChannelPrefix string
+}
+
+// redisError represents an error reply sent by the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisConn is a connection speaking the Redis serialization protocol (RESP)
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func dialRedis(opts RedisOptions) (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", opts.Addr, redisDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &redisConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+	}
+
+	if opts.Password != "" {
+		if _, err := rc.do("AUTH", []byte(opts.Password)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return rc, nil
+}
+
+// send writes a command as an array of bulk strings
+func (rc *redisConn) send(command string, args ...[]byte) error {
+	fmt.Fprintf(rc.writer, "*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(command), command)
+	for _, arg := range args {
+		fmt.Fprintf(rc.writer, "$%d\r\n", len(arg))
+		rc.writer.Write(arg)
+		rc.writer.WriteString("\r\n")
+	}
+	return rc.writer.Flush()
+}
+
+// do sends a command and reads its reply
+func (rc *redisConn) do(command string, args ...[]byte) (interface{}, error) {
+	if err := rc.send(command, args...); err != nil {
+		return nil, err
+	}
+
+	reply, err := rc.readReply()
+	if err != nil {
+		return nil, err
+	}
+
+	if replyErr, ok := reply.(redisError); ok {
+		return nil, replyErr
+	}
+	return reply, nil
+}
+
+// readReply reads one reply: string for simple strings, redisError for errors,
+// int64 for integers, []byte for bulk strings and []interface{} for arrays
+func (rc *redisConn) readReply() (interface{}, error) {
+	line, err := rc.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	payload := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return redisError(payload), nil
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		length, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(rc.reader, data); err != nil {
+			return nil, err
+		}
+		return data[:length], nil
+	case '*':
+		count, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = rc.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+	}
+}
+
+func (rc *redisConn) close() error {
+	return rc.conn.Close()
+}
+
+// RedisBus is a Bus backed by Redis PUBLISH/SUBSCRIBE.
+// Every room maps to the channel ChannelPrefix+room. One connection is used to
+// publish and a second one to receive; the receiving connection is re-established
+// with exponential backoff if it drops. Messages published meanwhile are lost, so
+// subscribers receive a MessageResync once their room is subscribed again.
+type RedisBus struct {
+	opts RedisOptions
+
+	pub      *redisConn
+	pubMutex sync.Mutex
+
+	sub      *redisConn
+	subMutex sync.Mutex
+
+	rooms map[string]map[*subscription]struct{}
+	// resubscribing holds the rooms subscribed again after a reconnect until Redis confirms them
+	resubscribing map[string]struct{}
+	closed        bool
+	mutex         sync.RWMutex
+	done          chan struct{}
+}
+
+// NewRedisBus connects to the Redis server and starts receiving messages
+func NewRedisBus(opts RedisOptions) (*RedisBus, error) {
+	if opts.Addr == "" {
+		return nil, errors.New("redis address is required")
+	}
+	if opts.ChannelPrefix == "" {
+		opts.ChannelPrefix = defaultChannelPrefix
+	}
+
+	pub, err := dialRedis(opts)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to redis: %w", err)
+	}
+
+	sub, err := dialRedis(opts)
+	if err != nil {
+		pub.close()
+		return nil, fmt.Errorf("connecting to redis: %w", err)
+	}
+
+	b := &RedisBus{
+		opts:          opts,
+		pub:           pub,
+		sub:           sub,
+		rooms:         make(map[string]map[*subscription]struct{}),
+		resubscribing: make(map[string]struct{}),
+		done:          make(chan struct{}),
+	}
+
+	go b.receive()
+	return b, nil
+}
+
+func (b *RedisBus) channel(room string) string {
+	return b.opts.ChannelPrefix + room
+}
+
+// Publish sends a message to all subscribers of the room
+func (b *RedisBus) Publish(room string, msg Message) error {
+	b.mutex.RLock()
+	closed := b.closed
+	b.mutex.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+
+	b.pubMutex.Lock()
+	defer b.pubMutex.Unlock()
+
+	payload := msg.Encode()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if b.pub == nil {
+			if b.pub, err = dialRedis(b.opts); err != nil {
+				continue
+			}
+		}
+
+		if _, err = b.pub.do("PUBLISH", []byte(b.channel(room)), payload); err == nil {
+			return nil
+		}
+
+		// The connection is in an unknown state, start over with a new one
+		b.pub.close()
+		b.pub = nil
+	}
+	return fmt.Errorf("publishing to redis: %w", err)
+}
+
+// IsConnected reports whether the receiving connection is established
+func (b *RedisBus) IsConnected() bool {
+	b.subMutex.Lock()
+	defer b.subMutex.Unlock()
+	return b.sub != nil
+}
+
+// Subscribe registers a handler for the room and returns a function that removes it
+func (b *RedisBus) Subscribe(room string, handler Handler) (func(), error) {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return nil, ErrClosed
+	}
+
+	sub := newSubscription(handler)
+	first := b.rooms[room] == nil
+	if first {
+		b.rooms[room] = make(map[*subscription]struct{})
+	}
+	b.rooms[room][sub] = struct{}{}
+	b.mutex.Unlock()
+
+	if first {
+		if err := b.sendSub("SUBSCRIBE", room); err != nil {
+			// The receive loop subscribes to all rooms again once it reconnects
+			log.Printf("Error subscribing to room %s: %v", room, err)
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			delete(b.rooms[room], sub)
+			last := len(b.rooms[room]) == 0
+			if last {
+				delete(b.rooms, room)
+				delete(b.resubscribing, room)
+			}
+			closed := b.closed
+			b.mutex.Unlock()
+			sub.close()
+
+			if last && !closed {
+				if err := b.sendSub("UNSUBSCRIBE", room); err != nil {
+					log.Printf("Error unsubscribing from room %s: %v", room, err)
+				}
+			}
+		})
+	}, nil
+}
+
+// sendSub writes a (UN)SUBSCRIBE command; the reply is consumed by the receive loop
+func (b *RedisBus) sendSub(command string, rooms ...string) error {
+	b.subMutex.Lock()
+	defer b.subMutex.Unlock()
+
+	if b.sub == nil {
+		return errors.New("not connected")
+	}
+
+	args := make([][]byte, len(rooms))
+	for i, room := range rooms {
+		args[i] = []byte(b.channel(room))
+	}
+	return b.sub.send(command, args...)
+}
+
+// receive reads pushed messages and reconnects when the connection drops
+func (b *RedisBus) receive() {
+	delay := redisMinReconnect
+
+	for {
+		b.subMutex.Lock()
+		conn := b.sub
+		b.subMutex.Unlock()
+
+		if conn != nil {
+			err := b.readMessages(conn)
+			conn.close()
+
+			b.subMutex.Lock()
+			if b.sub == conn {
+				b.sub = nil
+			}
+			b.subMutex.Unlock()
+
+			select {
+			case <-b.done:
+				return
+			default:
+			}
+			log.Printf("Redis bus connection lost: %v", err)
+		}
+
+		select {
+		case <-b.done:
+			return
+		case <-time.After(delay):
+		}
+
+		conn, err := dialRedis(b.opts)
+		if err != nil {
+			delay *= 2
+			if delay > redisMaxReconnect {
+				delay = redisMaxReconnect
+			}
+			b.subMutex.Lock()
+			b.sub = nil
+			b.subMutex.Unlock()
+			continue
+		}
+		delay = redisMinReconnect
+
+		b.subMutex.Lock()
+		b.sub = conn
+		b.subMutex.Unlock()
+
+		b.mutex.Lock()
+		rooms := make([]string, 0, len(b.rooms))
+		for room := range b.rooms {
+			rooms = append(rooms, room)
+			b.resubscribing[room] = struct{}{}
+		}
+		b.mutex.Unlock()
+
+		if len(rooms) > 0 {
+			if err := b.sendSub("SUBSCRIBE", rooms...); err != nil {
+				log.Printf("Error resubscribing to rooms: %v", err)
+			}
+		}
+	}
+}
+
+func (b *RedisBus) readMessages(conn *redisConn) error {
+	for {
+		reply, err := conn.readReply()
+		if err != nil {
+			return err
+		}
+
+		// Pushed messages look like ["message", channel, payload] and subscription
+		// confirmations like ["subscribe", channel, count]; other replies are ignored
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 3 {
+			continue
+		}
+		kind, _ := items[0].([]byte)
+		channel, _ := items[1].([]byte)
+		if !strings.HasPrefix(string(channel), b.opts.ChannelPrefix) {
+			continue
+		}
+		if string(kind) == "subscribe" {
+			b.confirmSubscription(strings.TrimPrefix(string(channel), b.opts.ChannelPrefix))
+			continue
+		}
+		payload, _ := items[2].([]byte)
+		if string(kind) != "message" {
+			continue
+		}
+
+		msg, err := DecodeMessage(payload)
+		if err != nil {
+			log.Printf("Error decoding bus message: %v", err)
+			continue
+		}
+
+		room := strings.TrimPrefix(string(channel), b.opts.ChannelPrefix)
+		b.mutex.RLock()
+		for sub := range b.rooms[room] {
+			sub.enqueue(msg)
+		}
+		b.mutex.RUnlock()
+	}
+}
+
+// confirmSubscription asks the subscribers of a room subscribed again after a
+// reconnect to sync, now that they receive the messages published to it
+func (b *RedisBus) confirmSubscription(room string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.resubscribing[room]; !ok {
+		return
+	}
+	delete(b.resubscribing, room)
+	for sub := range b.rooms[room] {
+		sub.resync()
+	}
+}
+
+// Close closes both connections and stops delivering messages
+func (b *RedisBus) Close() error {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+
+	for _, subs := range b.rooms {
+		for sub := range subs {
+			sub.close()
+		}
+	}
+	b.rooms = make(map[string]map[*subscription]struct{})
+	b.mutex.Unlock()
+
+	b.subMutex.Lock()
+	if b.sub != nil {
+		b.sub.close()
+	}
+	b.subMutex.Unlock()
+
+	b.pubMutex.Lock()
+	defer b.pubMutex.Unlock()
+	if b.pub != nil {
+		return b.pub.close()
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package bus
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis is a Redis server that knows just enough of PUBLISH and SUBSCRIBE for RedisBus
+type fakeRedis struct {
+	listener    net.Listener
+	subscribers map[string]map[*redisConn]struct{}
+	conns       map[*redisConn]struct{}
+	mutex       sync.Mutex
+}
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeRedis{
+		listener:    listener,
+		subscribers: make(map[string]map[*redisConn]struct{}),
+		conns:       make(map[*redisConn]struct{}),
+	}
+	go server.serve()
+	t.Cleanup(server.close)
+	return server
+}
+
+func (s *fakeRedis) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		rc := &redisConn{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+		s.mutex.Lock()
+		s.conns[rc] = struct{}{}
+		s.mutex.Unlock()
+		go s.handle(rc)
+	}
+}
+
+func (s *fakeRedis) handle(rc *redisConn) {
+	defer s.drop(rc)
+	for {
+		request, err := rc.readReply()
+		if err != nil {
+			return
+		}
+		args, _ := request.([]interface{})
+		if len(args) == 0 {
+			return
+		}
+		command, _ := args[0].([]byte)
+
+		s.mutex.Lock()
+		switch strings.ToUpper(string(command)) {
+		case "SUBSCRIBE":
+			for _, arg := range args[1:] {
+				channel := string(arg.([]byte))
+				if s.subscribers[channel] == nil {
+					s.subscribers[channel] = make(map[*redisConn]struct{})
+				}
+				s.subscribers[channel][rc] = struct{}{}
+				fmt.Fprintf(rc.writer, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(channel), channel)
+			}
+		case "UNSUBSCRIBE":
+			for _, arg := range args[1:] {
+				channel := string(arg.([]byte))
+				delete(s.subscribers[channel], rc)
+				fmt.Fprintf(rc.writer, "*3\r\n$11\r\nunsubscribe\r\n$%d\r\n%s\r\n:0\r\n", len(channel), channel)
+			}
+		case "PUBLISH":
+			channel, payload := string(args[1].([]byte)), args[2].([]byte)
+			for subscriber := range s.subscribers[channel] {
+				fmt.Fprintf(subscriber.writer, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(channel), channel, len(payload), payload)
+				subscriber.writer.Flush()
+			}
+			fmt.Fprintf(rc.writer, ":%d\r\n", len(s.subscribers[channel]))
+		default:
+			rc.writer.WriteString("+OK\r\n")
+		}
+		rc.writer.Flush()
+		s.mutex.Unlock()
+	}
+}
+
+func (s *fakeRedis) drop(rc *redisConn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	rc.close()
+	delete(s.conns, rc)
+	for _, subscribers := range s.subscribers {
+		delete(subscribers, rc)
+	}
+}
+
+// dropSubscribers closes the connections that subscribed to a channel
+func (s *fakeRedis) dropSubscribers() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, subscribers := range s.subscribers {
+		for rc := range subscribers {
+			rc.close()
+		}
+	}
+}
+
+func (s *fakeRedis) close() {
+	s.listener.Close()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for rc := range s.conns {
+		rc.close()
+	}
+}
+
+func TestRedisBusAsksForResyncAfterReconnect(t *testing.T) {
+	server := startFakeRedis(t)
+	b, err := NewRedisBus(RedisOptions{Addr: server.listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	received := make(chan Message, 10)
+	if _, err := b.Subscribe("room", func(msg Message) { received <- msg }); err != nil {
+		t.Fatal(err)
+	}
+	// The first subscription is no reconnect and asks for nothing
+	eventually(t, func() bool {
+		b.Publish("room", Message{Replica: "a", Data: []byte{1}})
+		select {
+		case msg := <-received:
+			if msg.Type != MessageUpdate {
+				t.Fatalf("got %+v", msg)
+			}
+			return true
+		default:
+			return false
+		}
+	})
+
+	server.dropSubscribers()
+	for {
+		// Messages published while waiting for the subscription may still arrive
+		msg := receive(t, received)
+		if msg.Type == MessageResync {
+			break
+		}
+		if msg.Data[0] != 1 {
+			t.Fatalf("got %+v instead of a resync", msg)
+		}
+	}
+	if err := b.Publish("room", Message{Replica: "a", Data: []byte{2}}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, received); msg.Type != MessageUpdate || msg.Data[0] != 2 {
+		t.Fatalf("got %+v after reconnecting", msg)
+	}
+}
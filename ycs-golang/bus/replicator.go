@@ -0,0 +1,188 @@
+package bus
+
+import (
+	"log"
+	"sync"
+	"ycs/core"
+)
+
+// Replicator keeps the document of one room in sync with the same room on peer replicas.
+// Local updates are handed to Publish by the owner of the document (usually from its
+// OnUpdateV2 handler); updates from peers are applied to the document with an Origin
+// naming the sending replica, so that Publish can skip them and no echo loop forms.
+//
+// When started, the replicator broadcasts its state vector (SyncStep1). Every peer
+// answers with the structs the replica is missing (SyncStep2) and with its own state
+// vector addressed to the new replica, which answers in turn. After that round trip
+// both sides hold the union of their documents. The same round trip runs again
+// whenever the bus reports lost messages with a MessageResync.
+type Replicator struct {
+	bus     Bus
+	replica string
+	room    string
+	doc     *core.YDoc
+	lock    sync.Locker
+
+	unsubscribe func()
+	mutex       sync.Mutex
+}
+
+// NewReplicator creates a new Replicator for the room's document.
+// The lock guards every access of the replicator to the document; pass the lock
+// that the document's owner uses, or nil to use the lock of the document itself.
+func NewReplicator(b Bus, replica string, room string, doc *core.YDoc, lock sync.Locker) *Replicator {
+	if lock == nil {
+		lock = doc
+	}
+
+	return &Replicator{
+		bus:     b,
+		replica: replica,
+		room:    room,
+		doc:     doc,
+		lock:    lock,
+	}
+}
+
+// GetReplica returns the ID of the local replica
+func (r *Replicator) GetReplica() string {
+	return r.replica
+}
+
+// GetRoom returns the room name
+func (r *Replicator) GetRoom() string {
+	return r.room
+}
+
+// Start subscribes to the room and requests the missing state from peers
+func (r *Replicator) Start() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.unsubscribe != nil {
+		return nil
+	}
+
+	unsubscribe, err := r.bus.Subscribe(r.room, r.handleMessage)
+	if err != nil {
+		return err
+	}
+	r.unsubscribe = unsubscribe
+
+	return r.requestState()
+}
+
+// requestState broadcasts the state vector so that peers send the missing structs
+func (r *Replicator) requestState() error {
+	r.lock.Lock()
+	stateVector := r.doc.EncodeStateVectorV2()
+	r.lock.Unlock()
+
+	return r.bus.Publish(r.room, Message{
+		Type:    MessageSyncStep1,
+		Replica: r.replica,
+		Data:    stateVector,
+	})
+}
+
+// Publish sends a local update to peers unless it was received from a peer
+func (r *Replicator) Publish(update []byte, origin interface{}) error {
+	if len(update) == 0 {
+		return nil
+	}
+	if _, remote := origin.(Origin); remote {
+		return nil
+	}
+
+	return r.bus.Publish(r.room, Message{
+		Type:    MessageUpdate,
+		Replica: r.replica,
+		Data:    update,
+	})
+}
+
+// Close stops receiving updates from peers
+func (r *Replicator) Close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.unsubscribe != nil {
+		r.unsubscribe()
+		r.unsubscribe = nil
+	}
+}
+
+func (r *Replicator) handleMessage(msg Message) {
+	if msg.Replica == r.replica || (msg.Target != "" && msg.Target != r.replica) {
+		return
+	}
+
+	switch msg.Type {
+	case MessageUpdate, MessageSyncStep2:
+		r.apply(msg)
+	case MessageSyncStep1:
+		r.answerSyncStep1(msg)
+	case MessageResync:
+		// Updates of peers were lost; a broadcast SyncStep1 restores them, and the
+		// peers ask for ours in turn
+		if err := r.requestState(); err != nil {
+			log.Printf("Error resyncing room %s: %v", r.room, err)
+		}
+	default:
+		log.Printf("Ignoring bus message of unknown type %d from replica %s", msg.Type, msg.Replica)
+	}
+}
+
+func (r *Replicator) apply(msg Message) {
+	if len(msg.Data) == 0 {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.doc.ApplyUpdateV2(msg.Data, Origin{Replica: msg.Replica}, false); err != nil {
+		log.Printf("Error applying %s from replica %s in room %s: %v", msg.Type, msg.Replica, r.room, err)
+	}
+}
+
+func (r *Replicator) answerSyncStep1(msg Message) {
+	update, stateVector, err := r.encodeAnswer(msg)
+	if err != nil {
+		log.Printf("Error answering SyncStep1 from replica %s in room %s: %v", msg.Replica, r.room, err)
+		return
+	}
+
+	if err := r.bus.Publish(r.room, Message{
+		Type:    MessageSyncStep2,
+		Replica: r.replica,
+		Target:  msg.Replica,
+		Data:    update,
+	}); err != nil {
+		log.Printf("Error sending SyncStep2 to replica %s: %v", msg.Replica, err)
+	}
+
+	// A broadcast SyncStep1 comes from a replica that just joined; ask it for
+	// whatever it has that we do not. Addressed requests are answered only once.
+	if msg.Target == "" {
+		if err := r.bus.Publish(r.room, Message{
+			Type:    MessageSyncStep1,
+			Replica: r.replica,
+			Target:  msg.Replica,
+			Data:    stateVector,
+		}); err != nil {
+			log.Printf("Error sending SyncStep1 to replica %s: %v", msg.Replica, err)
+		}
+	}
+}
+
+// encodeAnswer encodes the structs missing from the peer's state vector and the local state vector
+func (r *Replicator) encodeAnswer(msg Message) (update []byte, stateVector []byte, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if update, err = r.doc.EncodeStateAsUpdateV2(msg.Data); err != nil {
+		return nil, nil, err
+	}
+	return update, r.doc.EncodeStateVectorV2(), nil
+}
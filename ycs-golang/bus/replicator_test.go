@@ -0,0 +1,136 @@
+package bus
+
+import (
+	"testing"
+	"time"
+	"ycs/contracts"
+	"ycs/core"
+)
+
+// startReplica creates a document that publishes its updates through a replicator
+func startReplica(t *testing.T, b Bus, name string) (*core.YDoc, *Replicator) {
+	t.Helper()
+	doc := core.NewYDoc(contracts.YDocOptions{})
+	r := NewReplicator(b, name, "room", doc, nil)
+	doc.OnUpdateV2(func(update []byte, origin interface{}, tr contracts.ITransaction) {
+		if err := r.Publish(update, origin); err != nil {
+			t.Errorf("publishing: %v", err)
+		}
+	})
+	return doc, r
+}
+
+// eventually fails the test unless cond returns true within a second
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal("condition not met within a second")
+}
+
+func TestReplicatorsConverge(t *testing.T) {
+	b := NewMemoryBus()
+	defer b.Close()
+
+	a, ra := startReplica(t, b, "a")
+	a.GetText("t").Insert(0, "before")
+	if err := ra.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ra.Close()
+
+	// A replica joining later receives the existing state and sends its own
+	c, rc := startReplica(t, b, "c")
+	c.GetMap("m").Set("k", "v")
+	if err := rc.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	text := func(doc *core.YDoc) (s string) {
+		doc.View(func() { s = doc.GetText("t").ToString() })
+		return s
+	}
+	eventually(t, func() bool { return text(c) == "before" })
+	eventually(t, func() bool {
+		var v interface{}
+		a.View(func() { v = a.GetMap("m").Get("k") })
+		return v == "v"
+	})
+
+	c.GetText("t").Insert(6, " after")
+	eventually(t, func() bool { return text(a) == "before after" })
+}
+
+func TestReplicatorDoesNotEchoRemoteUpdates(t *testing.T) {
+	b := NewMemoryBus()
+	defer b.Close()
+
+	published := make(chan Message, 100)
+	b.Subscribe("room", func(msg Message) { published <- msg })
+
+	_, r := startReplica(t, b, "a")
+	if err := r.Publish([]byte{1}, Origin{Replica: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Publish([]byte{2}, "local"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, published); msg.Data[0] != 2 || msg.Replica != "a" {
+		t.Fatalf("published %+v", msg)
+	}
+}
+
+func TestReplicatorResyncsAfterLostMessages(t *testing.T) {
+	b := NewMemoryBus()
+	defer b.Close()
+
+	a, ra := startReplica(t, b, "a")
+	if err := ra.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ra.Close()
+	c, rc := startReplica(t, b, "c")
+	c.GetMap("m").Set("k", "v")
+	if err := rc.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	// The map arrives with the last message of the initial round trip
+	eventually(t, func() bool {
+		var v interface{}
+		a.View(func() { v = a.GetMap("m").Get("k") })
+		return v == "v"
+	})
+
+	// An update that c received from a peer is not published again, so a only
+	// learns about it by syncing
+	other := core.NewYDoc(contracts.YDocOptions{})
+	other.GetText("t").Insert(0, "lost")
+	update, err := other.EncodeStateAsUpdateV2()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Lock()
+	err = c.ApplyUpdateV2(update, Origin{Replica: "other"}, false)
+	c.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := func() (s string) {
+		a.View(func() { s = a.GetText("t").ToString() })
+		return s
+	}
+	time.Sleep(50 * time.Millisecond)
+	if s := text(); s != "" {
+		t.Fatalf("update arrived without a resync: %q", s)
+	}
+
+	ra.handleMessage(Message{Type: MessageResync})
+	eventually(t, func() bool { return text() == "lost" })
+}
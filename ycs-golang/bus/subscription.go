@@ -0,0 +1,69 @@
+package bus
+
+import (
+	"sync"
+)
+
+// maxSubscriptionQueue bounds the messages waiting for a subscriber
+const maxSubscriptionQueue = 1024
+
+// subscription delivers the messages of one subscriber asynchronously, in publish order
+type subscription struct {
+	handler Handler
+	queue   []Message
+	closed  bool
+	cond    *sync.Cond
+}
+
+func newSubscription(handler Handler) *subscription {
+	sub := &subscription{
+		handler: handler,
+		cond:    sync.NewCond(&sync.Mutex{}),
+	}
+	go sub.run()
+	return sub
+}
+
+func (s *subscription) enqueue(msg Message) {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	if s.closed {
+		return
+	}
+	if len(s.queue) >= maxSubscriptionQueue {
+		// The subscriber cannot keep up; it has to sync again instead
+		s.queue = []Message{{Type: MessageResync}}
+	}
+	s.queue = append(s.queue, msg)
+	s.cond.Signal()
+}
+
+// resync tells the subscriber that messages may have been lost
+func (s *subscription) resync() {
+	s.enqueue(Message{Type: MessageResync})
+}
+
+func (s *subscription) close() {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	s.closed = true
+	s.cond.Signal()
+}
+
+func (s *subscription) run() {
+	for {
+		s.cond.L.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.cond.L.Unlock()
+			return
+		}
+		msg := s.queue[0]
+		s.queue = s.queue[1:]
+		s.cond.L.Unlock()
+
+		s.handler(msg)
+	}
+}
@@ -0,0 +1,475 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"ycs/contracts"
+	"ycs/core"
+
+	"github.com/gorilla/websocket"
+)
+
+// Protocol selects the wire protocol spoken with the server
+type Protocol int
+
+const (
+	// ProtocolJSON is the JSON clock protocol of the browser connector
+	ProtocolJSON Protocol = iota
+	// ProtocolBinary is the y-websocket binary protocol carrying V2 updates
+	ProtocolBinary
+)
+
+const (
+	DefaultMinReconnectDelay = 500 * time.Millisecond
+	DefaultMaxReconnectDelay = 30 * time.Second
+	DefaultMaxOfflineUpdates = 1000
+
+	// writeTimeout bounds a single write to the server
+	writeTimeout = 10 * time.Second
+)
+
+// ErrNotConnected is returned when a message is sent while the client is disconnected
+var ErrNotConnected = errors.New("client: not connected")
+
+// Options configures a Client
+type Options struct {
+	Protocol Protocol
+	// Dialer dials the server; nil uses websocket.DefaultDialer
+	Dialer *websocket.Dialer
+	// Header is sent with every WebSocket handshake, e.g. for authentication
+	Header http.Header
+	// MinReconnectDelay and MaxReconnectDelay bound the exponential backoff between attempts
+	MinReconnectDelay time.Duration
+	MaxReconnectDelay time.Duration
+	// MaxOfflineUpdates bounds the local updates waiting to be sent, e.g. while the
+	// client is not synced. When the queue overflows, its updates are dropped: the
+	// handshake of a new connection sends every change the server is missing, so the
+	// client reconnects and syncs even if it could resume its session.
+	MaxOfflineUpdates int
+}
+
+// Client keeps a local document in sync with a document of the server's /ws endpoint.
+//
+// After connecting, the client sends its state vector (GetMissing, or SyncStep1 in
+// the binary protocol). The server answers with the structs the client is missing
+// and its own state vector, which the client answers in turn; from then on local
+// and remote updates are exchanged as they happen. Local updates are queued and
+// sent by a writer goroutine once the client is synced, so that changes to the
+// document never wait for the network.
+//
+// The client applies remote updates from its own goroutine. The lock guards every
+// access of the client to the document; by default it is the lock of the document,
+// so local changes are made inside Transact or while holding it.
+type Client struct {
+	url   string
+	doc   *core.YDoc
+	lock  sync.Locker
+	opts  Options
+	codec codec
+
+	conn     *websocket.Conn
+	synced   bool
+	syncedCh chan struct{}
+	// resync makes the next connection sync even if it resumes its session
+	resync bool
+	mutex  sync.Mutex
+
+	// updates queues the local updates for the writer of the current connection
+	updates chan []byte
+
+	// writeMutex serializes writes, which the codec numbers
+	writeMutex sync.Mutex
+
+	onSynced       func()
+	onDisconnected func(error)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// unobserve removes the update handler from the document
+	unobserve func()
+}
+
+// NewClient creates a client for the document at url, e.g. ws://localhost:8080/ws/room.
+// The client adds an OnUpdateV2 handler to the document. Pass the lock that guards
+// the document, or nil to use the lock of the document itself.
+func NewClient(url string, doc *core.YDoc, lock sync.Locker, opts Options) *Client {
+	if lock == nil {
+		lock = doc
+	}
+	if opts.Dialer == nil {
+		opts.Dialer = websocket.DefaultDialer
+	}
+	if opts.MinReconnectDelay <= 0 {
+		opts.MinReconnectDelay = DefaultMinReconnectDelay
+	}
+	if opts.MaxReconnectDelay < opts.MinReconnectDelay {
+		opts.MaxReconnectDelay = max(DefaultMaxReconnectDelay, opts.MinReconnectDelay)
+	}
+	if opts.MaxOfflineUpdates <= 0 {
+		opts.MaxOfflineUpdates = DefaultMaxOfflineUpdates
+	}
+
+	var c codec = newJSONCodec()
+	if opts.Protocol == ProtocolBinary {
+		c = binaryCodec{}
+	}
+
+	client := &Client{
+		url:      url,
+		doc:      doc,
+		lock:     lock,
+		opts:     opts,
+		codec:    c,
+		syncedCh: make(chan struct{}),
+		updates:  make(chan []byte, opts.MaxOfflineUpdates),
+	}
+	client.unobserve = doc.OnUpdateV2(client.handleLocalUpdate)
+	return client
+}
+
+// GetDoc returns the synced document
+func (c *Client) GetDoc() *core.YDoc {
+	return c.doc
+}
+
+// OnSynced sets the handler called every time the client has synced with the server
+func (c *Client) OnSynced(handler func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onSynced = handler
+}
+
+// OnDisconnected sets the handler called when a connection is lost
+func (c *Client) OnDisconnected(handler func(err error)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onDisconnected = handler
+}
+
+// IsSynced returns true if the client is connected and synced
+func (c *Client) IsSynced() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.synced
+}
+
+// WaitSynced blocks until the client is synced or ctx is done
+func (c *Client) WaitSynced(ctx context.Context) error {
+	c.mutex.Lock()
+	syncedCh := c.syncedCh
+	c.mutex.Unlock()
+
+	select {
+	case <-syncedCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Start connects to the server and keeps reconnecting until Close is called
+func (c *Client) Start() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.done != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	go c.run(ctx)
+}
+
+// Close disconnects from the server and stops reconnecting
+func (c *Client) Close() {
+	c.mutex.Lock()
+	cancel, done := c.cancel, c.done
+	c.mutex.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+	c.unobserve()
+}
+
+// run connects with exponential backoff until ctx is done
+func (c *Client) run(ctx context.Context) {
+	defer close(c.done)
+
+	delay := c.opts.MinReconnectDelay
+	for {
+		synced, err := c.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if synced {
+			delay = c.opts.MinReconnectDelay
+		}
+		if err != nil {
+			log.Printf("Connection to %s failed: %v", c.url, err)
+		}
+
+		// Jitter keeps clients from reconnecting all at once after a server restart
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		delay = min(delay*2, c.opts.MaxReconnectDelay)
+	}
+}
+
+// connect runs one connection until it fails; returns whether the client got synced
+func (c *Client) connect(ctx context.Context) (synced bool, err error) {
+	dialURL, err := c.codec.dialURL(c.url)
+	if err != nil {
+		return false, err
+	}
+	conn, _, err := c.opts.Dialer.DialContext(ctx, dialURL, c.opts.Header)
+	if err != nil {
+		return false, err
+	}
+
+	c.mutex.Lock()
+	c.conn = conn
+	syncedCh := c.syncedCh
+	c.mutex.Unlock()
+
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	writerDone := make(chan struct{})
+	go c.write(syncedCh, stop, writerDone)
+
+	err = c.serve(conn)
+	close(stop)
+	conn.Close()
+	// The writer must not send on the next connection before its handshake
+	<-writerDone
+	if ctx.Err() != nil {
+		// Closed by Close rather than by the server
+		err = ctx.Err()
+	}
+
+	synced = c.disconnected(err)
+	return synced, err
+}
+
+// serve handles the messages of a connection until it fails
+func (c *Client) serve(conn *websocket.Conn) error {
+	for _, message := range c.codec.connected() {
+		if err := c.handle(message); err != nil {
+			return err
+		}
+	}
+
+	for {
+		frameType, frame, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		messages, err := c.codec.decode(frameType, frame)
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			if err := c.handle(message); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle handles a message from the server
+func (c *Client) handle(message incoming) error {
+	switch message.kind {
+	case kindSessionStarted, kindSessionResumed:
+		c.mutex.Lock()
+		resync := c.resync
+		c.resync = false
+		c.mutex.Unlock()
+
+		if message.kind == kindSessionResumed && !resync {
+			c.markSynced()
+			return nil
+		}
+		stateVector, err := c.encode(nil)
+		if err != nil {
+			return err
+		}
+		return c.send(kindSyncStep1, stateVector)
+	case kindSyncStep1:
+		// Answering the server's state vector completes the handshake
+		missing, err := c.encode(message.payload)
+		if err != nil {
+			return err
+		}
+		if err := c.send(kindSyncStep2, missing); err != nil {
+			return err
+		}
+		c.markSynced()
+		return nil
+	case kindSyncStep2, kindUpdate:
+		return c.apply(message.payload)
+	default:
+		return fmt.Errorf("unexpected message kind %d", message.kind)
+	}
+}
+
+// encode returns the local state vector if stateVector is nil, or the structs missing from stateVector
+func (c *Client) encode(stateVector []byte) (encoded []byte, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if stateVector == nil {
+		return c.doc.EncodeStateVectorV2(), nil
+	}
+	return c.doc.EncodeStateAsUpdateV2(stateVector)
+}
+
+// apply applies a remote update to the document
+func (c *Client) apply(update []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.doc.ApplyUpdateV2(update, c, false); err != nil {
+		return fmt.Errorf("applying update: %w", err)
+	}
+	return nil
+}
+
+// send writes a message to the current connection
+func (c *Client) send(kind messageKind, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.mutex.Lock()
+	conn := c.conn
+	c.mutex.Unlock()
+
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	frameType, frame, err := c.codec.encode(kind, payload)
+	if err != nil {
+		return err
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := conn.WriteMessage(frameType, frame); err != nil {
+		// Let the reader notice and reconnect
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// write sends the queued local updates once the connection is synced, until stop is closed
+func (c *Client) write(syncedCh <-chan struct{}, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	select {
+	case <-syncedCh:
+	case <-stop:
+		return
+	}
+	for {
+		select {
+		case update := <-c.updates:
+			if err := c.sendUpdate(update); err != nil {
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// markSynced marks the client synced, which starts the writer
+func (c *Client) markSynced() {
+	c.mutex.Lock()
+	if c.synced {
+		c.mutex.Unlock()
+		return
+	}
+	c.synced = true
+	close(c.syncedCh)
+	handler := c.onSynced
+	c.mutex.Unlock()
+
+	if handler != nil {
+		handler()
+	}
+}
+
+// disconnected resets the sync state after a connection ended; returns whether it was synced
+func (c *Client) disconnected(err error) bool {
+	c.mutex.Lock()
+	wasSynced := c.synced
+	c.conn = nil
+	c.synced = false
+	if wasSynced {
+		c.syncedCh = make(chan struct{})
+	}
+	handler := c.onDisconnected
+	c.mutex.Unlock()
+
+	if handler != nil {
+		handler(err)
+	}
+	return wasSynced
+}
+
+// handleLocalUpdate queues a local update for the writer. It runs while the document
+// is locked, so it never waits for the network.
+func (c *Client) handleLocalUpdate(update []byte, origin interface{}, transaction contracts.ITransaction) {
+	if origin == c {
+		return
+	}
+
+	select {
+	case c.updates <- update:
+		return
+	default:
+	}
+
+	// The queue is full; drop it and let the handshake of a new connection recover the changes
+	for len(c.updates) > 0 {
+		select {
+		case <-c.updates:
+		default:
+		}
+	}
+	c.mutex.Lock()
+	c.resync = true
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.mutex.Unlock()
+}
+
+// sendUpdate sends a local update; an update that cannot be sent is recovered
+// by the handshake of the next connection
+func (c *Client) sendUpdate(update []byte) error {
+	return c.send(kindUpdate, update)
+}
@@ -0,0 +1,450 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ycs/contracts"
+	"ycs/core"
+	"ycs/protocols"
+
+	"github.com/gorilla/websocket"
+)
+
+// testServer serves one document over both protocols. It keeps the sessions of
+// the JSON protocol so that clients can resume them.
+type testServer struct {
+	*httptest.Server
+	doc *core.YDoc
+
+	sessions map[string]*testSession
+	conns    map[*testConn]struct{}
+	// refuse rejects new connections
+	refuse bool
+	// dropNextUpdate loses the next update of a client and closes its connection
+	dropNextUpdate bool
+	// syncRequests counts the state vectors sent by clients
+	syncRequests int
+	// processed counts the messages of clients that were handled
+	processed int
+	// attempts records the time of every connection attempt
+	attempts []time.Time
+	mutex    sync.Mutex
+}
+
+// testSession is a session of the JSON protocol
+type testSession struct {
+	id          string
+	clientClock int64
+	serverClock int64
+}
+
+// testConn is a connection to the test server; updates are sent once it is synced
+type testConn struct {
+	ws      *websocket.Conn
+	binary  bool
+	session *testSession
+	synced  bool
+	mutex   sync.Mutex
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	s := &testServer{
+		doc:      core.NewYDoc(contracts.YDocOptions{}),
+		sessions: make(map[string]*testSession),
+		conns:    make(map[*testConn]struct{}),
+	}
+	s.doc.OnUpdateV2(func(update []byte, origin interface{}, transaction contracts.ITransaction) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for conn := range s.conns {
+			if conn != origin && conn.isSynced() {
+				conn.send(protocols.MessageYjsUpdate, update)
+			}
+		}
+	})
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) url() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/room"
+}
+
+func (s *testServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.attempts = append(s.attempts, time.Now())
+	refuse := s.refuse
+	s.mutex.Unlock()
+	if refuse {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	conn := &testConn{ws: ws, binary: r.URL.Query().Get("protocol") == "binary"}
+	if !conn.binary {
+		conn.session = s.startSession(r.URL.Query().Get("session"), conn)
+	}
+	s.mutex.Lock()
+	s.conns[conn] = struct{}{}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+	}()
+
+	for {
+		_, frame, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		syncType, payload := conn.decode(frame)
+
+		s.mutex.Lock()
+		drop := syncType != protocols.MessageYjsSyncStep1 && s.dropNextUpdate
+		s.dropNextUpdate = false
+		if syncType == protocols.MessageYjsSyncStep1 {
+			s.syncRequests++
+		}
+		s.mutex.Unlock()
+		if drop {
+			return
+		}
+		if conn.session != nil {
+			conn.session.clientClock++
+		}
+
+		s.doc.Lock()
+		switch syncType {
+		case protocols.MessageYjsSyncStep1:
+			update, err := s.doc.EncodeStateAsUpdateV2(payload)
+			if err != nil {
+				s.doc.Unlock()
+				return
+			}
+			conn.send(protocols.MessageYjsSyncStep2, update)
+			conn.send(protocols.MessageYjsSyncStep1, s.doc.EncodeStateVectorV2())
+			conn.mutex.Lock()
+			conn.synced = true
+			conn.mutex.Unlock()
+		default:
+			err = s.doc.ApplyUpdateV2(payload, conn, false)
+		}
+		s.doc.Unlock()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.processed++
+		s.mutex.Unlock()
+	}
+}
+
+// startSession resumes the session with the ID or starts a new one, and announces it
+func (s *testServer) startSession(id string, conn *testConn) *testSession {
+	s.mutex.Lock()
+	session, resumed := s.sessions[id]
+	if !resumed {
+		session = &testSession{id: strconv.Itoa(len(s.sessions) + 1), clientClock: -1, serverClock: -1}
+		s.sessions[session.id] = session
+	}
+	s.mutex.Unlock()
+
+	// A resumed session is synced right away
+	conn.synced = resumed
+	conn.write(map[string]interface{}{
+		"type": commandSession,
+		"data": sessionInfo{SessionID: session.id, Resumed: resumed, ClientClock: session.clientClock},
+	})
+	return session
+}
+
+// edit changes the document of the server
+func (s *testServer) edit(f func(doc *core.YDoc)) {
+	s.doc.Transact(func(tr contracts.ITransaction) { f(s.doc) }, nil, true)
+}
+
+// disconnect closes all connections
+func (s *testServer) disconnect() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for conn := range s.conns {
+		conn.ws.Close()
+	}
+}
+
+func (s *testServer) setRefuse(refuse bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.refuse = refuse
+}
+
+func (s *testServer) getSyncRequests() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.syncRequests
+}
+
+func (c *testConn) isSynced() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.synced
+}
+
+// decode returns the sync message type and payload of a client frame
+func (c *testConn) decode(frame []byte) (uint32, []byte) {
+	if c.binary {
+		message, err := protocols.DecodeWebSocketMessage(frame)
+		if err != nil {
+			return protocols.MessageYjsUpdate, nil
+		}
+		return message.SyncType, message.Payload
+	}
+
+	var message struct {
+		Type string      `json:"type"`
+		Data jsonMessage `json:"data"`
+	}
+	json.Unmarshal(frame, &message)
+	payload, _ := base64.StdEncoding.DecodeString(message.Data.Data)
+	switch {
+	case message.Type == commandGetMissing:
+		return protocols.MessageYjsSyncStep1, payload
+	case message.Data.InReplyTo == commandGetMissing:
+		return protocols.MessageYjsSyncStep2, payload
+	default:
+		return protocols.MessageYjsUpdate, payload
+	}
+}
+
+// send writes a sync message in the protocol of the connection
+func (c *testConn) send(syncType uint32, payload []byte) {
+	if c.binary {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.ws.WriteMessage(websocket.BinaryMessage, protocols.EncodeSyncMessage(syncType, payload))
+		return
+	}
+
+	command, inReplyTo := commandUpdate, ""
+	switch syncType {
+	case protocols.MessageYjsSyncStep1:
+		command = commandGetMissing
+	case protocols.MessageYjsSyncStep2:
+		inReplyTo = commandGetMissing
+	}
+	c.mutex.Lock()
+	c.session.serverClock++
+	clock := c.session.serverClock
+	c.mutex.Unlock()
+	c.write(map[string]interface{}{
+		"type": command,
+		"data": jsonMessage{Clock: clock, Data: base64.StdEncoding.EncodeToString(payload), InReplyTo: inReplyTo},
+	})
+}
+
+func (c *testConn) write(message interface{}) {
+	frame, _ := json.Marshal(message)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ws.WriteMessage(websocket.TextMessage, frame)
+}
+
+// eventually fails the test unless cond returns true within a few seconds
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal("condition not met in time")
+}
+
+func text(doc *core.YDoc) (s string) {
+	doc.View(func() { s = doc.GetText("t").ToString() })
+	return s
+}
+
+// startClient starts a client of the server and waits until it is synced
+func startClient(t *testing.T, s *testServer, opts Options) *Client {
+	t.Helper()
+	if opts.MinReconnectDelay == 0 {
+		opts.MinReconnectDelay = 10 * time.Millisecond
+		opts.MaxReconnectDelay = 20 * time.Millisecond
+	}
+	c := NewClient(s.url(), core.NewYDoc(contracts.YDocOptions{}), nil, opts)
+	c.Start()
+	t.Cleanup(c.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.WaitSynced(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The handshake is complete once the server handled the answer to its state vector
+	eventually(t, func() bool {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.processed >= 2
+	})
+	return c
+}
+
+var protocolNames = map[Protocol]string{ProtocolJSON: "json", ProtocolBinary: "binary"}
+
+func TestClientSyncsBothWays(t *testing.T) {
+	for protocol, name := range protocolNames {
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.edit(func(doc *core.YDoc) { doc.GetText("t").Insert(0, "server") })
+
+			c := startClient(t, s, Options{Protocol: protocol})
+			// The handshake brings the state of the server
+			eventually(t, func() bool { return text(c.GetDoc()) == "server" })
+			if requests := s.getSyncRequests(); requests != 1 {
+				t.Fatalf("%d state vectors sent", requests)
+			}
+
+			doc := c.GetDoc()
+			doc.Transact(func(tr contracts.ITransaction) { doc.GetText("t").Insert(6, " client") }, nil, true)
+			eventually(t, func() bool { return text(s.doc) == "server client" })
+
+			s.edit(func(doc *core.YDoc) { doc.GetText("t").Insert(0, "> ") })
+			eventually(t, func() bool { return text(doc) == "> server client" })
+		})
+	}
+}
+
+func TestClientFlushesOfflineUpdates(t *testing.T) {
+	for protocol, name := range protocolNames {
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(t)
+			c := startClient(t, s, Options{Protocol: protocol})
+
+			s.setRefuse(true)
+			s.disconnect()
+			eventually(t, func() bool { return !c.IsSynced() })
+
+			doc := c.GetDoc()
+			for _, s := range []string{"c", "b", "a"} {
+				doc.Transact(func(tr contracts.ITransaction) { doc.GetText("t").Insert(0, s) }, nil, true)
+			}
+			if len(c.updates) != 3 {
+				t.Fatalf("%d updates queued", len(c.updates))
+			}
+
+			s.setRefuse(false)
+			eventually(t, func() bool { return text(s.doc) == "abc" })
+			eventually(t, func() bool { return len(c.updates) == 0 })
+			if requests, want := s.getSyncRequests(), map[Protocol]int{ProtocolJSON: 1, ProtocolBinary: 2}[protocol]; requests != want {
+				// A resumed JSON session only flushes the queue
+				t.Fatalf("%d state vectors sent, want %d", requests, want)
+			}
+		})
+	}
+}
+
+func TestClientResyncsAfterOfflineQueueOverflows(t *testing.T) {
+	s := newTestServer(t)
+	c := startClient(t, s, Options{MaxOfflineUpdates: 2})
+
+	s.setRefuse(true)
+	s.disconnect()
+	eventually(t, func() bool { return !c.IsSynced() })
+
+	doc := c.GetDoc()
+	for i := 0; i < 5; i++ {
+		doc.Transact(func(tr contracts.ITransaction) { doc.GetText("t").Insert(0, "x") }, nil, true)
+	}
+
+	// The session is resumed, but the dropped updates are only recovered by a handshake
+	s.setRefuse(false)
+	eventually(t, func() bool { return text(s.doc) == "xxxxx" })
+	if requests := s.getSyncRequests(); requests != 2 {
+		t.Fatalf("%d state vectors sent", requests)
+	}
+}
+
+func TestClientResumesSession(t *testing.T) {
+	s := newTestServer(t)
+	c := startClient(t, s, Options{})
+
+	s.disconnect()
+	eventually(t, func() bool { return !c.IsSynced() })
+	eventually(t, c.IsSynced)
+
+	doc := c.GetDoc()
+	doc.Transact(func(tr contracts.ITransaction) { doc.GetText("t").Insert(0, "resumed") }, nil, true)
+	eventually(t, func() bool { return text(s.doc) == "resumed" })
+	if requests := s.getSyncRequests(); requests != 1 {
+		t.Fatalf("%d state vectors sent for a resumed session", requests)
+	}
+	if len(s.sessions) != 1 {
+		t.Fatalf("%d sessions", len(s.sessions))
+	}
+}
+
+func TestClientResyncsAfterLostUpdate(t *testing.T) {
+	s := newTestServer(t)
+	c := startClient(t, s, Options{})
+
+	// The server loses the update and the connection; the session is resumed with
+	// an older client clock, which the client recovers from with a handshake
+	s.mutex.Lock()
+	s.dropNextUpdate = true
+	s.mutex.Unlock()
+	doc := c.GetDoc()
+	doc.Transact(func(tr contracts.ITransaction) { doc.GetText("t").Insert(0, "lost") }, nil, true)
+
+	eventually(t, func() bool { return text(s.doc) == "lost" })
+	if requests := s.getSyncRequests(); requests != 2 {
+		t.Fatalf("%d state vectors sent", requests)
+	}
+}
+
+func TestClientBacksOff(t *testing.T) {
+	s := newTestServer(t)
+	s.setRefuse(true)
+
+	minDelay, maxDelay := 20*time.Millisecond, 80*time.Millisecond
+	c := NewClient(s.url(), core.NewYDoc(contracts.YDocOptions{}), nil, Options{MinReconnectDelay: minDelay, MaxReconnectDelay: maxDelay})
+	c.Start()
+	defer c.Close()
+
+	eventually(t, func() bool {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return len(s.attempts) >= 6
+	})
+
+	s.mutex.Lock()
+	attempts := s.attempts
+	s.mutex.Unlock()
+	// The delay doubles up to the maximum, and the jitter waits at least half of it
+	delay := minDelay
+	for i := 1; i < 6; i++ {
+		if gap := attempts[i].Sub(attempts[i-1]); gap < delay/2 {
+			t.Fatalf("attempt %d after %v, want at least %v", i, gap, delay/2)
+		}
+		delay = min(delay*2, maxDelay)
+	}
+}
@@ -0,0 +1,285 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"ycs/protocols"
+
+	"github.com/gorilla/websocket"
+)
+
+// messageKind identifies the sync messages exchanged with the server,
+// independent of the protocol
+type messageKind int
+
+const (
+	// kindSessionStarted means the client must sync with a state vector
+	kindSessionStarted messageKind = iota
+	// kindSessionResumed means the server kept the session and the client is still synced
+	kindSessionResumed
+	// kindSyncStep1 carries a state vector (GetMissing)
+	kindSyncStep1
+	// kindSyncStep2 carries the update answering a state vector (Update in reply to GetMissing)
+	kindSyncStep2
+	// kindUpdate carries an incremental update
+	kindUpdate
+)
+
+// incoming is a message received from the server
+type incoming struct {
+	kind    messageKind
+	payload []byte
+}
+
+// codec frames the messages of one protocol. Codecs keep the protocol state
+// across connections, so that the JSON protocol can resume its session.
+type codec interface {
+	// dialURL returns the URL of the next connection
+	dialURL(base string) (string, error)
+	// connected returns the messages to handle as soon as the connection is open
+	connected() []incoming
+	// encode frames an outgoing message
+	encode(kind messageKind, payload []byte) (frameType int, frame []byte, err error)
+	// decode returns the messages of a frame that are ready to be handled, in order
+	decode(frameType int, frame []byte) ([]incoming, error)
+}
+
+// Command types of the JSON protocol
+const (
+	commandGetMissing = "GetMissing"
+	commandUpdate     = "Update"
+	commandSession    = "Session"
+)
+
+// jsonMessage is the data of a GetMissing or Update message of the JSON protocol
+type jsonMessage struct {
+	Clock     int64  `json:"clock"`
+	Data      string `json:"data"`
+	InReplyTo string `json:"inReplyTo,omitempty"`
+}
+
+// sessionInfo is the first message the server sends on every connection
+type sessionInfo struct {
+	SessionID   string `json:"sessionId"`
+	Resumed     bool   `json:"resumed"`
+	ClientClock int64  `json:"clientClock"`
+}
+
+// jsonCodec speaks the JSON clock protocol of the browser connector. Both sides
+// number their messages; the client resumes its session after reconnecting by
+// presenting the last server clock it processed.
+type jsonCodec struct {
+	sessionID   string
+	clientClock int64
+	serverClock int64
+	pending     map[int64]incoming
+	// handshakeDone is set once the client answered the server's state vector
+	handshakeDone bool
+	mutex         sync.Mutex
+}
+
+func newJSONCodec() *jsonCodec {
+	return &jsonCodec{
+		clientClock: -1,
+		serverClock: -1,
+		pending:     make(map[int64]incoming),
+	}
+}
+
+func (c *jsonCodec) dialURL(base string) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.sessionID == "" {
+		return base, nil
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("session", c.sessionID)
+	query.Set("serverClock", strconv.FormatInt(c.serverClock, 10))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func (c *jsonCodec) connected() []incoming {
+	// The server starts with the session message
+	return nil
+}
+
+func (c *jsonCodec) encode(kind messageKind, payload []byte) (int, []byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	command := commandUpdate
+	inReplyTo := ""
+	switch kind {
+	case kindSyncStep1:
+		command = commandGetMissing
+	case kindSyncStep2:
+		inReplyTo = commandGetMissing
+		c.handshakeDone = true
+	case kindUpdate:
+	default:
+		return 0, nil, fmt.Errorf("cannot send message kind %d", kind)
+	}
+
+	c.clientClock++
+	frame, err := json.Marshal(map[string]interface{}{
+		"type": command,
+		"data": jsonMessage{
+			Clock:     c.clientClock,
+			Data:      base64.StdEncoding.EncodeToString(payload),
+			InReplyTo: inReplyTo,
+		},
+	})
+	return websocket.TextMessage, frame, err
+}
+
+func (c *jsonCodec) decode(frameType int, frame []byte) ([]incoming, error) {
+	var message struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(frame, &message); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch message.Type {
+	case commandSession:
+		var info sessionInfo
+		if err := json.Unmarshal(message.Data, &info); err != nil {
+			return nil, fmt.Errorf("invalid session message: %w", err)
+		}
+		return []incoming{c.startSession(info)}, nil
+	case commandGetMissing, commandUpdate:
+		var data jsonMessage
+		if err := json.Unmarshal(message.Data, &data); err != nil {
+			return nil, fmt.Errorf("invalid %s message: %w", message.Type, err)
+		}
+		payload, err := base64.StdEncoding.DecodeString(data.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s data: %w", message.Type, err)
+		}
+
+		kind := kindUpdate
+		switch {
+		case message.Type == commandGetMissing:
+			kind = kindSyncStep1
+		case data.InReplyTo == commandGetMissing:
+			kind = kindSyncStep2
+		}
+		if data.Clock > c.serverClock {
+			c.pending[data.Clock] = incoming{kind: kind, payload: payload}
+		}
+		return c.takeReady(), nil
+	default:
+		// Awareness is not handled by this client
+		return nil, nil
+	}
+}
+
+// startSession adopts the session announced by the server
+func (c *jsonCodec) startSession(info sessionInfo) incoming {
+	for clock := range c.pending {
+		delete(c.pending, clock)
+	}
+
+	lost := info.ClientClock < c.clientClock
+	c.sessionID = info.SessionID
+	c.clientClock = info.ClientClock
+	if !info.Resumed {
+		c.serverClock = -1
+		c.handshakeDone = false
+		return incoming{kind: kindSessionStarted}
+	}
+	if lost || !c.handshakeDone {
+		// Messages sent on the old connection never arrived; sync again to recover them
+		return incoming{kind: kindSessionStarted}
+	}
+	return incoming{kind: kindSessionResumed}
+}
+
+// takeReady removes the pending messages that follow the last processed clock
+func (c *jsonCodec) takeReady() []incoming {
+	var ready []incoming
+	for {
+		message, ok := c.pending[c.serverClock+1]
+		if !ok {
+			return ready
+		}
+		delete(c.pending, c.serverClock+1)
+		c.serverClock++
+		ready = append(ready, message)
+	}
+}
+
+// binaryCodec speaks the y-websocket binary protocol. The connection orders the
+// messages, so there are no clocks and no sessions to resume.
+type binaryCodec struct{}
+
+func (binaryCodec) dialURL(base string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("protocol", "binary")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func (binaryCodec) connected() []incoming {
+	// The client opens the handshake right away
+	return []incoming{{kind: kindSessionStarted}}
+}
+
+func (binaryCodec) encode(kind messageKind, payload []byte) (int, []byte, error) {
+	var syncType uint32
+	switch kind {
+	case kindSyncStep1:
+		syncType = protocols.MessageYjsSyncStep1
+	case kindSyncStep2:
+		syncType = protocols.MessageYjsSyncStep2
+	case kindUpdate:
+		syncType = protocols.MessageYjsUpdate
+	default:
+		return 0, nil, fmt.Errorf("cannot send message kind %d", kind)
+	}
+	return websocket.BinaryMessage, protocols.EncodeSyncMessage(syncType, payload), nil
+}
+
+func (binaryCodec) decode(frameType int, frame []byte) ([]incoming, error) {
+	if frameType != websocket.BinaryMessage {
+		return nil, fmt.Errorf("unexpected text message")
+	}
+
+	message, err := protocols.DecodeWebSocketMessage(frame)
+	if err != nil {
+		return nil, err
+	}
+	if message.Type != protocols.MessageSync {
+		// Awareness is not handled by this client
+		return nil, nil
+	}
+
+	kind := kindUpdate
+	switch message.SyncType {
+	case protocols.MessageYjsSyncStep1:
+		kind = kindSyncStep1
+	case protocols.MessageYjsSyncStep2:
+		kind = kindSyncStep2
+	}
+	return []incoming{{kind: kind, payload: message.Payload}}, nil
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"ycs/protocols"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// outboundQueueSize is the number of messages buffered for a connection before it is dropped as too slow
+	outboundQueueSize = 1024
+	// historySize is the number of sent messages kept for replaying to a resuming client
+	historySize = 1024
+	// writeTimeout bounds a single write to a client
+	writeTimeout = 10 * time.Second
+	// pendingMessageTimeout is how long an out-of-order message waits for the messages before it
+	pendingMessageTimeout = 30 * time.Second
+)
+
+// SessionInfo is sent as the first message of every connection
+type SessionInfo struct {
+	SessionID string `json:"sessionId"`
+	// Resumed is true if the server replays the messages after the acknowledged clock;
+	// otherwise the client must reset its clocks and sync again with GetMissing
+	Resumed bool `json:"resumed"`
+	// ClientClock is the clock of the last message processed from the client
+	ClientClock int64 `json:"clientClock"`
+}
+
+// outboundMessage is a message waiting to be written to a client
+type outboundMessage struct {
+	clock       int64
+	command     YjsCommandType
+	data        interface{}
+	updateBytes int
+}
+
+// clientTransport frames messages for one protocol spoken by clients
+type clientTransport interface {
+	// WriteMessage writes a message; data is a YjsMessage, a SessionInfo or a base64 string
+	WriteMessage(command YjsCommandType, data interface{}) error
+	// WriteClose tells the client that the server closes the connection
+	WriteClose(code int, reason string) error
+	// Close closes the underlying connection without a handshake
+	Close() error
+}
+
+// jsonTransport speaks the JSON protocol of /ws: {"type": command, "data": data}
+type jsonTransport struct {
+	conn *websocket.Conn
+}
+
+func (t *jsonTransport) WriteMessage(command YjsCommandType, data interface{}) error {
+	t.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return t.conn.WriteJSON(map[string]interface{}{
+		"type": string(command),
+		"data": data,
+	})
+}
+
+func (t *jsonTransport) WriteClose(code int, reason string) error {
+	closeMessage := websocket.FormatCloseMessage(code, reason)
+	return t.conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(writeTimeout))
+}
+
+func (t *jsonTransport) Close() error {
+	return t.conn.Close()
+}
+
+// clientConnection writes messages to one connection of a client.
+// Messages are written by a single goroutine since connections do not
+// support concurrent writers. Its state is guarded by the owning ClientContext.
+type clientConnection struct {
+	transport   clientTransport
+	outbound    chan outboundMessage
+	closed      bool
+	closeCode   int
+	closeReason string
+	writerDone  chan struct{}
+}
+
+func newClientConnection(transport clientTransport) *clientConnection {
+	connection := &clientConnection{
+		transport:  transport,
+		outbound:   make(chan outboundMessage, outboundQueueSize),
+		writerDone: make(chan struct{}),
+	}
+	go connection.writeLoop()
+	return connection
+}
+
+// enqueue queues a message; returns false if the connection is closed or its queue is full
+func (c *clientConnection) enqueue(message outboundMessage) bool {
+	if c.closed {
+		return false
+	}
+
+	select {
+	case c.outbound <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops accepting messages. Queued messages are still written, followed
+// by a close frame with the given code and reason.
+func (c *clientConnection) close(code int, reason string) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.closeCode = code
+	c.closeReason = reason
+	close(c.outbound)
+}
+
+// wait blocks until all queued messages are written or ctx is done
+func (c *clientConnection) wait(ctx context.Context) error {
+	select {
+	case <-c.writerDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeLoop writes queued messages to the connection until it is closed
+func (c *clientConnection) writeLoop() {
+	defer close(c.writerDone)
+
+	failed := false
+	for message := range c.outbound {
+		if failed {
+			continue
+		}
+
+		if err := c.transport.WriteMessage(message.command, message.data); err != nil {
+			rejectedMessages.WithLabelValues(rejectSendFailed).Inc()
+			logErrorf("Error sending message to client: %v", err)
+			failed = true
+			continue
+		}
+		if message.updateBytes > 0 {
+			updateBytes.WithLabelValues(directionOut).Add(float64(message.updateBytes))
+		}
+	}
+
+	if !failed && c.closeCode != 0 {
+		if err := c.transport.WriteClose(c.closeCode, c.closeReason); err != nil {
+			logErrorf("Error sending close frame to client: %v", err)
+		}
+	}
+}
+
+// ClientContext manages the state for each client session.
+// A session outlives its WebSocket connection: when the connection drops, the
+// session keeps its clocks and the recently sent messages, so that a client
+// reconnecting with the session ID continues where it left off.
+type ClientContext struct {
+	sessionID string
+	synced    bool
+	// receivesUpdates is set once the document was sent in reply to GetMissing;
+	// updates broadcast from then on follow it, even before the client is synced
+	receivesUpdates bool
+	// subdocs maps the GUIDs of the subdocuments sent to the client, which it
+	// receives updates of, to whether the client is synced with them
+	subdocs     map[string]bool
+	serverClock int64
+	clientClock int64
+	messages    map[int64]*MessageToProcess
+	mutex       sync.RWMutex
+
+	// processMutex serializes message handling; handlers take mutex themselves
+	processMutex sync.Mutex
+
+	// history holds recently sent messages in clock order
+	history    []outboundMessage
+	connection *clientConnection
+	detachedAt time.Time
+	expired    bool
+
+	// awarenessClients holds the awareness client IDs announced by this session
+	awarenessClients map[uint32]struct{}
+}
+
+func NewClientContext(sessionID string) *ClientContext {
+	return &ClientContext{
+		sessionID:   sessionID,
+		synced:      false,
+		serverClock: -1,
+		clientClock: -1,
+		messages:    make(map[int64]*MessageToProcess),
+		subdocs:     make(map[string]bool),
+		detachedAt:  time.Now(),
+
+		awarenessClients: make(map[uint32]struct{}),
+	}
+}
+
+// newSessionID generates a random session ID
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("session_%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// GetSessionID returns the session ID
+func (cc *ClientContext) GetSessionID() string {
+	return cc.sessionID
+}
+
+// Send queues a message for the client. The server clock is assigned when the message
+// is queued, so messages are written in clock order. While the client is disconnected
+// the message is only kept for replaying. Returns false if the session has expired.
+func (cc *ClientContext) Send(command YjsCommandType, inReplyTo *YjsCommandType, data []byte) bool {
+	return cc.SendSubdoc("", command, inReplyTo, data)
+}
+
+// SendSubdoc queues a sync message of the subdocument with the given GUID like Send.
+// An empty GUID is the room document.
+func (cc *ClientContext) SendSubdoc(guid string, command YjsCommandType, inReplyTo *YjsCommandType, data []byte) bool {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	if cc.expired {
+		return false
+	}
+
+	cc.serverClock++
+	message := outboundMessage{
+		clock:   cc.serverClock,
+		command: command,
+		data: YjsMessage{
+			Clock:     cc.serverClock,
+			Data:      base64.StdEncoding.EncodeToString(data),
+			InReplyTo: inReplyTo,
+			Guid:      guid,
+		},
+	}
+	if command == Update {
+		message.updateBytes = len(data)
+	}
+
+	cc.history = append(cc.history, message)
+	if len(cc.history) > historySize {
+		// Drop the older half at once to keep appends cheap
+		cc.history = append([]outboundMessage(nil), cc.history[len(cc.history)-historySize/2:]...)
+	}
+
+	if cc.connection != nil && !cc.connection.enqueue(message) {
+		// The client does not keep up; it may resume from the history once it reconnects
+		rejectedMessages.WithLabelValues(rejectQueueFull).Inc()
+		cc.detachLocked(websocket.CloseTryAgainLater, "client too slow")
+	}
+
+	return true
+}
+
+// SendAwareness queues an awareness update for the attached connection. Awareness
+// messages carry no clock and are not replayed; a resuming client queries them again.
+func (cc *ClientContext) SendAwareness(update []byte) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	if cc.connection == nil {
+		return
+	}
+
+	message := outboundMessage{
+		clock:   -1,
+		command: UpdateAwareness,
+		data:    base64.StdEncoding.EncodeToString(update),
+	}
+	if !cc.connection.enqueue(message) {
+		// Awareness is refreshed periodically by the clients, so dropping it is harmless
+		rejectedMessages.WithLabelValues(rejectQueueFull).Inc()
+	}
+}
+
+// TrackAwareness records the awareness client IDs of entries sent by this session
+func (cc *ClientContext) TrackAwareness(entries []protocols.AwarenessEntry) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	for _, entry := range entries {
+		if entry.IsRemoved() {
+			delete(cc.awarenessClients, entry.ClientID)
+		} else {
+			cc.awarenessClients[entry.ClientID] = struct{}{}
+		}
+	}
+}
+
+// TakeAwarenessClients returns the awareness client IDs of this session and forgets them
+func (cc *ClientContext) TakeAwarenessClients() []uint32 {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	clientIDs := make([]uint32, 0, len(cc.awarenessClients))
+	for clientID := range cc.awarenessClients {
+		clientIDs = append(clientIDs, clientID)
+	}
+	cc.awarenessClients = make(map[uint32]struct{})
+	return clientIDs
+}
+
+// Attach binds a new connection to the session. If resume is true and every message
+// after ackClock is still in the history, those messages are replayed; otherwise the
+// session starts over and the client has to sync with GetMissing. A connection that
+// is still attached is closed. The SessionInfo is always the first message written.
+func (cc *ClientContext) Attach(transport clientTransport, resume bool, ackClock int64) (*clientConnection, bool) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	if cc.connection != nil {
+		cc.connection.close(websocket.CloseNormalClosure, "session resumed elsewhere")
+	}
+	connection := newClientConnection(transport)
+	cc.connection = connection
+
+	replay, resumed := cc.replayLocked(resume, ackClock)
+	if !resumed {
+		cc.synced = false
+		cc.receivesUpdates = false
+		cc.subdocs = make(map[string]bool)
+		cc.serverClock = -1
+		cc.clientClock = -1
+		cc.history = nil
+	}
+
+	// The client numbers its next message after the last one we processed;
+	// anything received past a gap on the old connection is lost
+	for clock := range cc.messages {
+		delete(cc.messages, clock)
+	}
+
+	connection.enqueue(outboundMessage{
+		clock:   -1,
+		command: Session,
+		data: SessionInfo{
+			SessionID:   cc.sessionID,
+			Resumed:     resumed,
+			ClientClock: cc.clientClock,
+		},
+	})
+	for _, message := range replay {
+		connection.enqueue(message)
+	}
+
+	return connection, resumed
+}
+
+// replayLocked returns the messages sent after ackClock, or false if some of them are gone
+func (cc *ClientContext) replayLocked(resume bool, ackClock int64) ([]outboundMessage, bool) {
+	if !resume || ackClock > cc.serverClock || ackClock < -1 {
+		return nil, false
+	}
+	if ackClock == cc.serverClock {
+		return nil, true
+	}
+	if len(cc.history) == 0 || cc.history[0].clock > ackClock+1 {
+		return nil, false
+	}
+
+	replay := cc.history[ackClock+1-cc.history[0].clock:]
+	// Leave room in the queue for the session message and new updates
+	if len(replay) >= outboundQueueSize/2 {
+		return nil, false
+	}
+	return replay, true
+}
+
+// Detach unbinds a connection whose peer has gone from the session if it is still attached
+func (cc *ClientContext) Detach(connection *clientConnection) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	if cc.connection == connection {
+		// No close frame; the connection is already closing
+		cc.detachLocked(0, "")
+	}
+}
+
+// CloseConnection closes the attached connection after its queued messages are written
+func (cc *ClientContext) CloseConnection(code int, reason string) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	if cc.connection != nil {
+		cc.detachLocked(code, reason)
+	}
+}
+
+func (cc *ClientContext) detachLocked(code int, reason string) {
+	cc.connection.close(code, reason)
+	cc.connection = nil
+	cc.detachedAt = time.Now()
+}
+
+// GetConnection returns the transport of the attached connection, or nil
+func (cc *ClientContext) GetConnection() clientTransport {
+	cc.mutex.RLock()
+	defer cc.mutex.RUnlock()
+
+	if cc.connection == nil {
+		return nil
+	}
+	return cc.connection.transport
+}
+
+// IsExpired returns true if the session has been detached for longer than timeout.
+// An expired session stops accepting messages.
+func (cc *ClientContext) IsExpired(timeout time.Duration) bool {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	if !cc.expired && cc.connection == nil && time.Since(cc.detachedAt) > timeout {
+		cc.expired = true
+		cc.history = nil
+	}
+	return cc.expired
+}
+
+// skipStaleGap drops messages that were already processed and, when the oldest
+// pending message has waited longer than pendingMessageTimeout for the ones before
+// it, gives up on the missing messages. Returns true if pending messages can be processed.
+func (cc *ClientContext) skipStaleGap() bool {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	next := int64(-1)
+	var oldest time.Time
+	for clock, message := range cc.messages {
+		if clock <= cc.clientClock {
+			delete(cc.messages, clock)
+			continue
+		}
+		if next == -1 || clock < next {
+			next = clock
+		}
+		if oldest.IsZero() || message.ReceivedAt.Before(oldest) {
+			oldest = message.ReceivedAt
+		}
+	}
+
+	if next == -1 || next == cc.clientClock+1 {
+		return next != -1
+	}
+	if time.Since(oldest) <= pendingMessageTimeout {
+		return false
+	}
+
+	// The skipped updates are recovered by the client's next GetMissing round trip
+	rejectedMessages.WithLabelValues(rejectGapExpired).Inc()
+	logWarnf("Skipping messages %d to %d of session %s that never arrived", cc.clientClock+1, next-1, cc.sessionID)
+	cc.clientClock = next - 1
+	return true
+}
+
+func (cc *ClientContext) IncrementAndGetClientClock() int64 {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	cc.clientClock++
+	return cc.clientClock
+}
+
+func (cc *ClientContext) ReassignClientClock(clock int64) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	cc.clientClock = clock
+}
+
+func (cc *ClientContext) IsSynced() bool {
+	cc.mutex.RLock()
+	defer cc.mutex.RUnlock()
+	return cc.synced
+}
+
+func (cc *ClientContext) SetSynced(synced bool) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	cc.synced = synced
+}
+
+// ReceivesUpdates returns whether updates of the document are sent to the client
+func (cc *ClientContext) ReceivesUpdates() bool {
+	cc.mutex.RLock()
+	defer cc.mutex.RUnlock()
+	return cc.synced || cc.receivesUpdates
+}
+
+// SetReceivesUpdates sets whether updates of the document are sent to the client
+func (cc *ClientContext) SetReceivesUpdates(receivesUpdates bool) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	cc.receivesUpdates = receivesUpdates
+}
+
+// GetSubdocState returns whether updates of the subdocument are sent to the client
+// and whether the client is synced with it
+func (cc *ClientContext) GetSubdocState(guid string) (receivesUpdates bool, synced bool) {
+	cc.mutex.RLock()
+	defer cc.mutex.RUnlock()
+	synced, receivesUpdates = cc.subdocs[guid]
+	return receivesUpdates, synced
+}
+
+// SetSubdocState starts sending updates of the subdocument to the client and sets
+// whether the client is synced with it
+func (cc *ClientContext) SetSubdocState(guid string, synced bool) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	cc.subdocs[guid] = synced
+}
+
+// DropSubdoc stops sending updates of the subdocument to the client
+func (cc *ClientContext) DropSubdoc(guid string) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	delete(cc.subdocs, guid)
+}
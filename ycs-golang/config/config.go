@@ -0,0 +1,420 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Persistence backends
+const (
+	PersistenceNone = "none"
+	PersistenceFile = "file"
+)
+
+// Log levels
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+// AnyOrigin in AllowedOrigins accepts WebSocket connections from every origin
+const AnyOrigin = "*"
+
+// Duration is a time.Duration written as "10s" or "1m30s" in configuration files
+type Duration time.Duration
+
+// UnmarshalText parses a duration string
+func (d *Duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+// MarshalText formats the duration
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// TLSConfig represents the certificate used to serve HTTPS
+type TLSConfig struct {
+	CertFile string `json:"certFile" yaml:"certFile"`
+	KeyFile  string `json:"keyFile" yaml:"keyFile"`
+}
+
+// Enabled returns true if a certificate is configured
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// PersistenceConfig represents where room documents are stored
+type PersistenceConfig struct {
+	// Backend is "none" or "file"
+	Backend string `json:"backend" yaml:"backend"`
+	// Dir is the directory of the file backend
+	Dir string `json:"dir" yaml:"dir"`
+}
+
+// HistoryConfig represents where the checkpoints of room documents are stored
+type HistoryConfig struct {
+	// Dir is the directory of the checkpoints; version history is disabled without it
+	Dir string `json:"dir" yaml:"dir"`
+	// Interval is how often an automatic checkpoint is created while a room changes,
+	// zero for named checkpoints only
+	Interval Duration `json:"interval" yaml:"interval"`
+}
+
+// Enabled returns true if a checkpoint directory is configured
+func (c HistoryConfig) Enabled() bool {
+	return c.Dir != ""
+}
+
+// Limits represents resource limits; zero means unlimited
+type Limits struct {
+	// MaxMessageBytes is the largest message accepted from a client
+	MaxMessageBytes int64 `json:"maxMessageBytes" yaml:"maxMessageBytes"`
+	// MaxClientsPerRoom is the number of clients a room accepts
+	MaxClientsPerRoom int `json:"maxClientsPerRoom" yaml:"maxClientsPerRoom"`
+	// MaxRooms is the number of rooms loaded at the same time
+	MaxRooms int `json:"maxRooms" yaml:"maxRooms"`
+	// MaxDocumentsPerConnection is the number of documents a multiplexed connection syncs
+	MaxDocumentsPerConnection int `json:"maxDocumentsPerConnection" yaml:"maxDocumentsPerConnection"`
+}
+
+// Config represents the server configuration
+type Config struct {
+	// Addr is the host:port the server listens on
+	Addr string    `json:"addr" yaml:"addr"`
+	TLS  TLSConfig `json:"tls" yaml:"tls"`
+	// AllowedOrigins lists the origins allowed to open WebSocket connections.
+	// Empty allows same-origin requests only, "*" allows every origin.
+	AllowedOrigins []string `json:"allowedOrigins" yaml:"allowedOrigins"`
+	// StaticDir holds the built client application
+	StaticDir   string            `json:"staticDir" yaml:"staticDir"`
+	Persistence PersistenceConfig `json:"persistence" yaml:"persistence"`
+	History     HistoryConfig     `json:"history" yaml:"history"`
+	// Redis is the host:port of a Redis server relaying updates between replicas
+	Redis  string `json:"redis" yaml:"redis"`
+	Limits Limits `json:"limits" yaml:"limits"`
+	// LogLevel is one of "debug", "info", "warn" and "error"
+	LogLevel string `json:"logLevel" yaml:"logLevel"`
+	// ShutdownTimeout is the time allowed for draining clients and persisting documents
+	ShutdownTimeout Duration `json:"shutdownTimeout" yaml:"shutdownTimeout"`
+	// ResumeTimeout is how long the session of a disconnected client can be resumed
+	ResumeTimeout Duration `json:"resumeTimeout" yaml:"resumeTimeout"`
+}
+
+// Default returns the configuration used when nothing else is specified
+func Default() *Config {
+	return &Config{
+		Addr:            ":8080",
+		AllowedOrigins:  []string{AnyOrigin},
+		StaticDir:       "./ClientApp/build",
+		Persistence:     PersistenceConfig{Backend: PersistenceNone},
+		History:         HistoryConfig{Interval: Duration(10 * time.Minute)},
+		LogLevel:        LogInfo,
+		ShutdownTimeout: Duration(10 * time.Second),
+		ResumeTimeout:   Duration(2 * time.Minute),
+	}
+}
+
+// Load builds the configuration from, in increasing priority, the defaults, the
+// configuration file, the YCS_* environment variables and the command line flags.
+// The file is named by the -config flag or the YCS_CONFIG variable; its format
+// is picked by the extension (.json, .yaml or .yml).
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("ycs", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := fs.String("config", "", "path of a JSON or YAML configuration file")
+	flags := cfg.bindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	path := *configFile
+	if path == "" {
+		path = getenv("YCS_CONFIG")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, fmt.Errorf("reading configuration file %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.loadEnv(getenv); err != nil {
+		return nil, err
+	}
+
+	// Only flags given on the command line override the file and the environment
+	fs.Visit(func(f *flag.Flag) {
+		if apply, ok := flags[f.Name]; ok {
+			apply()
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Usage writes the flag documentation to w
+func Usage(w io.Writer) {
+	fs := flag.NewFlagSet("ycs", flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.String("config", "", "path of a JSON or YAML configuration file")
+	Default().bindFlags(fs)
+	fs.PrintDefaults()
+}
+
+// bindFlags defines the flags and returns, by flag name, functions copying the parsed values into the configuration
+func (c *Config) bindFlags(fs *flag.FlagSet) map[string]func() {
+	addr := fs.String("addr", c.Addr, "host:port to listen on")
+	certFile := fs.String("tls-cert", "", "TLS certificate file; enables HTTPS together with -tls-key")
+	keyFile := fs.String("tls-key", "", "TLS private key file")
+	origins := fs.String("allowed-origins", strings.Join(c.AllowedOrigins, ","), `comma separated origins allowed to open WebSockets, "*" for any, empty for same origin`)
+	staticDir := fs.String("static-dir", c.StaticDir, "directory of the built client application")
+	backend := fs.String("persistence", c.Persistence.Backend, `persistence backend: "none" or "file"`)
+	dataDir := fs.String("data-dir", "", "directory of the file persistence backend")
+	historyDir := fs.String("history-dir", "", "directory of the checkpoints of room documents; enables version history")
+	historyInterval := fs.Duration("history-interval", time.Duration(c.History.Interval), "how often a changing room is checkpointed automatically, 0 to disable")
+	redis := fs.String("redis", "", "host:port of a Redis server relaying updates between replicas")
+	maxMessageBytes := fs.Int64("max-message-bytes", 0, "largest message accepted from a client, 0 for unlimited")
+	maxClients := fs.Int("max-clients-per-room", 0, "clients accepted per room, 0 for unlimited")
+	maxRooms := fs.Int("max-rooms", 0, "rooms loaded at the same time, 0 for unlimited")
+	maxDocuments := fs.Int("max-documents-per-connection", 0, "documents synced over one multiplexed connection, 0 for unlimited")
+	logLevel := fs.String("log-level", c.LogLevel, "log level: debug, info, warn or error")
+	resumeTimeout := fs.Duration("resume-timeout", time.Duration(c.ResumeTimeout), "how long the session of a disconnected client can be resumed")
+	shutdownTimeout := fs.Duration("shutdown-timeout", time.Duration(c.ShutdownTimeout), "time allowed for draining clients and persisting documents on shutdown")
+
+	return map[string]func(){
+		"addr":                         func() { c.Addr = *addr },
+		"tls-cert":                     func() { c.TLS.CertFile = *certFile },
+		"tls-key":                      func() { c.TLS.KeyFile = *keyFile },
+		"allowed-origins":              func() { c.AllowedOrigins = splitList(*origins) },
+		"static-dir":                   func() { c.StaticDir = *staticDir },
+		"persistence":                  func() { c.Persistence.Backend = *backend },
+		"data-dir":                     func() { c.setDataDir(*dataDir) },
+		"history-dir":                  func() { c.History.Dir = *historyDir },
+		"history-interval":             func() { c.History.Interval = Duration(*historyInterval) },
+		"redis":                        func() { c.Redis = *redis },
+		"max-message-bytes":            func() { c.Limits.MaxMessageBytes = *maxMessageBytes },
+		"max-clients-per-room":         func() { c.Limits.MaxClientsPerRoom = *maxClients },
+		"max-rooms":                    func() { c.Limits.MaxRooms = *maxRooms },
+		"max-documents-per-connection": func() { c.Limits.MaxDocumentsPerConnection = *maxDocuments },
+		"log-level":                    func() { c.LogLevel = *logLevel },
+		"shutdown-timeout":             func() { c.ShutdownTimeout = Duration(*shutdownTimeout) },
+		"resume-timeout":               func() { c.ResumeTimeout = Duration(*resumeTimeout) },
+	}
+}
+
+// setDataDir sets the persistence directory and selects the file backend if none was chosen
+func (c *Config) setDataDir(dir string) {
+	c.Persistence.Dir = dir
+	if dir != "" && c.Persistence.Backend == PersistenceNone {
+		c.Persistence.Backend = PersistenceFile
+	}
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(c)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported format %q, use .json, .yaml or .yml", filepath.Ext(path))
+	}
+}
+
+func (c *Config) loadEnv(getenv func(string) string) error {
+	stringVars := map[string]*string{
+		"YCS_ADDR":        &c.Addr,
+		"YCS_TLS_CERT":    &c.TLS.CertFile,
+		"YCS_TLS_KEY":     &c.TLS.KeyFile,
+		"YCS_STATIC_DIR":  &c.StaticDir,
+		"YCS_PERSISTENCE": &c.Persistence.Backend,
+		"YCS_HISTORY_DIR": &c.History.Dir,
+		"YCS_REDIS":       &c.Redis,
+		"YCS_LOG_LEVEL":   &c.LogLevel,
+	}
+	for name, target := range stringVars {
+		if value := getenv(name); value != "" {
+			*target = value
+		}
+	}
+
+	if value := getenv("YCS_ALLOWED_ORIGINS"); value != "" {
+		c.AllowedOrigins = splitList(value)
+	}
+	if value := getenv("YCS_DATA_DIR"); value != "" {
+		c.setDataDir(value)
+	}
+
+	intVars := map[string]*int{
+		"YCS_MAX_CLIENTS_PER_ROOM":         &c.Limits.MaxClientsPerRoom,
+		"YCS_MAX_ROOMS":                    &c.Limits.MaxRooms,
+		"YCS_MAX_DOCUMENTS_PER_CONNECTION": &c.Limits.MaxDocumentsPerConnection,
+	}
+	for name, target := range intVars {
+		if value := getenv(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*target = parsed
+		}
+	}
+
+	if value := getenv("YCS_MAX_MESSAGE_BYTES"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid YCS_MAX_MESSAGE_BYTES: %w", err)
+		}
+		c.Limits.MaxMessageBytes = parsed
+	}
+
+	durationVars := map[string]*Duration{
+		"YCS_SHUTDOWN_TIMEOUT": &c.ShutdownTimeout,
+		"YCS_RESUME_TIMEOUT":   &c.ResumeTimeout,
+		"YCS_HISTORY_INTERVAL": &c.History.Interval,
+	}
+	for name, target := range durationVars {
+		if value := getenv(name); value != "" {
+			if err := target.UnmarshalText([]byte(value)); err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the configuration and reports every problem found
+func (c *Config) Validate() error {
+	var problems []error
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf("  "+format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		addProblem("addr %q: %v", c.Addr, err)
+	}
+
+	if c.TLS.Enabled() {
+		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+			addProblem("tls: both certFile and keyFile are required")
+		}
+		for _, file := range []string{c.TLS.CertFile, c.TLS.KeyFile} {
+			if file == "" {
+				continue
+			}
+			if _, err := os.Stat(file); err != nil {
+				addProblem("tls: %v", err)
+			}
+		}
+	}
+
+	for _, origin := range c.AllowedOrigins {
+		if origin == AnyOrigin {
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || (parsed.Path != "" && parsed.Path != "/") {
+			addProblem("allowedOrigins: %q is not an origin like https://example.com", origin)
+		}
+	}
+
+	if c.StaticDir != "" {
+		if info, err := os.Stat(c.StaticDir); err == nil && !info.IsDir() {
+			addProblem("staticDir %q is not a directory", c.StaticDir)
+		}
+	}
+
+	switch c.Persistence.Backend {
+	case PersistenceNone:
+	case PersistenceFile:
+		if c.Persistence.Dir == "" {
+			addProblem("persistence: the file backend requires dir")
+		}
+	default:
+		addProblem("persistence: unknown backend %q, use %q or %q", c.Persistence.Backend, PersistenceNone, PersistenceFile)
+	}
+
+	if c.History.Interval < 0 {
+		addProblem("history: interval must not be negative")
+	}
+
+	if c.Limits.MaxMessageBytes < 0 {
+		addProblem("limits: maxMessageBytes must not be negative")
+	}
+	if c.Limits.MaxClientsPerRoom < 0 {
+		addProblem("limits: maxClientsPerRoom must not be negative")
+	}
+	if c.Limits.MaxRooms < 0 {
+		addProblem("limits: maxRooms must not be negative")
+	}
+	if c.Limits.MaxDocumentsPerConnection < 0 {
+		addProblem("limits: maxDocumentsPerConnection must not be negative")
+	}
+
+	switch c.LogLevel {
+	case LogDebug, LogInfo, LogWarn, LogError:
+	default:
+		addProblem("logLevel: unknown level %q, use debug, info, warn or error", c.LogLevel)
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		addProblem("shutdownTimeout must be positive")
+	}
+	if c.ResumeTimeout < 0 {
+		addProblem("resumeTimeout must not be negative")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(problems...))
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
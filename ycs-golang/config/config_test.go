@@ -0,0 +1,189 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a getenv reading the variables
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+// writeFile writes a configuration file into a temporary directory
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load(nil, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Fatalf("got %+v instead of the defaults", cfg)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "ycs.json", `{
+		"addr": ":1001",
+		"logLevel": "warn",
+		"redis": "file:6379",
+		"limits": {"maxRooms": 1, "maxClientsPerRoom": 1},
+		"shutdownTimeout": "5s"
+	}`)
+	vars := map[string]string{
+		"YCS_CONFIG":               file,
+		"YCS_ADDR":                 ":1002",
+		"YCS_REDIS":                "env:6379",
+		"YCS_MAX_ROOMS":            "2",
+		"YCS_MAX_CLIENTS_PER_ROOM": "2",
+	}
+	args := []string{"-addr", ":1003", "-max-rooms", "3"}
+
+	cfg, err := Load(args, env(vars))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"flag over environment and file", cfg.Addr, ":1003"},
+		{"flag over environment", cfg.Limits.MaxRooms, 3},
+		{"environment over file", cfg.Redis, "env:6379"},
+		{"environment over file without flag", cfg.Limits.MaxClientsPerRoom, 2},
+		{"file over default", cfg.LogLevel, LogWarn},
+		{"file duration", cfg.ShutdownTimeout, Duration(5 * time.Second)},
+		{"default", cfg.ResumeTimeout, Default().ResumeTimeout},
+	} {
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestFlagDefaultsDoNotOverride(t *testing.T) {
+	file := writeFile(t, "ycs.yaml", "logLevel: debug\nresumeTimeout: 1m\n")
+
+	// Flags left out keep their defaults, which must not replace the file and the environment
+	cfg, err := Load([]string{"-config", file}, env(map[string]string{"YCS_ALLOWED_ORIGINS": "https://a.example, https://b.example"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogLevel != LogDebug || cfg.ResumeTimeout != Duration(time.Minute) {
+		t.Errorf("file values replaced: %q, %v", cfg.LogLevel, time.Duration(cfg.ResumeTimeout))
+	}
+	if want := []string{"https://a.example", "https://b.example"}; !reflect.DeepEqual(cfg.AllowedOrigins, want) {
+		t.Errorf("allowed origins %v, want %v", cfg.AllowedOrigins, want)
+	}
+}
+
+func TestConfigFlagOverridesVariable(t *testing.T) {
+	fromFlag := writeFile(t, "flag.yml", "addr: \":2001\"\n")
+	fromVariable := writeFile(t, "variable.json", `{"addr": ":2002"}`)
+
+	cfg, err := Load([]string{"-config", fromFlag}, env(map[string]string{"YCS_CONFIG": fromVariable}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":2001" {
+		t.Fatalf("addr %q was not read from the -config file", cfg.Addr)
+	}
+}
+
+func TestDataDirSelectsFileBackend(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := Load([]string{"-data-dir", dir}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Persistence != (PersistenceConfig{Backend: PersistenceFile, Dir: dir}) {
+		t.Fatalf("persistence %+v", cfg.Persistence)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		args []string
+		vars map[string]string
+		want string
+	}{
+		{"unknown flag", []string{"-port", "1"}, nil, "flag provided but not defined"},
+		{"missing file", []string{"-config", "missing.json"}, nil, "missing.json"},
+		{"unsupported format", []string{"-config", writeFile(t, "ycs.toml", "")}, nil, "unsupported format"},
+		{"unknown JSON field", []string{"-config", writeFile(t, "ycs.json", `{"port": 1}`)}, nil, "unknown field"},
+		{"unknown YAML field", []string{"-config", writeFile(t, "ycs.yaml", "port: 1\n")}, nil, "not found"},
+		{"invalid file duration", []string{"-config", writeFile(t, "ycs.json", `{"resumeTimeout": "soon"}`)}, nil, "soon"},
+		{"invalid integer variable", nil, map[string]string{"YCS_MAX_ROOMS": "many"}, "invalid YCS_MAX_ROOMS"},
+		{"invalid size variable", nil, map[string]string{"YCS_MAX_MESSAGE_BYTES": "1MB"}, "invalid YCS_MAX_MESSAGE_BYTES"},
+		{"invalid duration variable", nil, map[string]string{"YCS_SHUTDOWN_TIMEOUT": "10"}, "invalid YCS_SHUTDOWN_TIMEOUT"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Load(c.args, env(c.vars))
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("got %v, want an error containing %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	file := writeFile(t, "file", "")
+	for _, c := range []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{"addr", func(c *Config) { c.Addr = "8080" }, "addr"},
+		{"TLS key", func(c *Config) { c.TLS.CertFile = file }, "both certFile and keyFile"},
+		{"TLS files", func(c *Config) { c.TLS = TLSConfig{CertFile: "missing.pem", KeyFile: file} }, "missing.pem"},
+		{"origin", func(c *Config) { c.AllowedOrigins = []string{"example.com"} }, "allowedOrigins"},
+		{"origin with path", func(c *Config) { c.AllowedOrigins = []string{"https://example.com/app"} }, "allowedOrigins"},
+		{"static directory", func(c *Config) { c.StaticDir = file }, "not a directory"},
+		{"file backend", func(c *Config) { c.Persistence.Backend = PersistenceFile }, "requires dir"},
+		{"backend", func(c *Config) { c.Persistence.Backend = "s3" }, "unknown backend"},
+		{"history interval", func(c *Config) { c.History.Interval = -1 }, "interval"},
+		{"message size", func(c *Config) { c.Limits.MaxMessageBytes = -1 }, "maxMessageBytes"},
+		{"clients", func(c *Config) { c.Limits.MaxClientsPerRoom = -1 }, "maxClientsPerRoom"},
+		{"rooms", func(c *Config) { c.Limits.MaxRooms = -1 }, "maxRooms"},
+		{"documents", func(c *Config) { c.Limits.MaxDocumentsPerConnection = -1 }, "maxDocumentsPerConnection"},
+		{"log level", func(c *Config) { c.LogLevel = "trace" }, "logLevel"},
+		{"shutdown timeout", func(c *Config) { c.ShutdownTimeout = 0 }, "shutdownTimeout"},
+		{"resume timeout", func(c *Config) { c.ResumeTimeout = -1 }, "resumeTimeout"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.StaticDir = ""
+			c.modify(cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("got %v, want an error containing %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	_, err := Load([]string{"-log-level", "trace", "-max-rooms", "-1"}, env(map[string]string{"YCS_PERSISTENCE": "s3"}))
+	if err == nil {
+		t.Fatal("no error for an invalid configuration")
+	}
+	for _, want := range []string{"logLevel", "maxRooms", "unknown backend"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q is missing from %v", want, err)
+		}
+	}
+}
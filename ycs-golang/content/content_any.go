@@ -2,6 +2,7 @@ package content
 
 import (
 	"ycs/contracts"
+	"ycs/lib0"
 )
 
 const ContentAnyRef = 8
@@ -84,10 +85,10 @@ func (c *ContentAny) Write(encoder contracts.IUpdateEncoder, offset int) error {
 
 func ReadContentAny(decoder contracts.IUpdateDecoder) *ContentAny {
 	length := decoder.ReadLength()
-	content := make([]interface{}, length)
+	content := make([]interface{}, 0, min(length, lib0.MaxPreallocation))
 
 	for i := 0; i < length; i++ {
-		content[i] = decoder.ReadAny()
+		content = append(content, decoder.ReadAny())
 	}
 
 	return &ContentAny{content: content}
@@ -67,7 +67,7 @@ func (c *ContentDeleted) Gc(store contracts.IStructStore) {
 }
 
 func (c *ContentDeleted) Write(encoder contracts.IUpdateEncoder, offset int) error {
-	encoder.WriteLength(c.length)
+	encoder.WriteLength(c.length - offset)
 	return nil
 }
 
@@ -6,18 +6,46 @@ import (
 
 const ContentDocRef = 9
 
-// ContentDoc represents document content
+// ContentDoc represents a sub-document embedded in a parent document
 type ContentDoc struct {
+	doc  contracts.IYDoc
 	opts *contracts.YDocOptions
 }
 
-// NewContentDoc creates a new ContentDoc instance
-func NewContentDoc(opts *contracts.YDocOptions) *ContentDoc {
+// NewContentDoc creates a new ContentDoc instance. A document can only be
+// integrated once; a second instance with the same guid has to be used instead.
+func NewContentDoc(doc contracts.IYDoc) *ContentDoc {
+	if doc.GetItem() != nil {
+		panic("this document was already integrated as a sub-document, create a second instance with the same guid instead")
+	}
+
+	opts := contracts.NewYDocOptions()
+	opts.Guid = doc.GetGuid()
+	opts.Gc = doc.GetGc()
+	opts.AutoLoad = doc.GetAutoLoad()
+	opts.Meta = doc.GetMeta()
+
 	return &ContentDoc{
+		doc:  doc,
 		opts: opts,
 	}
 }
 
+// GetDoc returns the sub-document
+func (c *ContentDoc) GetDoc() contracts.IYDoc {
+	return c.doc
+}
+
+// SetDoc sets the sub-document
+func (c *ContentDoc) SetDoc(doc contracts.IYDoc) {
+	c.doc = doc
+}
+
+// GetOpts returns the options the sub-document is written with
+func (c *ContentDoc) GetOpts() *contracts.YDocOptions {
+	return c.opts
+}
+
 // GetRef returns the reference ID for this content type
 func (c *ContentDoc) GetRef() int {
 	return ContentDocRef
@@ -25,7 +53,7 @@ func (c *ContentDoc) GetRef() int {
 
 // GetCountable returns whether this content is countable
 func (c *ContentDoc) GetCountable() bool {
-	return false
+	return true
 }
 
 // GetLength returns the length of this content
@@ -35,20 +63,19 @@ func (c *ContentDoc) GetLength() int {
 
 // GetContent returns the content as an interface slice
 func (c *ContentDoc) GetContent() []interface{} {
-	return []interface{}{nil}
+	return []interface{}{c.doc}
 }
 
-// Copy creates a copy of this content
+// Copy creates a copy of this content with a new, unloaded instance of the sub-document
 func (c *ContentDoc) Copy() contracts.IContent {
-	return &ContentDoc{
-		opts: c.opts,
-	}
+	opts := c.opts.Clone()
+	opts.Guid = c.doc.GetGuid()
+	return NewContentDoc(docFactory(opts))
 }
 
 // Splice splits this content at the given offset
 func (c *ContentDoc) Splice(offset int) contracts.IContent {
-	// ContentDoc cannot be split
-	return nil
+	panic("ContentDoc cannot be split")
 }
 
 // MergeWith attempts to merge this content with another
@@ -59,23 +86,33 @@ func (c *ContentDoc) MergeWith(right contracts.IContent) bool {
 
 // Integrate integrates this content into a transaction
 func (c *ContentDoc) Integrate(transaction contracts.ITransaction, item contracts.IStructItem) {
-	// Implementation would go here
+	// This needs to be reflected in YDoc.Destroy as well
+	c.doc.SetItem(item)
+	transaction.GetSubdocsAdded()[c.doc] = struct{}{}
+	if c.doc.GetShouldLoad() {
+		transaction.GetSubdocsLoaded()[c.doc] = struct{}{}
+	}
 }
 
 // Delete deletes this content
 func (c *ContentDoc) Delete(transaction contracts.ITransaction) {
-	// Implementation would go here
+	added := transaction.GetSubdocsAdded()
+	if _, ok := added[c.doc]; ok {
+		delete(added, c.doc)
+	} else {
+		transaction.GetSubdocsRemoved()[c.doc] = struct{}{}
+	}
 }
 
 // Gc garbage collects this content
 func (c *ContentDoc) Gc(store contracts.IStructStore) {
-	// Implementation would go here
+	// Do nothing
 }
 
 // Write writes this content to an encoder
 func (c *ContentDoc) Write(encoder contracts.IUpdateEncoder, offset int) error {
-	// Implementation would go here
-	return nil
+	encoder.WriteString(c.doc.GetGuid())
+	return c.opts.Write(encoder, offset)
 }
 
 // Document factory for avoiding circular dependencies
@@ -88,6 +125,12 @@ func SetDocFactory(factory func(*contracts.YDocOptions) contracts.IYDoc) {
 
 // ReadContentDoc reads ContentDoc from a decoder
 func ReadContentDoc(decoder contracts.IUpdateDecoder) *ContentDoc {
-	// Implementation would go here
-	return nil
+	guid := decoder.ReadString()
+	opts := contracts.ReadYDocOptions(decoder)
+	opts.Guid = guid
+
+	if docFactory == nil {
+		panic("DocFactory not initialized. Call SetDocFactory() first.")
+	}
+	return NewContentDoc(docFactory(opts))
 }
@@ -4,7 +4,7 @@ import (
 	"ycs/contracts"
 )
 
-const ContentEmbedRef = 3
+const ContentEmbedRef = 5
 
 // ContentEmbed represents embedded content
 type ContentEmbed struct {
@@ -18,6 +18,11 @@ func NewContentEmbed(embed interface{}) *ContentEmbed {
 	}
 }
 
+// GetEmbed returns the embedded value
+func (c *ContentEmbed) GetEmbed() interface{} {
+	return c.embed
+}
+
 // GetRef returns the reference ID for this content type
 func (c *ContentEmbed) GetRef() int {
 	return ContentEmbedRef
@@ -59,17 +64,17 @@ func (c *ContentEmbed) MergeWith(right contracts.IContent) bool {
 
 // Integrate integrates this content into a transaction
 func (c *ContentEmbed) Integrate(transaction contracts.ITransaction, item contracts.IStructItem) {
-	// Implementation would go here
+	// Do nothing
 }
 
 // Delete deletes this content
 func (c *ContentEmbed) Delete(transaction contracts.ITransaction) {
-	// Implementation would go here
+	// Do nothing
 }
 
 // Gc garbage collects this content
 func (c *ContentEmbed) Gc(store contracts.IStructStore) {
-	// Implementation would go here
+	// Do nothing
 }
 
 // Write writes this content to an encoder
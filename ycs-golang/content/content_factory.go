@@ -21,7 +21,7 @@ func (cf *ContentFactory) CreateContentType(abstractType contracts.IAbstractType
 // CreateContentDoc creates content for a document
 func (cf *ContentFactory) CreateContentDoc(doc interface{}) contracts.IContent {
 	if yDoc, ok := doc.(contracts.IYDoc); ok {
-		return NewContentDoc(yDoc.GetOpts())
+		return NewContentDoc(yDoc)
 	}
 	panic(fmt.Sprintf("Expected YDoc instance, got %T", doc))
 }
@@ -20,6 +20,16 @@ func NewContentFormat(key string, value interface{}) *ContentFormat {
 	}
 }
 
+// GetKey returns the attribute key
+func (c *ContentFormat) GetKey() string {
+	return c.key
+}
+
+// GetValue returns the attribute value, nil removes the attribute
+func (c *ContentFormat) GetValue() interface{} {
+	return c.value
+}
+
 // GetRef returns the reference ID for this content type
 func (c *ContentFormat) GetRef() int {
 	return ContentFormatRef
@@ -62,29 +72,32 @@ func (c *ContentFormat) MergeWith(right contracts.IContent) bool {
 
 // Integrate integrates this content into a transaction
 func (c *ContentFormat) Integrate(transaction contracts.ITransaction, item contracts.IStructItem) {
-	// Implementation would go here
+	// Search markers are currently unsupported for rich text documents
+	if arrayBase, ok := item.GetParent().(contracts.IYArrayBase); ok {
+		arrayBase.ClearSearchMarkers()
+	}
 }
 
 // Delete deletes this content
 func (c *ContentFormat) Delete(transaction contracts.ITransaction) {
-	// Implementation would go here
+	// Do nothing
 }
 
 // Gc garbage collects this content
 func (c *ContentFormat) Gc(store contracts.IStructStore) {
-	// Implementation would go here
+	// Do nothing
 }
 
 // Write writes this content to an encoder
 func (c *ContentFormat) Write(encoder contracts.IUpdateEncoder, offset int) error {
 	encoder.WriteKey(c.key)
-	encoder.WriteAny(c.value)
+	encoder.WriteJSON(c.value)
 	return nil
 }
 
 // ReadContentFormat reads ContentFormat from a decoder
 func ReadContentFormat(decoder contracts.IUpdateDecoder) *ContentFormat {
 	key := decoder.ReadKey()
-	value := decoder.ReadAny()
+	value := decoder.ReadJSON()
 	return &ContentFormat{key: key, value: value}
 }
@@ -1,7 +1,10 @@
 package content
 
 import (
+	"encoding/json"
+
 	"ycs/contracts"
+	"ycs/lib0"
 )
 
 const ContentJsonRef = 2
@@ -71,32 +74,46 @@ func (c *ContentJson) MergeWith(right contracts.IContent) bool {
 
 // Integrate integrates this content into a transaction
 func (c *ContentJson) Integrate(transaction contracts.ITransaction, item contracts.IStructItem) {
-	// Implementation would go here
+	// Do nothing
 }
 
 // Delete deletes this content
 func (c *ContentJson) Delete(transaction contracts.ITransaction) {
-	// Implementation would go here
+	// Do nothing
 }
 
 // Gc garbage collects this content
 func (c *ContentJson) Gc(store contracts.IStructStore) {
-	// Implementation would go here
+	// Do nothing
 }
 
-// Write writes this content to an encoder
+// Write writes this content to an encoder, one JSON string per element
 func (c *ContentJson) Write(encoder contracts.IUpdateEncoder, offset int) error {
-	encoder.WriteJSON(c.content)
+	length := len(c.content)
+	encoder.WriteLength(length - offset)
+	for i := offset; i < length; i++ {
+		data, err := json.Marshal(c.content[i])
+		if err != nil {
+			return err
+		}
+		encoder.WriteString(string(data))
+	}
 	return nil
 }
 
 // ReadContentJson reads ContentJson from a decoder
 func ReadContentJson(decoder contracts.IUpdateDecoder) *ContentJson {
 	length := decoder.ReadLength()
-	content := make([]interface{}, length)
+	content := make([]interface{}, 0, min(length, lib0.MaxPreallocation))
 
 	for i := 0; i < length; i++ {
-		content[i] = decoder.ReadAny()
+		var value interface{}
+		if str := decoder.ReadString(); str != "undefined" {
+			if err := json.Unmarshal([]byte(str), &value); err != nil {
+				panic(err)
+			}
+		}
+		content = append(content, value)
 	}
 
 	return &ContentJson{content: content}
@@ -0,0 +1,200 @@
+package content
+
+import (
+	"errors"
+	"math"
+
+	"ycs/contracts"
+	"ycs/lib0"
+)
+
+const ContentMoveRef = 11
+
+// MaxMovePriority is the highest priority a move can have; the priority is encoded
+// in the bits of a 32-bit integer above its six flags
+const MaxMovePriority = math.MaxUint32 >> 6
+
+// ContentMove moves a range of a list to the position of its item. The range is
+// given by the IDs of its first and last element; the association of each end says
+// whether the element itself belongs to the range, as with relative positions.
+// Elements claimed by several moves are rendered at the move with the highest
+// priority; equal priorities are ordered by the IDs of the moves, so that concurrent
+// moves of the same element converge. The encoding follows the experimental move
+// content of Yjs.
+type ContentMove struct {
+	start      contracts.StructID
+	startAssoc int
+	end        contracts.StructID
+	endAssoc   int
+	priority   int
+	// item is the item of the move once it is integrated
+	item contracts.IStructItem
+}
+
+// NewContentMove creates a move of the elements from start to end, both included.
+// Panics if priority is negative or higher than MaxMovePriority.
+func NewContentMove(start, end contracts.StructID, priority int) *ContentMove {
+	if priority < 0 || priority > MaxMovePriority {
+		panic("move priority out of range")
+	}
+	return &ContentMove{
+		start:      start,
+		startAssoc: 0,
+		end:        end,
+		endAssoc:   -1,
+		priority:   priority,
+	}
+}
+
+// GetStart returns the ID at which the range starts and its association: the
+// element belongs to the range if the association is not negative
+func (c *ContentMove) GetStart() (contracts.StructID, int) {
+	return c.start, c.startAssoc
+}
+
+// GetEnd returns the ID at which the range ends and its association: the element
+// belongs to the range if the association is negative
+func (c *ContentMove) GetEnd() (contracts.StructID, int) {
+	return c.end, c.endAssoc
+}
+
+// GetPriority returns the priority of the move
+func (c *ContentMove) GetPriority() int {
+	return c.priority
+}
+
+// GetRef returns the reference ID for this content type
+func (c *ContentMove) GetRef() int {
+	return ContentMoveRef
+}
+
+// GetCountable returns whether this content is countable. A move is rendered as the
+// elements it claims, which are counted at their own items.
+func (c *ContentMove) GetCountable() bool {
+	return false
+}
+
+// GetLength returns the length of this content
+func (c *ContentMove) GetLength() int {
+	return 1
+}
+
+// GetContent returns the content as an interface slice
+func (c *ContentMove) GetContent() []interface{} {
+	return []interface{}{nil}
+}
+
+// Copy creates a copy of this content
+func (c *ContentMove) Copy() contracts.IContent {
+	copied := *c
+	copied.item = nil
+	return &copied
+}
+
+// Splice splits this content at the given offset
+func (c *ContentMove) Splice(offset int) contracts.IContent {
+	panic("ContentMove cannot be split")
+}
+
+// MergeWith attempts to merge this content with another
+func (c *ContentMove) MergeWith(right contracts.IContent) bool {
+	return false
+}
+
+// Integrate integrates this content into a transaction
+func (c *ContentMove) Integrate(transaction contracts.ITransaction, item contracts.IStructItem) {
+	c.item = item
+	if arrayBase, ok := item.GetParent().(contracts.IYArrayBase); ok {
+		arrayBase.MarkMoved()
+	}
+}
+
+// Delete deletes this content. The elements it claimed are rendered at their own
+// position or at another move again.
+func (c *ContentMove) Delete(transaction contracts.ITransaction) {
+	if c.item == nil {
+		return
+	}
+	if arrayBase, ok := c.item.GetParent().(contracts.IYArrayBase); ok {
+		arrayBase.UnmarkMoved()
+	}
+}
+
+// Gc garbage collects this content
+func (c *ContentMove) Gc(store contracts.IStructStore) {
+	// Do nothing
+}
+
+// isCollapsed returns whether the range starts and ends at the same ID
+func (c *ContentMove) isCollapsed() bool {
+	return c.start == c.end
+}
+
+// Write writes this content to an encoder
+func (c *ContentMove) Write(encoder contracts.IUpdateEncoder, offset int) error {
+	var flags uint32
+	if c.startAssoc >= 0 {
+		flags |= 1
+	}
+	if c.endAssoc >= 0 {
+		flags |= 2
+	}
+	if c.isCollapsed() {
+		flags |= 4
+	}
+	flags |= uint32(c.priority) << 6
+
+	writer := encoder.GetRestWriter()
+	lib0.WriteVarUint(writer, flags)
+	lib0.WriteVarUint(writer, uint32(c.start.Client))
+	lib0.WriteVarUint(writer, uint32(c.start.Clock))
+	if !c.isCollapsed() {
+		lib0.WriteVarUint(writer, uint32(c.end.Client))
+		lib0.WriteVarUint(writer, uint32(c.end.Clock))
+	}
+	return nil
+}
+
+// ReadContentMove reads ContentMove from a decoder
+func ReadContentMove(decoder contracts.IUpdateDecoder) (*ContentMove, error) {
+	reader, ok := decoder.GetReader().(lib0.StreamReader)
+	if !ok {
+		return nil, errors.New("decoder reader does not support byte reads")
+	}
+	flags, err := lib0.ReadVarUint(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ContentMove{startAssoc: -1, endAssoc: -1, priority: int(flags >> 6)}
+	if flags&1 != 0 {
+		c.startAssoc = 0
+	}
+	if flags&2 != 0 {
+		c.endAssoc = 0
+	}
+
+	if c.start, err = readMoveID(reader); err != nil {
+		return nil, err
+	}
+	c.end = c.start
+	if flags&4 == 0 {
+		if c.end, err = readMoveID(reader); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// readMoveID reads the ID of one end of a move
+func readMoveID(reader lib0.StreamReader) (contracts.StructID, error) {
+	client, err := lib0.ReadVarUint(reader)
+	if err != nil {
+		return contracts.StructID{}, err
+	}
+	clock, err := lib0.ReadVarUint(reader)
+	if err != nil {
+		return contracts.StructID{}, err
+	}
+	return contracts.StructID{Client: int64(client), Clock: int64(clock)}, nil
+}
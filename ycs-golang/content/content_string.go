@@ -1,20 +1,23 @@
 package content
 
 import (
+	"unicode/utf16"
+
 	"ycs/contracts"
 )
 
 const ContentStringRef = 4
 
-// ContentString represents string content
+// ContentString represents string content stored as UTF-16 code units, so that
+// lengths and offsets match the other Yjs implementations
 type ContentString struct {
-	content string
+	content []uint16
 }
 
 // NewContentString creates a new ContentString instance
 func NewContentString(content string) *ContentString {
 	return &ContentString{
-		content: content,
+		content: utf16.Encode([]rune(content)),
 	}
 }
 
@@ -28,17 +31,21 @@ func (c *ContentString) GetCountable() bool {
 	return true
 }
 
-// GetLength returns the length of this content
+// GetLength returns the length of this content in UTF-16 code units
 func (c *ContentString) GetLength() int {
 	return len(c.content)
 }
 
-// GetContent returns the content as an interface slice
+// GetString returns the content as a string
+func (c *ContentString) GetString() string {
+	return string(utf16.Decode(c.content))
+}
+
+// GetContent returns the content as an interface slice, one entry per code unit
 func (c *ContentString) GetContent() []interface{} {
-	runes := []rune(c.content)
-	result := make([]interface{}, len(runes))
-	for i, r := range runes {
-		result[i] = string(r)
+	result := make([]interface{}, len(c.content))
+	for i, unit := range c.content {
+		result[i] = string(utf16.Decode([]uint16{unit}))
 	}
 	return result
 }
@@ -46,17 +53,25 @@ func (c *ContentString) GetContent() []interface{} {
 // Copy creates a copy of this content
 func (c *ContentString) Copy() contracts.IContent {
 	return &ContentString{
-		content: c.content,
+		content: append([]uint16(nil), c.content...),
 	}
 }
 
 // Splice splits this content at the given offset
 func (c *ContentString) Splice(offset int) contracts.IContent {
-	runes := []rune(c.content)
 	right := &ContentString{
-		content: string(runes[offset:]),
+		content: append([]uint16(nil), c.content[offset:]...),
+	}
+	c.content = c.content[:offset]
+
+	// Splitting a surrogate pair would produce an invalid document, so both
+	// halves are replaced with the unicode replacement character.
+	if offset > 0 && utf16.IsSurrogate(rune(c.content[offset-1])) && c.content[offset-1] < 0xDC00 {
+		c.content[offset-1] = 0xFFFD
+		if len(right.content) > 0 {
+			right.content[0] = 0xFFFD
+		}
 	}
-	c.content = string(runes[:offset])
 	return right
 }
 
@@ -66,34 +81,32 @@ func (c *ContentString) MergeWith(right contracts.IContent) bool {
 	if !ok {
 		return false
 	}
-	c.content += rightString.content
+	c.content = append(c.content, rightString.content...)
 	return true
 }
 
 // Integrate integrates this content into a transaction
 func (c *ContentString) Integrate(transaction contracts.ITransaction, item contracts.IStructItem) {
-	// Implementation would go here
+	// Do nothing
 }
 
 // Delete deletes this content
 func (c *ContentString) Delete(transaction contracts.ITransaction) {
-	// Implementation would go here
+	// Do nothing
 }
 
 // Gc garbage collects this content
 func (c *ContentString) Gc(store contracts.IStructStore) {
-	// Implementation would go here
+	// Do nothing
 }
 
 // Write writes this content to an encoder
 func (c *ContentString) Write(encoder contracts.IUpdateEncoder, offset int) error {
-	runes := []rune(c.content)
-	encoder.WriteString(string(runes[offset:]))
+	encoder.WriteString(string(utf16.Decode(c.content[offset:])))
 	return nil
 }
 
 // ReadContentString reads ContentString from a decoder
 func ReadContentString(decoder contracts.IUpdateDecoder) *ContentString {
-	str := decoder.ReadString()
-	return &ContentString{content: str}
+	return NewContentString(decoder.ReadString())
 }
@@ -0,0 +1,76 @@
+package content_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"ycs/content"
+	"ycs/core"
+)
+
+func TestContentStringCountsUTF16(t *testing.T) {
+	c := content.NewContentString("a😀é")
+	if got := c.GetLength(); got != 4 {
+		t.Fatalf("length %d, want 4", got)
+	}
+	if got := c.GetString(); got != "a😀é" {
+		t.Fatalf("string %q", got)
+	}
+
+	right := c.Splice(3).(*content.ContentString)
+	if c.GetString() != "a😀" || right.GetString() != "é" {
+		t.Fatalf("split into %q and %q", c.GetString(), right.GetString())
+	}
+	if !c.MergeWith(right) || c.GetString() != "a😀é" {
+		t.Fatalf("merged into %q", c.GetString())
+	}
+}
+
+func TestContentStringSpliceInsideSurrogatePair(t *testing.T) {
+	c := content.NewContentString("a😀b")
+	right := c.Splice(2).(*content.ContentString)
+	if c.GetString() != "a�" || right.GetString() != "�b" {
+		t.Fatalf("split into %q and %q", c.GetString(), right.GetString())
+	}
+	if c.GetLength()+right.GetLength() != 4 {
+		t.Fatal("splitting changed the length")
+	}
+}
+
+func TestContentRoundTrip(t *testing.T) {
+	encoder := core.NewUpdateEncoderV2()
+	content.NewContentString("héllo 😀").Write(encoder, 1)
+	content.NewContentJson([]interface{}{"a", float64(2), nil}).Write(encoder, 1)
+	content.NewContentFormat("bold", map[string]interface{}{"weight": float64(700)}).Write(encoder, 0)
+	content.NewContentEmbed(map[string]interface{}{"image": "x.png"}).Write(encoder, 0)
+	content.NewContentDeleted(5).Write(encoder, 2)
+	data := encoder.ToArray()
+
+	decoder := core.NewUpdateDecoderV2(bytes.NewReader(data))
+	if got := content.ReadContentString(decoder).GetString(); got != "éllo 😀" {
+		t.Errorf("string %q", got)
+	}
+	if got := content.ReadContentJson(decoder).GetContent(); !reflect.DeepEqual(got, []interface{}{float64(2), nil}) {
+		t.Errorf("json %#v", got)
+	}
+	format := content.ReadContentFormat(decoder)
+	if format.GetKey() != "bold" || !reflect.DeepEqual(format.GetValue(), map[string]interface{}{"weight": float64(700)}) {
+		t.Errorf("format %q %#v", format.GetKey(), format.GetValue())
+	}
+	if got := content.ReadContentEmbed(decoder).GetEmbed(); !reflect.DeepEqual(got, map[string]interface{}{"image": "x.png"}) {
+		t.Errorf("embed %#v", got)
+	}
+	if got := content.ReadContentDeleted(decoder).GetLength(); got != 3 {
+		t.Errorf("deleted length %d", got)
+	}
+}
+
+func TestContentRefsMatchYjs(t *testing.T) {
+	if content.NewContentEmbed(nil).GetRef() != 5 {
+		t.Error("embed ref")
+	}
+	if content.NewContentString("").GetRef() != 4 {
+		t.Error("string ref")
+	}
+}
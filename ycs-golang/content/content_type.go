@@ -19,6 +19,11 @@ func NewContentType(contentType contracts.IAbstractType) *ContentType {
 	}
 }
 
+// GetType returns the type held by this content
+func (c *ContentType) GetType() contracts.IAbstractType {
+	return c.contentType
+}
+
 // GetRef returns the reference ID for this content type
 func (c *ContentType) GetRef() int {
 	return ContentTypeRef
@@ -26,7 +31,7 @@ func (c *ContentType) GetRef() int {
 
 // GetCountable returns whether this content is countable
 func (c *ContentType) GetCountable() bool {
-	return false
+	return true
 }
 
 // GetLength returns the length of this content
@@ -41,14 +46,12 @@ func (c *ContentType) GetContent() []interface{} {
 
 // Copy creates a copy of this content
 func (c *ContentType) Copy() contracts.IContent {
-	// ContentType cannot be copied directly
-	return nil
+	return NewContentType(c.contentType.InternalCopy())
 }
 
 // Splice splits this content at the given offset
 func (c *ContentType) Splice(offset int) contracts.IContent {
-	// ContentType cannot be split
-	return nil
+	panic("ContentType cannot be split")
 }
 
 // MergeWith attempts to merge this content with another
@@ -59,22 +62,54 @@ func (c *ContentType) MergeWith(right contracts.IContent) bool {
 
 // Integrate integrates this content into a transaction
 func (c *ContentType) Integrate(transaction contracts.ITransaction, item contracts.IStructItem) {
-	// Implementation would go here
+	c.contentType.Integrate(transaction.GetDoc(), item)
 }
 
 // Delete deletes this content
 func (c *ContentType) Delete(transaction contracts.ITransaction) {
-	// Implementation would go here
+	for item := c.contentType.GetStart(); item != nil; item = item.GetRight() {
+		if !item.GetDeleted() {
+			item.Delete(transaction)
+		} else {
+			// Deleted children are gc'd later, so they are added to the merge
+			// structs of this transaction to be merged if possible.
+			transaction.AddMergeStruct(item)
+		}
+	}
+
+	for _, valueItem := range c.contentType.GetMap() {
+		if !valueItem.GetDeleted() {
+			valueItem.Delete(transaction)
+		} else {
+			transaction.AddMergeStruct(valueItem)
+		}
+	}
+
+	delete(transaction.GetChanged(), c.contentType)
 }
 
 // Gc garbage collects this content
 func (c *ContentType) Gc(store contracts.IStructStore) {
-	// Implementation would go here
+	for item := c.contentType.GetStart(); item != nil; {
+		next := item.GetRight()
+		item.Gc(store, true)
+		item = next
+	}
+	c.contentType.SetStart(nil)
+
+	for _, valueItem := range c.contentType.GetMap() {
+		for valueItem != nil {
+			left := valueItem.GetLeft()
+			valueItem.Gc(store, true)
+			valueItem = left
+		}
+	}
+	c.contentType.SetMap(make(map[string]contracts.IStructItem))
 }
 
 // Write writes this content to an encoder
 func (c *ContentType) Write(encoder contracts.IUpdateEncoder, offset int) error {
-	// Implementation would go here
+	c.contentType.Write(encoder)
 	return nil
 }
 
@@ -69,8 +69,11 @@ type IAbstractType interface {
 	InternalClone() IAbstractType
 	InternalCopy() IAbstractType
 	InvokeEventHandlers(evt IYEvent, transaction ITransaction)
+	Observe(handler YEventHandler) func()
+	ObserveDeep(handler YDeepEventHandler) func()
 	Write(encoder IUpdateEncoder)
 	First() IStructItem
+	ToJSON(textAsDelta ...bool) interface{} // textAsDelta defaults to false
 }
 
 // IContent represents content interface
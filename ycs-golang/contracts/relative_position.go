@@ -0,0 +1,33 @@
+package contracts
+
+// RelativePosition represents a relative position in the document
+// A relative position is based on the Y.js model and is not affected by document changes.
+// E.g. if you place a relative position before a certain character, it will always point to this character.
+// If you place a relative position at the end of a type, it will always point to the end of the type.
+type RelativePosition struct {
+	Item   *StructID
+	TypeId *StructID
+	TName  string
+	// A relative position is associated to a specific character.
+	// By default, the value is >= 0, the relative position is associated to the character
+	// after the meant position.
+	// If the value is < 0, then the relative position is associated with the character
+	// before the meant position.
+	Assoc int
+}
+
+// Equals compares two RelativePosition instances
+func (rp *RelativePosition) Equals(other *RelativePosition) bool {
+	if rp == other {
+		return true
+	}
+
+	if other == nil {
+		return false
+	}
+
+	return rp.TName == other.TName &&
+		EqualsPtr(rp.Item, other.Item) &&
+		EqualsPtr(rp.TypeId, other.TypeId) &&
+		rp.Assoc == other.Assoc
+}
@@ -10,12 +10,15 @@ type IStructStore interface {
 	FollowRedone(id StructID) (IStructItem, int)
 	GetItemCleanEnd(transaction ITransaction, id StructID) IStructItem
 	GetItemCleanStart(transaction ITransaction, id StructID) IStructItem
+	GetPendingDeleteSetCount() int
+	GetPendingStructCount() int
 	GetState(clientID int64) int64
 	GetStateVector() map[int64]int64
 	IntegrityCheck()
 	IterateStructs(transaction ITransaction, structs []IStructItem, clockStart int64, length int64, fun func(IStructItem) bool)
 	MergeReadStructsIntoPendingReads(clientStructRefs map[int64][]IStructItem)
 	ReadAndApplyDeleteSet(decoder IDSDecoder, transaction ITransaction) error
+	ReplacePendingParent(oldParent IAbstractType, newParent IAbstractType)
 	ReplaceStruct(oldStruct IStructItem, newStruct IStructItem) error
 	ResumeStructIntegration(transaction ITransaction)
 	TryResumePendingDeleteReaders(transaction ITransaction)
@@ -13,8 +13,12 @@ type IYArray interface {
 	Integrate(doc IYDoc, item IStructItem)
 	InternalClone() IAbstractType
 	InternalCopy() IAbstractType
+	Move(from, to int)
+	MoveRange(from, length, to int)
+	SetFromArray(values []interface{}, key func(value interface{}) string)
 	Slice(start ...int) []interface{} // start defaults to 0, optional end parameter
 	ToArray() []interface{}
+	ToArrayAt(snapshot ISnapshot) []interface{}
 	Unshift(content []interface{})
 	Write(encoder IUpdateEncoder)
 }
@@ -22,4 +26,8 @@ type IYArray interface {
 // IYArrayBase represents the base Y array interface
 type IYArrayBase interface {
 	ClearSearchMarkers()
+	// MarkMoved tells the list that a move was added to it
+	MarkMoved()
+	// UnmarkMoved tells the list that one of its moves was deleted
+	UnmarkMoved()
 }
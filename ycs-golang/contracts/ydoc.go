@@ -12,6 +12,7 @@ type AfterAllTransactionsHandler func([]ITransaction)
 type UpdateV2Handler func([]byte, interface{}, ITransaction)
 type DestroyedHandler func()
 type SubdocsChangedHandler func(map[IYDoc]struct{}, map[IYDoc]struct{}, map[IYDoc]struct{})
+type ClientIDChangedHandler func(oldClientID, newClientID int)
 
 // IYDoc represents a Y document interface
 type IYDoc interface {
@@ -37,20 +38,23 @@ type IYDoc interface {
 	GetTransactionCleanups() []ITransaction
 	SetTransactionCleanups(transactionCleanups []ITransaction)
 
-	ApplyUpdateV2(update []byte, transactionOrigin interface{}, local ...bool)         // local defaults to false
-	ApplyUpdateV2Stream(input io.Reader, transactionOrigin interface{}, local ...bool) // local defaults to false
+	ApplyUpdateV2(update []byte, transactionOrigin interface{}, local ...bool) error         // local defaults to false
+	ApplyUpdateV2Stream(input io.Reader, transactionOrigin interface{}, local ...bool) error // local defaults to false
 	CloneOptionsWithNewGuid() *YDocOptions
 	CreateSnapshot() ISnapshot
 	Destroy()
-	EncodeStateAsUpdateV2(encodedTargetStateVector ...[]byte) []byte // optional parameter
+	EncodeStateAsUpdateV2(encodedTargetStateVector ...[]byte) ([]byte, error) // optional parameter
 	EncodeStateVectorV2() []byte
 	FindRootTypeKey(abstractType IAbstractType) string
 	Get(name string, typeConstructor func() IAbstractType) IAbstractType // Generic equivalent
 	GetArray(name ...string) IYArray                                     // name defaults to ""
 	GetMap(name ...string) IYMap                                         // name defaults to ""
+	GetSubdoc(guid string) IYDoc
 	GetSubdocGuids() []string
-	GetText(name ...string) IYText // name defaults to ""
+	GetText(name ...string) IYText                     // name defaults to ""
+	ToJSON(textAsDelta ...bool) map[string]interface{} // textAsDelta defaults to false
 	InvokeAfterAllTransactions(transactions []ITransaction)
+	InvokeClientIDChanged(oldClientID, newClientID int)
 	InvokeBeforeAllTransactions()
 	InvokeDestroyed()
 	InvokeOnAfterTransaction(transaction ITransaction)
@@ -60,18 +64,23 @@ type IYDoc interface {
 	InvokeSubdocsChanged(loaded map[IYDoc]struct{}, added map[IYDoc]struct{}, removed map[IYDoc]struct{})
 	InvokeUpdateV2(transaction ITransaction)
 	Load()
+	ReassignClientID()
+	Lock()                                                              // see View
+	Unlock()                                                            // see View
+	View(fun func())                                                    // calls fun holding the lock of the document
 	Transact(fun func(ITransaction), origin interface{}, local ...bool) // local defaults to true
 	WriteStateAsUpdate(encoder IUpdateEncoder, targetStateVector map[int64]int64) error
 	WriteStateVector(encoder IDSEncoder) error
 
-	// Event handlers - Go doesn't have events like C#, so we use function fields
-	OnBeforeObserverCalls(handler BeforeObserverCallsHandler)
-	OnBeforeTransaction(handler BeforeTransactionHandler)
-	OnAfterTransaction(handler AfterTransactionHandler)
-	OnAfterTransactionCleanup(handler AfterTransactionCleanupHandler)
-	OnBeforeAllTransactions(handler BeforeAllTransactionsHandler)
-	OnAfterAllTransactions(handler AfterAllTransactionsHandler)
-	OnUpdateV2(handler UpdateV2Handler)
-	OnDestroyed(handler DestroyedHandler)
-	OnSubdocsChanged(handler SubdocsChangedHandler)
+	// Event handlers - every registration returns a function that removes the handler
+	OnBeforeObserverCalls(handler BeforeObserverCallsHandler) func()
+	OnBeforeTransaction(handler BeforeTransactionHandler) func()
+	OnAfterTransaction(handler AfterTransactionHandler) func()
+	OnAfterTransactionCleanup(handler AfterTransactionCleanupHandler) func()
+	OnBeforeAllTransactions(handler BeforeAllTransactionsHandler) func()
+	OnAfterAllTransactions(handler AfterAllTransactionsHandler) func()
+	OnUpdateV2(handler UpdateV2Handler) func()
+	OnDestroyed(handler DestroyedHandler) func()
+	OnSubdocsChanged(handler SubdocsChangedHandler) func()
+	OnClientIDChanged(handler ClientIDChangedHandler) func()
 }
@@ -74,22 +74,27 @@ func ReadYDocOptions(decoder IUpdateDecoder) *YDocOptions {
 
 	result := NewYDocOptions()
 
-	if gc, ok := dict["gc"]; ok {
-		result.Gc = gc.(bool)
+	if gc, ok := dict["gc"].(bool); ok {
+		result.Gc = gc
 	}
 
-	if guid, ok := dict["guid"]; ok {
-		result.Guid = guid.(string)
+	if guid, ok := dict["guid"].(string); ok {
+		result.Guid = guid
 	} else {
 		result.Guid = generateGUID()
 	}
 
-	if meta, ok := dict["meta"]; ok {
-		result.Meta = meta.(map[string]string)
+	if meta, ok := dict["meta"].(map[string]interface{}); ok {
+		result.Meta = make(map[string]string, len(meta))
+		for k, v := range meta {
+			if str, ok := v.(string); ok {
+				result.Meta[k] = str
+			}
+		}
 	}
 
-	if autoLoad, ok := dict["autoLoad"]; ok {
-		result.AutoLoad = autoLoad.(bool)
+	if autoLoad, ok := dict["autoLoad"].(bool); ok {
+		result.AutoLoad = autoLoad
 	}
 
 	return result
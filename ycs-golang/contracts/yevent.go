@@ -6,6 +6,12 @@ type YEventArgs struct {
 	Transaction ITransaction
 }
 
+// YEventHandler handles the event of a type
+type YEventHandler func(YEventArgs)
+
+// YDeepEventHandler handles the events of a type and of the types nested in it
+type YDeepEventHandler func(YDeepEventArgs)
+
 // NewYEventArgs creates a new YEventArgs instance
 func NewYEventArgs(event IYEvent, transaction ITransaction) *YEventArgs {
 	return &YEventArgs{
@@ -8,6 +8,7 @@ type IYMap interface {
 	Clone() IYMap
 	ContainsKey(key string) bool
 	Delete(key string)
+	EntriesAt(snapshot ISnapshot) map[string]interface{}
 	Get(key string) interface{}
 	GetEnumerator() map[string]interface{} // Go doesn't have IEnumerator, using map
 	Integrate(doc IYDoc, item IStructItem)
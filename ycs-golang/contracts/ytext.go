@@ -1,5 +1,7 @@
 package contracts
 
+import "regexp"
+
 // YTextChangeType represents the type of text change
 type YTextChangeType int
 
@@ -25,14 +27,46 @@ type YTextChangeAttributes struct {
 	Type  YTextChangeType
 	User  int
 	State YTextChangeType
+	// Author is the user that made the change, if known
+	Author string
+}
+
+// IUserAttribution maps the changes of a document to the users that made them
+type IUserAttribution interface {
+	// GetUserByClientID returns the user of a client ID, or "" if it is unknown
+	GetUserByClientID(clientID int64) string
+	// GetUserByDeletedID returns the user that deleted the struct with the ID, or "" if it is unknown
+	GetUserByDeletedID(id StructID) string
+}
+
+// TextBlame is a range of text inserted by one client
+type TextBlame struct {
+	// Index and Length count UTF-16 code units, like the indexes of YText
+	Index  int
+	Length int
+	Client int64
+	// Author is the user of the client, or "" if it is unknown
+	Author string
+}
+
+// TextMatch is a range of text found by Find. The positions stay on the matched
+// text while the document changes; text inserted right before or after the match
+// is not part of it.
+type TextMatch struct {
+	Start *RelativePosition
+	End   *RelativePosition
 }
 
 // IYText represents a Y text interface
 type IYText interface {
+	IAbstractType
 	ApplyDelta(delta []Delta, sanitize ...bool) // sanitize defaults to true
+	Blame(users IUserAttribution) []TextBlame
 	CallObserver(transaction ITransaction, parentSubs map[string]struct{})
 	Clone() IYText
 	Delete(index int, length int)
+	Find(pattern string) ([]TextMatch, error)
+	FindRegexp(re *regexp.Regexp) ([]TextMatch, error)
 	Format(index int, length int, attributes map[string]interface{})
 	GetAttribute(name string) interface{}
 	GetAttributes() map[string]interface{}
@@ -41,8 +75,12 @@ type IYText interface {
 	Integrate(doc IYDoc, item IStructItem)
 	InternalClone() IAbstractType
 	RemoveAttribute(name string)
+	ReplaceAll(old, replacement string, origin interface{}) (int, error)
+	ReplaceRegexp(re *regexp.Regexp, replacement string, origin interface{}) (int, error)
 	SetAttribute(name string, value interface{})
+	SetFromString(text string)
 	ToDelta(snapshot ISnapshot, prevSnapshot ISnapshot, computeYChange func(YTextChangeType, StructID, YTextChangeAttributes) interface{}) []Delta
 	ToString() string
+	ToStringAt(snapshot ISnapshot) string
 	Write(encoder IUpdateEncoder)
 }
@@ -22,7 +22,7 @@ func NewAbsolutePosition(typ contracts.IAbstractType, index int, assoc int) *Abs
 }
 
 // TryCreateFromRelativePosition tries to create an AbsolutePosition from a RelativePosition
-func TryCreateFromRelativePosition(rpos *RelativePosition, doc contracts.IYDoc) *AbsolutePosition {
+func TryCreateFromRelativePosition(rpos *contracts.RelativePosition, doc contracts.IYDoc) *AbsolutePosition {
 	store := doc.GetStore()
 	rightId := rpos.Item
 	typeId := rpos.TypeId
@@ -1,26 +1,36 @@
 package core
 
 import (
+	"strings"
 	"ycs/content"
 	"ycs/contracts"
 )
 
 // AbstractType represents the base type for all Y types
 type AbstractType struct {
+	self             contracts.IAbstractType
 	item             contracts.IStructItem
 	start            contracts.IStructItem
 	m                map[string]contracts.IStructItem
 	doc              contracts.IYDoc
 	length           int
-	eventHandler     func(contracts.YEventArgs)
-	deepEventHandler func(contracts.YDeepEventArgs)
+	eventHandler     observers[contracts.YEventHandler]
+	deepEventHandler observers[contracts.YDeepEventHandler]
 }
 
 // NewAbstractType creates a new AbstractType
 func NewAbstractType() *AbstractType {
-	return &AbstractType{
+	at := &AbstractType{
 		m: make(map[string]contracts.IStructItem),
 	}
+	at.self = at
+	return at
+}
+
+// setSelf sets the concrete type that embeds this AbstractType. Items, transactions
+// and observers always refer to the concrete type, never to the embedded base.
+func (at *AbstractType) setSelf(self contracts.IAbstractType) {
+	at.self = self
 }
 
 // GetItem returns the item
@@ -61,7 +71,9 @@ func (at *AbstractType) GetDoc() contracts.IYDoc {
 // GetParent returns the parent
 func (at *AbstractType) GetParent() contracts.IAbstractType {
 	if at.item != nil {
-		return at.item.GetParent().(contracts.IAbstractType)
+		if parent, ok := at.item.GetParent().(contracts.IAbstractType); ok {
+			return parent
+		}
 	}
 	return nil
 }
@@ -82,6 +94,91 @@ func (at *AbstractType) Integrate(doc contracts.IYDoc, item contracts.IStructIte
 	at.item = item
 }
 
+// View calls fun holding the lock of the document the type is integrated in, so
+// that several reads of the type are consistent, see YDoc. Types that are not
+// integrated yet belong to one goroutine and need no lock.
+func (at *AbstractType) View(fun func()) {
+	if at.doc == nil {
+		fun()
+		return
+	}
+	at.doc.View(fun)
+}
+
+// ToJSON renders a type whose kind is not known, see guessJSON. YMap, YArray and
+// YText render themselves; only root types that remote updates created and that
+// were never defined locally are rendered here.
+func (at *AbstractType) ToJSON(textAsDelta ...bool) interface{} {
+	return at.guessJSON(textAsDelta)
+}
+
+// guessJSON renders the content of a type whose kind is not known, guessing it from
+// the content: a sequence of text renders as a string, even with textAsDelta, since
+// its formatting cannot be told from embeds; another sequence renders as an array
+// and entries as a map. Returns nil for an empty type.
+func (at *AbstractType) guessJSON(textAsDelta []bool) (value interface{}) {
+	if at.start == nil {
+		if entries := at.typeMapEnumerateValues(); len(entries) > 0 {
+			value = jsonValue(entries, textAsDelta)
+		}
+		return
+	}
+
+	var sb strings.Builder
+	var elements []interface{}
+	isText := true
+	for n := at.start; n != nil; n = n.GetRight() {
+		if n.GetDeleted() || !n.GetCountable() {
+			continue
+		}
+		if cs, ok := n.GetContent().(*content.ContentString); ok {
+			sb.WriteString(cs.GetString())
+		} else {
+			isText = false
+		}
+		elements = append(elements, n.GetContent().GetContent()...)
+	}
+	if isText {
+		value = sb.String()
+	} else {
+		value = jsonValue(elements, textAsDelta)
+	}
+	return value
+}
+
+// checkInsertable panics if value is a type that is already part of a document. A
+// type can be inserted only once; insert a Clone of it or move it with MoveToMap or
+// MoveToArray instead.
+func checkInsertable(value interface{}) {
+	if at, ok := value.(contracts.IAbstractType); ok && at.GetDoc() != nil {
+		panic("the type is already part of a document, insert a Clone of it instead")
+	}
+}
+
+// jsonValue renders a value of a shared type for encoding/json: nested shared types
+// render with ToJSON and subdocuments as their GUID
+func jsonValue(value interface{}, textAsDelta []bool) interface{} {
+	switch v := value.(type) {
+	case contracts.IAbstractType:
+		return v.ToJSON(textAsDelta...)
+	case contracts.IYDoc:
+		return v.GetGuid()
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, element := range v {
+			result[i] = jsonValue(element, textAsDelta)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			result[key] = jsonValue(element, textAsDelta)
+		}
+		return result
+	}
+	return value
+}
+
 // InternalCopy creates an internal copy (to be overridden by subclasses)
 func (at *AbstractType) InternalCopy() contracts.IAbstractType {
 	panic("InternalCopy not implemented")
@@ -100,22 +197,22 @@ func (at *AbstractType) Write(encoder contracts.IUpdateEncoder) {
 // CallTypeObservers calls event listeners with an event. This will also add an event to all parents
 // for observeDeep handlers.
 func (at *AbstractType) CallTypeObservers(transaction contracts.ITransaction, evt contracts.IYEvent) {
-	currentType := at
+	currentType := at.self
 
 	for {
-		values, exists := transaction.GetChangedParentTypes()[currentType]
-		if !exists {
-			values = make([]contracts.IYEvent, 0)
-			transaction.GetChangedParentTypes()[currentType] = values
-		}
+		changedParentTypes := transaction.GetChangedParentTypes()
+		changedParentTypes[currentType] = append(changedParentTypes[currentType], evt)
 
-		transaction.GetChangedParentTypes()[currentType] = append(values, evt)
-
-		if currentType.item == nil {
+		item := currentType.GetItem()
+		if item == nil {
 			break
 		}
 
-		currentType = currentType.item.GetParent().(*AbstractType)
+		parent, ok := item.GetParent().(contracts.IAbstractType)
+		if !ok {
+			break
+		}
+		currentType = parent
 	}
 
 	at.InvokeEventHandlers(evt, transaction)
@@ -136,23 +233,34 @@ func (at *AbstractType) First() contracts.IStructItem {
 	return n
 }
 
+// Observe adds a handler for the events of this type and returns a function that removes it
+func (at *AbstractType) Observe(handler contracts.YEventHandler) func() {
+	return at.eventHandler.add(handler)
+}
+
+// ObserveDeep adds a handler for the events of this type and of all types nested in it,
+// and returns a function that removes it. The handler is called once per transaction.
+func (at *AbstractType) ObserveDeep(handler contracts.YDeepEventHandler) func() {
+	return at.deepEventHandler.add(handler)
+}
+
 // InvokeEventHandlers invokes event handlers
 func (at *AbstractType) InvokeEventHandlers(evt contracts.IYEvent, transaction contracts.ITransaction) {
-	if at.eventHandler != nil {
-		at.eventHandler(contracts.YEventArgs{Event: evt, Transaction: transaction})
+	for _, handler := range at.eventHandler.snapshot() {
+		handler(contracts.YEventArgs{Event: evt, Transaction: transaction})
 	}
 }
 
 // CallDeepEventHandlerListeners calls deep event handler listeners
 func (at *AbstractType) CallDeepEventHandlerListeners(events []contracts.IYEvent, transaction contracts.ITransaction) {
-	if at.deepEventHandler != nil {
-		at.deepEventHandler(contracts.YDeepEventArgs{Events: events, Transaction: transaction})
+	for _, handler := range at.deepEventHandler.snapshot() {
+		handler(contracts.YDeepEventArgs{Events: events, Transaction: transaction})
 	}
 }
 
 // FindRootTypeKey finds the root type key
 func (at *AbstractType) FindRootTypeKey() string {
-	return at.doc.FindRootTypeKey(at)
+	return at.doc.FindRootTypeKey(at.self)
 }
 
 // typeMapDelete deletes a key from the type map
@@ -169,21 +277,36 @@ func (at *AbstractType) typeMapSet(transaction contracts.ITransaction, key strin
 		left = l
 	}
 
-	doc := transaction.GetDoc()
-	ownClientID := doc.GetClientID()
 	contentObj := content.CreateContent(value)
+	insertItemAfter(transaction, at.self, left, &key, contentObj.(contracts.IContentEx))
+}
+
+// insertItemAfter inserts an item with content c into parent right after left and
+// returns it. Map entries are inserted under parentSub after its current item.
+func insertItemAfter(transaction contracts.ITransaction, parent contracts.IAbstractType, left contracts.IStructItem, parentSub *string, c contracts.IContentEx) contracts.IStructItem {
+	doc := transaction.GetDoc()
+	ownClientID := int64(doc.GetClientID())
 
-	newItem := NewStructItem(
-		contracts.StructID{Client: int64(ownClientID), Clock: doc.GetStore().GetState(int64(ownClientID))},
-		left,
-		func() *contracts.StructID { id := left.GetLastID(); return &id }(),
-		nil,
-		nil,
-		at,
-		&key,
-		contentObj.(contracts.IContentEx),
-	)
-	newItem.Integrate(transaction, 0)
+	var leftOrigin, rightOrigin *contracts.StructID
+	var right contracts.IStructItem
+	if left != nil {
+		lastID := left.GetLastID()
+		leftOrigin = &lastID
+	}
+	if parentSub == nil {
+		right = parent.GetStart()
+		if left != nil {
+			right = left.GetRight()
+		}
+	}
+	if right != nil {
+		rightID := right.GetID()
+		rightOrigin = &rightID
+	}
+
+	item := NewStructItem(contracts.StructID{Client: ownClientID, Clock: doc.GetStore().GetState(ownClientID)}, left, leftOrigin, right, rightOrigin, parent, parentSub, c)
+	item.Integrate(transaction, 0)
+	return item
 }
 
 // tryTypeMapGet tries to get a value from the type map
@@ -197,6 +320,13 @@ func (at *AbstractType) tryTypeMapGet(key string) (interface{}, bool) {
 
 // typeMapGetSnapshot gets a value from the type map at a specific snapshot
 func (at *AbstractType) typeMapGetSnapshot(key string, snapshot contracts.ISnapshot) interface{} {
+	value, _ := at.typeMapLookupSnapshot(key, snapshot)
+	return value
+}
+
+// typeMapLookupSnapshot gets a value from the type map at a specific snapshot and
+// reports whether the key was set then
+func (at *AbstractType) typeMapLookupSnapshot(key string, snapshot contracts.ISnapshot) (interface{}, bool) {
 	var v contracts.IStructItem
 	if val, exists := at.m[key]; exists {
 		v = val
@@ -214,9 +344,41 @@ func (at *AbstractType) typeMapGetSnapshot(key string, snapshot contracts.ISnaps
 
 	if v != nil && v.IsVisible(snapshot) {
 		content := v.GetContent().GetContent()
-		return content[v.GetLength()-1]
+		return content[v.GetLength()-1], true
 	}
-	return nil
+	return nil, false
+}
+
+// typeMapEnumerateSnapshot returns the entries of the type map at a specific snapshot
+func (at *AbstractType) typeMapEnumerateSnapshot(snapshot contracts.ISnapshot) map[string]interface{} {
+	result := make(map[string]interface{})
+	for key := range at.m {
+		if value, ok := at.typeMapLookupSnapshot(key, snapshot); ok {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// viewSnapshot calls read in a transaction in which the structs are split at the
+// boundaries of snapshot, so that each of them is either visible in it or not.
+// Deleted content is dropped by garbage collection, so the document must not be
+// garbage collected.
+func (at *AbstractType) viewSnapshot(snapshot contracts.ISnapshot, read func()) {
+	if at.doc == nil {
+		read()
+		return
+	}
+	if at.doc.GetGc() {
+		panic("originDoc must not be garbage collected")
+	}
+
+	// Snapshots are merged again after the transaction, so we need to keep the
+	// transaction alive until we are done.
+	transact(at.doc, func(tr contracts.ITransaction) {
+		SplitSnapshotAffectedStructs(tr, snapshot)
+		read()
+	}, "splitSnapshotAffectedStructs")
 }
 
 // typeMapEnumerate enumerates non-deleted items in the type map
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"ycs/content"
+	"ycs/contracts"
+)
+
+func TestInsertItemAfterInList(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	array := doc.GetArray("a").(*YArray)
+	// Two items, as inserting "a" before "c" keeps them from merging
+	array.Insert(0, []interface{}{"c"})
+	array.Insert(0, []interface{}{"a"})
+
+	var inserted contracts.IStructItem
+	doc.Transact(func(tr contracts.ITransaction) {
+		left := array.GetStart()
+		inserted = insertItemAfter(tr, array, left, nil, content.NewContentAny([]interface{}{"b"}))
+	}, nil)
+
+	if got := array.ToArray(); !reflect.DeepEqual(got, []interface{}{"a", "b", "c"}) {
+		t.Fatalf("got %v", got)
+	}
+	// Both origins are set, so concurrent inserts next to the item order like in Yjs
+	if inserted.GetLeftOrigin() == nil || inserted.GetRightOrigin() == nil {
+		t.Fatalf("origins %v %v", inserted.GetLeftOrigin(), inserted.GetRightOrigin())
+	}
+	if *inserted.GetRightOrigin() != inserted.GetRight().GetID() {
+		t.Fatalf("right origin %v, right %v", *inserted.GetRightOrigin(), inserted.GetRight().GetID())
+	}
+
+	remote := NewYDoc(contracts.YDocOptions{})
+	remote.ApplyUpdateV2(encodeUpdate(t, doc), nil)
+	if got := remote.GetArray("a").ToArray(); !reflect.DeepEqual(got, []interface{}{"a", "b", "c"}) {
+		t.Fatalf("remote got %v", got)
+	}
+}
+
+func TestInsertItemAfterInMap(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	m := doc.GetMap("m").(*YMap)
+	m.Set("k", "old")
+
+	key := "k"
+	doc.Transact(func(tr contracts.ITransaction) {
+		item := insertItemAfter(tr, m, m.GetMap()[key], &key, content.NewContentAny([]interface{}{"new"}))
+		if item.GetRightOrigin() != nil {
+			t.Fatalf("map entry has right origin %v", *item.GetRightOrigin())
+		}
+	}, nil)
+
+	if got := m.Get("k"); got != "new" {
+		t.Fatalf("got %v", got)
+	}
+}
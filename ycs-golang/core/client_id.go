@@ -0,0 +1,87 @@
+package core
+
+import (
+	"ycs/content"
+	"ycs/contracts"
+)
+
+// clientIDCollisionMeta marks a transaction, in its meta map, that received structs
+// of another peer under the client ID of the document
+const clientIDCollisionMeta = "ycs:clientIDCollision"
+
+// detectClientIDCollision reports whether refs, the structs read from a remote update,
+// hold structs of another peer under the client ID of doc. Such structs either extend
+// the local state of the client or differ from the local structs at the same clock.
+// Structs that equal the local ones are echoes of local updates.
+func detectClientIDCollision(doc contracts.IYDoc, store contracts.IStructStore, refs map[int64][]contracts.IStructItem) bool {
+	client := int64(doc.GetClientID())
+	ownRefs := refs[client]
+	if len(ownRefs) == 0 {
+		return false
+	}
+
+	state := store.GetState(client)
+	for _, ref := range ownRefs {
+		clock := ref.GetID().Clock
+		if clock+int64(ref.GetLength()) > state {
+			return true
+		}
+		if !sameStructAt(ref, store.GetClients()[client], clock) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameStructAt reports whether ref, which starts at clock, describes the local struct
+// at clock. Garbage collected structs and deleted content cannot be compared, only
+// the origins of deleted items are.
+func sameStructAt(ref contracts.IStructItem, structs []contracts.IStructItem, clock int64) bool {
+	local := structs[FindIndexSS(structs, clock)]
+	if ref.IsGC() || local.IsGC() {
+		return true
+	}
+
+	// A struct that was split from its left neighbor has that neighbor as its origin
+	offset := int(clock - local.GetID().Clock)
+	leftOrigin := local.GetLeftOrigin()
+	if offset > 0 {
+		leftOrigin = &contracts.StructID{Client: local.GetID().Client, Clock: clock - 1}
+	}
+
+	if !equalIDs(ref.GetLeftOrigin(), leftOrigin) ||
+		!equalIDs(ref.GetRightOrigin(), local.GetRightOrigin()) ||
+		ref.GetParentSub() != local.GetParentSub() {
+		return false
+	}
+
+	refContent, localContent := ref.GetContent(), local.GetContent()
+	switch refContent.(type) {
+	case *content.ContentDeleted:
+		return true
+	case *content.ContentType, *content.ContentDoc:
+		// Shared types and subdocuments are new objects in every update
+		return refContent.GetRef() == localContent.GetRef()
+	}
+	if _, deleted := localContent.(*content.ContentDeleted); deleted {
+		return true
+	}
+	if refContent.GetRef() != localContent.GetRef() {
+		return false
+	}
+
+	// Other values may decode to other Go types than they were inserted with
+	if _, isString := refContent.(*content.ContentString); !isString {
+		return true
+	}
+	refValues, localValues := refContent.GetContent(), localContent.GetContent()
+	return len(refValues) > 0 && offset < len(localValues) && refValues[0] == localValues[offset]
+}
+
+// equalIDs reports whether two optional struct IDs are equal
+func equalIDs(a, b *contracts.StructID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"testing"
+
+	"ycs/contracts"
+)
+
+// newDocWithClientID creates a document with a fixed client ID
+func newDocWithClientID(clientID int) *YDoc {
+	doc := NewYDoc(contracts.YDocOptions{})
+	doc.SetClientID(clientID)
+	return doc
+}
+
+// recordClientIDChanges returns the client ID changes of doc, as old and new ID pairs
+func recordClientIDChanges(doc *YDoc) *[][2]int {
+	var changes [][2]int
+	doc.OnClientIDChanged(func(oldClientID, newClientID int) {
+		changes = append(changes, [2]int{oldClientID, newClientID})
+	})
+	return &changes
+}
+
+func TestForeignStructsReassignClientID(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		local string
+	}{
+		// The other peer's structs extend the local state of the client
+		{"ahead", "ab"},
+		// The other peer's structs have the clocks of local structs
+		{"conflicting", "xyz"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := newDocWithClientID(7), newDocWithClientID(7)
+			a.GetText("t").Insert(0, tc.local)
+			b.GetText("t").Insert(0, "hello")
+			changes := recordClientIDChanges(a)
+
+			if err := a.ApplyUpdateV2(encodeUpdate(t, b), "b", false); err != nil {
+				t.Fatal(err)
+			}
+			if len(*changes) != 1 || (*changes)[0][0] != 7 || (*changes)[0][1] != a.GetClientID() || a.GetClientID() == 7 {
+				t.Fatalf("client ID %d after changes %v", a.GetClientID(), *changes)
+			}
+
+			// Changes made afterwards do not collide with the other peer
+			a.GetText("t").Insert(0, "!")
+			if _, exists := a.GetStore().GetClients()[int64(a.GetClientID())]; !exists {
+				t.Fatal("no structs under the new client ID")
+			}
+		})
+	}
+}
+
+func TestEchoedUpdatesKeepClientID(t *testing.T) {
+	doc := newDocWithClientID(7)
+	text := doc.GetText("t")
+	text.Insert(0, "hello")
+	stateVector := doc.EncodeStateVectorV2()
+	text.Insert(2, "XY")
+	text.Delete(0, 3)
+	doc.GetMap("m").Set("k", int64(5))
+	doc.GetArray("a").Insert(0, []interface{}{int64(1), "x", map[string]interface{}{"q": 1.5}})
+	changes := recordClientIDChanges(doc)
+
+	// The server echoes the updates of a client, in full or from a state vector
+	for _, update := range [][]byte{encodeUpdate(t, doc, stateVector), encodeUpdate(t, doc)} {
+		if err := doc.ApplyUpdateV2(update, "echo", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(*changes) != 0 || doc.GetClientID() != 7 {
+		t.Fatalf("client ID %d after changes %v", doc.GetClientID(), *changes)
+	}
+}
+
+func TestLocalUpdatesKeepClientID(t *testing.T) {
+	a, b := newDocWithClientID(7), newDocWithClientID(7)
+	b.GetText("t").Insert(0, "hello")
+	changes := recordClientIDChanges(a)
+
+	// Only remote transactions are checked
+	if err := a.ApplyUpdateV2(encodeUpdate(t, b), "restore", true); err != nil {
+		t.Fatal(err)
+	}
+	if len(*changes) != 0 {
+		t.Fatalf("client ID changes %v", *changes)
+	}
+}
@@ -6,52 +6,51 @@ import (
 	"ycs/lib0"
 )
 
-// DeleteItem represents a deleted item range
-type DeleteItem struct {
-	Clock  int64
-	Length int64
-}
-
-// NewDeleteItem creates a new DeleteItem
-func NewDeleteItem(clock, length int64) *DeleteItem {
-	return &DeleteItem{
-		Clock:  clock,
-		Length: length,
-	}
-}
-
-// DeleteSet represents a set of deleted items organized by client
+// DeleteSet represents a set of deleted items organized by client.
+// Delete items are unsorted until SortAndMergeDeleteSet is called.
 type DeleteSet struct {
-	clients map[int64][]*DeleteItem
+	clients map[int64][]contracts.DeleteItem
 }
 
 // NewDeleteSet creates a new DeleteSet
 func NewDeleteSet() *DeleteSet {
 	return &DeleteSet{
-		clients: make(map[int64][]*DeleteItem),
+		clients: make(map[int64][]contracts.DeleteItem),
 	}
 }
 
-// NewDeleteSetFromStore creates a DeleteSet from a struct store
+// NewDeleteSetFromDeleteSets creates a DeleteSet that merges all the given delete sets
+func NewDeleteSetFromDeleteSets(dss []contracts.IDeleteSet) *DeleteSet {
+	ds := NewDeleteSet()
+	ds.mergeDeleteSets(dss)
+	return ds
+}
+
+// NewDeleteSetFromStore creates a DeleteSet from the deleted structs of a struct store
 func NewDeleteSetFromStore(store contracts.IStructStore) *DeleteSet {
 	ds := NewDeleteSet()
 
 	for client, structs := range store.GetClients() {
-		var deleteItems []*DeleteItem
-
-		for _, item := range structs {
-			if item.GetDeleted() {
-				if len(deleteItems) > 0 {
-					lastItem := deleteItems[len(deleteItems)-1]
-					// Check if we can merge with the last delete item
-					if lastItem.Clock+lastItem.Length == item.GetID().Clock {
-						lastItem.Length += int64(item.GetLength())
-						continue
-					}
-				}
+		var deleteItems []contracts.DeleteItem
 
-				deleteItems = append(deleteItems, NewDeleteItem(item.GetID().Clock, int64(item.GetLength())))
+		for i := 0; i < len(structs); i++ {
+			str := structs[i]
+			if !str.GetDeleted() {
+				continue
 			}
+
+			clock := str.GetID().Clock
+			length := int64(str.GetLength())
+			for i+1 < len(structs) {
+				next := structs[i+1]
+				if next.GetID().Clock != clock+length || !next.GetDeleted() {
+					break
+				}
+				length += int64(next.GetLength())
+				i++
+			}
+
+			deleteItems = append(deleteItems, contracts.NewDeleteItem(clock, length))
 		}
 
 		if len(deleteItems) > 0 {
@@ -62,133 +61,184 @@ func NewDeleteSetFromStore(store contracts.IStructStore) *DeleteSet {
 	return ds
 }
 
-// GetClients returns the clients map
+// GetClients returns the delete items of each client
 func (ds *DeleteSet) GetClients() map[int64][]contracts.DeleteItem {
-	result := make(map[int64][]contracts.DeleteItem)
-	for client, items := range ds.clients {
-		contractItems := make([]contracts.DeleteItem, len(items))
-		for i, item := range items {
-			contractItems[i] = contracts.DeleteItem{Clock: item.Clock, Length: item.Length}
+	return ds.clients
+}
+
+// Add adds a delete range to the set
+func (ds *DeleteSet) Add(client, clock, length int64) {
+	ds.clients[client] = append(ds.clients[client], contracts.NewDeleteItem(clock, length))
+}
+
+// IterateDeletedStructs calls fun for every struct in the delete set, splitting
+// structs at the boundaries of the deleted ranges
+func (ds *DeleteSet) IterateDeletedStructs(transaction contracts.ITransaction, fun func(contracts.IStructItem) bool) {
+	store := transaction.GetDoc().GetStore()
+	for client, deleteItems := range ds.clients {
+		for _, del := range deleteItems {
+			if structs := store.GetClients()[client]; len(structs) > 0 {
+				store.IterateStructs(transaction, structs, del.Clock, del.Length, fun)
+			}
 		}
-		result[client] = contractItems
 	}
-	return result
 }
 
-// IsDeleted checks if a struct ID is deleted
-func (ds *DeleteSet) IsDeleted(id contracts.StructID) bool {
-	deleteItems, exists := ds.clients[id.Client]
-	if !exists {
-		return false
-	}
+// FindIndexSS finds the index of the delete item containing clock, or nil
+func (ds *DeleteSet) FindIndexSS(dis []contracts.DeleteItem, clock int64) *int {
+	left := 0
+	right := len(dis) - 1
+
+	for left <= right {
+		midIndex := (left + right) / 2
+		mid := dis[midIndex]
 
-	for _, item := range deleteItems {
-		if item.Clock <= id.Clock && id.Clock < item.Clock+item.Length {
-			return true
+		if mid.Clock <= clock {
+			if clock < mid.Clock+mid.Length {
+				return &midIndex
+			}
+			left = midIndex + 1
+		} else {
+			right = midIndex - 1
 		}
 	}
 
-	return false
+	return nil
 }
 
-// Add adds a delete range to the set
-func (ds *DeleteSet) Add(client, clock, length int64) {
-	if length <= 0 {
-		return
-	}
+// IsDeleted checks if a struct ID is deleted. The set has to be sorted.
+func (ds *DeleteSet) IsDeleted(id contracts.StructID) bool {
+	dis, exists := ds.clients[id.Client]
+	return exists && ds.FindIndexSS(dis, id.Clock) != nil
+}
 
-	deleteItems := ds.clients[client]
-	newItem := NewDeleteItem(clock, length)
+// SortAndMergeDeleteSet sorts the delete items of each client and merges adjacent and
+// overlapping ranges
+func (ds *DeleteSet) SortAndMergeDeleteSet() {
+	for client, dels := range ds.clients {
+		sort.SliceStable(dels, func(i, j int) bool {
+			return dels[i].Clock < dels[j].Clock
+		})
 
-	// Find insertion point and merge if possible
-	insertPos := 0
-	for i, item := range deleteItems {
-		if item.Clock > clock {
-			insertPos = i
-			break
-		}
-		insertPos = i + 1
-
-		// Check if we can merge with existing item
-		if item.Clock+item.Length == clock {
-			// Merge with this item
-			item.Length += length
-
-			// Check if we can merge with the next item too
-			if i+1 < len(deleteItems) {
-				nextItem := deleteItems[i+1]
-				if item.Clock+item.Length == nextItem.Clock {
-					item.Length += nextItem.Length
-					// Remove the next item
-					deleteItems = append(deleteItems[:i+1], deleteItems[i+2:]...)
+		// Merge items without filtering or splicing the slice: i is the current
+		// pointer, j the insert position for the pointed item.
+		j := 1
+		for i := 1; i < len(dels); i++ {
+			left := dels[j-1]
+			right := dels[i]
+
+			if left.Clock+left.Length >= right.Clock {
+				dels[j-1] = contracts.NewDeleteItem(left.Clock, max(left.Length, right.Clock+right.Length-left.Clock))
+			} else {
+				if j < i {
+					dels[j] = right
 				}
+				j++
 			}
-
-			ds.clients[client] = deleteItems
-			return
 		}
 
-		if clock+length == item.Clock {
-			// Merge by extending this item backwards
-			item.Clock = clock
-			item.Length += length
-			ds.clients[client] = deleteItems
-			return
+		if j < len(dels) {
+			ds.clients[client] = dels[:j]
 		}
 	}
+}
 
-	// Insert new item at the correct position
-	if insertPos >= len(deleteItems) {
-		deleteItems = append(deleteItems, newItem)
-	} else {
-		deleteItems = append(deleteItems[:insertPos+1], deleteItems[insertPos:]...)
-		deleteItems[insertPos] = newItem
-	}
+// TryGc garbage collects the deleted structs and merges them afterwards
+func (ds *DeleteSet) TryGc(store contracts.IStructStore, gcFilter func(contracts.IStructItem) bool) {
+	ds.TryGcDeleteSet(store, gcFilter)
+	ds.TryMergeDeleteSet(store)
+}
+
+// TryGcDeleteSet garbage collects the deleted structs that are not kept
+func (ds *DeleteSet) TryGcDeleteSet(store contracts.IStructStore, gcFilter func(contracts.IStructItem) bool) {
+	for client, deleteItems := range ds.clients {
+		for di := len(deleteItems) - 1; di >= 0; di-- {
+			deleteItem := deleteItems[di]
+			endDeleteItemClock := deleteItem.Clock + deleteItem.Length
+
+			structs := store.GetClients()[client]
+			for si := FindIndexSS(structs, deleteItem.Clock); si < len(structs); si++ {
+				str := structs[si]
+				if str.GetID().Clock >= endDeleteItemClock {
+					break
+				}
 
-	ds.clients[client] = deleteItems
+				if !str.IsGC() && str.GetDeleted() && !str.GetKeep() && gcFilter(str) {
+					str.Gc(store, false)
+				}
+			}
+		}
+	}
 }
 
-// SortAndMergeDeleteSet sorts and merges the delete set
-func (ds *DeleteSet) SortAndMergeDeleteSet() {
+// TryMergeDeleteSet tries to merge the deleted and gc'd structs with their neighbors.
+// Structs are merged from right to left so that no merge target is missed.
+func (ds *DeleteSet) TryMergeDeleteSet(store contracts.IStructStore) {
+	clients := store.GetClients()
 	for client, deleteItems := range ds.clients {
-		if len(deleteItems) <= 1 {
-			continue
+		for di := len(deleteItems) - 1; di >= 0; di-- {
+			deleteItem := deleteItems[di]
+			structs := clients[client]
+
+			// Start with merging the item next to the last deleted item
+			mostRightIndexToCheck := min(len(structs)-1, 1+FindIndexSS(structs, deleteItem.Clock+deleteItem.Length-1))
+			for si := mostRightIndexToCheck; si > 0 && structs[si].GetID().Clock >= deleteItem.Clock; si-- {
+				structs = TryToMergeWithLeft(structs, si)
+			}
+			clients[client] = structs
 		}
+	}
+}
 
-		// Sort by clock
-		sort.Slice(deleteItems, func(i, j int) bool {
-			return deleteItems[i].Clock < deleteItems[j].Clock
-		})
+// TryToMergeWithLeft tries to merge the struct at pos with its left neighbor and
+// returns the updated list
+func TryToMergeWithLeft(structs []contracts.IStructItem, pos int) []contracts.IStructItem {
+	left := structs[pos-1]
+	right := structs[pos]
 
-		// Merge adjacent items
-		merged := make([]*DeleteItem, 0, len(deleteItems))
-		current := deleteItems[0]
+	if left.GetDeleted() != right.GetDeleted() || left.IsGC() != right.IsGC() || !left.MergeWith(right) {
+		return structs
+	}
 
-		for i := 1; i < len(deleteItems); i++ {
-			next := deleteItems[i]
+	structs = append(structs[:pos], structs[pos+1:]...)
 
-			if current.Clock+current.Length == next.Clock {
-				// Merge with current
-				current.Length += next.Length
-			} else {
-				// Add current and move to next
-				merged = append(merged, current)
-				current = next
+	if parentSub := right.GetParentSub(); parentSub != "" {
+		if parent, ok := right.GetParent().(contracts.IAbstractType); ok {
+			if value, ok := parent.GetMap()[parentSub]; ok && value == right {
+				parent.GetMap()[parentSub] = left
 			}
 		}
+	}
+
+	return structs
+}
+
+// mergeDeleteSets merges the delete items of all dss into this set
+func (ds *DeleteSet) mergeDeleteSets(dss []contracts.IDeleteSet) {
+	for dssI, other := range dss {
+		for client, delsLeft := range other.GetClients() {
+			if _, exists := ds.clients[client]; exists {
+				continue
+			}
 
-		// Add the last item
-		merged = append(merged, current)
-		ds.clients[client] = merged
+			// Write all missing keys from the current set and all following ones.
+			// If the client is already present, the current set was added before.
+			dels := append([]contracts.DeleteItem(nil), delsLeft...)
+			for i := dssI + 1; i < len(dss); i++ {
+				dels = append(dels, dss[i].GetClients()[client]...)
+			}
+			ds.clients[client] = dels
+		}
 	}
+
+	ds.SortAndMergeDeleteSet()
 }
 
-// Write writes the delete set to an encoder
+// Write writes the delete set to an encoder, with clients in ascending order
 func (ds *DeleteSet) Write(encoder contracts.IDSEncoder) error {
 	lib0.WriteVarUint(encoder.GetRestWriter(), uint32(len(ds.clients)))
 
-	// Write clients in sorted order for consistency
-	var clients []int64
+	clients := make([]int64, 0, len(ds.clients))
 	for client := range ds.clients {
 		clients = append(clients, client)
 	}
@@ -198,10 +248,11 @@ func (ds *DeleteSet) Write(encoder contracts.IDSEncoder) error {
 
 	for _, client := range clients {
 		deleteItems := ds.clients[client]
+
+		encoder.ResetDsCurVal()
 		lib0.WriteVarUint(encoder.GetRestWriter(), uint32(client))
 		lib0.WriteVarUint(encoder.GetRestWriter(), uint32(len(deleteItems)))
 
-		encoder.ResetDsCurVal()
 		for _, item := range deleteItems {
 			encoder.WriteDsClock(item.Clock)
 			encoder.WriteDsLength(item.Length)
@@ -211,118 +262,35 @@ func (ds *DeleteSet) Write(encoder contracts.IDSEncoder) error {
 	return nil
 }
 
-// TryGcDeleteSet tries to garbage collect the delete set
-func (ds *DeleteSet) TryGcDeleteSet(store contracts.IStructStore, gcFilter func(contracts.IStructItem) bool) {
-	for client, deleteItems := range ds.clients {
-		structs, exists := store.GetClients()[client]
-		if !exists {
-			continue
-		}
-
-		for _, deleteItem := range deleteItems {
-			ds.iterateDeletedStructs(store, structs, deleteItem.Clock, deleteItem.Length, func(item contracts.IStructItem) bool {
-				if item.GetDeleted() && (gcFilter == nil || gcFilter(item)) {
-					// Replace with GC item
-					itemID := item.GetID()
-					coreItemID := itemID
-					gcItem := NewStructGC(coreItemID, item.GetLength())
-					store.ReplaceStruct(item, gcItem)
-				}
-				return true
-			})
-		}
-	}
-}
-
-// TryMergeDeleteSet tries to merge the delete set
-func (ds *DeleteSet) TryMergeDeleteSet(store contracts.IStructStore) {
-	for client, deleteItems := range ds.clients {
-		structs, exists := store.GetClients()[client]
-		if !exists {
-			continue
-		}
-
-		for _, deleteItem := range deleteItems {
-			ds.iterateDeletedStructs(store, structs, deleteItem.Clock, deleteItem.Length, func(item contracts.IStructItem) bool {
-				if item.GetDeleted() {
-					// Try to merge with adjacent items
-					ds.tryToMergeWithLeft(structs, item)
-				}
-				return true
-			})
-		}
-	}
-}
-
-// IterateDeletedStructs iterates over deleted structs in a range
-func (ds *DeleteSet) IterateDeletedStructs(transaction contracts.ITransaction, fn func(contracts.IStructItem) bool) {
-	// This method needs to be implemented according to the interface
-	// For now, we'll provide a basic implementation
-	for client, deleteItems := range ds.clients {
-		structs, exists := transaction.GetStructStore().GetClients()[client]
-		if !exists {
-			continue
-		}
-
-		for _, deleteItem := range deleteItems {
-			ds.iterateDeletedStructs(transaction.GetStructStore(), structs, deleteItem.Clock, deleteItem.Length, fn)
-		}
+// ReadDeleteSet reads a delete set from a decoder
+func ReadDeleteSet(decoder contracts.IDSDecoder) (*DeleteSet, error) {
+	ds := NewDeleteSet()
+	reader, ok := decoder.GetReader().(lib0.StreamReader)
+	if !ok {
+		return nil, errStreamReader
 	}
-}
 
-// iterateDeletedStructs iterates over deleted structs in a range
-func (ds *DeleteSet) iterateDeletedStructs(store contracts.IStructStore, structs []contracts.IStructItem, clock, length int64, fn func(contracts.IStructItem) bool) {
-	if length <= 0 {
-		return
+	numClients, err := lib0.ReadVarUint(reader)
+	if err != nil {
+		return nil, err
 	}
 
-	clockEnd := clock + length
-	index := FindIndexSS(structs, clock)
-
-	for index < len(structs) {
-		item := structs[index]
+	for i := uint32(0); i < numClients; i++ {
+		decoder.ResetDsCurVal()
 
-		if item.GetID().Clock >= clockEnd {
-			break
+		client, err := lib0.ReadVarUint(reader)
+		if err != nil {
+			return nil, err
 		}
-
-		if item.GetID().Clock >= clock {
-			if !fn(item) {
-				break
-			}
+		numberOfDeletes, err := lib0.ReadVarUint(reader)
+		if err != nil {
+			return nil, err
 		}
 
-		index++
-	}
-}
-
-// TryGc performs garbage collection on the delete set
-func (ds *DeleteSet) TryGc(store contracts.IStructStore, gcFilter func(contracts.IStructItem) bool) {
-	// TryGcDeleteSet already implements the GC functionality
-	ds.TryGcDeleteSet(store, gcFilter)
-}
-
-// FindIndexSS finds the index of a delete item with the given clock
-func (ds *DeleteSet) FindIndexSS(dis []contracts.DeleteItem, clock int64) *int {
-	for i, item := range dis {
-		if item.Clock == clock {
-			return &i
+		for j := uint32(0); j < numberOfDeletes; j++ {
+			ds.Add(int64(client), decoder.ReadDsClock(), decoder.ReadDsLength())
 		}
 	}
-	return nil
-}
 
-// tryToMergeWithLeft tries to merge an item with its left neighbor
-func (ds *DeleteSet) tryToMergeWithLeft(structs []contracts.IStructItem, item contracts.IStructItem) {
-	index := FindIndexSS(structs, item.GetID().Clock)
-	if index <= 0 {
-		return
-	}
-
-	left := structs[index-1]
-	if left.TryToMergeWithRight(item) {
-		// Remove the current item since it was merged into left
-		copy(structs[index:], structs[index+1:])
-		structs = structs[:len(structs)-1]
-	}
+	return ds, nil
 }
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"ycs/contracts"
+)
+
+func TestSortAndMergeDeleteSet(t *testing.T) {
+	ds := NewDeleteSet()
+	ds.Add(1, 10, 2)
+	ds.Add(1, 0, 3)
+	ds.Add(1, 3, 2)
+	ds.Add(1, 11, 4)
+	ds.Add(1, 20, 1)
+	ds.Add(2, 5, 1)
+	ds.SortAndMergeDeleteSet()
+
+	want := map[int64][]contracts.DeleteItem{
+		1: {contracts.NewDeleteItem(0, 5), contracts.NewDeleteItem(10, 5), contracts.NewDeleteItem(20, 1)},
+		2: {contracts.NewDeleteItem(5, 1)},
+	}
+	if got := ds.GetClients(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	for clock, deleted := range map[int64]bool{0: true, 4: true, 5: false, 9: false, 10: true, 14: true, 15: false, 20: true, 21: false} {
+		if got := ds.IsDeleted(contracts.StructID{Client: 1, Clock: clock}); got != deleted {
+			t.Errorf("IsDeleted(1, %d) = %v", clock, got)
+		}
+	}
+	if ds.IsDeleted(contracts.StructID{Client: 3, Clock: 0}) {
+		t.Error("unknown client is deleted")
+	}
+}
+
+func TestMergeDeleteSets(t *testing.T) {
+	a := NewDeleteSet()
+	a.Add(1, 0, 5)
+	b := NewDeleteSet()
+	b.Add(1, 2, 6)
+	b.Add(2, 0, 1)
+
+	merged := NewDeleteSetFromDeleteSets([]contracts.IDeleteSet{a, b})
+	want := map[int64][]contracts.DeleteItem{
+		1: {contracts.NewDeleteItem(0, 8)},
+		2: {contracts.NewDeleteItem(0, 1)},
+	}
+	if got := merged.GetClients(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestDeleteSetRoundTrip(t *testing.T) {
+	ds := NewDeleteSet()
+	ds.Add(7, 0, 1)
+	ds.Add(7, 4, 10)
+	ds.Add(3, 2, 2)
+	ds.SortAndMergeDeleteSet()
+
+	encoder := NewDSEncoderV2()
+	if err := ds.Write(encoder); err != nil {
+		t.Fatal(err)
+	}
+	data := encoder.ToArray()
+
+	decoded, err := ReadDeleteSet(NewDSDecoderV2(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.GetClients(), ds.GetClients()) {
+		t.Fatalf("got %v, want %v", decoded.GetClients(), ds.GetClients())
+	}
+}
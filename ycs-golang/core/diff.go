@@ -0,0 +1,168 @@
+package core
+
+// diffBudget bounds the work of a diff. Past it, the differing ranges that are left
+// are replaced as a whole, which keeps the diff of very different sequences fast.
+const diffBudget = 1 << 24
+
+// diffHunk replaces the elements [oldStart, oldEnd) of a sequence by the elements
+// [newStart, newEnd) of another
+type diffHunk struct {
+	oldStart, oldEnd int
+	newStart, newEnd int
+}
+
+// differ computes the elements two sequences have in common with the linear space
+// variant of the algorithm of Myers, as diff-match-patch does
+type differ struct {
+	equal   func(i, j int) bool
+	keepOld []bool
+	keepNew []bool
+	budget  int
+}
+
+// diffSequences returns the hunks of a shortest edit script turning a sequence of n
+// elements into a sequence of m elements, in order. equal reports whether element i
+// of the first sequence equals element j of the second.
+func diffSequences(n, m int, equal func(i, j int) bool) []diffHunk {
+	d := &differ{equal: equal, keepOld: make([]bool, n), keepNew: make([]bool, m), budget: diffBudget}
+	d.compare(0, n, 0, m)
+
+	// The kept elements of both sequences match in order
+	var hunks []diffHunk
+	for i, j := 0, 0; i < n || j < m; {
+		if i < n && j < m && d.keepOld[i] && d.keepNew[j] {
+			i++
+			j++
+			continue
+		}
+		hunk := diffHunk{oldStart: i, newStart: j}
+		for i < n && !d.keepOld[i] {
+			i++
+		}
+		for j < m && !d.keepNew[j] {
+			j++
+		}
+		hunk.oldEnd, hunk.newEnd = i, j
+		hunks = append(hunks, hunk)
+	}
+	return hunks
+}
+
+// compare marks the common elements of old[aLo:aHi] and new[bLo:bHi]
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.equal(aLo, bLo) {
+		d.keepOld[aLo], d.keepNew[bLo] = true, true
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.equal(aHi-1, bHi-1) {
+		aHi--
+		bHi--
+		d.keepOld[aHi], d.keepNew[bHi] = true, true
+	}
+	if aLo == aHi || bLo == bHi {
+		return
+	}
+
+	if x, y, ok := d.bisect(aLo, aHi, bLo, bHi); ok {
+		d.compare(aLo, x, bLo, y)
+		d.compare(x, aHi, y, bHi)
+	}
+}
+
+// bisect finds the middle snake of an optimal path through old[aLo:aHi] and
+// new[bLo:bHi] and returns the point at which to split the problem. Returns false
+// if the ranges have nothing in common or the budget is spent.
+func (d *differ) bisect(aLo, aHi, bLo, bHi int) (int, int, bool) {
+	n, m := aHi-aLo, bHi-bLo
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	length := 2*maxD + 2
+	v1 := make([]int, length)
+	v2 := make([]int, length)
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[offset+1], v2[offset+1] = 0, 0
+
+	delta := n - m
+	// If the total number of elements is odd, the front path collides with the reverse path
+	front := delta%2 != 0
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+
+	for step := 0; step < maxD; step++ {
+		if d.budget -= 2*step + 1; d.budget < 0 {
+			return 0, 0, false
+		}
+
+		// Walk the front path one step
+		for k1 := -step + k1start; k1 <= step-k1end; k1 += 2 {
+			k1Offset := offset + k1
+			var x1 int
+			if k1 == -step || (k1 != step && v1[k1Offset-1] < v1[k1Offset+1]) {
+				x1 = v1[k1Offset+1]
+			} else {
+				x1 = v1[k1Offset-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && d.equal(aLo+x1, bLo+y1) {
+				x1++
+				y1++
+			}
+			v1[k1Offset] = x1
+
+			if x1 > n {
+				// Ran off the right of the graph
+				k1end += 2
+			} else if y1 > m {
+				// Ran off the bottom of the graph
+				k1start += 2
+			} else if front {
+				k2Offset := offset + delta - k1
+				if k2Offset >= 0 && k2Offset < length && v2[k2Offset] != -1 {
+					// Mirror x2 onto the top-left coordinate system
+					if x2 := n - v2[k2Offset]; x1 >= x2 {
+						return aLo + x1, bLo + y1, true
+					}
+				}
+			}
+		}
+
+		// Walk the reverse path one step
+		for k2 := -step + k2start; k2 <= step-k2end; k2 += 2 {
+			k2Offset := offset + k2
+			var x2 int
+			if k2 == -step || (k2 != step && v2[k2Offset-1] < v2[k2Offset+1]) {
+				x2 = v2[k2Offset+1]
+			} else {
+				x2 = v2[k2Offset-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && d.equal(aHi-x2-1, bHi-y2-1) {
+				x2++
+				y2++
+			}
+			v2[k2Offset] = x2
+
+			if x2 > n {
+				// Ran off the left of the graph
+				k2end += 2
+			} else if y2 > m {
+				// Ran off the top of the graph
+				k2start += 2
+			} else if !front {
+				k1Offset := offset + delta - k2
+				if k1Offset >= 0 && k1Offset < length && v1[k1Offset] != -1 {
+					x1 := v1[k1Offset]
+					y1 := offset + x1 - k1Offset
+					if x1 >= n-x2 {
+						return aLo + x1, bLo + y1, true
+					}
+				}
+			}
+		}
+	}
+
+	// No common element
+	return 0, 0, false
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"ycs/lib0"
+)
+
+// DSEncoderV1 encodes delete sets as version 1 updates do. Yjs stores the
+// deletions of users in PermanentUserData in this encoding.
+type DSEncoderV1 struct {
+	restWriter *bytes.Buffer
+}
+
+// NewDSEncoderV1 creates a new DSEncoderV1
+func NewDSEncoderV1() *DSEncoderV1 {
+	return &DSEncoderV1{restWriter: &bytes.Buffer{}}
+}
+
+// GetRestWriter returns the rest writer
+func (dse *DSEncoderV1) GetRestWriter() io.Writer {
+	return dse.restWriter
+}
+
+// ResetDsCurVal does nothing, version 1 clocks are not delta encoded
+func (dse *DSEncoderV1) ResetDsCurVal() {
+	// Do nothing
+}
+
+// WriteDsClock writes a delete set clock value
+func (dse *DSEncoderV1) WriteDsClock(clock int64) {
+	lib0.WriteVarUint(dse.restWriter, uint32(clock))
+}
+
+// WriteDsLength writes a delete set length value
+func (dse *DSEncoderV1) WriteDsLength(length int64) {
+	lib0.WriteVarUint(dse.restWriter, uint32(length))
+}
+
+// ToArray returns the encoded bytes
+func (dse *DSEncoderV1) ToArray() []byte {
+	return dse.restWriter.Bytes()
+}
+
+// Close closes the encoder
+func (dse *DSEncoderV1) Close() error {
+	return nil
+}
+
+// DSDecoderV1 decodes delete sets encoded by DSEncoderV1.
+// Like the version 2 decoder it panics on malformed input.
+type DSDecoderV1 struct {
+	reader lib0.StreamReader
+}
+
+// NewDSDecoderV1 creates a new DSDecoderV1
+func NewDSDecoderV1(input io.Reader) *DSDecoderV1 {
+	return &DSDecoderV1{reader: toStreamReader(input)}
+}
+
+// GetReader returns the reader
+func (dsd *DSDecoderV1) GetReader() io.Reader {
+	return dsd.reader
+}
+
+// ResetDsCurVal does nothing, version 1 clocks are not delta encoded
+func (dsd *DSDecoderV1) ResetDsCurVal() {
+	// Do nothing
+}
+
+// ReadDsClock reads a delete set clock value
+func (dsd *DSDecoderV1) ReadDsClock() int64 {
+	clock, err := lib0.ReadVarUint(dsd.reader)
+	if err != nil {
+		panic(err)
+	}
+	return int64(clock)
+}
+
+// ReadDsLength reads a delete set length value
+func (dsd *DSDecoderV1) ReadDsLength() int64 {
+	length, err := lib0.ReadVarUint(dsd.reader)
+	if err != nil {
+		panic(err)
+	}
+	return int64(length)
+}
+
+// Close closes the decoder
+func (dsd *DSDecoderV1) Close() error {
+	return nil
+}
@@ -34,6 +34,8 @@ func ReadItemContent(decoder contracts.IUpdateDecoder, info byte) (contracts.ICo
 		return content.ReadContentAny(decoder), nil
 	case 9: // Doc
 		return content.ReadContentDoc(decoder), nil
+	case content.ContentMoveRef:
+		return content.ReadContentMove(decoder)
 	default:
 		return nil, errors.New("content type not recognized")
 	}
@@ -47,6 +49,11 @@ func ReadStructs(decoder contracts.IUpdateDecoder, transaction contracts.ITransa
 		return err
 	}
 
+	// Checked before integrating, which drops the structs the store already has
+	if !transaction.GetLocal() && detectClientIDCollision(transaction.GetDoc(), store, clientStructRefs) {
+		transaction.GetMeta()[clientIDCollisionMeta] = true
+	}
+
 	store.MergeReadStructsIntoPendingReads(clientStructRefs)
 	store.ResumeStructIntegration(transaction)
 	store.CleanupPendingStructs()
@@ -141,7 +148,7 @@ func ReadClientStructRefs(decoder contracts.IUpdateDecoder, doc contracts.IYDoc)
 			return nil, errors.New("invalid number of structs")
 		}
 
-		refs := make([]contracts.IStructItem, 0, numberOfStructs)
+		refs := make([]contracts.IStructItem, 0, min(numberOfStructs, lib0.MaxPreallocation))
 		client := decoder.ReadClient()
 		clockVal, err := lib0.ReadVarUint(decoder.GetReader().(lib0.StreamReader))
 		if err != nil {
@@ -232,9 +239,18 @@ func ReadClientStructRefs(decoder contracts.IUpdateDecoder, doc contracts.IYDoc)
 func WriteStateVector(encoder contracts.IDSEncoder, sv map[int64]int64) error {
 	lib0.WriteVarUint(encoder.GetRestWriter(), uint32(len(sv)))
 
-	for client, clock := range sv {
+	// Clients are written in ascending order so that equal vectors encode equally
+	clients := make([]int64, 0, len(sv))
+	for client := range sv {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i] < clients[j]
+	})
+
+	for _, client := range clients {
 		lib0.WriteVarUint(encoder.GetRestWriter(), uint32(client))
-		lib0.WriteVarUint(encoder.GetRestWriter(), uint32(clock))
+		lib0.WriteVarUint(encoder.GetRestWriter(), uint32(sv[client]))
 	}
 
 	return nil
@@ -247,7 +263,7 @@ func ReadStateVector(decoder contracts.IDSDecoder) (map[int64]int64, error) {
 		return nil, err
 	}
 	ssLength := int(ssLengthVal)
-	ss := make(map[int64]int64, ssLength)
+	ss := make(map[int64]int64, min(ssLength, lib0.MaxPreallocation))
 
 	for i := 0; i < ssLength; i++ {
 		clientVal, err := lib0.ReadVarUint(decoder.GetReader().(lib0.StreamReader))
@@ -290,7 +306,10 @@ func FindIndexSS(structs []contracts.IStructItem, clock int64) int {
 	}
 
 	// Binary search with pivoting
-	midIndex := int(clock * int64(right) / (midClock + int64(mid.GetLength()) - 1))
+	midIndex := 0
+	if pivot := midClock + int64(mid.GetLength()) - 1; pivot > 0 {
+		midIndex = min(int(clock*int64(right)/pivot), right)
+	}
 	for left <= right {
 		mid = structs[midIndex]
 		midClock = mid.GetID().Clock
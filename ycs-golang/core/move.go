@@ -0,0 +1,57 @@
+package core
+
+import (
+	"errors"
+
+	"ycs/contracts"
+)
+
+var (
+	// errNotNested is returned when moving a type that is not nested in a document
+	errNotNested = errors.New("only types nested in a document can be moved")
+	// errMoveIntoItself is returned when moving a type into itself or into a type nested in it
+	errMoveIntoItself = errors.New("cannot move a type into itself")
+)
+
+// MoveToMap moves a nested type under the key of a map. Types cannot be moved in
+// place, so a deep copy is inserted and the original is deleted, in one transaction
+// if both are part of the same document. Changes that other peers make to the
+// original concurrently are lost. Returns the copy.
+func MoveToMap(t contracts.IAbstractType, target *YMap, key string) (contracts.IAbstractType, error) {
+	return moveType(t, target, func(clone contracts.IAbstractType) {
+		target.Set(key, clone)
+	})
+}
+
+// MoveToArray moves a nested type to the index of an array like MoveToMap. The index
+// is counted before the original is deleted.
+func MoveToArray(t contracts.IAbstractType, target *YArray, index int) (contracts.IAbstractType, error) {
+	return moveType(t, target, func(clone contracts.IAbstractType) {
+		target.Insert(index, []interface{}{clone})
+	})
+}
+
+// moveType inserts a deep copy of t with insert and deletes t
+func moveType(t contracts.IAbstractType, target contracts.IAbstractType, insert func(clone contracts.IAbstractType)) (contracts.IAbstractType, error) {
+	item := t.GetItem()
+	if t.GetDoc() == nil || item == nil || item.GetDeleted() {
+		return nil, errNotNested
+	}
+	for p := target; p != nil; p = p.GetParent() {
+		if p == t {
+			return nil, errMoveIntoItself
+		}
+	}
+
+	var clone contracts.IAbstractType
+	transact(t.GetDoc(), func(tr contracts.ITransaction) {
+		clone = t.InternalClone()
+		insert(clone)
+
+		item.Delete(tr)
+		if parent, ok := item.GetParent().(contracts.IYArrayBase); ok {
+			parent.ClearSearchMarkers()
+		}
+	}, nil, true)
+	return clone, nil
+}
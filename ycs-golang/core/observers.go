@@ -0,0 +1,78 @@
+package core
+
+import (
+	"sync"
+)
+
+// observers is a list of handlers of one event. Handlers can be added and
+// removed at any time, also from inside a handler.
+type observers[F any] struct {
+	mutex    sync.Mutex
+	nextID   uint64
+	handlers []observer[F]
+}
+
+type observer[F any] struct {
+	id      uint64
+	handler F
+}
+
+// add registers handler and returns a function that removes it again. Calling
+// the returned function more than once has no effect.
+func (o *observers[F]) add(handler F) func() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.nextID++
+	id := o.nextID
+	o.handlers = append(o.handlers, observer[F]{id: id, handler: handler})
+
+	return func() {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		for i, h := range o.handlers {
+			if h.id == id {
+				// Copy, so that a snapshot being called is not modified
+				o.handlers = append(o.handlers[:i:i], o.handlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// snapshot returns the registered handlers in registration order
+func (o *observers[F]) snapshot() []F {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if len(o.handlers) == 0 {
+		return nil
+	}
+
+	handlers := make([]F, len(o.handlers))
+	for i, h := range o.handlers {
+		handlers[i] = h.handler
+	}
+	return handlers
+}
+
+// take removes all handlers and returns them in registration order
+func (o *observers[F]) take() []F {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	handlers := make([]F, len(o.handlers))
+	for i, h := range o.handlers {
+		handlers[i] = h.handler
+	}
+	o.handlers = nil
+	return handlers
+}
+
+// empty returns whether no handler is registered
+func (o *observers[F]) empty() bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return len(o.handlers) == 0
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"ycs/contracts"
+)
+
+func TestObserversRemoveOneOfSeveral(t *testing.T) {
+	var o observers[func() int]
+	o.add(func() int { return 1 })
+	remove := o.add(func() int { return 2 })
+	o.add(func() int { return 3 })
+
+	call := func() (results []int) {
+		for _, handler := range o.snapshot() {
+			results = append(results, handler())
+		}
+		return results
+	}
+
+	remove()
+	if got := call(); !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Fatalf("got %v after removing the second handler", got)
+	}
+	remove()
+	if got := call(); !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Fatalf("got %v after removing it twice", got)
+	}
+}
+
+func TestObserversRemoveWhileCalling(t *testing.T) {
+	var o observers[func()]
+	var calls []int
+	var removeSecond func()
+	o.add(func() {
+		calls = append(calls, 1)
+		removeSecond()
+	})
+	removeSecond = o.add(func() { calls = append(calls, 2) })
+
+	// The running snapshot still calls the removed handler, later ones do not
+	for _, handler := range o.snapshot() {
+		handler()
+	}
+	for _, handler := range o.snapshot() {
+		handler()
+	}
+	if !reflect.DeepEqual(calls, []int{1, 2, 1}) {
+		t.Fatalf("calls %v", calls)
+	}
+	if o.empty() {
+		t.Fatal("the first handler was removed")
+	}
+}
+
+func TestUnsubscribingOneOfSeveralDocumentHandlers(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	text := doc.GetText("t")
+
+	var updates, events []string
+	subscribe := func(name string) (func(), func()) {
+		return doc.OnUpdateV2(func([]byte, interface{}, contracts.ITransaction) { updates = append(updates, name) }),
+			text.Observe(func(contracts.YEventArgs) { events = append(events, name) })
+	}
+	subscribe("a")
+	unsubscribeUpdates, unobserve := subscribe("b")
+	subscribe("c")
+
+	text.Insert(0, "x")
+	unsubscribeUpdates()
+	unobserve()
+	text.Insert(0, "y")
+
+	if want := []string{"a", "b", "c", "a", "c"}; !reflect.DeepEqual(updates, want) {
+		t.Errorf("update handlers called %v, want %v", updates, want)
+	}
+	if want := []string{"a", "b", "c", "a", "c"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("observers called %v, want %v", events, want)
+	}
+}
@@ -0,0 +1,305 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"ycs/contracts"
+)
+
+// PermanentUserData maps client IDs and deletions to the users that made them. The
+// mapping is kept in a map of the document, by default "users", so that every peer
+// can attribute the content: each user is a map holding the array "ids" of its client
+// IDs and the array "ds" of its deletions, encoded as version 1 delete sets. The
+// layout is the one of PermanentUserData in Yjs.
+//
+// PermanentUserData implements contracts.IUserAttribution, for YText.Blame, and
+// ComputeYChange attributes the changes rendered by YText.ToDelta.
+type PermanentUserData struct {
+	doc   *YDoc
+	users contracts.IYMap
+
+	clients map[int64]string
+	dss     map[string]*DeleteSet
+	// deleters are the users in dss, sorted
+	deleters []string
+	// writes are the changes to the users that handlers queued, see flush
+	writes []func()
+	mutex  sync.RWMutex
+}
+
+// NewPermanentUserData creates a PermanentUserData reading and writing the users
+// in storeType, which defaults to the map "users" of doc
+func NewPermanentUserData(doc *YDoc, storeType ...contracts.IYMap) *PermanentUserData {
+	pud := &PermanentUserData{
+		doc:     doc,
+		clients: make(map[int64]string),
+		dss:     make(map[string]*DeleteSet),
+	}
+	if len(storeType) > 0 && storeType[0] != nil {
+		pud.users = storeType[0]
+	} else {
+		pud.users = doc.GetMap("users")
+	}
+
+	pud.users.Observe(func(args contracts.YEventArgs) {
+		for key := range args.Event.(*YMapEvent).KeysChanged {
+			if user, ok := pud.users.Get(key).(contracts.IYMap); ok {
+				pud.initUser(user, key)
+			}
+		}
+	})
+	for key, value := range pud.users.GetEnumerator() {
+		if user, ok := value.(contracts.IYMap); ok {
+			pud.initUser(user, key)
+		}
+	}
+	doc.OnAfterAllTransactions(func(transactions []contracts.ITransaction) {
+		pud.flush()
+	})
+	return pud
+}
+
+// queueWrite queues a change to the users. Handlers of transactions do not change
+// the document themselves; their changes are made by flush once the transactions
+// are done.
+func (pud *PermanentUserData) queueWrite(write func()) {
+	pud.mutex.Lock()
+	defer pud.mutex.Unlock()
+	pud.writes = append(pud.writes, write)
+}
+
+// flush makes the queued changes to the users in a new transaction
+func (pud *PermanentUserData) flush() {
+	pud.mutex.Lock()
+	writes := pud.writes
+	pud.writes = nil
+	pud.mutex.Unlock()
+
+	if len(writes) == 0 {
+		return
+	}
+	transact(pud.doc, func(tr contracts.ITransaction) {
+		for _, write := range writes {
+			write()
+		}
+	}, nil)
+}
+
+// initUser reads the client IDs and deletions of a user and keeps reading those added later
+func (pud *PermanentUserData) initUser(user contracts.IYMap, description string) {
+	ids, idsOk := user.Get("ids").(contracts.IYArray)
+	ds, dsOk := user.Get("ds").(contracts.IYArray)
+	if !idsOk || !dsOk {
+		log.Printf("Ignoring user %q without ids and ds", description)
+		return
+	}
+
+	ds.Observe(func(args contracts.YEventArgs) {
+		for item := range args.Event.GetChanges().Added {
+			pud.addDeleteSets(description, item.GetContent().GetContent())
+		}
+	})
+	pud.addDeleteSets(description, ds.ToArray())
+
+	ids.Observe(func(args contracts.YEventArgs) {
+		for item := range args.Event.GetChanges().Added {
+			pud.addClientIDs(description, item.GetContent().GetContent())
+		}
+	})
+	pud.addClientIDs(description, ids.ToArray())
+}
+
+// addClientIDs attributes the client IDs among values to the user
+func (pud *PermanentUserData) addClientIDs(description string, values []interface{}) {
+	pud.mutex.Lock()
+	defer pud.mutex.Unlock()
+
+	for _, value := range values {
+		switch id := value.(type) {
+		case int64:
+			pud.clients[id] = description
+		case int:
+			pud.clients[int64(id)] = description
+		case float64:
+			// Client IDs above 2^31 are encoded as floats by Yjs
+			pud.clients[int64(id)] = description
+		}
+	}
+}
+
+// addDeleteSets attributes the encoded delete sets among values to the user
+func (pud *PermanentUserData) addDeleteSets(description string, values []interface{}) {
+	dss := make([]contracts.IDeleteSet, 0, len(values)+1)
+	for _, value := range values {
+		encoded, ok := value.([]byte)
+		if !ok {
+			continue
+		}
+		ds, err := decodeUserDeleteSet(encoded)
+		if err != nil {
+			log.Printf("Ignoring deletions of user %q: %v", description, err)
+			continue
+		}
+		dss = append(dss, ds)
+	}
+	if len(dss) == 0 {
+		return
+	}
+
+	pud.mutex.Lock()
+	defer pud.mutex.Unlock()
+
+	if existing, ok := pud.dss[description]; ok {
+		dss = append([]contracts.IDeleteSet{existing}, dss...)
+	} else {
+		i := sort.SearchStrings(pud.deleters, description)
+		pud.deleters = append(pud.deleters, "")
+		copy(pud.deleters[i+1:], pud.deleters[i:])
+		pud.deleters[i] = description
+	}
+	pud.dss[description] = NewDeleteSetFromDeleteSets(dss)
+}
+
+// decodeUserDeleteSet decodes a delete set stored by a peer
+func decodeUserDeleteSet(encoded []byte) (ds *DeleteSet, err error) {
+	defer func() {
+		// The deletions come from the network, malformed ones must not take the process down
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("malformed delete set: %v", rec)
+		}
+	}()
+	return ReadDeleteSet(NewDSDecoderV1(bytes.NewReader(encoded)))
+}
+
+// encodeUserDeleteSet encodes a delete set as it is stored for users
+func encodeUserDeleteSet(ds contracts.IDeleteSet) []byte {
+	encoder := NewDSEncoderV1()
+	ds.Write(encoder)
+	return encoder.ToArray()
+}
+
+// SetUserMapping attributes the changes of clientID to the user: the client ID is
+// added to the user, and the deletions of every later local transaction of doc for
+// which filter returns true (every transaction without a filter). The deletions are
+// added in a transaction of their own after the transactions of doc. Returns a
+// function that stops recording the deletions.
+func (pud *PermanentUserData) SetUserMapping(doc *YDoc, clientID int64, description string, filter ...func(transaction contracts.ITransaction, ds contracts.IDeleteSet) bool) func() {
+	var user contracts.IYMap
+	transact(pud.doc, func(tr contracts.ITransaction) {
+		var ok bool
+		if user, ok = pud.users.Get(description).(contracts.IYMap); !ok {
+			user = NewYMap(map[string]interface{}{
+				"ids": NewYArray(nil),
+				"ds":  NewYArray(nil),
+			})
+			pud.users.Set(description, user)
+		}
+		user.Get("ids").(contracts.IYArray).Add([]interface{}{clientID})
+	}, nil)
+
+	// A peer may overwrite the user concurrently; the mapping is copied to the user that wins
+	unobserve := pud.users.Observe(func(args contracts.YEventArgs) {
+		if _, changed := args.Event.(*YMapEvent).KeysChanged[description]; !changed {
+			return
+		}
+		overwrite, ok := pud.users.Get(description).(contracts.IYMap)
+		if !ok || overwrite == user {
+			return
+		}
+		user = overwrite
+		pud.queueWrite(func() {
+			pud.copyUser(overwrite, description)
+		})
+	})
+
+	unsubscribe := doc.OnAfterTransaction(func(transaction contracts.ITransaction) {
+		ds := transaction.GetDeleteSet()
+		if !transaction.GetLocal() || len(ds.GetClients()) == 0 {
+			return
+		}
+		if len(filter) > 0 && filter[0] != nil && !filter[0](transaction, ds) {
+			return
+		}
+		encoded := encodeUserDeleteSet(ds)
+		target := user
+		pud.queueWrite(func() {
+			if yds, ok := target.Get("ds").(contracts.IYArray); ok {
+				yds.Add([]interface{}{encoded})
+			}
+		})
+	})
+
+	// The deletions of another document are added once its transactions are done
+	unflush := func() {}
+	if doc != pud.doc {
+		unflush = doc.OnAfterAllTransactions(func(transactions []contracts.ITransaction) {
+			pud.flush()
+		})
+	}
+
+	return func() {
+		unobserve()
+		unsubscribe()
+		unflush()
+	}
+}
+
+// copyUser adds the client IDs and deletions known of the user to user, which
+// overwrote the map of the user that they were read from
+func (pud *PermanentUserData) copyUser(user contracts.IYMap, description string) {
+	pud.mutex.RLock()
+	var clients []interface{}
+	for client, userDescription := range pud.clients {
+		if userDescription == description {
+			clients = append(clients, client)
+		}
+	}
+	ds := pud.dss[description]
+	pud.mutex.RUnlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].(int64) < clients[j].(int64)
+	})
+	if ids, ok := user.Get("ids").(contracts.IYArray); ok && len(clients) > 0 {
+		ids.Add(clients)
+	}
+	if yds, ok := user.Get("ds").(contracts.IYArray); ok && ds != nil {
+		yds.Add([]interface{}{encodeUserDeleteSet(ds)})
+	}
+}
+
+// GetUserByClientID returns the user of a client ID, or "" if it is unknown
+func (pud *PermanentUserData) GetUserByClientID(clientID int64) string {
+	pud.mutex.RLock()
+	defer pud.mutex.RUnlock()
+	return pud.clients[clientID]
+}
+
+// GetUserByDeletedID returns the user that deleted the struct with the ID, or "" if
+// it is unknown
+func (pud *PermanentUserData) GetUserByDeletedID(id contracts.StructID) string {
+	pud.mutex.RLock()
+	defer pud.mutex.RUnlock()
+
+	// Several users may have deleted the struct concurrently; the first one in order wins
+	for _, description := range pud.deleters {
+		if pud.dss[description].IsDeleted(id) {
+			return description
+		}
+	}
+	return ""
+}
+
+// ComputeYChange attributes a change to its user, for ToDelta of YText: added content
+// is attributed to the user of its client, removed content to the user that deleted it
+func (pud *PermanentUserData) ComputeYChange(changeType contracts.YTextChangeType, id contracts.StructID, attrs contracts.YTextChangeAttributes) interface{} {
+	if changeType == contracts.YTextChangeTypeAdded {
+		attrs.Author = pud.GetUserByClientID(id.Client)
+	} else {
+		attrs.Author = pud.GetUserByDeletedID(id)
+	}
+	return attrs
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"ycs/contracts"
+)
+
+// newUserDoc creates a document that keeps its history, with the changes of its
+// client attributed to user
+func newUserDoc(clientID int, user string) (*YDoc, *PermanentUserData) {
+	doc := NewYDoc(contracts.YDocOptions{Gc: false})
+	doc.SetClientID(clientID)
+	pud := NewPermanentUserData(doc)
+	pud.SetUserMapping(doc, int64(clientID), user)
+	return doc, pud
+}
+
+// textID returns the ID of the character at index of the text, including deleted ones
+func textID(text contracts.IYText, index int) contracts.StructID {
+	for n := text.(*YText).GetStart(); n != nil; n = n.GetRight() {
+		if index < n.GetLength() {
+			id := n.GetID()
+			return contracts.StructID{Client: id.Client, Clock: id.Clock + int64(index)}
+		}
+		index -= n.GetLength()
+	}
+	panic("index out of range")
+}
+
+func TestYTextBlame(t *testing.T) {
+	alice, alicePud := newUserDoc(1, "alice")
+	bob, _ := newUserDoc(2, "bob")
+
+	alice.GetText("t").Insert(0, "hello world")
+	syncDocs(t, alice, bob)
+	bob.GetText("t").Insert(5, ",")
+	bob.GetText("t").Insert(12, "!")
+	syncDocs(t, alice, bob)
+
+	want := []contracts.TextBlame{
+		{Index: 0, Length: 5, Client: 1, Author: "alice"},
+		{Index: 5, Length: 1, Client: 2, Author: "bob"},
+		{Index: 6, Length: 6, Client: 1, Author: "alice"},
+		{Index: 12, Length: 1, Client: 2, Author: "bob"},
+	}
+	if got := alice.GetText("t").Blame(alicePud); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	// Without users, only the clients are known
+	for i := range want {
+		want[i].Author = ""
+	}
+	if got := bob.GetText("t").Blame(nil); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestPermanentUserDataAttributesDeletions(t *testing.T) {
+	alice, _ := newUserDoc(1, "alice")
+	bob, bobPud := newUserDoc(2, "bob")
+
+	alice.GetText("t").Insert(0, "abc")
+	syncDocs(t, alice, bob)
+	before := bob.CreateSnapshot()
+	bob.GetText("t").Delete(1, 1)
+	syncDocs(t, alice, bob)
+
+	// The deletion is known to the peer that synced it
+	alicePud := NewPermanentUserData(alice)
+	deleted := textID(alice.GetText("t"), 1)
+	for _, pud := range []*PermanentUserData{alicePud, bobPud} {
+		if got := pud.GetUserByDeletedID(deleted); got != "bob" {
+			t.Fatalf("got deleter %q, want bob", got)
+		}
+		if got := pud.GetUserByDeletedID(textID(alice.GetText("t"), 0)); got != "" {
+			t.Fatalf("got deleter %q of a struct that is not deleted", got)
+		}
+		if got := pud.GetUserByClientID(1); got != "alice" {
+			t.Fatalf("got user %q, want alice", got)
+		}
+	}
+
+	delta := bob.GetText("t").ToDelta(bob.CreateSnapshot(), before, bobPud.ComputeYChange)
+	if len(delta) != 3 {
+		t.Fatalf("got %+v", delta)
+	}
+	change, ok := delta[1].Attributes[YTextChangeKey].(contracts.YTextChangeAttributes)
+	if !ok || change.Type != contracts.YTextChangeTypeRemoved || change.Author != "bob" {
+		t.Fatalf("got %+v", delta[1])
+	}
+}
+
+func TestPermanentUserDataRecordsDeletionsAfterTransaction(t *testing.T) {
+	doc, pud := newUserDoc(1, "alice")
+	doc.GetText("t").Insert(0, "abc")
+
+	// The deletion is added to the user in a transaction of its own
+	var transactions []bool
+	doc.OnAfterTransaction(func(tr contracts.ITransaction) {
+		transactions = append(transactions, len(tr.GetDeleteSet().GetClients()) > 0)
+	})
+	doc.Transact(func(tr contracts.ITransaction) {
+		doc.GetText("t").Delete(0, 1)
+	}, nil)
+	if want := []bool{true, false}; !reflect.DeepEqual(transactions, want) {
+		t.Fatalf("got transactions deleting %v, want %v", transactions, want)
+	}
+
+	ds := doc.GetMap("users").Get("alice").(contracts.IYMap).Get("ds").(contracts.IYArray)
+	if ds.GetLength() != 1 {
+		t.Fatalf("got %d deletions", ds.GetLength())
+	}
+	if got := pud.GetUserByDeletedID(textID(doc.GetText("t"), 0)); got != "alice" {
+		t.Fatalf("got deleter %q, want alice", got)
+	}
+
+	// Deletions that the filter rejects are not recorded
+	other := NewYDoc(contracts.YDocOptions{Gc: false})
+	otherPud := NewPermanentUserData(other)
+	stop := otherPud.SetUserMapping(other, int64(other.GetClientID()), "bob", func(tr contracts.ITransaction, ds contracts.IDeleteSet) bool {
+		return tr.GetOrigin() != "ignored"
+	})
+	other.GetText("t").Insert(0, "abc")
+	other.Transact(func(tr contracts.ITransaction) {
+		other.GetText("t").Delete(0, 1)
+	}, "ignored")
+	stop()
+	other.GetText("t").Delete(0, 1)
+	if n := other.GetMap("users").Get("bob").(contracts.IYMap).Get("ds").(contracts.IYArray).GetLength(); n != 0 {
+		t.Fatalf("got %d deletions", n)
+	}
+}
+
+func TestPermanentUserDataConcurrentDeleters(t *testing.T) {
+	alice, _ := newUserDoc(1, "alice")
+	bob, _ := newUserDoc(2, "bob")
+	carol, carolPud := newUserDoc(3, "carol")
+
+	carol.GetText("t").Insert(0, "abc")
+	syncDocs(t, alice, bob, carol)
+	bob.GetText("t").Delete(0, 1)
+	alice.GetText("t").Delete(0, 1)
+	syncDocs(t, alice, bob, carol)
+
+	// Of the users that deleted the struct concurrently, the first in order wins
+	if got := carolPud.GetUserByDeletedID(textID(carol.GetText("t"), 0)); got != "alice" {
+		t.Fatalf("got deleter %q, want alice", got)
+	}
+}
+
+func TestPermanentUserDataConcurrentMappings(t *testing.T) {
+	first, firstPud := newUserDoc(1, "ann")
+	second, secondPud := newUserDoc(2, "ann")
+
+	// Both peers created the user; the mapping of the one that loses is copied over
+	syncDocs(t, first, second)
+	syncDocs(t, first, second)
+	for _, pud := range []*PermanentUserData{firstPud, secondPud} {
+		if pud.GetUserByClientID(1) != "ann" || pud.GetUserByClientID(2) != "ann" {
+			t.Fatalf("got users %q and %q", pud.GetUserByClientID(1), pud.GetUserByClientID(2))
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"ycs/contracts"
+)
+
+// nestedPrelim returns a preliminary map holding an array, a formatted text and a map
+func nestedPrelim() *YMap {
+	text := NewYText("")
+	text.Insert(0, "hello world")
+	text.Format(0, 5, map[string]interface{}{"bold": true})
+
+	return NewYMap(map[string]interface{}{
+		"list": NewYArray([]interface{}{int64(1), NewYMap(map[string]interface{}{"k": "v"})}),
+		"text": text,
+		"n":    int64(2),
+	})
+}
+
+var nestedJSON = map[string]interface{}{
+	"list": []interface{}{int64(1), map[string]interface{}{"k": "v"}},
+	"text": []interface{}{
+		map[string]interface{}{"insert": "hello", "attributes": map[string]interface{}{"bold": true}},
+		map[string]interface{}{"insert": " world"},
+	},
+	"n": int64(2),
+}
+
+func TestIntegratesNestedPrelimTypes(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	doc.GetMap("m").Set("nested", nestedPrelim())
+
+	nested := doc.GetMap("m").Get("nested").(*YMap)
+	if got := nested.ToJSON(true); !reflect.DeepEqual(got, nestedJSON) {
+		t.Fatalf("got %#v", got)
+	}
+	if nested.Get("list").(*YArray).Get(1).(*YMap).GetDoc() != contracts.IYDoc(doc) {
+		t.Fatal("the innermost map is not integrated")
+	}
+
+	replica := replicate(t, doc)
+	if got := replica.GetMap("m").Get("nested").(*YMap).ToJSON(true); !reflect.DeepEqual(got, nestedJSON) {
+		t.Fatalf("replica has %#v", got)
+	}
+}
+
+func TestPrelimTypesAreReadable(t *testing.T) {
+	m := nestedPrelim()
+	if m.GetCount() != 3 || !m.ContainsKey("list") || m.Get("n") != int64(2) {
+		t.Fatalf("preliminary map %v", m.Keys())
+	}
+	m.Delete("n")
+	if m.ContainsKey("n") {
+		t.Fatal("deleted key is still there")
+	}
+
+	list := NewYArray([]interface{}{int64(1)})
+	list.Insert(1, []interface{}{int64(2)})
+	if list.GetLength() != 2 {
+		t.Fatalf("preliminary array has %d elements", list.GetLength())
+	}
+}
+
+func TestCloneIsDeep(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	m := doc.GetMap("m")
+	m.Set("nested", nestedPrelim())
+	original := m.Get("nested").(*YMap)
+
+	m.Set("clone", original.Clone())
+	clone := m.Get("clone").(*YMap)
+	if got := clone.ToJSON(true); !reflect.DeepEqual(got, nestedJSON) {
+		t.Fatalf("clone has %#v", got)
+	}
+
+	// Nested types are copies, not shared with the original
+	original.Get("list").(*YArray).Get(1).(*YMap).Set("k", "changed")
+	original.Get("text").(*YText).Insert(0, ">")
+	if got := clone.ToJSON(true); !reflect.DeepEqual(got, nestedJSON) {
+		t.Fatalf("changing the original changed the clone: %#v", got)
+	}
+	clone.Get("list").(*YArray).Delete(0)
+	if original.Get("list").(*YArray).GetLength() != 2 {
+		t.Fatal("changing the clone changed the original")
+	}
+}
+
+func TestClonePrelimTypes(t *testing.T) {
+	for name, prelim := range map[string]contracts.IAbstractType{
+		"map":   nestedPrelim(),
+		"array": NewYArray([]interface{}{nestedPrelim(), "x"}),
+		"text":  nestedPrelim().Get("text").(*YText),
+	} {
+		t.Run(name, func(t *testing.T) {
+			doc := NewYDoc(contracts.YDocOptions{})
+			array := doc.GetArray("a")
+			array.Insert(0, []interface{}{prelim.InternalClone(), prelim})
+
+			first, second := array.Get(0).(contracts.IAbstractType), array.Get(1).(contracts.IAbstractType)
+			if first == second || !reflect.DeepEqual(toJSON(first), toJSON(second)) {
+				t.Fatalf("clone %#v, original %#v", toJSON(first), toJSON(second))
+			}
+		})
+	}
+}
+
+// toJSON returns the JSON value of a type, with texts as deltas
+func toJSON(at contracts.IAbstractType) interface{} {
+	switch v := at.(type) {
+	case *YMap:
+		return v.ToJSON(true)
+	case *YArray:
+		return v.ToJSON(true)
+	case *YText:
+		return v.ToDelta(nil, nil, nil)
+	}
+	return nil
+}
+
+func TestMoveToMapCopiesAndDeletes(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	m := doc.GetMap("m").(*YMap)
+	m.Set("from", nestedPrelim())
+	replica := replicate(t, doc)
+
+	updates := 0
+	doc.OnUpdateV2(func(update []byte, origin interface{}, tr contracts.ITransaction) {
+		updates++
+		if err := replica.ApplyUpdateV2(update, nil, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	moved, err := MoveToMap(m.Get("from").(*YMap), m, "to")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updates != 1 {
+		t.Fatalf("the move took %d transactions", updates)
+	}
+	if m.ContainsKey("from") || m.Get("to") != moved || !reflect.DeepEqual(toJSON(moved), nestedJSON) {
+		t.Fatalf("map has %v", m.Keys())
+	}
+	replicaMap := replica.GetMap("m")
+	if replicaMap.ContainsKey("from") || !reflect.DeepEqual(toJSON(replicaMap.Get("to").(contracts.IAbstractType)), nestedJSON) {
+		t.Fatalf("replica has %v", replicaMap.Keys())
+	}
+}
+
+func TestMoveToArray(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	array := doc.GetArray("a").(*YArray)
+	array.Insert(0, []interface{}{"a", NewYText("moved"), "b"})
+
+	// The index counts the original, which is deleted afterwards
+	moved, err := MoveToArray(array.Get(1).(*YText), array, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if array.GetLength() != 3 || array.Get(2) != moved || array.Get(0) != "a" || array.Get(1) != "b" {
+		t.Fatalf("array has %v", array.ToArray())
+	}
+	if got := moved.(*YText).ToString(); got != "moved" {
+		t.Fatalf("moved text has %q", got)
+	}
+}
+
+func TestMoveRejectsInvalidMoves(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	m := doc.GetMap("m").(*YMap)
+	m.Set("outer", NewYMap(map[string]interface{}{"inner": NewYMap(nil)}))
+	outer := m.Get("outer").(*YMap)
+	inner := outer.Get("inner").(*YMap)
+
+	if _, err := MoveToMap(outer, inner, "x"); !errors.Is(err, errMoveIntoItself) {
+		t.Errorf("moving into a nested type: got %v", err)
+	}
+	if _, err := MoveToMap(outer, outer, "x"); !errors.Is(err, errMoveIntoItself) {
+		t.Errorf("moving into itself: got %v", err)
+	}
+	if _, err := MoveToMap(m, outer, "x"); !errors.Is(err, errNotNested) {
+		t.Errorf("moving a root type: got %v", err)
+	}
+	if _, err := MoveToMap(NewYMap(nil), m, "x"); !errors.Is(err, errNotNested) {
+		t.Errorf("moving a preliminary type: got %v", err)
+	}
+	m.Delete("outer")
+	if _, err := MoveToMap(outer, m, "x"); !errors.Is(err, errNotNested) {
+		t.Errorf("moving a deleted type: got %v", err)
+	}
+}
@@ -4,25 +4,9 @@ import (
 	"ycs/contracts"
 )
 
-// RelativePosition represents a relative position in the document
-// A relative position is based on the Y.js model and is not affected by document changes.
-// E.g. if you place a relative position before a certain character, it will always point to this character.
-// If you place a relative position at the end of a type, it will always point to the end of the type.
-type RelativePosition struct {
-	Item   *contracts.StructID
-	TypeId *contracts.StructID
-	TName  string
-	// A relative position is associated to a specific character.
-	// By default, the value is >= 0, the relative position is associated to the character
-	// after the meant position.
-	// If the value is < 0, then the relative position is associated with the character
-	// before the meant position.
-	Assoc int
-}
-
 // NewRelativePosition creates a new RelativePosition
-func NewRelativePosition(typ contracts.IAbstractType, item *contracts.StructID, assoc int) *RelativePosition {
-	rpos := &RelativePosition{
+func NewRelativePosition(typ contracts.IAbstractType, item *contracts.StructID, assoc int) *contracts.RelativePosition {
+	rpos := &contracts.RelativePosition{
 		Item:  item,
 		Assoc: assoc,
 	}
@@ -41,8 +25,8 @@ func NewRelativePosition(typ contracts.IAbstractType, item *contracts.StructID,
 }
 
 // NewRelativePositionFromComponents creates a new RelativePosition from components
-func NewRelativePositionFromComponents(typeId *contracts.StructID, tname string, item *contracts.StructID, assoc int) *RelativePosition {
-	return &RelativePosition{
+func NewRelativePositionFromComponents(typeId *contracts.StructID, tname string, item *contracts.StructID, assoc int) *contracts.RelativePosition {
+	return &contracts.RelativePosition{
 		TypeId: typeId,
 		TName:  tname,
 		Item:   item,
@@ -50,18 +34,28 @@ func NewRelativePositionFromComponents(typeId *contracts.StructID, tname string,
 	}
 }
 
-// Equals compares two RelativePosition instances
-func (rp *RelativePosition) Equals(other *RelativePosition) bool {
-	if rp == other {
-		return true
+// NewRelativePositionFromTypeIndex creates a RelativePosition at index of typ.
+// With assoc < 0 the position is associated to the element before index.
+func NewRelativePositionFromTypeIndex(typ contracts.IAbstractType, index int, assoc int) *contracts.RelativePosition {
+	if assoc < 0 {
+		if index == 0 {
+			return NewRelativePosition(typ, nil, assoc)
+		}
+		index--
 	}
 
-	if other == nil {
-		return false
+	for t := typ.GetStart(); t != nil; t = t.GetRight() {
+		if !t.GetDeleted() && t.GetCountable() {
+			if t.GetLength() > index {
+				id := contracts.StructID{Client: t.GetID().Client, Clock: t.GetID().Clock + int64(index)}
+				return NewRelativePosition(typ, &id, assoc)
+			}
+			index -= t.GetLength()
+		}
+		if t.GetRight() == nil && assoc < 0 {
+			id := t.GetLastID()
+			return NewRelativePosition(typ, &id, assoc)
+		}
 	}
-
-	return rp.TName == other.TName &&
-		contracts.EqualsPtr(rp.Item, other.Item) &&
-		contracts.EqualsPtr(rp.TypeId, other.TypeId) &&
-		rp.Assoc == other.Assoc
+	return NewRelativePosition(typ, nil, assoc)
 }
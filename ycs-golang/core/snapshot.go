@@ -1,6 +1,9 @@
 package core
 
 import (
+	"io"
+	"sort"
+	"ycs/content"
 	"ycs/contracts"
 	"ycs/lib0"
 )
@@ -21,6 +24,10 @@ func NewSnapshot(ds contracts.IDeleteSet, stateMap map[int64]int64) *Snapshot {
 
 // RestoreDocument restores a document from this snapshot
 func (s *Snapshot) RestoreDocument(originDoc contracts.IYDoc, opts *contracts.YDocOptions) contracts.IYDoc {
+	if opts == nil {
+		opts = originDoc.CloneOptionsWithNewGuid()
+	}
+
 	if originDoc.GetGc() {
 		// We should try to restore a GC-ed document, because some of the restored items might have their content deleted.
 		panic("originDoc must not be garbage collected")
@@ -29,7 +36,7 @@ func (s *Snapshot) RestoreDocument(originDoc contracts.IYDoc, opts *contracts.YD
 	encoder := NewUpdateEncoderV2()
 	defer encoder.Close()
 
-	originDoc.Transact(func(tr contracts.ITransaction) {
+	transact(originDoc, func(tr contracts.ITransaction) {
 		// Count non-zero states
 		size := 0
 		for _, clock := range s.StateVector {
@@ -40,7 +47,8 @@ func (s *Snapshot) RestoreDocument(originDoc contracts.IYDoc, opts *contracts.YD
 		lib0.WriteVarUint(encoder.restWriter, uint32(size))
 
 		// Splitting the structs before writing them to the encoder.
-		for client, clock := range s.StateVector {
+		for _, client := range sortedClients(s.StateVector) {
+			clock := s.StateVector[client]
 			if clock == 0 {
 				continue
 			}
@@ -73,6 +81,143 @@ func (s *Snapshot) RestoreDocument(originDoc contracts.IYDoc, opts *contracts.YD
 	return newDoc
 }
 
+// RevertDocument changes the content of doc back to the content it had at this
+// snapshot. Unlike RestoreDocument, the document is changed in place by a new
+// transaction with origin that syncs like any other change: content added since the
+// snapshot is deleted, and copies of content deleted since are inserted where it was.
+// Types created since the snapshot are emptied, as they cannot be removed.
+func (s *Snapshot) RevertDocument(doc contracts.IYDoc, origin interface{}) {
+	if doc.GetGc() {
+		// Deleted content would have to be restored from garbage collected items.
+		panic("originDoc must not be garbage collected")
+	}
+
+	transact(doc, func(tr contracts.ITransaction) {
+		SplitSnapshotAffectedStructs(tr, s)
+		r := &snapshotRevert{snapshot: s, transaction: tr, copies: make(map[contracts.StructID]contracts.StructID)}
+		share := doc.GetShare()
+		for _, name := range sortedKeys(share) {
+			r.revertType(share[name])
+		}
+	}, origin)
+}
+
+// snapshotRevert holds the state of a document revert
+type snapshotRevert struct {
+	snapshot    *Snapshot
+	transaction contracts.ITransaction
+	// copies maps the elements of restored items to the elements they copy
+	copies map[contracts.StructID]contracts.StructID
+}
+
+// revertType reverts the content of a type that exists now and at the snapshot
+func (r *snapshotRevert) revertType(t contracts.IAbstractType) {
+	for n := t.GetStart(); n != nil; n = n.GetRight() {
+		visibleNow, visibleThen := !n.GetDeleted(), n.IsVisible(r.snapshot)
+		switch {
+		case visibleNow && !visibleThen:
+			n.Delete(r.transaction)
+		case !visibleNow && visibleThen:
+			// The copy goes right after the original, so the loop skips it
+			if restored := r.restoreItem(t, n, nil, n); restored != nil {
+				n = restored
+			}
+		case visibleNow:
+			if c, ok := n.GetContent().(*content.ContentType); ok {
+				r.revertType(c.GetType())
+			}
+		}
+	}
+	r.reorder(t, t)
+
+	m := t.GetMap()
+	for _, key := range sortedKeys(m) {
+		now := m[key]
+		then := now
+		for then != nil && !then.IsVisible(r.snapshot) {
+			then = then.GetLeft()
+		}
+
+		switch {
+		case then == now && !now.GetDeleted():
+			if c, ok := now.GetContent().(*content.ContentType); ok {
+				r.revertType(c.GetType())
+			}
+		case then != nil:
+			r.restoreItem(t, now, &key, then)
+		case !now.GetDeleted():
+			now.Delete(r.transaction)
+		}
+	}
+}
+
+// restoreItem inserts a copy of the content that original had at the snapshot into
+// parent after left. Returns nil if nothing was inserted: garbage collected content
+// cannot be copied, and moves are restored by reorder.
+func (r *snapshotRevert) restoreItem(parent contracts.IAbstractType, left contracts.IStructItem, parentSub *string, original contracts.IStructItem) contracts.IStructItem {
+	switch original.GetContent().(type) {
+	case *content.ContentDeleted, *content.ContentMove:
+		return nil
+	}
+
+	copied := original.GetContent().Copy().(contracts.IContentEx)
+	item := insertItemAfter(r.transaction, parent, left, parentSub, copied)
+	id, originalID := item.GetID(), original.GetID()
+	for i := 0; i < item.GetLength(); i++ {
+		r.copies[contracts.StructID{Client: id.Client, Clock: id.Clock + int64(i)}] = contracts.StructID{Client: originalID.Client, Clock: originalID.Clock + int64(i)}
+	}
+
+	if c, ok := original.GetContent().(*content.ContentType); ok {
+		r.fillType(copied.(*content.ContentType).GetType(), c.GetType())
+	}
+	return item
+}
+
+// fillType inserts copies of the content source had at the snapshot into target
+func (r *snapshotRevert) fillType(target, source contracts.IAbstractType) {
+	var left contracts.IStructItem
+	for n := source.GetStart(); n != nil; n = n.GetRight() {
+		if n.IsVisible(r.snapshot) {
+			if item := r.restoreItem(target, left, nil, n); item != nil {
+				left = item
+			}
+		}
+	}
+	r.reorder(target, source)
+
+	m := source.GetMap()
+	for _, key := range sortedKeys(m) {
+		then := m[key]
+		for then != nil && !then.IsVisible(r.snapshot) {
+			then = then.GetLeft()
+		}
+		if then != nil {
+			r.restoreItem(target, target.GetMap()[key], &key, then)
+		}
+	}
+}
+
+// reorder moves the elements of target into the order the elements they copy had
+// in source at the snapshot. Restored elements are rendered at their own position,
+// because moves cannot claim copies.
+func (r *snapshotRevert) reorder(target, source contracts.IAbstractType) {
+	list, ok := target.(*YArray)
+	sourceList, sourceOk := source.(*YArray)
+	if !ok || !sourceOk || !sourceList.hasMoves {
+		return
+	}
+
+	want := segmentIDs(sourceList.renderList(func(item contracts.IStructItem) bool {
+		return item.IsVisible(r.snapshot)
+	}))
+	list.reorderElements(r.transaction, want, func(id contracts.StructID) contracts.StructID {
+		if original, ok := r.copies[id]; ok {
+			return original
+		}
+		return id
+	})
+}
+
 // Equals compares two snapshots for equality
 func (s *Snapshot) Equals(other *Snapshot) bool {
 	if other == nil {
@@ -120,8 +265,44 @@ func (s *Snapshot) GetStateVector() map[int64]int64 {
 	return s.StateVector
 }
 
-// EncodeSnapshotV2 encodes the snapshot as bytes
+// EncodeSnapshotV2 encodes the delete set and the state vector of the snapshot
 func (s *Snapshot) EncodeSnapshotV2() []byte {
-	// TODO: Implement proper snapshot encoding
-	return []byte{}
+	encoder := NewDSEncoderV2()
+	defer encoder.Close()
+
+	if err := s.DeleteSet.Write(encoder); err != nil {
+		panic(err)
+	}
+	if err := WriteStateVector(encoder, s.StateVector); err != nil {
+		panic(err)
+	}
+	return encoder.ToArray()
+}
+
+// DecodeSnapshot decodes a snapshot encoded by EncodeSnapshotV2
+func DecodeSnapshot(input io.Reader) (*Snapshot, error) {
+	decoder := NewDSDecoderV2(input)
+	defer decoder.Close()
+
+	ds, err := ReadDeleteSet(decoder)
+	if err != nil {
+		return nil, err
+	}
+	sv, err := ReadStateVector(decoder)
+	if err != nil {
+		return nil, err
+	}
+	return NewSnapshot(ds, sv), nil
+}
+
+// sortedClients returns the clients of a state vector in ascending order
+func sortedClients(sv map[int64]int64) []int64 {
+	clients := make([]int64, 0, len(sv))
+	for client := range sv {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i] < clients[j]
+	})
+	return clients
 }
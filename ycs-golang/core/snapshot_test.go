@@ -0,0 +1,150 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"ycs/contracts"
+)
+
+func TestYArrayToArrayAt(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{Gc: false})
+	a := doc.GetArray("a")
+
+	empty := doc.CreateSnapshot()
+	a.Insert(0, []interface{}{int64(1), int64(2), int64(3), int64(4)})
+	inserted := doc.CreateSnapshot()
+	a.Delete(1, 2)
+	deleted := doc.CreateSnapshot()
+	a.Insert(1, []interface{}{int64(5), int64(6)})
+	a.Move(0, 3)
+	moved := doc.CreateSnapshot()
+	a.MoveRange(1, 2, 0)
+	a.Delete(0)
+
+	for _, tc := range []struct {
+		snapshot contracts.ISnapshot
+		want     []interface{}
+	}{
+		{empty, []interface{}{}},
+		{inserted, []interface{}{int64(1), int64(2), int64(3), int64(4)}},
+		{deleted, []interface{}{int64(1), int64(4)}},
+		{moved, []interface{}{int64(5), int64(6), int64(4), int64(1)}},
+		{doc.CreateSnapshot(), a.ToArray()},
+	} {
+		if got := a.ToArrayAt(tc.snapshot); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("got %v, want %v", got, tc.want)
+		}
+	}
+	if got, want := a.ToArray(), []interface{}{int64(4), int64(5), int64(1)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("reading snapshots changed the array: got %v, want %v", got, want)
+	}
+}
+
+func TestYMapEntriesAt(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{Gc: false})
+	m := doc.GetMap("m")
+
+	empty := doc.CreateSnapshot()
+	m.Set("k", int64(1))
+	set := doc.CreateSnapshot()
+	m.Set("k", int64(2))
+	m.Set("j", "x")
+	overwritten := doc.CreateSnapshot()
+	m.Delete("j")
+
+	for _, tc := range []struct {
+		snapshot contracts.ISnapshot
+		want     map[string]interface{}
+	}{
+		{empty, map[string]interface{}{}},
+		{set, map[string]interface{}{"k": int64(1)}},
+		{overwritten, map[string]interface{}{"k": int64(2), "j": "x"}},
+		{doc.CreateSnapshot(), map[string]interface{}{"k": int64(2)}},
+	} {
+		if got := m.EntriesAt(tc.snapshot); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("got %v, want %v", got, tc.want)
+		}
+	}
+}
+
+func TestYTextToStringAt(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{Gc: false})
+	text := doc.GetText("t")
+
+	empty := doc.CreateSnapshot()
+	text.Insert(0, "hello world")
+	inserted := doc.CreateSnapshot()
+	text.Delete(2, 4)
+	deleted := doc.CreateSnapshot()
+	text.Insert(1, "ab")
+
+	for _, tc := range []struct {
+		snapshot contracts.ISnapshot
+		want     string
+	}{
+		{empty, ""},
+		{inserted, "hello world"},
+		{deleted, "heworld"},
+		{doc.CreateSnapshot(), "habeworld"},
+	} {
+		if got := text.ToStringAt(tc.snapshot); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+	if got := text.ToString(); got != "habeworld" {
+		t.Fatalf("reading snapshots changed the text: got %q", got)
+	}
+}
+
+func TestYTextToDeltaBetweenSnapshots(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{Gc: false})
+	text := doc.GetText("t")
+	text.Insert(0, "abc")
+	prev := doc.CreateSnapshot()
+	text.Delete(1, 1)
+	text.Insert(2, "d")
+	next := doc.CreateSnapshot()
+	text.Insert(0, "later")
+
+	client := doc.GetClientID()
+	change := func(changeType contracts.YTextChangeType) map[string]interface{} {
+		return map[string]interface{}{
+			YTextChangeKey: contracts.YTextChangeAttributes{Type: changeType, User: client, State: changeType},
+		}
+	}
+	want := []contracts.Delta{
+		{Insert: "a"},
+		{Insert: "b", Attributes: change(contracts.YTextChangeTypeRemoved)},
+		{Insert: "c"},
+		{Insert: "d", Attributes: change(contracts.YTextChangeTypeAdded)},
+	}
+	if got := text.ToDelta(next, prev, nil); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	// computeYChange replaces the attributes of the changes
+	got := text.ToDelta(next, prev, func(changeType contracts.YTextChangeType, _ contracts.StructID, _ contracts.YTextChangeAttributes) interface{} {
+		return changeType.String()
+	})
+	if len(got) != 4 || got[1].Attributes[YTextChangeKey] != "Removed" || got[3].Attributes[YTextChangeKey] != "Added" {
+		t.Fatalf("got %+v", got)
+	}
+
+	if got := text.ToDelta(prev, nil, nil); !reflect.DeepEqual(got, []contracts.Delta{{Insert: "abc"}}) {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestSnapshotReadsRequireHistory(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{Gc: true})
+	a := doc.GetArray("a")
+	a.Insert(0, []interface{}{int64(1)})
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic for a garbage collected document")
+		}
+	}()
+	a.ToArrayAt(doc.CreateSnapshot())
+}
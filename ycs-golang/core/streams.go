@@ -0,0 +1,220 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"ycs/contracts"
+)
+
+// StreamPolicy decides what a stream does with a value when its buffer is full
+type StreamPolicy int
+
+const (
+	// StreamBlock waits until the receiver makes room. The transaction that produced
+	// the value waits too, so the receiver must not use the document while it is
+	// behind, or the two wait for each other.
+	StreamBlock StreamPolicy = iota
+	// StreamDropOldest discards the oldest buffered value to make room for the new one
+	StreamDropOldest
+	// StreamDropNewest discards the new value
+	StreamDropNewest
+)
+
+// defaultStreamBuffer is the channel capacity of a stream if none is configured
+const defaultStreamBuffer = 64
+
+// StreamOptions configures the channels returned by Updates, Events and DeepEvents
+type StreamOptions struct {
+	// Buffer is the capacity of the channel, 64 if zero
+	Buffer int
+	// Policy decides what happens when the buffer is full
+	Policy StreamPolicy
+	// OnDrop is called for every value the policy discards, e.g. to count them.
+	// A receiver of Updates that missed an update has to sync the document again.
+	OnDrop func()
+}
+
+// UpdateEvent is the update of one transaction, as sent by Updates. Update is
+// shared with the other update handlers and must not be modified.
+type UpdateEvent struct {
+	Update []byte
+	Origin interface{}
+	Local  bool
+}
+
+// TypeEvent is an event of a shared type, as sent by Events and DeepEvents. The
+// changes are computed while the transaction runs, so the event can be read on
+// any goroutine.
+type TypeEvent struct {
+	// Target is the type that changed
+	Target contracts.IAbstractType
+	// Path leads from the observed type to Target
+	Path    []interface{}
+	Changes *contracts.ChangesCollection
+	// Delta is the text delta of YText events, nil for other types
+	Delta  []contracts.Delta
+	Origin interface{}
+	Local  bool
+}
+
+// Updates returns a channel with the update of every transaction that changes the
+// document. The channel is closed when ctx is done or the document is destroyed.
+func (ydoc *YDoc) Updates(ctx context.Context, opts ...StreamOptions) <-chan UpdateEvent {
+	s := newStream[UpdateEvent](opts...)
+	s.start(ctx, ydoc,
+		ydoc.OnUpdateV2(func(update []byte, origin interface{}, transaction contracts.ITransaction) {
+			s.send(UpdateEvent{Update: update, Origin: origin, Local: transaction.GetLocal()})
+		}),
+	)
+	return s.ch
+}
+
+// Events returns a channel with the events of this type. The channel is closed when
+// ctx is done or the document is destroyed.
+func (at *AbstractType) Events(ctx context.Context, opts ...StreamOptions) <-chan TypeEvent {
+	s := newStream[TypeEvent](opts...)
+	s.start(ctx, at.doc,
+		at.Observe(func(args contracts.YEventArgs) {
+			s.send(newTypeEvent(args.Event, args.Transaction))
+		}),
+	)
+	return s.ch
+}
+
+// DeepEvents returns a channel with the events of this type and of all types nested
+// in it. The channel is closed when ctx is done or the document is destroyed.
+func (at *AbstractType) DeepEvents(ctx context.Context, opts ...StreamOptions) <-chan TypeEvent {
+	s := newStream[TypeEvent](opts...)
+	s.start(ctx, at.doc,
+		at.ObserveDeep(func(args contracts.YDeepEventArgs) {
+			for _, evt := range args.Events {
+				s.send(newTypeEvent(evt, args.Transaction))
+			}
+		}),
+	)
+	return s.ch
+}
+
+// newTypeEvent computes the changes of evt
+func newTypeEvent(evt contracts.IYEvent, transaction contracts.ITransaction) TypeEvent {
+	te := TypeEvent{
+		Target:  evt.GetTarget(),
+		Path:    evt.GetPath(),
+		Changes: evt.GetChanges(),
+		Origin:  transaction.GetOrigin(),
+		Local:   transaction.GetLocal(),
+	}
+	if textEvent, ok := evt.(*YTextEvent); ok {
+		te.Delta = textEvent.GetDelta()
+	}
+	return te
+}
+
+// stream feeds a channel from event handlers according to its options
+type stream[T any] struct {
+	ch   chan T
+	opts StreamOptions
+
+	// done is closed when the stream stops; sends stop waiting then
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mutex  sync.Mutex
+	closed bool
+}
+
+func newStream[T any](opts ...StreamOptions) *stream[T] {
+	var o StreamOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.Buffer <= 0 {
+		o.Buffer = defaultStreamBuffer
+	}
+
+	return &stream[T]{
+		ch:   make(chan T, o.Buffer),
+		opts: o,
+		done: make(chan struct{}),
+	}
+}
+
+// start stops the stream when ctx is done or doc is destroyed, then removes the handlers
+func (s *stream[T]) start(ctx context.Context, doc contracts.IYDoc, unobserve ...func()) {
+	if doc != nil {
+		unobserve = append(unobserve, doc.OnDestroyed(s.stop))
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.stop()
+		case <-s.done:
+		}
+
+		for _, f := range unobserve {
+			f()
+		}
+
+		s.mutex.Lock()
+		s.closed = true
+		close(s.ch)
+		s.mutex.Unlock()
+	}()
+}
+
+// stop ends the stream
+func (s *stream[T]) stop() {
+	s.stopOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// send passes value to the channel according to the policy
+func (s *stream[T]) send(value T) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.ch <- value:
+		return
+	default:
+	}
+
+	switch s.opts.Policy {
+	case StreamDropNewest:
+		s.dropped()
+
+	case StreamDropOldest:
+		for {
+			select {
+			case <-s.ch:
+				s.dropped()
+			default:
+			}
+
+			select {
+			case s.ch <- value:
+				return
+			default:
+			}
+		}
+
+	default:
+		select {
+		case s.ch <- value:
+		case <-s.done:
+			s.dropped()
+		}
+	}
+}
+
+func (s *stream[T]) dropped() {
+	if s.opts.OnDrop != nil {
+		s.opts.OnDrop()
+	}
+}
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ycs/contracts"
+)
+
+// next returns the next value of ch, failing the test after a second
+func next[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case value, ok := <-ch:
+		if !ok {
+			t.Fatal("channel closed")
+		}
+		return value
+	case <-time.After(time.Second):
+		t.Fatal("no value received")
+		var zero T
+		return zero
+	}
+}
+
+// waitClosed fails the test unless ch is closed within a second
+func waitClosed[T any](t *testing.T, ch <-chan T) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("channel not closed")
+		}
+	}
+}
+
+// keys returns the sorted keys changed by the event
+func keys(event TypeEvent) []string {
+	var result []string
+	for key := range event.Changes.Keys {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestUpdatesStream(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := doc.Updates(ctx)
+
+	doc.GetText("t").Insert(0, "hello")
+	remote := NewYDoc(contracts.YDocOptions{})
+	remote.GetText("t").Insert(0, "!")
+	if err := doc.ApplyUpdateV2(encodeUpdate(t, remote), "peer", false); err != nil {
+		t.Fatal(err)
+	}
+
+	local := next(t, updates)
+	if !local.Local || local.Origin != nil {
+		t.Fatalf("local update %+v", local)
+	}
+	replica := NewYDoc(contracts.YDocOptions{})
+	if err := replica.ApplyUpdateV2(local.Update, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := replica.GetText("t").ToString(); got != "hello" {
+		t.Fatalf("update holds %q", got)
+	}
+	if applied := next(t, updates); applied.Local || applied.Origin != "peer" {
+		t.Fatalf("applied update %+v", applied)
+	}
+
+	cancel()
+	waitClosed(t, updates)
+	// Changes after the stream ended are not sent
+	doc.GetText("t").Insert(0, "x")
+}
+
+func TestUpdatesStreamEndsWithDocument(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	updates := doc.Updates(context.Background())
+	doc.Destroy()
+	waitClosed(t, updates)
+}
+
+func TestEventsStream(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	text := doc.GetText("t").(*YText)
+	events := text.Events(ctx)
+	text.Insert(0, "ab")
+	text.Delete(0, 1)
+
+	inserted := next(t, events)
+	if inserted.Target != text || !inserted.Local || !reflect.DeepEqual(inserted.Delta, []contracts.Delta{{Insert: "ab"}}) {
+		t.Fatalf("insert event %+v", inserted)
+	}
+	deleted := next(t, events)
+	if len(deleted.Delta) != 1 || deleted.Delta[0].Delete == nil || *deleted.Delta[0].Delete != 1 {
+		t.Fatalf("delete event %+v", deleted)
+	}
+
+	// Events of other types are not sent
+	doc.GetMap("m").Set("k", "v")
+	select {
+	case event := <-events:
+		t.Fatalf("received %+v", event)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestDeepEventsStream(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := doc.GetMap("m").(*YMap)
+	m.Set("list", NewYArray(nil))
+	deep := m.DeepEvents(ctx)
+	shallow := m.Events(ctx)
+
+	list := m.Get("list").(*YArray)
+	list.Insert(0, []interface{}{int64(1)})
+	m.Set("k", "v")
+
+	nested := next(t, deep)
+	if nested.Target != list || !reflect.DeepEqual(nested.Path, []interface{}{"list"}) {
+		t.Fatalf("nested event %+v", nested)
+	}
+	if own := next(t, deep); own.Target != m || !reflect.DeepEqual(keys(own), []string{"k"}) {
+		t.Fatalf("map event %+v", own)
+	}
+	// Events only sends the events of the map itself
+	if own := next(t, shallow); own.Target != m || !reflect.DeepEqual(keys(own), []string{"k"}) {
+		t.Fatalf("map event %+v", own)
+	}
+}
+
+func TestStreamPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy StreamPolicy
+		want   []string
+	}{
+		{StreamDropOldest, []string{"c", "d"}},
+		{StreamDropNewest, []string{"a", "b"}},
+	} {
+		doc := NewYDoc(contracts.YDocOptions{})
+		ctx, cancel := context.WithCancel(context.Background())
+		m := doc.GetMap("m").(*YMap)
+		var drops atomic.Int32
+		events := m.Events(ctx, StreamOptions{Buffer: 2, Policy: tc.policy, OnDrop: func() { drops.Add(1) }})
+
+		for _, key := range []string{"a", "b", "c", "d"} {
+			m.Set(key, int64(0))
+		}
+		var got []string
+		for range tc.want {
+			got = append(got, keys(next(t, events))...)
+		}
+		if !reflect.DeepEqual(got, tc.want) || drops.Load() != 2 {
+			t.Errorf("policy %d: received %v with %d drops, want %v with 2", tc.policy, got, drops.Load(), tc.want)
+		}
+		cancel()
+	}
+}
+
+func TestStreamBlockWaitsForReceiver(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := doc.GetMap("m").(*YMap)
+	events := m.Events(ctx, StreamOptions{Buffer: 1, Policy: StreamBlock})
+	m.Set("a", int64(0))
+
+	done := make(chan struct{})
+	go func() {
+		m.Set("b", int64(0))
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("the transaction did not wait for the receiver")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if got := keys(next(t, events)); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("received %v", got)
+	}
+	<-done
+	if got := keys(next(t, events)); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Fatalf("received %v", got)
+	}
+}
+
+func TestStreamBlockEndsWhenCanceled(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	m := doc.GetMap("m").(*YMap)
+	var drops atomic.Int32
+	events := m.Events(ctx, StreamOptions{Buffer: 1, Policy: StreamBlock, OnDrop: func() { drops.Add(1) }})
+	m.Set("a", int64(0))
+
+	done := make(chan struct{})
+	go func() {
+		m.Set("b", int64(0))
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// A stream that ends releases the waiting transaction and drops its value
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the transaction still waits")
+	}
+	waitClosed(t, events)
+	if drops.Load() != 1 {
+		t.Fatalf("%d drops", drops.Load())
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"ycs/contracts"
 )
 
+// StructGCRefNumber is the info byte of GC structs in updates
+const StructGCRefNumber = 0
+
 // StructGC represents a garbage collected struct
 type StructGC struct {
 	id     contracts.StructID
@@ -193,13 +196,19 @@ func (gc *StructGC) SplitItem(transaction contracts.ITransaction, diff int) cont
 	return rightGC
 }
 
-// Integrate does nothing for GC structs
+// Integrate adds the GC struct to the store, without the first offset clocks
 func (gc *StructGC) Integrate(transaction contracts.ITransaction, offset int) {
-	// Do nothing
+	if offset > 0 {
+		gc.id = contracts.StructID{Client: gc.id.Client, Clock: gc.id.Clock + int64(offset)}
+		gc.length -= offset
+	}
+
+	transaction.GetDoc().GetStore().AddStruct(gc)
 }
 
 // Write writes the GC struct to an encoder
 func (gc *StructGC) Write(encoder contracts.IUpdateEncoder, offset int) error {
+	encoder.WriteInfo(StructGCRefNumber)
 	encoder.WriteLength(gc.length - offset)
 	return nil
 }
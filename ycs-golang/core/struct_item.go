@@ -1,7 +1,9 @@
 package core
 
 import (
+	"ycs/content"
 	"ycs/contracts"
+	"ycs/lib0"
 )
 
 // InfoFlags represents the bit flags for struct item properties
@@ -22,11 +24,12 @@ type StructItem struct {
 	left        contracts.IStructItem
 	rightOrigin *contracts.StructID
 	right       contracts.IStructItem
-	parent      interface{}
-	parentSub   *string
-	redone      *contracts.StructID
-	content     contracts.IContentEx
-	info        InfoFlags
+	// parent is the parent type, or its StructID or root key while the item is being read
+	parent    interface{}
+	parentSub *string
+	redone    *contracts.StructID
+	content   contracts.IContentEx
+	info      InfoFlags
 }
 
 // NewStructItem creates a new StructItem
@@ -34,10 +37,10 @@ func NewStructItem(id contracts.StructID, left contracts.IStructItem, leftOrigin
 	item := &StructItem{
 		id:          id,
 		length:      content.GetLength(),
-		leftOrigin:  leftOrigin,
+		leftOrigin:  copyStructID(leftOrigin),
 		left:        left,
 		right:       right,
-		rightOrigin: rightOrigin,
+		rightOrigin: copyStructID(rightOrigin),
 		parent:      parent,
 		parentSub:   parentSub,
 		redone:      nil,
@@ -52,6 +55,15 @@ func NewStructItem(id contracts.StructID, left contracts.IStructItem, leftOrigin
 	return item
 }
 
+// copyStructID returns a copy of an optional StructID
+func copyStructID(id *contracts.StructID) *contracts.StructID {
+	if id == nil {
+		return nil
+	}
+	copied := *id
+	return &copied
+}
+
 // GetID returns the struct ID
 func (si *StructItem) GetID() contracts.StructID {
 	return si.id
@@ -79,7 +91,7 @@ func (si *StructItem) GetLeftOrigin() *contracts.StructID {
 
 // SetLeftOrigin sets the left origin
 func (si *StructItem) SetLeftOrigin(leftOrigin *contracts.StructID) {
-	si.leftOrigin = leftOrigin
+	si.leftOrigin = copyStructID(leftOrigin)
 }
 
 // GetLeft returns the left item
@@ -99,12 +111,7 @@ func (si *StructItem) GetRightOrigin() *contracts.StructID {
 
 // SetRightOrigin sets the right origin
 func (si *StructItem) SetRightOrigin(rightOrigin *contracts.StructID) {
-	if rightOrigin == nil {
-		si.rightOrigin = nil
-	} else {
-		id := *rightOrigin
-		si.rightOrigin = &id
-	}
+	si.rightOrigin = copyStructID(rightOrigin)
 }
 
 // GetRight returns the right item
@@ -127,6 +134,12 @@ func (si *StructItem) SetParent(parent interface{}) {
 	si.parent = parent
 }
 
+// parentType returns the parent type, or nil if the parent is not resolved
+func (si *StructItem) parentType() contracts.IAbstractType {
+	parent, _ := si.parent.(contracts.IAbstractType)
+	return parent
+}
+
 // GetParentSub returns the parent sub key
 func (si *StructItem) GetParentSub() string {
 	if si.parentSub == nil {
@@ -146,20 +159,12 @@ func (si *StructItem) SetParentSub(parentSub string) {
 
 // GetRedone returns the redone ID
 func (si *StructItem) GetRedone() *contracts.StructID {
-	if si.redone == nil {
-		return nil
-	}
 	return si.redone
 }
 
 // SetRedone sets the redone ID
 func (si *StructItem) SetRedone(redone *contracts.StructID) {
-	if redone == nil {
-		si.redone = nil
-	} else {
-		id := *redone
-		si.redone = &id
-	}
+	si.redone = copyStructID(redone)
 }
 
 // GetContent returns the content
@@ -246,14 +251,13 @@ func (si *StructItem) IsGC() bool {
 
 // GetLastID returns the last ID based on this struct's ID and length
 func (si *StructItem) GetLastID() contracts.StructID {
-	if si.length <= 1 {
+	if si.length == 1 {
 		return si.id
 	}
-	lastID := contracts.StructID{
+	return contracts.StructID{
 		Client: si.id.Client,
 		Clock:  si.id.Clock + int64(si.length) - 1,
 	}
-	return lastID
 }
 
 // GetNext returns the next non-deleted item
@@ -274,211 +278,105 @@ func (si *StructItem) GetPrev() contracts.IStructItem {
 	return n
 }
 
-// First returns the first non-deleted item
-func (si *StructItem) First() contracts.IStructItem {
-	// Note: This method should be called on AbstractType, not StructItem
-	// For StructItem, we'll return the item itself if it's not deleted
-	if !si.GetDeleted() {
-		return si
-	}
-	return nil
-}
-
 // MarkDeleted marks this item as deleted
 func (si *StructItem) MarkDeleted() {
 	si.info |= InfoDeleted
 }
 
-// MergeWith tries to merge with the right item
+// MergeWith merges the right item into this one if both were created in sequence
+// by the same client; returns false if they cannot be merged
 func (si *StructItem) MergeWith(right contracts.IStructItem) bool {
-	// Check if we can merge with the right item
-	if si.id.Client != right.GetID().Client {
+	rightItem, ok := right.(*StructItem)
+	if !ok {
 		return false
 	}
 
-	// Check if items are adjacent
-	if si.id.Clock+int64(si.length) != right.GetID().Clock {
-		return false
-	}
-
-	// Check if both items have the same parent
-	if si.parent != right.GetParent() {
-		return false
-	}
-
-	// Check if both items have the same parentSub
-	if si.parentSub != nil && right.GetParentSub() != "" {
-		if *si.parentSub != right.GetParentSub() {
-			return false
+	lastID := si.GetLastID()
+	if contracts.EqualsPtr(rightItem.leftOrigin, &lastID) &&
+		si.right == right &&
+		contracts.EqualsPtr(rightItem.rightOrigin, si.rightOrigin) &&
+		si.id.Client == rightItem.id.Client &&
+		si.id.Clock+int64(si.length) == rightItem.id.Clock &&
+		si.GetDeleted() == rightItem.GetDeleted() &&
+		si.redone == nil &&
+		rightItem.redone == nil &&
+		si.content.GetRef() == rightItem.content.GetRef() &&
+		si.content.MergeWith(rightItem.content) {
+		if rightItem.IsKeep() {
+			si.SetKeep(true)
 		}
-	} else if si.parentSub != nil || right.GetParentSub() != "" {
-		return false
-	}
 
-	// Check if both items are either deleted or not deleted
-	if si.GetDeleted() != right.GetDeleted() {
-		return false
-	}
+		si.right = rightItem.right
+		if si.right != nil {
+			si.right.SetLeft(si)
+		}
 
-	// Try to merge contents
-	if si.content.MergeWith(right.GetContent()) {
-		// Update length
-		si.length += right.GetLength()
+		si.length += rightItem.length
 		return true
 	}
 
 	return false
 }
 
-// TryToMergeWithRight tries to merge with the right item
+// TryToMergeWithRight merges the right item into this one if possible
 func (si *StructItem) TryToMergeWithRight(right contracts.IStructItem) bool {
-	// Check if we can merge with the right item
-	if si.id.Client != right.GetID().Client {
-		return false
-	}
-
-	// Check if items are adjacent
-	if si.id.Clock+int64(si.length) != right.GetID().Clock {
-		return false
-	}
-
-	// Check if both items have the same parent
-	if si.parent != right.GetParent() {
-		return false
-	}
-
-	// Check if both items have the same parentSub
-	siParentSub := si.GetParentSub()
-	rightParentSub := right.GetParentSub()
-
-	if siParentSub != "" && rightParentSub != "" {
-		if siParentSub != rightParentSub {
-			return false
-		}
-	} else if siParentSub != "" || rightParentSub != "" {
-		return false
-	}
-
-	// Check if both items are either deleted or not deleted
-	if si.GetDeleted() != right.GetDeleted() {
-		return false
-	}
-
-	// Try to merge contents
-	if si.content.MergeWith(right.GetContent()) {
-		// Update length
-		si.length += right.GetLength()
-		return true
-	}
-
-	return false
+	return si.MergeWith(right)
 }
 
 // Delete marks this item as deleted
 func (si *StructItem) Delete(transaction contracts.ITransaction) {
-	if !si.GetDeleted() {
-		if parent, ok := si.parent.(contracts.IAbstractType); ok {
-			if si.IsCountable() && si.parentSub == nil {
-				parent.SetLength(parent.GetLength() - si.length)
-			}
-		}
-
-		si.MarkDeleted()
-		transaction.GetDeleteSet().Add(si.id.Client, si.id.Clock, int64(si.length))
-
-		if parent, ok := si.parent.(contracts.IAbstractType); ok {
-			parentSub := ""
-			if si.parentSub != nil {
-				parentSub = *si.parentSub
-			}
-			transaction.AddChangedTypeToTransaction(parent, parentSub)
-		}
+	if si.GetDeleted() {
+		return
+	}
 
-		si.content.Delete(transaction)
+	parent := si.parentType()
+	if si.IsCountable() && si.parentSub == nil {
+		parent.SetLength(parent.GetLength() - si.length)
 	}
+
+	si.MarkDeleted()
+	transaction.GetDeleteSet().Add(si.id.Client, si.id.Clock, int64(si.length))
+	transaction.AddChangedTypeToTransaction(parent, si.GetParentSub())
+	si.content.Delete(transaction)
 }
 
-// Integrate integrates this item into the document
+// Integrate integrates this item into the document, without the first offset clocks
 func (si *StructItem) Integrate(transaction contracts.ITransaction, offset int) {
-	if offset > 0 {
-		si.id = contracts.StructID{
-			Client: si.id.Client,
-			Clock:  si.id.Clock + int64(offset),
-		}
-
-		leftID := contracts.StructID{
-			Client: si.id.Client,
-			Clock:  si.id.Clock - 1,
-		}
-
-		si.left = transaction.GetDoc().GetStore().GetItemCleanEnd(transaction, leftID)
+	store := transaction.GetDoc().GetStore()
 
+	if offset > 0 {
+		si.id = contracts.StructID{Client: si.id.Client, Clock: si.id.Clock + int64(offset)}
+		si.left = store.GetItemCleanEnd(transaction, contracts.StructID{Client: si.id.Client, Clock: si.id.Clock - 1})
+		si.leftOrigin = nil
 		if si.left != nil {
 			lastID := si.left.GetLastID()
 			si.leftOrigin = &lastID
 		}
-
 		si.content = si.content.Splice(offset).(contracts.IContentEx)
 		si.length -= offset
 	}
 
-	if si.parent != nil {
-		si.integrateIntoParent(transaction)
-	} else {
-		// Parent is not defined. Integrate GC struct instead
-		gcStruct := NewStructGC(si.id, si.length)
-		gcStruct.Integrate(transaction, 0)
+	parent := si.parentType()
+	if parent == nil {
+		// Parent is not defined. Integrate GC struct instead.
+		NewStructGC(si.id, si.length).Integrate(transaction, 0)
 		return
 	}
 
-	// Add to store
-	transaction.GetDoc().GetStore().AddStruct(si)
-
-	// Integrate content
-	si.content.Integrate(transaction, si)
-
-	// Add parent to transaction.changed
-	if parent, ok := si.parent.(contracts.IAbstractType); ok {
-		parentSub := ""
-		if si.parentSub != nil {
-			parentSub = *si.parentSub
-		}
-		transaction.AddChangedTypeToTransaction(parent, parentSub)
-
-		// Delete if parent is deleted or if this is not the current attribute value of parent
-		if (parent.GetItem() != nil && parent.GetItem().GetDeleted()) ||
-			(si.parentSub != nil && si.right != nil) {
-			si.Delete(transaction)
-		}
-	}
-}
-
-// integrateIntoParent handles the complex integration logic into parent
-func (si *StructItem) integrateIntoParent(transaction contracts.ITransaction) {
-	// Check if we need to find the correct position
-	if (si.left == nil && (si.right == nil || si.right.GetLeft() != nil)) ||
-		(si.left != nil && si.left.GetRight() != si.right) {
-
-		var left contracts.IStructItem = si.left
+	if (si.left == nil && (si.right == nil || si.right.GetLeft() != nil)) || (si.left != nil && si.left.GetRight() != si.right) {
+		left := si.left
 		var o contracts.IStructItem
 
 		// Set 'o' to the first conflicting item
 		if left != nil {
 			o = left.GetRight()
 		} else if si.parentSub != nil {
-			if parent, ok := si.parent.(contracts.IAbstractType); ok {
-				parentMap := parent.GetMap()
-				if item, exists := parentMap[*si.parentSub]; exists {
-					o = item
-					for o != nil && o.GetLeft() != nil {
-						o = o.GetLeft()
-					}
-				}
+			o = parent.GetMap()[*si.parentSub]
+			for o != nil && o.GetLeft() != nil {
+				o = o.GetLeft()
 			}
 		} else {
-			if parent, ok := si.parent.(contracts.IAbstractType); ok {
-				o = parent.GetStart()
-			}
+			o = parent.GetStart()
 		}
 
 		conflictingItems := make(map[contracts.IStructItem]struct{})
@@ -488,26 +386,26 @@ func (si *StructItem) integrateIntoParent(transaction contracts.ITransaction) {
 			itemsBeforeOrigin[o] = struct{}{}
 			conflictingItems[o] = struct{}{}
 
-			if structIDEquals(si.leftOrigin, o.GetLeftOrigin()) {
+			if contracts.EqualsPtr(si.leftOrigin, o.GetLeftOrigin()) {
 				// Case 1
 				if o.GetID().Client < si.id.Client {
 					left = o
 					conflictingItems = make(map[contracts.IStructItem]struct{})
-				} else if structIDEquals(si.rightOrigin, o.GetRightOrigin()) {
-					// This and 'o' are conflicting and point to the same integration points
-					// The id decides which item comes first
+				} else if contracts.EqualsPtr(si.rightOrigin, o.GetRightOrigin()) {
+					// This and 'o' are conflicting and point to the same integration points.
+					// The id decides which item comes first.
+					// Since this is to the left of 'o', we can break here.
 					break
 				}
-			} else if o.GetLeftOrigin() != nil {
-				leftOriginItem, err := transaction.GetDoc().GetStore().Find(*o.GetLeftOrigin())
-				if err == nil && leftOriginItem != nil {
-					if _, exists := itemsBeforeOrigin[leftOriginItem]; exists {
-						// Case 2
-						if _, conflicts := conflictingItems[leftOriginItem]; !conflicts {
-							left = o
-							conflictingItems = make(map[contracts.IStructItem]struct{})
-						}
-					}
+				// Else, 'o' might be integrated before an item that this conflicts with.
+				// If so, we will find it in the next iterations.
+			} else if o.GetLeftOrigin() != nil && isItemBeforeOrigin(store, *o.GetLeftOrigin(), itemsBeforeOrigin) {
+				// Case 2
+				// Use 'Find' instead of 'GetItemCleanEnd', because we don't want / need to split items.
+				originItem, _ := store.Find(*o.GetLeftOrigin())
+				if _, conflicting := conflictingItems[originItem]; !conflicting {
+					left = o
+					conflictingItems = make(map[contracts.IStructItem]struct{})
 				}
 			} else {
 				break
@@ -521,29 +419,19 @@ func (si *StructItem) integrateIntoParent(transaction contracts.ITransaction) {
 
 	// Reconnect left/right + update parent map/start if necessary
 	if si.left != nil {
-		right := si.left.GetRight()
-		si.right = right
+		si.right = si.left.GetRight()
 		si.left.SetRight(si)
 	} else {
 		var r contracts.IStructItem
-
 		if si.parentSub != nil {
-			if parent, ok := si.parent.(contracts.IAbstractType); ok {
-				parentMap := parent.GetMap()
-				if item, exists := parentMap[*si.parentSub]; exists {
-					r = item
-					for r != nil && r.GetLeft() != nil {
-						r = r.GetLeft()
-					}
-				}
+			r = parent.GetMap()[*si.parentSub]
+			for r != nil && r.GetLeft() != nil {
+				r = r.GetLeft()
 			}
 		} else {
-			if parent, ok := si.parent.(contracts.IAbstractType); ok {
-				r = parent.GetStart()
-				parent.SetStart(si)
-			}
+			r = parent.GetStart()
+			parent.SetStart(si)
 		}
-
 		si.right = r
 	}
 
@@ -551,41 +439,73 @@ func (si *StructItem) integrateIntoParent(transaction contracts.ITransaction) {
 		si.right.SetLeft(si)
 	} else if si.parentSub != nil {
 		// Set as current parent value if right == nil and this is parentSub
-		if parent, ok := si.parent.(contracts.IAbstractType); ok {
-			parentMap := parent.GetMap()
-			parentMap[*si.parentSub] = si
-			// This is the current attribute value of parent. Delete left.
-			if si.left != nil {
-				si.left.Delete(transaction)
-			}
+		parent.GetMap()[*si.parentSub] = si
+		// This is the current attribute value of parent. Delete left.
+		if si.left != nil {
+			si.left.Delete(transaction)
 		}
 	}
 
 	// Adjust length of parent
 	if si.parentSub == nil && si.IsCountable() && !si.GetDeleted() {
-		if parent, ok := si.parent.(contracts.IAbstractType); ok {
-			parent.SetLength(parent.GetLength() + si.length)
-		}
+		parent.SetLength(parent.GetLength() + si.length)
+	}
+
+	store.AddStruct(si)
+	si.content.Integrate(transaction, si)
+
+	// Add parent to transaction.changed
+	transaction.AddChangedTypeToTransaction(parent, si.GetParentSub())
+
+	if (parent.GetItem() != nil && parent.GetItem().GetDeleted()) || (si.parentSub != nil && si.right != nil) {
+		// Delete if parent is deleted or if this is not the current attribute value of parent
+		si.Delete(transaction)
 	}
 }
 
+// isItemBeforeOrigin returns true if the item with the given id is one of itemsBeforeOrigin
+func isItemBeforeOrigin(store contracts.IStructStore, id contracts.StructID, itemsBeforeOrigin map[contracts.IStructItem]struct{}) bool {
+	item, err := store.Find(id)
+	if err != nil {
+		return false
+	}
+	_, exists := itemsBeforeOrigin[item]
+	return exists
+}
+
 // GetMissing returns the creator ClientID of the missing OP or defines missing items and returns nil
 func (si *StructItem) GetMissing(transaction contracts.ITransaction, store contracts.IStructStore) *int64 {
 	if si.leftOrigin != nil && si.leftOrigin.Client != si.id.Client && si.leftOrigin.Clock >= store.GetState(si.leftOrigin.Client) {
-		return &si.leftOrigin.Client
+		client := si.leftOrigin.Client
+		return &client
 	}
 
 	if si.rightOrigin != nil && si.rightOrigin.Client != si.id.Client && si.rightOrigin.Clock >= store.GetState(si.rightOrigin.Client) {
-		return &si.rightOrigin.Client
+		client := si.rightOrigin.Client
+		return &client
 	}
 
 	if parentID, ok := si.parent.(contracts.StructID); ok && si.id.Client != parentID.Client && parentID.Clock >= store.GetState(parentID.Client) {
-		return &parentID.Client
+		client := parentID.Client
+		return &client
+	}
+
+	// A move needs the ends of its range to render it
+	if move, ok := si.content.(*content.ContentMove); ok {
+		start, _ := move.GetStart()
+		end, _ := move.GetEnd()
+		for _, id := range []contracts.StructID{start, end} {
+			if id.Client != si.id.Client && id.Clock >= store.GetState(id.Client) {
+				client := id.Client
+				return &client
+			}
+		}
 	}
 
 	// We have all missing ids, now find the items
 	if si.leftOrigin != nil {
 		si.left = store.GetItemCleanEnd(transaction, *si.leftOrigin)
+		si.leftOrigin = nil
 		if si.left != nil {
 			lastID := si.left.GetLastID()
 			si.leftOrigin = &lastID
@@ -594,120 +514,131 @@ func (si *StructItem) GetMissing(transaction contracts.ITransaction, store contr
 
 	if si.rightOrigin != nil {
 		si.right = store.GetItemCleanStart(transaction, *si.rightOrigin)
-		if si.right != nil {
-			rightID := si.right.GetID()
-			si.rightOrigin = &rightID
+		rightID := si.right.GetID()
+		si.rightOrigin = &rightID
+	}
+
+	if (si.left != nil && si.left.IsGC()) || (si.right != nil && si.right.IsGC()) {
+		si.parent = nil
+	} else if si.parent == nil {
+		// Only set parent if this shouldn't be garbage collected
+		if si.right != nil && !si.right.IsGC() {
+			si.parent = si.right.GetParent()
+			si.SetParentSub(si.right.GetParentSub())
+		} else if si.left != nil && !si.left.IsGC() {
+			si.parent = si.left.GetParent()
+			si.SetParentSub(si.left.GetParentSub())
+		}
+	} else if parentID, ok := si.parent.(contracts.StructID); ok {
+		si.parent = nil
+		if parentItem, err := store.Find(parentID); err == nil && !parentItem.IsGC() {
+			if contentType, ok := parentItem.GetContent().(*content.ContentType); ok {
+				si.parent = contentType.GetType()
+			}
 		}
 	}
 
-	if parentID, ok := si.parent.(contracts.StructID); ok {
-		if parentItem, err := store.Find(parentID); err == nil {
-			si.parent = parentItem
+	return nil
+}
+
+// Gc replaces the content of this deleted item, or the whole item if its parent was collected too
+func (si *StructItem) Gc(store contracts.IStructStore, parentGCd bool) {
+	if !si.GetDeleted() {
+		panic("cannot garbage collect an item that is not deleted")
+	}
+
+	si.content.Gc(store)
+
+	if parentGCd {
+		store.ReplaceStruct(si, NewStructGC(si.id, si.length))
+	} else {
+		si.content = content.NewContentDeleted(si.length)
+	}
+}
+
+// KeepItemAndParents marks this item and its parents as kept (not to be garbage collected)
+func (si *StructItem) KeepItemAndParents(keep bool) {
+	var item contracts.IStructItem = si
+	for item != nil && item.GetKeep() != keep {
+		item.SetKeep(keep)
+		parent, ok := item.GetParent().(contracts.IAbstractType)
+		if !ok {
+			break
 		}
+		item = parent.GetItem()
 	}
+}
 
-	return nil
+// IsVisible returns whether this item is visible in a snapshot, or now if snapshot is nil
+func (si *StructItem) IsVisible(snapshot contracts.ISnapshot) bool {
+	if snapshot == nil {
+		return !si.GetDeleted()
+	}
+
+	clientClock, exists := snapshot.GetStateVector()[si.id.Client]
+	return exists && clientClock > si.id.Clock && !snapshot.GetDeleteSet().IsDeleted(si.id)
 }
 
-// Write writes this item to an encoder
+// Write writes this item to an encoder, without the first offset clocks
 func (si *StructItem) Write(encoder contracts.IUpdateEncoder, offset int) error {
-	hasLeftOrigin := si.leftOrigin != nil
-	hasRightOrigin := si.rightOrigin != nil
-	hasParentYKey := false
-	hasParentSub := si.parentSub != nil
-
-	// Determine parent info
-	var parentYKey *string
-	if si.parent != nil {
-		if _, isStructID := si.parent.(contracts.StructID); !isStructID {
-			if parent, ok := si.parent.(contracts.IAbstractType); ok {
-				key := parent.FindRootTypeKey()
-				parentYKey = &key
-				hasParentYKey = true
-			}
-		}
+	origin := si.leftOrigin
+	if offset > 0 {
+		origin = &contracts.StructID{Client: si.id.Client, Clock: si.id.Clock + int64(offset) - 1}
 	}
+	rightOrigin := si.rightOrigin
 
-	// Calculate info byte
-	info := si.content.GetRef()
-	if hasLeftOrigin {
-		info |= 0x80 // Bit8
+	info := uint32(si.content.GetRef()) & lib0.Bits5
+	if origin != nil {
+		info |= lib0.Bit8
 	}
-	if hasRightOrigin {
-		info |= 0x40 // Bit7
+	if rightOrigin != nil {
+		info |= lib0.Bit7
 	}
-	if hasParentSub {
-		info |= 0x20 // Bit6
+	if si.parentSub != nil {
+		info |= lib0.Bit6
 	}
-
 	encoder.WriteInfo(byte(info))
 
-	if hasLeftOrigin {
-		encoder.WriteLeftID(*si.leftOrigin)
+	if origin != nil {
+		encoder.WriteLeftID(*origin)
 	}
-	if hasRightOrigin {
-		encoder.WriteRightID(*si.rightOrigin)
+	if rightOrigin != nil {
+		encoder.WriteRightID(*rightOrigin)
 	}
 
-	if (info & (0x40 | 0x80)) == 0 {
-		encoder.WriteParentInfo(hasParentYKey)
-		if hasParentYKey {
-			encoder.WriteString(*parentYKey)
-		} else if !hasParentYKey {
-			if parentID, ok := si.parent.(contracts.StructID); ok {
-				encoder.WriteLeftID(parentID)
-			}
+	if origin == nil && rightOrigin == nil {
+		parent := si.parentType()
+		if parentItem := parent.GetItem(); parentItem == nil {
+			// Parent type on y._map; find the correct key
+			encoder.WriteParentInfo(true)
+			encoder.WriteString(parent.FindRootTypeKey())
+		} else {
+			encoder.WriteParentInfo(false)
+			encoder.WriteLeftID(parentItem.GetID())
 		}
-	}
 
-	if hasParentSub {
-		encoder.WriteString(*si.parentSub)
+		if si.parentSub != nil {
+			encoder.WriteString(*si.parentSub)
+		}
 	}
 
 	return si.content.Write(encoder, offset)
 }
 
-// IsVisible returns whether this item is visible in a snapshot
-func (si *StructItem) IsVisible(snapshot contracts.ISnapshot) bool {
-	stateVector := snapshot.GetStateVector()
-	clientClock, exists := stateVector[si.id.Client]
-	return exists && si.id.Clock < clientClock && !snapshot.GetDeleteSet().IsDeleted(si.id)
-}
-
-// KeepItemAndParents marks this item and its parents as kept (not to be garbage collected)
-func (si *StructItem) KeepItemAndParents(keep bool) {
-	var item contracts.IStructItem = si
-	for item != nil && item.GetKeep() != keep {
-		item.SetKeep(keep)
-		if parent, ok := item.GetParent().(contracts.IAbstractType); ok {
-			item = parent.GetItem()
-		} else {
-			break
-		}
-	}
-}
-
-// SplitItem splits this item at the given difference
+// SplitItem splits this item at the given difference and returns the right part
 func (si *StructItem) SplitItem(transaction contracts.ITransaction, diff int) contracts.IStructItem {
-	if diff == 0 {
-		return si
-	}
+	client := si.id.Client
+	clock := si.id.Clock
 
-	rightID := contracts.StructID{
-		Client: si.id.Client,
-		Clock:  si.id.Clock + int64(diff),
-	}
-
-	rightContent := si.content.Splice(diff)
 	rightItem := NewStructItem(
-		rightID,
+		contracts.StructID{Client: client, Clock: clock + int64(diff)},
 		si,
-		func() *contracts.StructID { id := si.GetLastID(); return &id }(),
+		&contracts.StructID{Client: client, Clock: clock + int64(diff) - 1},
 		si.right,
 		si.rightOrigin,
 		si.parent,
 		si.parentSub,
-		rightContent.(contracts.IContentEx),
+		si.content.Splice(diff).(contracts.IContentEx),
 	)
 
 	if si.GetDeleted() {
@@ -719,38 +650,25 @@ func (si *StructItem) SplitItem(transaction contracts.ITransaction, diff int) co
 	}
 
 	if si.redone != nil {
-		rightRedone := contracts.StructID{
-			Client: si.redone.Client,
-			Clock:  si.redone.Clock + int64(diff),
-		}
-		rightItem.redone = &rightRedone
+		rightItem.redone = &contracts.StructID{Client: si.redone.Client, Clock: si.redone.Clock + int64(diff)}
 	}
 
-	// Update connections
+	// Update left (do not set leftItem.RightOrigin as it will lead to problems when syncing)
 	si.right = rightItem
+
+	// Update right
 	if rightItem.right != nil {
 		rightItem.right.SetLeft(rightItem)
 	}
-	si.rightOrigin = &rightID
-	si.length = diff
 
-	return rightItem
-}
+	// Right is more specific
+	transaction.AddMergeStruct(rightItem)
 
-// Gc performs garbage collection on this item
-func (si *StructItem) Gc(store contracts.IStructStore, parentGCd bool) {
-	if !si.GetDeleted() && !si.IsKeep() {
-		si.content.Gc(store)
+	// Update parent._map
+	if rightItem.parentSub != nil && rightItem.right == nil {
+		rightItem.parentType().GetMap()[*rightItem.parentSub] = rightItem
 	}
-}
 
-// Helper function to compare StructID pointers
-func structIDEquals(a, b *contracts.StructID) bool {
-	if a == nil && b == nil {
-		return true
-	}
-	if a == nil || b == nil {
-		return false
-	}
-	return a.Client == b.Client && a.Clock == b.Clock
+	si.length = diff
+	return rightItem
 }
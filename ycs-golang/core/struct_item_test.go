@@ -0,0 +1,34 @@
+package core
+
+import (
+	"testing"
+
+	"ycs/content"
+	"ycs/contracts"
+)
+
+func TestItemNextToCollectedStructIsCollected(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{Gc: true})
+	doc.SetClientID(1)
+	array := doc.GetArray("a").(*YArray)
+	array.Insert(0, []interface{}{int64(1)})
+	right := array.GetStart()
+
+	// Client 3 had an item whose parent was collected
+	doc.GetStore().AddStruct(NewStructGC(contracts.NewStructID(3, 0), 1))
+
+	leftOrigin := contracts.NewStructID(3, 0)
+	rightOrigin := right.GetID()
+	item := NewStructItem(contracts.NewStructID(2, 0), nil, &leftOrigin, nil, &rightOrigin, nil, nil, content.NewContentAny([]interface{}{int64(2)}))
+
+	doc.Transact(func(tr contracts.ITransaction) {
+		if missing := item.GetMissing(tr, doc.GetStore()); missing != nil {
+			t.Fatalf("missing client %d", *missing)
+		}
+	}, nil)
+
+	// Like in Yjs, the item is collected too instead of joining the right neighbour's parent
+	if item.GetParent() != nil {
+		t.Fatalf("parent is %T, want nil", item.GetParent())
+	}
+}
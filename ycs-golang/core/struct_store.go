@@ -2,6 +2,7 @@ package core
 
 import (
 	"errors"
+	"fmt"
 	"sort"
 	"ycs/contracts"
 	"ycs/lib0"
@@ -67,6 +68,20 @@ func (ss *StructStore) GetState(clientID int64) int64 {
 	return 0
 }
 
+// GetPendingStructCount returns the number of structs waiting for missing dependencies
+func (ss *StructStore) GetPendingStructCount() int {
+	count := len(ss.pendingStack)
+	for _, refs := range ss.pendingClientStructRefs {
+		count += len(refs.Refs) - refs.NextReadOperation
+	}
+	return count
+}
+
+// GetPendingDeleteSetCount returns the number of delete sets waiting for missing structs
+func (ss *StructStore) GetPendingDeleteSetCount() int {
+	return len(ss.pendingDeleteReaders)
+}
+
 // IntegrityCheck performs integrity check on the store
 func (ss *StructStore) IntegrityCheck() {
 	for _, structs := range ss.clients {
@@ -107,15 +122,31 @@ func (ss *StructStore) CleanupPendingStructs() {
 	}
 }
 
+// ReplacePendingParent points the pending structs that reference oldParent to newParent.
+// Used when a root type that was created by a remote update is realized.
+func (ss *StructStore) ReplacePendingParent(oldParent, newParent contracts.IAbstractType) {
+	replace := func(s contracts.IStructItem) {
+		if s.GetParent() == oldParent {
+			s.SetParent(newParent)
+		}
+	}
+
+	for _, s := range ss.pendingStack {
+		replace(s)
+	}
+	for _, refs := range ss.pendingClientStructRefs {
+		for _, s := range refs.Refs {
+			replace(s)
+		}
+	}
+}
+
 // AddStruct adds a struct to the store
 func (ss *StructStore) AddStruct(str contracts.IStructItem) {
 	client := str.GetID().Client
-	structs, exists := ss.clients[client]
+	structs := ss.clients[client]
 
-	if !exists {
-		structs = make([]contracts.IStructItem, 0)
-		ss.clients[client] = structs
-	} else if len(structs) > 0 {
+	if len(structs) > 0 {
 		lastStruct := structs[len(structs)-1]
 		if lastStruct.GetID().Clock+int64(lastStruct.GetLength()) != str.GetID().Clock {
 			panic("unexpected struct clock")
@@ -129,7 +160,7 @@ func (ss *StructStore) AddStruct(str contracts.IStructItem) {
 func (ss *StructStore) Find(id contracts.StructID) (contracts.IStructItem, error) {
 	structs, exists := ss.clients[id.Client]
 	if !exists {
-		return nil, errors.New("no structs for client")
+		return nil, fmt.Errorf("no structs for client: %d", id.Client)
 	}
 
 	index := FindIndexSS(structs, id.Clock)
@@ -140,21 +171,28 @@ func (ss *StructStore) Find(id contracts.StructID) (contracts.IStructItem, error
 	return structs[index], nil
 }
 
-// FindIndexCleanStart finds index with clean start
+// errStreamReader is returned when a decoder does not read from a lib0 stream
+var errStreamReader = errors.New("reader does not implement StreamReader interface")
+
+// insertStruct inserts a struct into the list of its client at the given index
+func (ss *StructStore) insertStruct(index int, str contracts.IStructItem) []contracts.IStructItem {
+	client := str.GetID().Client
+	structs := append(ss.clients[client], nil)
+	copy(structs[index+1:], structs[index:])
+	structs[index] = str
+	ss.clients[client] = structs
+	return structs
+}
+
+// FindIndexCleanStart finds the index of the struct starting at clock, splitting
+// the struct that contains it if necessary. The list of the client is updated in
+// place, so callers have to re-read it from GetClients after a split.
 func (ss *StructStore) FindIndexCleanStart(transaction contracts.ITransaction, structs []contracts.IStructItem, clock int64) int {
 	index := FindIndexSS(structs, clock)
 	str := structs[index]
 
-	if str.GetID().Clock < clock {
-		splitItem := str.SplitItem(transaction, int(clock-str.GetID().Clock))
-		// Insert the split item
-		newStructs := make([]contracts.IStructItem, len(structs)+1)
-		copy(newStructs[:index+1], structs[:index+1])
-		newStructs[index+1] = splitItem
-		copy(newStructs[index+2:], structs[index+1:])
-
-		// Update the clients map
-		ss.clients[str.GetID().Client] = newStructs
+	if str.GetID().Clock < clock && !str.IsGC() {
+		ss.insertStruct(index+1, str.SplitItem(transaction, int(clock-str.GetID().Clock)))
 		return index + 1
 	}
 
@@ -165,38 +203,25 @@ func (ss *StructStore) FindIndexCleanStart(transaction contracts.ITransaction, s
 func (ss *StructStore) GetItemCleanStart(transaction contracts.ITransaction, id contracts.StructID) contracts.IStructItem {
 	structs, exists := ss.clients[id.Client]
 	if !exists {
-		panic("no structs for client")
+		panic(fmt.Sprintf("no structs for client: %d", id.Client))
 	}
 
-	indexCleanStart := ss.FindIndexCleanStart(transaction, structs, id.Clock)
-	if indexCleanStart < 0 || indexCleanStart >= len(structs) {
-		panic("invalid index")
-	}
-
-	return structs[indexCleanStart]
+	index := ss.FindIndexCleanStart(transaction, structs, id.Clock)
+	return ss.clients[id.Client][index]
 }
 
 // GetItemCleanEnd gets item with clean end
 func (ss *StructStore) GetItemCleanEnd(transaction contracts.ITransaction, id contracts.StructID) contracts.IStructItem {
 	structs, exists := ss.clients[id.Client]
 	if !exists {
-		panic("no structs for client")
+		panic(fmt.Sprintf("no structs for client: %d", id.Client))
 	}
 
 	index := FindIndexSS(structs, id.Clock)
 	str := structs[index]
 
 	if id.Clock != str.GetID().Clock+int64(str.GetLength())-1 && !str.IsGC() {
-		splitItem := str.SplitItem(transaction, int(id.Clock-str.GetID().Clock+1))
-		// Insert the split item
-		newStructs := make([]contracts.IStructItem, len(structs)+1)
-		copy(newStructs[:index+1], structs[:index+1])
-		newStructs[index+1] = splitItem
-		copy(newStructs[index+2:], structs[index+1:])
-
-		// Update the clients map
-		ss.clients[str.GetID().Client] = newStructs
-		return newStructs[index+1]
+		ss.insertStruct(index+1, str.SplitItem(transaction, int(id.Clock-str.GetID().Clock+1)))
 	}
 
 	return str
@@ -206,7 +231,7 @@ func (ss *StructStore) GetItemCleanEnd(transaction contracts.ITransaction, id co
 func (ss *StructStore) ReplaceStruct(oldStruct, newStruct contracts.IStructItem) error {
 	structs, exists := ss.clients[oldStruct.GetID().Client]
 	if !exists {
-		return errors.New("no structs for client")
+		return fmt.Errorf("no structs for client: %d", oldStruct.GetID().Client)
 	}
 
 	index := FindIndexSS(structs, oldStruct.GetID().Clock)
@@ -214,16 +239,19 @@ func (ss *StructStore) ReplaceStruct(oldStruct, newStruct contracts.IStructItem)
 	return nil
 }
 
-// IterateStructs iterates over structs in a range
+// IterateStructs iterates over the structs of a client in a clock range, splitting
+// the first and last struct so that fun only sees structs inside the range
 func (ss *StructStore) IterateStructs(transaction contracts.ITransaction, structs []contracts.IStructItem, clockStart int64, length int64, fun func(contracts.IStructItem) bool) {
 	if length <= 0 {
 		return
 	}
 
+	client := structs[0].GetID().Client
 	clockEnd := clockStart + length
 	index := ss.FindIndexCleanStart(transaction, structs, clockStart)
 
-	for index < len(structs) {
+	for {
+		structs = ss.clients[client]
 		str := structs[index]
 
 		if clockEnd < str.GetID().Clock+int64(str.GetLength()) {
@@ -235,7 +263,8 @@ func (ss *StructStore) IterateStructs(transaction contracts.ITransaction, struct
 		}
 
 		index++
-		if index < len(structs) && structs[index].GetID().Clock >= clockEnd {
+		structs = ss.clients[client]
+		if index >= len(structs) || structs[index].GetID().Clock >= clockEnd {
 			break
 		}
 	}
@@ -253,12 +282,12 @@ func (ss *StructStore) FollowRedone(id contracts.StructID) (contracts.IStructIte
 
 		item, err := ss.Find(nextID)
 		if err != nil {
-			return nil, 0
+			panic(err)
 		}
 
 		diff = int(nextID.Clock - item.GetID().Clock)
 
-		if item.GetRedone() == nil {
+		if item.IsGC() || item.GetRedone() == nil {
 			return item, diff
 		}
 
@@ -282,7 +311,7 @@ func (ss *StructStore) MergeReadStructsIntoPendingReads(clientStructRefs map[int
 			}
 
 			merged := append(pendingStructRefs.Refs, structRefs...)
-			sort.Slice(merged, func(i, j int) bool {
+			sort.SliceStable(merged, func(i, j int) bool {
 				return merged[i].GetID().Clock < merged[j].GetID().Clock
 			})
 
@@ -377,11 +406,10 @@ func (ss *StructStore) ResumeStructIntegration(transaction contracts.ITransactio
 
 					// Sort the set because this approach might bring the list out of order
 					refs := structRefs.Refs[structRefs.NextReadOperation:]
-					sort.Slice(refs, func(i, j int) bool {
+					sort.SliceStable(refs, func(i, j int) bool {
 						return refs[i].GetID().Clock < refs[j].GetID().Clock
 					})
-					structRefs.Refs = structRefs.Refs[:structRefs.NextReadOperation]
-					structRefs.Refs = append(structRefs.Refs, refs...)
+					structRefs.Refs = refs
 					structRefs.NextReadOperation = 0
 					continue
 				}
@@ -438,106 +466,83 @@ func (ss *StructStore) ResumeStructIntegration(transaction contracts.ITransactio
 	ss.pendingClientStructRefs = make(map[int64]*PendingClientStructRef)
 }
 
-// ReadAndApplyDeleteSet reads and applies delete set
+// ReadAndApplyDeleteSet reads a delete set and applies it to the structs that
+// are already known. Deletions of unknown structs are kept as pending.
 func (ss *StructStore) ReadAndApplyDeleteSet(decoder contracts.IDSDecoder, transaction contracts.ITransaction) error {
 	unappliedDs := NewDeleteSet()
-	reader := decoder.GetReader()
-	streamReader, ok := reader.(lib0.StreamReader)
+	reader, ok := decoder.GetReader().(lib0.StreamReader)
 	if !ok {
-		return errors.New("reader does not implement StreamReader interface")
+		return errStreamReader
 	}
 
-	numClients, err := lib0.ReadVarUint(streamReader)
+	numClients, err := lib0.ReadVarUint(reader)
 	if err != nil {
 		return err
 	}
 
-	for i := uint32(0); i < uint32(numClients); i++ {
+	for i := uint32(0); i < numClients; i++ {
 		decoder.ResetDsCurVal()
 
-		clientVal, err := lib0.ReadVarUint(streamReader)
+		clientVal, err := lib0.ReadVarUint(reader)
 		if err != nil {
 			return err
 		}
 		client := int64(clientVal)
-		numberOfDeletes, err := lib0.ReadVarUint(streamReader)
+		numberOfDeletes, err := lib0.ReadVarUint(reader)
 		if err != nil {
 			return err
 		}
 
-		structs, exists := ss.clients[client]
-		if !exists {
-			structs = make([]contracts.IStructItem, 0)
-			ss.clients[client] = structs
-		}
-
 		state := ss.GetState(client)
 
-		for deleteIndex := uint32(0); deleteIndex < uint32(numberOfDeletes); deleteIndex++ {
-			clock := int64(decoder.ReadDsClock())
-			clockEnd := clock + int64(decoder.ReadDsLength())
+		for deleteIndex := uint32(0); deleteIndex < numberOfDeletes; deleteIndex++ {
+			clock := decoder.ReadDsClock()
+			clockEnd := clock + decoder.ReadDsLength()
 
-			if clock < state {
-				if state < clockEnd {
-					unappliedDs.Add(client, state, clockEnd-state)
-				}
+			if clock >= state {
+				unappliedDs.Add(client, clock, clockEnd-clock)
+				continue
+			}
 
-				index := FindIndexSS(structs, clock)
+			if state < clockEnd {
+				unappliedDs.Add(client, state, clockEnd-state)
+			}
 
-				// We can ignore the case of GC and Delete structs, because we are going to skip them
-				str := structs[index]
+			structs := ss.clients[client]
+			index := FindIndexSS(structs, clock)
 
-				// Split the first item if necessary
-				if !str.GetDeleted() && str.GetID().Clock < clock {
-					splitItem := str.SplitItem(transaction, int(clock-str.GetID().Clock))
-					// Insert split item
-					newStructs := make([]contracts.IStructItem, len(structs)+1)
-					copy(newStructs[:index+1], structs[:index+1])
-					newStructs[index+1] = splitItem
-					copy(newStructs[index+2:], structs[index+1:])
-					structs = newStructs
-					ss.clients[client] = structs
+			// We can ignore the case of GC and Delete structs, because we are going to skip them
+			str := structs[index]
 
-					// Increase, we now want to use the next struct
-					index++
+			// Split the first item if necessary
+			if !str.GetDeleted() && str.GetID().Clock < clock {
+				structs = ss.insertStruct(index+1, str.SplitItem(transaction, int(clock-str.GetID().Clock)))
+				// Increase, we now want to use the next struct
+				index++
+			}
+
+			for index < len(structs) {
+				str = structs[index]
+				index++
+				if str.GetID().Clock >= clockEnd {
+					break
 				}
 
-				for index < len(structs) {
-					str = structs[index]
-					if str.GetID().Clock < clockEnd {
-						if !str.GetDeleted() {
-							if clockEnd < str.GetID().Clock+int64(str.GetLength()) {
-								splitItem := str.SplitItem(transaction, int(clockEnd-str.GetID().Clock))
-								// Insert split item
-								newStructs := make([]contracts.IStructItem, len(structs)+1)
-								copy(newStructs[:index+1], structs[:index+1])
-								newStructs[index+1] = splitItem
-								copy(newStructs[index+2:], structs[index+1:])
-								structs = newStructs
-								ss.clients[client] = structs
-							}
-
-							str.Delete(transaction)
-						}
-						index++
-					} else {
-						break
+				if !str.GetDeleted() {
+					if clockEnd < str.GetID().Clock+int64(str.GetLength()) {
+						structs = ss.insertStruct(index, str.SplitItem(transaction, int(clockEnd-str.GetID().Clock)))
 					}
+					str.Delete(transaction)
 				}
-			} else {
-				unappliedDs.Add(client, clock, clockEnd-clock)
 			}
 		}
 	}
 
 	if len(unappliedDs.GetClients()) > 0 {
-		// Create encoder for unapplied delete set
+		// The unapplied deletions are re-encoded and tried again after the next update
 		encoder := NewDSEncoderV2()
 		unappliedDs.Write(encoder)
-
-		// Create decoder from encoded data
-		decoder := NewDSDecoderV2FromBytes(encoder.ToArray())
-		ss.pendingDeleteReaders = append(ss.pendingDeleteReaders, decoder)
+		ss.pendingDeleteReaders = append(ss.pendingDeleteReaders, NewDSDecoderV2FromBytes(encoder.ToArray()))
 	}
 
 	return nil
@@ -553,19 +558,3 @@ func (ss *StructStore) TryResumePendingDeleteReaders(transaction contracts.ITran
 		ss.ReadAndApplyDeleteSet(reader, transaction)
 	}
 }
-
-// TryToMergeWithLeft tries to merge struct with left neighbor
-func TryToMergeWithLeft(structs []contracts.IStructItem, pos int) {
-	if pos <= 0 || pos >= len(structs) {
-		return
-	}
-
-	left := structs[pos-1]
-	right := structs[pos]
-
-	if left.TryToMergeWithRight(right) {
-		// Remove the right item since it was merged
-		copy(structs[pos:], structs[pos+1:])
-		structs = structs[:len(structs)-1]
-	}
-}
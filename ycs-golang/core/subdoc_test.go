@@ -0,0 +1,154 @@
+package core
+
+import (
+	"sort"
+	"testing"
+
+	"ycs/contracts"
+)
+
+// subdocsChanges records the GUIDs of the loaded, added and removed subdocuments
+type subdocsChanges struct {
+	loaded, added, removed []string
+}
+
+func recordSubdocsChanges(doc *YDoc) *subdocsChanges {
+	changes := &subdocsChanges{}
+	guids := func(docs map[contracts.IYDoc]struct{}) (result []string) {
+		for doc := range docs {
+			result = append(result, doc.GetGuid())
+		}
+		sort.Strings(result)
+		return result
+	}
+	doc.OnSubdocsChanged(func(loaded, added, removed map[contracts.IYDoc]struct{}) {
+		changes.loaded = append(changes.loaded, guids(loaded)...)
+		changes.added = append(changes.added, guids(added)...)
+		changes.removed = append(changes.removed, guids(removed)...)
+	})
+	return changes
+}
+
+// replicate applies the state of doc to a new document and returns it
+func replicate(t *testing.T, doc *YDoc) *YDoc {
+	t.Helper()
+	replica := NewYDoc(contracts.YDocOptions{})
+	if err := replica.ApplyUpdateV2(encodeUpdate(t, doc), nil, false); err != nil {
+		t.Fatal(err)
+	}
+	return replica
+}
+
+func TestSubdocLoad(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	doc.GetMap("m").Set("sub", NewYDoc(contracts.YDocOptions{Guid: "sub"}))
+
+	replica := NewYDoc(contracts.YDocOptions{})
+	changes := recordSubdocsChanges(replica)
+	if err := replica.ApplyUpdateV2(encodeUpdate(t, doc), nil, false); err != nil {
+		t.Fatal(err)
+	}
+	subdoc := replica.GetSubdoc("sub").(*YDoc)
+	if subdoc == nil || subdoc.GetShouldLoad() || len(changes.loaded) != 0 || len(changes.added) != 1 {
+		t.Fatalf("subdocument %v, changes %+v", subdoc, changes)
+	}
+
+	subdoc.Load()
+	if !subdoc.GetShouldLoad() || len(changes.loaded) != 1 || changes.loaded[0] != "sub" {
+		t.Fatalf("loaded %v, changes %+v", subdoc.GetShouldLoad(), changes)
+	}
+	// Loading again does not announce the subdocument again
+	subdoc.Load()
+	if len(changes.loaded) != 1 {
+		t.Fatalf("changes %+v", changes)
+	}
+}
+
+func TestSubdocLoadsUnloadedParent(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	parent := NewYDoc(contracts.YDocOptions{Guid: "parent"})
+	doc.GetMap("m").Set("parent", parent)
+	parent.GetMap("m").Set("child", NewYDoc(contracts.YDocOptions{Guid: "child"}))
+
+	replica := replicate(t, doc)
+	replicaParent := replica.GetSubdoc("parent").(*YDoc)
+	if err := replicaParent.ApplyUpdateV2(encodeUpdate(t, parent), nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	replicaParent.GetSubdoc("child").Load()
+	if !replicaParent.GetShouldLoad() {
+		t.Fatal("the parent of a loaded subdocument is not loaded")
+	}
+}
+
+func TestSubdocAutoLoad(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	doc.GetMap("m").Set("auto", NewYDoc(contracts.YDocOptions{Guid: "auto", AutoLoad: true}))
+	doc.GetMap("m").Set("manual", NewYDoc(contracts.YDocOptions{Guid: "manual"}))
+
+	replica := NewYDoc(contracts.YDocOptions{})
+	changes := recordSubdocsChanges(replica)
+	if err := replica.ApplyUpdateV2(encodeUpdate(t, doc), nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes.loaded) != 1 || changes.loaded[0] != "auto" {
+		t.Fatalf("loaded %v", changes.loaded)
+	}
+	if !replica.GetSubdoc("auto").GetShouldLoad() || replica.GetSubdoc("manual").GetShouldLoad() {
+		t.Fatal("only the auto-loaded subdocument should load")
+	}
+}
+
+func TestSubdocDestroy(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	subdoc := NewYDoc(contracts.YDocOptions{Guid: "sub"})
+	doc.GetMap("m").Set("sub", subdoc)
+	subdoc.GetText("t").Insert(0, "hello")
+	changes := recordSubdocsChanges(doc)
+	destroyed := 0
+	subdoc.OnDestroyed(func() { destroyed++ })
+
+	subdoc.Destroy()
+	if destroyed != 1 {
+		t.Fatalf("destroyed handler called %d times", destroyed)
+	}
+
+	// The destroyed document is replaced by an unloaded one with the same GUID
+	replacement := doc.GetMap("m").Get("sub").(*YDoc)
+	if replacement == subdoc || replacement.GetGuid() != "sub" || replacement.GetShouldLoad() {
+		t.Fatalf("replacement %v", replacement)
+	}
+	if doc.GetSubdoc("sub") != contracts.IYDoc(replacement) {
+		t.Fatal("the replacement is not a subdocument")
+	}
+	if len(changes.added) != 1 || len(changes.removed) != 1 || changes.added[0] != "sub" || changes.removed[0] != "sub" {
+		t.Fatalf("changes %+v", changes)
+	}
+}
+
+func TestDestroyingDeletedSubdocRemovesIt(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	subdoc := NewYDoc(contracts.YDocOptions{Guid: "sub"})
+	doc.GetMap("m").Set("sub", subdoc)
+	changes := recordSubdocsChanges(doc)
+
+	doc.GetMap("m").Delete("sub")
+	if len(doc.GetSubdocGuids()) != 0 || len(changes.removed) == 0 || len(changes.added) != 0 {
+		t.Fatalf("subdocuments %v after deleting, changes %+v", doc.GetSubdocGuids(), changes)
+	}
+}
+
+func TestDestroyDestroysSubdocs(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	subdoc := NewYDoc(contracts.YDocOptions{Guid: "sub"})
+	doc.GetMap("m").Set("sub", subdoc)
+	destroyed := false
+	subdoc.OnDestroyed(func() { destroyed = true })
+
+	doc.Destroy()
+	if !destroyed {
+		t.Fatal("the subdocument was not destroyed with its parent")
+	}
+}
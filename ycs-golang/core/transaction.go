@@ -2,6 +2,7 @@ package core
 
 import (
 	"sort"
+	"ycs/content"
 	"ycs/contracts"
 )
 
@@ -198,8 +199,9 @@ func CleanupTransactions(transactionCleanups []contracts.ITransaction, i int) {
 				structs := store.GetClients()[client]
 				firstChangePos := max(FindIndexSS(structs, beforeClock), 1)
 				for j := len(structs) - 1; j >= firstChangePos; j-- {
-					TryToMergeWithLeft(structs, j)
+					structs = TryToMergeWithLeft(structs, j)
 				}
+				store.GetClients()[client] = structs
 			}
 		}
 
@@ -211,12 +213,13 @@ func CleanupTransactions(transactionCleanups []contracts.ITransaction, i int) {
 			replacedStructPos := FindIndexSS(structs, clock)
 
 			if replacedStructPos+1 < len(structs) {
-				TryToMergeWithLeft(structs, replacedStructPos+1)
+				structs = TryToMergeWithLeft(structs, replacedStructPos+1)
 			}
 
 			if replacedStructPos > 0 {
-				TryToMergeWithLeft(structs, replacedStructPos)
+				structs = TryToMergeWithLeft(structs, replacedStructPos)
 			}
+			store.GetClients()[client] = structs
 		}
 
 		if !transaction.GetLocal() {
@@ -230,15 +233,18 @@ func CleanupTransactions(transactionCleanups []contracts.ITransaction, i int) {
 				beforeClock = bc
 			}
 
-			if afterClock != beforeClock {
-				doc.SetClientID(int(generateNewClientID()))
+			// Another peer uses the client ID of this document
+			if afterClock != beforeClock || transaction.GetMeta()[clientIDCollisionMeta] == true {
+				doc.ReassignClientID()
 			}
 		}
 
 		doc.InvokeOnAfterTransactionCleanup(transaction)
 		doc.InvokeUpdateV2(transaction)
 
+		// Subdocuments are edited by the same peer as their parent
 		for subDoc := range transaction.GetSubdocsAdded() {
+			subDoc.SetClientID(doc.GetClientID())
 			doc.GetSubdocs()[subDoc] = struct{}{}
 		}
 
@@ -256,15 +262,15 @@ func CleanupTransactions(transactionCleanups []contracts.ITransaction, i int) {
 			subDoc.Destroy()
 		}
 
-		if len(transactionCleanups) <= i+1 {
+		// Observers may have started new transactions that were appended to the
+		// document's list after it was handed to us, so always re-read it
+		cleanups := doc.GetTransactionCleanups()
+		if len(cleanups) <= i+1 {
 			// Clear transaction cleanups and invoke after all transactions
-			cleanups := doc.GetTransactionCleanups()
-			for len(cleanups) > 0 {
-				cleanups = cleanups[:len(cleanups)-1]
-			}
-			doc.InvokeAfterAllTransactions(transactionCleanups)
+			doc.SetTransactionCleanups(make([]contracts.ITransaction, 0))
+			doc.InvokeAfterAllTransactions(cleanups)
 		} else {
-			CleanupTransactions(transactionCleanups, i+1)
+			CleanupTransactions(cleanups, i+1)
 		}
 	}()
 
@@ -317,8 +323,8 @@ func CleanupTransactions(transactionCleanups []contracts.ITransaction, i int) {
 		doc.InvokeOnAfterTransaction(transaction)
 	})
 
-	// Execute all actions
-	callAll(actions, 0)
+	// Execute all actions; the deep observer action appends more of them
+	callAll(&actions, 0)
 }
 
 // RedoItem redoes the effect of an operation
@@ -332,7 +338,7 @@ func (tr *Transaction) RedoItem(item contracts.IStructItem, redoItems map[contra
 		return store.GetItemCleanStart(tr, *redone)
 	}
 
-	parentItem := item.GetParent().(contracts.IAbstractType).GetItem()
+	parentItem := parentItemOf(item)
 	var left contracts.IStructItem
 	var right contracts.IStructItem
 
@@ -376,7 +382,7 @@ func (tr *Transaction) RedoItem(item contracts.IStructItem, redoItems map[contra
 		// Find next cloned_redo items
 		for left != nil {
 			leftTrace := left
-			for leftTrace != nil && leftTrace.GetParent().(contracts.IAbstractType).GetItem() != parentItem {
+			for leftTrace != nil && parentItemOf(leftTrace) != parentItem {
 				if leftTrace.GetRedone() == nil {
 					leftTrace = nil
 				} else {
@@ -384,7 +390,7 @@ func (tr *Transaction) RedoItem(item contracts.IStructItem, redoItems map[contra
 				}
 			}
 
-			if leftTrace != nil && leftTrace.GetParent().(contracts.IAbstractType).GetItem() == parentItem {
+			if leftTrace != nil && parentItemOf(leftTrace) == parentItem {
 				left = leftTrace
 				break
 			}
@@ -394,7 +400,7 @@ func (tr *Transaction) RedoItem(item contracts.IStructItem, redoItems map[contra
 
 		for right != nil {
 			rightTrace := right
-			for rightTrace != nil && rightTrace.GetParent().(contracts.IAbstractType).GetItem() != parentItem {
+			for rightTrace != nil && parentItemOf(rightTrace) != parentItem {
 				if rightTrace.GetRedone() == nil {
 					rightTrace = nil
 				} else {
@@ -402,7 +408,7 @@ func (tr *Transaction) RedoItem(item contracts.IStructItem, redoItems map[contra
 				}
 			}
 
-			if rightTrace != nil && rightTrace.GetParent().(contracts.IAbstractType).GetItem() == parentItem {
+			if rightTrace != nil && parentItemOf(rightTrace) == parentItem {
 				right = rightTrace
 				break
 			}
@@ -429,9 +435,8 @@ func (tr *Transaction) RedoItem(item contracts.IStructItem, redoItems map[contra
 	var parent interface{}
 	if parentItem == nil {
 		parent = item.GetParent()
-	} else {
-		// This would need proper content type handling
-		parent = parentItem // Simplified
+	} else if contentType, ok := parentItem.GetContent().(*content.ContentType); ok {
+		parent = contentType.GetType()
 	}
 
 	redoneItem := NewStructItem(
@@ -482,24 +487,25 @@ func (tr *Transaction) WriteUpdateMessageFromTransaction(encoder contracts.IUpda
 	return true
 }
 
-// Helper functions
-func callAll(funcs []func(), index int) {
+// parentItemOf returns the item of the type that contains item, or nil
+func parentItemOf(item contracts.IStructItem) contracts.IStructItem {
+	if parent, ok := item.GetParent().(contracts.IAbstractType); ok {
+		return parent.GetItem()
+	}
+	return nil
+}
+
+// callAll calls all funcs, even if one of them panics. Funcs may append to
+// the slice while it is being called. A panic is re-raised after the
+// remaining funcs were called.
+func callAll(funcs *[]func(), index int) {
 	defer func() {
-		if r := recover(); r != nil {
-			if index < len(funcs) {
-				callAll(funcs, index+1)
-			}
+		if index < len(*funcs) {
+			callAll(funcs, index+1)
 		}
 	}()
 
-	for i := index; i < len(funcs); i++ {
-		funcs[i]()
-	}
-}
-
-func max(a, b int) int {
-	if a > b {
-		return a
+	for ; index < len(*funcs); index++ {
+		(*funcs)[index]()
 	}
-	return b
 }
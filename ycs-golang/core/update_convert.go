@@ -0,0 +1,109 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"ycs/contracts"
+	"ycs/lib0"
+)
+
+// ConvertUpdateV1ToV2 converts an update in the version 1 format, which Yjs and
+// the Hocuspocus provider send, to the version 2 format the documents apply. The
+// structs are transcoded without a document, so updates with missing dependencies
+// convert as well.
+func ConvertUpdateV1ToV2(update []byte) (converted []byte, err error) {
+	defer recoverMalformedUpdate(&err)
+
+	encoder := NewUpdateEncoderV2()
+	defer encoder.Close()
+	if err := convertUpdate(NewUpdateDecoderV1(bytes.NewReader(update)), encoder); err != nil {
+		return nil, err
+	}
+	return encoder.ToArray(), nil
+}
+
+// ConvertUpdateV2ToV1 converts an update in the version 2 format to the version 1
+// format, for peers that do not read version 2.
+func ConvertUpdateV2ToV1(update []byte) (converted []byte, err error) {
+	defer recoverMalformedUpdate(&err)
+
+	encoder := NewUpdateEncoderV1()
+	defer encoder.Close()
+	if err := convertUpdate(NewUpdateDecoderV2(bytes.NewReader(update)), encoder); err != nil {
+		return nil, err
+	}
+	return encoder.ToArray(), nil
+}
+
+// recoverMalformedUpdate turns the panic of a decoder into an error. The update
+// comes from the network, a malformed one must not take the process down.
+func recoverMalformedUpdate(err *error) {
+	if rec := recover(); rec != nil {
+		*err = fmt.Errorf("malformed update: %v", rec)
+	}
+}
+
+// convertUpdate reads the structs and the delete set of an update from decoder and
+// writes them to encoder in the same order
+func convertUpdate(decoder contracts.IUpdateDecoder, encoder contracts.IUpdateEncoder) error {
+	reader := decoder.GetReader().(lib0.StreamReader)
+	writer := encoder.GetRestWriter()
+
+	numOfStateUpdates := must(lib0.ReadVarUint(reader))
+	lib0.WriteVarUint(writer, numOfStateUpdates)
+	for i := uint32(0); i < numOfStateUpdates; i++ {
+		numberOfStructs := must(lib0.ReadVarUint(reader))
+		client := decoder.ReadClient()
+		clock := must(lib0.ReadVarUint(reader))
+		lib0.WriteVarUint(writer, numberOfStructs)
+		encoder.WriteClient(client)
+		lib0.WriteVarUint(writer, clock)
+
+		for j := uint32(0); j < numberOfStructs; j++ {
+			if err := convertStruct(decoder, encoder); err != nil {
+				return err
+			}
+		}
+	}
+
+	ds, err := ReadDeleteSet(decoder)
+	if err != nil {
+		return err
+	}
+	return ds.Write(encoder)
+}
+
+// convertStruct transcodes a single struct, in the layout ReadClientStructRefs reads
+func convertStruct(decoder contracts.IUpdateDecoder, encoder contracts.IUpdateEncoder) error {
+	info := decoder.ReadInfo()
+	encoder.WriteInfo(info)
+	if (info & 0x1F) == 0 { // Bits5
+		encoder.WriteLength(decoder.ReadLength())
+		return nil
+	}
+
+	if (info & 0x80) == 0x80 { // Bit8
+		encoder.WriteLeftID(decoder.ReadLeftID())
+	}
+	if (info & 0x40) == 0x40 { // Bit7
+		encoder.WriteRightID(decoder.ReadRightID())
+	}
+	if (info & (0x40 | 0x80)) == 0 {
+		hasParentYKey := decoder.ReadParentInfo()
+		encoder.WriteParentInfo(hasParentYKey)
+		if hasParentYKey {
+			encoder.WriteString(decoder.ReadString())
+		} else {
+			encoder.WriteLeftID(decoder.ReadLeftID())
+		}
+		if (info & 0x20) == 0x20 { // Bit6
+			encoder.WriteString(decoder.ReadString())
+		}
+	}
+
+	content, err := ReadItemContent(decoder, info)
+	if err != nil {
+		return err
+	}
+	return content.(contracts.IContentEx).Write(encoder, 0)
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"ycs/contracts"
+)
+
+// yjsInsertUpdate is the version 1 update Yjs encodes after client 1 inserts "a"
+// into the root text "t"
+var yjsInsertUpdate = []byte{1, 1, 1, 0, 4, 1, 1, 't', 1, 'a', 0}
+
+func TestConvertUpdateV1FromYjs(t *testing.T) {
+	update, err := ConvertUpdateV1ToV2(yjsInsertUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewYDoc(contracts.YDocOptions{})
+	if err := doc.ApplyUpdateV2(update, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.GetText("t").ToString(); got != "a" {
+		t.Fatalf("got %q", got)
+	}
+
+	local := NewYDoc(contracts.YDocOptions{})
+	local.SetClientID(1)
+	local.GetText("t").Insert(0, "a")
+	v1, err := ConvertUpdateV2ToV1(encodeUpdate(t, local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v1, yjsInsertUpdate) {
+		t.Fatalf("got %v, want %v", v1, yjsInsertUpdate)
+	}
+}
+
+func TestConvertUpdateRoundTrip(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	text := doc.GetText("text")
+	text.Insert(0, "hello world")
+	text.Format(0, 5, map[string]interface{}{"bold": true})
+	text.InsertEmbed(5, map[string]interface{}{"image": "a.png"})
+	text.Delete(6, 3)
+	m := doc.GetMap("map")
+	m.Set("a", int64(1))
+	m.Set("b", []byte{1, 2})
+	m.Set("a", "replaced")
+	array := doc.GetArray("array")
+	array.Insert(0, []interface{}{int64(1), "two", NewYMap(nil)})
+	array.Delete(0, 1)
+
+	v1, err := ConvertUpdateV2ToV1(encodeUpdate(t, doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := ConvertUpdateV1ToV2(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := NewYDoc(contracts.YDocOptions{})
+	if err := remote.ApplyUpdateV2(v2, nil); err != nil {
+		t.Fatal(err)
+	}
+	remote.GetText("text")
+	remote.GetMap("map")
+	remote.GetArray("array")
+	if got, want := remote.ToJSON(true), doc.ToJSON(true); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestConvertMalformedUpdate(t *testing.T) {
+	if _, err := ConvertUpdateV1ToV2(yjsInsertUpdate[:7]); err == nil {
+		t.Fatal("no error for a truncated update")
+	}
+	if _, err := ConvertUpdateV2ToV1([]byte{0, 1}); err == nil {
+		t.Fatal("no error for a truncated update")
+	}
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+	"ycs/contracts"
+	"ycs/lib0"
+)
+
+// UpdateDecoderV1 decodes updates encoded by UpdateEncoderV1 or by Yjs in its
+// default format. Like the version 2 decoder it panics on malformed input.
+type UpdateDecoderV1 struct {
+	*DSDecoderV1
+}
+
+// NewUpdateDecoderV1 creates a new UpdateDecoderV1
+func NewUpdateDecoderV1(input io.Reader) *UpdateDecoderV1 {
+	return &UpdateDecoderV1{DSDecoderV1: NewDSDecoderV1(input)}
+}
+
+// ReadLeftID reads a left ID
+func (ud *UpdateDecoderV1) ReadLeftID() contracts.StructID {
+	return ud.readID()
+}
+
+// ReadRightID reads a right ID
+func (ud *UpdateDecoderV1) ReadRightID() contracts.StructID {
+	return ud.readID()
+}
+
+// readID reads a client followed by a clock
+func (ud *UpdateDecoderV1) readID() contracts.StructID {
+	client := must(lib0.ReadVarUint(ud.reader))
+	clock := must(lib0.ReadVarUint(ud.reader))
+	return contracts.StructID{Client: int64(client), Clock: int64(clock)}
+}
+
+// ReadClient reads a client ID
+func (ud *UpdateDecoderV1) ReadClient() int64 {
+	return int64(must(lib0.ReadVarUint(ud.reader)))
+}
+
+// ReadInfo reads info byte
+func (ud *UpdateDecoderV1) ReadInfo() byte {
+	return must(ud.reader.ReadByte())
+}
+
+// ReadString reads a string
+func (ud *UpdateDecoderV1) ReadString() string {
+	return must(lib0.ReadVarString(ud.reader))
+}
+
+// ReadParentInfo reads parent info
+func (ud *UpdateDecoderV1) ReadParentInfo() bool {
+	return must(lib0.ReadVarUint(ud.reader)) == 1
+}
+
+// ReadTypeRef reads a type reference
+func (ud *UpdateDecoderV1) ReadTypeRef() uint32 {
+	return must(lib0.ReadVarUint(ud.reader))
+}
+
+// ReadLength reads a length
+func (ud *UpdateDecoderV1) ReadLength() int {
+	length := must(lib0.ReadVarUint(ud.reader))
+	if length > maxDecodedLength {
+		panic("length out of range")
+	}
+	return int(length)
+}
+
+// ReadKey reads a key
+func (ud *UpdateDecoderV1) ReadKey() string {
+	return ud.ReadString()
+}
+
+// ReadAny reads any data
+func (ud *UpdateDecoderV1) ReadAny() interface{} {
+	return must(lib0.ReadAny(ud.reader))
+}
+
+// ReadBuffer reads a buffer
+func (ud *UpdateDecoderV1) ReadBuffer() []byte {
+	return must(lib0.ReadVarUint8Array(ud.reader))
+}
+
+// ReadEmbed reads an embed object
+func (ud *UpdateDecoderV1) ReadEmbed() interface{} {
+	return ud.ReadJSON()
+}
+
+// ReadJSON reads JSON data
+func (ud *UpdateDecoderV1) ReadJSON() interface{} {
+	jsonString := must(lib0.ReadVarString(ud.reader))
+
+	var result interface{}
+	if err := json.Unmarshal([]byte(jsonString), &result); err != nil {
+		panic(err)
+	}
+	return result
+}
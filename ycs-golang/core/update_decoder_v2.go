@@ -2,15 +2,39 @@ package core
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"ycs/contracts"
-	// "ycs/lib0/decoding" // Temporarily disabled due to API mismatch
+	"ycs/lib0"
+	"ycs/lib0/decoding"
 )
 
+// byteStream is a read-only stream over a byte slice, as the lib0 decoders expect
+type byteStream struct {
+	*bytes.Reader
+}
+
+// Close does nothing; the bytes are released with the stream
+func (byteStream) Close() error {
+	return nil
+}
+
+// toStreamReader returns input as a stream reader, reading it into memory if needed
+func toStreamReader(input io.Reader) lib0.StreamReader {
+	if reader, ok := input.(lib0.StreamReader); ok {
+		return reader
+	}
+	data, err := io.ReadAll(input)
+	if err != nil {
+		panic(err)
+	}
+	return bytes.NewReader(data)
+}
+
 // DSDecoderV2 represents a delete set decoder version 2
 type DSDecoderV2 struct {
 	dsCurVal  int64
-	reader    io.Reader
+	reader    lib0.StreamReader
 	leaveOpen bool
 	disposed  bool
 }
@@ -19,7 +43,7 @@ type DSDecoderV2 struct {
 func NewDSDecoderV2(input io.Reader) *DSDecoderV2 {
 	return &DSDecoderV2{
 		dsCurVal:  0,
-		reader:    input,
+		reader:    toStreamReader(input),
 		leaveOpen: false,
 		disposed:  false,
 	}
@@ -42,24 +66,23 @@ func (dsd *DSDecoderV2) ResetDsCurVal() {
 
 // ReadDsClock reads a delete set clock value
 func (dsd *DSDecoderV2) ReadDsClock() int64 {
-	// TODO: Implement proper decoding when lib0/decoding is fixed
-	// diff := decoding.ReadVarUint(dsd.reader)
-	// dsd.dsCurVal += int64(diff)
-	// if dsd.dsCurVal < 0 {
-	// 	panic("dsCurVal cannot be negative")
-	// }
+	diff, err := lib0.ReadVarUint(dsd.reader)
+	if err != nil {
+		panic(err)
+	}
+	dsd.dsCurVal += int64(diff)
 	return dsd.dsCurVal
 }
 
 // ReadDsLength reads a delete set length value
 func (dsd *DSDecoderV2) ReadDsLength() int64 {
-	// TODO: Implement proper decoding when lib0/decoding is fixed
-	// diff := decoding.ReadVarUint(dsd.reader) + 1
-	// if diff < 0 {
-	// 	panic("diff cannot be negative")
-	// }
-	// dsd.dsCurVal += int64(diff)
-	return 1 // Default value
+	diff, err := lib0.ReadVarUint(dsd.reader)
+	if err != nil {
+		panic(err)
+	}
+	length := int64(diff) + 1
+	dsd.dsCurVal += length
+	return length
 }
 
 // Close closes the decoder
@@ -74,20 +97,21 @@ func (dsd *DSDecoderV2) Close() error {
 	return nil
 }
 
-// UpdateDecoderV2 represents an update decoder version 2
+// UpdateDecoderV2 represents an update decoder version 2.
+// Like the C# decoder it panics on malformed input.
 type UpdateDecoderV2 struct {
 	*DSDecoderV2
 	keys []string
-	// TODO: Add proper decoder fields when lib0/decoding is fixed
-	// keyClockDecoder   *decoding.IntDiffOptRleDecoder
-	// clientDecoder     *decoding.UintOptRleDecoder
-	// leftClockDecoder  *decoding.IntDiffOptRleDecoder
-	// rightClockDecoder *decoding.IntDiffOptRleDecoder
-	// infoDecoder       *decoding.RleDecoder
-	// stringDecoder     *decoding.StringDecoder
-	// parentInfoDecoder *decoding.RleDecoder
-	// typeRefDecoder    *decoding.UintOptRleDecoder
-	// lengthDecoder     *decoding.UintOptRleDecoder
+
+	keyClockDecoder   *decoding.IntDiffOptRleDecoder
+	clientDecoder     *decoding.UintOptRleDecoder
+	leftClockDecoder  *decoding.IntDiffOptRleDecoder
+	rightClockDecoder *decoding.IntDiffOptRleDecoder
+	infoDecoder       *decoding.RleDecoder
+	stringDecoder     *decoding.StringDecoder
+	parentInfoDecoder *decoding.RleDecoder
+	typeRefDecoder    *decoding.UintOptRleDecoder
+	lengthDecoder     *decoding.UintOptRleDecoder
 }
 
 // NewUpdateDecoderV2 creates a new UpdateDecoderV2
@@ -95,94 +119,133 @@ func NewUpdateDecoderV2(input io.Reader) *UpdateDecoderV2 {
 	ud := &UpdateDecoderV2{
 		DSDecoderV2: NewDSDecoderV2(input),
 		keys:        make([]string, 0),
-		// TODO: Initialize proper decoders when lib0/decoding is fixed
 	}
+
+	// Feature flag - currently unused
+	if _, err := ud.reader.ReadByte(); err != nil {
+		panic(err)
+	}
+
+	ud.keyClockDecoder = decoding.NewIntDiffOptRleDecoder(ud.readStream(), false)
+	ud.clientDecoder = decoding.NewUintOptRleDecoder(ud.readStream(), false)
+	ud.leftClockDecoder = decoding.NewIntDiffOptRleDecoder(ud.readStream(), false)
+	ud.rightClockDecoder = decoding.NewIntDiffOptRleDecoder(ud.readStream(), false)
+	ud.infoDecoder = decoding.NewRleDecoder(ud.readStream(), false)
+	ud.stringDecoder = decoding.NewStringDecoder(ud.readStream(), false)
+	ud.parentInfoDecoder = decoding.NewRleDecoder(ud.readStream(), false)
+	ud.typeRefDecoder = decoding.NewUintOptRleDecoder(ud.readStream(), false)
+	ud.lengthDecoder = decoding.NewUintOptRleDecoder(ud.readStream(), false)
 	return ud
 }
 
+// readStream reads the buffer of a sub-decoder
+func (ud *UpdateDecoderV2) readStream() io.ReadSeekCloser {
+	data, err := lib0.ReadVarUint8Array(ud.reader)
+	if err != nil {
+		panic(err)
+	}
+	return byteStream{bytes.NewReader(data)}
+}
+
+// maxDecodedLength bounds the lengths read from an update
+const maxDecodedLength = 1 << 30
+
+// must panics on a decoding error, the way the C# decoder throws
+func must[T any](value T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
 // ReadLeftID reads a left ID
 func (ud *UpdateDecoderV2) ReadLeftID() contracts.StructID {
-	// TODO: Implement proper reading when lib0/decoding is fixed
-	return contracts.StructID{Client: 0, Clock: 0}
+	client := must(ud.clientDecoder.Read())
+	return contracts.NewStructID(int64(client), must(ud.leftClockDecoder.Read()))
 }
 
 // ReadRightID reads a right ID
 func (ud *UpdateDecoderV2) ReadRightID() contracts.StructID {
-	// TODO: Implement proper reading when lib0/decoding is fixed
-	return contracts.StructID{Client: 0, Clock: 0}
+	client := must(ud.clientDecoder.Read())
+	return contracts.NewStructID(int64(client), must(ud.rightClockDecoder.Read()))
 }
 
 // ReadClient reads a client ID
 func (ud *UpdateDecoderV2) ReadClient() int64 {
-	// TODO: Implement proper reading when lib0/decoding is fixed
-	return 0
+	return int64(must(ud.clientDecoder.Read()))
 }
 
 // ReadInfo reads info byte
 func (ud *UpdateDecoderV2) ReadInfo() byte {
-	// TODO: Implement proper reading when lib0/decoding is fixed
-	return 0
+	return must(ud.infoDecoder.Read())
 }
 
 // ReadString reads a string
 func (ud *UpdateDecoderV2) ReadString() string {
-	// TODO: Implement proper reading when lib0/decoding is fixed
-	return ""
+	return must(ud.stringDecoder.Read())
 }
 
 // ReadParentInfo reads parent info
 func (ud *UpdateDecoderV2) ReadParentInfo() bool {
-	// TODO: Implement proper reading when lib0/decoding is fixed
-	return false
+	return must(ud.parentInfoDecoder.Read()) == 1
 }
 
 // ReadTypeRef reads a type reference
 func (ud *UpdateDecoderV2) ReadTypeRef() uint32 {
-	// TODO: Implement proper reading when lib0/decoding is fixed
-	return 0
+	return uint32(must(ud.typeRefDecoder.Read()))
 }
 
 // ReadLength reads a length
 func (ud *UpdateDecoderV2) ReadLength() int {
-	// TODO: Implement proper reading when lib0/decoding is fixed
-	return 0
+	length := must(ud.lengthDecoder.Read())
+	if length > maxDecodedLength {
+		panic("length out of range")
+	}
+	return int(length)
 }
 
 // ReadKey reads a key with caching support
 func (ud *UpdateDecoderV2) ReadKey() string {
-	// TODO: Implement proper reading when lib0/decoding is fixed
-	return ""
+	keyClock := must(ud.keyClockDecoder.Read())
+	if keyClock >= 0 && keyClock < int64(len(ud.keys)) {
+		return ud.keys[keyClock]
+	}
+
+	key := ud.ReadString()
+	ud.keys = append(ud.keys, key)
+	return key
 }
 
 // ReadAny reads any data
 func (ud *UpdateDecoderV2) ReadAny() interface{} {
-	// TODO: Implement proper reading when lib0/decoding is fixed
-	return nil
+	return must(lib0.ReadAny(ud.reader))
 }
 
 // ReadBuffer reads a buffer
 func (ud *UpdateDecoderV2) ReadBuffer() []byte {
-	// TODO: Implement proper reading when lib0/decoding is fixed
-	return []byte{}
+	return must(lib0.ReadVarUint8Array(ud.reader))
 }
 
 // ReadEmbed reads an embed object
 func (ud *UpdateDecoderV2) ReadEmbed() interface{} {
-	// TODO: Implement proper reading when lib0/decoding is fixed
-	return nil
+	return ud.ReadJSON()
 }
 
 // ReadJSON reads JSON data
 func (ud *UpdateDecoderV2) ReadJSON() interface{} {
-	// TODO: Implement proper reading when lib0/decoding is fixed
-	return nil
+	jsonString := must(lib0.ReadVarString(ud.reader))
+
+	var result interface{}
+	if err := json.Unmarshal([]byte(jsonString), &result); err != nil {
+		panic(err)
+	}
+	return result
 }
 
 // Close closes the decoder and all sub-decoders
 func (ud *UpdateDecoderV2) Close() error {
 	if !ud.disposed {
-		// TODO: Close all sub-decoders when lib0/decoding is fixed
-		// Close the base decoder
+		ud.keys = nil
 		ud.DSDecoderV2.Close()
 	}
 	return nil
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"encoding/json"
+	"ycs/contracts"
+	"ycs/lib0"
+)
+
+// UpdateEncoderV1 encodes updates in the version 1 format, which Yjs uses by
+// default. Every value is written to the rest writer in order.
+type UpdateEncoderV1 struct {
+	*DSEncoderV1
+}
+
+// NewUpdateEncoderV1 creates a new UpdateEncoderV1
+func NewUpdateEncoderV1() *UpdateEncoderV1 {
+	return &UpdateEncoderV1{DSEncoderV1: NewDSEncoderV1()}
+}
+
+// WriteLeftID writes a left ID
+func (ue *UpdateEncoderV1) WriteLeftID(id contracts.StructID) {
+	lib0.WriteVarUint(ue.restWriter, uint32(id.Client))
+	lib0.WriteVarUint(ue.restWriter, uint32(id.Clock))
+}
+
+// WriteRightID writes a right ID
+func (ue *UpdateEncoderV1) WriteRightID(id contracts.StructID) {
+	lib0.WriteVarUint(ue.restWriter, uint32(id.Client))
+	lib0.WriteVarUint(ue.restWriter, uint32(id.Clock))
+}
+
+// WriteClient writes a client ID
+func (ue *UpdateEncoderV1) WriteClient(client int64) {
+	lib0.WriteVarUint(ue.restWriter, uint32(client))
+}
+
+// WriteInfo writes info byte
+func (ue *UpdateEncoderV1) WriteInfo(info byte) {
+	ue.restWriter.WriteByte(info)
+}
+
+// WriteString writes a string
+func (ue *UpdateEncoderV1) WriteString(s string) {
+	lib0.WriteVarString(ue.restWriter, s)
+}
+
+// WriteParentInfo writes parent info
+func (ue *UpdateEncoderV1) WriteParentInfo(isYKey bool) {
+	var info uint32
+	if isYKey {
+		info = 1
+	}
+	lib0.WriteVarUint(ue.restWriter, info)
+}
+
+// WriteTypeRef writes a type reference
+func (ue *UpdateEncoderV1) WriteTypeRef(typeRef uint32) {
+	lib0.WriteVarUint(ue.restWriter, typeRef)
+}
+
+// WriteLength writes a length
+func (ue *UpdateEncoderV1) WriteLength(length int) {
+	if length < 0 {
+		panic("length cannot be negative")
+	}
+	lib0.WriteVarUint(ue.restWriter, uint32(length))
+}
+
+// WriteKey writes a key. Version 1 has no key cache, keys are plain strings.
+func (ue *UpdateEncoderV1) WriteKey(key string) {
+	lib0.WriteVarString(ue.restWriter, key)
+}
+
+// WriteAny writes any data
+func (ue *UpdateEncoderV1) WriteAny(data interface{}) {
+	if err := lib0.WriteAny(ue.restWriter, data); err != nil {
+		panic(err)
+	}
+}
+
+// WriteBuffer writes a buffer
+func (ue *UpdateEncoderV1) WriteBuffer(buf []byte) {
+	lib0.WriteVarUint8Array(ue.restWriter, buf)
+}
+
+// WriteJSON writes JSON data
+func (ue *UpdateEncoderV1) WriteJSON(data interface{}) {
+	jsonString, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+	lib0.WriteVarString(ue.restWriter, string(jsonString))
+}
+
+// WriteEmbed writes embedded data
+func (ue *UpdateEncoderV1) WriteEmbed(embed interface{}) {
+	ue.WriteJSON(embed)
+}
@@ -2,9 +2,11 @@ package core
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"ycs/contracts"
-	// "ycs/lib0/encoding" // Temporarily disabled due to API mismatch
+	"ycs/lib0"
+	encoding "ycs/lib0/Encoding"
 )
 
 // DSEncoderV2 represents a delete set encoder version 2
@@ -40,8 +42,7 @@ func (dse *DSEncoderV2) WriteDsClock(clock int64) {
 		panic("clock diff cannot be negative")
 	}
 	dse.dsCurVal = clock
-	// TODO: Implement proper encoding when lib0/encoding is fixed
-	// encoding.WriteVarUint(dse.restWriter, uint64(diff))
+	lib0.WriteVarUint(dse.restWriter, uint32(diff))
 }
 
 // WriteDsLength writes a delete set length value
@@ -49,8 +50,7 @@ func (dse *DSEncoderV2) WriteDsLength(length int64) {
 	if length <= 0 {
 		panic("length must be positive")
 	}
-	// TODO: Implement proper encoding when lib0/encoding is fixed
-	// encoding.WriteVarUint(dse.restWriter, uint64(length-1))
+	lib0.WriteVarUint(dse.restWriter, uint32(length-1))
 	dse.dsCurVal += length
 }
 
@@ -71,113 +71,174 @@ func (dse *DSEncoderV2) Close() error {
 // UpdateEncoderV2 represents an update encoder version 2
 type UpdateEncoderV2 struct {
 	*DSEncoderV2
-	keyClock int
+	// keyClock refers to the next unique key-identifier to be used
+	keyClock int64
 	keyMap   map[string]int
-	// TODO: Add proper encoder fields when lib0/encoding is fixed
-	// keyClockEncoder   *encoding.IntDiffOptRleEncoder
-	// clientEncoder     *encoding.UintOptRleEncoder
-	// leftClockEncoder  *encoding.IntDiffOptRleEncoder
-	// rightClockEncoder *encoding.IntDiffOptRleEncoder
-	// infoEncoder       *encoding.RleEncoder
-	// stringEncoder     *encoding.StringEncoder
-	// parentInfoEncoder *encoding.RleEncoder
-	// typeRefEncoder    *encoding.UintOptRleEncoder
-	// lengthEncoder     *encoding.UintOptRleEncoder
+
+	keyClockEncoder   *encoding.IntDiffOptRleEncoder
+	clientEncoder     *encoding.UintOptRleEncoder
+	leftClockEncoder  *encoding.IntDiffOptRleEncoder
+	rightClockEncoder *encoding.IntDiffOptRleEncoder
+	infoEncoder       *encoding.RleEncoder
+	stringEncoder     *encoding.StringEncoder
+	parentInfoEncoder *encoding.RleEncoder
+	typeRefEncoder    *encoding.UintOptRleEncoder
+	lengthEncoder     *encoding.UintOptRleEncoder
 }
 
 // NewUpdateEncoderV2 creates a new UpdateEncoderV2
 func NewUpdateEncoderV2() *UpdateEncoderV2 {
-	ue := &UpdateEncoderV2{
-		DSEncoderV2: NewDSEncoderV2(),
-		keyClock:    0,
-		keyMap:      make(map[string]int),
-		// TODO: Initialize proper encoders when lib0/encoding is fixed
+	return &UpdateEncoderV2{
+		DSEncoderV2:       NewDSEncoderV2(),
+		keyClock:          0,
+		keyMap:            make(map[string]int),
+		keyClockEncoder:   encoding.NewIntDiffOptRleEncoder(),
+		clientEncoder:     encoding.NewUintOptRleEncoder(),
+		leftClockEncoder:  encoding.NewIntDiffOptRleEncoder(),
+		rightClockEncoder: encoding.NewIntDiffOptRleEncoder(),
+		infoEncoder:       encoding.NewRleEncoder(),
+		stringEncoder:     encoding.NewStringEncoder(),
+		parentInfoEncoder: encoding.NewRleEncoder(),
+		typeRefEncoder:    encoding.NewUintOptRleEncoder(),
+		lengthEncoder:     encoding.NewUintOptRleEncoder(),
 	}
-	return ue
 }
 
 // WriteLeftID writes a left ID
 func (ue *UpdateEncoderV2) WriteLeftID(id contracts.StructID) {
-	// TODO: Implement proper encoding when lib0/encoding is fixed
+	ue.clientEncoder.Write(uint32(id.Client))
+	ue.leftClockEncoder.Write(id.Clock)
 }
 
 // WriteRightID writes a right ID
 func (ue *UpdateEncoderV2) WriteRightID(id contracts.StructID) {
-	// TODO: Implement proper encoding when lib0/encoding is fixed
+	ue.clientEncoder.Write(uint32(id.Client))
+	ue.rightClockEncoder.Write(id.Clock)
 }
 
 // WriteClient writes a client ID
 func (ue *UpdateEncoderV2) WriteClient(client int64) {
-	// TODO: Implement proper encoding when lib0/encoding is fixed
+	ue.clientEncoder.Write(uint32(client))
 }
 
 // WriteInfo writes info byte
 func (ue *UpdateEncoderV2) WriteInfo(info byte) {
-	// TODO: Implement proper encoding when lib0/encoding is fixed
+	ue.infoEncoder.Write(info)
 }
 
 // WriteString writes a string
 func (ue *UpdateEncoderV2) WriteString(s string) {
-	// TODO: Implement proper encoding when lib0/encoding is fixed
+	ue.stringEncoder.Write(s)
 }
 
 // WriteParentInfo writes parent info
 func (ue *UpdateEncoderV2) WriteParentInfo(isYKey bool) {
-	// TODO: Implement proper encoding when lib0/encoding is fixed
+	var info byte
+	if isYKey {
+		info = 1
+	}
+	ue.parentInfoEncoder.Write(info)
 }
 
 // WriteTypeRef writes a type reference
 func (ue *UpdateEncoderV2) WriteTypeRef(typeRef uint32) {
-	// TODO: Implement proper encoding when lib0/encoding is fixed
+	ue.typeRefEncoder.Write(typeRef)
 }
 
 // WriteLength writes a length
 func (ue *UpdateEncoderV2) WriteLength(length int) {
-	// TODO: Implement proper encoding when lib0/encoding is fixed
+	if length < 0 {
+		panic("length cannot be negative")
+	}
+	ue.lengthEncoder.Write(uint32(length))
 }
 
-// WriteKey writes a key with optional caching
+// WriteKey writes a key. Keys are not deduplicated, like in the C# encoder, so
+// every key clock refers to a new string.
 func (ue *UpdateEncoderV2) WriteKey(key string) {
-	// TODO: Implement proper encoding when lib0/encoding is fixed
+	ue.keyClockEncoder.Write(ue.keyClock)
+	ue.keyClock++
+
+	if _, exists := ue.keyMap[key]; !exists {
+		ue.stringEncoder.Write(key)
+	}
 }
 
 // WriteAny writes any data
 func (ue *UpdateEncoderV2) WriteAny(data interface{}) {
-	// TODO: Implement this method
+	if err := lib0.WriteAny(ue.restWriter, data); err != nil {
+		panic(err)
+	}
 }
 
 // WriteBuffer writes a buffer
 func (ue *UpdateEncoderV2) WriteBuffer(buf []byte) {
-	// TODO: Implement this method
+	lib0.WriteVarUint8Array(ue.restWriter, buf)
 }
 
 // WriteJSON writes JSON data
 func (ue *UpdateEncoderV2) WriteJSON(data interface{}) {
-	// TODO: Implement this method
+	jsonString, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+	lib0.WriteVarString(ue.restWriter, string(jsonString))
 }
 
 // WriteEmbed writes embedded data
 func (ue *UpdateEncoderV2) WriteEmbed(embed interface{}) {
-	// TODO: Implement this method
+	ue.WriteJSON(embed)
 }
 
 // ToArray finalizes encoding and returns the complete byte array
 func (ue *UpdateEncoderV2) ToArray() []byte {
-	// Finalize all encoders and write to the main buffer
-	ue.writeEncoders()
-	return ue.DSEncoderV2.ToArray()
-}
-
-// writeEncoders writes all encoder contents to the main buffer
-func (ue *UpdateEncoderV2) writeEncoders() {
-	// TODO: Implement proper encoder writing when lib0/encoding is fixed
+	buf := &bytes.Buffer{}
+
+	// Feature flag that might be used in the future
+	buf.WriteByte(0)
+
+	ue.writeEncoders(buf)
+
+	// The rest of the data is appended as is
+	buf.Write(ue.DSEncoderV2.ToArray())
+	return buf.Bytes()
+}
+
+// writeEncoders writes the contents of all sub-encoders to the buffer
+func (ue *UpdateEncoderV2) writeEncoders(buf *bytes.Buffer) {
+	encoders := []interface{ ToArray() ([]byte, error) }{
+		ue.keyClockEncoder,
+		ue.clientEncoder,
+		ue.leftClockEncoder,
+		ue.rightClockEncoder,
+		ue.infoEncoder,
+		ue.stringEncoder,
+		ue.parentInfoEncoder,
+		ue.typeRefEncoder,
+		ue.lengthEncoder,
+	}
+	for _, encoder := range encoders {
+		data, err := encoder.ToArray()
+		if err != nil {
+			panic(err)
+		}
+		lib0.WriteVarUint8Array(buf, data)
+	}
 }
 
 // Close closes the encoder and all sub-encoders
 func (ue *UpdateEncoderV2) Close() error {
 	if !ue.disposed {
-		// TODO: Close all sub-encoders when lib0/encoding is fixed
-		// Close the base encoder
+		ue.keyMap = nil
+		ue.keyClockEncoder.Dispose()
+		ue.clientEncoder.Dispose()
+		ue.leftClockEncoder.Dispose()
+		ue.rightClockEncoder.Dispose()
+		ue.infoEncoder.Dispose()
+		ue.stringEncoder.Dispose()
+		ue.parentInfoEncoder.Dispose()
+		ue.typeRefEncoder.Dispose()
+		ue.lengthEncoder.Dispose()
 		ue.DSEncoderV2.Close()
 	}
 	return nil
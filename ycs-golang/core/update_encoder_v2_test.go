@@ -0,0 +1,99 @@
+package core
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"ycs/contracts"
+)
+
+func TestUpdateEncoderV2RoundTrip(t *testing.T) {
+	encoder := NewUpdateEncoderV2()
+	encoder.WriteLeftID(contracts.StructID{Client: 7, Clock: 3})
+	encoder.WriteRightID(contracts.StructID{Client: 9, Clock: 12})
+	encoder.WriteClient(42)
+	encoder.WriteInfo(0x84)
+	encoder.WriteString("héllo 😀")
+	encoder.WriteParentInfo(true)
+	encoder.WriteParentInfo(false)
+	encoder.WriteTypeRef(3)
+	encoder.WriteLength(17)
+	encoder.WriteKey("key")
+	encoder.WriteKey("key")
+	encoder.WriteAny(map[string]interface{}{"n": int64(1)})
+	encoder.WriteBuffer([]byte{1, 2, 3})
+	encoder.WriteJSON(map[string]interface{}{"bold": true})
+	data := encoder.ToArray()
+	encoder.Close()
+
+	decoder := NewUpdateDecoderV2(bytes.NewReader(data))
+	defer decoder.Close()
+
+	if got := decoder.ReadLeftID(); got != (contracts.StructID{Client: 7, Clock: 3}) {
+		t.Errorf("left id %v", got)
+	}
+	if got := decoder.ReadRightID(); got != (contracts.StructID{Client: 9, Clock: 12}) {
+		t.Errorf("right id %v", got)
+	}
+	if got := decoder.ReadClient(); got != 42 {
+		t.Errorf("client %d", got)
+	}
+	if got := decoder.ReadInfo(); got != 0x84 {
+		t.Errorf("info %x", got)
+	}
+	if got := decoder.ReadString(); got != "héllo 😀" {
+		t.Errorf("string %q", got)
+	}
+	if !decoder.ReadParentInfo() || decoder.ReadParentInfo() {
+		t.Error("parent info")
+	}
+	if got := decoder.ReadTypeRef(); got != 3 {
+		t.Errorf("type ref %d", got)
+	}
+	if got := decoder.ReadLength(); got != 17 {
+		t.Errorf("length %d", got)
+	}
+	for i := 0; i < 2; i++ {
+		if got := decoder.ReadKey(); got != "key" {
+			t.Errorf("key %q", got)
+		}
+	}
+	if got := decoder.ReadAny(); !reflect.DeepEqual(got, map[string]interface{}{"n": int64(1)}) {
+		t.Errorf("any %#v", got)
+	}
+	if got := decoder.ReadBuffer(); !bytes.Equal(got, []byte{1, 2, 3}) {
+		t.Errorf("buffer %v", got)
+	}
+	if got := decoder.ReadJSON(); !reflect.DeepEqual(got, map[string]interface{}{"bold": true}) {
+		t.Errorf("json %#v", got)
+	}
+}
+
+func TestStateVectorEncodingIsStable(t *testing.T) {
+	sv := map[int64]int64{5: 10, 1: 3, 99: 1, 42: 7}
+
+	encode := func() []byte {
+		encoder := NewDSEncoderV2()
+		defer encoder.Close()
+		if err := WriteStateVector(encoder, sv); err != nil {
+			t.Fatal(err)
+		}
+		return encoder.ToArray()
+	}
+
+	first := encode()
+	for i := 0; i < 20; i++ {
+		if again := encode(); !bytes.Equal(first, again) {
+			t.Fatalf("encoding is not stable: %v != %v", first, again)
+		}
+	}
+
+	decoded, err := DecodeStateVector(bytes.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, sv) {
+		t.Fatalf("got %v, want %v", decoded, sv)
+	}
+}
@@ -1,85 +1,468 @@
 package core
 
 import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"ycs/content"
 	"ycs/contracts"
 )
 
 const YArrayRefID = 0
 
-// YArrayBase represents the base functionality for YArray
+// maxSearchMarkers is the maximum number of search markers kept per type
+const maxSearchMarkers = 80
+
+// globalSearchMarkerTimestamp is assigned to -1, so the first timestamp is 0
+var globalSearchMarkerTimestamp atomic.Int64
+
+func init() {
+	globalSearchMarkerTimestamp.Store(-1)
+}
+
+// ArraySearchMarker remembers the index of an item to speed up positional lookups
+type ArraySearchMarker struct {
+	P         contracts.IStructItem
+	Index     int
+	Timestamp int64
+}
+
+// NewArraySearchMarker creates a new ArraySearchMarker and marks p
+func NewArraySearchMarker(p contracts.IStructItem, index int) *ArraySearchMarker {
+	m := &ArraySearchMarker{
+		P:     p,
+		Index: index,
+	}
+	p.SetMarker(true)
+	m.RefreshTimestamp()
+	return m
+}
+
+// RefreshTimestamp marks the marker as recently used
+func (m *ArraySearchMarker) RefreshTimestamp() {
+	m.Timestamp = globalSearchMarkerTimestamp.Add(1)
+}
+
+// Update moves the marker to a new position
+func (m *ArraySearchMarker) Update(p contracts.IStructItem, index int) {
+	m.P.SetMarker(false)
+	m.P = p
+	p.SetMarker(true)
+	m.Index = index
+	m.RefreshTimestamp()
+}
+
+// ArraySearchMarkerCollection holds the search markers of a type
+type ArraySearchMarkerCollection struct {
+	searchMarkers []*ArraySearchMarker
+}
+
+// Count returns the number of markers
+func (c *ArraySearchMarkerCollection) Count() int {
+	return len(c.searchMarkers)
+}
+
+// Clear removes all markers
+func (c *ArraySearchMarkerCollection) Clear() {
+	c.searchMarkers = c.searchMarkers[:0]
+}
+
+// MarkPosition creates a new marker, or overrides the oldest one when the collection is full
+func (c *ArraySearchMarkerCollection) MarkPosition(p contracts.IStructItem, index int) *ArraySearchMarker {
+	if len(c.searchMarkers) >= maxSearchMarkers {
+		// Override oldest marker (we don't want to create more objects).
+		marker := c.searchMarkers[0]
+		for _, m := range c.searchMarkers[1:] {
+			if m.Timestamp < marker.Timestamp {
+				marker = m
+			}
+		}
+		marker.Update(p, index)
+		return marker
+	}
+
+	pm := NewArraySearchMarker(p, index)
+	c.searchMarkers = append(c.searchMarkers, pm)
+	return pm
+}
+
+// UpdateMarkerChanges updates the marker indices after length items were inserted
+// (length > 0) or deleted (length < 0) at index
+func (c *ArraySearchMarkerCollection) UpdateMarkerChanges(index, length int) {
+	for i := len(c.searchMarkers) - 1; i >= 0; i-- {
+		m := c.searchMarkers[i]
+
+		if length > 0 {
+			p := m.P
+			p.SetMarker(false)
+
+			// Iterate marker to prev undeleted countable position so we know what to do when updating a position.
+			for p != nil && (p.GetDeleted() || !p.GetCountable()) {
+				p = p.GetLeft()
+				if p != nil && !p.GetDeleted() && p.GetCountable() {
+					// Adjust position. The loop should break now.
+					m.Index -= p.GetLength()
+				}
+			}
+
+			if p == nil || p.GetMarker() {
+				// Remove search marker if updated position is nil or if position is already marked.
+				c.searchMarkers = append(c.searchMarkers[:i], c.searchMarkers[i+1:]...)
+				continue
+			}
+
+			m.P = p
+			p.SetMarker(true)
+		}
+
+		// A simple index <= m.Index check would actually suffice.
+		if index < m.Index || (length > 0 && index == m.Index) {
+			m.Index = max(index, m.Index+length)
+		}
+	}
+}
+
+// YArrayBase represents the base functionality for list-like types
 type YArrayBase struct {
 	*AbstractType
-	searchMarkers []interface{} // Placeholder for search markers
+	searchMarkers *ArraySearchMarkerCollection
+	// hasMoves is set once the list contains a move, see yarray_move.go. Snapshots
+	// and events of the list are rendered with moves from then on, as deleted moves
+	// still render the earlier states.
+	hasMoves bool
+	// liveMoves is the number of moves of the list that are not deleted. While there
+	// are any, the current state is rendered with moves and search markers are not used.
+	liveMoves int
 }
 
 // NewYArrayBase creates a new YArrayBase
 func NewYArrayBase() *YArrayBase {
 	return &YArrayBase{
 		AbstractType:  NewAbstractType(),
-		searchMarkers: make([]interface{}, 0),
+		searchMarkers: &ArraySearchMarkerCollection{},
 	}
 }
 
 // ClearSearchMarkers clears search markers
 func (yab *YArrayBase) ClearSearchMarkers() {
-	yab.searchMarkers = yab.searchMarkers[:0]
+	yab.searchMarkers.Clear()
 }
 
-// FindMarker finds a marker (placeholder implementation)
-func (yab *YArrayBase) FindMarker(index int) interface{} {
-	// Placeholder implementation
-	return nil
+// CallObserver clears the search markers for remote changes
+func (yab *YArrayBase) CallObserver(transaction contracts.ITransaction, parentSubs map[string]struct{}) {
+	if !transaction.GetLocal() {
+		yab.searchMarkers.Clear()
+	}
 }
 
-// IsCountable checks if an item is countable
-func (yab *YArrayBase) IsCountable() bool {
-	return true
+// InsertGenerics inserts content at index
+func (yab *YArrayBase) InsertGenerics(transaction contracts.ITransaction, index int, content []interface{}) {
+	if yab.liveMoves > 0 {
+		yab.InsertGenericsAfter(transaction, yab.insertLeftWithMoves(transaction, index), content)
+		return
+	}
+
+	if index == 0 {
+		if yab.searchMarkers.Count() > 0 {
+			yab.searchMarkers.UpdateMarkerChanges(index, len(content))
+		}
+		yab.InsertGenericsAfter(transaction, nil, content)
+		return
+	}
+
+	startIndex := index
+	marker := yab.FindMarker(index)
+	n := yab.GetStart()
+
+	if marker != nil {
+		n = marker.P
+		index -= marker.Index
+
+		// We need to iterate one to the left so that the algorithm works.
+		if index == 0 {
+			n = n.GetPrev()
+			if n != nil && n.GetCountable() && !n.GetDeleted() {
+				index += n.GetLength()
+			}
+		}
+	}
+
+	for ; n != nil; n = n.GetRight() {
+		if !n.GetDeleted() && n.GetCountable() {
+			if index <= n.GetLength() {
+				if index < n.GetLength() {
+					// Insert in-between.
+					transaction.GetDoc().GetStore().GetItemCleanStart(transaction, contracts.StructID{Client: n.GetID().Client, Clock: n.GetID().Clock + int64(index)})
+				}
+				break
+			}
+			index -= n.GetLength()
+		}
+	}
+
+	if yab.searchMarkers.Count() > 0 {
+		yab.searchMarkers.UpdateMarkerChanges(startIndex, len(content))
+	}
+
+	yab.InsertGenericsAfter(transaction, n, content)
 }
 
-// InsertGenerics inserts generic content
-func (yab *YArrayBase) InsertGenerics(transaction contracts.ITransaction, index int, content []interface{}) {
-	// Placeholder implementation
+// InsertGenericsAfter inserts content right after referenceItem. Consecutive JSON
+// values are packed into a single ContentAny.
+func (yab *YArrayBase) InsertGenericsAfter(transaction contracts.ITransaction, referenceItem contracts.IStructItem, values []interface{}) {
+	left := referenceItem
+	insert := func(c contracts.IContent) {
+		left = yab.insertContentAfter(transaction, left, c)
+	}
+
+	var jsonContent []interface{}
+	packJSONContent := func() {
+		if len(jsonContent) > 0 {
+			insert(content.NewContentAny(jsonContent))
+			jsonContent = nil
+		}
+	}
+
+	for _, c := range values {
+		switch v := c.(type) {
+		case []byte:
+			packJSONContent()
+			insert(content.NewContentBinary(v))
+		case contracts.IYDoc:
+			packJSONContent()
+			insert(content.NewContentDoc(v))
+		case contracts.IAbstractType:
+			packJSONContent()
+			insert(content.NewContentType(v))
+		default:
+			jsonContent = append(jsonContent, c)
+		}
+	}
+
+	packJSONContent()
 }
 
-// DeleteRange deletes a range of items
-func (yab *YArrayBase) DeleteRange(transaction contracts.ITransaction, index, length int) {
-	// Placeholder implementation
+// insertContentAfter inserts an item with content c right after left and returns it
+func (yab *YArrayBase) insertContentAfter(transaction contracts.ITransaction, left contracts.IStructItem, c contracts.IContent) contracts.IStructItem {
+	return insertItemAfter(transaction, yab.self, left, nil, c.(contracts.IContentEx))
 }
 
-// internalSlice returns internal slice
-func (yab *YArrayBase) internalSlice(start, end int) []interface{} {
-	result := make([]interface{}, 0)
+// DeleteRange deletes length items starting at index
+func (yab *YArrayBase) DeleteRange(transaction contracts.ITransaction, index, length int) {
+	if length == 0 {
+		return
+	}
+	if yab.liveMoves > 0 {
+		yab.deleteRangeWithMoves(transaction, index, length)
+		return
+	}
+
+	startIndex := index
+	startLength := length
+	marker := yab.FindMarker(index)
 	n := yab.GetStart()
-	currentIndex := 0
 
-	for n != nil && currentIndex < end {
+	if marker != nil {
+		n = marker.P
+		index -= marker.Index
+	}
+
+	// Compute the first item to be deleted.
+	for ; n != nil && index > 0; n = n.GetRight() {
 		if !n.GetDeleted() && n.GetCountable() {
-			itemLength := n.GetLength()
-			if currentIndex+itemLength > start {
-				content := n.GetContent().GetContent()
-				startOffset := 0
-				if currentIndex < start {
-					startOffset = start - currentIndex
-				}
-				endOffset := itemLength
-				if currentIndex+itemLength > end {
-					endOffset = end - currentIndex
-				}
+			if index < n.GetLength() {
+				transaction.GetDoc().GetStore().GetItemCleanStart(transaction, contracts.StructID{Client: n.GetID().Client, Clock: n.GetID().Clock + int64(index)})
+			}
+			index -= n.GetLength()
+		}
+	}
 
-				for i := startOffset; i < endOffset; i++ {
-					if i < len(content) {
-						result = append(result, content[i])
-					}
-				}
+	// Delete all items until done.
+	for length > 0 && n != nil {
+		if !n.GetDeleted() {
+			if length < n.GetLength() {
+				transaction.GetDoc().GetStore().GetItemCleanStart(transaction, contracts.StructID{Client: n.GetID().Client, Clock: n.GetID().Clock + int64(length)})
 			}
-			currentIndex += itemLength
+			n.Delete(transaction)
+			length -= n.GetLength()
 		}
 		n = n.GetRight()
 	}
 
+	if length > 0 {
+		panic("Array length exceeded")
+	}
+
+	if yab.searchMarkers.Count() > 0 {
+		yab.searchMarkers.UpdateMarkerChanges(startIndex, -startLength+length)
+	}
+}
+
+// InternalSlice returns the content between start and end. Negative indices
+// count from the end of the list.
+func (yab *YArrayBase) InternalSlice(start, end int) []interface{} {
+	if start < 0 {
+		start += yab.GetLength()
+	}
+	if end < 0 {
+		end += yab.GetLength()
+	}
+	if start < 0 || end < 0 || start > end {
+		panic(fmt.Sprintf("slice bounds out of range [%d:%d]", start, end))
+	}
+
+	length := end - start
+	cs := make([]interface{}, 0, length)
+	if yab.liveMoves > 0 {
+		for _, s := range sliceSegments(yab.renderList(notDeleted), start, length) {
+			cs = append(cs, s.values()...)
+		}
+		return cs
+	}
+
+	for n := yab.GetStart(); n != nil && length > 0; n = n.GetRight() {
+		if n.GetCountable() && !n.GetDeleted() {
+			c := n.GetContent().GetContent()
+			if len(c) <= start {
+				start -= len(c)
+			} else {
+				for i := start; i < len(c) && length > 0; i++ {
+					cs = append(cs, c[i])
+					length--
+				}
+				start = 0
+			}
+		}
+	}
+
+	return cs
+}
+
+// ForEach calls fun for every value in the list
+func (yab *YArrayBase) ForEach(fun func(value interface{}, index int)) {
+	index := 0
+	if yab.liveMoves > 0 {
+		for _, s := range yab.renderList(notDeleted) {
+			for _, c := range s.values() {
+				fun(c, index)
+				index++
+			}
+		}
+		return
+	}
+	for n := yab.GetStart(); n != nil; n = n.GetRight() {
+		if n.GetCountable() && !n.GetDeleted() {
+			for _, c := range n.GetContent().GetContent() {
+				fun(c, index)
+				index++
+			}
+		}
+	}
+}
+
+// ForEachSnapshot calls fun for every value in the list that is visible in snapshot
+func (yab *YArrayBase) ForEachSnapshot(fun func(value interface{}, index int), snapshot contracts.ISnapshot) {
+	index := 0
+	if yab.hasMoves {
+		visible := func(item contracts.IStructItem) bool {
+			return item.IsVisible(snapshot)
+		}
+		for _, s := range yab.renderList(visible) {
+			for _, c := range s.values() {
+				fun(c, index)
+				index++
+			}
+		}
+		return
+	}
+	for n := yab.GetStart(); n != nil; n = n.GetRight() {
+		if n.GetCountable() && n.IsVisible(snapshot) {
+			for _, c := range n.GetContent().GetContent() {
+				fun(c, index)
+				index++
+			}
+		}
+	}
+}
+
+// EnumerateContent returns the content of all non-deleted items
+func (yab *YArrayBase) EnumerateContent() []interface{} {
+	result := make([]interface{}, 0)
+	for n := yab.GetStart(); n != nil; n = n.GetRight() {
+		if !n.GetDeleted() {
+			result = append(result, n.GetContent().GetContent()...)
+		}
+	}
 	return result
 }
 
+// FindMarker returns a search marker close to index, or nil if searching from
+// the start is just as fast
+func (yab *YArrayBase) FindMarker(index int) *ArraySearchMarker {
+	if yab.GetStart() == nil || index == 0 || yab.liveMoves > 0 || yab.searchMarkers.Count() == 0 {
+		return nil
+	}
+
+	markers := yab.searchMarkers.searchMarkers
+	marker := markers[0]
+	for _, m := range markers[1:] {
+		if abs(index-m.Index) < abs(index-marker.Index) {
+			marker = m
+		}
+	}
+
+	p := marker.P
+	pIndex := marker.Index
+	// We used it, we might need to use it again.
+	marker.RefreshTimestamp()
+
+	// Iterate to right if possible.
+	for p.GetRight() != nil && pIndex < index {
+		if !p.GetDeleted() && p.GetCountable() {
+			if index < pIndex+p.GetLength() {
+				break
+			}
+			pIndex += p.GetLength()
+		}
+		p = p.GetRight()
+	}
+
+	// Iterate to left if necessary (might be that pIndex > index).
+	for p.GetLeft() != nil && pIndex > index {
+		p = p.GetLeft()
+		if !p.GetDeleted() && p.GetCountable() {
+			pIndex -= p.GetLength()
+		}
+	}
+
+	// We want to make sure that p can't be merged with left, because that would screw up everything.
+	// In that case just return what we have (it is most likely the best marker anyway).
+	// Iterate to left until p can't be merged with left.
+	for p.GetLeft() != nil && p.GetLeft().GetID().Client == p.GetID().Client && p.GetLeft().GetID().Clock+int64(p.GetLeft().GetLength()) == p.GetID().Clock {
+		p = p.GetLeft()
+		if !p.GetDeleted() && p.GetCountable() {
+			pIndex -= p.GetLength()
+		}
+	}
+
+	if parent, ok := p.GetParent().(contracts.IAbstractType); ok && abs(marker.Index-pIndex) < parent.GetLength()/maxSearchMarkers {
+		// Adjust existing marker.
+		marker.Update(p, pIndex)
+		return marker
+	}
+
+	// Create a new marker.
+	return yab.searchMarkers.MarkPosition(p, pIndex)
+}
+
+// abs returns the absolute value of x
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
 // YArrayEvent represents an event for YArray changes
 type YArrayEvent struct {
 	*YEvent
@@ -104,6 +487,7 @@ func NewYArray(prelimContent []interface{}) *YArray {
 		YArrayBase:    NewYArrayBase(),
 		prelimContent: make([]interface{}, 0),
 	}
+	ya.setSelf(ya)
 
 	if prelimContent != nil {
 		ya.prelimContent = append(ya.prelimContent, prelimContent...)
@@ -128,8 +512,11 @@ func (ya *YArray) Clone() contracts.IYArray {
 // Integrate integrates the array with a document and item
 func (ya *YArray) Integrate(doc contracts.IYDoc, item contracts.IStructItem) {
 	ya.YArrayBase.Integrate(doc, item)
-	ya.Insert(0, ya.prelimContent)
+	prelimContent := ya.prelimContent
 	ya.prelimContent = nil
+	if len(prelimContent) > 0 {
+		ya.Insert(0, prelimContent)
+	}
 }
 
 // InternalCopy creates an internal copy
@@ -137,19 +524,16 @@ func (ya *YArray) InternalCopy() contracts.IAbstractType {
 	return NewYArray(nil)
 }
 
-// InternalClone creates an internal clone
+// InternalClone creates a preliminary deep copy of the array: nested types are cloned too
 func (ya *YArray) InternalClone() contracts.IAbstractType {
-	arr := NewYArray(nil)
-
-	for _, item := range ya.enumerateList() {
-		if at, ok := item.(contracts.IAbstractType); ok {
-			arr.Add([]interface{}{at.InternalClone()})
-		} else {
-			arr.Add([]interface{}{item})
+	elements := ya.ToArray()
+	for i, element := range elements {
+		if at, ok := element.(contracts.IAbstractType); ok {
+			elements[i] = at.InternalClone()
 		}
 	}
 
-	return arr
+	return NewYArray(elements)
 }
 
 // Write writes the array to an encoder
@@ -170,21 +554,22 @@ func (ya *YArray) CallObserver(transaction contracts.ITransaction, parentSubs ma
 
 // Insert inserts new content at an index
 func (ya *YArray) Insert(index int, content []interface{}) {
+	for _, value := range content {
+		checkInsertable(value)
+	}
 	if ya.GetDoc() != nil {
-		ya.GetDoc().Transact(func(tr contracts.ITransaction) {
+		transact(ya.GetDoc(), func(tr contracts.ITransaction) {
 			ya.InsertGenerics(tr, index, content)
 		}, nil, true)
 	} else {
-		// Insert into preliminary content
 		if index > len(ya.prelimContent) {
-			index = len(ya.prelimContent)
+			panic("Index out of range")
 		}
 
-		// Extend slice if needed
-		newContent := make([]interface{}, len(ya.prelimContent)+len(content))
-		copy(newContent[:index], ya.prelimContent[:index])
-		copy(newContent[index:index+len(content)], content)
-		copy(newContent[index+len(content):], ya.prelimContent[index:])
+		newContent := make([]interface{}, 0, len(ya.prelimContent)+len(content))
+		newContent = append(newContent, ya.prelimContent[:index]...)
+		newContent = append(newContent, content...)
+		newContent = append(newContent, ya.prelimContent[index:]...)
 		ya.prelimContent = newContent
 	}
 }
@@ -206,59 +591,149 @@ func (ya *YArray) Delete(index int, length ...int) {
 		deleteLength = length[0]
 	}
 
-	if deleteLength <= 0 {
-		return
-	}
-
 	if ya.GetDoc() != nil {
-		ya.GetDoc().Transact(func(tr contracts.ITransaction) {
+		transact(ya.GetDoc(), func(tr contracts.ITransaction) {
 			ya.DeleteRange(tr, index, deleteLength)
 		}, nil, true)
 	} else {
-		if index < 0 || index >= len(ya.prelimContent) {
-			return
-		}
-
 		end := index + deleteLength
-		if end > len(ya.prelimContent) {
-			end = len(ya.prelimContent)
+		if index < 0 || deleteLength < 0 || end > len(ya.prelimContent) {
+			panic("Array length exceeded")
 		}
 
-		newContent := make([]interface{}, 0, len(ya.prelimContent)-(end-index))
+		newContent := make([]interface{}, 0, len(ya.prelimContent)-deleteLength)
 		newContent = append(newContent, ya.prelimContent[:index]...)
 		newContent = append(newContent, ya.prelimContent[end:]...)
 		ya.prelimContent = newContent
 	}
 }
 
-// Slice returns a slice of the array
+// SetFromArray changes the array to values with as few inserts and deletes as
+// possible, in one transaction. Elements are matched by the keys that key returns;
+// matched elements are kept as they are, including nested types, so changes of their
+// content are up to the caller. Without key, elements are matched if their values
+// are deeply equal.
+func (ya *YArray) SetFromArray(values []interface{}, key func(value interface{}) string) {
+	for _, value := range values {
+		checkInsertable(value)
+	}
+
+	setFromArray := func() {
+		current := ya.ToArray()
+		equal := func(i, j int) bool {
+			return reflect.DeepEqual(current[i], values[j])
+		}
+		if key != nil {
+			currentKeys := make([]string, len(current))
+			for i, value := range current {
+				currentKeys[i] = key(value)
+			}
+			keys := make([]string, len(values))
+			for j, value := range values {
+				keys[j] = key(value)
+			}
+			equal = func(i, j int) bool {
+				return currentKeys[i] == keys[j]
+			}
+		}
+
+		// Later hunks are applied first, so the indexes of earlier ones stay valid
+		hunks := diffSequences(len(current), len(values), equal)
+		for h := len(hunks) - 1; h >= 0; h-- {
+			hunk := hunks[h]
+			if hunk.oldEnd > hunk.oldStart {
+				ya.Delete(hunk.oldStart, hunk.oldEnd-hunk.oldStart)
+			}
+			if hunk.newEnd > hunk.newStart {
+				ya.Insert(hunk.oldStart, values[hunk.newStart:hunk.newEnd])
+			}
+		}
+	}
+
+	if ya.GetDoc() == nil {
+		setFromArray()
+		return
+	}
+	transact(ya.GetDoc(), func(tr contracts.ITransaction) {
+		setFromArray()
+	}, nil, true)
+}
+
+// Move moves the element at index from so that it ends up at index to
+func (ya *YArray) Move(from, to int) {
+	ya.MoveRange(from, 1, to)
+}
+
+// MoveRange moves length elements starting at from so that they start at index to
+// of the resulting array. Unlike deleting and inserting them again, moving keeps the
+// elements, so nested types and concurrent changes to them are kept too. If peers
+// move the same element concurrently, all of them end up with the element at the
+// same place.
+func (ya *YArray) MoveRange(from, length, to int) {
+	if ya.GetDoc() != nil {
+		transact(ya.GetDoc(), func(tr contracts.ITransaction) {
+			ya.moveElements(tr, from, length, to)
+		}, nil, true)
+		return
+	}
+
+	if from < 0 || length < 0 || from+length > len(ya.prelimContent) || to < 0 || to > len(ya.prelimContent)-length {
+		panic("Index out of range")
+	}
+	moved := append([]interface{}(nil), ya.prelimContent[from:from+length]...)
+	rest := append(append([]interface{}(nil), ya.prelimContent[:from]...), ya.prelimContent[from+length:]...)
+	newContent := make([]interface{}, 0, len(ya.prelimContent))
+	newContent = append(newContent, rest[:to]...)
+	newContent = append(newContent, moved...)
+	newContent = append(newContent, rest[to:]...)
+	ya.prelimContent = newContent
+}
+
+// Slice returns a slice of the array. The optional second argument is the end index.
 func (ya *YArray) Slice(start ...int) []interface{} {
 	startIndex := 0
 	if len(start) > 0 {
 		startIndex = start[0]
 	}
-	return ya.internalSlice(startIndex, ya.GetLength())
+	endIndex := ya.GetLength()
+	if len(start) > 1 {
+		endIndex = start[1]
+	}
+	if ya.prelimContent != nil {
+		return append([]interface{}(nil), ya.prelimContent[startIndex:endIndex]...)
+	}
+	return ya.InternalSlice(startIndex, endIndex)
 }
 
 // Get returns the element at the specified index
 func (ya *YArray) Get(index int) interface{} {
+	if ya.prelimContent != nil {
+		return ya.prelimContent[index]
+	}
+
+	if ya.liveMoves > 0 {
+		parts := sliceSegments(ya.renderList(notDeleted), index, 1)
+		if len(parts) == 0 {
+			return nil
+		}
+		return parts[0].values()[0]
+	}
+
 	marker := ya.FindMarker(index)
 	n := ya.GetStart()
 
 	if marker != nil {
-		// This is a placeholder - actual marker implementation would be needed
-		// n = marker.GetP()
-		// index -= marker.GetIndex()
+		n = marker.P
+		index -= marker.Index
 	}
 
-	for n != nil {
+	for ; n != nil; n = n.GetRight() {
 		if !n.GetDeleted() && n.GetCountable() {
 			if index < n.GetLength() {
 				return n.GetContent().GetContent()[index]
 			}
 			index -= n.GetLength()
 		}
-		n = n.GetRight()
 	}
 
 	return nil
@@ -266,25 +741,36 @@ func (ya *YArray) Get(index int) interface{} {
 
 // ToArray converts the array to a Go slice
 func (ya *YArray) ToArray() []interface{} {
-	result := make([]interface{}, 0)
-	for _, item := range ya.enumerateList() {
-		result = append(result, item)
+	if ya.prelimContent != nil {
+		return append([]interface{}{}, ya.prelimContent...)
 	}
+
+	result := make([]interface{}, 0, ya.GetLength())
+	ya.ForEach(func(value interface{}, _ int) {
+		result = append(result, value)
+	})
 	return result
 }
 
-// enumerateList enumerates all items in the list
-func (ya *YArray) enumerateList() []interface{} {
+// ToArrayAt returns the elements the array had at snapshot. The history is read in
+// place, so the document must not be garbage collected. Nested types are returned as
+// they are now; read them with their own snapshot methods.
+func (ya *YArray) ToArrayAt(snapshot contracts.ISnapshot) []interface{} {
 	result := make([]interface{}, 0)
-	n := ya.GetStart()
-
-	for n != nil {
-		if n.GetCountable() && !n.GetDeleted() {
-			content := n.GetContent().GetContent()
-			result = append(result, content...)
-		}
-		n = n.GetRight()
+	if ya.prelimContent != nil {
+		return result
 	}
 
+	ya.viewSnapshot(snapshot, func() {
+		ya.ForEachSnapshot(func(value interface{}, _ int) {
+			result = append(result, value)
+		}, snapshot)
+	})
 	return result
 }
+
+// ToJSON returns the elements of the array with nested shared types rendered for
+// encoding/json. Texts render as strings, or as deltas if textAsDelta is set.
+func (ya *YArray) ToJSON(textAsDelta ...bool) interface{} {
+	return jsonValue(ya.ToArray(), textAsDelta)
+}
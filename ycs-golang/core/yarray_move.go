@@ -0,0 +1,547 @@
+package core
+
+import (
+	"sort"
+
+	"ycs/content"
+	"ycs/contracts"
+)
+
+// Moves are not applied to the items of a list. Instead, every element is rendered
+// at the live move with the highest priority whose range contains it, or at its own
+// position if there is none. Which move renders an element only depends on the
+// document, so peers that received the same moves in any order render the same list.
+//
+// This differs from the experimental moves of Yjs, whose encoding the moves share.
+// Yjs records the winning move of every element in item.moved as moves integrate;
+// this port keeps no such field and finds the winners whenever a list with live
+// moves is read or changed, which costs time linear in the list and in the ranges
+// of its live moves. To keep that small, a peer that moves or deletes elements also
+// deletes the moves that render nothing afterwards, so lists without live moves are
+// handled like lists that never had any. The priority of a new move beats only the
+// moves that claim its elements and stay live, so it is bounded by the number of
+// live moves instead of growing with every move of the same element.
+
+// listSegment is a run of elements of one item in the order the list renders them
+type listSegment struct {
+	item   contracts.IStructItem
+	offset int
+	length int
+	// move is the move item that renders the elements, nil if they are rendered at
+	// their own position
+	move contracts.IStructItem
+}
+
+// id returns the ID of the first element of the segment
+func (s listSegment) id() contracts.StructID {
+	id := s.item.GetID()
+	return contracts.StructID{Client: id.Client, Clock: id.Clock + int64(s.offset)}
+}
+
+// values returns the elements of the segment
+func (s listSegment) values() []interface{} {
+	return s.item.GetContent().GetContent()[s.offset : s.offset+s.length]
+}
+
+// moveClaim is a part of an item that a move contains
+type moveClaim struct {
+	item   contracts.IStructItem
+	lo, hi int
+	move   contracts.IStructItem
+}
+
+// MarkMoved tells the list that a move was added to it. Search markers cannot skip
+// over moved elements, so they are not used while the list has live moves.
+func (yab *YArrayBase) MarkMoved() {
+	yab.hasMoves = true
+	yab.liveMoves++
+	yab.searchMarkers.Clear()
+}
+
+// UnmarkMoved tells the list that one of its moves was deleted
+func (yab *YArrayBase) UnmarkMoved() {
+	yab.liveMoves--
+}
+
+// notDeleted is the visibility of the current state of a list
+func notDeleted(item contracts.IStructItem) bool {
+	return !item.GetDeleted()
+}
+
+// renderList returns the elements of the items for which visible is true in the
+// order the list renders them. Only moves for which visible is true are applied.
+func (yab *YArrayBase) renderList(visible func(item contracts.IStructItem) bool) []listSegment {
+	var segments []listSegment
+	var moves []contracts.IStructItem
+	if yab.hasMoves {
+		for n := yab.GetStart(); n != nil; n = n.GetRight() {
+			if _, ok := n.GetContent().(*content.ContentMove); ok && visible(n) {
+				moves = append(moves, n)
+			}
+		}
+	}
+
+	if len(moves) == 0 {
+		for n := yab.GetStart(); n != nil; n = n.GetRight() {
+			if n.GetCountable() && visible(n) {
+				segments = append(segments, listSegment{item: n, length: n.GetLength()})
+			}
+		}
+		return segments
+	}
+
+	// Find the winning move of every claimed part of an item
+	ranges := make(map[contracts.IStructItem][]moveClaim, len(moves))
+	claims := make(map[contracts.IStructItem][]moveClaim)
+	for _, m := range moves {
+		parts := yab.moveRange(m)
+		ranges[m] = parts
+		for _, part := range parts {
+			claims[part.item] = append(claims[part.item], part)
+		}
+	}
+	owners := make(map[contracts.IStructItem][]moveClaim, len(claims))
+	for item, itemClaims := range claims {
+		owners[item] = resolveClaims(item, itemClaims)
+	}
+
+	for n := yab.GetStart(); n != nil; n = n.GetRight() {
+		if !visible(n) {
+			continue
+		}
+		if _, ok := n.GetContent().(*content.ContentMove); ok {
+			for _, part := range ranges[n] {
+				if !visible(part.item) {
+					continue
+				}
+				for _, owner := range owners[part.item] {
+					if owner.move == n {
+						segments = append(segments, listSegment{item: owner.item, offset: owner.lo, length: owner.hi - owner.lo, move: n})
+					}
+				}
+			}
+			continue
+		}
+		if !n.GetCountable() {
+			continue
+		}
+
+		itemOwners, claimed := owners[n]
+		if !claimed {
+			segments = append(segments, listSegment{item: n, length: n.GetLength()})
+			continue
+		}
+		for _, owner := range itemOwners {
+			if owner.move == nil {
+				segments = append(segments, listSegment{item: n, offset: owner.lo, length: owner.hi - owner.lo})
+			}
+		}
+	}
+	return segments
+}
+
+// moveRange returns the parts of the countable items of the list that a move
+// contains, in list order. Ranges whose ends were garbage collected or that do not
+// belong to this list contain nothing.
+func (yab *YArrayBase) moveRange(m contracts.IStructItem) []moveClaim {
+	c := m.GetContent().(*content.ContentMove)
+	store := yab.GetDoc().GetStore()
+	start, startAssoc := c.GetStart()
+	end, endAssoc := c.GetEnd()
+
+	first, err := store.Find(start)
+	if err != nil || first.IsGC() || first.GetParent() != yab.self {
+		return nil
+	}
+	last, err := store.Find(end)
+	if err != nil || last.IsGC() || last.GetParent() != yab.self {
+		return nil
+	}
+
+	lo := int(start.Clock - first.GetID().Clock)
+	if startAssoc < 0 {
+		lo++
+	}
+	hi := int(end.Clock-last.GetID().Clock) + 1
+	if endAssoc >= 0 {
+		hi--
+	}
+
+	var parts []moveClaim
+	for n := first; n != nil; n = n.GetRight() {
+		from, to := 0, n.GetLength()
+		if n == first {
+			from = lo
+		}
+		if n == last {
+			to = hi
+		}
+		if _, isMove := n.GetContent().(*content.ContentMove); !isMove && n.GetCountable() && from < to {
+			parts = append(parts, moveClaim{item: n, lo: from, hi: to, move: m})
+		}
+		if n == last {
+			return parts
+		}
+	}
+	// The range ends before it starts
+	return nil
+}
+
+// resolveClaims splits an item into parts that are rendered by the same move. The
+// parts cover the whole item; unclaimed parts have no move.
+func resolveClaims(item contracts.IStructItem, claims []moveClaim) []moveClaim {
+	bounds := []int{0, item.GetLength()}
+	for _, c := range claims {
+		bounds = append(bounds, c.lo, c.hi)
+	}
+	sort.Ints(bounds)
+
+	var owners []moveClaim
+	for i := 1; i < len(bounds); i++ {
+		lo, hi := bounds[i-1], bounds[i]
+		if lo == hi {
+			continue
+		}
+		var winner contracts.IStructItem
+		for _, c := range claims {
+			if c.lo <= lo && hi <= c.hi && (winner == nil || movePrecedes(winner, c.move)) {
+				winner = c.move
+			}
+		}
+		if len(owners) > 0 && owners[len(owners)-1].move == winner {
+			owners[len(owners)-1].hi = hi
+		} else {
+			owners = append(owners, moveClaim{item: item, lo: lo, hi: hi, move: winner})
+		}
+	}
+	return owners
+}
+
+// movePrecedes returns whether the move a loses against the move b
+func movePrecedes(a, b contracts.IStructItem) bool {
+	pa := a.GetContent().(*content.ContentMove).GetPriority()
+	pb := b.GetContent().(*content.ContentMove).GetPriority()
+	if pa != pb {
+		return pa < pb
+	}
+	ida, idb := a.GetID(), b.GetID()
+	if ida.Client != idb.Client {
+		return ida.Client < idb.Client
+	}
+	return ida.Clock < idb.Clock
+}
+
+// sliceSegments returns the parts of the segments that render the elements from
+// index to index+length. The result is shorter if the list is.
+func sliceSegments(segments []listSegment, index, length int) []listSegment {
+	var result []listSegment
+	for _, s := range segments {
+		if length <= 0 {
+			break
+		}
+		if index >= s.length {
+			index -= s.length
+			continue
+		}
+		n := min(s.length-index, length)
+		result = append(result, listSegment{item: s.item, offset: s.offset + index, length: n, move: s.move})
+		length -= n
+		index = 0
+	}
+	return result
+}
+
+// segmentsLength returns the number of elements of the segments
+func segmentsLength(segments []listSegment) int {
+	length := 0
+	for _, s := range segments {
+		length += s.length
+	}
+	return length
+}
+
+// insertLeftWithMoves returns the item after which content inserted at index is
+// rendered at index, splitting items if needed. Content inserted at the end of the
+// elements of a move is inserted after the move item, content inserted in the
+// middle of them is inserted into its range.
+func (yab *YArrayBase) insertLeftWithMoves(transaction contracts.ITransaction, index int) contracts.IStructItem {
+	if index == 0 {
+		return nil
+	}
+
+	segments := yab.renderList(notDeleted)
+	i, offset := findSegment(segments, index-1)
+	s := segments[i]
+	if s.move != nil && offset == s.length-1 && (i+1 == len(segments) || segments[i+1].move != s.move) {
+		return s.move
+	}
+	id := s.id()
+	return transaction.GetDoc().GetStore().GetItemCleanEnd(transaction, contracts.StructID{Client: id.Client, Clock: id.Clock + int64(offset)})
+}
+
+// findSegment returns the segment that renders the element at index and the offset
+// of the element in it
+func findSegment(segments []listSegment, index int) (int, int) {
+	for i, s := range segments {
+		if index < s.length {
+			return i, index
+		}
+		index -= s.length
+	}
+	panic("Index out of range")
+}
+
+// deleteRangeWithMoves deletes length elements starting at index
+func (yab *YArrayBase) deleteRangeWithMoves(transaction contracts.ITransaction, index, length int) {
+	segments := yab.renderList(notDeleted)
+	parts := sliceSegments(segments, index, length)
+	if segmentsLength(parts) < length {
+		panic("Array length exceeded")
+	}
+
+	for m := range yab.supersededMoves(segments, parts) {
+		m.Delete(transaction)
+	}
+
+	store := transaction.GetDoc().GetStore()
+	for _, part := range parts {
+		id := part.id()
+		item := store.GetItemCleanStart(transaction, id)
+		store.GetItemCleanEnd(transaction, contracts.StructID{Client: id.Client, Clock: id.Clock + int64(part.length) - 1})
+		item.Delete(transaction)
+	}
+}
+
+// moveElements moves length elements starting at from so that they start at index to
+// of the resulting list
+func (yab *YArrayBase) moveElements(transaction contracts.ITransaction, from, length, to int) {
+	listLength := yab.GetLength()
+	if from < 0 || length < 0 || from+length > listLength || to < 0 || to > listLength-length {
+		panic("Index out of range")
+	}
+	if length == 0 || from == to {
+		return
+	}
+
+	segments := yab.renderList(notDeleted)
+	parts := sliceSegments(segments, from, length)
+
+	index := to
+	if to > from {
+		index = to + length
+	}
+
+	// Moves are not moved themselves, so the moved elements go after the move item of
+	// the elements they are moved between, followed by the rest of its elements
+	var left contracts.IStructItem
+	var leftElement contracts.StructID
+	if index > 0 {
+		i, offset := findSegment(segments, index-1)
+		left = segments[i].move
+		if left == nil {
+			id := segments[i].id()
+			leftElement = contracts.StructID{Client: id.Client, Clock: id.Clock + int64(offset)}
+		} else {
+			blockEnd := index - 1 - offset + segments[i].length
+			for j := i + 1; j < len(segments) && segments[j].move == left; j++ {
+				blockEnd += segments[j].length
+			}
+			if end := min(from, blockEnd); index < end {
+				parts = append(parts, sliceSegments(segments, index, end-index)...)
+			}
+			if start := max(from+length, index); start < blockEnd {
+				parts = append(parts, sliceSegments(segments, start, blockEnd-start)...)
+			}
+		}
+	}
+
+	// Every move contains elements that follow each other in the list, apart from
+	// deleted ones
+	type span struct{ start, end contracts.StructID }
+	var spans []span
+	for i, part := range parts {
+		id := part.id()
+		last := contracts.StructID{Client: id.Client, Clock: id.Clock + int64(part.length) - 1}
+		if i > 0 && followsInList(parts[i-1], part) {
+			spans[len(spans)-1].end = last
+		} else {
+			spans = append(spans, span{start: id, end: last})
+		}
+	}
+
+	// The new moves win against the moves that claim their elements and stay live;
+	// the moves that only rendered the moved elements are deleted
+	superseded := yab.supersededMoves(segments, parts)
+	priority := min(yab.claimPriority(parts, superseded), content.MaxMovePriority)
+	for m := range superseded {
+		m.Delete(transaction)
+	}
+
+	// Items are split once the spans do not need the segments anymore
+	if index > 0 && left == nil {
+		left = transaction.GetDoc().GetStore().GetItemCleanEnd(transaction, leftElement)
+	}
+	for _, s := range spans {
+		left = yab.insertContentAfter(transaction, left, content.NewContentMove(s.start, s.end, priority))
+	}
+}
+
+// supersededMoves returns the live moves that render nothing once the elements of
+// parts, which are segments of the list, are moved or deleted: the moves that render
+// only elements of parts, and those that render nothing already because moves with a
+// higher priority took over their elements or their elements were deleted. Moves
+// whose range has not been received yet are kept, they may render elements later.
+func (yab *YArrayBase) supersededMoves(segments, parts []listSegment) map[contracts.IStructItem]struct{} {
+	rendered := make(map[contracts.IStructItem]int)
+	for _, s := range segments {
+		if s.move != nil {
+			rendered[s.move] += s.length
+		}
+	}
+	for _, p := range parts {
+		if p.move != nil {
+			rendered[p.move] -= p.length
+		}
+	}
+
+	store := yab.GetDoc().GetStore()
+	superseded := make(map[contracts.IStructItem]struct{})
+	for n := yab.GetStart(); n != nil; n = n.GetRight() {
+		c, ok := n.GetContent().(*content.ContentMove)
+		if !ok || n.GetDeleted() || rendered[n] > 0 {
+			continue
+		}
+		start, _ := c.GetStart()
+		end, _ := c.GetEnd()
+		if store.GetState(start.Client) > start.Clock && store.GetState(end.Client) > end.Clock {
+			superseded[n] = struct{}{}
+		}
+	}
+	return superseded
+}
+
+// claimPriority returns the priority a move of the elements of parts needs to win
+// against the live moves whose ranges contain any of them, apart from the moves in except
+func (yab *YArrayBase) claimPriority(parts []listSegment, except map[contracts.IStructItem]struct{}) int {
+	moved := make(map[contracts.IStructItem][]listSegment, len(parts))
+	for _, p := range parts {
+		moved[p.item] = append(moved[p.item], p)
+	}
+
+	priority := 0
+	for n := yab.GetStart(); n != nil; n = n.GetRight() {
+		c, ok := n.GetContent().(*content.ContentMove)
+		if !ok || n.GetDeleted() || c.GetPriority() < priority {
+			continue
+		}
+		if _, ok := except[n]; ok {
+			continue
+		}
+		for _, claim := range yab.moveRange(n) {
+			for _, p := range moved[claim.item] {
+				if claim.lo < p.offset+p.length && p.offset < claim.hi {
+					priority = c.GetPriority() + 1
+				}
+			}
+		}
+	}
+	return priority
+}
+
+// followsInList returns whether the elements of b follow the elements of a in the
+// list, with only deleted items in between
+func followsInList(a, b listSegment) bool {
+	if a.item == b.item {
+		return a.offset+a.length == b.offset
+	}
+	if a.offset+a.length != a.item.GetLength() || b.offset != 0 {
+		return false
+	}
+	n := a.item.GetRight()
+	for n != nil && n != b.item && n.GetDeleted() {
+		n = n.GetRight()
+	}
+	return n == b.item
+}
+
+// moveDelta computes the delta of a list with moves by comparing the elements
+// before and after the transaction of the event
+func (yab *YArrayBase) moveDelta(ye *YEvent) []contracts.Delta {
+	before := yab.renderList(func(item contracts.IStructItem) bool {
+		return !ye.adds(item) && (!item.GetDeleted() || ye.deletes(item))
+	})
+	after := yab.renderList(notDeleted)
+
+	beforeIDs := segmentIDs(before)
+	afterIDs := segmentIDs(after)
+	prefix := 0
+	for prefix < len(beforeIDs) && prefix < len(afterIDs) && beforeIDs[prefix] == afterIDs[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(beforeIDs)-prefix && suffix < len(afterIDs)-prefix && beforeIDs[len(beforeIDs)-1-suffix] == afterIDs[len(afterIDs)-1-suffix] {
+		suffix++
+	}
+
+	var delta []contracts.Delta
+	if prefix > 0 {
+		retain := prefix
+		delta = append(delta, contracts.Delta{Retain: &retain})
+	}
+	if deleted := len(beforeIDs) - prefix - suffix; deleted > 0 {
+		delta = append(delta, contracts.Delta{Delete: &deleted})
+	}
+	if inserted := len(afterIDs) - prefix - suffix; inserted > 0 {
+		values := make([]interface{}, 0, inserted)
+		for _, s := range sliceSegments(after, prefix, inserted) {
+			values = append(values, s.values()...)
+		}
+		delta = append(delta, contracts.Delta{Insert: values})
+	}
+	if len(delta) > 0 && delta[len(delta)-1].Retain != nil {
+		delta = delta[:len(delta)-1]
+	}
+	return delta
+}
+
+// segmentIDs returns the ID of every element of the segments
+func segmentIDs(segments []listSegment) []contracts.StructID {
+	ids := make([]contracts.StructID, 0, segmentsLength(segments))
+	for _, s := range segments {
+		id := s.id()
+		for i := 0; i < s.length; i++ {
+			ids = append(ids, contracts.StructID{Client: id.Client, Clock: id.Clock + int64(i)})
+		}
+	}
+	return ids
+}
+
+// reorderElements moves the elements of the list into the order of want. key maps
+// the ID of an element to the ID it has in want.
+func (yab *YArrayBase) reorderElements(transaction contracts.ITransaction, want []contracts.StructID, key func(id contracts.StructID) contracts.StructID) {
+	for i := 0; i < len(want); i++ {
+		ids := segmentIDs(yab.renderList(notDeleted))
+		if len(ids) != len(want) {
+			return
+		}
+		if key(ids[i]) == want[i] {
+			continue
+		}
+
+		j := i + 1
+		for j < len(ids) && key(ids[j]) != want[i] {
+			j++
+		}
+		if j == len(ids) {
+			return
+		}
+
+		// Elements that already follow each other are moved together
+		length := 1
+		for j+length < len(ids) && i+length < len(want) && key(ids[j+length]) == want[i+length] {
+			length++
+		}
+		yab.moveElements(transaction, j, length, i)
+		i += length - 1
+	}
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"ycs/content"
+	"ycs/contracts"
+)
+
+func TestReorderElements(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	array := doc.GetArray("a").(*YArray)
+	array.Insert(0, []interface{}{"a", "b", "c", "d", "e"})
+	array.Move(0, 2)
+
+	ids := segmentIDs(array.renderList(notDeleted))
+	values := array.ToArray()
+	byID := make(map[contracts.StructID]interface{})
+	for i, id := range ids {
+		byID[id] = values[i]
+	}
+
+	// Reverse the list, keeping the elements
+	want := make([]contracts.StructID, len(ids))
+	for i, id := range ids {
+		want[len(ids)-1-i] = id
+	}
+	doc.Transact(func(tr contracts.ITransaction) {
+		array.reorderElements(tr, want, func(id contracts.StructID) contracts.StructID { return id })
+	}, nil)
+
+	got := array.ToArray()
+	for i, id := range want {
+		if got[i] != byID[id] {
+			t.Fatalf("got %v, want the reverse of %v", got, values)
+		}
+	}
+	if gotIDs := segmentIDs(array.renderList(notDeleted)); !reflect.DeepEqual(gotIDs, want) {
+		t.Fatalf("elements were copied instead of moved: %v", gotIDs)
+	}
+}
+
+// syncDocs exchanges the missing updates between all docs
+func syncDocs(t *testing.T, docs ...*YDoc) {
+	t.Helper()
+	for _, from := range docs {
+		for _, to := range docs {
+			if from == to {
+				continue
+			}
+			if err := to.ApplyUpdateV2(encodeUpdate(t, from, to.EncodeStateVectorV2()), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// sortedValues returns the values of the array in ascending order
+func sortedValues(array *YArray) []int64 {
+	values := make([]int64, 0, array.GetLength())
+	for _, v := range array.ToArray() {
+		values = append(values, v.(int64))
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values
+}
+
+func TestConcurrentMovesConverge(t *testing.T) {
+	docs := make([]*YDoc, 3)
+	for i := range docs {
+		docs[i] = NewYDoc(contracts.YDocOptions{})
+	}
+	docs[0].GetArray("a").Insert(0, []interface{}{int64(0), int64(1), int64(2), int64(3), int64(4), int64(5)})
+	syncDocs(t, docs...)
+
+	// The same element moved to different places, and overlapping ranges
+	docs[0].GetArray("a").(*YArray).Move(1, 5)
+	docs[1].GetArray("a").(*YArray).Move(1, 0)
+	docs[2].GetArray("a").(*YArray).MoveRange(0, 3, 3)
+	syncDocs(t, docs...)
+
+	want := docs[0].GetArray("a").ToArray()
+	for i, doc := range docs {
+		if got := doc.GetArray("a").ToArray(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("doc %d: got %v, want %v", i, got, want)
+		}
+	}
+	if got := sortedValues(docs[0].GetArray("a").(*YArray)); !reflect.DeepEqual(got, []int64{0, 1, 2, 3, 4, 5}) {
+		t.Fatalf("elements were lost or duplicated: %v", got)
+	}
+}
+
+func TestRandomMovesConverge(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		prng := rand.New(rand.NewSource(seed))
+		docs := make([]*YDoc, 3)
+		for i := range docs {
+			docs[i] = NewYDoc(contracts.YDocOptions{})
+		}
+		next := int64(0)
+		for round := 0; round < 20; round++ {
+			for _, doc := range docs {
+				array := doc.GetArray("a").(*YArray)
+				length := array.GetLength()
+				switch op := prng.Intn(4); {
+				case op == 0 || length < 2:
+					array.Insert(prng.Intn(length+1), []interface{}{next})
+					next++
+				case op == 1:
+					array.Delete(prng.Intn(length), 1)
+				default:
+					count := 1 + prng.Intn(min(3, length))
+					from := prng.Intn(length - count + 1)
+					array.MoveRange(from, count, prng.Intn(length-count+1))
+				}
+			}
+			if prng.Intn(3) == 0 {
+				syncDocs(t, docs...)
+			}
+		}
+		syncDocs(t, docs...)
+
+		want := docs[0].GetArray("a").ToArray()
+		for i, doc := range docs[1:] {
+			if got := doc.GetArray("a").ToArray(); !reflect.DeepEqual(got, want) {
+				t.Fatalf("seed %d, doc %d: got %v, want %v", seed, i+1, got, want)
+			}
+		}
+		values := sortedValues(docs[0].GetArray("a").(*YArray))
+		for i := 1; i < len(values); i++ {
+			if values[i] == values[i-1] {
+				t.Fatalf("seed %d: element %d is rendered twice in %v", seed, values[i], want)
+			}
+		}
+	}
+}
+
+func TestRepeatedMovesKeepPriorityBounded(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	array := doc.GetArray("a").(*YArray)
+	array.Insert(0, []interface{}{int64(0), int64(1), int64(2), int64(3)})
+	for i := 0; i < 1000; i++ {
+		array.Move(i%4, (i+2)%4)
+	}
+
+	for n := array.GetStart(); n != nil; n = n.GetRight() {
+		if c, ok := n.GetContent().(*content.ContentMove); ok && !n.GetDeleted() && c.GetPriority() > array.liveMoves {
+			t.Fatalf("priority %d with %d live moves", c.GetPriority(), array.liveMoves)
+		}
+	}
+	if array.liveMoves > array.GetLength() {
+		t.Fatalf("%d live moves for %d elements", array.liveMoves, array.GetLength())
+	}
+
+	remote := NewYDoc(contracts.YDocOptions{})
+	if err := remote.ApplyUpdateV2(encodeUpdate(t, doc), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := remote.GetArray("a").ToArray(), array.ToArray(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestSupersededMovesAreDeleted(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	array := doc.GetArray("a").(*YArray)
+	values := make([]interface{}, 100)
+	for i := range values {
+		values[i] = int64(i)
+	}
+	array.Insert(0, values)
+
+	array.Move(0, 50)
+	array.Move(50, 10)
+	if array.liveMoves != 1 {
+		t.Fatalf("got %d live moves, want the second move only", array.liveMoves)
+	}
+
+	array.Delete(10, 1)
+	if array.liveMoves != 0 {
+		t.Fatalf("got %d live moves after deleting the moved element", array.liveMoves)
+	}
+
+	if got := array.Get(80); got != int64(81) {
+		t.Fatalf("got %v", got)
+	}
+
+	// The remote list counts the moves it receives
+	remote := NewYDoc(contracts.YDocOptions{})
+	if err := remote.ApplyUpdateV2(encodeUpdate(t, doc), nil); err != nil {
+		t.Fatal(err)
+	}
+	if list := remote.GetArray("a").(*YArray); list.liveMoves != 0 || !reflect.DeepEqual(list.ToArray(), array.ToArray()) {
+		t.Fatalf("remote has %d live moves and %v", list.liveMoves, list.ToArray())
+	}
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"ycs/contracts"
+)
+
+// TestYArrayRandomEdits checks inserts and deletes against a plain slice. The
+// array is long enough for search markers to be used and moved around.
+func TestYArrayRandomEdits(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	doc := NewYDoc(contracts.YDocOptions{})
+	arr := doc.GetArray("a")
+	var model []interface{}
+
+	for step := 0; step < 2000; step++ {
+		if len(model) == 0 || r.Intn(3) > 0 {
+			index := r.Intn(len(model) + 1)
+			values := make([]interface{}, 1+r.Intn(3))
+			for i := range values {
+				values[i] = int64(step*10 + i)
+			}
+			arr.Insert(index, values)
+			model = append(model[:index], append(values, model[index:]...)...)
+		} else {
+			index := r.Intn(len(model))
+			length := 1 + r.Intn(min(3, len(model)-index))
+			arr.Delete(index, length)
+			model = append(model[:index], model[index+length:]...)
+		}
+
+		if arr.GetLength() != len(model) {
+			t.Fatalf("step %d: length %d, want %d", step, arr.GetLength(), len(model))
+		}
+		if len(model) > 0 {
+			index := r.Intn(len(model))
+			if got := arr.Get(index); got != model[index] {
+				t.Fatalf("step %d: Get(%d) = %v, want %v", step, index, got, model[index])
+			}
+		}
+	}
+
+	if got := arr.ToArray(); !reflect.DeepEqual(got, model) {
+		t.Fatalf("got %v, want %v", got, model)
+	}
+}
+
+func TestYArraySyncsToDefinedArray(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	arr := doc.GetArray("a")
+	arr.Add([]interface{}{int64(1), "two"})
+	arr.Unshift([]interface{}{int64(0)})
+	arr.Insert(2, []interface{}{true})
+
+	remote := NewYDoc(contracts.YDocOptions{})
+	remoteArr := remote.GetArray("a")
+	remote.ApplyUpdateV2(encodeUpdate(t, doc), nil)
+
+	want := []interface{}{int64(0), int64(1), true, "two"}
+	if got := remoteArr.ToArray(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := remoteArr.Slice(1, 3); !reflect.DeepEqual(got, want[1:3]) {
+		t.Fatalf("slice got %v", got)
+	}
+}
@@ -3,19 +3,49 @@ package core
 import (
 	"bytes"
 	"crypto/rand"
+	"fmt"
 	"io"
+	"math"
 	"math/big"
+	"reflect"
 	"sync"
+	"ycs/content"
 	"ycs/contracts"
 )
 
-// Initialize initializes the core package
-func Initialize() {
-	// This function can be used to initialize any core package requirements
-	// For now, it's empty but can be extended as needed
-}
+var initializeOnce sync.Once
 
-// YDoc represents a Yjs instance that handles the state of shared data
+// Initialize registers the shared type readers and the sub-document factory with
+// the content package. It is safe to call it more than once, NewYDoc calls it too.
+func Initialize() {
+	initializeOnce.Do(func() {
+		content.RegisterTypeReader(YArrayRefID, func(decoder contracts.IUpdateDecoder) contracts.IAbstractType {
+			return ReadYArray(decoder)
+		})
+		content.RegisterTypeReader(YMapRefID, func(decoder contracts.IUpdateDecoder) contracts.IAbstractType {
+			return ReadYMap(decoder)
+		})
+		content.RegisterTypeReader(YTextRefID, func(decoder contracts.IUpdateDecoder) contracts.IAbstractType {
+			return ReadYText(decoder)
+		})
+
+		content.SetDocFactory(func(opts *contracts.YDocOptions) contracts.IYDoc {
+			return NewYDoc(*opts)
+		})
+	})
+}
+
+// YDoc represents a Yjs instance that handles the state of shared data.
+//
+// A document is not safe for concurrent use by itself. Goroutines that share one
+// serialize their access with its lock, a plain mutex: Transact holds it while the
+// transaction runs and its observers are called, View while a function reads the
+// document, and Lock and Unlock around longer sections, e.g. applying an update and
+// encoding the state. The other methods of the document and its types do not take
+// the lock, so they can be called inside Transact, View and observers; changes made
+// there join the running transaction. The lock is not re-entrant: Transact and View
+// must not be called while it is held, and handlers must not wait for other
+// goroutines that use the document.
 type YDoc struct {
 	opts                contracts.YDocOptions
 	shouldLoad          bool
@@ -27,24 +57,31 @@ type YDoc struct {
 	clientID            int64
 	store               contracts.IStructStore
 	mutex               sync.RWMutex
+	lock                sync.Mutex
 
 	// Event handlers
-	beforeObserverCalls     func(contracts.ITransaction)
-	beforeTransaction       func(contracts.ITransaction)
-	afterTransaction        func(contracts.ITransaction)
-	afterTransactionCleanup func(contracts.ITransaction)
-	beforeAllTransactions   func()
-	afterAllTransactions    func([]contracts.ITransaction)
-	updateV2                func([]byte, interface{}, contracts.ITransaction)
-	destroyed               func()
-	subdocsChanged          func(map[contracts.IYDoc]struct{}, map[contracts.IYDoc]struct{}, map[contracts.IYDoc]struct{})
+	beforeObserverCalls     observers[contracts.BeforeObserverCallsHandler]
+	beforeTransaction       observers[contracts.BeforeTransactionHandler]
+	afterTransaction        observers[contracts.AfterTransactionHandler]
+	afterTransactionCleanup observers[contracts.AfterTransactionCleanupHandler]
+	beforeAllTransactions   observers[contracts.BeforeAllTransactionsHandler]
+	afterAllTransactions    observers[contracts.AfterAllTransactionsHandler]
+	updateV2                observers[contracts.UpdateV2Handler]
+	destroyed               observers[contracts.DestroyedHandler]
+	subdocsChanged          observers[contracts.SubdocsChangedHandler]
+	clientIDChanged         observers[contracts.ClientIDChangedHandler]
 }
 
 // NewYDoc creates a new YDoc instance
 func NewYDoc(opts contracts.YDocOptions) *YDoc {
+	Initialize()
+
 	if opts.Guid == "" {
 		opts.Guid = generateGUID()
 	}
+	if opts.GcFilter == nil {
+		opts.GcFilter = func(contracts.IStructItem) bool { return true }
+	}
 
 	doc := &YDoc{
 		opts:                opts,
@@ -59,25 +96,25 @@ func NewYDoc(opts contracts.YDocOptions) *YDoc {
 	return doc
 }
 
-// generateNewClientID generates a new random client ID
+// generateNewClientID generates a new random client ID. Client IDs are encoded
+// as 32-bit variable length integers, so they are kept below math.MaxInt32.
 func generateNewClientID() int64 {
-	max := big.NewInt(int64(^uint(0) >> 1)) // max int64
-	n, err := rand.Int(rand.Reader, max)
+	n, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt32))
 	if err != nil {
-		// Fallback to a simple method if crypto/rand fails
-		return 12345 // This should be improved in production
+		panic(err)
 	}
 	return n.Int64()
 }
 
-// generateGUID generates a simple GUID
+// generateGUID generates a random version 4 GUID in the 8-4-4-4-12 hex format
 func generateGUID() string {
 	b := make([]byte, 16)
-	_, err := rand.Read(b)
-	if err != nil {
-		return "default-guid"
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
 	}
-	return string(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
 }
 
 // GetOpts returns the document options
@@ -173,6 +210,23 @@ func (ydoc *YDoc) SetItem(item contracts.IStructItem) {
 	ydoc.item = item
 }
 
+// ToJSON returns the root types of the document rendered for encoding/json, as
+// their ToJSON renders them. A root type that only remote updates created has no
+// kind until it is defined with GetMap, GetArray or GetText; its kind is guessed
+// from its content then, which can differ from what the peer defined, e.g. an empty
+// type renders as nil and a text with embeds as an array.
+func (ydoc *YDoc) ToJSON(textAsDelta ...bool) map[string]interface{} {
+	result := make(map[string]interface{})
+	for name, sharedType := range ydoc.GetShare() {
+		if undefined, ok := sharedType.(*AbstractType); ok {
+			result[name] = undefined.guessJSON(textAsDelta)
+			continue
+		}
+		result[name] = sharedType.ToJSON(textAsDelta...)
+	}
+	return result
+}
+
 // GetShare returns the shared types
 func (ydoc *YDoc) GetShare() map[string]contracts.IAbstractType {
 	ydoc.mutex.RLock()
@@ -201,6 +255,22 @@ func (ydoc *YDoc) SetClientID(clientID int) {
 	ydoc.clientID = int64(clientID)
 }
 
+// ReassignClientID replaces the client ID with a new random one that no struct in
+// the document uses yet. It is called when structs of another peer arrive under the
+// client ID of this document, which would otherwise conflict with local changes.
+func (ydoc *YDoc) ReassignClientID() {
+	oldClientID := ydoc.GetClientID()
+
+	clients := ydoc.store.GetClients()
+	newClientID := generateNewClientID()
+	for _, exists := clients[newClientID]; exists || int(newClientID) == oldClientID; _, exists = clients[newClientID] {
+		newClientID = generateNewClientID()
+	}
+
+	ydoc.SetClientID(int(newClientID))
+	ydoc.InvokeClientIDChanged(oldClientID, int(newClientID))
+}
+
 // GetStore returns the struct store
 func (ydoc *YDoc) GetStore() contracts.IStructStore {
 	return ydoc.store
@@ -213,12 +283,14 @@ func (ydoc *YDoc) SetStore(store contracts.IStructStore) {
 	ydoc.store = store
 }
 
-// Load notifies the parent document that you request to load data into this subdocument
+// Load notifies the parent document that you request to load data into this subdocument.
+// A subdocument can only be synced through its parent, so an unloaded parent is loaded first.
 func (ydoc *YDoc) Load() {
 	item := ydoc.GetItem()
 	if item != nil && !ydoc.GetShouldLoad() {
-		parent := item.GetParent().(contracts.IAbstractType)
-		parent.GetDoc().Transact(func(tr contracts.ITransaction) {
+		parentDoc := item.GetParent().(contracts.IAbstractType).GetDoc()
+		parentDoc.Load()
+		transact(parentDoc, func(tr contracts.ITransaction) {
 			tr.GetSubdocsLoaded()[ydoc] = struct{}{}
 		}, nil, true)
 	}
@@ -227,7 +299,17 @@ func (ydoc *YDoc) Load() {
 
 // CreateSnapshot creates a snapshot of the current document state
 func (ydoc *YDoc) CreateSnapshot() contracts.ISnapshot {
-	return NewSnapshot(NewDeleteSet(), ydoc.store.GetStateVector())
+	return NewSnapshot(NewDeleteSetFromStore(ydoc.store), ydoc.store.GetStateVector())
+}
+
+// GetSubdoc returns the subdocument with the given GUID, or nil if there is none
+func (ydoc *YDoc) GetSubdoc(guid string) contracts.IYDoc {
+	for subdoc := range ydoc.GetSubdocs() {
+		if subdoc.GetGuid() == guid {
+			return subdoc
+		}
+	}
+	return nil
 }
 
 // GetSubdocGuids returns the GUIDs of all subdocuments
@@ -239,7 +321,8 @@ func (ydoc *YDoc) GetSubdocGuids() []string {
 	return guids
 }
 
-// Destroy destroys the document and all subdocuments
+// Destroy destroys the document and all subdocuments. A subdocument is replaced
+// in its parent by a fresh, unloaded document with the same GUID.
 func (ydoc *YDoc) Destroy() {
 	for subdoc := range ydoc.GetSubdocs() {
 		subdoc.Destroy()
@@ -248,16 +331,61 @@ func (ydoc *YDoc) Destroy() {
 	item := ydoc.GetItem()
 	if item != nil {
 		ydoc.SetItem(nil)
-		// Handle content doc logic here
-		// This is simplified compared to the C# version
-		if ydoc.destroyed != nil {
-			ydoc.destroyed()
+		contentDoc, _ := item.GetContent().(*content.ContentDoc)
+
+		if item.GetDeleted() {
+			if contentDoc != nil {
+				contentDoc.SetDoc(nil)
+			}
+		} else {
+			newOpts := *contentDoc.GetOpts()
+			newOpts.Guid = ydoc.GetGuid()
+
+			// The replacement has to be loaded again, even if the options auto-load it
+			newDoc := NewYDoc(newOpts)
+			newDoc.SetShouldLoad(false)
+			newDoc.SetItem(item)
+			contentDoc.SetDoc(newDoc)
 		}
+
+		transact(item.GetParent().(contracts.IAbstractType).GetDoc(), func(tr contracts.ITransaction) {
+			if !item.GetDeleted() {
+				tr.GetSubdocsAdded()[contentDoc.GetDoc()] = struct{}{}
+			}
+			tr.GetSubdocsRemoved()[ydoc] = struct{}{}
+		}, nil, true)
 	}
+
+	ydoc.InvokeDestroyed()
 }
 
-// Transact bundles changes in a transaction
+// Lock locks the document, see YDoc. Together with Unlock it implements sync.Locker.
+func (ydoc *YDoc) Lock() {
+	ydoc.lock.Lock()
+}
+
+// Unlock unlocks the document
+func (ydoc *YDoc) Unlock() {
+	ydoc.lock.Unlock()
+}
+
+// View calls fun holding the lock of the document, so that fun sees no concurrent changes
+func (ydoc *YDoc) View(fun func()) {
+	ydoc.Lock()
+	defer ydoc.Unlock()
+	fun()
+}
+
+// Transact bundles changes in a transaction, holding the lock of the document
 func (ydoc *YDoc) Transact(fun func(contracts.ITransaction), origin interface{}, local ...bool) {
+	ydoc.Lock()
+	defer ydoc.Unlock()
+	ydoc.transact(fun, origin, local...)
+}
+
+// transact runs fun in the running transaction, or in a new one with origin that
+// is cleaned up when fun returns. Unlike Transact it does not take the lock.
+func (ydoc *YDoc) transact(fun func(contracts.ITransaction), origin interface{}, local ...bool) {
 	initialCall := false
 	if ydoc.GetTransaction() == nil {
 		initialCall = true
@@ -272,13 +400,10 @@ func (ydoc *YDoc) Transact(fun func(contracts.ITransaction), origin interface{},
 		isFirst := len(ydoc.transactionCleanups) == 1
 		ydoc.mutex.Unlock()
 
-		if isFirst && ydoc.beforeAllTransactions != nil {
-			ydoc.beforeAllTransactions()
-		}
-
-		if ydoc.beforeTransaction != nil {
-			ydoc.beforeTransaction(transaction)
+		if isFirst {
+			ydoc.InvokeBeforeAllTransactions()
 		}
+		ydoc.InvokeOnBeforeTransaction(transaction)
 	}
 
 	defer func() {
@@ -290,6 +415,12 @@ func (ydoc *YDoc) Transact(fun func(contracts.ITransaction), origin interface{},
 	fun(ydoc.GetTransaction())
 }
 
+// transact runs fun in a transaction of doc without taking its lock, see YDoc.transact.
+// The methods of the types use it, so that they can be called inside Transact.
+func transact(doc contracts.IYDoc, fun func(contracts.ITransaction), origin interface{}, local ...bool) {
+	doc.(*YDoc).transact(fun, origin, local...)
+}
+
 // GetArray returns or creates a YArray with the given name
 func (ydoc *YDoc) GetArray(name ...string) contracts.IYArray {
 	nameStr := ""
@@ -314,89 +445,143 @@ func (ydoc *YDoc) GetText(name ...string) contracts.IYText {
 	if len(name) > 0 {
 		nameStr = name[0]
 	}
-	return ydoc.Get(nameStr, func() contracts.IAbstractType { return NewYText(nil) }).(contracts.IYText)
+	return ydoc.Get(nameStr, func() contracts.IAbstractType { return NewYText("") }).(contracts.IYText)
 }
 
-// Get returns or creates a shared type with the given name
+// Get returns or creates a shared type with the given name. A type that was
+// created by a remote update before it was defined locally is a plain
+// AbstractType; it is upgraded to the requested type here.
 func (ydoc *YDoc) Get(name string, typeConstructor func() contracts.IAbstractType) contracts.IAbstractType {
-	ydoc.mutex.Lock()
-	defer ydoc.mutex.Unlock()
+	ydoc.mutex.RLock()
+	existingType, exists := ydoc.share[name]
+	ydoc.mutex.RUnlock()
+
+	if !exists {
+		var newType contracts.IAbstractType
+		if typeConstructor != nil {
+			newType = typeConstructor()
+		} else {
+			newType = NewAbstractType()
+		}
+
+		// The lock is not held while integrating, the type may start a transaction
+		newType.Integrate(ydoc, nil)
+		ydoc.mutex.Lock()
+		ydoc.share[name] = newType
+		ydoc.mutex.Unlock()
+		return newType
+	}
+
+	if typeConstructor == nil {
+		return existingType
+	}
 
-	if existingType, exists := ydoc.share[name]; exists {
+	requestedType := typeConstructor()
+	if reflect.TypeOf(existingType) == reflect.TypeOf(requestedType) {
 		return existingType
 	}
 
-	// Create a new type using the provided constructor
-	var newType contracts.IAbstractType
-	if typeConstructor != nil {
-		newType = typeConstructor()
-	} else {
-		// Default to abstract type if no constructor provided
-		newType = NewAbstractType()
+	if _, isPlain := existingType.(*AbstractType); !isPlain {
+		panic(fmt.Sprintf("Type with the name %s has already been defined with a different constructor", name))
 	}
 
-	newType.Integrate(ydoc, nil)
-	ydoc.share[name] = newType
-	return newType
+	// Remote type is realized when this method is called.
+	requestedType.SetMap(existingType.GetMap())
+	for _, item := range existingType.GetMap() {
+		for n := item; n != nil; n = n.GetLeft() {
+			n.SetParent(requestedType)
+		}
+	}
+
+	requestedType.SetStart(existingType.GetStart())
+	for n := requestedType.GetStart(); n != nil; n = n.GetRight() {
+		n.SetParent(requestedType)
+		// Moves integrated into the plain type did not mark the list
+		if _, isMove := n.GetContent().(*content.ContentMove); isMove {
+			if list, ok := requestedType.(contracts.IYArrayBase); ok {
+				list.MarkMoved()
+				if n.GetDeleted() {
+					list.UnmarkMoved()
+				}
+			}
+		}
+	}
+
+	requestedType.SetLength(existingType.GetLength())
+	ydoc.store.ReplacePendingParent(existingType, requestedType)
+
+	ydoc.mutex.Lock()
+	ydoc.share[name] = requestedType
+	ydoc.mutex.Unlock()
+
+	requestedType.Integrate(ydoc, nil)
+	return requestedType
 }
 
-// ApplyUpdateV2 applies an update to the document
-func (ydoc *YDoc) ApplyUpdateV2(update []byte, transactionOrigin interface{}, local ...bool) {
+// ApplyUpdateV2 applies an update to the document. Malformed updates are
+// reported as an error instead of a panic.
+func (ydoc *YDoc) ApplyUpdateV2(update []byte, transactionOrigin interface{}, local ...bool) error {
 	localBool := false
 	if len(local) > 0 {
 		localBool = local[0]
 	}
-	ydoc.ApplyUpdateV2Stream(bytes.NewReader(update), transactionOrigin, localBool)
+	return ydoc.ApplyUpdateV2Stream(bytes.NewReader(update), transactionOrigin, localBool)
 }
 
 // ApplyUpdateV2Stream applies an update from a stream to the document
-func (ydoc *YDoc) ApplyUpdateV2Stream(input io.Reader, transactionOrigin interface{}, local ...bool) {
+func (ydoc *YDoc) ApplyUpdateV2Stream(input io.Reader, transactionOrigin interface{}, local ...bool) (err error) {
 	localBool := false
 	if len(local) > 0 {
 		localBool = local[0]
 	}
-	ydoc.Transact(func(tr contracts.ITransaction) {
+
+	defer func() {
+		// The update comes from the network, a malformed one must not take the process down
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("malformed update: %v", rec)
+		}
+	}()
+
+	transact(ydoc, func(tr contracts.ITransaction) {
 		decoder := NewUpdateDecoderV2(input)
-		err := ReadStructs(decoder, tr, ydoc.store)
-		if err != nil {
-			// Handle error - in Go we'd typically panic like C# would throw
-			panic(err)
+		if err = ReadStructs(decoder, tr, ydoc.store); err != nil {
+			return
 		}
-		ydoc.store.ReadAndApplyDeleteSet(decoder, tr)
+		err = ydoc.store.ReadAndApplyDeleteSet(decoder, tr)
 	}, transactionOrigin, localBool)
+	return err
 }
 
 // ApplyUpdateV2Bytes applies an update from byte slice
-func (ydoc *YDoc) ApplyUpdateV2Bytes(update []byte, transactionOrigin interface{}, local bool) {
-	ydoc.ApplyUpdateV2Stream(bytes.NewReader(update), transactionOrigin, local)
+func (ydoc *YDoc) ApplyUpdateV2Bytes(update []byte, transactionOrigin interface{}, local bool) error {
+	return ydoc.ApplyUpdateV2Stream(bytes.NewReader(update), transactionOrigin, local)
 }
 
-// EncodeStateAsUpdateV2 encodes the document state as an update
-func (ydoc *YDoc) EncodeStateAsUpdateV2(encodedTargetStateVector ...[]byte) []byte {
+// EncodeStateAsUpdateV2 encodes the document state as an update, or only the structs
+// missing from the encoded state vector if one is given. State vectors come from the
+// network, so a malformed one is reported as an error.
+func (ydoc *YDoc) EncodeStateAsUpdateV2(encodedTargetStateVector ...[]byte) ([]byte, error) {
 	encoder := NewUpdateEncoderV2()
 	defer encoder.Close()
 
-	var targetStateVector map[int64]int64
-	if len(encodedTargetStateVector) == 0 || encodedTargetStateVector[0] == nil {
-		targetStateVector = make(map[int64]int64)
-	} else {
+	targetStateVector := make(map[int64]int64)
+	if len(encodedTargetStateVector) > 0 && encodedTargetStateVector[0] != nil {
 		var err error
 		targetStateVector, err = DecodeStateVector(bytes.NewReader(encodedTargetStateVector[0]))
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("malformed state vector: %w", err)
 		}
 	}
 
-	err := ydoc.WriteStateAsUpdate(encoder, targetStateVector)
-	if err != nil {
-		panic(err)
+	if err := ydoc.WriteStateAsUpdate(encoder, targetStateVector); err != nil {
+		return nil, err
 	}
-	return encoder.ToArray()
+	return encoder.ToArray(), nil
 }
 
 // EncodeStateVectorV2 encodes the state vector
 func (ydoc *YDoc) EncodeStateVectorV2() []byte {
-	encoder := NewUpdateEncoderV2()
+	encoder := NewDSEncoderV2()
 	defer encoder.Close()
 
 	err := ydoc.WriteStateVector(encoder)
@@ -408,7 +593,10 @@ func (ydoc *YDoc) EncodeStateVectorV2() []byte {
 
 // WriteStateAsUpdate writes the document state as an update
 func (ydoc *YDoc) WriteStateAsUpdate(encoder contracts.IUpdateEncoder, targetStateVector map[int64]int64) error {
-	return WriteClientsStructs(encoder, ydoc.store, targetStateVector)
+	if err := WriteClientsStructs(encoder, ydoc.store, targetStateVector); err != nil {
+		return err
+	}
+	return NewDeleteSetFromStore(ydoc.store).Write(encoder)
 }
 
 // WriteStateVector writes the state vector
@@ -428,124 +616,129 @@ func (ydoc *YDoc) FindRootTypeKey(targetType contracts.IAbstractType) string {
 
 // Event handler methods
 func (ydoc *YDoc) InvokeSubdocsChanged(loaded, added, removed map[contracts.IYDoc]struct{}) {
-	if ydoc.subdocsChanged != nil {
-		ydoc.subdocsChanged(loaded, added, removed)
+	for _, handler := range ydoc.subdocsChanged.snapshot() {
+		handler(loaded, added, removed)
 	}
 }
 
 func (ydoc *YDoc) InvokeOnBeforeObserverCalls(transaction contracts.ITransaction) {
-	if ydoc.beforeObserverCalls != nil {
-		ydoc.beforeObserverCalls(transaction)
+	for _, handler := range ydoc.beforeObserverCalls.snapshot() {
+		handler(transaction)
 	}
 }
 
 func (ydoc *YDoc) InvokeAfterAllTransactions(transactions []contracts.ITransaction) {
-	if ydoc.afterAllTransactions != nil {
-		ydoc.afterAllTransactions(transactions)
+	for _, handler := range ydoc.afterAllTransactions.snapshot() {
+		handler(transactions)
 	}
 }
 
 func (ydoc *YDoc) InvokeOnBeforeTransaction(transaction contracts.ITransaction) {
-	if ydoc.beforeTransaction != nil {
-		ydoc.beforeTransaction(transaction)
+	for _, handler := range ydoc.beforeTransaction.snapshot() {
+		handler(transaction)
 	}
 }
 
 func (ydoc *YDoc) InvokeOnAfterTransaction(transaction contracts.ITransaction) {
-	if ydoc.afterTransaction != nil {
-		ydoc.afterTransaction(transaction)
+	for _, handler := range ydoc.afterTransaction.snapshot() {
+		handler(transaction)
 	}
 }
 
 func (ydoc *YDoc) InvokeOnAfterTransactionCleanup(transaction contracts.ITransaction) {
-	if ydoc.afterTransactionCleanup != nil {
-		ydoc.afterTransactionCleanup(transaction)
+	for _, handler := range ydoc.afterTransactionCleanup.snapshot() {
+		handler(transaction)
 	}
 }
 
 func (ydoc *YDoc) InvokeBeforeAllTransactions() {
-	if ydoc.beforeAllTransactions != nil {
-		ydoc.beforeAllTransactions()
+	for _, handler := range ydoc.beforeAllTransactions.snapshot() {
+		handler()
+	}
+}
+
+func (ydoc *YDoc) InvokeClientIDChanged(oldClientID, newClientID int) {
+	for _, handler := range ydoc.clientIDChanged.snapshot() {
+		handler(oldClientID, newClientID)
 	}
 }
 
 func (ydoc *YDoc) InvokeDestroyed() {
-	if ydoc.destroyed != nil {
-		ydoc.destroyed()
+	// A document is destroyed once, even if it is destroyed again while it is removed from its parent
+	for _, handler := range ydoc.destroyed.take() {
+		handler()
 	}
 }
 
 func (ydoc *YDoc) InvokeUpdateV2(transaction contracts.ITransaction) {
-	if ydoc.updateV2 != nil {
-		encoder := NewUpdateEncoderV2()
-		hasContent := transaction.WriteUpdateMessageFromTransaction(encoder)
-		if hasContent {
-			ydoc.updateV2(encoder.ToArray(), transaction.GetOrigin(), transaction)
-		}
+	handlers := ydoc.updateV2.snapshot()
+	if len(handlers) == 0 {
+		return
+	}
+
+	// The update is encoded once and shared by all handlers
+	encoder := NewUpdateEncoderV2()
+	hasContent := transaction.WriteUpdateMessageFromTransaction(encoder)
+	if !hasContent {
+		return
+	}
+
+	update := encoder.ToArray()
+	for _, handler := range handlers {
+		handler(update, transaction.GetOrigin(), transaction)
 	}
 }
 
-// OnAfterAllTransactions sets the after all transactions handler
-func (ydoc *YDoc) OnAfterAllTransactions(handler contracts.AfterAllTransactionsHandler) {
-	ydoc.mutex.Lock()
-	defer ydoc.mutex.Unlock()
-	ydoc.afterAllTransactions = handler
+// OnAfterAllTransactions adds an after all transactions handler and returns a function that removes it
+func (ydoc *YDoc) OnAfterAllTransactions(handler contracts.AfterAllTransactionsHandler) func() {
+	return ydoc.afterAllTransactions.add(handler)
 }
 
-// OnBeforeObserverCalls sets the before observer calls handler
-func (ydoc *YDoc) OnBeforeObserverCalls(handler contracts.BeforeObserverCallsHandler) {
-	ydoc.mutex.Lock()
-	defer ydoc.mutex.Unlock()
-	ydoc.beforeObserverCalls = handler
+// OnBeforeObserverCalls adds a before observer calls handler and returns a function that removes it
+func (ydoc *YDoc) OnBeforeObserverCalls(handler contracts.BeforeObserverCallsHandler) func() {
+	return ydoc.beforeObserverCalls.add(handler)
 }
 
-// OnBeforeTransaction sets the before transaction handler
-func (ydoc *YDoc) OnBeforeTransaction(handler contracts.BeforeTransactionHandler) {
-	ydoc.mutex.Lock()
-	defer ydoc.mutex.Unlock()
-	ydoc.beforeTransaction = handler
+// OnBeforeTransaction adds a before transaction handler and returns a function that removes it
+func (ydoc *YDoc) OnBeforeTransaction(handler contracts.BeforeTransactionHandler) func() {
+	return ydoc.beforeTransaction.add(handler)
 }
 
-// OnAfterTransaction sets the after transaction handler
-func (ydoc *YDoc) OnAfterTransaction(handler contracts.AfterTransactionHandler) {
-	ydoc.mutex.Lock()
-	defer ydoc.mutex.Unlock()
-	ydoc.afterTransaction = handler
+// OnAfterTransaction adds an after transaction handler and returns a function that removes it
+func (ydoc *YDoc) OnAfterTransaction(handler contracts.AfterTransactionHandler) func() {
+	return ydoc.afterTransaction.add(handler)
 }
 
-// OnAfterTransactionCleanup sets the after transaction cleanup handler
-func (ydoc *YDoc) OnAfterTransactionCleanup(handler contracts.AfterTransactionCleanupHandler) {
-	ydoc.mutex.Lock()
-	defer ydoc.mutex.Unlock()
-	ydoc.afterTransactionCleanup = handler
+// OnAfterTransactionCleanup adds an after transaction cleanup handler and returns a function that removes it
+func (ydoc *YDoc) OnAfterTransactionCleanup(handler contracts.AfterTransactionCleanupHandler) func() {
+	return ydoc.afterTransactionCleanup.add(handler)
 }
 
-// OnBeforeAllTransactions sets the before all transactions handler
-func (ydoc *YDoc) OnBeforeAllTransactions(handler contracts.BeforeAllTransactionsHandler) {
-	ydoc.mutex.Lock()
-	defer ydoc.mutex.Unlock()
-	ydoc.beforeAllTransactions = handler
+// OnBeforeAllTransactions adds a before all transactions handler and returns a function that removes it
+func (ydoc *YDoc) OnBeforeAllTransactions(handler contracts.BeforeAllTransactionsHandler) func() {
+	return ydoc.beforeAllTransactions.add(handler)
 }
 
-// OnUpdateV2 sets the update V2 handler
-func (ydoc *YDoc) OnUpdateV2(handler contracts.UpdateV2Handler) {
-	ydoc.mutex.Lock()
-	defer ydoc.mutex.Unlock()
-	ydoc.updateV2 = handler
+// OnUpdateV2 adds an update V2 handler and returns a function that removes it.
+// Handlers are called with the update of every transaction that changed the document.
+func (ydoc *YDoc) OnUpdateV2(handler contracts.UpdateV2Handler) func() {
+	return ydoc.updateV2.add(handler)
 }
 
-// OnDestroyed sets the destroyed handler
-func (ydoc *YDoc) OnDestroyed(handler contracts.DestroyedHandler) {
-	ydoc.mutex.Lock()
-	defer ydoc.mutex.Unlock()
-	ydoc.destroyed = handler
+// OnDestroyed adds a destroyed handler and returns a function that removes it
+func (ydoc *YDoc) OnDestroyed(handler contracts.DestroyedHandler) func() {
+	return ydoc.destroyed.add(handler)
 }
 
-// OnSubdocsChanged sets the subdocs changed handler
-func (ydoc *YDoc) OnSubdocsChanged(handler contracts.SubdocsChangedHandler) {
-	ydoc.mutex.Lock()
-	defer ydoc.mutex.Unlock()
-	ydoc.subdocsChanged = handler
+// OnSubdocsChanged adds a subdocs changed handler and returns a function that removes it
+func (ydoc *YDoc) OnSubdocsChanged(handler contracts.SubdocsChangedHandler) func() {
+	return ydoc.subdocsChanged.add(handler)
+}
+
+// OnClientIDChanged adds a client ID changed handler and returns a function that removes it.
+// Handlers are called after ReassignClientID gave the document a new client ID.
+func (ydoc *YDoc) OnClientIDChanged(handler contracts.ClientIDChangedHandler) func() {
+	return ydoc.clientIDChanged.add(handler)
 }
 
 // CloneOptionsWithNewGuid creates a copy of options with a new GUID
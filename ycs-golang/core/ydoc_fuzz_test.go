@@ -0,0 +1,78 @@
+package core
+
+import (
+	"testing"
+	"ycs/contracts"
+)
+
+// fuzzSeedUpdates returns valid updates that cover every struct and content kind
+func fuzzSeedUpdates() [][]byte {
+	doc := NewYDoc(contracts.YDocOptions{})
+	doc.SetClientID(1)
+
+	text := doc.GetText("text")
+	text.Insert(0, "hello world")
+	text.Format(0, 5, map[string]interface{}{"bold": true})
+	text.InsertEmbed(5, map[string]interface{}{"image": "a.png"})
+	text.Delete(6, 3)
+
+	array := doc.GetArray("array")
+	array.Insert(0, []interface{}{1, "two", 3.5, true, nil, []byte{4}})
+	array.Insert(1, []interface{}{NewYMap(nil), NewYArray(nil), NewYText("nested")})
+	array.Delete(0, 1)
+	array.Move(0, 2)
+
+	m := doc.GetMap("map")
+	m.Set("key", "value")
+	m.Set("key", map[string]interface{}{"a": []interface{}{1, 2}})
+	m.Set("doc", NewYDoc(contracts.YDocOptions{}))
+
+	update, err := doc.EncodeStateAsUpdateV2()
+	if err != nil {
+		panic(err)
+	}
+	return [][]byte{
+		{},
+		{0, 0},
+		update,
+		doc.EncodeStateVectorV2(),
+	}
+}
+
+// FuzzApplyUpdateV2 checks that applying arbitrary bytes reports an error instead of panicking
+func FuzzApplyUpdateV2(f *testing.F) {
+	for _, update := range fuzzSeedUpdates() {
+		f.Add(update)
+	}
+
+	f.Fuzz(func(t *testing.T, update []byte) {
+		doc := NewYDoc(contracts.YDocOptions{})
+		if err := doc.ApplyUpdateV2(update, nil); err != nil {
+			return
+		}
+
+		// Whatever was accepted must encode again
+		if _, err := doc.EncodeStateAsUpdateV2(); err != nil {
+			t.Fatal(err)
+		}
+		doc.EncodeStateVectorV2()
+	})
+}
+
+// FuzzEncodeStateAsUpdateV2 checks that encoding the structs missing from arbitrary
+// state vectors reports an error instead of panicking
+func FuzzEncodeStateAsUpdateV2(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{1})
+	f.Add([]byte{1, 1, 3})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0x0f})
+
+	updates := fuzzSeedUpdates()
+	f.Fuzz(func(t *testing.T, stateVector []byte) {
+		doc := NewYDoc(contracts.YDocOptions{})
+		if err := doc.ApplyUpdateV2(updates[2], nil); err != nil {
+			t.Fatal(err)
+		}
+		doc.EncodeStateAsUpdateV2(stateVector)
+	})
+}
@@ -0,0 +1,214 @@
+package core
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+
+	"ycs/contracts"
+)
+
+// encodeUpdate encodes the structs of doc missing from stateVector, failing the test on error
+func encodeUpdate(t testing.TB, doc *YDoc, stateVector ...[]byte) []byte {
+	t.Helper()
+	update, err := doc.EncodeStateAsUpdateV2(stateVector...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return update
+}
+
+func TestYDocRealizesRemoteRootType(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	doc.GetMap("m").Set("a", int64(1))
+	doc.GetArray("a").Insert(0, []interface{}{"x", "y"})
+
+	remote := NewYDoc(contracts.YDocOptions{})
+	remote.ApplyUpdateV2(encodeUpdate(t, doc), nil)
+
+	if got := remote.GetMap("m").GetEnumerator(); !reflect.DeepEqual(got, map[string]interface{}{"a": int64(1)}) {
+		t.Fatalf("map %v", got)
+	}
+	if got := remote.GetArray("a").ToArray(); !reflect.DeepEqual(got, []interface{}{"x", "y"}) {
+		t.Fatalf("array %v", got)
+	}
+}
+
+func TestYDocToJSONGuessesUndefinedRootTypes(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	text := doc.GetText("text")
+	text.Insert(0, "hi")
+	text.Format(0, 1, map[string]interface{}{"bold": true})
+	nested := NewYMap(nil)
+	doc.GetArray("array").Insert(0, []interface{}{int64(1), "x", nested})
+	nested.Set("a", int64(1))
+	doc.GetMap("map").Set("k", "v")
+	doc.GetText("embed").InsertEmbed(0, map[string]interface{}{"image": "a.png"})
+
+	remote := NewYDoc(contracts.YDocOptions{})
+	remote.ApplyUpdateV2(encodeUpdate(t, doc), nil)
+
+	want := map[string]interface{}{
+		"text":  "hi",
+		"array": []interface{}{int64(1), "x", map[string]interface{}{"a": int64(1)}},
+		"map":   map[string]interface{}{"k": "v"},
+		"embed": []interface{}{map[string]interface{}{"image": "a.png"}},
+	}
+	if got := remote.ToJSON(true); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	// Once defined, the type renders as what it is
+	remote.GetText("text")
+	if got, want := remote.ToJSON(true)["text"], doc.GetText("text").ToJSON(true); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestYDocSyncsDeletes(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	a := doc.GetArray("a")
+	a.Insert(0, []interface{}{int64(1), int64(2), int64(3)})
+	a.Delete(1, 1)
+
+	remote := NewYDoc(contracts.YDocOptions{})
+	remote.ApplyUpdateV2(encodeUpdate(t, doc), nil)
+
+	if got := remote.GetArray("a").ToArray(); !reflect.DeepEqual(got, []interface{}{int64(1), int64(3)}) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestSnapshotEncodingRoundTrip(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{Gc: false})
+	a := doc.GetArray("a")
+	a.Insert(0, []interface{}{int64(1), int64(2), int64(3)})
+	a.Delete(0, 2)
+
+	snapshot := doc.CreateSnapshot().(*Snapshot)
+	encoded := snapshot.EncodeSnapshotV2()
+	decoded, err := DecodeSnapshot(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !snapshot.Equals(decoded) {
+		t.Fatalf("decoded snapshot differs")
+	}
+	if !bytes.Equal(decoded.EncodeSnapshotV2(), encoded) {
+		t.Fatalf("encoding is not stable")
+	}
+}
+
+func TestYDocDefaults(t *testing.T) {
+	guid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for i := 0; i < 100; i++ {
+		doc := NewYDoc(contracts.YDocOptions{})
+		if !guid.MatchString(doc.GetGuid()) {
+			t.Fatalf("guid %q", doc.GetGuid())
+		}
+		if id := doc.GetClientID(); id < 0 || id > math.MaxInt32 {
+			t.Fatalf("client id %d", id)
+		}
+	}
+}
+
+func TestApplyUpdateV2ReportsMalformedUpdates(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	doc.GetText("t").Insert(0, "hello")
+	update := encodeUpdate(t, doc)
+
+	for _, malformed := range [][]byte{{0xff}, update[:len(update)/2], append([]byte{0, 5}, update...)} {
+		remote := NewYDoc(contracts.YDocOptions{})
+		if err := remote.ApplyUpdateV2(malformed, nil); err == nil {
+			t.Fatalf("no error for %x", malformed)
+		}
+	}
+
+	remote := NewYDoc(contracts.YDocOptions{})
+	if err := remote.ApplyUpdateV2(update, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := remote.GetText("t").ToString(); got != "hello" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestEncodeStateAsUpdateV2ReportsMalformedStateVectors(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	doc.GetText("t").Insert(0, "hello")
+
+	// A state vector announcing a client without its clock, and a truncated count
+	for _, malformed := range [][]byte{{1}, {1, 5}, {0xff}} {
+		if _, err := doc.EncodeStateAsUpdateV2(malformed); err == nil {
+			t.Fatalf("no error for %x", malformed)
+		}
+	}
+
+	update, err := doc.EncodeStateAsUpdateV2(doc.EncodeStateVectorV2())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := NewYDoc(contracts.YDocOptions{})
+	if err := remote.ApplyUpdateV2(update, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentTransactions(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	const writers, edits = 4, 100
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < edits; j++ {
+				doc.Transact(func(tr contracts.ITransaction) {
+					text := doc.GetText("t")
+					text.Insert(text.GetLength(), "x")
+				}, nil)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < edits; j++ {
+			doc.View(func() {
+				_ = doc.GetText("t").ToString()
+				_ = doc.EncodeStateVectorV2()
+			})
+		}
+	}()
+	wg.Wait()
+
+	doc.View(func() {
+		if got := doc.GetText("t").GetLength(); got != writers*edits {
+			t.Fatalf("got length %d, want %d", got, writers*edits)
+		}
+	})
+}
+
+func TestObserversChangeDocumentUnderLock(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	text := doc.GetText("t")
+	log := doc.GetArray("log")
+	text.Observe(func(args contracts.YEventArgs) {
+		// The lock is held; the types are used directly
+		log.Insert(log.GetLength(), []interface{}{text.ToString()})
+	})
+
+	doc.Transact(func(tr contracts.ITransaction) {
+		text.Insert(0, "ab")
+		text.Delete(0, 1)
+	}, nil)
+	text.Insert(1, "c")
+
+	if got, want := log.ToArray(), []interface{}{"b", "bc"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -32,35 +32,12 @@ func NewYDeepEventArgs(events []contracts.IYEvent, transaction contracts.ITransa
 	}
 }
 
-// Delta represents a change delta
-type Delta struct {
-	Delete     *int
-	Retain     *int
-	Insert     []interface{}
-	Attributes map[string]interface{}
-}
-
-// ChangeKey represents a key change
-type ChangeKey struct {
-	Action   string
-	OldValue interface{}
-	NewValue interface{}
-}
-
-// ChangesCollection represents a collection of changes
-type ChangesCollection struct {
-	Added   map[contracts.IStructItem]struct{}
-	Deleted map[contracts.IStructItem]struct{}
-	Delta   []Delta
-	Keys    map[string]ChangeKey
-}
-
 // YEvent represents a Y event
 type YEvent struct {
 	target        contracts.IAbstractType
 	currentTarget contracts.IAbstractType
 	transaction   contracts.ITransaction
-	changes       *ChangesCollection
+	changes       *contracts.ChangesCollection
 }
 
 // NewYEvent creates a new YEvent
@@ -109,153 +86,166 @@ func (ye *YEvent) GetPath() []interface{} {
 
 // GetChanges returns the changes collection
 func (ye *YEvent) GetChanges() *contracts.ChangesCollection {
-	changes := ye.collectChanges()
-	// Convert to contracts.ChangesCollection
-	return &contracts.ChangesCollection{
-		Added:   changes.Added,
-		Deleted: changes.Deleted,
-		Delta:   convertDelta(changes.Delta),
-		Keys:    convertKeys(changes.Keys),
-	}
-}
-
-// Helper function to convert Delta slice
-func convertDelta(delta []Delta) []contracts.Delta {
-	result := make([]contracts.Delta, len(delta))
-	for i, d := range delta {
-		result[i] = contracts.Delta{
-			Delete:     d.Delete,
-			Retain:     d.Retain,
-			Insert:     d.Insert,
-			Attributes: d.Attributes,
-		}
-	}
-	return result
-}
-
-// Helper function to convert Keys map
-func convertKeys(keys map[string]ChangeKey) map[string]contracts.ChangeKey {
-	result := make(map[string]contracts.ChangeKey)
-	for k, v := range keys {
-		// Convert string action to ChangeAction enum
-		var action contracts.ChangeAction
-		switch v.Action {
-		case "Add":
-			action = contracts.ChangeActionAdd
-		case "Update":
-			action = contracts.ChangeActionUpdate
-		case "Delete":
-			action = contracts.ChangeActionDelete
-		default:
-			action = contracts.ChangeActionUpdate
-		}
-
-		result[k] = contracts.ChangeKey{
-			Action:   action,
-			OldValue: v.OldValue,
-		}
-	}
-	return result
+	return ye.collectChanges()
 }
 
-// Deletes checks if a struct is deleted by this event
+// deletes checks if a struct is deleted by this event
 func (ye *YEvent) deletes(str contracts.IStructItem) bool {
 	return ye.transaction.GetDeleteSet().IsDeleted(str.GetID())
 }
 
-// Adds checks if a struct is added by this event
+// adds checks if a struct is added by this event
 func (ye *YEvent) adds(str contracts.IStructItem) bool {
 	beforeClock, exists := ye.transaction.GetBeforeState()[str.GetID().Client]
 	return !exists || str.GetID().Clock >= beforeClock
 }
 
-// collectChanges collects all changes for this event
-func (ye *YEvent) collectChanges() *ChangesCollection {
-	if ye.changes == nil {
-		added := make(map[contracts.IStructItem]struct{})
-		deleted := make(map[contracts.IStructItem]struct{})
-		delta := make([]Delta, 0)
-		keys := make(map[string]ChangeKey)
-
-		ye.changes = &ChangesCollection{
-			Added:   added,
-			Deleted: deleted,
-			Delta:   delta,
-			Keys:    keys,
-		}
+// collectChanges computes the changes of this event once and caches them
+func (ye *YEvent) collectChanges() *contracts.ChangesCollection {
+	if ye.changes != nil {
+		return ye.changes
+	}
 
-		changed, exists := ye.transaction.GetChanged()[ye.target]
-		if !exists {
-			changed = make(map[string]struct{})
-			ye.transaction.GetChanged()[ye.target] = changed
-		}
+	target := ye.target
+	changes := contracts.NewChangesCollection()
+	ye.changes = changes
+
+	changed, exists := ye.transaction.GetChanged()[target]
+	if !exists {
+		changed = make(map[string]struct{})
+		ye.transaction.GetChanged()[target] = changed
+	}
 
-		// Check if null key exists (indicating array changes)
-		if _, hasNullKey := changed[""]; hasNullKey {
-			var lastOp *Delta
+	// The empty key marks changes of the list content
+	if _, hasListChanges := changed[""]; hasListChanges {
+		var lastOp *contracts.Delta
 
-			packOp := func() {
-				if lastOp != nil {
-					delta = append(delta, *lastOp)
-					ye.changes.Delta = delta
-				}
+		packOp := func() {
+			if lastOp != nil {
+				changes.Delta = append(changes.Delta, *lastOp)
 			}
+		}
 
-			for item := ye.target.GetStart(); item != nil; item = item.GetRight() {
-				if item.GetDeleted() {
-					if ye.deletes(item) && !ye.adds(item) {
-						if lastOp == nil || lastOp.Delete == nil {
-							packOp()
-							deleteVal := 0
-							lastOp = &Delta{Delete: &deleteVal}
-						}
-						*lastOp.Delete += item.GetLength()
-						deleted[item] = struct{}{}
+		for item := target.GetStart(); item != nil; item = item.GetRight() {
+			if item.GetDeleted() {
+				if ye.deletes(item) && !ye.adds(item) {
+					if lastOp == nil || lastOp.Delete == nil {
+						packOp()
+						lastOp = &contracts.Delta{Delete: new(int)}
+					}
+					*lastOp.Delete += item.GetLength()
+					changes.Deleted[item] = struct{}{}
+				}
+				// Else: items that were added and deleted in the same transaction are skipped.
+			} else {
+				if ye.adds(item) {
+					if lastOp == nil || lastOp.Insert == nil {
+						packOp()
+						lastOp = &contracts.Delta{Insert: make([]interface{}, 0, 1)}
 					}
-					// else: do nothing for items that were added and then deleted in the same transaction
+					lastOp.Insert = append(lastOp.Insert.([]interface{}), item.GetContent().GetContent()...)
+					changes.Added[item] = struct{}{}
 				} else {
-					if ye.adds(item) {
-						if lastOp == nil || lastOp.Insert == nil {
-							packOp()
-							lastOp = &Delta{Insert: make([]interface{}, 0)}
-						}
-						content := item.GetContent().GetContent()
-						lastOp.Insert = append(lastOp.Insert, content...)
-						added[item] = struct{}{}
-					} else {
-						if lastOp == nil || lastOp.Retain == nil {
-							packOp()
-							retainVal := 0
-							lastOp = &Delta{Retain: &retainVal}
-						}
-						*lastOp.Retain += item.GetLength()
+					if lastOp == nil || lastOp.Retain == nil {
+						packOp()
+						lastOp = &contracts.Delta{Retain: new(int)}
 					}
+					*lastOp.Retain += item.GetLength()
 				}
 			}
+		}
 
-			if lastOp != nil && (lastOp.Retain != nil && *lastOp.Retain > 0) {
-				packOp()
-			}
+		if lastOp != nil && lastOp.Retain == nil {
+			packOp()
+		}
+
+		// The items of a list with moves are not in the order it renders them
+		if list, ok := target.(*YArray); ok && list.hasMoves {
+			changes.Delta = list.moveDelta(ye)
+		}
+	}
+
+	for key := range changed {
+		if key == "" {
+			continue
 		}
 
-		// Handle key changes for maps
-		for key := range changed {
-			if key != "" { // Skip the null key we handled above
-				// Simplified key change handling
-				keys[key] = ChangeKey{
-					Action: "update", // This would need more sophisticated logic
+		var action contracts.ChangeAction
+		var oldValue interface{}
+		item := target.GetMap()[key]
+
+		if ye.adds(item) {
+			prev := item.GetLeft()
+			for prev != nil && ye.adds(prev) {
+				prev = prev.GetLeft()
+			}
+
+			if ye.deletes(item) {
+				if prev == nil || !ye.deletes(prev) {
+					continue
 				}
+				action = contracts.ChangeActionDelete
+				oldValue = lastContent(prev)
+			} else if prev != nil && ye.deletes(prev) {
+				action = contracts.ChangeActionUpdate
+				oldValue = lastContent(prev)
+			} else {
+				action = contracts.ChangeActionAdd
 			}
+		} else {
+			if !ye.deletes(item) {
+				continue
+			}
+			action = contracts.ChangeActionDelete
+			oldValue = lastContent(item)
 		}
+
+		changes.Keys[key] = contracts.NewChangeKey(action, oldValue)
 	}
 
-	return ye.changes
+	return changes
 }
 
-// getPathTo returns the path from one type to another
+// lastContent returns the last value of an item's content
+func lastContent(item contracts.IStructItem) interface{} {
+	content := item.GetContent().GetContent()
+	if len(content) == 0 {
+		return nil
+	}
+	return content[len(content)-1]
+}
+
+// getPathTo computes the path from parent to child, made of map keys and array indices
 func (ye *YEvent) getPathTo(parent, child contracts.IAbstractType) []interface{} {
-	path := make([]interface{}, 0)
-	// This is a simplified implementation
-	// In a full implementation, you'd traverse the parent-child relationship
+	var path []interface{}
+
+	for child.GetItem() != nil && child != parent {
+		item := child.GetItem()
+		itemParent, ok := item.GetParent().(contracts.IAbstractType)
+		if !ok {
+			break
+		}
+
+		if parentSub := item.GetParentSub(); parentSub != "" {
+			// Parent is map-ish.
+			path = append(path, parentSub)
+		} else {
+			// Parent is array-ish.
+			i := 0
+			for c := itemParent.GetStart(); c != item && c != nil; c = c.GetRight() {
+				if !c.GetDeleted() {
+					i++
+				}
+			}
+			path = append(path, i)
+		}
+
+		child = itemParent
+	}
+
+	// The path was collected from the child up, reverse it.
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
 	return path
 }
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"ycs/contracts"
+)
+
+func TestYEventMapKeyChanges(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	m := doc.GetMap("m").(*YMap)
+	m.Set("a", int64(1))
+	m.Set("b", int64(2))
+
+	var keys map[string]contracts.ChangeKey
+	m.Observe(func(args contracts.YEventArgs) {
+		keys = args.Event.GetChanges().Keys
+	})
+	doc.Transact(func(tr contracts.ITransaction) {
+		m.Set("a", int64(3))
+		m.Delete("b")
+		m.Set("c", int64(4))
+	}, nil)
+
+	want := map[string]contracts.ChangeKey{
+		"a": contracts.NewChangeKey(contracts.ChangeActionUpdate, int64(1)),
+		"b": contracts.NewChangeKey(contracts.ChangeActionDelete, int64(2)),
+		"c": contracts.NewChangeKey(contracts.ChangeActionAdd, nil),
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("got %v, want %v", keys, want)
+	}
+}
+
+func TestYEventArrayDelta(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	a := doc.GetArray("a").(*YArray)
+	a.Insert(0, []interface{}{int64(1), int64(2), int64(3)})
+
+	var delta []contracts.Delta
+	a.Observe(func(args contracts.YEventArgs) {
+		delta = args.Event.GetChanges().Delta
+	})
+	doc.Transact(func(tr contracts.ITransaction) {
+		a.Delete(1, 1)
+		a.Insert(2, []interface{}{int64(4)})
+	}, nil)
+
+	one := 1
+	want := []contracts.Delta{
+		{Retain: &one},
+		{Delete: &one},
+		{Retain: &one},
+		{Insert: []interface{}{int64(4)}},
+	}
+	if len(delta) != len(want) {
+		t.Fatalf("got %d ops: %+v", len(delta), delta)
+	}
+	for i := range want {
+		got := delta[i]
+		if !reflect.DeepEqual(got.Insert, want[i].Insert) ||
+			(got.Retain == nil) != (want[i].Retain == nil) || (got.Retain != nil && *got.Retain != one) ||
+			(got.Delete == nil) != (want[i].Delete == nil) || (got.Delete != nil && *got.Delete != one) {
+			t.Fatalf("op %d: got %+v, want %+v", i, got, want[i])
+		}
+	}
+}
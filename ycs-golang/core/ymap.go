@@ -1,6 +1,7 @@
 package core
 
 import (
+	"sort"
 	"ycs/contracts"
 )
 
@@ -32,6 +33,7 @@ func NewYMap(entries map[string]interface{}) *YMap {
 		AbstractType:  NewAbstractType(),
 		prelimContent: make(map[string]interface{}),
 	}
+	ym.setSelf(ym)
 
 	if entries != nil {
 		for k, v := range entries {
@@ -59,6 +61,10 @@ func (ym *YMap) GetCount() int {
 
 // Get returns the value for the specified key
 func (ym *YMap) Get(key string) interface{} {
+	if ym.prelimContent != nil {
+		return ym.prelimContent[key]
+	}
+
 	value, exists := ym.tryTypeMapGet(key)
 	if !exists {
 		return nil
@@ -68,8 +74,9 @@ func (ym *YMap) Get(key string) interface{} {
 
 // Set sets a value for the specified key
 func (ym *YMap) Set(key string, value interface{}) {
+	checkInsertable(value)
 	if ym.GetDoc() != nil {
-		ym.GetDoc().Transact(func(tr contracts.ITransaction) {
+		transact(ym.GetDoc(), func(tr contracts.ITransaction) {
 			ym.typeMapSet(tr, key, value)
 		}, nil, true)
 	} else {
@@ -80,7 +87,7 @@ func (ym *YMap) Set(key string, value interface{}) {
 // Delete removes the specified key from the map
 func (ym *YMap) Delete(key string) {
 	if ym.GetDoc() != nil {
-		ym.GetDoc().Transact(func(tr contracts.ITransaction) {
+		transact(ym.GetDoc(), func(tr contracts.ITransaction) {
 			ym.typeMapDelete(tr, key)
 		}, nil, true)
 	} else {
@@ -90,12 +97,21 @@ func (ym *YMap) Delete(key string) {
 
 // ContainsKey checks if the map contains the specified key
 func (ym *YMap) ContainsKey(key string) bool {
+	if ym.prelimContent != nil {
+		_, contains := ym.prelimContent[key]
+		return contains
+	}
+
 	val, exists := ym.GetMap()[key]
 	return exists && !val.GetDeleted()
 }
 
 // Keys returns all keys in the map
 func (ym *YMap) Keys() []string {
+	if ym.prelimContent != nil {
+		return sortedKeys(ym.prelimContent)
+	}
+
 	var keys []string
 	for key := range ym.typeMapEnumerate() {
 		keys = append(keys, key)
@@ -105,6 +121,14 @@ func (ym *YMap) Keys() []string {
 
 // Values returns all values in the map
 func (ym *YMap) Values() []interface{} {
+	if ym.prelimContent != nil {
+		values := make([]interface{}, 0, len(ym.prelimContent))
+		for _, key := range sortedKeys(ym.prelimContent) {
+			values = append(values, ym.prelimContent[key])
+		}
+		return values
+	}
+
 	var values []interface{}
 	for _, item := range ym.typeMapEnumerate() {
 		content := item.GetContent().GetContent()
@@ -123,13 +147,15 @@ func (ym *YMap) InternalCopy() contracts.IAbstractType {
 	return NewYMap(nil)
 }
 
-// InternalClone creates an internal clone
+// InternalClone creates a preliminary deep copy of the map: nested types are cloned too
 func (ym *YMap) InternalClone() contracts.IAbstractType {
 	ymap := NewYMap(nil)
 
-	for key, item := range ym.typeMapEnumerate() {
-		// TODO: Check if this should handle AbstractType cloning
-		ymap.Set(key, item)
+	for key, value := range ym.GetEnumerator() {
+		if at, ok := value.(contracts.IAbstractType); ok {
+			value = at.InternalClone()
+		}
+		ymap.Set(key, value)
 	}
 
 	return ymap
@@ -139,11 +165,13 @@ func (ym *YMap) InternalClone() contracts.IAbstractType {
 func (ym *YMap) Integrate(doc contracts.IYDoc, item contracts.IStructItem) {
 	ym.AbstractType.Integrate(doc, item)
 
-	for key, value := range ym.prelimContent {
-		ym.Set(key, value)
-	}
-
+	prelimContent := ym.prelimContent
 	ym.prelimContent = nil
+
+	// Keys are inserted in a stable order so that equal preliminary maps produce equal updates
+	for _, key := range sortedKeys(prelimContent) {
+		ym.Set(key, prelimContent[key])
+	}
 }
 
 // CallObserver creates YMapEvent and calls observers
@@ -163,10 +191,52 @@ func ReadYMap(decoder contracts.IUpdateDecoder) contracts.IYMap {
 
 // Entries returns all key-value pairs in the map
 func (ym *YMap) Entries() map[string]interface{} {
-	return ym.typeMapEnumerateValues()
+	return ym.GetEnumerator()
 }
 
 // GetEnumerator returns all key-value pairs (implements IYMap interface)
 func (ym *YMap) GetEnumerator() map[string]interface{} {
+	if ym.prelimContent != nil {
+		entries := make(map[string]interface{}, len(ym.prelimContent))
+		for key, value := range ym.prelimContent {
+			entries[key] = value
+		}
+		return entries
+	}
+
 	return ym.typeMapEnumerateValues()
 }
+
+// EntriesAt returns the entries the map had at snapshot. The history is read in
+// place, so the document must not be garbage collected. Nested types are returned as
+// they are now; read them with their own snapshot methods.
+func (ym *YMap) EntriesAt(snapshot contracts.ISnapshot) (entries map[string]interface{}) {
+	if ym.prelimContent != nil {
+		return make(map[string]interface{})
+	}
+
+	ym.viewSnapshot(snapshot, func() {
+		entries = ym.typeMapEnumerateSnapshot(snapshot)
+	})
+	return entries
+}
+
+// ToJSON returns the entries of the map with nested shared types rendered for
+// encoding/json. Texts render as strings, or as deltas if textAsDelta is set.
+func (ym *YMap) ToJSON(textAsDelta ...bool) interface{} {
+	if ym.prelimContent != nil {
+		return jsonValue(ym.prelimContent, textAsDelta)
+	}
+
+	return jsonValue(ym.typeMapEnumerateValues(), textAsDelta)
+}
+
+// sortedKeys returns the keys of m in ascending order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"ycs/contracts"
+)
+
+func TestYMapSyncsToDefinedMap(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	m := doc.GetMap("m")
+	m.Set("a", int64(1))
+	m.Set("a", int64(2))
+	m.Set("b", "x")
+
+	remote := NewYDoc(contracts.YDocOptions{})
+	remoteMap := remote.GetMap("m")
+	remote.ApplyUpdateV2(encodeUpdate(t, doc), nil)
+
+	want := map[string]interface{}{"a": int64(2), "b": "x"}
+	if got := remoteMap.GetEnumerator(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := m.GetEnumerator(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("local got %v, want %v", got, want)
+	}
+}
+
+func TestYMapParentIsConcreteType(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	m := doc.GetMap("m")
+	m.Set("a", int64(1))
+
+	item := m.GetMap()["a"]
+	if _, ok := item.GetParent().(*YMap); !ok {
+		t.Fatalf("parent is %T, want *YMap", item.GetParent())
+	}
+	if got := m.FindRootTypeKey(); got != "m" {
+		t.Fatalf("root key %q", got)
+	}
+}
+
+func TestYMapPrelimContentEncodesStably(t *testing.T) {
+	encode := func() []byte {
+		doc := NewYDoc(contracts.YDocOptions{})
+		doc.SetClientID(1)
+		doc.GetMap("m").Set("nested", NewYMap(map[string]interface{}{"z": int64(1), "a": int64(2), "m": int64(3)}))
+		return encodeUpdate(t, doc)
+	}
+
+	first := encode()
+	for i := 0; i < 10; i++ {
+		if !reflect.DeepEqual(encode(), first) {
+			t.Fatal("equal preliminary maps encode differently")
+		}
+	}
+}
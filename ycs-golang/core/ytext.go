@@ -1,12 +1,18 @@
 package core
 
 import (
+	"reflect"
 	"strings"
+	"unicode/utf16"
+	"ycs/content"
 	"ycs/contracts"
 )
 
 const YTextRefID = 2
 
+// YTextChangeKey is the attribute key under which ToDelta reports snapshot changes
+const YTextChangeKey = "ychange"
+
 // ChangeType represents the type of change in YText
 type ChangeType int
 
@@ -26,28 +32,26 @@ type YTextEvent struct {
 }
 
 // NewYTextEvent creates a new YTextEvent
-func NewYTextEvent(text contracts.IYText, transaction contracts.ITransaction, subs map[string]struct{}) *YTextEvent {
+func NewYTextEvent(text *YText, transaction contracts.ITransaction, subs map[string]struct{}) *YTextEvent {
 	event := &YTextEvent{
-		YEvent:           NewYEvent(text.(contracts.IAbstractType), transaction),
+		YEvent:           NewYEvent(text, transaction),
 		Subs:             subs,
 		KeysChanged:      make(map[string]struct{}),
 		ChildListChanged: false,
 	}
 
-	if subs != nil {
-		for sub := range subs {
-			if sub == "" {
-				event.ChildListChanged = true
-			} else {
-				event.KeysChanged[sub] = struct{}{}
-			}
+	for sub := range subs {
+		if sub == "" {
+			event.ChildListChanged = true
+		} else {
+			event.KeysChanged[sub] = struct{}{}
 		}
 	}
 
 	return event
 }
 
-// GetDelta returns the delta representation of changes
+// GetDelta returns the changes of this event in the delta format
 func (yte *YTextEvent) GetDelta() []contracts.Delta {
 	if yte.delta == nil {
 		yte.computeDelta()
@@ -55,65 +59,352 @@ func (yte *YTextEvent) GetDelta() []contracts.Delta {
 	return yte.delta
 }
 
-// computeDelta computes the delta representation (simplified version)
+// computeDelta computes the delta of this event. Format items that turn out to be
+// redundant are deleted on the way.
 func (yte *YTextEvent) computeDelta() {
-	// This is a simplified implementation
-	// The full implementation would be much more complex
-	yte.delta = []contracts.Delta{}
+	doc := yte.GetTarget().GetDoc()
+	delta := make([]contracts.Delta, 0)
+
+	transact(doc, func(transaction contracts.ITransaction) {
+		// Saves all current attributes for insert.
+		currentAttributes := make(map[string]interface{})
+		oldAttributes := make(map[string]interface{})
+		attributes := make(map[string]interface{})
+		item := yte.GetTarget().GetStart()
+
+		var action *ChangeType
+		var insert interface{} = ""
+		retain := 0
+		deleteLen := 0
+
+		setAction := func(a ChangeType) {
+			action = &a
+		}
+
+		addOp := func() {
+			if action == nil {
+				return
+			}
+
+			var op contracts.Delta
+			switch *action {
+			case ChangeTypeDelete:
+				deleteCount := deleteLen
+				op = contracts.Delta{Delete: &deleteCount}
+				deleteLen = 0
+			case ChangeTypeInsert:
+				op = contracts.Delta{Insert: insert}
+				if len(currentAttributes) > 0 {
+					op.Attributes = make(map[string]interface{})
+					for key, value := range currentAttributes {
+						if value != nil {
+							op.Attributes[key] = value
+						}
+					}
+				}
+				insert = ""
+			case ChangeTypeRetain:
+				retainCount := retain
+				op = contracts.Delta{Retain: &retainCount}
+				if len(attributes) > 0 {
+					op.Attributes = make(map[string]interface{}, len(attributes))
+					for key, value := range attributes {
+						op.Attributes[key] = value
+					}
+				}
+				retain = 0
+			}
+
+			delta = append(delta, op)
+			action = nil
+		}
+
+		for ; item != nil; item = item.GetRight() {
+			switch c := item.GetContent().(type) {
+			case *content.ContentEmbed:
+				if yte.adds(item) {
+					if !yte.deletes(item) {
+						addOp()
+						setAction(ChangeTypeInsert)
+						insert = c.GetEmbed()
+						addOp()
+					}
+				} else if yte.deletes(item) {
+					if action == nil || *action != ChangeTypeDelete {
+						addOp()
+						setAction(ChangeTypeDelete)
+					}
+					deleteLen++
+				} else if !item.GetDeleted() {
+					if action == nil || *action != ChangeTypeRetain {
+						addOp()
+						setAction(ChangeTypeRetain)
+					}
+					retain++
+				}
+
+			case *content.ContentString:
+				if yte.adds(item) {
+					if !yte.deletes(item) {
+						if action == nil || *action != ChangeTypeInsert {
+							addOp()
+							setAction(ChangeTypeInsert)
+						}
+						insert = insert.(string) + c.GetString()
+					}
+				} else if yte.deletes(item) {
+					if action == nil || *action != ChangeTypeDelete {
+						addOp()
+						setAction(ChangeTypeDelete)
+					}
+					deleteLen += item.GetLength()
+				} else if !item.GetDeleted() {
+					if action == nil || *action != ChangeTypeRetain {
+						addOp()
+						setAction(ChangeTypeRetain)
+					}
+					retain += item.GetLength()
+				}
+
+			case *content.ContentFormat:
+				key, value := c.GetKey(), c.GetValue()
+				if yte.adds(item) {
+					if !yte.deletes(item) {
+						if !equalAttrs(currentAttributes[key], value) {
+							if action != nil && *action == ChangeTypeRetain {
+								addOp()
+							}
+							if equalAttrs(value, oldAttributes[key]) {
+								delete(attributes, key)
+							} else {
+								attributes[key] = value
+							}
+						} else {
+							item.Delete(transaction)
+						}
+					}
+				} else if yte.deletes(item) {
+					oldAttributes[key] = value
+					curVal := currentAttributes[key]
+					if !equalAttrs(curVal, value) {
+						if action != nil && *action == ChangeTypeRetain {
+							addOp()
+						}
+						attributes[key] = curVal
+					}
+				} else if !item.GetDeleted() {
+					oldAttributes[key] = value
+					if attr, ok := attributes[key]; ok {
+						if !equalAttrs(attr, value) {
+							if action != nil && *action == ChangeTypeRetain {
+								addOp()
+							}
+							if value == nil {
+								attributes[key] = nil
+							} else {
+								delete(attributes, key)
+							}
+						} else {
+							item.Delete(transaction)
+						}
+					}
+				}
+
+				if !item.GetDeleted() {
+					if action != nil && *action == ChangeTypeInsert {
+						addOp()
+					}
+					updateCurrentAttributes(currentAttributes, c)
+				}
+			}
+		}
+
+		addOp()
+
+		// Retain deltas are dropped from the end if they don't assign attributes.
+		for len(delta) > 0 {
+			lastOp := delta[len(delta)-1]
+			if lastOp.Retain != nil && lastOp.Attributes == nil {
+				delta = delta[:len(delta)-1]
+			} else {
+				break
+			}
+		}
+	}, nil)
+
+	yte.delta = delta
+}
+
+// itemTextListPosition is a cursor into the item list of a YText
+type itemTextListPosition struct {
+	left              contracts.IStructItem
+	right             contracts.IStructItem
+	index             int
+	currentAttributes map[string]interface{}
+}
+
+// forward moves the cursor one item to the right
+func (pos *itemTextListPosition) forward() {
+	if pos.right == nil {
+		panic("Unexpected")
+	}
+
+	switch c := pos.right.GetContent().(type) {
+	case *content.ContentEmbed, *content.ContentString:
+		if !pos.right.GetDeleted() {
+			pos.index += pos.right.GetLength()
+		}
+	case *content.ContentFormat:
+		if !pos.right.GetDeleted() {
+			updateCurrentAttributes(pos.currentAttributes, c)
+		}
+	}
+
+	pos.left = pos.right
+	pos.right = pos.right.GetRight()
+}
+
+// findNextPosition moves the cursor count characters to the right, splitting the item it stops in
+func (pos *itemTextListPosition) findNextPosition(transaction contracts.ITransaction, count int) {
+	for pos.right != nil && count > 0 {
+		switch c := pos.right.GetContent().(type) {
+		case *content.ContentEmbed, *content.ContentString:
+			if !pos.right.GetDeleted() {
+				if count < pos.right.GetLength() {
+					// Split right.
+					transaction.GetDoc().GetStore().GetItemCleanStart(transaction, contracts.StructID{Client: pos.right.GetID().Client, Clock: pos.right.GetID().Clock + int64(count)})
+				}
+				pos.index += pos.right.GetLength()
+				count -= pos.right.GetLength()
+			}
+		case *content.ContentFormat:
+			if !pos.right.GetDeleted() {
+				updateCurrentAttributes(pos.currentAttributes, c)
+			}
+		}
+
+		pos.left = pos.right
+		pos.right = pos.right.GetRight()
+		// We don't forward() because that would halve the performance because we already do the checks above.
+	}
+}
+
+// insertNegatedAttributes inserts the format items that end the attributes started before the cursor
+func (pos *itemTextListPosition) insertNegatedAttributes(transaction contracts.ITransaction, parent contracts.IAbstractType, negatedAttributes map[string]interface{}) {
+	// Check if we really need to remove attributes.
+	for pos.right != nil {
+		if !pos.right.GetDeleted() {
+			cf, ok := pos.right.GetContent().(*content.ContentFormat)
+			if !ok {
+				break
+			}
+			negated, exists := negatedAttributes[cf.GetKey()]
+			if !exists || !equalAttrs(negated, cf.GetValue()) {
+				break
+			}
+			delete(negatedAttributes, cf.GetKey())
+		}
+		pos.forward()
+	}
+
+	doc := transaction.GetDoc()
+	ownClientID := int64(doc.GetClientID())
+	left := pos.left
+	right := pos.right
+
+	for _, key := range sortedKeys(negatedAttributes) {
+		value := negatedAttributes[key]
+		left = newTextItem(doc, ownClientID, left, right, parent, content.NewContentFormat(key, value))
+		left.Integrate(transaction, 0)
+		pos.currentAttributes[key] = value
+		updateCurrentAttributes(pos.currentAttributes, left.GetContent().(*content.ContentFormat))
+	}
+	// Content inserted next goes after the formats that end the attributes
+	pos.left = left
+}
+
+// minimizeAttributeChanges moves the cursor right while the format items it passes don't change attributes
+func (pos *itemTextListPosition) minimizeAttributeChanges(attributes map[string]interface{}) {
+	// Go right while attributes[right.Key] == right.Value (or right is deleted).
+	for pos.right != nil {
+		if pos.right.GetDeleted() {
+			pos.forward()
+			continue
+		}
+		if cf, ok := pos.right.GetContent().(*content.ContentFormat); ok && equalAttrs(attributes[cf.GetKey()], cf.GetValue()) {
+			pos.forward()
+			continue
+		}
+		break
+	}
+}
+
+// newTextItem creates a new item between left and right with the next clock of the local client
+func newTextItem(doc contracts.IYDoc, ownClientID int64, left, right contracts.IStructItem, parent contracts.IAbstractType, c contracts.IContentEx) contracts.IStructItem {
+	var leftOrigin, rightOrigin *contracts.StructID
+	if left != nil {
+		lastID := left.GetLastID()
+		leftOrigin = &lastID
+	}
+	if right != nil {
+		rightID := right.GetID()
+		rightOrigin = &rightID
+	}
+	return NewStructItem(contracts.StructID{Client: ownClientID, Clock: doc.GetStore().GetState(ownClientID)}, left, leftOrigin, right, rightOrigin, parent, nil, c)
 }
 
 // YText represents a shared text implementation
 type YText struct {
-	*AbstractType
-	prelimContent []interface{}
+	*YArrayBase
+	// pending holds the changes made before the text is integrated
+	pending []func(yt *YText)
 }
 
-// NewYText creates a new YText
-func NewYText(prelimContent []interface{}) *YText {
+// NewYText creates a new YText with optional initial text
+func NewYText(text string) *YText {
 	yt := &YText{
-		AbstractType:  NewAbstractType(),
-		prelimContent: make([]interface{}, 0),
+		YArrayBase: NewYArrayBase(),
 	}
+	yt.setSelf(yt)
 
-	if prelimContent != nil {
-		yt.prelimContent = append(yt.prelimContent, prelimContent...)
+	if text != "" {
+		yt.pending = append(yt.pending, func(yt *YText) { yt.Insert(0, text) })
 	}
 
 	return yt
 }
 
-// GetLength returns the length of the text
-func (yt *YText) GetLength() int {
-	if yt.prelimContent != nil {
-		return len(yt.prelimContent)
-	}
-	return yt.AbstractType.GetLength()
-}
-
 // Clone creates a clone of the YText
 func (yt *YText) Clone() contracts.IYText {
 	return yt.InternalClone().(contracts.IYText)
 }
 
-// Integrate integrates the YText into a document
+// Integrate integrates the YText into a document and applies the pending changes
 func (yt *YText) Integrate(doc contracts.IYDoc, item contracts.IStructItem) {
-	yt.AbstractType.Integrate(doc, item)
-	if len(yt.prelimContent) > 0 {
-		yt.InsertContent(0, yt.prelimContent)
-		yt.prelimContent = nil
+	yt.YArrayBase.Integrate(doc, item)
+
+	pending := yt.pending
+	yt.pending = nil
+	for _, change := range pending {
+		change(yt)
 	}
 }
 
 // InternalCopy creates an internal copy
 func (yt *YText) InternalCopy() contracts.IAbstractType {
-	return NewYText(nil)
+	return NewYText("")
 }
 
 // InternalClone creates an internal clone
 func (yt *YText) InternalClone() contracts.IAbstractType {
-	clone := NewYText(nil)
-	// Clone the content - simplified implementation
-	return clone
+	text := NewYText("")
+	if yt.GetDoc() == nil {
+		// A preliminary text has no content yet, only changes to replay
+		text.pending = append(text.pending, yt.pending...)
+		return text
+	}
+	text.ApplyDelta(yt.ToDelta(nil, nil, nil))
+	return text
 }
 
 // Write writes the YText to an encoder
@@ -123,174 +414,765 @@ func (yt *YText) Write(encoder contracts.IUpdateEncoder) {
 
 // ReadYText reads a YText from a decoder
 func ReadYText(decoder contracts.IUpdateDecoder) contracts.IAbstractType {
-	return NewYText(nil)
+	return NewYText("")
 }
 
-// CallObserver creates YTextEvent and calls observers
+// CallObserver creates YTextEvent and calls observers. For remote changes the
+// formatting items made redundant by concurrent edits are cleaned up first.
 func (yt *YText) CallObserver(transaction contracts.ITransaction, parentSubs map[string]struct{}) {
-	yt.AbstractType.CallObserver(transaction, parentSubs)
-	// Create interface reference for YText
-	var ytInterface contracts.IYText = yt
-	yt.CallTypeObservers(transaction, NewYTextEvent(ytInterface, transaction, parentSubs))
+	yt.YArrayBase.CallObserver(transaction, parentSubs)
+	evt := NewYTextEvent(yt, transaction, parentSubs)
+	doc := transaction.GetDoc()
+
+	if !transaction.GetLocal() {
+		// Check if another formatting item was inserted.
+		foundFormattingItem := false
+		for client, afterClock := range transaction.GetAfterState() {
+			clock := transaction.GetBeforeState()[client]
+			if afterClock == clock {
+				continue
+			}
+
+			doc.GetStore().IterateStructs(transaction, doc.GetStore().GetClients()[client], clock, afterClock, func(item contracts.IStructItem) bool {
+				if !item.IsGC() && !item.GetDeleted() {
+					if _, ok := item.GetContent().(*content.ContentFormat); ok {
+						foundFormattingItem = true
+						// Stop loop.
+						return false
+					}
+				}
+				return true
+			})
+
+			if foundFormattingItem {
+				break
+			}
+		}
+
+		if !foundFormattingItem {
+			transaction.GetDeleteSet().IterateDeletedStructs(transaction, func(item contracts.IStructItem) bool {
+				if !item.IsGC() && item.GetParent() == contracts.IAbstractType(yt) {
+					if _, ok := item.GetContent().(*content.ContentFormat); ok {
+						foundFormattingItem = true
+						// Don't iterate further.
+						return false
+					}
+				}
+				return true
+			})
+		}
+
+		transact(doc, func(tr contracts.ITransaction) {
+			if foundFormattingItem {
+				// If a formatting item was inserted, we simply clean the whole type.
+				// We need to compute currentAttributes for the current position anyway.
+				yt.CleanupFormatting()
+			} else {
+				// If no formatting attribute was inserted, we can make due with contextless formatting cleanups.
+				// Contextless: it is not necessary to compute currentAttributes for the affected position.
+				tr.GetDeleteSet().IterateDeletedStructs(tr, func(item contracts.IStructItem) bool {
+					if !item.IsGC() && item.GetParent() == contracts.IAbstractType(yt) {
+						yt.cleanupContextlessFormattingGap(tr, item)
+					}
+					return true
+				})
+			}
+		}, nil)
+	}
+
+	yt.CallTypeObservers(transaction, evt)
 }
 
-// InsertContent inserts content array at the specified index (internal method)
-func (yt *YText) InsertContent(index int, content []interface{}) {
-	yt.insertContent(index, content)
+// ApplyDelta applies a delta in the Quill format to the text. Unless sanitize is
+// false, a trailing newline of the last insert is dropped.
+func (yt *YText) ApplyDelta(delta []contracts.Delta, sanitize ...bool) {
+	sanitizeValue := true
+	if len(sanitize) > 0 {
+		sanitizeValue = sanitize[0]
+	}
+
+	if yt.GetDoc() == nil {
+		yt.pending = append(yt.pending, func(yt *YText) { yt.ApplyDelta(delta, sanitizeValue) })
+		return
+	}
+
+	transact(yt.GetDoc(), func(tr contracts.ITransaction) {
+		curPos := &itemTextListPosition{right: yt.GetStart(), currentAttributes: make(map[string]interface{})}
+
+		for i, op := range delta {
+			attributes := op.Attributes
+			if attributes == nil {
+				attributes = make(map[string]interface{})
+			}
+
+			switch {
+			case op.Insert != nil:
+				// Quill assumes that the content starts with an empty paragraph.
+				// Yjs/Y.Text assumes that it starts empty. We always hide that
+				// there is a newline at the end of the content.
+				// If we omit this step, clients will see a different number of paragraphs,
+				// but nothing bad will happen.
+				ins := op.Insert
+				if insertStr, ok := ins.(string); ok {
+					if !sanitizeValue && i == len(delta)-1 && curPos.right == nil && strings.HasSuffix(insertStr, "\n") {
+						insertStr = insertStr[:len(insertStr)-1]
+					}
+					if insertStr == "" {
+						continue
+					}
+					ins = insertStr
+				}
+				yt.insertText(tr, curPos, ins, attributes)
+			case op.Retain != nil:
+				yt.formatText(tr, curPos, *op.Retain, attributes)
+			case op.Delete != nil:
+				yt.deleteText(tr, curPos, *op.Delete)
+			}
+		}
+	}, nil)
 }
 
-// insertAt performs the actual insertion during a transaction
-func (yt *YText) insertAt(transaction contracts.ITransaction, index int, content []interface{}) {
-	// This is a simplified implementation
-	// The full implementation would handle complex text operations
+// ToDelta returns the content as a delta. When snapshots are given, the content
+// visible in either snapshot is returned and the changes between them are marked
+// with the "ychange" attribute, computed by computeYChange if it is set.
+func (yt *YText) ToDelta(snapshot contracts.ISnapshot, prevSnapshot contracts.ISnapshot, computeYChange func(contracts.YTextChangeType, contracts.StructID, contracts.YTextChangeAttributes) interface{}) []contracts.Delta {
+	ops := make([]contracts.Delta, 0)
+	currentAttributes := make(map[string]interface{})
+	var str strings.Builder
+
+	packStr := func() {
+		if str.Len() == 0 {
+			return
+		}
+
+		// Pack str with attributes to ops.
+		op := contracts.Delta{Insert: str.String()}
+		if len(currentAttributes) > 0 {
+			op.Attributes = make(map[string]interface{}, len(currentAttributes))
+			for key, value := range currentAttributes {
+				op.Attributes[key] = value
+			}
+		}
+		ops = append(ops, op)
+		str.Reset()
+	}
+
+	changeAttributes := func(changeType contracts.YTextChangeType, id contracts.StructID) interface{} {
+		attrs := contracts.YTextChangeAttributes{Type: changeType, User: int(id.Client), State: changeType}
+		if computeYChange != nil {
+			return computeYChange(changeType, id, attrs)
+		}
+		return attrs
+	}
+
+	collect := func() {
+		for n := yt.GetStart(); n != nil; n = n.GetRight() {
+			if !n.IsVisible(snapshot) && (prevSnapshot == nil || !n.IsVisible(prevSnapshot)) {
+				continue
+			}
+
+			switch c := n.GetContent().(type) {
+			case *content.ContentString:
+				var change interface{}
+				if snapshot != nil && !n.IsVisible(snapshot) {
+					change = changeAttributes(contracts.YTextChangeTypeRemoved, n.GetID())
+				} else if prevSnapshot != nil && !n.IsVisible(prevSnapshot) {
+					change = changeAttributes(contracts.YTextChangeTypeAdded, n.GetID())
+				}
+
+				// Consecutive changes attributed alike are packed together
+				cur, hasCur := currentAttributes[YTextChangeKey]
+				if change != nil && (!hasCur || !reflect.DeepEqual(cur, change)) {
+					packStr()
+					currentAttributes[YTextChangeKey] = change
+				} else if change == nil && hasCur {
+					packStr()
+					delete(currentAttributes, YTextChangeKey)
+				}
+				str.WriteString(c.GetString())
+
+			case *content.ContentEmbed:
+				packStr()
+				op := contracts.Delta{Insert: c.GetEmbed()}
+				if len(currentAttributes) > 0 {
+					op.Attributes = make(map[string]interface{}, len(currentAttributes))
+					for key, value := range currentAttributes {
+						op.Attributes[key] = value
+					}
+				}
+				ops = append(ops, op)
+
+			case *content.ContentFormat:
+				if n.IsVisible(snapshot) {
+					packStr()
+					updateCurrentAttributes(currentAttributes, c)
+				}
+			}
+		}
+		packStr()
+	}
+
+	doc := yt.GetDoc()
+	if doc == nil {
+		collect()
+		return ops
+	}
+
+	// Snapshots are merged again after the transaction, so we need to keep the
+	// transaction alive until we are done.
+	transact(doc, func(tr contracts.ITransaction) {
+		if snapshot != nil {
+			SplitSnapshotAffectedStructs(tr, snapshot)
+		}
+		if prevSnapshot != nil {
+			SplitSnapshotAffectedStructs(tr, prevSnapshot)
+		}
+		collect()
+	}, "splitSnapshotAffectedStructs")
+
+	return ops
 }
 
-// Delete deletes content from the specified range
-func (yt *YText) Delete(index int, length int) {
-	if yt.GetDoc() != nil {
-		yt.GetDoc().Transact(func(tr contracts.ITransaction) {
-			yt.deleteAt(tr, index, length)
-		}, "delete")
-	} else {
-		// Handle preliminary content deletion
-		if index < len(yt.prelimContent) {
-			end := index + length
-			if end > len(yt.prelimContent) {
-				end = len(yt.prelimContent)
+// Insert inserts text at index. Without attributes the text takes the
+// attributes of the text before it.
+func (yt *YText) Insert(index int, text string, attributes ...map[string]interface{}) {
+	if text == "" {
+		return
+	}
+
+	var attrs map[string]interface{}
+	if len(attributes) > 0 {
+		attrs = attributes[0]
+	}
+
+	if yt.GetDoc() == nil {
+		yt.pending = append(yt.pending, func(yt *YText) { yt.Insert(index, text, attributes...) })
+		return
+	}
+
+	transact(yt.GetDoc(), func(tr contracts.ITransaction) {
+		pos := yt.findPosition(tr, index)
+		if attrs == nil {
+			attrs = make(map[string]interface{}, len(pos.currentAttributes))
+			for key, value := range pos.currentAttributes {
+				attrs[key] = value
 			}
-			newContent := make([]interface{}, 0, len(yt.prelimContent)-(end-index))
-			newContent = append(newContent, yt.prelimContent[:index]...)
-			newContent = append(newContent, yt.prelimContent[end:]...)
-			yt.prelimContent = newContent
 		}
+		yt.insertText(tr, pos, text, attrs)
+	}, nil)
+}
+
+// InsertEmbed inserts an embed object at the specified index
+func (yt *YText) InsertEmbed(index int, embed interface{}, attributes ...map[string]interface{}) {
+	var attrs map[string]interface{}
+	if len(attributes) > 0 && attributes[0] != nil {
+		attrs = attributes[0]
+	} else {
+		attrs = make(map[string]interface{})
+	}
+
+	if yt.GetDoc() == nil {
+		yt.pending = append(yt.pending, func(yt *YText) { yt.InsertEmbed(index, embed, attrs) })
+		return
+	}
+
+	transact(yt.GetDoc(), func(tr contracts.ITransaction) {
+		pos := yt.findPosition(tr, index)
+		yt.insertText(tr, pos, embed, attrs)
+	}, nil)
+}
+
+// Delete deletes length characters starting at index
+func (yt *YText) Delete(index int, length int) {
+	if length == 0 {
+		return
 	}
+
+	if yt.GetDoc() == nil {
+		yt.pending = append(yt.pending, func(yt *YText) { yt.Delete(index, length) })
+		return
+	}
+
+	transact(yt.GetDoc(), func(tr contracts.ITransaction) {
+		pos := yt.findPosition(tr, index)
+		yt.deleteText(tr, pos, length)
+	}, nil)
 }
 
-// deleteAt performs the actual deletion during a transaction
-func (yt *YText) deleteAt(transaction contracts.ITransaction, index int, length int) {
-	// This is a simplified implementation
-	// The full implementation would handle complex text operations
+// SetFromString changes the text to text with as few inserts and deletes as possible,
+// in one transaction, so that the unchanged text keeps its formatting, attribution
+// and the relative positions pointing into it. The text is compared character by
+// character; embeds are not part of ToString and are kept.
+func (yt *YText) SetFromString(text string) {
+	if yt.GetDoc() == nil {
+		yt.pending = append(yt.pending, func(yt *YText) { yt.SetFromString(text) })
+		return
+	}
+
+	transact(yt.GetDoc(), func(tr contracts.ITransaction) {
+		// The characters of the text and the index of each, in UTF-16 code units
+		var oldChars []rune
+		var oldIndexes []int
+		index := 0
+		for n := yt.GetStart(); n != nil; n = n.GetRight() {
+			if n.GetDeleted() || !n.GetCountable() {
+				continue
+			}
+			cs, ok := n.GetContent().(*content.ContentString)
+			if !ok {
+				index += n.GetLength()
+				continue
+			}
+			for _, r := range cs.GetString() {
+				oldChars = append(oldChars, r)
+				oldIndexes = append(oldIndexes, index)
+				index += utf16.RuneLen(r)
+			}
+		}
+		newChars := []rune(text)
+
+		hunks := diffSequences(len(oldChars), len(newChars), func(i, j int) bool {
+			return oldChars[i] == newChars[j]
+		})
+
+		// Later hunks are applied first, so the indexes of earlier ones stay valid
+		for h := len(hunks) - 1; h >= 0; h-- {
+			hunk := hunks[h]
+
+			// Deleted characters separated by embeds are deleted range by range
+			for end := hunk.oldEnd; end > hunk.oldStart; {
+				start := end - 1
+				for start > hunk.oldStart && oldIndexes[start-1]+utf16.RuneLen(oldChars[start-1]) == oldIndexes[start] {
+					start--
+				}
+				last := oldIndexes[end-1] + utf16.RuneLen(oldChars[end-1])
+				yt.Delete(oldIndexes[start], last-oldIndexes[start])
+				end = start
+			}
+
+			// Inserted text follows the character before it, taking its attributes
+			if hunk.newEnd > hunk.newStart {
+				at := 0
+				if hunk.oldStart > 0 {
+					at = oldIndexes[hunk.oldStart-1] + utf16.RuneLen(oldChars[hunk.oldStart-1])
+				}
+				yt.Insert(at, string(newChars[hunk.newStart:hunk.newEnd]))
+			}
+		}
+	}, nil)
+}
+
+// Format applies attributes to a range of text. A nil attribute value removes the attribute.
+func (yt *YText) Format(index int, length int, attributes map[string]interface{}) {
+	if length == 0 {
+		return
+	}
+
+	if yt.GetDoc() == nil {
+		yt.pending = append(yt.pending, func(yt *YText) { yt.Format(index, length, attributes) })
+		return
+	}
+
+	transact(yt.GetDoc(), func(tr contracts.ITransaction) {
+		pos := yt.findPosition(tr, index)
+		if pos.right == nil {
+			return
+		}
+		yt.formatText(tr, pos, length, attributes)
+	}, nil)
 }
 
 // ToString returns the string representation of the text
 func (yt *YText) ToString() string {
-	if yt.prelimContent != nil {
-		var builder strings.Builder
-		for _, item := range yt.prelimContent {
-			if str, ok := item.(string); ok {
-				builder.WriteString(str)
+	var sb strings.Builder
+	for n := yt.GetStart(); n != nil; n = n.GetRight() {
+		if !n.GetDeleted() && n.GetCountable() {
+			if cs, ok := n.GetContent().(*content.ContentString); ok {
+				sb.WriteString(cs.GetString())
 			}
 		}
-		return builder.String()
 	}
+	return sb.String()
+}
 
-	// For integrated YText, iterate through the items
-	var builder strings.Builder
-	item := yt.GetStart()
-	for item != nil {
-		if !item.GetDeleted() && item.GetCountable() {
-			content := item.GetContent()
-			contentData := content.GetContent()
-			if len(contentData) > 0 {
-				if str, ok := contentData[0].(string); ok {
-					builder.WriteString(str)
+// ToStringAt returns the text at snapshot. The history is read in place, so the
+// document must not be garbage collected.
+func (yt *YText) ToStringAt(snapshot contracts.ISnapshot) string {
+	var sb strings.Builder
+	yt.viewSnapshot(snapshot, func() {
+		for n := yt.GetStart(); n != nil; n = n.GetRight() {
+			if n.IsVisible(snapshot) {
+				if cs, ok := n.GetContent().(*content.ContentString); ok {
+					sb.WriteString(cs.GetString())
 				}
 			}
 		}
-		item = item.GetNext()
-	}
-	return builder.String()
+	})
+	return sb.String()
 }
 
-// Format applies formatting to a range of text
-func (yt *YText) Format(index int, length int, attributes map[string]interface{}) {
-	if yt.GetDoc() != nil {
-		yt.GetDoc().Transact(func(tr contracts.ITransaction) {
-			yt.formatAt(tr, index, length, attributes)
-		}, "format")
+// Blame returns the ranges of the text inserted by one client each, in order.
+// Authors are looked up in users, which may be nil.
+func (yt *YText) Blame(users contracts.IUserAttribution) []contracts.TextBlame {
+	blame := make([]contracts.TextBlame, 0)
+	index := 0
+	for n := yt.GetStart(); n != nil; n = n.GetRight() {
+		if n.GetDeleted() || !n.GetCountable() {
+			continue
+		}
+
+		client := n.GetID().Client
+		if last := len(blame) - 1; last >= 0 && blame[last].Client == client {
+			blame[last].Length += n.GetLength()
+		} else {
+			author := ""
+			if users != nil {
+				author = users.GetUserByClientID(client)
+			}
+			blame = append(blame, contracts.TextBlame{Index: index, Length: n.GetLength(), Client: client, Author: author})
+		}
+		index += n.GetLength()
 	}
+	return blame
 }
 
-// formatAt performs the actual formatting during a transaction
-func (yt *YText) formatAt(transaction contracts.ITransaction, index int, length int, attributes map[string]interface{}) {
-	// This is a simplified implementation
-	// The full implementation would handle complex formatting operations
+// String implements fmt.Stringer
+func (yt *YText) String() string {
+	return yt.ToString()
 }
 
-// GetAttributesAt returns the attributes at the specified index
-func (yt *YText) GetAttributesAt(index int) map[string]interface{} {
-	// This is a simplified implementation
-	return make(map[string]interface{})
+// ToJSON returns the text as a string, or as a delta if textAsDelta is set. A delta
+// is a list of {"insert": ..., "attributes": {...}} operations as Quill uses them;
+// embedded shared types are rendered with their ToJSON.
+func (yt *YText) ToJSON(textAsDelta ...bool) interface{} {
+	if len(textAsDelta) == 0 || !textAsDelta[0] {
+		return yt.ToString()
+	}
+
+	delta := yt.ToDelta(nil, nil, nil)
+	ops := make([]interface{}, 0, len(delta))
+	for _, d := range delta {
+		op := map[string]interface{}{"insert": jsonValue(d.Insert, textAsDelta)}
+		if len(d.Attributes) > 0 {
+			op["attributes"] = jsonValue(d.Attributes, textAsDelta)
+		}
+		ops = append(ops, op)
+	}
+	return ops
 }
 
-// TryGc attempts to garbage collect the YText
-func (yt *YText) TryGc(store contracts.IStructStore) {
-	// This is a simplified implementation
-	// The full implementation would handle garbage collection
+// RemoveAttribute removes an attribute of the text type
+func (yt *YText) RemoveAttribute(name string) {
+	if yt.GetDoc() == nil {
+		yt.pending = append(yt.pending, func(yt *YText) { yt.RemoveAttribute(name) })
+		return
+	}
+
+	transact(yt.GetDoc(), func(tr contracts.ITransaction) {
+		yt.typeMapDelete(tr, name)
+	}, nil)
 }
 
-// ApplyDelta applies delta operations to the text
-func (yt *YText) ApplyDelta(delta []contracts.Delta, sanitize ...bool) {
-	// TODO: Implement delta application
+// SetAttribute sets an attribute of the text type
+func (yt *YText) SetAttribute(name string, value interface{}) {
+	if yt.GetDoc() == nil {
+		yt.pending = append(yt.pending, func(yt *YText) { yt.SetAttribute(name, value) })
+		return
+	}
+
+	transact(yt.GetDoc(), func(tr contracts.ITransaction) {
+		yt.typeMapSet(tr, name, value)
+	}, nil)
 }
 
-// GetAttribute returns a single attribute value
+// GetAttribute returns an attribute of the text type
 func (yt *YText) GetAttribute(name string) interface{} {
-	// TODO: Implement attribute retrieval
-	return nil
+	value, _ := yt.tryTypeMapGet(name)
+	return value
 }
 
-// GetAttributes returns all attributes (implements IYText interface)
+// GetAttributes returns all attributes of the text type
 func (yt *YText) GetAttributes() map[string]interface{} {
-	// TODO: Implement attributes retrieval
-	return make(map[string]interface{})
+	return yt.typeMapEnumerateValues()
 }
 
-// Insert with string parameter (implements IYText interface)
-func (yt *YText) Insert(index int, text string, attributes ...map[string]interface{}) {
-	content := []interface{}{text}
-	yt.insertContent(index, content)
+// findPosition returns a cursor at index
+func (yt *YText) findPosition(transaction contracts.ITransaction, index int) *itemTextListPosition {
+	currentAttributes := make(map[string]interface{})
+
+	if marker := yt.FindMarker(index); marker != nil {
+		pos := &itemTextListPosition{left: marker.P.GetLeft(), right: marker.P, index: marker.Index, currentAttributes: currentAttributes}
+		pos.findNextPosition(transaction, index-marker.Index)
+		return pos
+	}
+
+	pos := &itemTextListPosition{right: yt.GetStart(), currentAttributes: currentAttributes}
+	pos.findNextPosition(transaction, index)
+	return pos
 }
 
-// insertContent is the internal method for inserting content
-func (yt *YText) insertContent(index int, content []interface{}) {
-	if yt.GetDoc() != nil {
-		yt.GetDoc().Transact(func(tr contracts.ITransaction) {
-			yt.insertAt(tr, index, content)
-		}, "insert")
+// insertAttributes inserts the format items for the attributes that differ at
+// the cursor and returns the attributes that have to be negated afterwards
+func (yt *YText) insertAttributes(transaction contracts.ITransaction, currPos *itemTextListPosition, attributes map[string]interface{}) map[string]interface{} {
+	doc := transaction.GetDoc()
+	ownClientID := int64(doc.GetClientID())
+	negatedAttributes := make(map[string]interface{})
+
+	// Insert format-start items.
+	for _, key := range sortedKeys(attributes) {
+		value := attributes[key]
+		currentVal := currPos.currentAttributes[key]
+		if !equalAttrs(currentVal, value) {
+			// Save negated attribute (set nil if currentVal is not set).
+			negatedAttributes[key] = currentVal
+			currPos.right = newTextItem(doc, ownClientID, currPos.left, currPos.right, yt, content.NewContentFormat(key, value))
+			currPos.right.Integrate(transaction, 0)
+			currPos.forward()
+		}
+	}
+
+	return negatedAttributes
+}
+
+// insertText inserts a string or an embed at the cursor
+func (yt *YText) insertText(transaction contracts.ITransaction, currPos *itemTextListPosition, text interface{}, attributes map[string]interface{}) {
+	if attributes == nil {
+		attributes = make(map[string]interface{})
+	}
+	for key := range currPos.currentAttributes {
+		if _, ok := attributes[key]; !ok {
+			attributes[key] = nil
+		}
+	}
+
+	doc := transaction.GetDoc()
+	ownClientID := int64(doc.GetClientID())
+	currPos.minimizeAttributeChanges(attributes)
+	negatedAttributes := yt.insertAttributes(transaction, currPos, attributes)
+
+	// Insert content.
+	var c contracts.IContentEx
+	if s, ok := text.(string); ok {
+		c = content.NewContentString(s)
 	} else {
-		// Store as preliminary content
-		if index == len(yt.prelimContent) {
-			yt.prelimContent = append(yt.prelimContent, content...)
-		} else {
-			// Insert at specific position
-			newContent := make([]interface{}, 0, len(yt.prelimContent)+len(content))
-			newContent = append(newContent, yt.prelimContent[:index]...)
-			newContent = append(newContent, content...)
-			newContent = append(newContent, yt.prelimContent[index:]...)
-			yt.prelimContent = newContent
+		c = content.NewContentEmbed(text)
+	}
+
+	if yt.searchMarkers.Count() > 0 {
+		yt.searchMarkers.UpdateMarkerChanges(currPos.index, c.GetLength())
+	}
+
+	currPos.right = newTextItem(doc, ownClientID, currPos.left, currPos.right, yt, c)
+	currPos.right.Integrate(transaction, 0)
+	currPos.forward()
+	currPos.insertNegatedAttributes(transaction, yt, negatedAttributes)
+}
+
+// formatText applies attributes to the next length characters after the cursor
+func (yt *YText) formatText(transaction contracts.ITransaction, curPos *itemTextListPosition, length int, attributes map[string]interface{}) {
+	doc := transaction.GetDoc()
+	ownClientID := int64(doc.GetClientID())
+	curPos.minimizeAttributeChanges(attributes)
+	negatedAttributes := yt.insertAttributes(transaction, curPos, attributes)
+
+	// Iterate until first non-format or nil is found.
+	// Delete all formats with attributes[format.Key] != nil
+	for length > 0 && curPos.right != nil {
+		if !curPos.right.GetDeleted() {
+			switch c := curPos.right.GetContent().(type) {
+			case *content.ContentFormat:
+				if attr, ok := attributes[c.GetKey()]; ok {
+					if equalAttrs(attr, c.GetValue()) {
+						delete(negatedAttributes, c.GetKey())
+					} else {
+						negatedAttributes[c.GetKey()] = c.GetValue()
+					}
+					curPos.right.Delete(transaction)
+				}
+			case *content.ContentEmbed, *content.ContentString:
+				if length < curPos.right.GetLength() {
+					transaction.GetDoc().GetStore().GetItemCleanStart(transaction, contracts.StructID{Client: curPos.right.GetID().Client, Clock: curPos.right.GetID().Clock + int64(length)})
+				}
+				length -= curPos.right.GetLength()
+			}
 		}
+		curPos.forward()
+	}
+
+	// Quill just assumes that the editor starts with a newline and that it always
+	// ends with a newline. We only insert that newline when a new newline is
+	// inserted - i.e. when length is bigger than type.length.
+	if length > 0 {
+		newLines := strings.Repeat("\n", length-1)
+		curPos.right = newTextItem(doc, ownClientID, curPos.left, curPos.right, yt, content.NewContentString(newLines))
+		curPos.right.Integrate(transaction, 0)
+		curPos.forward()
 	}
+
+	curPos.insertNegatedAttributes(transaction, yt, negatedAttributes)
 }
 
-// InsertEmbed inserts an embed object at the specified index
-func (yt *YText) InsertEmbed(index int, embed interface{}, attributes ...map[string]interface{}) {
-	content := []interface{}{embed}
-	yt.insertContent(index, content)
+// cleanupFormattingGap deletes the format items between start and end that are
+// overwritten or redundant, and returns how many were deleted
+func (yt *YText) cleanupFormattingGap(transaction contracts.ITransaction, start, end contracts.IStructItem, startAttributes, endAttributes map[string]interface{}) int {
+	for end != nil && !isTextContent(end) {
+		if cf, ok := end.GetContent().(*content.ContentFormat); ok && !end.GetDeleted() {
+			updateCurrentAttributes(endAttributes, cf)
+		}
+		end = end.GetRight()
+	}
+
+	cleanups := 0
+	for start != nil && start != end {
+		if !start.GetDeleted() {
+			if cf, ok := start.GetContent().(*content.ContentFormat); ok {
+				value := cf.GetValue()
+				if !equalAttrs(endAttributes[cf.GetKey()], value) || equalAttrs(startAttributes[cf.GetKey()], value) {
+					// Either this format is overwritten or it is not necessary because the attribute already existed.
+					start.Delete(transaction)
+					cleanups++
+				}
+			}
+		}
+		start = start.GetRight()
+	}
+
+	return cleanups
 }
 
-// RemoveAttribute removes an attribute
-func (yt *YText) RemoveAttribute(name string) {
-	// TODO: Implement attribute removal
+// cleanupContextlessFormattingGap deletes duplicate format items around item
+func (yt *YText) cleanupContextlessFormattingGap(transaction contracts.ITransaction, item contracts.IStructItem) {
+	// Iterate until item.Right is nil or content.
+	for item != nil && item.GetRight() != nil && (item.GetRight().GetDeleted() || !isTextContent(item.GetRight())) {
+		item = item.GetRight()
+	}
+
+	attrs := make(map[string]struct{})
+
+	// Iterate back until a content item is found.
+	for item != nil && (item.GetDeleted() || !isTextContent(item)) {
+		if cf, ok := item.GetContent().(*content.ContentFormat); ok && !item.GetDeleted() {
+			if _, exists := attrs[cf.GetKey()]; exists {
+				item.Delete(transaction)
+			} else {
+				attrs[cf.GetKey()] = struct{}{}
+			}
+		}
+		item = item.GetLeft()
+	}
 }
 
-// SetAttribute sets an attribute
-func (yt *YText) SetAttribute(name string, value interface{}) {
-	// TODO: Implement attribute setting
+// CleanupFormatting deletes all redundant format items and returns how many were deleted
+func (yt *YText) CleanupFormatting() int {
+	res := 0
+
+	transact(yt.GetDoc(), func(transaction contracts.ITransaction) {
+		start := yt.GetStart()
+		end := yt.GetStart()
+		startAttributes := make(map[string]interface{})
+		currentAttributes := make(map[string]interface{})
+
+		for ; end != nil; end = end.GetRight() {
+			if end.GetDeleted() {
+				continue
+			}
+
+			switch c := end.GetContent().(type) {
+			case *content.ContentFormat:
+				updateCurrentAttributes(currentAttributes, c)
+			case *content.ContentEmbed, *content.ContentString:
+				res += yt.cleanupFormattingGap(transaction, start, end, startAttributes, currentAttributes)
+				startAttributes = make(map[string]interface{}, len(currentAttributes))
+				for key, value := range currentAttributes {
+					startAttributes[key] = value
+				}
+				start = end
+			}
+		}
+	}, nil)
+
+	return res
 }
 
-// ToDelta converts the text to delta format
-func (yt *YText) ToDelta(snapshot contracts.ISnapshot, prevSnapshot contracts.ISnapshot, computeYChange func(contracts.YTextChangeType, contracts.StructID, contracts.YTextChangeAttributes) interface{}) []contracts.Delta {
-	// TODO: Implement delta conversion
-	return []contracts.Delta{}
+// deleteText deletes length characters after the cursor
+func (yt *YText) deleteText(transaction contracts.ITransaction, curPos *itemTextListPosition, length int) *itemTextListPosition {
+	startLength := length
+	startAttrs := make(map[string]interface{}, len(curPos.currentAttributes))
+	for key, value := range curPos.currentAttributes {
+		startAttrs[key] = value
+	}
+	start := curPos.right
+
+	for length > 0 && curPos.right != nil {
+		if !curPos.right.GetDeleted() && isTextContent(curPos.right) {
+			if length < curPos.right.GetLength() {
+				transaction.GetDoc().GetStore().GetItemCleanStart(transaction, contracts.StructID{Client: curPos.right.GetID().Client, Clock: curPos.right.GetID().Clock + int64(length)})
+			}
+			length -= curPos.right.GetLength()
+			curPos.right.Delete(transaction)
+		}
+		curPos.forward()
+	}
+
+	if start != nil {
+		endAttrs := make(map[string]interface{}, len(curPos.currentAttributes))
+		for key, value := range curPos.currentAttributes {
+			endAttrs[key] = value
+		}
+		yt.cleanupFormattingGap(transaction, start, curPos.right, startAttrs, endAttrs)
+	}
+
+	if yt.searchMarkers.Count() > 0 {
+		yt.searchMarkers.UpdateMarkerChanges(curPos.index, -startLength+length)
+	}
+
+	return curPos
+}
+
+// isTextContent returns whether item holds a string or an embed
+func isTextContent(item contracts.IStructItem) bool {
+	switch item.GetContent().(type) {
+	case *content.ContentString, *content.ContentEmbed:
+		return true
+	}
+	return false
+}
+
+// equalAttrs compares two attribute values
+func equalAttrs(attr1, attr2 interface{}) bool {
+	return reflect.DeepEqual(attr1, attr2)
+}
+
+// updateCurrentAttributes applies a format item to a set of attributes
+func updateCurrentAttributes(attributes map[string]interface{}, format *content.ContentFormat) {
+	if format.GetValue() == nil {
+		delete(attributes, format.GetKey())
+	} else {
+		attributes[format.GetKey()] = format.GetValue()
+	}
+}
+
+// SplitSnapshotAffectedStructs splits the structs at the boundaries of a snapshot
+// so that each struct is either fully visible or fully invisible in it
+func SplitSnapshotAffectedStructs(transaction contracts.ITransaction, snapshot contracts.ISnapshot) {
+	meta, ok := transaction.GetMeta()["splitSnapshotAffectedStructs"].(map[contracts.ISnapshot]struct{})
+	if !ok {
+		meta = make(map[contracts.ISnapshot]struct{})
+		transaction.GetMeta()["splitSnapshotAffectedStructs"] = meta
+	}
+
+	// Check if we already split for this snapshot.
+	if _, done := meta[snapshot]; done {
+		return
+	}
+
+	store := transaction.GetDoc().GetStore()
+	for client, clock := range snapshot.GetStateVector() {
+		if clock < store.GetState(client) {
+			store.GetItemCleanStart(transaction, contracts.StructID{Client: client, Clock: clock})
+		}
+	}
+
+	snapshot.GetDeleteSet().IterateDeletedStructs(transaction, func(contracts.IStructItem) bool { return true })
+	meta[snapshot] = struct{}{}
 }
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+	"ycs/content"
+	"ycs/contracts"
+)
+
+// errNotInDocument is returned when searching a text that is not part of a document
+var errNotInDocument = errors.New("the text must be part of a document")
+
+// Find returns the non-overlapping occurrences of pattern in the text, in order.
+// Embeds are searched as the object replacement character U+FFFC. An empty pattern
+// matches nothing. Returns an error if the text is not part of a document.
+func (yt *YText) Find(pattern string) ([]contracts.TextMatch, error) {
+	return yt.find(func(text string) [][]int {
+		return indexAll(text, pattern)
+	})
+}
+
+// FindRegexp returns the non-overlapping matches of re in the text, in order.
+// Empty matches are left out, as they do not cover any text. Returns an error if
+// the text is not part of a document.
+func (yt *YText) FindRegexp(re *regexp.Regexp) ([]contracts.TextMatch, error) {
+	return yt.find(func(text string) [][]int {
+		return re.FindAllStringIndex(text, -1)
+	})
+}
+
+// ReplaceAll replaces the non-overlapping occurrences of old by replacement in one
+// transaction with origin, and returns the number of replacements. Each replacement
+// takes the attributes of the first character it replaces; the surrounding text
+// keeps its own. Like in Find, embeds are searched as U+FFFC, and an empty old
+// matches nothing. Returns an error if the text is not part of a document.
+func (yt *YText) ReplaceAll(old, replacement string, origin interface{}) (int, error) {
+	return yt.replace(func(text string) [][]int {
+		return indexAll(text, old)
+	}, func(text string, match []int) string {
+		return replacement
+	}, origin)
+}
+
+// ReplaceRegexp replaces the matches of re like ReplaceAll. Like in FindRegexp,
+// empty matches are left out. Inside replacement, $ signs are expanded like in
+// regexp.Regexp.Expand, so $1 stands for the text of the first submatch.
+func (yt *YText) ReplaceRegexp(re *regexp.Regexp, replacement string, origin interface{}) (int, error) {
+	return yt.replace(func(text string) [][]int {
+		return re.FindAllStringSubmatchIndex(text, -1)
+	}, func(text string, match []int) string {
+		return string(re.ExpandString(nil, replacement, text, match))
+	}, origin)
+}
+
+// find returns the non-empty matches that match returns for the string of the text
+func (yt *YText) find(match func(text string) [][]int) ([]contracts.TextMatch, error) {
+	if yt.GetDoc() == nil {
+		return nil, errNotInDocument
+	}
+
+	matches := make([]contracts.TextMatch, 0)
+	text, indexes := yt.textIndexes()
+	for _, m := range match(text) {
+		if m[0] == m[1] {
+			continue
+		}
+		start, end := indexes[m[0]], indexes[m[1]]
+		matches = append(matches, contracts.TextMatch{
+			Start: NewRelativePositionFromTypeIndex(yt, start, 0),
+			End:   NewRelativePositionFromTypeIndex(yt, end, -1),
+		})
+	}
+	return matches, nil
+}
+
+// replace replaces the non-empty matches that match returns for the string of the
+// text by the text that replacement returns for them
+func (yt *YText) replace(match func(text string) [][]int, replacement func(text string, match []int) string, origin interface{}) (int, error) {
+	if yt.GetDoc() == nil {
+		return 0, errNotInDocument
+	}
+
+	count := 0
+	transact(yt.GetDoc(), func(tr contracts.ITransaction) {
+		text, indexes := yt.textIndexes()
+		var matches [][]int
+		for _, m := range match(text) {
+			if m[0] != m[1] {
+				matches = append(matches, m)
+			}
+		}
+		count = len(matches)
+
+		// Later matches are replaced first, so the indexes of earlier ones stay valid
+		for i := len(matches) - 1; i >= 0; i-- {
+			m := matches[i]
+			start, end := indexes[m[0]], indexes[m[1]]
+			with := replacement(text, m)
+
+			attributes := yt.attributesAt(tr, start)
+			yt.Delete(start, end-start)
+			yt.Insert(start, with, attributes)
+		}
+	}, origin)
+	return count, nil
+}
+
+// objectReplacement stands for an embed in the text that is searched
+const objectReplacement = '\uFFFC'
+
+// textIndexes returns the text as it is searched and the index in UTF-16 code units
+// of each of its bytes, followed by the length of the text
+func (yt *YText) textIndexes() (string, []int) {
+	var sb strings.Builder
+	var indexes []int
+	index := 0
+	for n := yt.GetStart(); n != nil; n = n.GetRight() {
+		if n.GetDeleted() || !n.GetCountable() {
+			continue
+		}
+		text := string(objectReplacement)
+		if cs, ok := n.GetContent().(*content.ContentString); ok {
+			text = cs.GetString()
+		}
+		for _, r := range text {
+			sb.WriteRune(r)
+			for size := utf8.RuneLen(r); size > 0; size-- {
+				indexes = append(indexes, index)
+			}
+			index += utf16.RuneLen(r)
+		}
+	}
+	return sb.String(), append(indexes, index)
+}
+
+// attributesAt returns the attributes of the character at index
+func (yt *YText) attributesAt(transaction contracts.ITransaction, index int) map[string]interface{} {
+	pos := yt.findPosition(transaction, index)
+	for pos.right != nil && (pos.right.GetDeleted() || !pos.right.GetCountable()) {
+		pos.forward()
+	}
+	return pos.currentAttributes
+}
+
+// indexAll returns the byte ranges of the non-overlapping occurrences of pattern in text
+func indexAll(text, pattern string) [][]int {
+	if pattern == "" {
+		return nil
+	}
+
+	var matches [][]int
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], pattern)
+		if i < 0 {
+			return matches
+		}
+		start := offset + i
+		offset = start + len(pattern)
+		matches = append(matches, []int{start, offset})
+	}
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"ycs/contracts"
+)
+
+// matchRanges resolves the positions of matches to their indexes in the text
+func matchRanges(t *testing.T, doc *YDoc, matches []contracts.TextMatch) [][2]int {
+	t.Helper()
+	ranges := make([][2]int, 0, len(matches))
+	for _, match := range matches {
+		start := TryCreateFromRelativePosition(match.Start, doc)
+		end := TryCreateFromRelativePosition(match.End, doc)
+		if start == nil || end == nil {
+			t.Fatalf("cannot resolve match %+v", match)
+		}
+		ranges = append(ranges, [2]int{start.Index, end.Index})
+	}
+	return ranges
+}
+
+func TestYTextFind(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	text := doc.GetText("t").(*YText)
+	text.Insert(0, "😀ab")
+	text.InsertEmbed(4, map[string]interface{}{"image": "a.png"})
+	text.Insert(5, "ab", map[string]interface{}{"bold": true})
+
+	matches, err := text.Find("ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Indexes count UTF-16 code units and the embed
+	if got, want := matchRanges(t, doc, matches), [][2]int{{2, 4}, {5, 7}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// Embeds are searched as U+FFFC
+	if matches, err := text.Find("b￼a"); err != nil || len(matches) != 1 {
+		t.Fatalf("got %d matches, %v", len(matches), err)
+	}
+
+	// The positions stay on the matched text; text inserted next to it is not part of it
+	text.Insert(0, "xx")
+	text.Insert(4, "y")
+	if got, want := matchRanges(t, doc, matches), [][2]int{{5, 7}, {8, 10}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if matches, err := text.Find(""); err != nil || len(matches) != 0 {
+		t.Fatalf("got %d matches for an empty pattern, %v", len(matches), err)
+	}
+}
+
+func TestYTextFindRegexp(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	text := doc.GetText("t").(*YText)
+	text.Insert(0, "baab 😀 aaa")
+
+	// Empty matches are left out
+	matches, err := text.FindRegexp(regexp.MustCompile(`a*`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := matchRanges(t, doc, matches), [][2]int{{1, 3}, {8, 11}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestYTextReplaceAll(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	text := doc.GetText("t").(*YText)
+	text.Insert(0, "one 😀 one", map[string]interface{}{"bold": true})
+	text.Insert(text.GetLength(), " one", map[string]interface{}{"bold": nil})
+
+	var transactions []interface{}
+	doc.OnAfterTransaction(func(tr contracts.ITransaction) {
+		transactions = append(transactions, tr.GetOrigin())
+	})
+	count, err := text.ReplaceAll("one", "two", "replace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("got %d replacements, want 3", count)
+	}
+	if !reflect.DeepEqual(transactions, []interface{}{"replace"}) {
+		t.Fatalf("got transactions with origins %v", transactions)
+	}
+
+	// Each replacement takes the attributes of the text it replaces
+	if got, want := deltaString(text.ToDelta(nil, nil, nil)), "two 😀 two[bold=true]| two[]"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	if count, err := text.ReplaceAll("", "x", nil); err != nil || count != 0 {
+		t.Fatalf("got %d replacements for an empty string, %v", count, err)
+	}
+}
+
+func TestYTextReplaceRegexp(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	text := doc.GetText("t").(*YText)
+	text.Insert(0, "a1 b22 c")
+
+	count, err := text.ReplaceRegexp(regexp.MustCompile(`([a-z])(\d+)`), "$2$1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := text.ToString(); count != 2 || got != "1a 22b c" {
+		t.Fatalf("got %q after %d replacements", got, count)
+	}
+
+	// Empty matches are left out, like FindRegexp does
+	count, err = text.ReplaceRegexp(regexp.MustCompile(`\d*`), "#", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := text.ToString(); count != 2 || got != "#a #b c" {
+		t.Fatalf("got %q after %d replacements", got, count)
+	}
+}
+
+func TestYTextFindRequiresDocument(t *testing.T) {
+	text := NewYText("abc")
+	if _, err := text.Find("a"); err == nil {
+		t.Fatal("no error for Find on a text without a document")
+	}
+	if _, err := text.FindRegexp(regexp.MustCompile(`a`)); err == nil {
+		t.Fatal("no error for FindRegexp on a text without a document")
+	}
+	if _, err := text.ReplaceAll("a", "b", nil); err == nil {
+		t.Fatal("no error for ReplaceAll on a text without a document")
+	}
+	if _, err := text.ReplaceRegexp(regexp.MustCompile(`a`), "b", nil); err == nil {
+		t.Fatal("no error for ReplaceRegexp on a text without a document")
+	}
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"ycs/contracts"
+)
+
+// deltaString renders a delta compactly, attributes in sorted order
+func deltaString(delta []contracts.Delta) string {
+	var parts []string
+	for _, op := range delta {
+		var attrs []string
+		for _, key := range sortedKeys(op.Attributes) {
+			attrs = append(attrs, fmt.Sprintf("%s=%v", key, op.Attributes[key]))
+		}
+		parts = append(parts, fmt.Sprintf("%v%v", op.Insert, attrs))
+	}
+	return strings.Join(parts, "|")
+}
+
+func TestYTextInsertDeleteUTF16(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	text := doc.GetText("t").(*YText)
+	text.Insert(0, "a😀c")
+	if got := text.GetLength(); got != 4 {
+		t.Fatalf("length %d, want 4", got)
+	}
+
+	// Index 3 is after the surrogate pair
+	text.Insert(3, "b")
+	text.Delete(0, 1)
+	if got := text.ToString(); got != "😀bc" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestYTextFormatting(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	text := doc.GetText("t").(*YText)
+	text.Insert(0, "hello world")
+	text.Format(0, 5, map[string]interface{}{"bold": true})
+	text.Insert(11, "!", map[string]interface{}{"italic": true})
+	text.InsertEmbed(5, map[string]interface{}{"image": "x"})
+
+	want := "hello[bold=true]|map[image:x][]| world[]|![italic=true]"
+	if got := deltaString(text.ToDelta(nil, nil, nil)); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	// Removing the attribute again leaves no formatting behind
+	text.Format(0, 5, map[string]interface{}{"bold": nil})
+	want = "hello[]|map[image:x][]| world[]|![italic=true]"
+	if got := deltaString(text.ToDelta(nil, nil, nil)); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestYTextApplyDelta(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	text := doc.GetText("t").(*YText)
+	text.Insert(0, "abc")
+
+	retain, del := 1, 1
+	text.ApplyDelta([]contracts.Delta{
+		{Retain: &retain},
+		{Delete: &del},
+		{Insert: "X", Attributes: map[string]interface{}{"bold": true}},
+	})
+
+	if got := deltaString(text.ToDelta(nil, nil, nil)); got != "a[]|X[bold=true]|c[]" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestYTextSyncsToDefinedText(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	text := doc.GetText("t").(*YText)
+	text.Insert(0, "héllo 😀")
+	text.Format(1, 4, map[string]interface{}{"bold": true})
+
+	remote := NewYDoc(contracts.YDocOptions{})
+	remoteText := remote.GetText("t")
+	remote.ApplyUpdateV2(encodeUpdate(t, doc), nil)
+
+	if got, want := deltaString(remoteText.ToDelta(nil, nil, nil)), deltaString(text.ToDelta(nil, nil, nil)); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestYTextSetFromStringUTF16(t *testing.T) {
+	for _, tc := range []struct{ from, to string }{
+		{"", "a😀b"},
+		{"a😀b", ""},
+		{"a😀b", "a😁b"},
+		{"😀😀", "😀x😀"},
+		{"x😀y😀z", "😀y😀"},
+		{"𝄞a𝄞", "a𝄞a"},
+		{"héllo wörld", "hello wörld 😀"},
+	} {
+		doc := NewYDoc(contracts.YDocOptions{})
+		text := doc.GetText("t").(*YText)
+		text.Insert(0, tc.from)
+
+		remote := NewYDoc(contracts.YDocOptions{})
+		if err := remote.ApplyUpdateV2(encodeUpdate(t, doc, nil), nil); err != nil {
+			t.Fatal(err)
+		}
+		text.SetFromString(tc.to)
+		if got := text.ToString(); got != tc.to {
+			t.Errorf("%q to %q: got %q", tc.from, tc.to, got)
+			continue
+		}
+		if got, want := text.GetLength(), len(utf16.Encode([]rune(tc.to))); got != want {
+			t.Errorf("%q to %q: got length %d, want %d", tc.from, tc.to, got, want)
+		}
+
+		// The changes are made at UTF-16 offsets, so peers apply them alike
+		if err := remote.ApplyUpdateV2(encodeUpdate(t, doc, remote.EncodeStateVectorV2()), nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := remote.GetText("t").ToString(); got != tc.to {
+			t.Errorf("%q to %q: remote got %q", tc.from, tc.to, got)
+		}
+	}
+}
+
+func TestYTextSetFromStringKeepsUnchangedText(t *testing.T) {
+	doc := NewYDoc(contracts.YDocOptions{})
+	text := doc.GetText("t").(*YText)
+	text.Insert(0, "😀 bold", map[string]interface{}{"bold": true})
+	text.InsertEmbed(text.GetLength(), map[string]interface{}{"image": "a.png"})
+	text.Insert(text.GetLength(), " plain")
+
+	text.SetFromString("😀 bolder plain!")
+	if got, want := deltaString(text.ToDelta(nil, nil, nil)), "😀 bolder[bold=true]|map[image:a.png][]| plain![]"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	// Unchanged text is not replaced
+	var updates int
+	doc.OnUpdateV2(func(update []byte, origin interface{}, transaction contracts.ITransaction) {
+		updates++
+	})
+	text.SetFromString(text.ToString())
+	if updates != 0 {
+		t.Fatalf("setting the same text produced %d updates", updates)
+	}
+}
@@ -8,3 +8,5 @@ require (
 	github.com/gorilla/mux v1.8.1
 	github.com/gorilla/websocket v1.5.0
 )
+
+require gopkg.in/yaml.v3 v3.0.1
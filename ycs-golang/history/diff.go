@@ -0,0 +1,115 @@
+package history
+
+import (
+	"reflect"
+	"sort"
+
+	"ycs/contracts"
+	"ycs/core"
+)
+
+// Change is a value that differs between two versions of a document
+type Change struct {
+	// Path names the value by the root type and the map keys leading to it, joined by "/"
+	Path string `json:"path"`
+	// Before is nil if the value was added
+	Before interface{} `json:"before"`
+	// After is nil if the value was removed
+	After interface{} `json:"after"`
+}
+
+// Diff lists the changes between two checkpoints
+type Diff struct {
+	From Checkpoint `json:"from"`
+	// To is nil when comparing with the current document
+	To      *Checkpoint `json:"to"`
+	Changes []Change    `json:"changes"`
+}
+
+// Diff compares two checkpoints, or a checkpoint with the current document if to is
+// empty. Maps are compared key by key; texts and arrays that differ are reported as
+// a whole.
+func (r *Recorder) Diff(from string, to string) (*Diff, error) {
+	fromCheckpoint, fromSnapshot, err := r.load(from)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &Diff{From: fromCheckpoint, Changes: make([]Change, 0)}
+	toSnapshot := r.snapshot()
+	if to != "" {
+		toCheckpoint, snapshot, err := r.load(to)
+		if err != nil {
+			return nil, err
+		}
+		diff.To = &toCheckpoint
+		toSnapshot = snapshot
+	}
+
+	before, after := r.render(fromSnapshot), r.render(toSnapshot)
+	names := make(map[string]struct{})
+	for name := range before {
+		names[name] = struct{}{}
+	}
+	for name := range after {
+		names[name] = struct{}{}
+	}
+	for _, name := range sortedNames(names) {
+		diffValues(name, before[name], after[name], &diff.Changes)
+	}
+	return diff, nil
+}
+
+// render returns the root types of the document at the snapshot as JSON values. The
+// roots are read as the types the room document defines them, so that texts and
+// arrays with moves render like they do for clients.
+func (r *Recorder) render(snapshot *core.Snapshot) map[string]interface{} {
+	r.doc.Lock()
+	defer r.doc.Unlock()
+
+	restored := snapshot.RestoreDocument(r.doc, nil).(*core.YDoc)
+	for name, sharedType := range r.doc.GetShare() {
+		switch sharedType.(type) {
+		case contracts.IYText:
+			restored.GetText(name)
+		case contracts.IYArray:
+			restored.GetArray(name)
+		case contracts.IYMap:
+			restored.GetMap(name)
+		}
+	}
+	return restored.ToJSON()
+}
+
+// diffValues appends the changes between two JSON values to changes
+func diffValues(path string, before, after interface{}, changes *[]Change) {
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	if !beforeIsMap || !afterIsMap {
+		if !reflect.DeepEqual(before, after) {
+			*changes = append(*changes, Change{Path: path, Before: before, After: after})
+		}
+		return
+	}
+
+	keys := make(map[string]struct{})
+	for key := range beforeMap {
+		keys[key] = struct{}{}
+	}
+	for key := range afterMap {
+		keys[key] = struct{}{}
+	}
+	for _, key := range sortedNames(keys) {
+		diffValues(path+"/"+key, beforeMap[key], afterMap[key], changes)
+	}
+}
+
+// sortedNames returns the names of a set in order
+func sortedNames(set map[string]struct{}) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
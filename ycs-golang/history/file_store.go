@@ -0,0 +1,144 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const fileExtension = ".json"
+
+// storedCheckpoint is the content of a checkpoint file
+type storedCheckpoint struct {
+	Checkpoint
+	Snapshot []byte `json:"snapshot"`
+}
+
+// FileStore is a Store keeping one directory per room with one file per checkpoint
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a new FileStore, creating the directory if necessary
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("history directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating history directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// roomDir returns the directory of the room. Names are escaped so they cannot leave
+// the directory; the names that stay the same path element when escaped are rejected.
+func (fs *FileStore) roomDir(room string) (string, error) {
+	name := url.PathEscape(room)
+	if name == "" || name == "." || name == ".." {
+		return "", ErrInvalidRoom
+	}
+	return filepath.Join(fs.dir, name), nil
+}
+
+// Save stores a new checkpoint of the room.
+// The file is written atomically so that a crash never leaves a partial checkpoint.
+func (fs *FileStore) Save(room string, checkpoint Checkpoint, snapshot []byte) error {
+	data, err := json.Marshal(storedCheckpoint{Checkpoint: checkpoint, Snapshot: snapshot})
+	if err != nil {
+		return err
+	}
+
+	dir, err := fs.roomDir(room)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, url.PathEscape(checkpoint.ID)+fileExtension))
+}
+
+// List returns the checkpoints of the room, oldest first
+func (fs *FileStore) List(room string) ([]Checkpoint, error) {
+	dir, err := fs.roomDir(room)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoints := make([]Checkpoint, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, fileExtension) {
+			continue
+		}
+		stored, err := fs.read(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, stored.Checkpoint)
+	}
+
+	sort.SliceStable(checkpoints, func(i, j int) bool {
+		return checkpoints[i].Created.Before(checkpoints[j].Created)
+	})
+	return checkpoints, nil
+}
+
+// Load returns the checkpoint of the room and its snapshot, or ErrNotFound
+func (fs *FileStore) Load(room string, id string) (Checkpoint, []byte, error) {
+	dir, err := fs.roomDir(room)
+	if err != nil {
+		return Checkpoint{}, nil, err
+	}
+	stored, err := fs.read(filepath.Join(dir, url.PathEscape(id)+fileExtension))
+	if errors.Is(err, os.ErrNotExist) {
+		return Checkpoint{}, nil, ErrNotFound
+	}
+	if err != nil {
+		return Checkpoint{}, nil, err
+	}
+	return stored.Checkpoint, stored.Snapshot, nil
+}
+
+// read decodes a checkpoint file
+func (fs *FileStore) read(path string) (*storedCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var stored storedCheckpoint
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("reading checkpoint %s: %w", filepath.Base(path), err)
+	}
+	return &stored, nil
+}
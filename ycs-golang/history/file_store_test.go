@@ -0,0 +1,88 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newFileStore creates a FileStore in a directory of its own within a temporary directory
+func newFileStore(t *testing.T) (*FileStore, string) {
+	t.Helper()
+	parent := t.TempDir()
+	store, err := NewFileStore(filepath.Join(parent, "history"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, parent
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	store, _ := newFileStore(t)
+
+	first := Checkpoint{ID: "a", Name: "first", Created: time.Unix(1, 0).UTC()}
+	second := Checkpoint{ID: "b/c", Author: "ann", Clients: []int64{3}, Created: time.Unix(2, 0).UTC(), Automatic: true}
+	if err := store.Save("room/1", second, []byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("room/1", first, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoints, err := store.List("room/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Checkpoint{first, second}; !reflect.DeepEqual(checkpoints, want) {
+		t.Fatalf("got %+v, want %+v", checkpoints, want)
+	}
+
+	checkpoint, snapshot, err := store.Load("room/1", "b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(checkpoint, second) || !reflect.DeepEqual(snapshot, []byte{2}) {
+		t.Fatalf("got %+v, %v", checkpoint, snapshot)
+	}
+
+	if _, _, err := store.Load("room/1", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+	if checkpoints, err := store.List("unknown"); err != nil || len(checkpoints) != 0 {
+		t.Fatalf("got %+v, %v", checkpoints, err)
+	}
+}
+
+func TestFileStoreRejectsRoomsOutsideDirectory(t *testing.T) {
+	store, parent := newFileStore(t)
+	checkpoint := Checkpoint{ID: "a", Created: time.Now().UTC()}
+
+	for _, room := range []string{"", ".", ".."} {
+		if err := store.Save(room, checkpoint, nil); !errors.Is(err, ErrInvalidRoom) {
+			t.Errorf("Save(%q): got %v, want ErrInvalidRoom", room, err)
+		}
+		if _, err := store.List(room); !errors.Is(err, ErrInvalidRoom) {
+			t.Errorf("List(%q): got %v, want ErrInvalidRoom", room, err)
+		}
+		if _, _, err := store.Load(room, "a"); !errors.Is(err, ErrInvalidRoom) {
+			t.Errorf("Load(%q): got %v, want ErrInvalidRoom", room, err)
+		}
+	}
+
+	// Separators are escaped, so rooms stay within the directory
+	for _, room := range []string{"../escape", "..\\escape", "a/../../escape"} {
+		if err := store.Save(room, checkpoint, nil); err != nil {
+			t.Fatalf("Save(%q): %v", room, err)
+		}
+	}
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "history" {
+		t.Fatalf("files written outside the history directory: %v", entries)
+	}
+}
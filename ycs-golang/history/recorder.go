@@ -0,0 +1,195 @@
+package history
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"ycs/contracts"
+	"ycs/core"
+)
+
+// Recorder creates the checkpoints of the document of one room. Named checkpoints
+// are created on request; with an interval, an automatic checkpoint is created at
+// the end of every interval in which the document changed.
+//
+// The document must keep deleted content (it must not be garbage collected), as the
+// versions of a checkpoint are computed from the current document.
+type Recorder struct {
+	room     string
+	doc      *core.YDoc
+	store    Store
+	interval time.Duration
+
+	// changed is set when the document changes and cleared by every checkpoint
+	changed bool
+	// stateVector is the state of the document at the latest checkpoint
+	stateVector map[int64]int64
+	mutex       sync.Mutex
+
+	unsubscribe func()
+	stop        chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewRecorder creates a Recorder for the room's document. With a zero interval no
+// automatic checkpoints are created.
+func NewRecorder(room string, doc *core.YDoc, store Store, interval time.Duration) *Recorder {
+	recorder := &Recorder{
+		room:        room,
+		doc:         doc,
+		store:       store,
+		interval:    interval,
+		stateVector: make(map[int64]int64),
+		stop:        make(chan struct{}),
+	}
+
+	// Clients are reported from the latest stored checkpoint on
+	if checkpoints, err := store.List(room); err != nil {
+		log.Printf("Error listing checkpoints of room %s: %v", room, err)
+	} else if len(checkpoints) > 0 {
+		if _, snapshot, err := recorder.load(checkpoints[len(checkpoints)-1].ID); err == nil {
+			recorder.stateVector = snapshot.GetStateVector()
+		}
+	}
+
+	recorder.unsubscribe = doc.OnUpdateV2(func(update []byte, origin interface{}, transaction contracts.ITransaction) {
+		recorder.mutex.Lock()
+		recorder.changed = true
+		recorder.mutex.Unlock()
+	})
+
+	if interval > 0 {
+		go recorder.checkpointLoop()
+	}
+	return recorder
+}
+
+// Close stops creating automatic checkpoints
+func (r *Recorder) Close() {
+	r.closeOnce.Do(func() {
+		close(r.stop)
+		r.unsubscribe()
+	})
+}
+
+func (r *Recorder) checkpointLoop() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.mutex.Lock()
+			changed := r.changed
+			r.mutex.Unlock()
+			if !changed {
+				continue
+			}
+			if _, err := r.createCheckpoint("", "", true); err != nil {
+				log.Printf("Error creating checkpoint of room %s: %v", r.room, err)
+			}
+		}
+	}
+}
+
+// Checkpoint creates a named checkpoint of the current document
+func (r *Recorder) Checkpoint(name string, author string) (Checkpoint, error) {
+	return r.createCheckpoint(name, author, false)
+}
+
+func (r *Recorder) createCheckpoint(name string, author string, automatic bool) (Checkpoint, error) {
+	snapshot := r.snapshot()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	checkpoint := Checkpoint{
+		ID:        newCheckpointID(),
+		Name:      name,
+		Author:    author,
+		Created:   time.Now().UTC(),
+		Automatic: automatic,
+	}
+	for client, clock := range snapshot.GetStateVector() {
+		if clock > r.stateVector[client] {
+			checkpoint.Clients = append(checkpoint.Clients, client)
+		}
+	}
+	sort.Slice(checkpoint.Clients, func(i, j int) bool {
+		return checkpoint.Clients[i] < checkpoint.Clients[j]
+	})
+
+	if err := r.store.Save(r.room, checkpoint, snapshot.EncodeSnapshotV2()); err != nil {
+		return Checkpoint{}, err
+	}
+	r.stateVector = snapshot.GetStateVector()
+	r.changed = false
+	return checkpoint, nil
+}
+
+// List returns the checkpoints of the room, oldest first
+func (r *Recorder) List() ([]Checkpoint, error) {
+	return r.store.List(r.room)
+}
+
+// Restore changes the document back to a checkpoint. The change is a new change
+// that syncs to every client like an edit, so the versions in between are kept.
+// A checkpoint of the document before the change is created first and returned.
+func (r *Recorder) Restore(id string, author string) (Checkpoint, error) {
+	checkpoint, snapshot, err := r.load(id)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+
+	name := checkpoint.Name
+	if name == "" {
+		name = checkpoint.Created.Format(time.RFC3339)
+	}
+	before, err := r.Checkpoint(fmt.Sprintf("Before restoring %s", name), author)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+
+	r.doc.Lock()
+	defer r.doc.Unlock()
+	snapshot.RevertDocument(r.doc, r)
+	return before, nil
+}
+
+// snapshot returns a snapshot of the current document
+func (r *Recorder) snapshot() (snapshot *core.Snapshot) {
+	r.doc.View(func() {
+		snapshot = r.doc.CreateSnapshot().(*core.Snapshot)
+	})
+	return snapshot
+}
+
+// load returns a checkpoint and its decoded snapshot
+func (r *Recorder) load(id string) (Checkpoint, *core.Snapshot, error) {
+	checkpoint, data, err := r.store.Load(r.room, id)
+	if err != nil {
+		return Checkpoint{}, nil, err
+	}
+	snapshot, err := core.DecodeSnapshot(bytes.NewReader(data))
+	if err != nil {
+		return Checkpoint{}, nil, fmt.Errorf("decoding checkpoint %s: %w", id, err)
+	}
+	return checkpoint, snapshot, nil
+}
+
+// newCheckpointID generates a random checkpoint ID
+func newCheckpointID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
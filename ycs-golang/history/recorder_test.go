@@ -0,0 +1,201 @@
+package history
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"ycs/contracts"
+	"ycs/core"
+)
+
+// edit changes the document in a transaction of its own
+func edit(doc *core.YDoc, change func()) {
+	doc.Transact(func(tr contracts.ITransaction) {
+		change()
+	}, nil)
+}
+
+// newRecordedDoc creates a document that keeps its history and a Recorder of it
+func newRecordedDoc(t *testing.T, store Store, interval time.Duration) (*core.YDoc, *Recorder) {
+	t.Helper()
+	doc := core.NewYDoc(contracts.YDocOptions{Gc: false})
+	recorder := NewRecorder("room", doc, store, interval)
+	t.Cleanup(recorder.Close)
+	return doc, recorder
+}
+
+func TestRecorderCheckpoints(t *testing.T) {
+	store, _ := newFileStore(t)
+	doc, recorder := newRecordedDoc(t, store, 0)
+
+	edit(doc, func() { doc.GetText("t").Insert(0, "hello") })
+	first, err := recorder.Checkpoint("first", "ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Name != "first" || first.Author != "ann" || first.Automatic {
+		t.Fatalf("got %+v", first)
+	}
+	if want := []int64{int64(doc.GetClientID())}; !reflect.DeepEqual(first.Clients, want) {
+		t.Fatalf("got clients %v, want %v", first.Clients, want)
+	}
+
+	// Only the clients that changed the document since the previous checkpoint are listed
+	second, err := recorder.Checkpoint("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second.Clients) != 0 {
+		t.Fatalf("got clients %v for an unchanged document", second.Clients)
+	}
+
+	checkpoints, err := recorder.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) != 2 || checkpoints[0].ID != first.ID || checkpoints[1].ID != second.ID {
+		t.Fatalf("got %+v", checkpoints)
+	}
+
+	// A new recorder reports the clients from the latest stored checkpoint on
+	_, resumed := newRecordedDoc(t, store, 0)
+	third, err := resumed.Checkpoint("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(third.Clients) != 0 {
+		t.Fatalf("got clients %v after resuming", third.Clients)
+	}
+}
+
+func TestRecorderDiff(t *testing.T) {
+	store, _ := newFileStore(t)
+	doc, recorder := newRecordedDoc(t, store, 0)
+
+	edit(doc, func() {
+		doc.GetMap("m").Set("a", int64(1))
+		doc.GetMap("m").Set("b", "x")
+	})
+	before, err := recorder.Checkpoint("before", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(doc, func() {
+		doc.GetMap("m").Set("a", int64(2))
+		doc.GetMap("m").Delete("b")
+		doc.GetText("t").Insert(0, "new")
+	})
+	after, err := recorder.Checkpoint("after", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := recorder.Diff(before.ID, after.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Path: "m/a", Before: float64(1), After: float64(2)},
+		{Path: "m/b", Before: "x"},
+		{Path: "t", Before: "", After: "new"},
+	}
+	if diff.From.ID != before.ID || diff.To == nil || diff.To.ID != after.ID {
+		t.Fatalf("got from %+v, to %+v", diff.From, diff.To)
+	}
+	if !reflect.DeepEqual(normalize(t, diff.Changes), normalize(t, want)) {
+		t.Fatalf("got %+v, want %+v", diff.Changes, want)
+	}
+
+	// Without to, the checkpoint is compared with the current document
+	edit(doc, func() { doc.GetText("t").Insert(3, "er") })
+	diff, err = recorder.Diff(after.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.To != nil || len(diff.Changes) != 1 || diff.Changes[0].After != "newer" {
+		t.Fatalf("got %+v", diff)
+	}
+
+	if _, err := recorder.Diff("missing", ""); err != ErrNotFound {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+}
+
+func TestRecorderRestore(t *testing.T) {
+	store, _ := newFileStore(t)
+	doc, recorder := newRecordedDoc(t, store, 0)
+
+	edit(doc, func() { doc.GetText("t").Insert(0, "old") })
+	old, err := recorder.Checkpoint("old", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(doc, func() {
+		doc.GetText("t").Delete(0, 3)
+		doc.GetText("t").Insert(0, "new")
+	})
+
+	before, err := recorder.Restore(old.ID, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Name != "Before restoring old" || before.Author != "bob" {
+		t.Fatalf("got %+v", before)
+	}
+	var text string
+	doc.View(func() { text = doc.GetText("t").ToString() })
+	if text != "old" {
+		t.Fatalf("got %q after restoring", text)
+	}
+
+	// The version before the restore is kept
+	diff, err := recorder.Diff(before.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Changes) != 1 || diff.Changes[0].Before != "new" || diff.Changes[0].After != "old" {
+		t.Fatalf("got %+v", diff.Changes)
+	}
+}
+
+func TestRecorderAutomaticCheckpoints(t *testing.T) {
+	store, _ := newFileStore(t)
+	doc, recorder := newRecordedDoc(t, store, 10*time.Millisecond)
+
+	edit(doc, func() { doc.GetText("t").Insert(0, "a") })
+	deadline := time.Now().Add(5 * time.Second)
+	var checkpoints []Checkpoint
+	for len(checkpoints) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no automatic checkpoint created")
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		var err error
+		if checkpoints, err = recorder.List(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !checkpoints[0].Automatic || checkpoints[0].Name != "" {
+		t.Fatalf("got %+v", checkpoints[0])
+	}
+
+	// Unchanged documents get no further checkpoints
+	time.Sleep(50 * time.Millisecond)
+	if checkpoints, err := recorder.List(); err != nil || len(checkpoints) != 1 {
+		t.Fatalf("got %d checkpoints, %v", len(checkpoints), err)
+	}
+}
+
+// normalize encodes changes like they are sent to clients, so that numbers compare
+// alike whatever their type
+func normalize(t *testing.T, changes []Change) string {
+	t.Helper()
+	data, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,35 @@
+package history
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when a checkpoint does not exist
+var ErrNotFound = errors.New("checkpoint not found")
+
+// ErrInvalidRoom is returned when a room name cannot be stored
+var ErrInvalidRoom = errors.New("invalid room name")
+
+// Checkpoint describes a version of a room document
+type Checkpoint struct {
+	ID string `json:"id"`
+	// Name is given by the author; automatic checkpoints are unnamed
+	Name   string `json:"name,omitempty"`
+	Author string `json:"author,omitempty"`
+	// Clients lists the clients that changed the document since the previous checkpoint
+	Clients   []int64   `json:"clients,omitempty"`
+	Created   time.Time `json:"created"`
+	Automatic bool      `json:"automatic"`
+}
+
+// Store saves and loads the checkpoints of rooms together with their snapshots,
+// encoded as V2 snapshots
+type Store interface {
+	// Save stores a new checkpoint of the room
+	Save(room string, checkpoint Checkpoint, snapshot []byte) error
+	// List returns the checkpoints of the room, oldest first
+	List(room string) ([]Checkpoint, error)
+	// Load returns the checkpoint of the room and its snapshot, or ErrNotFound
+	Load(room string, id string) (Checkpoint, []byte, error)
+}
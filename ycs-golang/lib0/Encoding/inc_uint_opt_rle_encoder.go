@@ -50,6 +50,22 @@ func (e *IncUintOptRleEncoder) Flush() error {
 	return e.AbstractStreamEncoder.Flush()
 }
 
+// ToArray flushes the pending run and returns the encoded bytes.
+func (e *IncUintOptRleEncoder) ToArray() ([]byte, error) {
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return e.buffer.Bytes(), nil
+}
+
+// GetBuffer flushes the pending run and returns the buffer and its length.
+func (e *IncUintOptRleEncoder) GetBuffer() ([]byte, int, error) {
+	if err := e.Flush(); err != nil {
+		return nil, 0, err
+	}
+	return e.buffer.Bytes(), e.buffer.Len(), nil
+}
+
 // writeEncodedValue writes the current state and count to the stream.
 func (e *IncUintOptRleEncoder) writeEncodedValue() error {
 	if e.count == 0 {
@@ -67,12 +83,9 @@ func (e *IncUintOptRleEncoder) writeEncodedValue() error {
 		}
 	} else {
 		// Write negative value to indicate there's a length coming
-		negValue := -int64(e.state)
-		if e.state == 0 {
-			// Special case for zero to ensure it's treated as negative
-			negValue = 0
-		}
-		if err := lib0.WriteVarInt(writer, negValue, nil); err != nil {
+		// Treat zero as negative in case we pass the '-0'
+		treatZeroAsNegative := e.state == 0
+		if err := lib0.WriteVarInt(writer, -int64(e.state), &treatZeroAsNegative); err != nil {
 			return err
 		}
 
@@ -57,6 +57,22 @@ func (e *IntDiffOptRleEncoder) Flush() error {
 	return e.AbstractStreamEncoder.Flush()
 }
 
+// ToArray flushes the pending run and returns the encoded bytes.
+func (e *IntDiffOptRleEncoder) ToArray() ([]byte, error) {
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return e.buffer.Bytes(), nil
+}
+
+// GetBuffer flushes the pending run and returns the buffer and its length.
+func (e *IntDiffOptRleEncoder) GetBuffer() ([]byte, int, error) {
+	if err := e.Flush(); err != nil {
+		return nil, 0, err
+	}
+	return e.buffer.Bytes(), e.buffer.Len(), nil
+}
+
 // writeEncodedValue writes the current diff and count to the stream.
 func (e *IntDiffOptRleEncoder) writeEncodedValue() error {
 	if e.count == 0 {
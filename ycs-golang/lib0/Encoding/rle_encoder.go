@@ -54,18 +54,3 @@ func (e *RleEncoder) Write(value byte) error {
 	}
 	return nil
 }
-
-// Flush writes any remaining values to the stream.
-func (e *RleEncoder) Flush() error {
-	if e.count > 0 && e.state != nil {
-		writer, err := e.GetWriter()
-		if err != nil {
-			return err
-		}
-
-		if err := lib0.WriteVarUint(writer, e.count-1); err != nil {
-			return err
-		}
-	}
-	return e.AbstractStreamEncoder.Flush()
-}
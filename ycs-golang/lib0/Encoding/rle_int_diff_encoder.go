@@ -51,18 +51,3 @@ func (e *RleIntDiffEncoder) Write(value int64) error {
 	}
 	return nil
 }
-
-// Flush writes any remaining values to the stream.
-func (e *RleIntDiffEncoder) Flush() error {
-	if e.count > 0 {
-		writer, err := e.GetWriter()
-		if err != nil {
-			return err
-		}
-
-		if err := lib0.WriteVarUint(writer, e.count-1); err != nil {
-			return err
-		}
-	}
-	return e.AbstractStreamEncoder.Flush()
-}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"strings"
+	"unicode/utf16"
 
 	"ycs/lib0"
 )
@@ -24,6 +25,7 @@ func NewStringEncoder() *StringEncoder {
 }
 
 // Write writes a string value to the encoder.
+// Lengths are counted in UTF-16 code units, like the other Yjs implementations.
 func (e *StringEncoder) Write(value string) error {
 	if e.disposed {
 		return NewObjectDisposedException("StringEncoder")
@@ -33,11 +35,11 @@ func (e *StringEncoder) Write(value string) error {
 	if err != nil {
 		return err
 	}
-	return e.lengthEncoder.Write(uint32(len(value)))
+	return e.lengthEncoder.Write(uint32(utf16Length(value)))
 }
 
-// WriteChars writes a character array to the encoder.
-func (e *StringEncoder) WriteChars(value []byte, offset int, count int) error {
+// WriteChars writes count UTF-16 code units of value, starting at offset, to the encoder.
+func (e *StringEncoder) WriteChars(value []uint16, offset int, count int) error {
 	if e.disposed {
 		return NewObjectDisposedException("StringEncoder")
 	}
@@ -46,13 +48,22 @@ func (e *StringEncoder) WriteChars(value []byte, offset int, count int) error {
 		return errors.New("invalid offset or count")
 	}
 
-	_, err := e.builder.Write(value[offset : offset+count])
+	_, err := e.builder.WriteString(string(utf16.Decode(value[offset : offset+count])))
 	if err != nil {
 		return err
 	}
 	return e.lengthEncoder.Write(uint32(count))
 }
 
+// utf16Length returns the number of UTF-16 code units of a string
+func utf16Length(value string) int {
+	length := 0
+	for _, r := range value {
+		length += utf16.RuneLen(r)
+	}
+	return length
+}
+
 // ToArray returns the encoded data as a byte array.
 func (e *StringEncoder) ToArray() ([]byte, error) {
 	if e.disposed {
@@ -50,6 +50,22 @@ func (e *UintOptRleEncoder) Flush() error {
 	return e.AbstractStreamEncoder.Flush()
 }
 
+// ToArray flushes the pending run and returns the encoded bytes.
+func (e *UintOptRleEncoder) ToArray() ([]byte, error) {
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return e.buffer.Bytes(), nil
+}
+
+// GetBuffer flushes the pending run and returns the buffer and its length.
+func (e *UintOptRleEncoder) GetBuffer() ([]byte, int, error) {
+	if err := e.Flush(); err != nil {
+		return nil, 0, err
+	}
+	return e.buffer.Bytes(), e.buffer.Len(), nil
+}
+
 // writeEncodedValue writes the current state and count to the stream.
 func (e *UintOptRleEncoder) writeEncodedValue() error {
 	if e.count == 0 {
@@ -68,15 +84,9 @@ func (e *UintOptRleEncoder) writeEncodedValue() error {
 		}
 	} else {
 		// Multiple values - write as negative varint followed by count
-		var encodedValue int64
-		if e.state == 0 {
-			// Special case for zero to ensure it's treated as negative
-			encodedValue = 0
-		} else {
-			encodedValue = -int64(e.state)
-		}
-
-		if err := lib0.WriteVarInt(writer, encodedValue, nil); err != nil {
+		// Treat zero as negative in case we pass the '-0'
+		treatZeroAsNegative := e.state == 0
+		if err := lib0.WriteVarInt(writer, -int64(e.state), &treatZeroAsNegative); err != nil {
 			return err
 		}
 
@@ -8,6 +8,7 @@ package decoding
 
 import (
 	"io"
+	"unicode/utf16"
 
 	"ycs/lib0"
 )
@@ -15,9 +16,10 @@ import (
 // StringDecoder decodes strings from a stream using length prefixes.
 type StringDecoder struct {
 	lengthDecoder *UintOptRleDecoder
-	value         string
-	pos           int
-	disposed      bool
+	// value holds the UTF-16 code units the lengths refer to
+	value    []uint16
+	pos      int
+	disposed bool
 }
 
 // NewStringDecoder creates a new instance of StringDecoder.
@@ -34,7 +36,7 @@ func NewStringDecoder(input io.ReadSeekCloser, leaveOpen bool) *StringDecoder {
 	}
 
 	return &StringDecoder{
-		value:         value,
+		value:         utf16.Encode([]rune(value)),
 		lengthDecoder: NewUintOptRleDecoder(input, leaveOpen),
 	}
 }
@@ -59,12 +61,12 @@ func (d *StringDecoder) Read() (string, error) {
 		return "", io.ErrUnexpectedEOF
 	}
 
-	result := d.value[d.pos:endPos]
+	result := string(utf16.Decode(d.value[d.pos:endPos]))
 	d.pos = endPos
 
 	// No need to keep the string in memory anymore
 	if d.pos >= len(d.value) {
-		d.value = ""
+		d.value = nil
 	}
 
 	return result, nil
@@ -0,0 +1,100 @@
+package decoding_test
+
+import (
+	"bytes"
+	"testing"
+
+	encoding "ycs/lib0/Encoding"
+	"ycs/lib0/decoding"
+)
+
+// readSeekCloser is a stream over bytes, as the decoders expect
+type readSeekCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekCloser) Close() error {
+	return nil
+}
+
+func stream(data []byte) readSeekCloser {
+	return readSeekCloser{bytes.NewReader(data)}
+}
+
+func TestStringRoundTripCountsUTF16(t *testing.T) {
+	values := []string{"", "plain", "é", "😀 emoji", "mixed 😀é\n", ""}
+
+	encoder := encoding.NewStringEncoder()
+	for _, value := range values {
+		if err := encoder.Write(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := encoder.ToArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := decoding.NewStringDecoder(stream(data), false)
+	for _, want := range values {
+		got, err := decoder.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestUintOptRleRoundTrip(t *testing.T) {
+	values := []uint32{0, 0, 0, 1, 2, 2, 7, 0, 300, 300, 300}
+
+	encoder := encoding.NewUintOptRleEncoder()
+	for _, value := range values {
+		if err := encoder.Write(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := encoder.ToArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := decoding.NewUintOptRleDecoder(stream(data), false)
+	for i, want := range values {
+		got, err := decoder.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint32(got) != want {
+			t.Fatalf("value %d: got %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestIntDiffOptRleRoundTrip(t *testing.T) {
+	values := []int64{0, 1, 2, 3, 3, 3, 10, 5, 0, -4}
+
+	encoder := encoding.NewIntDiffOptRleEncoder()
+	for _, value := range values {
+		if err := encoder.Write(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := encoder.ToArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := decoding.NewIntDiffOptRleDecoder(stream(data), false)
+	for i, want := range values {
+		got, err := decoder.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("value %d: got %d, want %d", i, got, want)
+		}
+	}
+}
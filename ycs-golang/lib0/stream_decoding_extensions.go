@@ -21,6 +21,11 @@ var (
 	ErrUnknownObjectType = errors.New("unknown object type")
 )
 
+// MaxPreallocation bounds the capacity allocated up front for a length read from a
+// stream. Longer data grows while it is read, so a hostile length cannot allocate
+// more memory than the stream actually holds.
+const MaxPreallocation = 1 << 16
+
 // TypeAssertionError represents an error when type assertion fails
 type TypeAssertionError struct {
 	Message string
@@ -126,8 +131,8 @@ func ReadVarString(reader StreamReader) (string, error) {
 		return "", nil
 	}
 
-	data := make([]byte, length)
-	if _, err := io.ReadFull(reader, data); err != nil {
+	data, err := readFull(reader, length)
+	if err != nil {
 		return "", err
 	}
 
@@ -145,11 +150,26 @@ func ReadVarUint8Array(reader StreamReader) ([]byte, error) {
 		return nil, err
 	}
 
-	data := make([]byte, length)
-	if _, err := io.ReadFull(reader, data); err != nil {
-		return nil, err
+	return readFull(reader, length)
+}
+
+// readFull reads exactly length bytes, allocating no more than what was read
+func readFull(reader StreamReader, length uint32) ([]byte, error) {
+	if length <= MaxPreallocation {
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return data, nil
 	}
 
+	data, err := io.ReadAll(io.LimitReader(reader, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < int(length) {
+		return nil, io.ErrUnexpectedEOF
+	}
 	return data, nil
 }
 
@@ -213,13 +233,13 @@ func ReadAny(reader StreamReader) (interface{}, error) {
 			return nil, err
 		}
 
-		array := make([]interface{}, length)
+		array := make([]interface{}, 0, min(length, MaxPreallocation))
 		for i := uint32(0); i < length; i++ {
 			item, err := ReadAny(reader)
 			if err != nil {
 				return nil, err
 			}
-			array[i] = item
+			array = append(array, item)
 		}
 		return array, nil
 
@@ -0,0 +1,69 @@
+package lib0
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+)
+
+// allocated returns the number of bytes allocated while f runs
+func allocated(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestReadHostileLengthsDoNotPreallocate(t *testing.T) {
+	// Without the bound, reading this length would allocate gigabytes up front
+	const length = 1 << 30
+
+	var header bytes.Buffer
+	if err := WriteVarUint(&header, length); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		tag  []byte
+		read func(r StreamReader) error
+	}{
+		{"string", nil, func(r StreamReader) error {
+			_, err := ReadVarString(r)
+			return err
+		}},
+		{"bytes", nil, func(r StreamReader) error {
+			_, err := ReadVarUint8Array(r)
+			return err
+		}},
+		{"array", []byte{117}, func(r StreamReader) error {
+			_, err := ReadAny(r)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		name, read := tt.name, tt.read
+		input := append(append(append([]byte{}, tt.tag...), header.Bytes()...), 'a', 'b', 'c')
+		var err error
+		n := allocated(func() { err = read(bytes.NewReader(input)) })
+		if err == nil {
+			t.Fatalf("%s: read %d items from 3 bytes", name, length)
+		}
+		if n > 32*MaxPreallocation {
+			t.Fatalf("%s: allocated %d bytes for a 3 byte input", name, n)
+		}
+	}
+}
+
+func TestReadVarUint8ArrayReportsShortInput(t *testing.T) {
+	var buf bytes.Buffer
+	WriteVarUint(&buf, MaxPreallocation+10)
+	buf.Write(make([]byte, MaxPreallocation))
+
+	if _, err := ReadVarUint8Array(bytes.NewReader(buf.Bytes())); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
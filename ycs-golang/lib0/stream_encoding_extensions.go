@@ -12,6 +12,7 @@ import (
 	"io"
 	"math"
 	"reflect"
+	"sort"
 )
 
 var (
@@ -52,7 +53,7 @@ func WriteVarUint(writer io.Writer, num uint32) error {
 		}
 		return streamWriter.WriteByte(byte(num & 0x7F))
 	}
-	
+
 	// Fallback implementation for regular io.Writer
 	buf := make([]byte, 1)
 	for num > 0x7F {
@@ -190,11 +191,17 @@ func WriteAny(writer StreamWriter, data interface{}) error {
 		if err := WriteVarUint(writer, uint32(len(v))); err != nil {
 			return err
 		}
-		for key, val := range v {
+		// Sorted keys keep the encoding of equal objects identical
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
 			if err := WriteVarString(writer, key); err != nil {
 				return err
 			}
-			if err := WriteAny(writer, val); err != nil {
+			if err := WriteAny(writer, v[key]); err != nil {
 				return err
 			}
 		}
@@ -234,19 +241,19 @@ func WriteAny(writer StreamWriter, data interface{}) error {
 			return nil
 
 		case reflect.Map:
+			if val.Type().Key().Kind() != reflect.String {
+				return ErrUnsupportedType
+			}
 			if err := writer.WriteByte(118); err != nil {
 				return err
 			}
 			keys := val.MapKeys()
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
 			if err := WriteVarUint(writer, uint32(len(keys))); err != nil {
 				return err
 			}
 			for _, key := range keys {
-				strKey, ok := key.Interface().(string)
-				if !ok {
-					return ErrUnsupportedType
-				}
-				if err := WriteVarString(writer, strKey); err != nil {
+				if err := WriteVarString(writer, key.String()); err != nil {
 					return err
 				}
 				if err := WriteAny(writer, val.MapIndex(key).Interface()); err != nil {
@@ -255,10 +262,39 @@ func WriteAny(writer StreamWriter, data interface{}) error {
 			}
 			return nil
 
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint, reflect.Uint64:
+			return WriteAny(writer, int64(toInt(val)))
+
+		case reflect.Float32, reflect.Float64, reflect.Bool, reflect.String, reflect.Int, reflect.Int64:
+			// Named types of the basic kinds
+			return WriteAny(writer, reflect.ValueOf(data).Convert(basicTypes[val.Kind()]).Interface())
+
+		case reflect.Pointer, reflect.Interface:
+			if val.IsNil() {
+				return writer.WriteByte(126)
+			}
+			return WriteAny(writer, val.Elem().Interface())
+
 		default:
 			return ErrUnsupportedType
 		}
 	}
 }
 
+// basicTypes maps the basic kinds to the types WriteAny encodes
+var basicTypes = map[reflect.Kind]reflect.Type{
+	reflect.Float32: reflect.TypeOf(float32(0)),
+	reflect.Float64: reflect.TypeOf(float64(0)),
+	reflect.Bool:    reflect.TypeOf(false),
+	reflect.String:  reflect.TypeOf(""),
+	reflect.Int:     reflect.TypeOf(int(0)),
+	reflect.Int64:   reflect.TypeOf(int64(0)),
+}
 
+// toInt returns the value of an integer of any size
+func toInt(val reflect.Value) int64 {
+	if val.CanInt() {
+		return val.Int()
+	}
+	return int64(val.Uint())
+}
@@ -0,0 +1,68 @@
+package lib0
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func encodeAny(t *testing.T, data interface{}) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteAny(&buf, data); err != nil {
+		t.Fatalf("WriteAny(%v): %v", data, err)
+	}
+	return buf.Bytes()
+}
+
+func TestWriteAnySortsMapKeys(t *testing.T) {
+	value := map[string]interface{}{"b": int64(1), "a": "x", "c": true, "d": nil}
+	first := encodeAny(t, value)
+	for i := 0; i < 20; i++ {
+		if again := encodeAny(t, value); !bytes.Equal(first, again) {
+			t.Fatalf("encoding is not stable: %v != %v", first, again)
+		}
+	}
+
+	decoded, err := ReadAny(bytes.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, value) {
+		t.Fatalf("got %#v, want %#v", decoded, value)
+	}
+}
+
+func TestWriteAnyNamedAndSizedTypes(t *testing.T) {
+	type name string
+	type count uint16
+	text := "pointer"
+
+	tests := []struct {
+		in   interface{}
+		want interface{}
+	}{
+		{name("n"), "n"},
+		{count(7), int64(7)},
+		{int32(-3), int64(-3)},
+		{&text, "pointer"},
+		{(*string)(nil), nil},
+		{map[string]int{"z": 1, "a": 2}, map[string]interface{}{"a": int64(2), "z": int64(1)}},
+	}
+	for _, tt := range tests {
+		got, err := ReadAny(bytes.NewReader(encodeAny(t, tt.in)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("WriteAny(%#v) decodes to %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriteAnyRejectsNonStringKeys(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteAny(&buf, map[int]string{1: "a"}); err != ErrUnsupportedType {
+		t.Fatalf("got %v, want ErrUnsupportedType", err)
+	}
+}
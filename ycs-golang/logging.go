@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+
+	"ycs/config"
+)
+
+// logLevel represents the minimum severity written to the log
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var currentLogLevel = levelInfo
+
+// setLogLevel sets the minimum severity from a configuration value
+func setLogLevel(name string) {
+	switch name {
+	case config.LogDebug:
+		currentLogLevel = levelDebug
+	case config.LogWarn:
+		currentLogLevel = levelWarn
+	case config.LogError:
+		currentLogLevel = levelError
+	default:
+		currentLogLevel = levelInfo
+	}
+}
+
+func logf(level logLevel, prefix string, format string, args ...interface{}) {
+	if level >= currentLogLevel {
+		log.Printf(prefix+format, args...)
+	}
+}
+
+func logDebugf(format string, args ...interface{}) {
+	logf(levelDebug, "DEBUG ", format, args...)
+}
+
+func logInfof(format string, args ...interface{}) {
+	logf(levelInfo, "", format, args...)
+}
+
+func logWarnf(format string, args ...interface{}) {
+	logf(levelWarn, "WARN ", format, args...)
+}
+
+func logErrorf(format string, args ...interface{}) {
+	logf(levelError, "ERROR ", format, args...)
+}
@@ -1,16 +1,34 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"ycs/bus"
+	"ycs/config"
 	"ycs/contracts"
 	"ycs/core"
+	"ycs/history"
+	"ycs/persistence"
+	"ycs/protocols"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -22,6 +40,12 @@ type YjsCommandType string
 const (
 	GetMissing YjsCommandType = "GetMissing"
 	Update     YjsCommandType = "Update"
+	// QueryAwareness asks for the awareness states of all clients
+	QueryAwareness YjsCommandType = "QueryAwareness"
+	// UpdateAwareness carries a y-protocols awareness update
+	UpdateAwareness YjsCommandType = "UpdateAwareness"
+	// Session is sent by the server as the first message of a connection
+	Session YjsCommandType = "Session"
 )
 
 // YjsMessage represents a message structure for Yjs communication
@@ -29,6 +53,8 @@ type YjsMessage struct {
 	Clock     int64           `json:"clock"`
 	Data      string          `json:"data"`
 	InReplyTo *YjsCommandType `json:"inReplyTo,omitempty"`
+	// Guid is set on sync messages of a subdocument of the room document
+	Guid string `json:"guid,omitempty"`
 }
 
 // MessageToProcess represents a message to be processed
@@ -36,76 +62,67 @@ type MessageToProcess struct {
 	Command   YjsCommandType
 	InReplyTo *YjsCommandType
 	Data      string
+	// Guid is the subdocument the message syncs, empty for the room document
+	Guid string
+	// ReceivedAt is set when the message is queued for processing
+	ReceivedAt time.Time
 }
 
-// ClientContext manages the state for each connected client
-type ClientContext struct {
-	synced      bool
-	serverClock int64
-	clientClock int64
-	messages    map[int64]*MessageToProcess
-	conn        *websocket.Conn
-	mutex       sync.RWMutex
-}
-
-func NewClientContext(conn *websocket.Conn) *ClientContext {
-	return &ClientContext{
-		synced:      false,
-		serverClock: -1,
-		clientClock: -1,
-		messages:    make(map[int64]*MessageToProcess),
-		conn:        conn,
-	}
-}
-
-func (cc *ClientContext) IncrementAndGetServerClock() int64 {
-	cc.mutex.Lock()
-	defer cc.mutex.Unlock()
-	cc.serverClock++
-	return cc.serverClock
-}
+// errClientClosed is returned when replying to a client whose session has expired
+var errClientClosed = errors.New("client session expired")
 
-func (cc *ClientContext) IncrementAndGetClientClock() int64 {
-	cc.mutex.Lock()
-	defer cc.mutex.Unlock()
-	cc.clientClock++
-	return cc.clientClock
-}
-
-func (cc *ClientContext) ReassignClientClock(clock int64) {
-	cc.mutex.Lock()
-	defer cc.mutex.Unlock()
-	cc.clientClock = clock
-}
-
-func (cc *ClientContext) IsSynced() bool {
-	cc.mutex.RLock()
-	defer cc.mutex.RUnlock()
-	return cc.synced
-}
+// sweepInterval is how often sessions and pending messages are checked for expiry
+const sweepInterval = time.Second
 
-func (cc *ClientContext) SetSynced(synced bool) {
-	cc.mutex.Lock()
-	defer cc.mutex.Unlock()
-	cc.synced = synced
-}
-
-// YcsManager manages the YCS document and client connections
+// YcsManager manages the YCS document of one room and its client connections
 type YcsManager struct {
-	doc     *core.YDoc
-	clients map[string]*ClientContext
-	mutex   sync.RWMutex
+	room       string
+	doc        *core.YDoc
+	clients    map[string]*ClientContext
+	mutex      sync.RWMutex
+	replicator *bus.Replicator
+	awareness  *protocols.Awareness
+
+	// closeCode and closeReason are set once the clients were asked to disconnect
+	closeCode   int
+	closeReason string
+
+	// connections counts the open connections, including those still being flushed
+	connections int
+
+	// resumeTimeout is how long the session of a disconnected client is kept
+	resumeTimeout time.Duration
+	stopSweep     chan struct{}
+	closeOnce     sync.Once
+
+	// clientIDClaims maps the client IDs that sessions announced ahead of the
+	// document to the session that announced them first
+	clientIDClaims map[int64]string
+
+	// subdocs holds the subdocuments that clients sync, by GUID. They are loaded
+	// from store and replicated through bus like the room document.
+	subdocs map[string]*roomSubdoc
+	store   persistence.Store
+	bus     bus.Bus
+	replica string
+
+	// history creates the checkpoints of the room, nil without version history
+	history *history.Recorder
 }
 
-func NewYcsManager() *YcsManager {
+func NewYcsManager(room string, resumeTimeout time.Duration) *YcsManager {
 	manager := &YcsManager{
-		doc:     core.NewYDoc(contracts.YDocOptions{}),
-		clients: make(map[string]*ClientContext),
+		room:          room,
+		doc:           core.NewYDoc(contracts.YDocOptions{}),
+		clients:       make(map[string]*ClientContext),
+		awareness:     protocols.NewAwareness(),
+		resumeTimeout: resumeTimeout,
+		stopSweep:     make(chan struct{}),
+
+		clientIDClaims: make(map[int64]string),
+		subdocs:        make(map[string]*roomSubdoc),
 	}
-
-	// Prepopulate document with data (like C# version)
-	text := manager.doc.GetText("monaco")
-	text.Insert(0, "Hello, world!")
+	go manager.sweepLoop()
 
 	// Set up update handler
 	manager.doc.OnUpdateV2(func(update []byte, origin interface{}, transaction contracts.ITransaction) {
@@ -113,52 +130,355 @@ func NewYcsManager() *YcsManager {
 			return
 		}
 
-		encodedUpdate := base64.StdEncoding.EncodeToString(update)
+		// Relay local updates to the other replicas serving this room
+		if manager.replicator != nil {
+			if err := manager.replicator.Publish(update, origin); err != nil {
+				logErrorf("Error publishing update for room %s: %v", manager.room, err)
+			}
+		}
 
-		// Send update to all synced clients
+		// Send update to all clients that were sent the document
 		manager.mutex.RLock()
 		clients := make([]*ClientContext, 0, len(manager.clients))
 		for _, client := range manager.clients {
-			if client.IsSynced() {
+			if client.ReceivesUpdates() {
 				clients = append(clients, client)
 			}
 		}
 		manager.mutex.RUnlock()
 
 		for _, client := range clients {
-			msg := YjsMessage{
-				Clock: client.IncrementAndGetServerClock(),
-				Data:  encodedUpdate,
-			}
+			client.Send(Update, nil, update)
+		}
+	})
+	manager.doc.OnSubdocsChanged(manager.handleSubdocsChanged)
 
-			response := map[string]interface{}{
-				"type": string(Update),
-				"data": msg,
-			}
+	return manager
+}
+
+// Prepopulate fills the document with sample data (like C# version)
+func (ym *YcsManager) Prepopulate() {
+	ym.doc.Transact(func(tr contracts.ITransaction) {
+		ym.doc.GetText("monaco").Insert(0, "Hello, world!")
+	}, nil)
+}
+
+// AttachBus starts relaying the room's document through the bus.
+// The local document is reconciled with the peers that already serve the room.
+func (ym *YcsManager) AttachBus(b bus.Bus, replica string) error {
+	replicator := bus.NewReplicator(b, replica, ym.room, ym.doc, nil)
+	ym.replicator = replicator
+	ym.bus = b
+	ym.replica = replica
+	return replicator.Start()
+}
+
+// AttachHistory starts recording checkpoints of the room's document in the store
+func (ym *YcsManager) AttachHistory(store history.Store, interval time.Duration) {
+	ym.history = history.NewRecorder(ym.room, ym.doc, store, interval)
+}
+
+// GetHistory returns the checkpoint recorder of the room, or nil without version history
+func (ym *YcsManager) GetHistory() *history.Recorder {
+	return ym.history
+}
+
+// Close detaches the manager from the bus and stops expiring sessions
+func (ym *YcsManager) Close() {
+	ym.closeOnce.Do(func() {
+		close(ym.stopSweep)
+	})
+	if ym.replicator != nil {
+		ym.replicator.Close()
+	}
+	if ym.history != nil {
+		ym.history.Close()
+	}
+	ym.closeSubdocs()
+}
+
+// sweepLoop periodically expires sessions and gives up on lost messages
+func (ym *YcsManager) sweepLoop() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ym.stopSweep:
+			return
+		case <-ticker.C:
+			ym.sweep()
+		}
+	}
+}
+
+func (ym *YcsManager) sweep() {
+	ym.mutex.RLock()
+	clients := make([]*ClientContext, 0, len(ym.clients))
+	for _, client := range ym.clients {
+		clients = append(clients, client)
+	}
+	ym.mutex.RUnlock()
 
-			go func(c *ClientContext) {
-				if err := c.conn.WriteJSON(response); err != nil {
-					log.Printf("Error sending update to client: %v", err)
-				}
-			}(client)
+	for _, client := range clients {
+		if client.IsExpired(ym.resumeTimeout) {
+			ym.HandleClientDisconnected(client.GetSessionID())
+			continue
 		}
+
+		if client.skipStaleGap() {
+			if err := ym.processMessagesInOrder(client); err != nil {
+				logErrorf("Error processing message: %v", err)
+			}
+		}
+	}
+}
+
+// GetRoom returns the name of the room
+func (ym *YcsManager) GetRoom() string {
+	return ym.room
+}
+
+// GetClientCount returns the number of open client connections
+func (ym *YcsManager) GetClientCount() int {
+	ym.mutex.RLock()
+	defer ym.mutex.RUnlock()
+	return ym.connections
+}
+
+// GetPendingCounts returns the number of structs and delete sets of the document
+// that wait for missing updates
+func (ym *YcsManager) GetPendingCounts() (structs int, deleteSets int) {
+	ym.doc.Lock()
+	defer ym.doc.Unlock()
+
+	store := ym.doc.GetStore()
+	return store.GetPendingStructCount(), store.GetPendingDeleteSetCount()
+}
+
+// Load applies the stored document of the room. Returns false if the room was never stored.
+// Subdocuments are loaded from the store once a client syncs them.
+func (ym *YcsManager) Load(store persistence.Store) (bool, error) {
+	ym.store = store
+	update, err := store.Load(ym.room)
+	if err != nil || update == nil {
+		return false, err
+	}
+
+	ym.doc.Lock()
+	defer ym.doc.Unlock()
+	if err := ym.doc.ApplyUpdateV2(update, store, false); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Save stores the whole document of the room and the subdocuments synced by clients
+func (ym *YcsManager) Save(store persistence.Store) error {
+	var update []byte
+	var err error
+	ym.doc.View(func() {
+		update, err = ym.doc.EncodeStateAsUpdateV2(nil)
 	})
+	if err != nil {
+		return err
+	}
+	if err := store.Save(ym.room, update); err != nil {
+		return err
+	}
 
-	return manager
+	return ym.saveSubdocs(store)
+}
+
+// CloseClients closes all clients after their queued messages are written.
+// Clients connecting afterwards are closed right away.
+func (ym *YcsManager) CloseClients(code int, reason string) {
+	ym.mutex.Lock()
+	defer ym.mutex.Unlock()
+
+	ym.closeCode = code
+	ym.closeReason = reason
+	for _, client := range ym.clients {
+		client.CloseConnection(code, reason)
+	}
+}
+
+// WaitClients blocks until all clients have disconnected or ctx is done
+func (ym *YcsManager) WaitClients(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for ym.GetClientCount() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
 }
 
-func (ym *YcsManager) HandleClientConnected(clientID string, conn *websocket.Conn) {
+// CloseConnections closes the connections of the remaining clients without a handshake
+func (ym *YcsManager) CloseConnections() {
+	ym.mutex.RLock()
+	defer ym.mutex.RUnlock()
+
+	for _, client := range ym.clients {
+		if transport := client.GetConnection(); transport != nil {
+			transport.Close()
+		}
+	}
+}
+
+// HandleClientConnected binds a connection to the client's session. A client presenting
+// the ID of a live session resumes it from ackClock, the last server clock it processed;
+// otherwise a new session is created. Returns the session, the connection and whether
+// the session was resumed.
+func (ym *YcsManager) HandleClientConnected(sessionID string, ackClock int64, transport clientTransport) (*ClientContext, *clientConnection, bool) {
 	ym.mutex.Lock()
 	defer ym.mutex.Unlock()
-	ym.clients[clientID] = NewClientContext(conn)
-	log.Printf("Client connected: %s", clientID)
+
+	client, exists := ym.clients[sessionID]
+	if exists && client.IsExpired(ym.resumeTimeout) {
+		exists = false
+	}
+	if !exists {
+		client = NewClientContext(newSessionID())
+		ym.clients[client.GetSessionID()] = client
+	}
+
+	connection, resumed := client.Attach(transport, exists, ackClock)
+	ym.connections++
+	if ym.closeCode != 0 {
+		client.CloseConnection(ym.closeCode, ym.closeReason)
+	}
+
+	if resumed {
+		logInfof("Client resumed: %s", client.GetSessionID())
+	} else {
+		logInfof("Client connected: %s", client.GetSessionID())
+	}
+	return client, connection, resumed
 }
 
+// HandleClientDetached unbinds a closed connection and waits until its queued messages
+// are written. The session is kept for resumeTimeout, but its awareness states are removed.
+func (ym *YcsManager) HandleClientDetached(client *ClientContext, connection *clientConnection) {
+	client.Detach(connection)
+
+	if removal := ym.awareness.RemoveStates(client.TakeAwarenessClients()); removal != nil {
+		ym.broadcastAwareness(client, removal)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	connection.wait(ctx)
+
+	ym.mutex.Lock()
+	ym.connections--
+	ym.mutex.Unlock()
+	logInfof("Client detached: %s", client.GetSessionID())
+}
+
+// HandleClientDisconnected removes the client's session
 func (ym *YcsManager) HandleClientDisconnected(clientID string) {
 	ym.mutex.Lock()
 	defer ym.mutex.Unlock()
 	delete(ym.clients, clientID)
-	log.Printf("Client disconnected: %s", clientID)
+	for id, session := range ym.clientIDClaims {
+		if session == clientID {
+			delete(ym.clientIDClaims, id)
+		}
+	}
+	logInfof("Client disconnected: %s", clientID)
+}
+
+// claimClientIDs records the client IDs for which the client announced more structs
+// than the document has. Only the peer using a client ID creates its structs, so two
+// connected clients announcing the same ID ahead of the document use the same ID, and
+// the changes they make under it conflict. Clients detect this themselves once they
+// receive each other's structs; the server can only warn.
+func (ym *YcsManager) claimClientIDs(client *ClientContext, stateVector []byte, docState map[int64]int64) {
+	announced, err := core.DecodeStateVector(bytes.NewReader(stateVector))
+	if err != nil {
+		return
+	}
+
+	ym.mutex.Lock()
+	defer ym.mutex.Unlock()
+
+	session := client.GetSessionID()
+	for id, clock := range announced {
+		if clock <= docState[id] {
+			continue
+		}
+
+		if owner, claimed := ym.clientIDClaims[id]; claimed && owner != session {
+			clientIDConflicts.WithLabelValues().Inc()
+			logWarnf("Clients %s and %s of room %s both announce client ID %d", owner, session, ym.room, id)
+			continue
+		}
+		ym.clientIDClaims[id] = session
+	}
+}
+
+// ProcessAwareness handles an awareness message. Awareness messages carry no clock
+// and are handled as they arrive.
+func (ym *YcsManager) ProcessAwareness(clientID string, command YjsCommandType, data string) error {
+	ym.mutex.RLock()
+	client, exists := ym.clients[clientID]
+	ym.mutex.RUnlock()
+
+	if !exists {
+		rejectedMessages.WithLabelValues(rejectUnknownClient).Inc()
+		return fmt.Errorf("client not found: %s", clientID)
+	}
+
+	switch command {
+	case QueryAwareness:
+		if states := ym.awareness.EncodeStates(); states != nil {
+			client.SendAwareness(states)
+		}
+		return nil
+	case UpdateAwareness:
+		update, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			rejectedMessages.WithLabelValues(rejectInvalidData).Inc()
+			return err
+		}
+
+		changed, err := ym.awareness.ApplyUpdate(update)
+		if err != nil {
+			rejectedMessages.WithLabelValues(rejectInvalidData).Inc()
+			return err
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+
+		// Remember whose states these are so they can be removed when the client leaves
+		client.TrackAwareness(changed)
+		ym.broadcastAwareness(client, protocols.EncodeAwarenessUpdate(changed))
+		return nil
+	default:
+		return fmt.Errorf("not an awareness message: %s", command)
+	}
+}
+
+// broadcastAwareness sends an awareness update to all clients except the sender
+func (ym *YcsManager) broadcastAwareness(sender *ClientContext, update []byte) {
+	ym.mutex.RLock()
+	clients := make([]*ClientContext, 0, len(ym.clients))
+	for _, client := range ym.clients {
+		if client != sender {
+			clients = append(clients, client)
+		}
+	}
+	ym.mutex.RUnlock()
+
+	for _, client := range clients {
+		client.SendAwareness(update)
+	}
 }
 
 func (ym *YcsManager) ProcessMessage(clientID string, clock int64, message *MessageToProcess) error {
@@ -167,10 +487,17 @@ func (ym *YcsManager) ProcessMessage(clientID string, clock int64, message *Mess
 	ym.mutex.RUnlock()
 
 	if !exists {
+		rejectedMessages.WithLabelValues(rejectUnknownClient).Inc()
 		return fmt.Errorf("client not found: %s", clientID)
 	}
 
 	client.mutex.Lock()
+	if clock <= client.clientClock {
+		// Already processed, e.g. sent again after a reconnect
+		client.mutex.Unlock()
+		return nil
+	}
+	message.ReceivedAt = time.Now()
 	client.messages[clock] = message
 	client.mutex.Unlock()
 
@@ -179,93 +506,120 @@ func (ym *YcsManager) ProcessMessage(clientID string, clock int64, message *Mess
 }
 
 func (ym *YcsManager) processMessagesInOrder(client *ClientContext) error {
-	client.mutex.Lock()
-	defer client.mutex.Unlock()
+	client.processMutex.Lock()
+	defer client.processMutex.Unlock()
 
-	// Process messages in chronological order
+	// Process messages in chronological order. A message that fails is still
+	// consumed so that it cannot hold back the messages after it.
+	var firstErr error
 	for {
+		client.mutex.RLock()
 		nextClock := client.clientClock + 1
 		message, exists := client.messages[nextClock]
+		client.mutex.RUnlock()
 		if !exists {
 			break
 		}
 
-		switch message.Command {
-		case GetMissing:
-			if err := ym.handleGetMissing(client, message); err != nil {
-				return err
-			}
-		case Update:
-			if err := ym.handleUpdate(client, message); err != nil {
-				return err
-			}
+		if err := ym.handleMessage(client, message); err != nil && firstErr == nil {
+			firstErr = err
 		}
 
+		client.mutex.Lock()
 		client.clientClock++
 		delete(client.messages, nextClock)
+		client.mutex.Unlock()
 	}
 
-	return nil
+	return firstErr
+}
+
+// handleMessage handles one message of a client. Messages are processed by the
+// goroutine of the connection or by the sweep, which must not be taken down by a
+// message, so a panic is reported as the message's error.
+func (ym *YcsManager) handleMessage(client *ClientContext, message *MessageToProcess) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("handling %s from client %s: %v", message.Command, client.GetSessionID(), rec)
+		}
+	}()
+
+	switch {
+	case message.Command == GetMissing && message.Guid != "":
+		return ym.handleSubdocGetMissing(client, message)
+	case message.Command == Update && message.Guid != "":
+		return ym.handleSubdocUpdate(client, message)
+	case message.Command == GetMissing:
+		return ym.handleGetMissing(client, message)
+	case message.Command == Update:
+		return ym.handleUpdate(client, message)
+	default:
+		rejectedMessages.WithLabelValues(rejectUnknownType).Inc()
+		logWarnf("Ignoring message of unknown type %s", message.Command)
+		return nil
+	}
 }
 
 func (ym *YcsManager) handleGetMissing(client *ClientContext, message *MessageToProcess) error {
 	// Decode state vector
 	decodedStateVector, err := base64.StdEncoding.DecodeString(message.Data)
 	if err != nil {
+		rejectedMessages.WithLabelValues(rejectInvalidData).Inc()
 		return err
 	}
 
-	// Generate update and state vector
-	update := ym.doc.EncodeStateAsUpdateV2(decodedStateVector)
-	stateVector := ym.doc.EncodeStateVectorV2()
-
-	// Send SyncStep2 (Update) message
-	getMissingType := GetMissing
-	syncStep2Message := YjsMessage{
-		Clock:     client.IncrementAndGetServerClock(),
-		Data:      base64.StdEncoding.EncodeToString(update),
-		InReplyTo: &getMissingType,
-	}
-
-	response2 := map[string]interface{}{
-		"type": string(Update),
-		"data": syncStep2Message,
-	}
+	// Generate update and state vector; the replies are queued before the lock is
+	// released so that they precede any update broadcast afterwards
+	ym.doc.Lock()
+	defer ym.doc.Unlock()
 
-	if err := client.conn.WriteJSON(response2); err != nil {
+	update, err := ym.doc.EncodeStateAsUpdateV2(decodedStateVector)
+	if err != nil {
+		rejectedMessages.WithLabelValues(rejectInvalidData).Inc()
 		return err
 	}
+	ym.claimClientIDs(client, decodedStateVector, ym.doc.GetStore().GetStateVector())
+	stateVector := ym.doc.EncodeStateVectorV2()
 
-	// Send SyncStep1 (GetMissing) message
-	syncStep1Message := YjsMessage{
-		Clock:     client.IncrementAndGetServerClock(),
-		Data:      base64.StdEncoding.EncodeToString(stateVector),
-		InReplyTo: &getMissingType,
-	}
-
-	response1 := map[string]interface{}{
-		"type": string(GetMissing),
-		"data": syncStep1Message,
+	// Send SyncStep2 (Update) message immediately followed by SyncStep1 (GetMissing) message
+	getMissingType := GetMissing
+	if !client.Send(Update, &getMissingType, update) || !client.Send(GetMissing, &getMissingType, stateVector) {
+		return errClientClosed
 	}
 
-	return client.conn.WriteJSON(response1)
+	// Updates made from now on are not in the reply; the client must not miss them
+	// while its own reply to GetMissing is on the way
+	client.SetReceivesUpdates(true)
+	return nil
 }
 
 func (ym *YcsManager) handleUpdate(client *ClientContext, message *MessageToProcess) error {
 	// Only process updates if client is synced or this is a sync response
 	getMissingType := GetMissing
 	if !client.IsSynced() && (message.InReplyTo == nil || *message.InReplyTo != getMissingType) {
+		rejectedMessages.WithLabelValues(rejectUnsynced).Inc()
 		return nil
 	}
 
 	// Decode update
 	update, err := base64.StdEncoding.DecodeString(message.Data)
 	if err != nil {
+		rejectedMessages.WithLabelValues(rejectInvalidData).Inc()
 		return err
 	}
+	updateBytes.WithLabelValues(directionIn).Add(float64(len(update)))
 
 	// Apply update to document
-	ym.doc.ApplyUpdateV2(update, "websocket", false)
+	ym.doc.Lock()
+	start := time.Now()
+	err = ym.doc.ApplyUpdateV2(update, "websocket", false)
+	updateApplySeconds.WithLabelValues().Observe(time.Since(start).Seconds())
+	ym.doc.Unlock()
+
+	if err != nil {
+		rejectedMessages.WithLabelValues(rejectInvalidData).Inc()
+		return err
+	}
 
 	// Mark client as synced if this was a sync response
 	if message.InReplyTo != nil && *message.InReplyTo == GetMissing {
@@ -275,42 +629,348 @@ func (ym *YcsManager) handleUpdate(client *ClientContext, message *MessageToProc
 	return nil
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins in development
-	},
+var upgrader = websocket.Upgrader{}
+
+// newOriginChecker returns the CheckOrigin function accepting the allowed origins.
+// Without origins only same-origin requests are accepted; "*" accepts every origin.
+func newOriginChecker(allowed []string) func(r *http.Request) bool {
+	if len(allowed) == 0 {
+		// The upgrader falls back to its same-origin check
+		return nil
+	}
+
+	origins := make(map[string]struct{}, len(allowed))
+	for _, origin := range allowed {
+		if origin == config.AnyOrigin {
+			return func(r *http.Request) bool {
+				return true
+			}
+		}
+		origins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = struct{}{}
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// Not a browser; origins only protect browser sessions
+			return true
+		}
+		_, ok := origins[strings.ToLower(origin)]
+		return ok
+	}
+}
+
+// defaultRoom is the room of clients connecting to /ws without a room name
+const defaultRoom = "default"
+
+// YcsRooms keeps one YcsManager per room, created when the first client joins
+type YcsRooms struct {
+	managers map[string]*YcsManager
+	bus      bus.Bus
+	replica  string
+	store    persistence.Store
+	limits   config.Limits
+	// history stores the checkpoints of the rooms, nil without version history
+	history         history.Store
+	historyInterval time.Duration
+	// resumeTimeout is how long the session of a disconnected client is kept
+	resumeTimeout time.Duration
+	closed        bool
+	mutex         sync.Mutex
+}
+
+// NewYcsRooms creates a new YcsRooms. With a nil bus every room lives in this process only;
+// with a nil store documents are not persisted.
+func NewYcsRooms(b bus.Bus, replica string, store persistence.Store, limits config.Limits, resumeTimeout time.Duration) *YcsRooms {
+	return &YcsRooms{
+		managers:      make(map[string]*YcsManager),
+		bus:           b,
+		replica:       replica,
+		store:         store,
+		limits:        limits,
+		resumeTimeout: resumeTimeout,
+	}
+}
+
+// SetHistory enables version history: the rooms created from now on record their
+// checkpoints in store, automatically every interval while they change
+func (yr *YcsRooms) SetHistory(store history.Store, interval time.Duration) {
+	yr.mutex.Lock()
+	defer yr.mutex.Unlock()
+	yr.history = store
+	yr.historyInterval = interval
+}
+
+// HasHistory returns true if version history is enabled
+func (yr *YcsRooms) HasHistory() bool {
+	yr.mutex.Lock()
+	defer yr.mutex.Unlock()
+	return yr.history != nil
+}
+
+var (
+	// errShuttingDown is returned when a room is requested after Shutdown was called
+	errShuttingDown = errors.New("server is shutting down")
+	// errTooManyRooms is returned when a new room would exceed the configured limit
+	errTooManyRooms = errors.New("too many rooms")
+)
+
+// Get returns the manager of the room, creating it if necessary
+func (yr *YcsRooms) Get(room string) (*YcsManager, error) {
+	yr.mutex.Lock()
+	defer yr.mutex.Unlock()
+
+	if yr.closed {
+		return nil, errShuttingDown
+	}
+
+	if manager, exists := yr.managers[room]; exists {
+		return manager, nil
+	}
+
+	if yr.limits.MaxRooms > 0 && len(yr.managers) >= yr.limits.MaxRooms {
+		return nil, errTooManyRooms
+	}
+
+	manager := NewYcsManager(room, yr.resumeTimeout)
+
+	loaded := false
+	if yr.store != nil {
+		var err error
+		if loaded, err = manager.Load(yr.store); err != nil {
+			manager.Close()
+			return nil, fmt.Errorf("loading room %s: %w", room, err)
+		}
+	}
+
+	if yr.bus == nil {
+		if !loaded {
+			// With a bus, the room is seeded by the replicas that already serve it;
+			// prepopulating on every replica would duplicate the sample text
+			manager.Prepopulate()
+		}
+	} else if err := manager.AttachBus(yr.bus, yr.replica); err != nil {
+		manager.Close()
+		return nil, fmt.Errorf("attaching room %s to bus: %w", room, err)
+	}
+
+	if yr.history != nil {
+		manager.AttachHistory(yr.history, yr.historyInterval)
+	}
+
+	yr.managers[room] = manager
+	logInfof("Room created: %s", room)
+	return manager, nil
+}
+
+// Lookup returns the manager of the room if it is loaded, without creating it
+func (yr *YcsRooms) Lookup(room string) (*YcsManager, bool) {
+	yr.mutex.Lock()
+	defer yr.mutex.Unlock()
+
+	manager, exists := yr.managers[room]
+	return manager, exists
+}
+
+// ListCheckpoints returns the stored checkpoints of the room, oldest first, whether
+// the room is loaded or not
+func (yr *YcsRooms) ListCheckpoints(room string) ([]history.Checkpoint, error) {
+	yr.mutex.Lock()
+	store := yr.history
+	yr.mutex.Unlock()
+
+	if store == nil {
+		return nil, nil
+	}
+	return store.List(room)
+}
+
+// GetManagers returns the managers of all loaded rooms
+func (yr *YcsRooms) GetManagers() []*YcsManager {
+	yr.mutex.Lock()
+	defer yr.mutex.Unlock()
+
+	managers := make([]*YcsManager, 0, len(yr.managers))
+	for _, manager := range yr.managers {
+		managers = append(managers, manager)
+	}
+	return managers
+}
+
+// Shutdown stops creating rooms and closes every client connection with the given
+// reason once its queued messages are written. It waits for clients to disconnect
+// for most of the time left until the deadline of ctx, then persists every loaded
+// document and drops the connections that are still open.
+func (yr *YcsRooms) Shutdown(ctx context.Context, reason string) {
+	yr.mutex.Lock()
+	yr.closed = true
+	yr.mutex.Unlock()
+
+	managers := yr.GetManagers()
+	for _, manager := range managers {
+		manager.CloseClients(websocket.CloseServiceRestart, reason)
+	}
+
+	// Keep a quarter of the remaining time for persisting the documents
+	drainCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		drainCtx, cancel = context.WithTimeout(ctx, time.Until(deadline)*3/4)
+		defer cancel()
+	}
+	for _, manager := range managers {
+		if err := manager.WaitClients(drainCtx); err != nil {
+			logWarnf("Clients of room %s did not disconnect in time", manager.GetRoom())
+			break
+		}
+	}
+
+	if yr.store != nil {
+		for _, manager := range managers {
+			if err := manager.Save(yr.store); err != nil {
+				logErrorf("Error persisting room %s: %v", manager.GetRoom(), err)
+			}
+		}
+		logInfof("Persisted %d rooms", len(managers))
+	}
+
+	for _, manager := range managers {
+		manager.CloseConnections()
+	}
+}
+
+// Close detaches all rooms from the bus
+func (yr *YcsRooms) Close() {
+	yr.mutex.Lock()
+	defer yr.mutex.Unlock()
+
+	for _, manager := range yr.managers {
+		manager.Close()
+	}
+}
+
+var ycsRooms *YcsRooms
+
+// newReplicaID generates a random ID identifying this server process on the bus
+func newReplicaID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("replica_%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
 }
 
-var ycsManager = NewYcsManager()
+// getRoom returns the manager of the room named in the request, or writes an
+// error response and returns nil if the room cannot be loaded
+func getRoom(w http.ResponseWriter, r *http.Request) *YcsManager {
+	room := requestRoom(r)
+	ycsManager, err := ycsRooms.Get(room)
+	if errors.Is(err, errShuttingDown) || errors.Is(err, errTooManyRooms) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return nil
+	}
+	if err != nil {
+		logErrorf("Error joining room %s: %v", room, err)
+		http.Error(w, "room unavailable", http.StatusInternalServerError)
+		return nil
+	}
+	return ycsManager
+}
+
+// requestRoom returns the name of the room in the request
+func requestRoom(r *http.Request) string {
+	if room := mux.Vars(r)["room"]; room != "" {
+		return room
+	}
+	return defaultRoom
+}
+
+// joinRoom returns the manager of the room named in the request, or writes an
+// error response and returns nil if the client cannot join it
+func joinRoom(w http.ResponseWriter, r *http.Request) *YcsManager {
+	ycsManager := getRoom(w, r)
+	if ycsManager == nil {
+		return nil
+	}
+
+	if maxClients := ycsRooms.limits.MaxClientsPerRoom; maxClients > 0 && ycsManager.GetClientCount() >= maxClients {
+		http.Error(w, "room is full", http.StatusServiceUnavailable)
+		return nil
+	}
+
+	return ycsManager
+}
 
 func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("protocol") == protocolMultiplex {
+		// Multiplexed clients name the rooms in their messages
+		handleMultiplexWebSocket(w, r)
+		return
+	}
+
+	ycsManager := joinRoom(w, r)
+	if ycsManager == nil {
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade failed: %v", err)
+		logWarnf("WebSocket upgrade failed: %v", err)
 		return
 	}
 	defer conn.Close()
 
-	clientID := fmt.Sprintf("client_%d", time.Now().UnixNano())
-	ycsManager.HandleClientConnected(clientID, conn)
-	defer ycsManager.HandleClientDisconnected(clientID)
+	if ycsRooms.limits.MaxMessageBytes > 0 {
+		conn.SetReadLimit(ycsRooms.limits.MaxMessageBytes)
+	}
+
+	query := r.URL.Query()
+	if query.Get("protocol") == protocolBinary {
+		serveBinaryClient(ycsManager, conn)
+		return
+	}
+
+	// A reconnecting client resumes its session from the last server clock it processed
+	ackClock, err := strconv.ParseInt(query.Get("serverClock"), 10, 64)
+	if err != nil {
+		ackClock = -1
+	}
+	client, connection, _ := ycsManager.HandleClientConnected(query.Get("session"), ackClock, &jsonTransport{conn: conn})
+	clientID := client.GetSessionID()
+	defer ycsManager.HandleClientDetached(client, connection)
 
 	for {
 		var rawMessage map[string]interface{}
 		if err := conn.ReadJSON(&rawMessage); err != nil {
-			log.Printf("Error reading message: %v", err)
+			if errors.Is(err, websocket.ErrReadLimit) {
+				rejectedMessages.WithLabelValues(rejectTooLarge).Inc()
+			}
+			logDebugf("Error reading message: %v", err)
 			break
 		}
 
 		messageType, ok := rawMessage["type"].(string)
 		if !ok {
-			log.Printf("Invalid message format: missing type")
+			rejectedMessages.WithLabelValues(rejectMalformed).Inc()
+			logWarnf("Invalid message format: missing type")
 			continue
 		}
 
 		dataRaw, ok := rawMessage["data"]
 		if !ok {
-			log.Printf("Invalid message format: missing data")
+			rejectedMessages.WithLabelValues(rejectMalformed).Inc()
+			logWarnf("Invalid message format: missing data")
+			continue
+		}
+
+		command := YjsCommandType(messageType)
+		if command == QueryAwareness || command == UpdateAwareness {
+			// Awareness data is the plain base64 update, without a clock
+			data, _ := dataRaw.(string)
+			logDebugf("Received %s message from %s", command, clientID)
+			if err := ycsManager.ProcessAwareness(clientID, command, data); err != nil {
+				logErrorf("Error processing awareness message: %v", err)
+			}
 			continue
 		}
 
@@ -319,7 +979,8 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		switch v := dataRaw.(type) {
 		case string:
 			if err := json.Unmarshal([]byte(v), &yjsMessage); err != nil {
-				log.Printf("Error parsing YJS message from string: %v", err)
+				rejectedMessages.WithLabelValues(rejectMalformed).Inc()
+				logErrorf("Error parsing YJS message from string: %v", err)
 				continue
 			}
 		case map[string]interface{}:
@@ -334,38 +995,52 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 				replyType := YjsCommandType(inReplyTo)
 				yjsMessage.InReplyTo = &replyType
 			}
+			if guid, ok := v["guid"].(string); ok {
+				yjsMessage.Guid = guid
+			}
 		default:
-			log.Printf("Invalid data format: %T", dataRaw)
+			rejectedMessages.WithLabelValues(rejectMalformed).Inc()
+			logWarnf("Invalid data format: %T", dataRaw)
 			continue
 		}
 
-		command := YjsCommandType(messageType)
+		logDebugf("Received %s message with clock %d from %s", command, yjsMessage.Clock, clientID)
 		messageToProcess := &MessageToProcess{
 			Command:   command,
 			InReplyTo: yjsMessage.InReplyTo,
 			Data:      yjsMessage.Data,
+			Guid:      yjsMessage.Guid,
 		}
 
 		if err := ycsManager.ProcessMessage(clientID, yjsMessage.Clock, messageToProcess); err != nil {
-			log.Printf("Error processing message: %v", err)
+			logErrorf("Error processing message: %v", err)
 		}
 	}
 }
 
-func handleSPA(w http.ResponseWriter, r *http.Request) {
-	// Check if the ClientApp/build directory exists and serve from there
-	// Otherwise, serve a simple fallback page
-	buildPath := "./ClientApp/build"
-	indexPath := buildPath + "/index.html"
+// newSPAHandler serves index.html of the build directory, or a simple fallback page
+func newSPAHandler(buildPath string) http.HandlerFunc {
+	indexPath := filepath.Join(buildPath, "index.html")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Check if built React app exists
+		if _, err := http.Dir(buildPath).Open("index.html"); err == nil {
+			// Serve the React app
+			http.ServeFile(w, r, indexPath)
+		} else {
+			// Fallback to simple HTML page
+			handleSimpleIndex(w, r)
+		}
+	}
+}
 
-	// Check if built React app exists
-	if _, err := http.Dir(buildPath).Open("index.html"); err == nil {
-		// Serve the React app
-		http.ServeFile(w, r, indexPath)
-	} else {
-		// Fallback to simple HTML page
-		handleSimpleIndex(w, r)
+// listenPort returns the port of a listen address for display
+func listenPort(addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
 	}
+	return port
 }
 
 func handleSimpleIndex(w http.ResponseWriter, r *http.Request) {
@@ -428,20 +1103,95 @@ npm start</pre>
 }
 
 func main() {
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		config.Usage(os.Stderr)
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\nRun %s -h to list the options.\n", err, os.Args[0])
+		os.Exit(2)
+	}
+	setLogLevel(cfg.LogLevel)
+
 	// Initialize the system
 	core.Initialize()
 
-	log.Printf("Starting YCS Golang server...")
-	log.Printf("Created YDoc with client ID: %d", ycsManager.doc.GetClientID())
+	logInfof("Starting YCS Golang server...")
+
+	replica := newReplicaID()
+	var updateBus bus.Bus
+	if cfg.Redis != "" {
+		redisBus, err := bus.NewRedisBus(bus.RedisOptions{Addr: cfg.Redis})
+		if err != nil {
+			log.Fatal("Failed to connect to bus:", err)
+		}
+		defer redisBus.Close()
+		updateBus = redisBus
+		logInfof("Relaying updates through Redis at %s as replica %s", cfg.Redis, replica)
+	}
+
+	var store persistence.Store
+	if cfg.Persistence.Backend == config.PersistenceFile {
+		fileStore, err := persistence.NewFileStore(cfg.Persistence.Dir)
+		if err != nil {
+			log.Fatal("Failed to open persistence store:", err)
+		}
+		store = fileStore
+		logInfof("Persisting documents in %s", cfg.Persistence.Dir)
+	}
+
+	var historyStore history.Store
+	if cfg.History.Enabled() {
+		fileStore, err := history.NewFileStore(cfg.History.Dir)
+		if err != nil {
+			log.Fatal("Failed to open history store:", err)
+		}
+		historyStore = fileStore
+		logInfof("Recording checkpoints in %s", cfg.History.Dir)
+	}
+
+	upgrader.CheckOrigin = newOriginChecker(cfg.AllowedOrigins)
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == config.AnyOrigin {
+			logWarnf("WebSocket connections are accepted from any origin")
+		}
+	}
+
+	ycsRooms = NewYcsRooms(updateBus, replica, store, cfg.Limits, time.Duration(cfg.ResumeTimeout))
+	defer ycsRooms.Close()
+	if historyStore != nil {
+		ycsRooms.SetHistory(historyStore, time.Duration(cfg.History.Interval))
+	}
+	registerRoomMetrics(ycsRooms)
 
 	// Setup routes
 	r := mux.NewRouter()
 
-	// WebSocket endpoint
+	// Monitoring endpoints
+	r.Handle("/metrics", metricsRegistry.Handler()).Methods("GET")
+	r.HandleFunc("/healthz", handleHealthz).Methods("GET")
+	r.HandleFunc("/readyz", handleReadyz).Methods("GET")
+
+	// WebSocket endpoints
 	r.HandleFunc("/ws", handleWebSocket)
+	r.HandleFunc("/ws/{room}", handleWebSocket)
+
+	// SignalR hub endpoints for clients of the original YcsHub
+	r.HandleFunc("/hubs/ycs/negotiate", handleSignalRNegotiate).Methods("POST", "OPTIONS")
+	r.HandleFunc("/hubs/ycs/{room}/negotiate", handleSignalRNegotiate).Methods("POST", "OPTIONS")
+	r.HandleFunc("/hubs/ycs", handleSignalRHub).Methods("GET")
+	r.HandleFunc("/hubs/ycs/{room}", handleSignalRHub).Methods("GET")
+
+	// Version history endpoints
+	r.HandleFunc("/rooms/{room}/checkpoints", handleListCheckpoints).Methods("GET")
+	r.HandleFunc("/rooms/{room}/checkpoints", handleCreateCheckpoint).Methods("POST")
+	r.HandleFunc("/rooms/{room}/checkpoints/diff", handleDiffCheckpoints).Methods("GET")
+	r.HandleFunc("/rooms/{room}/checkpoints/{id}/restore", handleRestoreCheckpoint).Methods("POST")
 
 	// Serve React app static files if they exist
-	buildPath := "./ClientApp/build"
+	buildPath := cfg.StaticDir
 	if _, err := http.Dir(buildPath).Open("index.html"); err == nil {
 		// Serve static files from build directory
 		r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir(buildPath+"/static/"))))
@@ -450,17 +1200,61 @@ func main() {
 	}
 
 	// Fallback route for SPA (must be last)
-	r.PathPrefix("/").HandlerFunc(handleSPA).Methods("GET")
+	r.PathPrefix("/").HandlerFunc(newSPAHandler(buildPath)).Methods("GET")
 
 	// Additional static file serving
 	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(http.Dir("./static/"))))
 
 	// Start server
-	port := ":8080"
-	log.Printf("Server starting on port %s", port)
-	log.Printf("Open http://localhost%s in your browser to test", port)
+	scheme := "http"
+	if cfg.TLS.Enabled() {
+		scheme = "https"
+	}
+	logInfof("Server starting on %s", cfg.Addr)
+	logInfof("Open %s://localhost:%s in your browser to test", scheme, listenPort(cfg.Addr))
 
-	if err := http.ListenAndServe(port, r); err != nil {
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
 		log.Fatal("Server failed to start:", err)
 	}
+
+	server := &http.Server{Handler: r}
+	serveErr := make(chan error, 1)
+	go func() {
+		if cfg.TLS.Enabled() {
+			serveErr <- server.ServeTLS(listener, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			serveErr <- server.Serve(listener)
+		}
+	}()
+	serverReady.Store(true)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serveErr:
+		log.Fatal("Server failed:", err)
+	case sig := <-stop:
+		logInfof("Received %s, shutting down", sig)
+	}
+
+	shutdown(server, time.Duration(cfg.ShutdownTimeout))
+}
+
+// shutdown stops accepting connections, drains and closes the WebSocket clients
+// and persists all loaded documents within the timeout
+func shutdown(server *http.Server, timeout time.Duration) {
+	serverReady.Store(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Closes the listener; hijacked WebSocket connections are not tracked by the server
+	if err := server.Shutdown(ctx); err != nil {
+		logErrorf("Error shutting down HTTP server: %v", err)
+	}
+
+	ycsRooms.Shutdown(ctx, "server restarting")
+	logInfof("Shutdown complete")
 }
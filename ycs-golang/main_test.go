@@ -0,0 +1,867 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ycs/client"
+	"ycs/config"
+	"ycs/contracts"
+	"ycs/core"
+	"ycs/history"
+	"ycs/lib0"
+	"ycs/persistence"
+	"ycs/protocols"
+	"ycs/signalr"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// text returns the text of a room's document, holding its lock
+func text(doc *core.YDoc) (s string) {
+	doc.View(func() {
+		s = doc.GetText("monaco").ToString()
+	})
+	return s
+}
+
+// TestConcurrentClients edits one room from clients of both protocols at once while
+// the room is read and saved, for running with -race
+func TestConcurrentClients(t *testing.T) {
+	ycsRooms = NewYcsRooms(nil, "test", nil, config.Limits{}, time.Minute)
+	defer ycsRooms.Close()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/ws/{room}", handleWebSocket)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/race"
+	protocols := []client.Protocol{client.ProtocolJSON, client.ProtocolBinary, client.ProtocolJSON, client.ProtocolBinary}
+	const edits = 50
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	clients := make([]*client.Client, len(protocols))
+	for i, protocol := range protocols {
+		clients[i] = client.NewClient(url, core.NewYDoc(contracts.YDocOptions{}), nil, client.Options{Protocol: protocol})
+		clients[i].Start()
+		defer clients[i].Close()
+
+		if err := clients[i].WaitSynced(ctx); err != nil {
+			t.Fatalf("client %d: %v", i, err)
+		}
+	}
+
+	manager, err := ycsRooms.Get("race")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			doc := c.GetDoc()
+			for j := 0; j < edits; j++ {
+				doc.Transact(func(tr contracts.ITransaction) {
+					text := doc.GetText("monaco")
+					text.Insert(text.GetLength(), fmt.Sprint(i))
+				}, nil)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < edits; j++ {
+			manager.GetPendingCounts()
+			_ = text(manager.doc)
+			manager.doc.View(func() {
+				_ = manager.doc.EncodeStateVectorV2()
+			})
+		}
+	}()
+	wg.Wait()
+
+	want := len("Hello, world!") + len(clients)*edits
+	for {
+		converged := len(text(manager.doc)) == want
+		for _, c := range clients {
+			converged = converged && text(c.GetDoc()) == text(manager.doc)
+		}
+		if converged {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			for i, c := range clients {
+				t.Logf("client %d: %q", i, text(c.GetDoc()))
+			}
+			t.Fatalf("clients did not converge: server has %q", text(manager.doc))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// failingStore is a persistence.Store whose loads fail
+type failingStore struct{}
+
+func (failingStore) Load(room string) ([]byte, error) {
+	return nil, fmt.Errorf("disk on fire")
+}
+
+func (failingStore) Save(room string, update []byte) error {
+	return nil
+}
+
+// TestRoomLoadErrorReleasesManager checks that a room that fails to load stops its
+// background work and is not kept
+func TestRoomLoadErrorReleasesManager(t *testing.T) {
+	rooms := NewYcsRooms(nil, "test", failingStore{}, config.Limits{}, time.Minute)
+	defer rooms.Close()
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		if _, err := rooms.Get("broken"); err == nil {
+			t.Fatal("no error for a room that cannot be loaded")
+		}
+	}
+
+	// Stopped goroutines take a moment to exit
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("%d goroutines leaked", n-before)
+	}
+	if managers := rooms.GetManagers(); len(managers) != 0 {
+		t.Fatalf("%d rooms are kept", len(managers))
+	}
+}
+
+// TestMultiplexClientExchangesV1Updates syncs a document the way a Hocuspocus
+// provider does: authenticate, sync with V1 updates, then send an update
+func TestMultiplexClientExchangesV1Updates(t *testing.T) {
+	ycsRooms = NewYcsRooms(nil, "test", nil, config.Limits{}, time.Minute)
+	defer ycsRooms.Close()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/ws", handleWebSocket)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?protocol=multiplex", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	write := func(frame []byte) {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// read returns the next frame of the given type
+	read := func(messageType uint32) protocols.MultiplexMessage {
+		t.Helper()
+		for {
+			_, frame, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			message, err := protocols.DecodeMultiplexMessage(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message.Document != "doc" {
+				t.Fatalf("frame for document %q", message.Document)
+			}
+			if message.Type == messageType {
+				return message
+			}
+		}
+	}
+
+	auth := &bytes.Buffer{}
+	lib0.WriteVarString(auth, "doc")
+	lib0.WriteVarUint(auth, protocols.MultiplexAuth)
+	lib0.WriteVarUint(auth, protocols.AuthToken)
+	lib0.WriteVarString(auth, "any token")
+	write(auth.Bytes())
+	if message := read(protocols.MultiplexAuth); message.AuthType != protocols.AuthAuthenticated {
+		t.Fatalf("got %+v", message)
+	}
+
+	doc := core.NewYDoc(contracts.YDocOptions{})
+	step1, err := protocols.EncodeMultiplexSyncMessage("doc", protocols.MessageYjsSyncStep1, doc.EncodeStateVectorV2())
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(step1)
+	message := read(protocols.MultiplexSync)
+	if message.SyncType != protocols.MessageYjsSyncStep2 {
+		t.Fatalf("got %+v", message)
+	}
+	// The V2 decoder would reject a V1 update
+	if err := core.NewYDoc(contracts.YDocOptions{}).ApplyUpdateV2(message.Payload, nil); err == nil {
+		t.Fatal("server sent a V2 update")
+	}
+	update, err := core.ConvertUpdateV1ToV2(message.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.ApplyUpdateV2(update, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.GetText("monaco").ToString(); got != "Hello, world!" {
+		t.Fatalf("got %q", got)
+	}
+
+	// The server asks for the client's state in turn; the room applies updates once
+	// it is answered
+	message = read(protocols.MultiplexSync)
+	if message.SyncType != protocols.MessageYjsSyncStep1 {
+		t.Fatalf("got %+v", message)
+	}
+	update, err = doc.EncodeStateAsUpdateV2(message.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step2, err := protocols.EncodeMultiplexSyncMessage("doc", protocols.MessageYjsSyncStep2, update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(step2)
+
+	before := doc.EncodeStateVectorV2()
+	doc.GetText("monaco").Insert(0, ">")
+	if update, err = doc.EncodeStateAsUpdateV2(before); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := protocols.EncodeMultiplexSyncMessage("doc", protocols.MessageYjsUpdate, update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(frame)
+	read(protocols.MultiplexSyncStatus)
+
+	manager, err := ycsRooms.Get("doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := text(manager.doc); got != ">Hello, world!" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+// TestReadingHistoryDoesNotCreateRooms checks that the history of a room that is
+// neither loaded nor has checkpoints is not found, and that reading it creates no room
+func TestReadingHistoryDoesNotCreateRooms(t *testing.T) {
+	store, err := history.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ycsRooms = NewYcsRooms(nil, "test", nil, config.Limits{MaxRooms: 1}, time.Minute)
+	ycsRooms.SetHistory(store, 0)
+	defer func() { ycsRooms.Close() }()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/rooms/{room}/checkpoints", handleListCheckpoints).Methods("GET")
+	r.HandleFunc("/rooms/{room}/checkpoints", handleCreateCheckpoint).Methods("POST")
+	r.HandleFunc("/rooms/{room}/checkpoints/diff", handleDiffCheckpoints).Methods("GET")
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	request := func(method, path string, want int) {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s: got status %d, want %d", method, path, resp.StatusCode, want)
+		}
+	}
+
+	request("GET", "/rooms/unknown/checkpoints", http.StatusNotFound)
+	request("GET", "/rooms/unknown/checkpoints/diff?from=a", http.StatusNotFound)
+	if managers := ycsRooms.GetManagers(); len(managers) != 0 {
+		t.Fatalf("reading history created %d rooms", len(managers))
+	}
+
+	request("POST", "/rooms/known/checkpoints", http.StatusCreated)
+	request("GET", "/rooms/known/checkpoints", http.StatusOK)
+	// The limit of one room is taken, so only rooms that do not count against it are read
+	request("GET", "/rooms/other/checkpoints", http.StatusNotFound)
+
+	// Stored checkpoints are listed without loading the room
+	ycsRooms.Close()
+	ycsRooms = NewYcsRooms(nil, "test", nil, config.Limits{MaxRooms: 1}, time.Minute)
+	ycsRooms.SetHistory(store, 0)
+	request("GET", "/rooms/known/checkpoints", http.StatusOK)
+	if _, exists := ycsRooms.Lookup("known"); exists {
+		t.Fatal("listing checkpoints loaded the room")
+	}
+}
+
+// discardTransport is a clientTransport that drops what is written to it
+type discardTransport struct{}
+
+func (discardTransport) WriteMessage(command YjsCommandType, data interface{}) error { return nil }
+func (discardTransport) WriteClose(code int, reason string) error                    { return nil }
+func (discardTransport) Close() error                                                { return nil }
+
+// TestMalformedGetMissingIsRejected checks that a malformed state vector is reported
+// as an error, also when the message waited behind a gap and is processed by the sweep
+func TestMalformedGetMissingIsRejected(t *testing.T) {
+	manager := NewYcsManager("malformed", time.Minute)
+	defer manager.Close()
+	client, _, _ := manager.HandleClientConnected("", -1, discardTransport{})
+
+	// The state vector announces a client without its clock
+	malformed := &MessageToProcess{Command: GetMissing, Data: "AQ=="}
+	if err := manager.ProcessMessage(client.GetSessionID(), 0, malformed); err == nil {
+		t.Fatal("no error for a malformed state vector")
+	}
+
+	// Message 1 never arrives, so message 2 waits until the sweep gives up on it
+	if err := manager.ProcessMessage(client.GetSessionID(), 2, &MessageToProcess{Command: GetMissing, Data: "AQ=="}); err != nil {
+		t.Fatal(err)
+	}
+	client.mutex.Lock()
+	client.messages[2].ReceivedAt = time.Now().Add(-2 * pendingMessageTimeout)
+	client.mutex.Unlock()
+	manager.sweep()
+
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	if client.clientClock != 2 || len(client.messages) != 0 {
+		t.Fatalf("message not consumed: clock %d, %d pending", client.clientClock, len(client.messages))
+	}
+}
+
+// TestPanickingMessageIsConsumed checks that a message whose handler panics is
+// reported as an error and does not hold back the messages after it
+func TestPanickingMessageIsConsumed(t *testing.T) {
+	manager := NewYcsManager("panic", time.Minute)
+	defer manager.Close()
+	client, _, _ := manager.HandleClientConnected("", -1, discardTransport{})
+
+	// Without a document, the handler panics
+	manager.doc = nil
+	if err := manager.ProcessMessage(client.GetSessionID(), 0, &MessageToProcess{Command: GetMissing, Data: ""}); err == nil {
+		t.Fatal("no error for a panicking handler")
+	}
+	if client.clientClock != 0 {
+		t.Fatalf("message not consumed: clock %d", client.clientClock)
+	}
+}
+
+// TestSignalRMessageSpanningFramesIsLimited checks that a message split across
+// frames is held to the message size limit like a message in a single frame
+func TestSignalRMessageSpanningFramesIsLimited(t *testing.T) {
+	ycsRooms = NewYcsRooms(nil, "test", nil, config.Limits{MaxMessageBytes: 1024}, time.Minute)
+	defer ycsRooms.Close()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/hubs/ycs/{room}", handleSignalRHub).Methods("GET")
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/hubs/ycs/big", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("{\"protocol\":\"json\",\"version\":1}\x1e")); err != nil {
+		t.Fatal(err)
+	}
+	// Every frame is below the limit, but none ends the message
+	frame := bytes.Repeat([]byte("x"), 512)
+	for i := 0; i < 3; i++ {
+		if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+			break
+		}
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("connection closed without a close message: %v", err)
+		}
+		records, _ := signalr.SplitRecords(data)
+		for _, record := range records {
+			message, err := signalr.ParseMessage(record)
+			if err != nil || message.Type != signalr.CloseMessage {
+				continue
+			}
+			if message.Error == "" {
+				t.Fatal("close message without an error")
+			}
+			return
+		}
+	}
+}
+
+// TestClientIDConflictIsCounted checks that the server notices two clients
+// announcing changes under the same client ID
+func TestClientIDConflictIsCounted(t *testing.T) {
+	manager := NewYcsManager("conflict", time.Minute)
+	defer manager.Close()
+
+	announce := func(client *ClientContext, clock int64, text string) {
+		t.Helper()
+		doc := core.NewYDoc(contracts.YDocOptions{})
+		doc.SetClientID(7)
+		doc.GetText("t").Insert(0, text)
+		stateVector := base64.StdEncoding.EncodeToString(doc.EncodeStateVectorV2())
+		if err := manager.ProcessMessage(client.GetSessionID(), clock, &MessageToProcess{Command: GetMissing, Data: stateVector}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conflicts := clientIDConflicts.WithLabelValues()
+	before := conflicts.Get()
+
+	a, _, _ := manager.HandleClientConnected("", -1, discardTransport{})
+	b, _, _ := manager.HandleClientConnected("", -1, discardTransport{})
+	announce(a, 0, "hello")
+	announce(a, 1, "hello!")
+	if got := conflicts.Get() - before; got != 0 {
+		t.Fatalf("%v conflicts for one client", got)
+	}
+
+	announce(b, 0, "hi")
+	if got := conflicts.Get() - before; got != 1 {
+		t.Fatalf("%v conflicts for two clients", got)
+	}
+	manager.mutex.RLock()
+	owner := manager.clientIDClaims[7]
+	manager.mutex.RUnlock()
+	if owner != a.GetSessionID() {
+		t.Fatalf("client ID claimed by %s", owner)
+	}
+}
+
+// TestSubdocSyncsOverRoomConnection syncs a subdocument of the room document with
+// messages tagged with its GUID on the connection of the room
+func TestSubdocSyncsOverRoomConnection(t *testing.T) {
+	ycsRooms = NewYcsRooms(nil, "test", nil, config.Limits{}, time.Minute)
+	defer ycsRooms.Close()
+
+	manager, err := ycsRooms.Get("subdocs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.doc.Transact(func(tr contracts.ITransaction) {
+		manager.doc.GetMap("files").Set("readme", core.NewYDoc(contracts.YDocOptions{Guid: "readme"}))
+	}, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/ws/{room}", handleWebSocket)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/subdocs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	clock := int64(-1)
+	send := func(command YjsCommandType, guid string, data []byte, inReplyTo string) {
+		t.Helper()
+		clock++
+		message := map[string]interface{}{"clock": clock, "data": base64.StdEncoding.EncodeToString(data), "guid": guid}
+		if inReplyTo != "" {
+			message["inReplyTo"] = inReplyTo
+		}
+		if err := conn.WriteJSON(map[string]interface{}{"type": command, "data": message}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// read returns the payload of the next message of the command
+	read := func(command YjsCommandType, guid string) []byte {
+		t.Helper()
+		for {
+			var message struct {
+				Type YjsCommandType `json:"type"`
+				Data YjsMessage     `json:"data"`
+			}
+			if err := conn.ReadJSON(&message); err != nil {
+				t.Fatal(err)
+			}
+			if message.Type == command && message.Data.Guid == guid {
+				data, err := base64.StdEncoding.DecodeString(message.Data.Data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return data
+			}
+		}
+	}
+
+	// Syncing the room document tells the client about the subdocument
+	doc := core.NewYDoc(contracts.YDocOptions{})
+	send(GetMissing, "", doc.EncodeStateVectorV2(), "")
+	if err := doc.ApplyUpdateV2(read(Update, ""), "server", false); err != nil {
+		t.Fatal(err)
+	}
+	roomUpdate, err := doc.EncodeStateAsUpdateV2(read(GetMissing, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(Update, "", roomUpdate, string(GetMissing))
+	subdoc, ok := doc.GetSubdoc("readme").(*core.YDoc)
+	if !ok {
+		t.Fatalf("subdocuments %v", doc.GetSubdocGuids())
+	}
+
+	// The client syncs the subdocument and sends its content
+	subdoc.Load()
+	subdoc.GetText("monaco").Insert(0, "hello")
+	send(GetMissing, "readme", subdoc.EncodeStateVectorV2(), "")
+	if err := subdoc.ApplyUpdateV2(read(Update, "readme"), "server", false); err != nil {
+		t.Fatal(err)
+	}
+	stateVector := read(GetMissing, "readme")
+	update, err := subdoc.EncodeStateAsUpdateV2(stateVector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(Update, "readme", update, string(GetMissing))
+
+	var roomSubdoc *roomSubdoc
+	for deadline := time.Now().Add(5 * time.Second); roomSubdoc == nil || text(roomSubdoc.doc) != "hello"; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the subdocument was not synced")
+		}
+		manager.mutex.RLock()
+		roomSubdoc = manager.subdocs["readme"]
+		manager.mutex.RUnlock()
+	}
+	if strings.Contains(text(manager.doc), "hello") {
+		t.Fatal("the subdocument update was applied to the room document")
+	}
+
+	// Changes on the server reach the client tagged with the GUID
+	roomSubdoc.doc.Transact(func(tr contracts.ITransaction) { roomSubdoc.doc.GetText("monaco").Insert(5, "!") }, nil)
+	for subdoc.GetText("monaco").ToString() != "hello!" {
+		// The server also echoes the update of the client
+		if err := subdoc.ApplyUpdateV2(read(Update, "readme"), "server", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Deleting the subdocument from the room document unloads it
+	manager.doc.Transact(func(tr contracts.ITransaction) { manager.doc.GetMap("files").Delete("readme") }, nil)
+	manager.mutex.RLock()
+	_, loaded := manager.subdocs["readme"]
+	manager.mutex.RUnlock()
+	if loaded {
+		t.Fatal("the deleted subdocument is still loaded")
+	}
+}
+
+var (
+	// metricsRooms are the rooms whose gauges are registered, which can happen only once
+	metricsRooms     *YcsRooms
+	metricsRoomsOnce sync.Once
+)
+
+// scrapeMetrics returns the samples served at /metrics by name and labels
+func scrapeMetrics(t *testing.T, url string) map[string]float64 {
+	t.Helper()
+	resp, err := http.Get(url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples := make(map[string]float64)
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("invalid sample %q", line)
+		}
+		samples[line[:i]] = value
+	}
+	return samples
+}
+
+// TestMetricsAfterSync scrapes the metrics of a room that a client synced and edited
+func TestMetricsAfterSync(t *testing.T) {
+	metricsRoomsOnce.Do(func() {
+		metricsRooms = NewYcsRooms(nil, "test", nil, config.Limits{}, time.Minute)
+		registerRoomMetrics(metricsRooms)
+	})
+	ycsRooms = metricsRooms
+
+	r := mux.NewRouter()
+	r.HandleFunc("/ws/{room}", handleWebSocket)
+	r.Handle("/metrics", metricsRegistry.Handler()).Methods("GET")
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	before := scrapeMetrics(t, server.URL)
+
+	c := client.NewClient("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/metrics", core.NewYDoc(contracts.YDocOptions{}), nil, client.Options{})
+	c.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.WaitSynced(ctx); err != nil {
+		t.Fatal(err)
+	}
+	doc := c.GetDoc()
+	doc.Transact(func(tr contracts.ITransaction) { doc.GetText("monaco").Insert(0, "metrics ") }, nil)
+	want := text(doc)
+
+	manager, err := ycsRooms.Get("metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for text(manager.doc) != want {
+		if ctx.Err() != nil {
+			t.Fatal("the edit did not reach the server")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	after := scrapeMetrics(t, server.URL)
+	increased := func(name string) {
+		t.Helper()
+		if after[name] <= before[name] {
+			t.Errorf("%s went from %v to %v", name, before[name], after[name])
+		}
+	}
+	increased(`ycs_update_bytes_total{direction="in"}`)
+	increased(`ycs_update_bytes_total{direction="out"}`)
+	increased(`ycs_update_apply_duration_seconds_count`)
+
+	for name, want := range map[string]float64{
+		`ycs_connected_clients{room="metrics"}`:   1,
+		`ycs_pending_structs{room="metrics"}`:     0,
+		`ycs_pending_delete_sets{room="metrics"}`: 0,
+	} {
+		if got, exists := after[name]; !exists || got != want {
+			t.Errorf("%s is %v, want %v", name, got, want)
+		}
+	}
+	if after["ycs_loaded_documents"] < 1 {
+		t.Errorf("ycs_loaded_documents is %v", after["ycs_loaded_documents"])
+	}
+
+	c.Close()
+	for {
+		if scrapeMetrics(t, server.URL)[`ycs_connected_clients{room="metrics"}`] == 0 {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatal("the client is still counted after disconnecting")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// dialRoom connects a raw JSON client to the room and reads its session message
+func dialRoom(t *testing.T, server *httptest.Server, room string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/"+room, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var session map[string]interface{}
+	if err := conn.ReadJSON(&session); err != nil || session["type"] != string(Session) {
+		t.Fatalf("got %v, %v instead of the session", session, err)
+	}
+	return conn
+}
+
+// storedText returns the text of the room saved in store
+func storedText(t *testing.T, store persistence.Store, room string) string {
+	t.Helper()
+	update, err := store.Load(room)
+	if err != nil || update == nil {
+		t.Fatalf("room %s was not saved: %v", room, err)
+	}
+	doc := core.NewYDoc(contracts.YDocOptions{})
+	doc.Lock()
+	err = doc.ApplyUpdateV2(update, nil, false)
+	doc.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text(doc)
+}
+
+// TestShutdownDrainsClientsAndPersists checks that shutting down writes the queued
+// messages and a close frame to clients, waits for them to leave and saves the rooms
+func TestShutdownDrainsClientsAndPersists(t *testing.T) {
+	store, err := persistence.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ycsRooms = NewYcsRooms(nil, "test", store, config.Limits{}, time.Minute)
+	defer ycsRooms.Close()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/ws/{room}", handleWebSocket)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	conn := dialRoom(t, server, "drain")
+	defer conn.Close()
+
+	// Updates are broadcast to the client once it asked for the missing ones
+	getMissing := map[string]interface{}{"type": GetMissing, "data": map[string]interface{}{"clock": 0, "data": "AA=="}}
+	if err := conn.WriteJSON(getMissing); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		var message map[string]interface{}
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		}
+		if message["type"] == string(GetMissing) {
+			break
+		}
+	}
+
+	// The client reads until the close frame, which it answers
+	received := make(chan []string, 1)
+	closed := make(chan error, 1)
+	go func() {
+		var types []string
+		for {
+			var message map[string]interface{}
+			if err := conn.ReadJSON(&message); err != nil {
+				received <- types
+				closed <- err
+				return
+			}
+			types = append(types, fmt.Sprint(message["type"]))
+		}
+	}()
+
+	manager, err := ycsRooms.Get("drain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.doc.Transact(func(tr contracts.ITransaction) {
+		manager.doc.GetText("monaco").Insert(0, "saved ")
+	}, nil)
+
+	start := time.Now()
+	shutdown(server.Config, 5*time.Second)
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("shutdown took %v although the client left", elapsed)
+	}
+
+	// The edit was queued before the shutdown, so it is written before the close frame
+	if types := <-received; len(types) == 0 || types[len(types)-1] != string(Update) {
+		t.Errorf("client received %v before the close frame", types)
+	}
+	var closeErr *websocket.CloseError
+	if err := <-closed; !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseServiceRestart || closeErr.Text != "server restarting" {
+		t.Errorf("connection ended with %v", err)
+	}
+	if n := manager.GetClientCount(); n != 0 {
+		t.Errorf("%d clients are still connected", n)
+	}
+
+	if got := storedText(t, store, "drain"); got != "saved Hello, world!" {
+		t.Errorf("saved %q", got)
+	}
+	if _, err := ycsRooms.Get("new"); !errors.Is(err, errShuttingDown) {
+		t.Errorf("room created during shutdown: %v", err)
+	}
+}
+
+// TestShutdownDropsClientsThatStay checks that clients ignoring the close frame are
+// dropped at the deadline, after the rooms are saved
+func TestShutdownDropsClientsThatStay(t *testing.T) {
+	store, err := persistence.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ycsRooms = NewYcsRooms(nil, "test", store, config.Limits{}, time.Minute)
+	defer ycsRooms.Close()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/ws/{room}", handleWebSocket)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// The client does not read, so it never answers the close frame
+	conn := dialRoom(t, server, "stay")
+	defer conn.Close()
+	manager, err := ycsRooms.Get("stay")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	ycsRooms.Shutdown(ctx, "server restarting")
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("shutdown returned after %v without waiting for the client", elapsed)
+	}
+
+	if got := storedText(t, store, "stay"); got != "Hello, world!" {
+		t.Errorf("saved %q", got)
+	}
+
+	// The connection is dropped, so the client reads the close frame and then nothing
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseServiceRestart {
+				t.Fatalf("connection ended with %v", err)
+			}
+			break
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); manager.GetClientCount() > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the dropped client is still connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,342 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets suited for latencies in seconds
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// Sample represents one value of a metric computed at scrape time
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// collector renders one metric family in the Prometheus text format
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics and renders them in the Prometheus text exposition format
+type Registry struct {
+	collectors []collector
+	names      map[string]struct{}
+	mutex      sync.Mutex
+}
+
+// NewRegistry creates a new Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		names: make(map[string]struct{}),
+	}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.names[name]; exists {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	r.names[name] = struct{}{}
+	r.collectors = append(r.collectors, c)
+}
+
+// NewCounterVec creates and registers a counter partitioned by the given labels
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: name, help: help, kind: "counter", labels: labels},
+		series: make(map[string]*Counter),
+	}
+	r.register(name, c)
+	return c
+}
+
+// NewGaugeFunc creates and registers a gauge whose samples are computed by collect on every scrape
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect func() []Sample) {
+	r.register(name, &gaugeFunc{
+		desc:    desc{name: name, help: help, kind: "gauge", labels: labels},
+		collect: collect,
+	})
+}
+
+// NewHistogramVec creates and registers a histogram partitioned by the given labels.
+// Buckets are upper bounds in increasing order; nil means DefaultBuckets.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*Histogram),
+	}
+	r.register(name, h)
+	return h
+}
+
+// WriteTo writes all registered metrics to w
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mutex.Lock()
+	collectors := make([]collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mutex.Unlock()
+
+	counter := &countingWriter{w: w}
+	bw := bufio.NewWriter(counter)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	err := bw.Flush()
+	return counter.n, err
+}
+
+// Handler returns an HTTP handler serving the metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// desc describes a metric family
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, help, d.name, d.kind)
+}
+
+// labelPairs renders {a="x",b="y"}, appending the extra pair if not empty
+func (d *desc) labelPairs(values []string, extraName, extraValue string) string {
+	if len(d.labels) == 0 && extraName == "" {
+		return ""
+	}
+
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, 0, len(d.labels)+1)
+	for i, label := range d.labels {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, label, escape.Replace(value)))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, escape.Replace(extraValue)))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (d *desc) checkLabelValues(values []string) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+}
+
+// seriesKey joins label values into a map key
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// sortedKeys returns the keys of a series map in a stable order
+func sortedKeys[T any](series map[string]T) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	labelValues []string
+	value       float64
+	mutex       sync.Mutex
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by v, which must not be negative
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("counter cannot decrease")
+	}
+	c.mutex.Lock()
+	c.value += v
+	c.mutex.Unlock()
+}
+
+// Get returns the current value
+func (c *Counter) Get() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.value
+}
+
+// CounterVec is a set of counters partitioned by label values
+type CounterVec struct {
+	desc
+	series map[string]*Counter
+	mutex  sync.Mutex
+}
+
+// WithLabelValues returns the counter for the label values, creating it if necessary
+func (cv *CounterVec) WithLabelValues(values ...string) *Counter {
+	cv.checkLabelValues(values)
+
+	cv.mutex.Lock()
+	defer cv.mutex.Unlock()
+
+	key := seriesKey(values)
+	counter, exists := cv.series[key]
+	if !exists {
+		counter = &Counter{labelValues: append([]string(nil), values...)}
+		cv.series[key] = counter
+	}
+	return counter
+}
+
+func (cv *CounterVec) write(w *bufio.Writer) {
+	cv.writeHeader(w)
+
+	cv.mutex.Lock()
+	defer cv.mutex.Unlock()
+
+	for _, key := range sortedKeys(cv.series) {
+		counter := cv.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", cv.name, cv.labelPairs(counter.labelValues, "", ""), formatFloat(counter.Get()))
+	}
+}
+
+// gaugeFunc is a gauge computed on every scrape
+type gaugeFunc struct {
+	desc
+	collect func() []Sample
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w)
+
+	samples := g.collect()
+	sort.Slice(samples, func(i, j int) bool {
+		return seriesKey(samples[i].LabelValues) < seriesKey(samples[j].LabelValues)
+	})
+	for _, sample := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(sample.LabelValues, "", ""), formatFloat(sample.Value))
+	}
+}
+
+// Histogram counts observations in configurable buckets
+type Histogram struct {
+	labelValues []string
+	buckets     []float64
+	counts      []uint64
+	count       uint64
+	sum         float64
+	mutex       sync.Mutex
+}
+
+// Observe adds an observation
+func (h *Histogram) Observe(v float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// HistogramVec is a set of histograms partitioned by label values
+type HistogramVec struct {
+	desc
+	buckets []float64
+	series  map[string]*Histogram
+	mutex   sync.Mutex
+}
+
+// WithLabelValues returns the histogram for the label values, creating it if necessary
+func (hv *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	hv.checkLabelValues(values)
+
+	hv.mutex.Lock()
+	defer hv.mutex.Unlock()
+
+	key := seriesKey(values)
+	histogram, exists := hv.series[key]
+	if !exists {
+		histogram = &Histogram{
+			labelValues: append([]string(nil), values...),
+			buckets:     hv.buckets,
+			counts:      make([]uint64, len(hv.buckets)),
+		}
+		hv.series[key] = histogram
+	}
+	return histogram
+}
+
+func (hv *HistogramVec) write(w *bufio.Writer) {
+	hv.writeHeader(w)
+
+	hv.mutex.Lock()
+	defer hv.mutex.Unlock()
+
+	for _, key := range sortedKeys(hv.series) {
+		h := hv.series[key]
+		h.mutex.Lock()
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, hv.labelPairs(h.labelValues, "le", formatFloat(bound)), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, hv.labelPairs(h.labelValues, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", hv.name, hv.labelPairs(h.labelValues, "", ""), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", hv.name, hv.labelPairs(h.labelValues, "", ""), h.count)
+		h.mutex.Unlock()
+	}
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
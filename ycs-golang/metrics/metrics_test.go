@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns the metrics served by the registry's handler
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	r.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Fatalf("content type %q", contentType)
+	}
+	return recorder.Body.String()
+}
+
+func TestRegistryRendersTextFormat(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounterVec("requests_total", "Requests.\nBy method.", "method")
+	counter.WithLabelValues("GET").Inc()
+	counter.WithLabelValues("GET").Add(2)
+	counter.WithLabelValues(`say "hi"`).Inc()
+
+	r.NewGaugeFunc("rooms", "Rooms.", []string{"room"}, func() []Sample {
+		return []Sample{{LabelValues: []string{"b"}, Value: 2}, {LabelValues: []string{"a"}, Value: 0.5}}
+	})
+
+	histogram := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1})
+	histogram.WithLabelValues().Observe(0.05)
+	histogram.WithLabelValues().Observe(0.5)
+	histogram.WithLabelValues().Observe(5)
+
+	want := `# HELP requests_total Requests.\nBy method.
+# TYPE requests_total counter
+requests_total{method="GET"} 3
+requests_total{method="say \"hi\""} 1
+# HELP rooms Rooms.
+# TYPE rooms gauge
+rooms{room="a"} 0.5
+rooms{room="b"} 2
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+`
+	if got := scrape(t, r); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRegistryRejectsMisuse(t *testing.T) {
+	expectPanic := func(name string, f func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s: no panic", name)
+			}
+		}()
+		f()
+	}
+
+	r := NewRegistry()
+	counter := r.NewCounterVec("total", "Total.", "label")
+	expectPanic("duplicate name", func() { r.NewCounterVec("total", "Total.") })
+	expectPanic("missing label value", func() { counter.WithLabelValues() })
+	expectPanic("negative increment", func() { counter.WithLabelValues("x").Add(-1) })
+}
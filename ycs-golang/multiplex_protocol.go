@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"ycs/core"
+	"ycs/protocols"
+
+	"github.com/gorilla/websocket"
+)
+
+// protocolMultiplex selects the multiplexed protocol on /ws with ?protocol=multiplex
+const protocolMultiplex = "multiplex"
+
+// multiplexScope is the access granted to clients that authenticate for a document
+const multiplexScope = "read-write"
+
+// multiplexConnection syncs several rooms over one WebSocket connection in the framing
+// of Hocuspocus: every frame starts with the name of a document, which is the room.
+// A client subscribes to a document with its first message for it, usually an auth
+// message, and unsubscribes with a close message. Each document is a session of its
+// room that speaks the binary protocol, with V1 updates instead of V2 ones.
+// Like the other endpoints it does not authenticate clients: every token is accepted.
+type multiplexConnection struct {
+	conn *websocket.Conn
+
+	// writeMutex serializes the writes of the sessions' writers
+	writeMutex sync.Mutex
+
+	documents map[string]*multiplexDocument
+	// serverClosed holds the documents the server closed; once it closed all of
+	// them, the connection is closed too
+	serverClosed map[string]struct{}
+	mutex        sync.Mutex
+}
+
+// multiplexDocument is the session of one document of a multiplexed connection
+type multiplexDocument struct {
+	manager    *YcsManager
+	client     *ClientContext
+	connection *clientConnection
+	// clock numbers the messages of the document as the JSON clients do
+	clock int64
+}
+
+// multiplexTransport frames the messages of one document of a multiplexed connection
+type multiplexTransport struct {
+	mc       *multiplexConnection
+	document string
+}
+
+func (t *multiplexTransport) WriteMessage(command YjsCommandType, data interface{}) error {
+	if message, ok := data.(YjsMessage); ok {
+		if message.Guid != "" {
+			return fmt.Errorf("subdocuments are not synced over multiplexed connections")
+		}
+		// Hocuspocus clients read V1 updates; state vectors need no conversion
+		if command == Update {
+			update, err := base64.StdEncoding.DecodeString(message.Data)
+			if err != nil {
+				return err
+			}
+			if update, err = core.ConvertUpdateV2ToV1(update); err != nil {
+				return err
+			}
+			message.Data = base64.StdEncoding.EncodeToString(update)
+			data = message
+		}
+	}
+
+	frame, err := encodeBinaryMessage(command, data)
+	if err != nil || frame == nil {
+		return err
+	}
+	return t.mc.write(protocols.EncodeMultiplexMessage(t.document, frame))
+}
+
+func (t *multiplexTransport) WriteClose(code int, reason string) error {
+	if err := t.mc.write(protocols.EncodeCloseMessage(t.document, reason)); err != nil {
+		return err
+	}
+	return t.mc.closedByServer(t.document, code, reason)
+}
+
+func (t *multiplexTransport) Close() error {
+	return t.mc.conn.Close()
+}
+
+// write writes a frame to the connection
+func (mc *multiplexConnection) write(frame []byte) error {
+	mc.writeMutex.Lock()
+	defer mc.writeMutex.Unlock()
+
+	mc.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return mc.conn.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// closedByServer records that the server closed the document and closes the
+// connection once every document is closed
+func (mc *multiplexConnection) closedByServer(document string, code int, reason string) error {
+	mc.mutex.Lock()
+	mc.serverClosed[document] = struct{}{}
+	closeAll := len(mc.serverClosed) >= len(mc.documents)
+	mc.mutex.Unlock()
+
+	if !closeAll {
+		return nil
+	}
+
+	mc.writeMutex.Lock()
+	defer mc.writeMutex.Unlock()
+	closeMessage := websocket.FormatCloseMessage(code, reason)
+	return mc.conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(writeTimeout))
+}
+
+// getDocument returns the session of the document, or nil if the client is not
+// subscribed to it. A document the server closed is unsubscribed first.
+func (mc *multiplexConnection) getDocument(name string) *multiplexDocument {
+	mc.mutex.Lock()
+	document := mc.documents[name]
+	_, closed := mc.serverClosed[name]
+	mc.mutex.Unlock()
+
+	if document != nil && closed {
+		mc.unsubscribe(name)
+		return nil
+	}
+	return document
+}
+
+// subscribe joins the room of the document; returns an error if the client cannot join it
+func (mc *multiplexConnection) subscribe(name string) (*multiplexDocument, error) {
+	if limit := ycsRooms.limits.MaxDocumentsPerConnection; limit > 0 {
+		mc.mutex.Lock()
+		count := len(mc.documents)
+		mc.mutex.Unlock()
+		if count >= limit {
+			return nil, errTooManyDocuments
+		}
+	}
+
+	manager, err := ycsRooms.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if maxClients := ycsRooms.limits.MaxClientsPerRoom; maxClients > 0 && manager.GetClientCount() >= maxClients {
+		return nil, errRoomFull
+	}
+
+	// Register the document before its session can write and close it
+	document := &multiplexDocument{manager: manager}
+	mc.mutex.Lock()
+	mc.documents[name] = document
+	mc.mutex.Unlock()
+
+	document.client, document.connection, _ = manager.HandleClientConnected("", -1, &multiplexTransport{mc: mc, document: name})
+	return document, nil
+}
+
+// unsubscribe leaves the room of the document
+func (mc *multiplexConnection) unsubscribe(name string) {
+	mc.mutex.Lock()
+	document := mc.documents[name]
+	mc.mutex.Unlock()
+	if document == nil {
+		return
+	}
+
+	// Multiplexed sessions cannot resume, like binary ones
+	document.manager.HandleClientDetached(document.client, document.connection)
+	document.manager.HandleClientDisconnected(document.client.GetSessionID())
+
+	mc.mutex.Lock()
+	delete(mc.documents, name)
+	delete(mc.serverClosed, name)
+	mc.mutex.Unlock()
+}
+
+// unsubscribeAll leaves the rooms of all documents
+func (mc *multiplexConnection) unsubscribeAll() {
+	mc.mutex.Lock()
+	names := make([]string, 0, len(mc.documents))
+	for name := range mc.documents {
+		names = append(names, name)
+	}
+	mc.mutex.Unlock()
+
+	for _, name := range names {
+		mc.unsubscribe(name)
+	}
+}
+
+var (
+	// errTooManyDocuments is returned when a client subscribes to more documents than allowed
+	errTooManyDocuments = errors.New("too many documents on this connection")
+	// errRoomFull is returned when a room has as many clients as allowed
+	errRoomFull = errors.New("room is full")
+)
+
+// handleMultiplexWebSocket serves a multiplexed connection until it closes
+func handleMultiplexWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logWarnf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	if ycsRooms.limits.MaxMessageBytes > 0 {
+		conn.SetReadLimit(ycsRooms.limits.MaxMessageBytes)
+	}
+
+	mc := &multiplexConnection{
+		conn:         conn,
+		documents:    make(map[string]*multiplexDocument),
+		serverClosed: make(map[string]struct{}),
+	}
+	defer mc.unsubscribeAll()
+
+	for {
+		messageType, frame, err := conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				rejectedMessages.WithLabelValues(rejectTooLarge).Inc()
+			}
+			logDebugf("Error reading message: %v", err)
+			return
+		}
+		if messageType != websocket.BinaryMessage {
+			rejectedMessages.WithLabelValues(rejectMalformed).Inc()
+			logWarnf("Ignoring text message from multiplexed client")
+			continue
+		}
+
+		message, err := protocols.DecodeMultiplexMessage(frame)
+		if err != nil {
+			rejectedMessages.WithLabelValues(rejectMalformed).Inc()
+			logWarnf("Invalid multiplexed message: %v", err)
+			continue
+		}
+
+		if err := mc.handle(message); err != nil {
+			logErrorf("Error processing message of document %s: %v", message.Document, err)
+		}
+	}
+}
+
+// handle handles a message of the client, subscribing to its document if necessary
+func (mc *multiplexConnection) handle(message protocols.MultiplexMessage) error {
+	document := mc.getDocument(message.Document)
+	if message.Type == protocols.MultiplexClose {
+		if document != nil {
+			mc.unsubscribe(message.Document)
+		}
+		return nil
+	}
+
+	if document == nil {
+		var err error
+		if document, err = mc.subscribe(message.Document); err != nil {
+			rejectedMessages.WithLabelValues(rejectDenied).Inc()
+			return mc.write(protocols.EncodePermissionDeniedMessage(message.Document, err.Error()))
+		}
+	}
+
+	clientID := document.client.GetSessionID()
+	switch message.Type {
+	case protocols.MultiplexAuth:
+		if message.AuthType != protocols.AuthToken {
+			rejectedMessages.WithLabelValues(rejectUnknownType).Inc()
+			return fmt.Errorf("unexpected auth message type %d", message.AuthType)
+		}
+		// The server does not authenticate clients on any of its endpoints: rooms are
+		// public, so the token is ignored and every client gets read-write access
+		return mc.write(protocols.EncodeAuthenticatedMessage(message.Document, multiplexScope))
+	case protocols.MultiplexSync, protocols.MultiplexSyncReply:
+		payload, err := protocols.DecodeMultiplexSyncPayload(message)
+		if err != nil {
+			rejectedMessages.WithLabelValues(rejectMalformed).Inc()
+			return err
+		}
+		messageToProcess := &MessageToProcess{Command: Update, Data: base64.StdEncoding.EncodeToString(payload)}
+		switch message.SyncType {
+		case protocols.MessageYjsSyncStep1:
+			messageToProcess.Command = GetMissing
+		case protocols.MessageYjsSyncStep2:
+			getMissingType := GetMissing
+			messageToProcess.InReplyTo = &getMissingType
+		}
+
+		logDebugf("Received %s message with clock %d from %s", messageToProcess.Command, document.clock, clientID)
+		err = document.manager.ProcessMessage(clientID, document.clock, messageToProcess)
+		document.clock++
+		if err != nil {
+			return err
+		}
+		if message.SyncType == protocols.MessageYjsUpdate {
+			return mc.write(protocols.EncodeSyncStatusMessage(message.Document, true))
+		}
+		return nil
+	case protocols.MultiplexAwareness, protocols.MultiplexQueryAwareness:
+		command := UpdateAwareness
+		if message.Type == protocols.MultiplexQueryAwareness {
+			command = QueryAwareness
+		}
+
+		logDebugf("Received %s message from %s", command, clientID)
+		return document.manager.ProcessAwareness(clientID, command, base64.StdEncoding.EncodeToString(message.Payload))
+	default:
+		// Stateless messages and sync statuses carry nothing the server keeps
+		logDebugf("Ignoring multiplexed message of type %d from %s", message.Type, clientID)
+		return nil
+	}
+}
@@ -0,0 +1,65 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+const fileExtension = ".ydoc"
+
+// FileStore is a Store keeping one file per room in a directory
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a new FileStore, creating the directory if necessary
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("persistence directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating persistence directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file of the room; room names are escaped so they cannot leave the directory
+func (fs *FileStore) path(room string) string {
+	return filepath.Join(fs.dir, url.PathEscape(room)+fileExtension)
+}
+
+// Load returns the stored update of the room, or nil if the room was never saved
+func (fs *FileStore) Load(room string) ([]byte, error) {
+	data, err := os.ReadFile(fs.path(room))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Save replaces the stored update of the room.
+// The file is replaced atomically so that a crash never leaves a partial document.
+func (fs *FileStore) Save(room string, update []byte) error {
+	tmp, err := os.CreateTemp(fs.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(update); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fs.path(room))
+}
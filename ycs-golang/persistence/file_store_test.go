@@ -0,0 +1,87 @@
+package persistence
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	fs, err := NewFileStore(filepath.Join(t.TempDir(), "rooms"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if update, err := fs.Load("room"); err != nil || update != nil {
+		t.Fatalf("unsaved room loaded as %v, %v", update, err)
+	}
+
+	for _, update := range [][]byte{{1, 2, 3}, {4}} {
+		if err := fs.Save("room", update); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := fs.Load("room")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(loaded, update) {
+			t.Fatalf("loaded %v, saved %v", loaded, update)
+		}
+	}
+
+	// Saving leaves no temporary files behind
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "room"+fileExtension {
+		t.Fatalf("directory holds %v", entries)
+	}
+}
+
+func TestFileStoreKeepsRoomsInDirectory(t *testing.T) {
+	parent := t.TempDir()
+	fs, err := NewFileStore(filepath.Join(parent, "rooms"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rooms := []string{"../escaped", "a/b", "a%2Fb"}
+	for i, room := range rooms {
+		if err := fs.Save(room, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, room := range rooms {
+		loaded, err := fs.Load(room)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(loaded, []byte{byte(i)}) {
+			t.Fatalf("room %q loaded as %v", room, loaded)
+		}
+	}
+
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("rooms were saved outside their directory: %v", entries)
+	}
+}
+
+func TestNewFileStoreRequiresDirectory(t *testing.T) {
+	if _, err := NewFileStore(""); err == nil {
+		t.Fatal("no error without a directory")
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(file); err == nil {
+		t.Fatal("no error for a directory that is a file")
+	}
+}
@@ -0,0 +1,9 @@
+package persistence
+
+// Store saves and loads room documents encoded as V2 updates
+type Store interface {
+	// Load returns the stored update of the room, or nil if the room was never saved
+	Load(room string) ([]byte, error)
+	// Save replaces the stored update of the room
+	Save(room string, update []byte) error
+}
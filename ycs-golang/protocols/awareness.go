@@ -0,0 +1,154 @@
+package protocols
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"ycs/lib0"
+)
+
+// nullState is the JSON state of a client that has left
+const nullState = "null"
+
+// AwarenessEntry represents the awareness state of one Yjs client in an awareness update
+type AwarenessEntry struct {
+	ClientID uint32
+	Clock    uint32
+	// State is the JSON encoded state, "null" if the client has left
+	State string
+}
+
+// IsRemoved returns true if the entry removes the client's state
+func (e AwarenessEntry) IsRemoved() bool {
+	return e.State == nullState
+}
+
+// DecodeAwarenessUpdate decodes an update of the y-protocols awareness protocol
+func DecodeAwarenessUpdate(update []byte) ([]AwarenessEntry, error) {
+	reader := bytes.NewReader(update)
+
+	count, err := lib0.ReadVarUint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading awareness entry count: %w", err)
+	}
+
+	// The count comes from the client, so only a bounded capacity is allocated up front
+	entries := make([]AwarenessEntry, 0, min(count, lib0.MaxPreallocation))
+	for i := uint32(0); i < count; i++ {
+		clientID, err := lib0.ReadVarUint(reader)
+		if err != nil {
+			return nil, fmt.Errorf("reading awareness client: %w", err)
+		}
+		clock, err := lib0.ReadVarUint(reader)
+		if err != nil {
+			return nil, fmt.Errorf("reading awareness clock: %w", err)
+		}
+		state, err := lib0.ReadVarString(reader)
+		if err != nil {
+			return nil, fmt.Errorf("reading awareness state: %w", err)
+		}
+		entries = append(entries, AwarenessEntry{ClientID: clientID, Clock: clock, State: state})
+	}
+
+	return entries, nil
+}
+
+// EncodeAwarenessUpdate encodes entries as an update of the y-protocols awareness protocol
+func EncodeAwarenessUpdate(entries []AwarenessEntry) []byte {
+	buf := &bytes.Buffer{}
+	lib0.WriteVarUint(buf, uint32(len(entries)))
+	for _, entry := range entries {
+		lib0.WriteVarUint(buf, entry.ClientID)
+		lib0.WriteVarUint(buf, entry.Clock)
+		lib0.WriteVarString(buf, entry.State)
+	}
+	return buf.Bytes()
+}
+
+// Awareness caches the awareness states of the clients of one document.
+// States are kept in their JSON encoding; the server only relays them.
+// Conflicts are resolved like y-protocols: a higher clock wins, and at the
+// same clock a removal wins over a state.
+type Awareness struct {
+	states map[uint32]AwarenessEntry
+	mutex  sync.Mutex
+}
+
+// NewAwareness creates a new Awareness
+func NewAwareness() *Awareness {
+	return &Awareness{
+		states: make(map[uint32]AwarenessEntry),
+	}
+}
+
+// ApplyUpdate merges an update into the cache and returns the entries that changed it
+func (a *Awareness) ApplyUpdate(update []byte) ([]AwarenessEntry, error) {
+	entries, err := DecodeAwarenessUpdate(update)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	changed := make([]AwarenessEntry, 0, len(entries))
+	for _, entry := range entries {
+		current, exists := a.states[entry.ClientID]
+		if exists && current.Clock > entry.Clock {
+			continue
+		}
+		if exists && current.Clock == entry.Clock && (current.IsRemoved() || !entry.IsRemoved()) {
+			continue
+		}
+		if !exists && entry.IsRemoved() {
+			// Remember the clock so that older states of the client are ignored
+			a.states[entry.ClientID] = entry
+			continue
+		}
+
+		a.states[entry.ClientID] = entry
+		changed = append(changed, entry)
+	}
+
+	return changed, nil
+}
+
+// EncodeStates encodes the states of all present clients, or returns nil if there are none
+func (a *Awareness) EncodeStates() []byte {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	entries := make([]AwarenessEntry, 0, len(a.states))
+	for _, entry := range a.states {
+		if !entry.IsRemoved() {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return EncodeAwarenessUpdate(entries)
+}
+
+// RemoveStates removes the states of the clients and returns the update announcing
+// the removal, or nil if none of the clients had a state
+func (a *Awareness) RemoveStates(clientIDs []uint32) []byte {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	entries := make([]AwarenessEntry, 0, len(clientIDs))
+	for _, clientID := range clientIDs {
+		current, exists := a.states[clientID]
+		if !exists || current.IsRemoved() {
+			continue
+		}
+
+		removal := AwarenessEntry{ClientID: clientID, Clock: current.Clock + 1, State: nullState}
+		a.states[clientID] = removal
+		entries = append(entries, removal)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return EncodeAwarenessUpdate(entries)
+}
@@ -0,0 +1,141 @@
+package protocols
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// awarenessUpdate encodes a single awareness entry
+func awarenessUpdate(clientID, clock uint32, state string) []byte {
+	return EncodeAwarenessUpdate([]AwarenessEntry{{ClientID: clientID, Clock: clock, State: state}})
+}
+
+// applyAwareness applies an update and returns the changed entries, failing the test on error
+func applyAwareness(t *testing.T, a *Awareness, update []byte) []AwarenessEntry {
+	t.Helper()
+	changed, err := a.ApplyUpdate(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return changed
+}
+
+func TestAwarenessUpdateRoundTrip(t *testing.T) {
+	entries := []AwarenessEntry{
+		{ClientID: 1, Clock: 0, State: `{"user":"a"}`},
+		{ClientID: 300, Clock: 70000, State: nullState},
+	}
+	decoded, err := DecodeAwarenessUpdate(EncodeAwarenessUpdate(entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, entries) {
+		t.Fatalf("got %+v, want %+v", decoded, entries)
+	}
+	if !decoded[1].IsRemoved() || decoded[0].IsRemoved() {
+		t.Fatal("IsRemoved does not match the state")
+	}
+
+	encoded := EncodeAwarenessUpdate(entries)
+	if _, err := DecodeAwarenessUpdate(encoded[:len(encoded)-2]); err == nil {
+		t.Fatal("no error for a truncated update")
+	}
+}
+
+func TestDecodeAwarenessUpdateHostileCount(t *testing.T) {
+	// The count claims 2^32-1 entries that the update does not hold
+	if _, err := DecodeAwarenessUpdate([]byte{0xff, 0xff, 0xff, 0xff, 0x0f}); err == nil {
+		t.Fatal("no error for an update with a hostile entry count")
+	}
+
+	// Only a bounded capacity is reserved for the claimed entries
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	DecodeAwarenessUpdate([]byte{0xff, 0xff, 0xff, 0xff, 0x0f})
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 8<<20 {
+		t.Fatalf("%d bytes allocated for a 5 byte update", allocated)
+	}
+}
+
+func TestAwarenessHigherClockWins(t *testing.T) {
+	a := NewAwareness()
+
+	if changed := applyAwareness(t, a, awarenessUpdate(1, 2, `"new"`)); len(changed) != 1 {
+		t.Fatalf("first state not applied: %+v", changed)
+	}
+	if changed := applyAwareness(t, a, awarenessUpdate(1, 1, `"old"`)); len(changed) != 0 {
+		t.Fatalf("older state applied: %+v", changed)
+	}
+	if changed := applyAwareness(t, a, awarenessUpdate(1, 2, `"same"`)); len(changed) != 0 {
+		t.Fatalf("state with the same clock applied: %+v", changed)
+	}
+
+	states, err := DecodeAwarenessUpdate(a.EncodeStates())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 1 || states[0].State != `"new"` {
+		t.Fatalf("got %+v", states)
+	}
+}
+
+func TestAwarenessRemovalWinsAtSameClock(t *testing.T) {
+	a := NewAwareness()
+	applyAwareness(t, a, awarenessUpdate(1, 3, `"state"`))
+
+	changed := applyAwareness(t, a, awarenessUpdate(1, 3, nullState))
+	if len(changed) != 1 || !changed[0].IsRemoved() {
+		t.Fatalf("removal at the same clock not applied: %+v", changed)
+	}
+	if a.EncodeStates() != nil {
+		t.Fatal("removed client still encoded")
+	}
+
+	// A later state brings the client back
+	if changed := applyAwareness(t, a, awarenessUpdate(1, 4, `"back"`)); len(changed) != 1 {
+		t.Fatalf("newer state not applied: %+v", changed)
+	}
+}
+
+func TestAwarenessRemovalOfUnknownClient(t *testing.T) {
+	a := NewAwareness()
+
+	if changed := applyAwareness(t, a, awarenessUpdate(1, 5, nullState)); len(changed) != 0 {
+		t.Fatalf("removal of an unknown client reported: %+v", changed)
+	}
+	// The removal's clock is remembered, so a stale state delivered late is ignored
+	if changed := applyAwareness(t, a, awarenessUpdate(1, 4, `"stale"`)); len(changed) != 0 {
+		t.Fatalf("stale state applied: %+v", changed)
+	}
+	if a.EncodeStates() != nil {
+		t.Fatal("stale state encoded")
+	}
+}
+
+func TestAwarenessRemoveStates(t *testing.T) {
+	a := NewAwareness()
+	applyAwareness(t, a, awarenessUpdate(1, 7, `"a"`))
+	applyAwareness(t, a, awarenessUpdate(2, 0, `"b"`))
+
+	removal, err := DecodeAwarenessUpdate(a.RemoveStates([]uint32{1, 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AwarenessEntry{{ClientID: 1, Clock: 8, State: nullState}}
+	if !reflect.DeepEqual(removal, want) {
+		t.Fatalf("got %+v, want %+v", removal, want)
+	}
+	if a.RemoveStates([]uint32{1}) != nil {
+		t.Fatal("second removal announced again")
+	}
+
+	states, err := DecodeAwarenessUpdate(a.EncodeStates())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 1 || states[0].ClientID != 2 {
+		t.Fatalf("got %+v", states)
+	}
+}
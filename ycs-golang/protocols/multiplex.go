@@ -0,0 +1,208 @@
+package protocols
+
+import (
+	"bytes"
+	"fmt"
+
+	"ycs/core"
+	"ycs/lib0"
+)
+
+// Message types of the multiplexed protocol, which syncs several documents over one
+// connection. Every frame starts with the name of its document followed by the message
+// type, as in Hocuspocus. Sync messages carry V1 updates like those of stock Hocuspocus
+// and TipTap clients; DecodeMultiplexSyncPayload and EncodeMultiplexSyncMessage convert
+// them from and to the V2 updates of the rest of this package. State vectors are the
+// same in both formats.
+const (
+	MultiplexSync               = 0
+	MultiplexAwareness          = 1
+	MultiplexAuth               = 2
+	MultiplexQueryAwareness     = 3
+	MultiplexSyncReply          = 4
+	MultiplexStateless          = 5
+	MultiplexBroadcastStateless = 6
+	MultiplexClose              = 7
+	MultiplexSyncStatus         = 8
+)
+
+// Auth message types of MultiplexAuth frames
+const (
+	AuthToken            = 0
+	AuthPermissionDenied = 1
+	AuthAuthenticated    = 2
+)
+
+// MultiplexMessage is a decoded frame of the multiplexed protocol
+type MultiplexMessage struct {
+	Document string
+	Type     uint32
+	// SyncType is the sync message type of a MultiplexSync or MultiplexSyncReply frame
+	SyncType uint32
+	// AuthType is the auth message type of a MultiplexAuth frame
+	AuthType uint32
+	// Payload is the state vector, update or awareness update
+	Payload []byte
+	// Text is the token or reason of an auth frame, the reason of a close frame or
+	// the payload of a stateless frame
+	Text string
+	// Body is the frame after the message type
+	Body []byte
+}
+
+// EncodeMultiplexMessage prefixes a y-websocket message with the name of its document.
+// Sync, awareness and query awareness messages are framed alike in both protocols.
+func EncodeMultiplexMessage(document string, message []byte) []byte {
+	buf := &bytes.Buffer{}
+	lib0.WriteVarString(buf, document)
+	buf.Write(message)
+	return buf.Bytes()
+}
+
+// EncodeAuthenticatedMessage tells the client that it may access the document with the given scope, e.g. "read-write"
+func EncodeAuthenticatedMessage(document string, scope string) []byte {
+	buf := &bytes.Buffer{}
+	lib0.WriteVarString(buf, document)
+	lib0.WriteVarUint(buf, MultiplexAuth)
+	lib0.WriteVarUint(buf, AuthAuthenticated)
+	lib0.WriteVarString(buf, scope)
+	return buf.Bytes()
+}
+
+// EncodePermissionDeniedMessage tells the client that it may not access the document
+func EncodePermissionDeniedMessage(document string, reason string) []byte {
+	buf := &bytes.Buffer{}
+	lib0.WriteVarString(buf, document)
+	lib0.WriteVarUint(buf, MultiplexAuth)
+	lib0.WriteVarUint(buf, AuthPermissionDenied)
+	lib0.WriteVarString(buf, reason)
+	return buf.Bytes()
+}
+
+// EncodeSyncStatusMessage acknowledges an update of the document
+func EncodeSyncStatusMessage(document string, saved bool) []byte {
+	buf := &bytes.Buffer{}
+	lib0.WriteVarString(buf, document)
+	lib0.WriteVarUint(buf, MultiplexSyncStatus)
+	if saved {
+		lib0.WriteVarUint(buf, 1)
+	} else {
+		lib0.WriteVarUint(buf, 0)
+	}
+	return buf.Bytes()
+}
+
+// EncodeCloseMessage tells the client that the server stopped syncing the document
+func EncodeCloseMessage(document string, reason string) []byte {
+	buf := &bytes.Buffer{}
+	lib0.WriteVarString(buf, document)
+	lib0.WriteVarUint(buf, MultiplexClose)
+	lib0.WriteVarString(buf, reason)
+	return buf.Bytes()
+}
+
+// DecodeMultiplexMessage decodes a frame of the multiplexed protocol
+func DecodeMultiplexMessage(frame []byte) (MultiplexMessage, error) {
+	var message MultiplexMessage
+	reader := bytes.NewReader(frame)
+
+	document, err := lib0.ReadVarString(reader)
+	if err != nil {
+		return message, fmt.Errorf("reading document name: %w", err)
+	}
+	message.Document = document
+
+	messageType, err := lib0.ReadVarUint(reader)
+	if err != nil {
+		return message, fmt.Errorf("reading message type: %w", err)
+	}
+	message.Type = messageType
+	message.Body = frame[len(frame)-reader.Len():]
+
+	switch messageType {
+	case MultiplexSync, MultiplexSyncReply:
+		syncType, err := lib0.ReadVarUint(reader)
+		if err != nil {
+			return message, fmt.Errorf("reading sync message type: %w", err)
+		}
+		if syncType > MessageYjsUpdate {
+			return message, fmt.Errorf("unknown sync message type: %d", syncType)
+		}
+		message.SyncType = syncType
+		fallthrough
+	case MultiplexAwareness:
+		payload, err := lib0.ReadVarUint8Array(reader)
+		if err != nil {
+			return message, fmt.Errorf("reading payload: %w", err)
+		}
+		message.Payload = payload
+	case MultiplexAuth:
+		authType, err := lib0.ReadVarUint(reader)
+		if err != nil {
+			return message, fmt.Errorf("reading auth message type: %w", err)
+		}
+		message.AuthType = authType
+		if message.Text, err = lib0.ReadVarString(reader); err != nil {
+			return message, fmt.Errorf("reading auth message: %w", err)
+		}
+	case MultiplexStateless, MultiplexBroadcastStateless:
+		if message.Text, err = lib0.ReadVarString(reader); err != nil {
+			return message, fmt.Errorf("reading stateless payload: %w", err)
+		}
+	case MultiplexClose:
+		// Clients close without a reason
+		if reader.Len() > 0 {
+			if message.Text, err = lib0.ReadVarString(reader); err != nil {
+				return message, fmt.Errorf("reading close reason: %w", err)
+			}
+		}
+	case MultiplexQueryAwareness, MultiplexSyncStatus:
+	default:
+		return message, fmt.Errorf("unknown message type: %d", messageType)
+	}
+
+	return message, nil
+}
+
+// DecodeMultiplexSyncPayload returns the payload of a sync frame with its update
+// converted to V2. The state vector of a SyncStep1 message is returned as is.
+func DecodeMultiplexSyncPayload(message MultiplexMessage) ([]byte, error) {
+	if message.SyncType == MessageYjsSyncStep1 {
+		return message.Payload, nil
+	}
+	return core.ConvertUpdateV1ToV2(message.Payload)
+}
+
+// EncodeMultiplexSyncMessage frames a sync message for the document. The V2 update
+// of a SyncStep2 or Update message is converted to V1.
+func EncodeMultiplexSyncMessage(document string, syncType uint32, payload []byte) ([]byte, error) {
+	if syncType != MessageYjsSyncStep1 {
+		var err error
+		if payload, err = core.ConvertUpdateV2ToV1(payload); err != nil {
+			return nil, err
+		}
+	}
+	return EncodeMultiplexMessage(document, EncodeSyncMessage(syncType, payload)), nil
+}
+
+// ReadMultiplexSyncMessage applies a sync frame to the document like ReadSyncMessage,
+// with V1 updates. Returns the reply, framed for the same document, or nil if there
+// is none.
+func ReadMultiplexSyncMessage(message MultiplexMessage, doc *core.YDoc, transactionOrigin interface{}) ([]byte, error) {
+	if message.Type != MultiplexSync && message.Type != MultiplexSyncReply {
+		return nil, fmt.Errorf("not a sync message: %d", message.Type)
+	}
+
+	payload, err := DecodeMultiplexSyncPayload(message)
+	if err != nil {
+		return nil, err
+	}
+	if message.SyncType == MessageYjsSyncStep1 {
+		update, err := doc.EncodeStateAsUpdateV2(payload)
+		if err != nil {
+			return nil, err
+		}
+		return EncodeMultiplexSyncMessage(message.Document, MessageYjsSyncStep2, update)
+	}
+	return nil, doc.ApplyUpdateV2(payload, transactionOrigin, false)
+}
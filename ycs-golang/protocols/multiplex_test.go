@@ -0,0 +1,122 @@
+package protocols
+
+import (
+	"bytes"
+	"testing"
+
+	"ycs/contracts"
+	"ycs/core"
+	"ycs/lib0"
+)
+
+// yjsInsertUpdate is the V1 update Yjs encodes after client 1 inserts "a" into the
+// root text "t"
+var yjsInsertUpdate = []byte{1, 1, 1, 0, 4, 1, 1, 't', 1, 'a', 0}
+
+// multiplexSyncFrame frames a sync message with a payload that is not converted
+func multiplexSyncFrame(document string, syncType uint32, payload []byte) []byte {
+	return EncodeMultiplexMessage(document, EncodeSyncMessage(syncType, payload))
+}
+
+// decodeMultiplex decodes a frame, failing the test on error
+func decodeMultiplex(t *testing.T, frame []byte) MultiplexMessage {
+	t.Helper()
+	message, err := DecodeMultiplexMessage(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func TestDecodeMultiplexMessage(t *testing.T) {
+	auth := &bytes.Buffer{}
+	lib0.WriteVarString(auth, "doc")
+	lib0.WriteVarUint(auth, MultiplexAuth)
+	lib0.WriteVarUint(auth, AuthToken)
+	lib0.WriteVarString(auth, "secret")
+	message := decodeMultiplex(t, auth.Bytes())
+	if message.Document != "doc" || message.Type != MultiplexAuth || message.AuthType != AuthToken || message.Text != "secret" {
+		t.Fatalf("got %+v", message)
+	}
+
+	message = decodeMultiplex(t, multiplexSyncFrame("doc", MessageYjsUpdate, yjsInsertUpdate))
+	if message.Type != MultiplexSync || message.SyncType != MessageYjsUpdate || !bytes.Equal(message.Payload, yjsInsertUpdate) {
+		t.Fatalf("got %+v", message)
+	}
+
+	message = decodeMultiplex(t, EncodeCloseMessage("doc", "bye"))
+	if message.Type != MultiplexClose || message.Text != "bye" {
+		t.Fatalf("got %+v", message)
+	}
+
+	for _, frame := range [][]byte{
+		nil,
+		EncodeMultiplexMessage("doc", []byte{MultiplexSync, 3}),
+		EncodeMultiplexMessage("doc", []byte{42}),
+		multiplexSyncFrame("doc", MessageYjsUpdate, yjsInsertUpdate)[:8],
+	} {
+		if _, err := DecodeMultiplexMessage(frame); err == nil {
+			t.Errorf("no error for frame %v", frame)
+		}
+	}
+}
+
+func TestMultiplexSyncPayloadIsV1(t *testing.T) {
+	payload, err := DecodeMultiplexSyncPayload(decodeMultiplex(t, multiplexSyncFrame("doc", MessageYjsUpdate, yjsInsertUpdate)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := core.NewYDoc(contracts.YDocOptions{})
+	if err := doc.ApplyUpdateV2(payload, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.GetText("t").ToString(); got != "a" {
+		t.Fatalf("got %q", got)
+	}
+
+	// State vectors are the same in both formats
+	sv := doc.EncodeStateVectorV2()
+	if payload, err := DecodeMultiplexSyncPayload(decodeMultiplex(t, multiplexSyncFrame("doc", MessageYjsSyncStep1, sv))); err != nil || !bytes.Equal(payload, sv) {
+		t.Fatalf("got %v, %v", payload, err)
+	}
+
+	update, err := doc.EncodeStateAsUpdateV2()
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := EncodeMultiplexSyncMessage("doc", MessageYjsSyncStep2, update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message := decodeMultiplex(t, frame); message.SyncType != MessageYjsSyncStep2 || !bytes.Equal(message.Payload, yjsInsertUpdate) {
+		t.Fatalf("got %+v, want payload %v", message, yjsInsertUpdate)
+	}
+
+	if _, err := DecodeMultiplexSyncPayload(decodeMultiplex(t, multiplexSyncFrame("doc", MessageYjsUpdate, []byte{1, 1}))); err == nil {
+		t.Fatal("no error for a malformed update")
+	}
+}
+
+func TestReadMultiplexSyncMessage(t *testing.T) {
+	server := core.NewYDoc(contracts.YDocOptions{})
+	reply, err := ReadMultiplexSyncMessage(decodeMultiplex(t, multiplexSyncFrame("doc", MessageYjsUpdate, yjsInsertUpdate)), server, nil)
+	if err != nil || reply != nil {
+		t.Fatalf("got %v, %v", reply, err)
+	}
+
+	client := core.NewYDoc(contracts.YDocOptions{})
+	reply, err = ReadMultiplexSyncMessage(decodeMultiplex(t, multiplexSyncFrame("doc", MessageYjsSyncStep1, client.EncodeStateVectorV2())), server, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := decodeMultiplex(t, reply)
+	if message.Document != "doc" || message.SyncType != MessageYjsSyncStep2 {
+		t.Fatalf("got %+v", message)
+	}
+	if _, err := ReadMultiplexSyncMessage(message, client, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.GetText("t").ToString(); got != "a" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -32,7 +32,10 @@ func WriteSyncStep2(writer io.Writer, doc *core.YDoc, encodedStateVector []byte)
 		return err
 	}
 
-	update := doc.EncodeStateAsUpdateV2(encodedStateVector)
+	update, err := doc.EncodeStateAsUpdateV2(encodedStateVector)
+	if err != nil {
+		return err
+	}
 	return lib0.WriteVarUint8Array(streamWriter, update)
 }
 
@@ -55,8 +58,7 @@ func ReadSyncStep2(reader io.Reader, doc *core.YDoc, transactionOrigin interface
 		return err
 	}
 
-	doc.ApplyUpdateV2(update, transactionOrigin, false)
-	return nil
+	return doc.ApplyUpdateV2(update, transactionOrigin, false)
 }
 
 // WriteUpdate writes an update message to stream
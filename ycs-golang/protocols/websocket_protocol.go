@@ -0,0 +1,106 @@
+package protocols
+
+import (
+	"bytes"
+	"fmt"
+	"ycs/lib0"
+)
+
+// Message type constants of the y-websocket binary protocol.
+// Sync messages carry V2 updates, like the rest of this package.
+const (
+	MessageSync           = 0
+	MessageAwareness      = 1
+	MessageAuth           = 2
+	MessageQueryAwareness = 3
+	// MessageSubdocSync is a sync message of a subdocument, prefixed with its GUID
+	MessageSubdocSync = 4
+)
+
+// WebSocketMessage is a decoded y-websocket frame
+type WebSocketMessage struct {
+	Type uint32
+	// Guid is the subdocument of a MessageSubdocSync frame
+	Guid string
+	// SyncType is the sync message type of a MessageSync frame
+	SyncType uint32
+	// Payload is the state vector, update or awareness update
+	Payload []byte
+}
+
+// EncodeSyncMessage encodes a sync message carrying a state vector (step 1) or an update
+func EncodeSyncMessage(syncType uint32, payload []byte) []byte {
+	buf := &bytes.Buffer{}
+	lib0.WriteVarUint(buf, MessageSync)
+	lib0.WriteVarUint(buf, syncType)
+	lib0.WriteVarUint8Array(buf, payload)
+	return buf.Bytes()
+}
+
+// EncodeSubdocSyncMessage encodes a sync message of the subdocument with the given GUID
+func EncodeSubdocSyncMessage(guid string, syncType uint32, payload []byte) []byte {
+	buf := &bytes.Buffer{}
+	lib0.WriteVarUint(buf, MessageSubdocSync)
+	lib0.WriteVarString(buf, guid)
+	lib0.WriteVarUint(buf, syncType)
+	lib0.WriteVarUint8Array(buf, payload)
+	return buf.Bytes()
+}
+
+// EncodeAwarenessMessage encodes an awareness update
+func EncodeAwarenessMessage(update []byte) []byte {
+	buf := &bytes.Buffer{}
+	lib0.WriteVarUint(buf, MessageAwareness)
+	lib0.WriteVarUint8Array(buf, update)
+	return buf.Bytes()
+}
+
+// EncodeQueryAwarenessMessage encodes a request for the awareness states of all clients
+func EncodeQueryAwarenessMessage() []byte {
+	buf := &bytes.Buffer{}
+	lib0.WriteVarUint(buf, MessageQueryAwareness)
+	return buf.Bytes()
+}
+
+// DecodeWebSocketMessage decodes a y-websocket frame
+func DecodeWebSocketMessage(frame []byte) (WebSocketMessage, error) {
+	var message WebSocketMessage
+	reader := bytes.NewReader(frame)
+
+	messageType, err := lib0.ReadVarUint(reader)
+	if err != nil {
+		return message, fmt.Errorf("reading message type: %w", err)
+	}
+	message.Type = messageType
+
+	switch messageType {
+	case MessageSubdocSync:
+		guid, err := lib0.ReadVarString(reader)
+		if err != nil {
+			return message, fmt.Errorf("reading subdocument GUID: %w", err)
+		}
+		message.Guid = guid
+		fallthrough
+	case MessageSync:
+		syncType, err := lib0.ReadVarUint(reader)
+		if err != nil {
+			return message, fmt.Errorf("reading sync message type: %w", err)
+		}
+		if syncType > MessageYjsUpdate {
+			return message, fmt.Errorf("unknown sync message type: %d", syncType)
+		}
+		message.SyncType = syncType
+		fallthrough
+	case MessageAwareness:
+		payload, err := lib0.ReadVarUint8Array(reader)
+		if err != nil {
+			return message, fmt.Errorf("reading payload: %w", err)
+		}
+		message.Payload = payload
+	case MessageQueryAwareness:
+	default:
+		return message, fmt.Errorf("unknown message type: %d", messageType)
+	}
+
+	return message, nil
+}
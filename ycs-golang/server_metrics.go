@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"ycs/metrics"
+)
+
+// Reasons for dropping or rejecting client messages
+const (
+	rejectMalformed     = "malformed"
+	rejectInvalidData   = "invalid_data"
+	rejectUnknownType   = "unknown_type"
+	rejectUnknownClient = "unknown_client"
+	rejectUnsynced      = "unsynced"
+	rejectSendFailed    = "send_failed"
+	rejectQueueFull     = "queue_full"
+	rejectTooLarge      = "too_large"
+	rejectGapExpired    = "gap_expired"
+	rejectUnknownSubdoc = "unknown_subdoc"
+	rejectDenied        = "denied"
+)
+
+// Update directions as seen from the server
+const (
+	directionIn  = "in"
+	directionOut = "out"
+)
+
+var (
+	metricsRegistry = metrics.NewRegistry()
+
+	updateBytes = metricsRegistry.NewCounterVec(
+		"ycs_update_bytes_total",
+		"Bytes of document updates received from (in) and sent to (out) clients.",
+		"direction")
+
+	updateApplySeconds = metricsRegistry.NewHistogramVec(
+		"ycs_update_apply_duration_seconds",
+		"Time spent applying client updates to room documents.",
+		nil)
+
+	rejectedMessages = metricsRegistry.NewCounterVec(
+		"ycs_messages_rejected_total",
+		"Client messages that were dropped or rejected, by reason.",
+		"reason")
+
+	clientIDConflicts = metricsRegistry.NewCounterVec(
+		"ycs_client_id_conflicts_total",
+		"Client IDs that two connected clients of a room announced ahead of the room document.")
+)
+
+// serverReady is set once the server accepts connections and cleared when it shuts down
+var serverReady atomic.Bool
+
+// registerRoomMetrics registers the gauges that are computed from the rooms on every scrape
+func registerRoomMetrics(rooms *YcsRooms) {
+	metricsRegistry.NewGaugeFunc(
+		"ycs_loaded_documents",
+		"Number of room documents loaded in memory.",
+		nil,
+		func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(len(rooms.GetManagers()))}}
+		})
+
+	metricsRegistry.NewGaugeFunc(
+		"ycs_connected_clients",
+		"Number of clients connected to a room.",
+		[]string{"room"},
+		func() []metrics.Sample {
+			managers := rooms.GetManagers()
+			samples := make([]metrics.Sample, 0, len(managers))
+			for _, manager := range managers {
+				samples = append(samples, metrics.Sample{
+					LabelValues: []string{manager.GetRoom()},
+					Value:       float64(manager.GetClientCount()),
+				})
+			}
+			return samples
+		})
+
+	metricsRegistry.NewGaugeFunc(
+		"ycs_pending_structs",
+		"Number of structs of a room document waiting for missing dependencies.",
+		[]string{"room"},
+		func() []metrics.Sample {
+			managers := rooms.GetManagers()
+			samples := make([]metrics.Sample, 0, len(managers))
+			for _, manager := range managers {
+				structs, _ := manager.GetPendingCounts()
+				samples = append(samples, metrics.Sample{
+					LabelValues: []string{manager.GetRoom()},
+					Value:       float64(structs),
+				})
+			}
+			return samples
+		})
+
+	metricsRegistry.NewGaugeFunc(
+		"ycs_pending_delete_sets",
+		"Number of delete sets of a room document waiting for missing structs.",
+		[]string{"room"},
+		func() []metrics.Sample {
+			managers := rooms.GetManagers()
+			samples := make([]metrics.Sample, 0, len(managers))
+			for _, manager := range managers {
+				_, deleteSets := manager.GetPendingCounts()
+				samples = append(samples, metrics.Sample{
+					LabelValues: []string{manager.GetRoom()},
+					Value:       float64(deleteSets),
+				})
+			}
+			return samples
+		})
+}
+
+// busHealth is implemented by buses that can report whether they are connected
+type busHealth interface {
+	IsConnected() bool
+}
+
+// handleHealthz reports that the process is alive
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports whether the server can take new clients
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	if !serverReady.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("not ready\n"))
+		return
+	}
+
+	if health, ok := ycsRooms.bus.(busHealth); ok && !health.IsConnected() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("bus disconnected\n"))
+		return
+	}
+
+	w.Write([]byte("ok\n"))
+}
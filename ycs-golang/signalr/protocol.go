@@ -0,0 +1,183 @@
+package signalr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// RecordSeparator terminates every message of the JSON hub protocol
+const RecordSeparator = 0x1e
+
+// ProtocolName is the only hub protocol supported
+const ProtocolName = "json"
+
+// ProtocolVersion is the version of the JSON hub protocol
+const ProtocolVersion = 1
+
+// MessageType identifies hub protocol messages
+type MessageType int
+
+const (
+	InvocationMessage       MessageType = 1
+	StreamItemMessage       MessageType = 2
+	CompletionMessage       MessageType = 3
+	StreamInvocationMessage MessageType = 4
+	CancelInvocationMessage MessageType = 5
+	PingMessage             MessageType = 6
+	CloseMessage            MessageType = 7
+)
+
+// ErrIncompleteRecord is returned when a handshake is not terminated by a record separator
+var ErrIncompleteRecord = errors.New("signalr: incomplete record")
+
+// HandshakeRequest is the first message sent by a client
+type HandshakeRequest struct {
+	Protocol string `json:"protocol"`
+	Version  int    `json:"version"`
+}
+
+// HandshakeResponse answers the handshake; an empty Error accepts it
+type HandshakeResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Message is a decoded hub message. Only the fields of its Type are set.
+type Message struct {
+	Type         MessageType       `json:"type"`
+	InvocationID string            `json:"invocationId,omitempty"`
+	Target       string            `json:"target,omitempty"`
+	Arguments    []json.RawMessage `json:"arguments,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// Invocation calls a method on the other side
+type Invocation struct {
+	Type      MessageType   `json:"type"`
+	Target    string        `json:"target"`
+	Arguments []interface{} `json:"arguments"`
+}
+
+// NewInvocation creates an invocation that expects no completion
+func NewInvocation(target string, arguments ...interface{}) Invocation {
+	return Invocation{Type: InvocationMessage, Target: target, Arguments: arguments}
+}
+
+// Completion reports the result of an invocation that has an invocation ID
+type Completion struct {
+	Type         MessageType `json:"type"`
+	InvocationID string      `json:"invocationId"`
+	Error        string      `json:"error,omitempty"`
+}
+
+// NewCompletion creates the completion of a void method; errMessage is empty on success
+func NewCompletion(invocationID string, errMessage string) Completion {
+	return Completion{Type: CompletionMessage, InvocationID: invocationID, Error: errMessage}
+}
+
+// Ping keeps the connection alive
+type Ping struct {
+	Type MessageType `json:"type"`
+}
+
+// NewPing creates a ping message
+func NewPing() Ping {
+	return Ping{Type: PingMessage}
+}
+
+// Close tells the client that the server closes the connection
+type Close struct {
+	Type           MessageType `json:"type"`
+	Error          string      `json:"error,omitempty"`
+	AllowReconnect bool        `json:"allowReconnect,omitempty"`
+}
+
+// NewClose creates a close message
+func NewClose(errMessage string, allowReconnect bool) Close {
+	return Close{Type: CloseMessage, Error: errMessage, AllowReconnect: allowReconnect}
+}
+
+// AvailableTransport describes a transport offered by negotiate
+type AvailableTransport struct {
+	Transport       string   `json:"transport"`
+	TransferFormats []string `json:"transferFormats"`
+}
+
+// NegotiateResponse is returned by the negotiate endpoint
+type NegotiateResponse struct {
+	ConnectionID        string               `json:"connectionId"`
+	ConnectionToken     string               `json:"connectionToken,omitempty"`
+	NegotiateVersion    int                  `json:"negotiateVersion"`
+	AvailableTransports []AvailableTransport `json:"availableTransports"`
+}
+
+// WebSocketsTransport is the only transport offered by negotiate
+var WebSocketsTransport = AvailableTransport{
+	Transport:       "WebSockets",
+	TransferFormats: []string{"Text"},
+}
+
+// EncodeRecord encodes a message as JSON followed by the record separator
+func EncodeRecord(message interface{}) ([]byte, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, RecordSeparator), nil
+}
+
+// SplitRecords returns the records of a frame without their separators.
+// Data after the last separator is returned as rest.
+func SplitRecords(data []byte) (records [][]byte, rest []byte) {
+	for {
+		end := bytes.IndexByte(data, RecordSeparator)
+		if end < 0 {
+			return records, data
+		}
+		records = append(records, data[:end])
+		data = data[end+1:]
+	}
+}
+
+// ParseHandshake decodes the handshake request at the start of data and returns
+// the records that follow it in the same frame
+func ParseHandshake(data []byte) (HandshakeRequest, []byte, error) {
+	var request HandshakeRequest
+
+	end := bytes.IndexByte(data, RecordSeparator)
+	if end < 0 {
+		return request, nil, ErrIncompleteRecord
+	}
+	if err := json.Unmarshal(data[:end], &request); err != nil {
+		return request, nil, fmt.Errorf("signalr: invalid handshake: %w", err)
+	}
+	if request.Protocol != ProtocolName {
+		return request, nil, fmt.Errorf("signalr: protocol %q is not supported", request.Protocol)
+	}
+	if request.Version != ProtocolVersion {
+		return request, nil, fmt.Errorf("signalr: protocol version %d is not supported", request.Version)
+	}
+	return request, data[end+1:], nil
+}
+
+// ParseMessage decodes one record
+func ParseMessage(record []byte) (Message, error) {
+	var message Message
+	if err := json.Unmarshal(record, &message); err != nil {
+		return message, fmt.Errorf("signalr: invalid message: %w", err)
+	}
+	return message, nil
+}
+
+// StringArgument returns the argument at index as a string
+func (m Message) StringArgument(index int) (string, error) {
+	if index >= len(m.Arguments) {
+		return "", fmt.Errorf("signalr: %s expects at least %d arguments", m.Target, index+1)
+	}
+	var value string
+	if err := json.Unmarshal(m.Arguments[index], &value); err != nil {
+		return "", fmt.Errorf("signalr: argument %d of %s is not a string", index, m.Target)
+	}
+	return value, nil
+}
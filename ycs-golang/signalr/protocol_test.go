@@ -0,0 +1,101 @@
+package signalr
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseHandshake(t *testing.T) {
+	frame := []byte("{\"protocol\":\"json\",\"version\":1}\x1e{\"type\":6}\x1e")
+	request, rest, err := ParseHandshake(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Protocol != ProtocolName || request.Version != ProtocolVersion {
+		t.Fatalf("got %+v", request)
+	}
+	if string(rest) != "{\"type\":6}\x1e" {
+		t.Fatalf("got rest %q", rest)
+	}
+}
+
+func TestParseHandshakeErrors(t *testing.T) {
+	if _, _, err := ParseHandshake([]byte(`{"protocol":"json","version":1}`)); !errors.Is(err, ErrIncompleteRecord) {
+		t.Fatalf("got %v, want ErrIncompleteRecord", err)
+	}
+
+	for _, frame := range []string{
+		"not json\x1e",
+		"{\"protocol\":\"messagepack\",\"version\":1}\x1e",
+		"{\"protocol\":\"json\",\"version\":2}\x1e",
+	} {
+		if _, _, err := ParseHandshake([]byte(frame)); err == nil {
+			t.Errorf("no error for handshake %q", frame)
+		}
+	}
+}
+
+func TestSplitRecords(t *testing.T) {
+	records, rest := SplitRecords([]byte("a\x1e\x1ebc\x1epartial"))
+	if len(records) != 3 || string(records[0]) != "a" || len(records[1]) != 0 || string(records[2]) != "bc" {
+		t.Fatalf("got %q", records)
+	}
+	if string(rest) != "partial" {
+		t.Fatalf("got rest %q", rest)
+	}
+
+	if records, rest := SplitRecords(nil); len(records) != 0 || len(rest) != 0 {
+		t.Fatalf("got %q, %q", records, rest)
+	}
+}
+
+func TestEncodeRecordRoundTrip(t *testing.T) {
+	record, err := EncodeRecord(NewInvocation("Update", "room", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record[len(record)-1] != RecordSeparator {
+		t.Fatal("record is not terminated by the record separator")
+	}
+
+	records, rest := SplitRecords(record)
+	if len(records) != 1 || len(rest) != 0 {
+		t.Fatalf("got %q, %q", records, rest)
+	}
+	message, err := ParseMessage(records[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Type != InvocationMessage || message.Target != "Update" {
+		t.Fatalf("got %+v", message)
+	}
+
+	room, err := message.StringArgument(0)
+	if err != nil || room != "room" {
+		t.Fatalf("got %q, %v", room, err)
+	}
+	if _, err := message.StringArgument(1); err == nil {
+		t.Fatal("no error for an argument that is not a string")
+	}
+	if _, err := message.StringArgument(2); err == nil {
+		t.Fatal("no error for a missing argument")
+	}
+}
+
+func TestEncodeRecordOmitsEmptyFields(t *testing.T) {
+	record, err := EncodeRecord(NewCompletion("1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(record); got != "{\"type\":3,\"invocationId\":\"1\"}\x1e" {
+		t.Fatalf("got %q", got)
+	}
+
+	record, err = EncodeRecord(NewClose("", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(record); got != "{\"type\":7}\x1e" {
+		t.Fatalf("got %q", got)
+	}
+}
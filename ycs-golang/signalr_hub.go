@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ycs/signalr"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// signalRKeepAliveInterval is how often the server pings SignalR clients
+	signalRKeepAliveInterval = 15 * time.Second
+	// signalRClientTimeout closes connections of clients that stopped sending, pings included
+	signalRClientTimeout = 30 * time.Second
+	// signalRHandshakeTimeout bounds the wait for the handshake request
+	signalRHandshakeTimeout = 15 * time.Second
+	// signalRNegotiateTimeout is how long a negotiated connection token can be used
+	signalRNegotiateTimeout = time.Minute
+)
+
+// signalRNegotiations holds the connection tokens handed out by negotiate
+type signalRNegotiations struct {
+	tokens map[string]time.Time
+	mutex  sync.Mutex
+}
+
+var negotiations = &signalRNegotiations{tokens: make(map[string]time.Time)}
+
+// add registers a token and forgets the expired ones
+func (n *signalRNegotiations) add(token string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	now := time.Now()
+	for pending, issuedAt := range n.tokens {
+		if now.Sub(issuedAt) > signalRNegotiateTimeout {
+			delete(n.tokens, pending)
+		}
+	}
+	n.tokens[token] = now
+}
+
+// take consumes a token; returns false if it is unknown or expired
+func (n *signalRNegotiations) take(token string) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	issuedAt, ok := n.tokens[token]
+	delete(n.tokens, token)
+	return ok && time.Since(issuedAt) <= signalRNegotiateTimeout
+}
+
+// signalRTransport speaks the SignalR JSON hub protocol. Hub messages are invocations
+// of the client methods named after the command, like the original YcsHub.
+type signalRTransport struct {
+	conn *websocket.Conn
+	// mutex serializes the writer of the client connection with keep-alive pings
+	mutex sync.Mutex
+}
+
+func (t *signalRTransport) writeRecord(message interface{}) error {
+	record, err := signalr.EncodeRecord(message)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return t.conn.WriteMessage(websocket.TextMessage, record)
+}
+
+func (t *signalRTransport) WriteMessage(command YjsCommandType, data interface{}) error {
+	var argument string
+	switch v := data.(type) {
+	case SessionInfo:
+		// SignalR clients have no sessions; they sync again after reconnecting
+		return nil
+	case YjsMessage:
+		// The connector expects the message as a JSON string argument
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		argument = string(encoded)
+	case string:
+		argument = v
+	default:
+		return fmt.Errorf("unsupported SignalR argument: %T", data)
+	}
+
+	return t.writeRecord(signalr.NewInvocation(string(command), argument))
+}
+
+func (t *signalRTransport) WriteClose(code int, reason string) error {
+	allowReconnect := code == websocket.CloseServiceRestart || code == websocket.CloseTryAgainLater
+	if err := t.writeRecord(signalr.NewClose(reason, allowReconnect)); err != nil {
+		return err
+	}
+
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	return t.conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(writeTimeout))
+}
+
+func (t *signalRTransport) Close() error {
+	return t.conn.Close()
+}
+
+// allowCrossOrigin applies the WebSocket origin policy to plain HTTP requests of the
+// SignalR client and sets the CORS headers; returns false if the origin is not allowed
+func allowCrossOrigin(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	allowed := false
+	if upgrader.CheckOrigin != nil {
+		allowed = upgrader.CheckOrigin(r)
+	} else if u, err := url.Parse(origin); err == nil {
+		allowed = strings.EqualFold(u.Host, r.Host)
+	}
+	if !allowed {
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Add("Vary", "Origin")
+	return true
+}
+
+// handleSignalRNegotiate hands out a connection token and offers the WebSockets transport
+func handleSignalRNegotiate(w http.ResponseWriter, r *http.Request) {
+	if !allowCrossOrigin(w, r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	response := signalr.NegotiateResponse{
+		ConnectionID:        newSessionID(),
+		AvailableTransports: []signalr.AvailableTransport{signalr.WebSocketsTransport},
+	}
+	// Version 1 clients connect with the token, older clients with the connection ID
+	if version, err := strconv.Atoi(r.URL.Query().Get("negotiateVersion")); err == nil && version >= 1 {
+		response.NegotiateVersion = 1
+		response.ConnectionToken = newSessionID()
+		negotiations.add(response.ConnectionToken)
+	} else {
+		negotiations.add(response.ConnectionID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleSignalRHub serves the hub over WebSockets. Clients that skip negotiation
+// connect without a connection token.
+func handleSignalRHub(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		http.Error(w, "only the WebSockets transport is supported", http.StatusBadRequest)
+		return
+	}
+	if id := r.URL.Query().Get("id"); id != "" && !negotiations.take(id) {
+		http.Error(w, "no connection with that ID", http.StatusNotFound)
+		return
+	}
+
+	ycsManager := joinRoom(w, r)
+	if ycsManager == nil {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logWarnf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	if ycsRooms.limits.MaxMessageBytes > 0 {
+		conn.SetReadLimit(ycsRooms.limits.MaxMessageBytes)
+	}
+
+	transport := &signalRTransport{conn: conn}
+
+	conn.SetReadDeadline(time.Now().Add(signalRHandshakeTimeout))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		logDebugf("Error reading SignalR handshake: %v", err)
+		return
+	}
+	_, pending, err := signalr.ParseHandshake(data)
+	if err != nil {
+		rejectedMessages.WithLabelValues(rejectMalformed).Inc()
+		logWarnf("SignalR handshake failed: %v", err)
+		transport.writeRecord(signalr.HandshakeResponse{Error: err.Error()})
+		return
+	}
+	if err := transport.writeRecord(signalr.HandshakeResponse{}); err != nil {
+		logDebugf("Error writing SignalR handshake: %v", err)
+		return
+	}
+
+	client, connection, _ := ycsManager.HandleClientConnected("", -1, transport)
+	clientID := client.GetSessionID()
+	// SignalR clients cannot resume, so the session ends with the connection
+	defer ycsManager.HandleClientDisconnected(clientID)
+	defer ycsManager.HandleClientDetached(client, connection)
+
+	stopKeepAlive := make(chan struct{})
+	defer close(stopKeepAlive)
+	go keepSignalRAlive(transport, stopKeepAlive)
+
+	for {
+		records, rest := signalr.SplitRecords(pending)
+		for _, record := range records {
+			if !handleSignalRMessage(ycsManager, clientID, transport, record) {
+				return
+			}
+		}
+
+		conn.SetReadDeadline(time.Now().Add(signalRClientTimeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				rejectedMessages.WithLabelValues(rejectTooLarge).Inc()
+			}
+			logDebugf("Error reading message: %v", err)
+			return
+		}
+		// A message may span several frames, so the read limit alone does not bound it
+		if maxBytes := ycsRooms.limits.MaxMessageBytes; maxBytes > 0 && int64(len(rest)+len(data)) > maxBytes {
+			rejectedMessages.WithLabelValues(rejectTooLarge).Inc()
+			logWarnf("SignalR message of client %s exceeds %d bytes", clientID, maxBytes)
+			transport.writeRecord(signalr.NewClose("message too large", false))
+			return
+		}
+		pending = append(rest, data...)
+	}
+}
+
+// keepSignalRAlive pings the client until stop is closed
+func keepSignalRAlive(transport *signalRTransport, stop <-chan struct{}) {
+	ticker := time.NewTicker(signalRKeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := transport.writeRecord(signalr.NewPing()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleSignalRMessage handles one hub message; returns false if the client closes the connection
+func handleSignalRMessage(ycsManager *YcsManager, clientID string, transport *signalRTransport, record []byte) bool {
+	message, err := signalr.ParseMessage(record)
+	if err != nil {
+		rejectedMessages.WithLabelValues(rejectMalformed).Inc()
+		logWarnf("Invalid SignalR message: %v", err)
+		return true
+	}
+
+	switch message.Type {
+	case signalr.PingMessage:
+	case signalr.CloseMessage:
+		return false
+	case signalr.InvocationMessage:
+		err := invokeHubMethod(ycsManager, clientID, message)
+		if err != nil {
+			logErrorf("Error processing message: %v", err)
+		}
+		if message.InvocationID != "" {
+			errMessage := ""
+			if err != nil {
+				errMessage = err.Error()
+			}
+			transport.writeRecord(signalr.NewCompletion(message.InvocationID, errMessage))
+		}
+	case signalr.StreamInvocationMessage:
+		transport.writeRecord(signalr.NewCompletion(message.InvocationID, "streaming is not supported"))
+	default:
+		logDebugf("Ignoring SignalR message of type %d from %s", message.Type, clientID)
+	}
+	return true
+}
+
+// invokeHubMethod calls a method of the original YcsHub
+func invokeHubMethod(ycsManager *YcsManager, clientID string, message signalr.Message) error {
+	command := YjsCommandType(message.Target)
+	data, err := message.StringArgument(0)
+	if err != nil {
+		rejectedMessages.WithLabelValues(rejectMalformed).Inc()
+		return err
+	}
+
+	switch command {
+	case GetMissing, Update:
+		var yjsMessage YjsMessage
+		if err := json.Unmarshal([]byte(data), &yjsMessage); err != nil {
+			rejectedMessages.WithLabelValues(rejectMalformed).Inc()
+			return fmt.Errorf("parsing YJS message: %w", err)
+		}
+
+		logDebugf("Received %s message with clock %d from %s", command, yjsMessage.Clock, clientID)
+		return ycsManager.ProcessMessage(clientID, yjsMessage.Clock, &MessageToProcess{
+			Command:   command,
+			InReplyTo: yjsMessage.InReplyTo,
+			Data:      yjsMessage.Data,
+		})
+	case QueryAwareness, UpdateAwareness:
+		logDebugf("Received %s message from %s", command, clientID)
+		return ycsManager.ProcessAwareness(clientID, command, data)
+	default:
+		rejectedMessages.WithLabelValues(rejectUnknownType).Inc()
+		return fmt.Errorf("unknown hub method: %s", message.Target)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"ycs/bus"
+	"ycs/contracts"
+	"ycs/core"
+	"ycs/persistence"
+)
+
+// errUnknownSubdoc is returned for sync messages of a subdocument the room document does not contain
+var errUnknownSubdoc = errors.New("unknown subdocument")
+
+// roomSubdoc is a subdocument of the room document that clients sync. Clients sync
+// a subdocument over the connection of the room by tagging the sync messages with
+// its GUID, after the room document told them about it.
+type roomSubdoc struct {
+	guid       string
+	doc        *core.YDoc
+	replicator *bus.Replicator
+	unobserve  func()
+}
+
+// subdocKey returns the name under which a subdocument is stored and replicated
+func (ym *YcsManager) subdocKey(guid string) string {
+	return ym.room + "/" + guid
+}
+
+// getSubdoc returns the subdocument of the room document with the given GUID. The
+// first time a subdocument is requested, it is loaded from the store and attached to the bus.
+func (ym *YcsManager) getSubdoc(guid string) (*roomSubdoc, error) {
+	// The room document lock keeps the subdocument from being removed meanwhile
+	// and serializes loading it
+	ym.doc.Lock()
+	defer ym.doc.Unlock()
+
+	ym.mutex.RLock()
+	subdoc, exists := ym.subdocs[guid]
+	ym.mutex.RUnlock()
+	if exists {
+		return subdoc, nil
+	}
+
+	doc, ok := ym.doc.GetSubdoc(guid).(*core.YDoc)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownSubdoc, guid)
+	}
+	doc.Load()
+
+	subdoc = &roomSubdoc{guid: guid, doc: doc}
+	if ym.store != nil {
+		update, err := ym.store.Load(ym.subdocKey(guid))
+		if err != nil {
+			return nil, fmt.Errorf("loading subdocument %s: %w", guid, err)
+		}
+		if update != nil {
+			if err := doc.ApplyUpdateV2(update, ym.store, false); err != nil {
+				return nil, fmt.Errorf("loading subdocument %s: %w", guid, err)
+			}
+		}
+	}
+
+	subdoc.unobserve = doc.OnUpdateV2(func(update []byte, origin interface{}, transaction contracts.ITransaction) {
+		ym.broadcastSubdocUpdate(subdoc, update, origin)
+	})
+
+	if ym.bus != nil {
+		subdoc.replicator = bus.NewReplicator(ym.bus, ym.replica, ym.subdocKey(guid), doc, nil)
+		if err := subdoc.replicator.Start(); err != nil {
+			subdoc.unobserve()
+			return nil, fmt.Errorf("attaching subdocument %s to bus: %w", guid, err)
+		}
+	}
+
+	ym.mutex.Lock()
+	ym.subdocs[guid] = subdoc
+	ym.mutex.Unlock()
+
+	logDebugf("Subdocument %s of room %s loaded", guid, ym.room)
+	return subdoc, nil
+}
+
+// broadcastSubdocUpdate relays an update of a subdocument to the replicas and to the
+// clients that sync it
+func (ym *YcsManager) broadcastSubdocUpdate(subdoc *roomSubdoc, update []byte, origin interface{}) {
+	if len(update) == 0 {
+		return
+	}
+
+	if subdoc.replicator != nil {
+		if err := subdoc.replicator.Publish(update, origin); err != nil {
+			logErrorf("Error publishing update of subdocument %s: %v", subdoc.guid, err)
+		}
+	}
+
+	ym.mutex.RLock()
+	clients := make([]*ClientContext, 0, len(ym.clients))
+	for _, client := range ym.clients {
+		if receivesUpdates, _ := client.GetSubdocState(subdoc.guid); receivesUpdates {
+			clients = append(clients, client)
+		}
+	}
+	ym.mutex.RUnlock()
+
+	for _, client := range clients {
+		client.SendSubdoc(subdoc.guid, Update, nil, update)
+	}
+}
+
+// handleSubdocsChanged forgets the subdocuments that were removed from the room document
+func (ym *YcsManager) handleSubdocsChanged(loaded, added, removed map[contracts.IYDoc]struct{}) {
+	for doc := range removed {
+		ym.mutex.Lock()
+		subdoc, exists := ym.subdocs[doc.GetGuid()]
+		if exists && contracts.IYDoc(subdoc.doc) == doc {
+			delete(ym.subdocs, subdoc.guid)
+		}
+		clients := make([]*ClientContext, 0, len(ym.clients))
+		for _, client := range ym.clients {
+			clients = append(clients, client)
+		}
+		ym.mutex.Unlock()
+
+		if !exists || contracts.IYDoc(subdoc.doc) != doc {
+			continue
+		}
+		subdoc.close()
+		for _, client := range clients {
+			client.DropSubdoc(subdoc.guid)
+		}
+		logDebugf("Subdocument %s of room %s removed", subdoc.guid, ym.room)
+	}
+}
+
+// close stops relaying the updates of the subdocument
+func (subdoc *roomSubdoc) close() {
+	subdoc.unobserve()
+	if subdoc.replicator != nil {
+		subdoc.replicator.Close()
+	}
+}
+
+// closeSubdocs stops relaying the updates of all subdocuments
+func (ym *YcsManager) closeSubdocs() {
+	ym.mutex.Lock()
+	subdocs := ym.subdocs
+	ym.subdocs = make(map[string]*roomSubdoc)
+	ym.mutex.Unlock()
+
+	for _, subdoc := range subdocs {
+		subdoc.close()
+	}
+}
+
+// saveSubdocs stores every loaded subdocument
+func (ym *YcsManager) saveSubdocs(store persistence.Store) error {
+	ym.mutex.RLock()
+	subdocs := make([]*roomSubdoc, 0, len(ym.subdocs))
+	for _, subdoc := range ym.subdocs {
+		subdocs = append(subdocs, subdoc)
+	}
+	ym.mutex.RUnlock()
+
+	for _, subdoc := range subdocs {
+		var update []byte
+		var err error
+		subdoc.doc.View(func() {
+			update, err = subdoc.doc.EncodeStateAsUpdateV2(nil)
+		})
+		if err != nil {
+			return fmt.Errorf("encoding subdocument %s: %w", subdoc.guid, err)
+		}
+		if err := store.Save(ym.subdocKey(subdoc.guid), update); err != nil {
+			return fmt.Errorf("saving subdocument %s: %w", subdoc.guid, err)
+		}
+	}
+	return nil
+}
+
+// handleSubdocGetMissing answers the state vector of a subdocument like handleGetMissing
+func (ym *YcsManager) handleSubdocGetMissing(client *ClientContext, message *MessageToProcess) error {
+	decodedStateVector, err := base64.StdEncoding.DecodeString(message.Data)
+	if err != nil {
+		rejectedMessages.WithLabelValues(rejectInvalidData).Inc()
+		return err
+	}
+
+	subdoc, err := ym.getSubdoc(message.Guid)
+	if err != nil {
+		if errors.Is(err, errUnknownSubdoc) {
+			rejectedMessages.WithLabelValues(rejectUnknownSubdoc).Inc()
+		}
+		return err
+	}
+
+	subdoc.doc.Lock()
+	defer subdoc.doc.Unlock()
+
+	update, err := subdoc.doc.EncodeStateAsUpdateV2(decodedStateVector)
+	if err != nil {
+		rejectedMessages.WithLabelValues(rejectInvalidData).Inc()
+		return err
+	}
+	stateVector := subdoc.doc.EncodeStateVectorV2()
+
+	getMissingType := GetMissing
+	if !client.SendSubdoc(subdoc.guid, Update, &getMissingType, update) ||
+		!client.SendSubdoc(subdoc.guid, GetMissing, &getMissingType, stateVector) {
+		return errClientClosed
+	}
+
+	// Updates made from now on are not in the reply, as in handleGetMissing
+	if receivesUpdates, _ := client.GetSubdocState(subdoc.guid); !receivesUpdates {
+		client.SetSubdocState(subdoc.guid, false)
+	}
+	return nil
+}
+
+// handleSubdocUpdate applies an update of a subdocument like handleUpdate
+func (ym *YcsManager) handleSubdocUpdate(client *ClientContext, message *MessageToProcess) error {
+	syncReply := message.InReplyTo != nil && *message.InReplyTo == GetMissing
+	if _, synced := client.GetSubdocState(message.Guid); !synced && !syncReply {
+		rejectedMessages.WithLabelValues(rejectUnsynced).Inc()
+		return nil
+	}
+
+	update, err := base64.StdEncoding.DecodeString(message.Data)
+	if err != nil {
+		rejectedMessages.WithLabelValues(rejectInvalidData).Inc()
+		return err
+	}
+	updateBytes.WithLabelValues(directionIn).Add(float64(len(update)))
+
+	subdoc, err := ym.getSubdoc(message.Guid)
+	if err != nil {
+		if errors.Is(err, errUnknownSubdoc) {
+			rejectedMessages.WithLabelValues(rejectUnknownSubdoc).Inc()
+		}
+		return err
+	}
+
+	subdoc.doc.Lock()
+	start := time.Now()
+	err = subdoc.doc.ApplyUpdateV2(update, "websocket", false)
+	updateApplySeconds.WithLabelValues().Observe(time.Since(start).Seconds())
+	subdoc.doc.Unlock()
+
+	if err != nil {
+		rejectedMessages.WithLabelValues(rejectInvalidData).Inc()
+		return err
+	}
+
+	if syncReply {
+		client.SetSubdocState(message.Guid, true)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"ycs/history"
+)
+
+// checkpointRequest is the body of requests creating or restoring a checkpoint
+type checkpointRequest struct {
+	Name   string `json:"name"`
+	Author string `json:"author"`
+}
+
+// roomHistory returns the checkpoint recorder of the room named in the request, or
+// writes an error response and returns nil
+func roomHistory(w http.ResponseWriter, r *http.Request) *history.Recorder {
+	if !ycsRooms.HasHistory() {
+		http.Error(w, "version history is disabled", http.StatusNotFound)
+		return nil
+	}
+
+	ycsManager := getRoom(w, r)
+	if ycsManager == nil {
+		return nil
+	}
+	return ycsManager.GetHistory()
+}
+
+// existingRoomHistory is like roomHistory, but only loads rooms that are loaded
+// already or have stored checkpoints, so that reading the history of an unknown room
+// neither creates it nor counts against the room limit
+func existingRoomHistory(w http.ResponseWriter, r *http.Request) *history.Recorder {
+	if !ycsRooms.HasHistory() {
+		http.Error(w, "version history is disabled", http.StatusNotFound)
+		return nil
+	}
+
+	room := requestRoom(r)
+	if _, exists := ycsRooms.Lookup(room); !exists {
+		checkpoints, err := ycsRooms.ListCheckpoints(room)
+		if err != nil {
+			writeHistoryResponse(w, r, http.StatusOK, nil, err)
+			return nil
+		}
+		if len(checkpoints) == 0 {
+			http.Error(w, "room not found", http.StatusNotFound)
+			return nil
+		}
+	}
+	return roomHistory(w, r)
+}
+
+// readCheckpointRequest decodes the optional JSON body of a request
+func readCheckpointRequest(w http.ResponseWriter, r *http.Request) (*checkpointRequest, bool) {
+	var request checkpointRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	return &request, true
+}
+
+// writeHistoryResponse writes value as JSON, or the error as a response
+func writeHistoryResponse(w http.ResponseWriter, r *http.Request, status int, value interface{}, err error) {
+	if errors.Is(err, history.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, history.ErrInvalidRoom) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logErrorf("Error serving %s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, "version history unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// handleListCheckpoints lists the checkpoints of a room, oldest first. They are read
+// from the store, so the room is not loaded; unknown rooms are not found.
+func handleListCheckpoints(w http.ResponseWriter, r *http.Request) {
+	if !ycsRooms.HasHistory() {
+		http.Error(w, "version history is disabled", http.StatusNotFound)
+		return
+	}
+
+	room := requestRoom(r)
+	checkpoints, err := ycsRooms.ListCheckpoints(room)
+	if _, exists := ycsRooms.Lookup(room); err == nil && len(checkpoints) == 0 && !exists {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return
+	}
+	if checkpoints == nil {
+		checkpoints = make([]history.Checkpoint, 0)
+	}
+	writeHistoryResponse(w, r, http.StatusOK, checkpoints, err)
+}
+
+// handleCreateCheckpoint creates a named checkpoint of a room
+func handleCreateCheckpoint(w http.ResponseWriter, r *http.Request) {
+	recorder := roomHistory(w, r)
+	if recorder == nil {
+		return
+	}
+	request, ok := readCheckpointRequest(w, r)
+	if !ok {
+		return
+	}
+
+	checkpoint, err := recorder.Checkpoint(request.Name, request.Author)
+	writeHistoryResponse(w, r, http.StatusCreated, checkpoint, err)
+}
+
+// handleDiffCheckpoints compares the checkpoints named by the from and to query
+// parameters; without to, the checkpoint is compared with the current document
+func handleDiffCheckpoints(w http.ResponseWriter, r *http.Request) {
+	recorder := existingRoomHistory(w, r)
+	if recorder == nil {
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("from") == "" {
+		http.Error(w, "from is required", http.StatusBadRequest)
+		return
+	}
+
+	diff, err := recorder.Diff(query.Get("from"), query.Get("to"))
+	writeHistoryResponse(w, r, http.StatusOK, diff, err)
+}
+
+// handleRestoreCheckpoint changes a room back to a checkpoint and returns the
+// checkpoint created of the document before
+func handleRestoreCheckpoint(w http.ResponseWriter, r *http.Request) {
+	recorder := roomHistory(w, r)
+	if recorder == nil {
+		return
+	}
+	request, ok := readCheckpointRequest(w, r)
+	if !ok {
+		return
+	}
+
+	before, err := recorder.Restore(mux.Vars(r)["id"], request.Author)
+	writeHistoryResponse(w, r, http.StatusOK, before, err)
+}
@@ -0,0 +1,284 @@
+// Package ycs provides typed Go access to the shared types of a document.
+package ycs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+
+	"ycs/core"
+)
+
+// ErrTypeMismatch is returned, wrapped, when a stored value cannot be converted to the requested type
+var ErrTypeMismatch = errors.New("ycs: type mismatch")
+
+// Codec converts between Go values of type T and the values stored in shared types.
+// Decode must not panic on values written by other peers; it returns an error
+// wrapping ErrTypeMismatch instead.
+type Codec[T any] interface {
+	Encode(value T) (interface{}, error)
+	Decode(stored interface{}) (T, error)
+}
+
+// mismatch returns the error for a stored value that is not a T
+func mismatch[T any](stored interface{}) error {
+	return fmt.Errorf("%w: cannot use %T as %s", ErrTypeMismatch, stored, reflect.TypeFor[T]())
+}
+
+// ValueCodec returns the codec of plain values: strings, booleans, numbers, byte
+// slices and the []interface{} and map[string]interface{} values of JSON. Numbers
+// are stored as int64 or float64, which is how they decode from updates, and decode
+// to any numeric type that holds them exactly. Null decodes to nil for interface{},
+// slices and maps.
+func ValueCodec[T any]() Codec[T] {
+	return valueCodec[T]{}
+}
+
+type valueCodec[T any] struct{}
+
+func (valueCodec[T]) Encode(value T) (interface{}, error) {
+	return encodeValue(reflect.ValueOf(&value).Elem())
+}
+
+func (valueCodec[T]) Decode(stored interface{}) (T, error) {
+	var value T
+	if v, ok := stored.(T); ok {
+		return v, nil
+	}
+	if err := decodeValue(stored, reflect.ValueOf(&value).Elem()); err != nil {
+		return value, err
+	}
+	return value, nil
+}
+
+// encodeValue encodes a value as ValueCodec does
+func encodeValue(v reflect.Value) (interface{}, error) {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("ycs: %d does not fit into an int64", v.Uint())
+		}
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return v.Bool(), nil
+	}
+
+	switch stored := v.Interface().(type) {
+	case []byte, []interface{}, map[string]interface{}:
+		return stored, nil
+	}
+	return nil, fmt.Errorf("ycs: cannot store %s, use JSONCodec", v.Type())
+}
+
+// decodeValue decodes a stored value into target as ValueCodec does
+func decodeValue(stored interface{}, target reflect.Value) error {
+	mismatch := func() error {
+		return fmt.Errorf("%w: cannot use %T as %s", ErrTypeMismatch, stored, target.Type())
+	}
+	if stored == nil {
+		// Null decodes to the zero value of the types that have nil
+		switch target.Kind() {
+		case reflect.Interface, reflect.Map, reflect.Slice, reflect.Pointer:
+			target.Set(reflect.Zero(target.Type()))
+			return nil
+		}
+		return mismatch()
+	}
+
+	source := reflect.ValueOf(stored)
+	if source.Type().AssignableTo(target.Type()) {
+		target.Set(source)
+		return nil
+	}
+
+	switch target.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := exactInt(source)
+		if !ok || target.OverflowInt(n) {
+			return mismatch()
+		}
+		target.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := exactInt(source)
+		if !ok || n < 0 || target.OverflowUint(uint64(n)) {
+			return mismatch()
+		}
+		target.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		switch source.Kind() {
+		case reflect.Float32, reflect.Float64:
+			target.SetFloat(source.Float())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			target.SetFloat(float64(source.Int()))
+		default:
+			return mismatch()
+		}
+	case reflect.String, reflect.Bool:
+		// Named string and bool types
+		if source.Kind() != target.Kind() {
+			return mismatch()
+		}
+		target.Set(source.Convert(target.Type()))
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+// exactInt returns the integer a stored number holds, if it holds one exactly
+func exactInt(source reflect.Value) (int64, bool) {
+	switch source.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return source.Int(), true
+	case reflect.Float32, reflect.Float64:
+		f := source.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+	return 0, false
+}
+
+// JSONCodec returns a codec that stores values as their JSON representation, for
+// structs and other values that ValueCodec cannot store. Values are stored as plain
+// maps and arrays, not as shared types, so they are replaced as a whole on every change.
+func JSONCodec[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+type jsonCodec[T any] struct{}
+
+func (jsonCodec[T]) Encode(value T) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored interface{}
+	err = json.Unmarshal(data, &stored)
+	return stored, err
+}
+
+func (jsonCodec[T]) Decode(stored interface{}) (T, error) {
+	var value T
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return value, fmt.Errorf("%w: %v", ErrTypeMismatch, err)
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return value, fmt.Errorf("%w: %v", ErrTypeMismatch, err)
+	}
+	return value, nil
+}
+
+// MapCodec returns the codec of nested maps whose values use the given codec, e.g.
+// for a TypedMap[*TypedMap[V]]. A nested map is stored by setting a map created with NewMap.
+// A nil map is stored as null, and null decodes to nil.
+func MapCodec[V any](codec Codec[V]) Codec[*TypedMap[V]] {
+	return mapCodec[V]{codec: codec}
+}
+
+type mapCodec[V any] struct {
+	codec Codec[V]
+}
+
+func (c mapCodec[V]) Encode(value *TypedMap[V]) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if value.ymap.GetDoc() != nil {
+		return nil, errIntegrated
+	}
+	return value.ymap, nil
+}
+
+func (c mapCodec[V]) Decode(stored interface{}) (*TypedMap[V], error) {
+	if stored == nil {
+		return nil, nil
+	}
+	ymap, ok := stored.(*core.YMap)
+	if !ok {
+		return nil, mismatch[*TypedMap[V]](stored)
+	}
+	return NewTypedMap(ymap, c.codec), nil
+}
+
+// ArrayCodec returns the codec of nested arrays whose elements use the given codec.
+// A nested array is stored by setting an array created with NewArray. A nil array is
+// stored as null, and null decodes to nil.
+func ArrayCodec[T any](codec Codec[T]) Codec[*TypedArray[T]] {
+	return arrayCodec[T]{codec: codec}
+}
+
+type arrayCodec[T any] struct {
+	codec Codec[T]
+}
+
+func (c arrayCodec[T]) Encode(value *TypedArray[T]) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if value.yarray.GetDoc() != nil {
+		return nil, errIntegrated
+	}
+	return value.yarray, nil
+}
+
+func (c arrayCodec[T]) Decode(stored interface{}) (*TypedArray[T], error) {
+	if stored == nil {
+		return nil, nil
+	}
+	yarray, ok := stored.(*core.YArray)
+	if !ok {
+		return nil, mismatch[*TypedArray[T]](stored)
+	}
+	return NewTypedArray(yarray, c.codec), nil
+}
+
+// TextCodec returns the codec of nested texts. A nested text is stored by setting
+// a text created with core.NewYText. A nil text is stored as null, and null decodes to nil.
+func TextCodec() Codec[*core.YText] {
+	return textCodec{}
+}
+
+type textCodec struct{}
+
+func (textCodec) Encode(value *core.YText) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if value.GetDoc() != nil {
+		return nil, errIntegrated
+	}
+	return value, nil
+}
+
+func (textCodec) Decode(stored interface{}) (*core.YText, error) {
+	if stored == nil {
+		return nil, nil
+	}
+	text, ok := stored.(*core.YText)
+	if !ok {
+		return nil, mismatch[*core.YText](stored)
+	}
+	return text, nil
+}
+
+// errIntegrated is returned when a shared type that is already part of a document is stored again
+var errIntegrated = errors.New("ycs: the shared type is already part of a document")
@@ -0,0 +1,160 @@
+package ycs
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"ycs/contracts"
+	"ycs/core"
+)
+
+func TestValueCodecNumbers(t *testing.T) {
+	stored, err := ValueCodec[uint8]().Encode(200)
+	if err != nil || stored != int64(200) {
+		t.Fatalf("got %#v, %v", stored, err)
+	}
+	if _, err := ValueCodec[uint64]().Encode(1 << 63); err == nil {
+		t.Fatal("no error for a uint64 that does not fit into an int64")
+	}
+
+	if n, err := ValueCodec[int32]().Decode(float64(7)); err != nil || n != 7 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if f, err := ValueCodec[float64]().Decode(int64(3)); err != nil || f != 3 {
+		t.Fatalf("got %v, %v", f, err)
+	}
+	for _, stored := range []interface{}{7.5, int64(300), "7", nil} {
+		if _, err := ValueCodec[uint8]().Decode(stored); !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("got %v for %#v, want ErrTypeMismatch", err, stored)
+		}
+	}
+}
+
+func TestValueCodecNamedTypes(t *testing.T) {
+	type color string
+	stored, err := ValueCodec[color]().Encode("red")
+	if err != nil || stored != "red" {
+		t.Fatalf("got %#v, %v", stored, err)
+	}
+	if c, err := ValueCodec[color]().Decode("blue"); err != nil || c != "blue" {
+		t.Fatalf("got %q, %v", c, err)
+	}
+	if _, err := ValueCodec[struct{ A int }]().Encode(struct{ A int }{1}); err == nil {
+		t.Fatal("no error for a struct")
+	}
+}
+
+func TestValueCodecNull(t *testing.T) {
+	if v, err := ValueCodec[interface{}]().Decode(nil); err != nil || v != nil {
+		t.Fatalf("got %#v, %v", v, err)
+	}
+	if v, err := ValueCodec[[]interface{}]().Decode(nil); err != nil || v != nil {
+		t.Fatalf("got %#v, %v", v, err)
+	}
+	if stored, err := ValueCodec[interface{}]().Encode(nil); err != nil || stored != nil {
+		t.Fatalf("got %#v, %v", stored, err)
+	}
+	if _, err := ValueCodec[string]().Decode(nil); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("got %v, want ErrTypeMismatch", err)
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	type point struct {
+		X, Y int
+	}
+	codec := JSONCodec[point]()
+	stored, err := codec.Encode(point{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"X": float64(1), "Y": float64(2)}; !reflect.DeepEqual(stored, want) {
+		t.Fatalf("got %#v, want %#v", stored, want)
+	}
+	if p, err := codec.Decode(map[string]interface{}{"X": int64(3), "Y": 4.0}); err != nil || p != (point{3, 4}) {
+		t.Fatalf("got %+v, %v", p, err)
+	}
+	if _, err := codec.Decode("not a point"); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("got %v, want ErrTypeMismatch", err)
+	}
+}
+
+func TestNestedCodecs(t *testing.T) {
+	doc := core.NewYDoc(contracts.YDocOptions{})
+	root := NewTypedMap(doc.GetMap("root").(*core.YMap), MapCodec[int](nil))
+
+	nested := NewMap[int](nil)
+	if err := root.Set("nested", nested); err != nil {
+		t.Fatal(err)
+	}
+	if err := nested.Set("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Set("again", nested); err == nil {
+		t.Fatal("no error for a map that is already part of the document")
+	}
+
+	got, ok, err := root.Get("nested")
+	if err != nil || !ok {
+		t.Fatalf("got %v, %v", ok, err)
+	}
+	if a, _, err := got.Get("a"); err != nil || a != 1 {
+		t.Fatalf("got %d, %v", a, err)
+	}
+
+	doc.GetMap("root").Set("text", "plain")
+	if _, _, err := root.Get("text"); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("got %v, want ErrTypeMismatch", err)
+	}
+
+	lists := NewTypedArray(doc.GetArray("lists").(*core.YArray), ArrayCodec[string](nil))
+	list := NewArray[string](nil)
+	if err := lists.Add(list); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Add("x", "y"); err != nil {
+		t.Fatal(err)
+	}
+	first, _, err := lists.Get(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values, err := first.ToSlice(); err != nil || !reflect.DeepEqual(values, []string{"x", "y"}) {
+		t.Fatalf("got %v, %v", values, err)
+	}
+
+	texts := NewTypedArray(doc.GetArray("texts").(*core.YArray), TextCodec())
+	if err := texts.Add(core.NewYText("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if text, _, err := texts.Get(0); err != nil || text.ToString() != "hello" {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestNestedCodecsNull(t *testing.T) {
+	doc := core.NewYDoc(contracts.YDocOptions{})
+	maps := NewTypedMap(doc.GetMap("maps").(*core.YMap), MapCodec[int](nil))
+	if err := maps.Set("none", nil); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok, err := maps.Get("none"); err != nil || !ok || m != nil {
+		t.Fatalf("got %v, %v, %v", m, ok, err)
+	}
+
+	arrays := NewTypedArray(doc.GetArray("arrays").(*core.YArray), ArrayCodec[int](nil))
+	if err := arrays.Add(nil); err != nil {
+		t.Fatal(err)
+	}
+	if a, _, err := arrays.Get(0); err != nil || a != nil {
+		t.Fatalf("got %v, %v", a, err)
+	}
+
+	if stored, err := TextCodec().Encode(nil); err != nil || stored != nil {
+		t.Fatalf("got %#v, %v", stored, err)
+	}
+	if text, err := TextCodec().Decode(nil); err != nil || text != nil {
+		t.Fatalf("got %v, %v", text, err)
+	}
+}
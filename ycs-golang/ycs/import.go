@@ -0,0 +1,257 @@
+package ycs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"ycs/contracts"
+	"ycs/core"
+)
+
+// Kind is the kind of shared type a JSON value is imported as
+type Kind int
+
+const (
+	// KindValue imports a value as a plain value, replaced as a whole on every change
+	KindValue Kind = iota
+	// KindMap imports an object as a YMap
+	KindMap
+	// KindArray imports an array as a YArray
+	KindArray
+	// KindText imports a string, or a delta as YText.ToJSON renders it, as a YText
+	KindText
+)
+
+// Schema tells ImportJSON which values of a JSON document become which shared types
+type Schema struct {
+	Kind Kind
+	// Properties are the schemas of the entries of a KindMap; entries without one are
+	// imported as plain values
+	Properties map[string]*Schema
+	// Items is the schema of the elements of a KindArray; without one they are
+	// imported as plain values
+	Items *Schema
+}
+
+// ImportJSON builds a new document from a JSON object, e.g. one written from
+// YDoc.ToJSON. Every entry of the object becomes a root type of the kind its schema
+// gives. Entries without a schema become a YMap for an object, a YArray for an array
+// and a YText for a string. The document is built in one transaction.
+func ImportJSON(data []byte, schema map[string]*Schema, opts contracts.YDocOptions) (*core.YDoc, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, fmt.Errorf("ycs: decoding JSON: %w", err)
+	}
+
+	// Check the whole document before building it
+	names := make([]string, 0, len(object))
+	schemas := make(map[string]*Schema, len(object))
+	for name, value := range object {
+		s := schema[name]
+		if s == nil {
+			s = inferSchema(value)
+		}
+		if s.Kind == KindValue {
+			return nil, fmt.Errorf("%s: %w: cannot import %T as a root type", name, ErrTypeMismatch, value)
+		}
+		if err := checkSchema(value, s); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		names = append(names, name)
+		schemas[name] = s
+	}
+	// Root types are created in a stable order so that equal documents produce equal updates
+	sort.Strings(names)
+
+	doc := core.NewYDoc(opts)
+	doc.Transact(func(tr contracts.ITransaction) {
+		for _, name := range names {
+			value, s := object[name], schemas[name]
+			switch s.Kind {
+			case KindMap:
+				importMap(value.(map[string]interface{}), s, doc.GetMap(name).(*core.YMap))
+			case KindArray:
+				importArray(value.([]interface{}), s, doc.GetArray(name).(*core.YArray))
+			case KindText:
+				importText(value, doc.GetText(name).(*core.YText))
+			}
+		}
+	}, nil)
+	return doc, nil
+}
+
+// inferSchema returns the schema of a root value that has none
+func inferSchema(value interface{}) *Schema {
+	switch value.(type) {
+	case map[string]interface{}:
+		return &Schema{Kind: KindMap}
+	case []interface{}:
+		return &Schema{Kind: KindArray}
+	case string:
+		return &Schema{Kind: KindText}
+	}
+	// Other values cannot be root types
+	return &Schema{Kind: KindValue}
+}
+
+// checkSchema returns an error if the value cannot be imported with the schema
+func checkSchema(value interface{}, s *Schema) error {
+	switch s.Kind {
+	case KindValue:
+		return nil
+	case KindMap:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%w: expected an object, got %T", ErrTypeMismatch, value)
+		}
+		for key, s := range s.Properties {
+			if entry, ok := object[key]; ok && s != nil {
+				if err := checkSchema(entry, s); err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+			}
+		}
+	case KindArray:
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%w: expected an array, got %T", ErrTypeMismatch, value)
+		}
+		if s.Items != nil {
+			for i, element := range array {
+				if err := checkSchema(element, s.Items); err != nil {
+					return fmt.Errorf("index %d: %w", i, err)
+				}
+			}
+		}
+	case KindText:
+		if _, err := textDelta(value); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("ycs: unknown kind %d", s.Kind)
+	}
+	return nil
+}
+
+// textDelta returns the delta that builds the text of a string or a JSON delta
+func textDelta(value interface{}) ([]contracts.Delta, error) {
+	switch v := value.(type) {
+	case string:
+		return []contracts.Delta{{Insert: v}}, nil
+	case []interface{}:
+		delta := make([]contracts.Delta, 0, len(v))
+		for i, element := range v {
+			op, ok := element.(map[string]interface{})
+			insert, hasInsert := op["insert"]
+			if !ok || !hasInsert {
+				return nil, fmt.Errorf("%w: operation %d of the delta is not an insert", ErrTypeMismatch, i)
+			}
+			d := contracts.Delta{Insert: importValue(insert)}
+			if attributes, ok := op["attributes"].(map[string]interface{}); ok {
+				d.Attributes = importValue(attributes).(map[string]interface{})
+			}
+			delta = append(delta, d)
+		}
+		return delta, nil
+	}
+	return nil, fmt.Errorf("%w: expected a string or a delta, got %T", ErrTypeMismatch, value)
+}
+
+// importMap stores the entries of an object in the map
+func importMap(object map[string]interface{}, s *Schema, ymap *core.YMap) {
+	for _, key := range sortedKeys(object) {
+		entry, entrySchema := object[key], s.Properties[key]
+		if entrySchema == nil || entrySchema.Kind == KindValue {
+			ymap.Set(key, importValue(entry))
+			continue
+		}
+		importShared(entry, entrySchema, func(shared interface{}) {
+			ymap.Set(key, shared)
+		})
+	}
+}
+
+// importArray stores the elements of an array in the array
+func importArray(array []interface{}, s *Schema, yarray *core.YArray) {
+	if s.Items == nil || s.Items.Kind == KindValue {
+		elements := make([]interface{}, len(array))
+		for i, element := range array {
+			elements[i] = importValue(element)
+		}
+		yarray.Add(elements)
+		return
+	}
+
+	for _, element := range array {
+		importShared(element, s.Items, func(shared interface{}) {
+			yarray.Add([]interface{}{shared})
+		})
+	}
+}
+
+// importShared creates the shared type of a value, stores it with store and then
+// fills it, so that its content is added to the document
+func importShared(value interface{}, s *Schema, store func(shared interface{})) {
+	switch s.Kind {
+	case KindMap:
+		ymap := core.NewYMap(nil)
+		store(ymap)
+		importMap(value.(map[string]interface{}), s, ymap)
+	case KindArray:
+		yarray := core.NewYArray(nil)
+		store(yarray)
+		importArray(value.([]interface{}), s, yarray)
+	case KindText:
+		text := core.NewYText("")
+		store(text)
+		importText(value, text)
+	}
+}
+
+// importText appends a string or a JSON delta to the text
+func importText(value interface{}, text *core.YText) {
+	// checkSchema checked the delta
+	delta, _ := textDelta(value)
+	text.ApplyDelta(delta)
+}
+
+// importValue converts a decoded JSON value to a stored value: numbers become int64
+// if they are integers and float64 otherwise, as they decode from updates
+func importValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, element := range v {
+			result[i] = importValue(element)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			result[key] = importValue(element)
+		}
+		return result
+	}
+	return value
+}
+
+// sortedKeys returns the keys of m in ascending order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,102 @@
+package ycs
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"ycs/contracts"
+	"ycs/core"
+)
+
+func TestImportJSONInfersRootTypes(t *testing.T) {
+	data := `{"settings":{"size":12,"ratio":1.5,"tags":["a"]},"items":[1,"two",null],"title":"Hello"}`
+	doc, err := ImportJSON([]byte(data), nil, contracts.YDocOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"settings": map[string]interface{}{"size": int64(12), "ratio": 1.5, "tags": []interface{}{"a"}},
+		"items":    []interface{}{int64(1), "two", nil},
+		"title":    "Hello",
+	}
+	if got := doc.ToJSON(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+	if _, ok := doc.GetText("title").(*core.YText); !ok {
+		t.Fatal("title is not a YText")
+	}
+}
+
+func TestImportJSONWithSchema(t *testing.T) {
+	data := `{"board":{"cards":[{"title":"a","body":"text"}],"name":"plain"}}`
+	schema := map[string]*Schema{
+		"board": {Kind: KindMap, Properties: map[string]*Schema{
+			"cards": {Kind: KindArray, Items: &Schema{Kind: KindMap, Properties: map[string]*Schema{
+				"body": {Kind: KindText},
+			}}},
+		}},
+	}
+	doc, err := ImportJSON([]byte(data), schema, contracts.YDocOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	board := doc.GetMap("board")
+	cards, ok := board.Get("cards").(*core.YArray)
+	if !ok {
+		t.Fatalf("cards is %T", board.Get("cards"))
+	}
+	card, ok := cards.Get(0).(*core.YMap)
+	if !ok {
+		t.Fatalf("card is %T", cards.Get(0))
+	}
+	if body, ok := card.Get("body").(*core.YText); !ok || body.ToString() != "text" {
+		t.Fatalf("body is %#v", card.Get("body"))
+	}
+	if card.Get("title") != "a" || board.Get("name") != "plain" {
+		t.Fatalf("got %v", doc.ToJSON())
+	}
+}
+
+func TestImportJSONRoundTripsDeltas(t *testing.T) {
+	doc := core.NewYDoc(contracts.YDocOptions{})
+	text := doc.GetText("text")
+	text.Insert(0, "hello world")
+	text.Format(0, 5, map[string]interface{}{"bold": true})
+	text.InsertEmbed(11, map[string]interface{}{"image": "a.png"})
+
+	data, err := json.Marshal(doc.ToJSON(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A delta is an array, so it needs a schema to become a text again
+	imported, err := ImportJSON(data, map[string]*Schema{"text": {Kind: KindText}}, contracts.YDocOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := imported.GetText("text").ToJSON(true), text.ToJSON(true); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestImportJSONErrors(t *testing.T) {
+	for _, test := range []struct {
+		data   string
+		schema map[string]*Schema
+	}{
+		{data: `{"count":1}`},
+		{data: `{"text":"a"}`, schema: map[string]*Schema{"text": {Kind: KindMap}}},
+		{data: `{"m":{"t":1}}`, schema: map[string]*Schema{"m": {Kind: KindMap, Properties: map[string]*Schema{"t": {Kind: KindText}}}}},
+		{data: `{"delta":[{"retain":1}]}`, schema: map[string]*Schema{"delta": {Kind: KindText}}},
+	} {
+		if _, err := ImportJSON([]byte(test.data), test.schema, contracts.YDocOptions{}); !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("got %v for %s, want ErrTypeMismatch", err, test.data)
+		}
+	}
+	if _, err := ImportJSON([]byte(`[1]`), nil, contracts.YDocOptions{}); err == nil {
+		t.Error("no error for a document that is not an object")
+	}
+}
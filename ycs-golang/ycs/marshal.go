@@ -0,0 +1,587 @@
+package ycs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"ycs/contracts"
+	"ycs/core"
+)
+
+// errNotIntegrated is returned when marshalling into a map that is not part of a document
+var errNotIntegrated = errors.New("ycs: the map is not part of a document")
+
+// structField is a field of a struct stored under a key of a map
+type structField struct {
+	name  string
+	key   string
+	index []int
+	// text stores a string field as a YText
+	text bool
+	// json stores the field as its JSON representation
+	json      bool
+	omitEmpty bool
+}
+
+var (
+	structFieldsCache sync.Map // map[reflect.Type][]structField
+
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	byteSliceType     = reflect.TypeFor[[]byte]()
+)
+
+// structFields returns the stored fields of a struct type. A field is stored under
+// the name of its ycs tag, or under its own name if it has none. The tag options are
+// "text", to store a string as a YText, "json", to store the field as its JSON
+// representation, and "omitempty", to delete the key of a zero value. Fields tagged
+// "-" and unexported fields are not stored; the fields of embedded structs without
+// a tag are stored as if they were fields of the outer struct.
+func structFields(t reflect.Type) []structField {
+	if fields, ok := structFieldsCache.Load(t); ok {
+		return fields.([]structField)
+	}
+
+	var fields []structField
+	for _, f := range reflect.VisibleFields(t) {
+		tag, tagged := f.Tag.Lookup("ycs")
+		if tag == "-" || !f.IsExported() {
+			continue
+		}
+		if f.Anonymous && !tagged && f.Type.Kind() == reflect.Struct {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		field := structField{name: f.Name, key: name, index: f.Index}
+		if field.key == "" {
+			field.key = f.Name
+		}
+		for _, option := range strings.Split(options, ",") {
+			switch option {
+			case "text":
+				field.text = true
+			case "json":
+				field.json = true
+			case "omitempty":
+				field.omitEmpty = true
+			}
+		}
+		fields = append(fields, field)
+	}
+
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+// Marshal stores the fields of a struct, or of a pointer to one, in a map of a
+// document. Nested structs and maps with string keys are stored as nested YMaps,
+// slices and arrays as YArrays and strings tagged "text" as YTexts. Other values are
+// stored as ValueCodec stores them, or as JSONCodec does for fields tagged "json" and
+// types that implement json.Marshaler.
+//
+// Marshal changes only what differs from the stored state, so that marshalling the
+// same value again produces no operations and changing one field produces operations
+// for that field only. Nested shared types are updated in place rather than replaced;
+// texts and arrays are updated by deleting and inserting the part between their
+// common prefix and suffix. All changes are made in one transaction, which holds the
+// lock of the document like Transact, so Marshal must not be called inside
+// Transact, View or observers.
+func Marshal(v interface{}, ymap *core.YMap) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return errors.New("ycs: cannot marshal a nil pointer")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("ycs: cannot marshal %s, expected a struct", rv.Type())
+	}
+	if ymap.GetDoc() == nil {
+		return errNotIntegrated
+	}
+
+	// Fail before anything is changed
+	if err := checkType(rv.Type(), structField{}, nil); err != nil {
+		return err
+	}
+
+	var err error
+	ymap.GetDoc().Transact(func(tr contracts.ITransaction) {
+		err = marshalStruct(rv, ymap)
+	}, nil, true)
+	return err
+}
+
+// checkType returns an error if values of the type cannot be marshalled
+func checkType(t reflect.Type, field structField, seen map[reflect.Type]bool) error {
+	if field.json {
+		return nil
+	}
+	if field.text {
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.String {
+			return fmt.Errorf("ycs: cannot store %s as text", t)
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return checkType(t.Elem(), field, seen)
+	case reflect.Struct:
+		if storesAsJSON(t) {
+			return nil
+		}
+		if seen[t] {
+			return nil
+		}
+		if seen == nil {
+			seen = make(map[reflect.Type]bool)
+		}
+		seen[t] = true
+		for _, f := range structFields(t) {
+			if err := checkType(t.FieldByIndex(f.index).Type, f, seen); err != nil {
+				return fmt.Errorf("%s: %w", f.name, err)
+			}
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		if t == byteSliceType {
+			return nil
+		}
+		return checkType(t.Elem(), structField{}, seen)
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return fmt.Errorf("ycs: cannot store %s, map keys must be strings", t)
+		}
+		return checkType(t.Elem(), structField{}, seen)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.String, reflect.Bool, reflect.Interface:
+		return nil
+	}
+	return fmt.Errorf("ycs: cannot store %s", t)
+}
+
+// storesAsJSON returns whether values of the type are stored as JSON, e.g. time.Time
+func storesAsJSON(t reflect.Type) bool {
+	return t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType)
+}
+
+// marshalStruct stores the fields of a struct in the map
+func marshalStruct(v reflect.Value, ymap *core.YMap) error {
+	for _, f := range structFields(v.Type()) {
+		fv, err := v.FieldByIndexErr(f.index)
+		if err != nil {
+			// A field promoted through a nil embedded pointer
+			fv = reflect.Value{}
+		}
+		if err := marshalEntry(ymap, f.key, fv, f); err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+// marshalEntry stores a value under the key of the map, if it differs from the stored value.
+// An invalid value, a nil pointer and, with omitempty, a zero value delete the key.
+func marshalEntry(ymap *core.YMap, key string, v reflect.Value, field structField) error {
+	for v.IsValid() && v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v = reflect.Value{}
+			break
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || (field.omitEmpty && v.IsZero()) {
+		if ymap.ContainsKey(key) {
+			ymap.Delete(key)
+		}
+		return nil
+	}
+
+	current := ymap.Get(key)
+	exists := ymap.ContainsKey(key)
+
+	switch {
+	case field.json || (v.Kind() == reflect.Struct && storesAsJSON(v.Type())):
+		stored, err := encodeJSON(v)
+		if err != nil {
+			return err
+		}
+		setIfChanged(ymap, key, current, exists, stored)
+	case field.text:
+		if text, ok := current.(*core.YText); ok {
+			text.SetFromString(v.String())
+		} else {
+			ymap.Set(key, core.NewYText(v.String()))
+		}
+	case v.Kind() == reflect.Struct:
+		nested, ok := current.(*core.YMap)
+		if !ok {
+			nested = core.NewYMap(nil)
+			ymap.Set(key, nested)
+		}
+		return marshalStruct(v, nested)
+	case v.Kind() == reflect.Map:
+		nested, ok := current.(*core.YMap)
+		if !ok {
+			nested = core.NewYMap(nil)
+			ymap.Set(key, nested)
+		}
+		return marshalMap(v, nested)
+	case (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type() != byteSliceType:
+		array, ok := current.(*core.YArray)
+		if !ok {
+			array = core.NewYArray(nil)
+			ymap.Set(key, array)
+		}
+		return marshalArray(v, array)
+	default:
+		stored, err := encodeValue(v)
+		if err != nil {
+			return err
+		}
+		setIfChanged(ymap, key, current, exists, stored)
+	}
+	return nil
+}
+
+// setIfChanged sets the key unless it already holds the value
+func setIfChanged(ymap *core.YMap, key string, current interface{}, exists bool, stored interface{}) {
+	if exists && reflect.DeepEqual(current, stored) {
+		return
+	}
+	ymap.Set(key, stored)
+}
+
+// encodeJSON encodes a value as JSONCodec does
+func encodeJSON(v reflect.Value) (interface{}, error) {
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	var stored interface{}
+	err = json.Unmarshal(data, &stored)
+	return stored, err
+}
+
+// marshalMap stores the entries of a Go map in the map and deletes the other keys
+func marshalMap(v reflect.Value, ymap *core.YMap) error {
+	for _, key := range ymap.Keys() {
+		if !v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())).IsValid() {
+			ymap.Delete(key)
+		}
+	}
+
+	iter := v.MapRange()
+	for iter.Next() {
+		key := iter.Key().String()
+		if err := marshalEntry(ymap, key, iter.Value(), structField{}); err != nil {
+			return fmt.Errorf("%q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// isShared returns whether values of the type are stored as shared types
+func isShared(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return !storesAsJSON(t)
+	case reflect.Map:
+		return true
+	case reflect.Slice, reflect.Array:
+		return t != byteSliceType
+	}
+	return false
+}
+
+// marshalArray stores the elements of a slice or array in the array. Shared elements
+// are updated in place by index; other elements are compared and only the range
+// between the common prefix and suffix is replaced.
+func marshalArray(v reflect.Value, array *core.YArray) error {
+	if isShared(v.Type().Elem()) {
+		return marshalSharedElements(v, array)
+	}
+
+	elements := make([]interface{}, v.Len())
+	for i := range elements {
+		stored, err := encodeValue(v.Index(i))
+		if err != nil {
+			return fmt.Errorf("index %d: %w", i, err)
+		}
+		elements[i] = stored
+	}
+
+	current := array.ToArray()
+	prefix := 0
+	for prefix < len(current) && prefix < len(elements) && reflect.DeepEqual(current[prefix], elements[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(current)-prefix && suffix < len(elements)-prefix &&
+		reflect.DeepEqual(current[len(current)-1-suffix], elements[len(elements)-1-suffix]) {
+		suffix++
+	}
+
+	if deleted := len(current) - prefix - suffix; deleted > 0 {
+		array.Delete(prefix, deleted)
+	}
+	if inserted := elements[prefix : len(elements)-suffix]; len(inserted) > 0 {
+		array.Insert(prefix, inserted)
+	}
+	return nil
+}
+
+// marshalSharedElements updates the shared elements of the array by index, replaces
+// the elements of another type and appends or deletes the rest
+func marshalSharedElements(v reflect.Value, array *core.YArray) error {
+	length := v.Len()
+	if current := array.GetLength(); current > length {
+		array.Delete(length, current-length)
+	}
+
+	for i := 0; i < length; i++ {
+		element := v.Index(i)
+		for element.Kind() == reflect.Pointer && !element.IsNil() {
+			element = element.Elem()
+		}
+
+		var current interface{}
+		if i < array.GetLength() {
+			current = array.Get(i)
+		}
+		if element.Kind() == reflect.Pointer {
+			// A nil element is stored as null
+			if current != nil || i >= array.GetLength() {
+				replaceElement(array, i, nil)
+			}
+			continue
+		}
+
+		var err error
+		switch element.Kind() {
+		case reflect.Struct, reflect.Map:
+			nested, ok := current.(*core.YMap)
+			if !ok {
+				nested = core.NewYMap(nil)
+				replaceElement(array, i, nested)
+			}
+			if element.Kind() == reflect.Struct {
+				err = marshalStruct(element, nested)
+			} else {
+				err = marshalMap(element, nested)
+			}
+		default:
+			nested, ok := current.(*core.YArray)
+			if !ok {
+				nested = core.NewYArray(nil)
+				replaceElement(array, i, nested)
+			}
+			err = marshalArray(element, nested)
+		}
+		if err != nil {
+			return fmt.Errorf("index %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// replaceElement stores the element at index, replacing the element there if there is one
+func replaceElement(array *core.YArray, index int, element interface{}) {
+	if index < array.GetLength() {
+		array.Delete(index, 1)
+	}
+	array.Insert(index, []interface{}{element})
+}
+
+// Unmarshal reads the fields of the struct v points to from a map, as Marshal stores
+// them. Fields whose key is not set are left unchanged. A field whose stored value
+// does not fit is left unchanged as well; the other fields are still read and the
+// returned error, which wraps ErrTypeMismatch, reports the first such field.
+func Unmarshal(ymap *core.YMap, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("ycs: cannot unmarshal into %T, expected a non-nil pointer", v)
+	}
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("ycs: cannot unmarshal into %s, expected a struct", rv.Type())
+	}
+
+	return unmarshalStruct(ymap, rv)
+}
+
+// unmarshalStruct reads the fields of a struct from the map
+func unmarshalStruct(ymap *core.YMap, v reflect.Value) error {
+	var firstErr error
+	for _, f := range structFields(v.Type()) {
+		if !ymap.ContainsKey(f.key) {
+			continue
+		}
+		fv, err := v.FieldByIndexErr(f.index)
+		if err != nil {
+			// Allocate the nil embedded pointer the field is promoted through
+			fv = fieldByIndexAlloc(v, f.index)
+		}
+		if err := unmarshalValue(ymap.Get(f.key), fv, f); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	return firstErr
+}
+
+// fieldByIndexAlloc returns the nested field, allocating nil embedded pointers on the way
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// unmarshalValue reads a stored value into target. target is only changed if the
+// stored value fits, except for the elements and entries of nested shared types,
+// which are read as far as they fit.
+func unmarshalValue(stored interface{}, target reflect.Value, field structField) error {
+	if target.Kind() == reflect.Pointer {
+		if stored == nil {
+			target.SetZero()
+			return nil
+		}
+		value := reflect.New(target.Type().Elem())
+		if !target.IsNil() {
+			value.Elem().Set(target.Elem())
+		}
+		if err := unmarshalValue(stored, value.Elem(), field); err != nil {
+			return err
+		}
+		target.Set(value)
+		return nil
+	}
+
+	mismatch := func() error {
+		return fmt.Errorf("%w: cannot use %T as %s", ErrTypeMismatch, stored, target.Type())
+	}
+
+	switch {
+	case target.Kind() == reflect.Interface:
+		if stored == nil {
+			target.SetZero()
+			return nil
+		}
+		if text, ok := stored.(*core.YText); ok {
+			stored = text.ToString()
+		}
+		if !reflect.TypeOf(stored).AssignableTo(target.Type()) {
+			return mismatch()
+		}
+		target.Set(reflect.ValueOf(stored))
+	case field.json || (target.Kind() == reflect.Struct && storesAsJSON(target.Type())):
+		data, err := json.Marshal(stored)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrTypeMismatch, err)
+		}
+		value := reflect.New(target.Type())
+		if err := json.Unmarshal(data, value.Interface()); err != nil {
+			return fmt.Errorf("%w: %v", ErrTypeMismatch, err)
+		}
+		target.Set(value.Elem())
+	case target.Kind() == reflect.String:
+		// Texts are read into strings whether or not the field is tagged "text"
+		if text, ok := stored.(*core.YText); ok {
+			target.SetString(text.ToString())
+			return nil
+		}
+		return decodeValue(stored, target)
+	case target.Kind() == reflect.Struct:
+		nested, ok := stored.(*core.YMap)
+		if !ok {
+			return mismatch()
+		}
+		return unmarshalStruct(nested, target)
+	case target.Kind() == reflect.Map:
+		nested, ok := stored.(*core.YMap)
+		if !ok {
+			return mismatch()
+		}
+		return unmarshalMap(nested, target)
+	case (target.Kind() == reflect.Slice || target.Kind() == reflect.Array) && target.Type() != byteSliceType:
+		var elements []interface{}
+		switch array := stored.(type) {
+		case *core.YArray:
+			elements = array.ToArray()
+		case []interface{}:
+			elements = array
+		default:
+			return mismatch()
+		}
+		return unmarshalElements(elements, target)
+	default:
+		return decodeValue(stored, target)
+	}
+	return nil
+}
+
+// unmarshalMap reads the entries of the map into a Go map, replacing its entries
+func unmarshalMap(ymap *core.YMap, target reflect.Value) error {
+	var firstErr error
+	result := reflect.MakeMapWithSize(target.Type(), ymap.GetCount())
+	for key, stored := range ymap.Entries() {
+		value := reflect.New(target.Type().Elem()).Elem()
+		if err := unmarshalValue(stored, value, structField{}); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%q: %w", key, err)
+			}
+			continue
+		}
+		result.SetMapIndex(reflect.ValueOf(key).Convert(target.Type().Key()), value)
+	}
+	target.Set(result)
+	return firstErr
+}
+
+// unmarshalElements reads the elements of an array into a slice or array. Elements
+// that do not fit are left zero.
+func unmarshalElements(elements []interface{}, target reflect.Value) error {
+	result := target
+	if target.Kind() == reflect.Slice {
+		result = reflect.MakeSlice(target.Type(), len(elements), len(elements))
+	}
+
+	var firstErr error
+	for i, stored := range elements {
+		if i >= result.Len() {
+			break
+		}
+		if err := unmarshalValue(stored, result.Index(i), structField{}); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("index %d: %w", i, err)
+		}
+	}
+	if target.Kind() == reflect.Slice {
+		target.Set(result)
+	}
+	return firstErr
+}
@@ -0,0 +1,185 @@
+package ycs
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"ycs/contracts"
+	"ycs/core"
+)
+
+type address struct {
+	City string `ycs:"city"`
+}
+
+type profile struct {
+	Name    string            `ycs:"name"`
+	Bio     string            `ycs:"bio,text"`
+	Age     int               `ycs:"age,omitempty"`
+	Tags    []string          `ycs:"tags"`
+	Address address           `ycs:"address"`
+	Links   map[string]string `ycs:"links"`
+	Seen    time.Time         `ycs:"seen"`
+	Extra   map[string]int    `ycs:"extra,json"`
+	Skipped string            `ycs:"-"`
+}
+
+// clock returns the number of structs the client of the document created
+func clock(t *testing.T, doc *core.YDoc) int64 {
+	t.Helper()
+	sv, err := core.DecodeStateVector(bytes.NewReader(doc.EncodeStateVectorV2()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sv[int64(doc.GetClientID())]
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	doc := core.NewYDoc(contracts.YDocOptions{})
+	ymap := doc.GetMap("profile").(*core.YMap)
+	in := profile{
+		Name:    "Ada",
+		Bio:     "Mathematician",
+		Age:     36,
+		Tags:    []string{"math", "engines"},
+		Address: address{City: "London"},
+		Links:   map[string]string{"home": "https://example.com"},
+		Seen:    time.Date(1843, 9, 1, 0, 0, 0, 0, time.UTC),
+		Extra:   map[string]int{"notes": 7},
+		Skipped: "not stored",
+	}
+	if err := Marshal(&in, ymap); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ymap.Get("bio").(*core.YText); !ok {
+		t.Fatalf("bio is stored as %T", ymap.Get("bio"))
+	}
+	if ymap.ContainsKey("Skipped") {
+		t.Fatal("field tagged - is stored")
+	}
+
+	// The stored state syncs to other documents
+	update, err := doc.EncodeStateAsUpdateV2()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := core.NewYDoc(contracts.YDocOptions{})
+	if err := remote.ApplyUpdateV2(update, nil); err != nil {
+		t.Fatal(err)
+	}
+	var out profile
+	if err := Unmarshal(remote.GetMap("profile").(*core.YMap), &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Skipped = ""
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("got %+v, want %+v", out, in)
+	}
+}
+
+func TestMarshalChangesOnlyWhatDiffers(t *testing.T) {
+	doc := core.NewYDoc(contracts.YDocOptions{})
+	ymap := doc.GetMap("profile").(*core.YMap)
+	value := profile{Name: "Ada", Bio: "Mathematician", Tags: []string{"a", "b", "c"}, Address: address{City: "London"}}
+	if err := Marshal(value, ymap); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := 0
+	defer doc.OnUpdateV2(func([]byte, interface{}, contracts.ITransaction) {
+		updates++
+	})()
+
+	before := clock(t, doc)
+	if err := Marshal(value, ymap); err != nil {
+		t.Fatal(err)
+	}
+	if updates != 0 || clock(t, doc) != before {
+		t.Fatalf("marshalling the same value made %d updates", updates)
+	}
+
+	bio := ymap.Get("bio")
+	nested := ymap.Get("address")
+	value.Bio = "Mathematician and writer"
+	value.Tags = []string{"a", "x", "c"}
+	if err := Marshal(value, ymap); err != nil {
+		t.Fatal(err)
+	}
+	if updates != 1 {
+		t.Fatalf("got %d updates, want one transaction", updates)
+	}
+	if ymap.Get("bio") != bio || ymap.Get("address") != nested {
+		t.Fatal("nested shared types were replaced")
+	}
+	// " and writer" is one insertion, "x" another
+	if got := clock(t, doc) - before; got != int64(len(" and writer")+1) {
+		t.Fatalf("clock advanced by %d", got)
+	}
+	if got := ymap.Get("tags").(*core.YArray).ToArray(); !reflect.DeepEqual(got, []interface{}{"a", "x", "c"}) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestMarshalDeletesOmittedFields(t *testing.T) {
+	doc := core.NewYDoc(contracts.YDocOptions{})
+	ymap := doc.GetMap("profile").(*core.YMap)
+	if err := Marshal(profile{Age: 36, Links: map[string]string{"a": "1", "b": "2"}}, ymap); err != nil {
+		t.Fatal(err)
+	}
+	if err := Marshal(profile{Links: map[string]string{"b": "2"}}, ymap); err != nil {
+		t.Fatal(err)
+	}
+	if ymap.ContainsKey("age") {
+		t.Fatal("zero value of an omitempty field is kept")
+	}
+	if got := ymap.Get("links").(*core.YMap).Keys(); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Fatalf("got keys %v", got)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	doc := core.NewYDoc(contracts.YDocOptions{})
+	ymap := doc.GetMap("m").(*core.YMap)
+
+	if err := Marshal((*profile)(nil), ymap); err == nil {
+		t.Error("no error for a nil pointer")
+	}
+	if err := Marshal(42, ymap); err == nil {
+		t.Error("no error for a value that is not a struct")
+	}
+	if err := Marshal(profile{}, core.NewYMap(nil)); !errors.Is(err, errNotIntegrated) {
+		t.Errorf("got %v, want errNotIntegrated", err)
+	}
+
+	type unsupported struct {
+		Name string
+		Ch   chan int
+	}
+	if err := Marshal(unsupported{Name: "x"}, ymap); err == nil {
+		t.Error("no error for a channel")
+	}
+	if ymap.ContainsKey("Name") {
+		t.Error("a value was stored before the error")
+	}
+}
+
+func TestUnmarshalMismatch(t *testing.T) {
+	doc := core.NewYDoc(contracts.YDocOptions{})
+	ymap := doc.GetMap("profile").(*core.YMap)
+	ymap.Set("name", int64(1))
+	ymap.Set("age", int64(36))
+
+	out := profile{Name: "unchanged"}
+	if err := Unmarshal(ymap, &out); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("got %v, want ErrTypeMismatch", err)
+	}
+	if out.Name != "unchanged" || out.Age != 36 {
+		t.Fatalf("got %+v", out)
+	}
+	if err := Unmarshal(ymap, out); err == nil {
+		t.Fatal("no error for a value that is not a pointer")
+	}
+}
@@ -0,0 +1,207 @@
+package ycs
+
+import (
+	"fmt"
+
+	"ycs/core"
+)
+
+// TypedMap is a YMap whose values are converted to and from V by a codec
+type TypedMap[V any] struct {
+	ymap  *core.YMap
+	codec Codec[V]
+}
+
+// NewTypedMap wraps a map; a nil codec uses ValueCodec
+func NewTypedMap[V any](ymap *core.YMap, codec Codec[V]) *TypedMap[V] {
+	if codec == nil {
+		codec = ValueCodec[V]()
+	}
+	return &TypedMap[V]{ymap: ymap, codec: codec}
+}
+
+// NewMap creates a map that is not part of a document yet, e.g. to be stored in
+// another map with MapCodec
+func NewMap[V any](codec Codec[V]) *TypedMap[V] {
+	return NewTypedMap(core.NewYMap(nil), codec)
+}
+
+// GetYMap returns the wrapped map
+func (m *TypedMap[V]) GetYMap() *core.YMap {
+	return m.ymap
+}
+
+// Get returns the value of the key. ok is false if the key is not set; err wraps
+// ErrTypeMismatch if the stored value cannot be converted.
+func (m *TypedMap[V]) Get(key string) (value V, ok bool, err error) {
+	ok = m.ymap.ContainsKey(key)
+	if !ok {
+		return value, false, nil
+	}
+
+	value, err = m.codec.Decode(m.ymap.Get(key))
+	if err != nil {
+		return value, true, fmt.Errorf("key %q: %w", key, err)
+	}
+	return value, true, nil
+}
+
+// Set sets the value of the key
+func (m *TypedMap[V]) Set(key string, value V) error {
+	stored, err := m.codec.Encode(value)
+	if err != nil {
+		return err
+	}
+	m.ymap.Set(key, stored)
+	return nil
+}
+
+// Delete deletes the key
+func (m *TypedMap[V]) Delete(key string) {
+	m.ymap.Delete(key)
+}
+
+// ContainsKey returns whether the key is set
+func (m *TypedMap[V]) ContainsKey(key string) bool {
+	return m.ymap.ContainsKey(key)
+}
+
+// Keys returns the keys of the map
+func (m *TypedMap[V]) Keys() []string {
+	return m.ymap.Keys()
+}
+
+// GetCount returns the number of entries
+func (m *TypedMap[V]) GetCount() int {
+	return m.ymap.GetCount()
+}
+
+// Entries returns the entries of the map. Entries that cannot be converted are
+// left out; the error reports one of them.
+func (m *TypedMap[V]) Entries() (map[string]V, error) {
+	var firstErr error
+	entries := make(map[string]V)
+	for key, stored := range m.ymap.Entries() {
+		value, err := m.codec.Decode(stored)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("key %q: %w", key, err)
+			}
+			continue
+		}
+		entries[key] = value
+	}
+	return entries, firstErr
+}
+
+// TypedArray is a YArray whose elements are converted to and from T by a codec
+type TypedArray[T any] struct {
+	yarray *core.YArray
+	codec  Codec[T]
+}
+
+// NewTypedArray wraps an array; a nil codec uses ValueCodec
+func NewTypedArray[T any](yarray *core.YArray, codec Codec[T]) *TypedArray[T] {
+	if codec == nil {
+		codec = ValueCodec[T]()
+	}
+	return &TypedArray[T]{yarray: yarray, codec: codec}
+}
+
+// NewArray creates an array that is not part of a document yet, e.g. to be stored
+// in a map with ArrayCodec
+func NewArray[T any](codec Codec[T]) *TypedArray[T] {
+	return NewTypedArray(core.NewYArray(nil), codec)
+}
+
+// GetYArray returns the wrapped array
+func (a *TypedArray[T]) GetYArray() *core.YArray {
+	return a.yarray
+}
+
+// GetLength returns the number of elements
+func (a *TypedArray[T]) GetLength() int {
+	return a.yarray.GetLength()
+}
+
+// Get returns the element at index. ok is false if index is out of range; err wraps
+// ErrTypeMismatch if the stored element cannot be converted.
+func (a *TypedArray[T]) Get(index int) (value T, ok bool, err error) {
+	ok = index >= 0 && index < a.yarray.GetLength()
+	if !ok {
+		return value, false, nil
+	}
+
+	value, err = a.codec.Decode(a.yarray.Get(index))
+	if err != nil {
+		return value, true, fmt.Errorf("index %d: %w", index, err)
+	}
+	return value, true, nil
+}
+
+// Insert inserts values at index
+func (a *TypedArray[T]) Insert(index int, values ...T) error {
+	stored, err := a.encode(values)
+	if err != nil {
+		return err
+	}
+	a.yarray.Insert(index, stored)
+	return nil
+}
+
+// Add appends values to the end of the array
+func (a *TypedArray[T]) Add(values ...T) error {
+	stored, err := a.encode(values)
+	if err != nil {
+		return err
+	}
+	a.yarray.Add(stored)
+	return nil
+}
+
+// Unshift prepends values to the beginning of the array
+func (a *TypedArray[T]) Unshift(values ...T) error {
+	stored, err := a.encode(values)
+	if err != nil {
+		return err
+	}
+	a.yarray.Unshift(stored)
+	return nil
+}
+
+// Delete deletes length elements starting at index
+func (a *TypedArray[T]) Delete(index int, length int) {
+	a.yarray.Delete(index, length)
+}
+
+// ToSlice returns the elements of the array. Elements that cannot be converted
+// are left out; the error reports the first of them.
+func (a *TypedArray[T]) ToSlice() ([]T, error) {
+	var firstErr error
+	stored := a.yarray.ToArray()
+	values := make([]T, 0, len(stored))
+	for index, element := range stored {
+		value, err := a.codec.Decode(element)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("index %d: %w", index, err)
+			}
+			continue
+		}
+		values = append(values, value)
+	}
+	return values, firstErr
+}
+
+// encode encodes values for storing them, failing before any is stored
+func (a *TypedArray[T]) encode(values []T) ([]interface{}, error) {
+	stored := make([]interface{}, len(values))
+	for i, value := range values {
+		encoded, err := a.codec.Encode(value)
+		if err != nil {
+			return nil, err
+		}
+		stored[i] = encoded
+	}
+	return stored, nil
+}
@@ -2,8 +2,6 @@ package main
 
 import (
 	"sync"
-	"ycs/content"
-	"ycs/contracts"
 	"ycs/core"
 )
 
@@ -24,21 +22,7 @@ func Initialize() {
 		return
 	}
 
-	// Register type readers directly in ContentType to match C# implementation
-	content.RegisterTypeReader(0, func(decoder contracts.IUpdateDecoder) contracts.IAbstractType {
-		return core.ReadYArray(decoder)
-	}) // YArray
-	content.RegisterTypeReader(1, func(decoder contracts.IUpdateDecoder) contracts.IAbstractType {
-		return core.ReadYMap(decoder)
-	}) // YMap
-	content.RegisterTypeReader(2, func(decoder contracts.IUpdateDecoder) contracts.IAbstractType {
-		return core.ReadYText(decoder)
-	}) // YText
-
-	// Set document factory
-	content.SetDocFactory(func(opts *contracts.YDocOptions) contracts.IYDoc {
-		return core.NewYDoc(*opts)
-	})
+	core.Initialize()
 
 	bootstrap.initialized = true
 }
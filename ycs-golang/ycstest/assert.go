@@ -0,0 +1,175 @@
+package ycstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"ycs/contracts"
+	"ycs/core"
+)
+
+// AssertConverged fails the test unless all peers have the same state. Call
+// SyncAll first to deliver everything that is in flight.
+func (n *Network) AssertConverged(t testing.TB) {
+	t.Helper()
+
+	if err := n.CheckConverged(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// CheckConverged compares every peer with the first one and returns an error on
+// the first difference. Peers are compared by the JSON view of their shared types,
+// their snapshot (state vector and delete set) and their structs. Garbage collected
+// ranges may be split differently on every peer, so they are compared merged rather
+// than by the bytes of EncodeStateAsUpdateV2.
+func (n *Network) CheckConverged() error {
+	if len(n.peers) == 0 {
+		return nil
+	}
+
+	n.realizeSharedTypes()
+
+	first := n.peers[0]
+	firstJSON, err := JSONView(first.doc)
+	if err != nil {
+		return fmt.Errorf("peer 0: %w", err)
+	}
+	firstSnapshot := first.doc.CreateSnapshot().EncodeSnapshotV2()
+	firstStructs := structView(first.doc)
+
+	for _, p := range n.peers[1:] {
+		view, err := JSONView(p.doc)
+		if err != nil {
+			return fmt.Errorf("peer %d: %w", p.index, err)
+		}
+		if view != firstJSON {
+			return fmt.Errorf("peer %d diverged from peer 0:\n  peer 0:  %s\n  peer %d: %s", p.index, firstJSON, p.index, view)
+		}
+
+		if snapshot := p.doc.CreateSnapshot().EncodeSnapshotV2(); !bytes.Equal(snapshot, firstSnapshot) {
+			return fmt.Errorf("peer %d has a different snapshot than peer 0:\n  peer 0:  %x\n  peer %d: %x", p.index, firstSnapshot, p.index, snapshot)
+		}
+
+		if structs := structView(p.doc); structs != firstStructs {
+			return fmt.Errorf("peer %d has different structs than peer 0:\npeer 0:\n%speer %d:\n%s", p.index, firstStructs, p.index, structs)
+		}
+	}
+	return nil
+}
+
+// structView lists the structs of doc by client, merging adjacent garbage collected ranges
+func structView(doc *core.YDoc) string {
+	clients := doc.GetStore().GetClients()
+	ids := make([]int64, 0, len(clients))
+	for client := range clients {
+		ids = append(ids, client)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var sb strings.Builder
+	for _, client := range ids {
+		gcStart, gcEnd := int64(-1), int64(-1)
+		flushGC := func() {
+			if gcStart >= 0 {
+				fmt.Fprintf(&sb, "  %d:%d gc %d\n", client, gcStart, gcEnd-gcStart)
+				gcStart = -1
+			}
+		}
+
+		for _, s := range clients[client] {
+			id := s.GetID()
+			if s.IsGC() {
+				if gcStart < 0 || gcEnd != id.Clock {
+					flushGC()
+					gcStart = id.Clock
+				}
+				gcEnd = id.Clock + int64(s.GetLength())
+				continue
+			}
+
+			flushGC()
+			fmt.Fprintf(&sb, "  %d:%d item %d deleted=%v content=%d origin=%s rightOrigin=%s parentSub=%q\n",
+				client, id.Clock, s.GetLength(), s.GetDeleted(), s.GetContent().GetRef(),
+				formatID(s.GetLeftOrigin()), formatID(s.GetRightOrigin()), s.GetParentSub())
+		}
+		flushGC()
+	}
+	return sb.String()
+}
+
+// formatID formats an optional struct ID
+func formatID(id *contracts.StructID) string {
+	if id == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", id.Client, id.Clock)
+}
+
+// JSONView returns the shared types of doc as JSON, with root types in name order.
+// Texts are rendered as strings, arrays as lists and maps as objects.
+func JSONView(doc *core.YDoc) (string, error) {
+	share := doc.GetShare()
+	names := make([]string, 0, len(share))
+	for name := range share {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	view := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		view[name] = jsonValue(share[name])
+	}
+
+	data, err := json.Marshal(view)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// jsonValue converts shared types inside value to plain values
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case contracts.IYText:
+		return v.ToString()
+	case contracts.IYArray:
+		values := v.ToArray()
+		for i, c := range values {
+			values[i] = jsonValue(c)
+		}
+		return values
+	case contracts.IYMap:
+		values := v.GetEnumerator()
+		for key, c := range values {
+			values[key] = jsonValue(c)
+		}
+		return values
+	case contracts.IYDoc:
+		return map[string]interface{}{"guid": v.GetGuid()}
+	case *core.AbstractType:
+		// A root type that no peer has defined yet
+		return nil
+	default:
+		return value
+	}
+}
+
+// realizeSharedTypes defines every root type that some peer has defined on all
+// peers, so that types only known from remote updates are compared by their content
+func (n *Network) realizeSharedTypes() {
+	for _, definer := range n.peers {
+		for name, t := range definer.doc.GetShare() {
+			if _, isPlain := t.(*core.AbstractType); isPlain {
+				continue
+			}
+
+			for _, p := range n.peers {
+				p.doc.Get(name, t.InternalCopy)
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package ycstest
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// FuzzOptions configures FuzzSeed
+type FuzzOptions struct {
+	// Peers is the number of peers, 3 if zero
+	Peers int
+	// Ops is the number of generated operations, 200 if zero
+	Ops int
+	// Network configures the network; its seed is replaced by the fuzzed seed
+	Network Options
+}
+
+func (o FuzzOptions) withDefaults() FuzzOptions {
+	if o.Peers == 0 {
+		o.Peers = 3
+	}
+	if o.Ops == 0 {
+		o.Ops = 200
+	}
+	return o
+}
+
+// RunOps applies ops to a new network of peers, checking that the peers
+// converge after every flush and after the last operation. A panic while
+// applying an operation is reported as an error too.
+func RunOps(peers int, opts Options, ops []Op) (err error) {
+	n := NewNetwork(peers, opts)
+
+	i := 0
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("op %d (%v) panicked: %v", i, ops[i], rec)
+		}
+	}()
+
+	for ; i < len(ops); i++ {
+		n.Apply(ops[i])
+
+		if ops[i].Kind == OpFlush {
+			if err := n.CheckConverged(); err != nil {
+				return fmt.Errorf("after op %d: %w", i, err)
+			}
+		}
+	}
+
+	n.SyncAll()
+	if err := n.CheckConverged(); err != nil {
+		return fmt.Errorf("after the final flush: %w", err)
+	}
+	return nil
+}
+
+// Shrink returns a smallest subsequence of ops for which fails still returns
+// true, removing chunks of halving size and then single operations until no
+// operation can be removed. fails must return true for ops.
+func Shrink(ops []Op, fails func([]Op) bool) []Op {
+	for chunk := len(ops) / 2; chunk >= 1; chunk /= 2 {
+		for start := 0; start < len(ops); {
+			end := min(start+chunk, len(ops))
+			candidate := append(append(make([]Op, 0, len(ops)-(end-start)), ops[:start]...), ops[end:]...)
+			if fails(candidate) {
+				ops = candidate
+				continue
+			}
+			start = end
+		}
+	}
+	return ops
+}
+
+// FuzzSeed generates a random operation log from seed and runs it. If the peers
+// do not converge, the log is shrunk and the test fails with the smallest log
+// that still fails, which RunOps replays with the same seed.
+func FuzzSeed(t testing.TB, seed int64, opts FuzzOptions) {
+	t.Helper()
+
+	opts = opts.withDefaults()
+	opts.Network.Seed = seed
+
+	ops := GenerateOps(rand.New(rand.NewSource(seed)), opts.Peers, opts.Ops)
+	err := RunOps(opts.Peers, opts.Network, ops)
+	if err == nil {
+		return
+	}
+
+	minimal := Shrink(ops, func(candidate []Op) bool {
+		return RunOps(opts.Peers, opts.Network, candidate) != nil
+	})
+	t.Fatalf("seed %d: %v\nshrunk from %d to %d ops: %v\n%s",
+		seed, err, len(ops), len(minimal), RunOps(opts.Peers, opts.Network, minimal), FormatOps(minimal))
+}
@@ -0,0 +1,45 @@
+package ycstest
+
+import (
+	"reflect"
+	"testing"
+	"ycs/contracts"
+)
+
+// FuzzConvergence checks that peers converge for random operation logs
+func FuzzConvergence(f *testing.F) {
+	for seed := int64(0); seed < 20; seed++ {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, seed int64) {
+		FuzzSeed(t, seed, FuzzOptions{Network: Options{
+			DropRate:   0.1,
+			Reorder:    seed%2 == 0,
+			DocOptions: contracts.YDocOptions{Gc: seed%3 == 0},
+		}})
+	})
+}
+
+func TestShrinkFindsMinimalLog(t *testing.T) {
+	ops := make([]Op, 20)
+	for i := range ops {
+		ops[i] = Op{Kind: OpFlush, Peer: i}
+	}
+
+	// The log fails as long as it still contains the ops of peers 3 and 11
+	fails := func(candidate []Op) bool {
+		found := 0
+		for _, op := range candidate {
+			if op.Peer == 3 || op.Peer == 11 {
+				found++
+			}
+		}
+		return found == 2
+	}
+
+	got := Shrink(ops, fails)
+	if want := []Op{ops[3], ops[11]}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
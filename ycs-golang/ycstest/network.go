@@ -0,0 +1,269 @@
+// Package ycstest simulates a network of documents for testing convergence.
+//
+// A Network wires N core.YDoc peers together with the y-protocols sync messages.
+// Messages are queued per link and only delivered when the test flushes them, in
+// a random order drawn from a seeded generator, so every run is reproducible.
+// Peers can be disconnected, split into partitions, and messages can be dropped;
+// SyncAll brings every peer back and exchanges the state until it converges.
+//
+// GenerateOps draws a random operation log from a seed and RunOps replays it on a
+// network; FuzzSeed does both and shrinks the log of a failing seed to the
+// smallest one that still fails.
+package ycstest
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"ycs/contracts"
+	"ycs/protocols"
+)
+
+// Options configures a Network
+type Options struct {
+	// Seed seeds the generator that picks the delivery order and the dropped messages
+	Seed int64
+	// DocOptions are the options of every peer's document
+	DocOptions contracts.YDocOptions
+	// DropRate is the probability in [0, 1] that a message is lost in flight
+	DropRate float64
+	// Reorder delivers the messages of a link in random order instead of first in, first out
+	Reorder bool
+}
+
+// Network connects a set of peers and controls the delivery of the messages between them
+type Network struct {
+	opts      Options
+	prng      *rand.Rand
+	peers     []*Peer
+	partition []int
+	dropped   int
+}
+
+// NewNetwork creates a network of n connected peers. Peer i uses client ID i.
+func NewNetwork(n int, opts Options) *Network {
+	network := &Network{
+		opts:      opts,
+		prng:      rand.New(rand.NewSource(opts.Seed)),
+		partition: make([]int, n),
+	}
+
+	for i := 0; i < n; i++ {
+		network.peers = append(network.peers, newPeer(network, i, opts.DocOptions))
+	}
+	for _, p := range network.peers {
+		p.receiving = make([][][]byte, n)
+	}
+	for _, p := range network.peers {
+		p.Connect()
+	}
+
+	return network
+}
+
+// GetPeers returns all peers
+func (n *Network) GetPeers() []*Peer {
+	return n.peers
+}
+
+// GetPeer returns the peer at index i
+func (n *Network) GetPeer(i int) *Peer {
+	return n.peers[i]
+}
+
+// GetRand returns the seeded generator of the network, for tests that want to
+// draw their operations from the same seed
+func (n *Network) GetRand() *rand.Rand {
+	return n.prng
+}
+
+// GetDropped returns the number of messages dropped so far
+func (n *Network) GetDropped() int {
+	return n.dropped
+}
+
+// SetDropRate sets the probability that a message is lost in flight
+func (n *Network) SetDropRate(dropRate float64) {
+	n.opts.DropRate = dropRate
+}
+
+// Pending returns the number of messages in flight
+func (n *Network) Pending() int {
+	pending := 0
+	for _, p := range n.peers {
+		pending += p.Pending()
+	}
+	return pending
+}
+
+// Partition splits the network into groups of peer indices. Peers only reach the
+// peers of their own group; messages in flight between groups are lost. Peers
+// that are not listed form one more group.
+func (n *Network) Partition(groups ...[]int) {
+	for i := range n.partition {
+		n.partition[i] = 0
+	}
+	for g, group := range groups {
+		for _, i := range group {
+			n.partition[i] = g + 1
+		}
+	}
+
+	for _, receiver := range n.peers {
+		for _, sender := range n.peers {
+			if !n.reachable(sender, receiver) {
+				receiver.receiving[sender.index] = nil
+			}
+		}
+	}
+}
+
+// Heal removes all partitions. Online peers that were separated exchange their
+// state vectors again.
+func (n *Network) Heal() {
+	separated := make([]bool, len(n.peers))
+	for i, group := range n.partition {
+		separated[i] = group != n.partition[0]
+	}
+	for i := range n.partition {
+		n.partition[i] = 0
+	}
+
+	for i, p := range n.peers {
+		if separated[i] && p.online {
+			p.handshake()
+		}
+	}
+}
+
+// FlushNext delivers one random message and queues the reply, if any. It
+// returns false if there was no message to deliver.
+func (n *Network) FlushNext() bool {
+	var receivers []*Peer
+	for _, p := range n.peers {
+		if p.online && p.Pending() > 0 {
+			receivers = append(receivers, p)
+		}
+	}
+	if len(receivers) == 0 {
+		return false
+	}
+
+	receiver := receivers[n.prng.Intn(len(receivers))]
+
+	var senders []int
+	for i, messages := range receiver.receiving {
+		if len(messages) > 0 {
+			senders = append(senders, i)
+		}
+	}
+	sender := n.peers[senders[n.prng.Intn(len(senders))]]
+
+	n.deliver(sender, receiver)
+	return true
+}
+
+// FlushAll delivers messages until none are left. It returns whether any message was delivered.
+func (n *Network) FlushAll() bool {
+	didSomething := false
+	for n.FlushNext() {
+		didSomething = true
+	}
+	return didSomething
+}
+
+// ReconnectAll connects every peer
+func (n *Network) ReconnectAll() {
+	for _, p := range n.peers {
+		p.Connect()
+	}
+}
+
+// DisconnectAll disconnects every peer
+func (n *Network) DisconnectAll() {
+	for _, p := range n.peers {
+		p.Disconnect()
+	}
+}
+
+// DisconnectRandom disconnects a random online peer. It returns false if no peer is online.
+func (n *Network) DisconnectRandom() bool {
+	var online []*Peer
+	for _, p := range n.peers {
+		if p.online {
+			online = append(online, p)
+		}
+	}
+	if len(online) == 0 {
+		return false
+	}
+
+	online[n.prng.Intn(len(online))].Disconnect()
+	return true
+}
+
+// ReconnectRandom connects a random offline peer. It returns false if every peer is online.
+func (n *Network) ReconnectRandom() bool {
+	var offline []*Peer
+	for _, p := range n.peers {
+		if !p.online {
+			offline = append(offline, p)
+		}
+	}
+	if len(offline) == 0 {
+		return false
+	}
+
+	offline[n.prng.Intn(len(offline))].Connect()
+	return true
+}
+
+// SyncAll heals all partitions, connects every peer and delivers all messages
+// without dropping any, so that every peer ends up with the same state
+func (n *Network) SyncAll() {
+	dropRate := n.opts.DropRate
+	n.opts.DropRate = 0
+	defer func() {
+		n.opts.DropRate = dropRate
+	}()
+
+	n.Heal()
+	n.ReconnectAll()
+
+	// Messages lost earlier are recovered by a fresh handshake of every peer
+	for _, p := range n.peers {
+		p.handshake()
+	}
+	n.FlushAll()
+}
+
+// reachable returns whether messages from sender reach receiver
+func (n *Network) reachable(sender, receiver *Peer) bool {
+	return receiver.online && n.partition[sender.index] == n.partition[receiver.index]
+}
+
+// shouldDrop draws whether the next message is lost
+func (n *Network) shouldDrop() bool {
+	return n.opts.DropRate > 0 && n.prng.Float64() < n.opts.DropRate
+}
+
+// deliver applies the next message from sender to receiver and queues the reply
+func (n *Network) deliver(sender, receiver *Peer) {
+	messages := receiver.receiving[sender.index]
+
+	i := 0
+	if n.opts.Reorder {
+		i = n.prng.Intn(len(messages))
+	}
+	m := messages[i]
+	receiver.receiving[sender.index] = append(messages[:i:i], messages[i+1:]...)
+
+	var reply bytes.Buffer
+	if _, err := protocols.ReadSyncMessage(bytes.NewReader(m), &reply, receiver.doc, n); err != nil {
+		panic(fmt.Sprintf("ycstest: peer %d failed to read message from peer %d: %v", receiver.index, sender.index, err))
+	}
+
+	if reply.Len() > 0 && n.reachable(receiver, sender) {
+		sender.receive(reply.Bytes(), receiver)
+	}
+}
@@ -0,0 +1,39 @@
+package ycstest
+
+import (
+	"testing"
+	"ycs/contracts"
+)
+
+func TestNetworkConvergesAfterPartition(t *testing.T) {
+	n := NewNetwork(3, Options{Seed: 1, Reorder: true})
+	n.Partition([]int{0}, []int{1, 2})
+
+	n.GetPeer(0).GetDoc().GetText("t").Insert(0, "left")
+	n.GetPeer(1).GetDoc().GetText("t").Insert(0, "right")
+	n.GetPeer(2).GetDoc().GetArray("a").Insert(0, []interface{}{int64(1)})
+	n.FlushAll()
+
+	if got := n.GetPeer(0).GetDoc().GetText("t").ToString(); got != "left" {
+		t.Fatalf("partitioned peer saw %q", got)
+	}
+
+	n.Heal()
+	n.SyncAll()
+	n.AssertConverged(t)
+}
+
+func TestNetworkConvergesWithDroppedMessages(t *testing.T) {
+	n := NewNetwork(4, Options{Seed: 2, DropRate: 0.3, DocOptions: contracts.YDocOptions{Gc: true}})
+	for i := 0; i < 50; i++ {
+		p := n.GetPeer(i % 4)
+		m := p.GetDoc().GetMap("m")
+		m.Set("k", int64(i))
+		n.FlushNext()
+	}
+	n.SyncAll()
+	if n.GetDropped() == 0 {
+		t.Fatalf("no message was dropped")
+	}
+	n.AssertConverged(t)
+}
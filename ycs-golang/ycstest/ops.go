@@ -0,0 +1,405 @@
+package ycstest
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"ycs/contracts"
+	"ycs/core"
+)
+
+// OpKind identifies the kind of a random operation
+type OpKind int
+
+const (
+	OpTextInsert OpKind = iota
+	OpTextDelete
+	OpTextFormat
+	OpTextEmbed
+	OpArrayInsert
+	OpArrayInsertType
+	OpArrayDelete
+	OpMapSet
+	OpMapSetType
+	OpMapDelete
+	OpTextSet
+	OpTextReplace
+	OpArrayMove
+	OpDeliver
+	OpFlush
+	OpDisconnect
+	OpReconnect
+	OpPartition
+	OpHeal
+	opKindCount
+)
+
+var opKindNames = [...]string{
+	OpTextInsert:      "TextInsert",
+	OpTextDelete:      "TextDelete",
+	OpTextFormat:      "TextFormat",
+	OpTextEmbed:       "TextEmbed",
+	OpArrayInsert:     "ArrayInsert",
+	OpArrayInsertType: "ArrayInsertType",
+	OpArrayDelete:     "ArrayDelete",
+	OpMapSet:          "MapSet",
+	OpMapSetType:      "MapSetType",
+	OpMapDelete:       "MapDelete",
+	OpTextSet:         "TextSet",
+	OpTextReplace:     "TextReplace",
+	OpArrayMove:       "ArrayMove",
+	OpDeliver:         "Deliver",
+	OpFlush:           "Flush",
+	OpDisconnect:      "Disconnect",
+	OpReconnect:       "Reconnect",
+	OpPartition:       "Partition",
+	OpHeal:            "Heal",
+}
+
+// String returns the name of the kind
+func (k OpKind) String() string {
+	if k >= 0 && k < opKindCount {
+		return opKindNames[k]
+	}
+	return fmt.Sprintf("OpKind(%d)", int(k))
+}
+
+// Root type names used by the generated operations
+const (
+	RootText  = "text"
+	RootArray = "array"
+	RootMap   = "map"
+)
+
+// Op is a random operation on one peer or on the network. Indices and lengths are
+// reduced to the current size of their target when the operation is applied, so
+// any subsequence of a generated log is still a valid log.
+type Op struct {
+	Kind OpKind
+	Peer int
+	// Target selects the shared type among the peer's types of the matching kind,
+	// in document order starting with the root type
+	Target int
+	Index  int
+	Length int
+	Key    string
+	// Value is the inserted text or value, the name of the nested type to create
+	// ("text", "array" or "map"), the attributes of a format, or the index an
+	// array move moves to
+	Value interface{}
+}
+
+// String returns a readable form of the operation
+func (op Op) String() string {
+	switch op.Kind {
+	case OpDeliver:
+		return fmt.Sprintf("Deliver(%d)", op.Length)
+	case OpFlush, OpHeal:
+		return op.Kind.String() + "()"
+	case OpPartition:
+		return fmt.Sprintf("Partition(%b)", op.Index)
+	case OpDisconnect, OpReconnect:
+		return fmt.Sprintf("peer %d: %s()", op.Peer, op.Kind)
+	case OpMapSet, OpMapSetType, OpMapDelete:
+		return fmt.Sprintf("peer %d: %s(target=%d, key=%q, value=%#v)", op.Peer, op.Kind, op.Target, op.Key, op.Value)
+	case OpTextReplace:
+		return fmt.Sprintf("peer %d: %s(target=%d, old=%q, new=%#v)", op.Peer, op.Kind, op.Target, op.Key, op.Value)
+	default:
+		return fmt.Sprintf("peer %d: %s(target=%d, index=%d, length=%d, value=%#v)", op.Peer, op.Kind, op.Target, op.Index, op.Length, op.Value)
+	}
+}
+
+// FormatOps returns the operations one per line
+func FormatOps(ops []Op) string {
+	var sb strings.Builder
+	for i, op := range ops {
+		fmt.Fprintf(&sb, "%4d  %s\n", i, op)
+	}
+	return sb.String()
+}
+
+// opWeights is the relative frequency of every kind in generated logs
+var opWeights = [opKindCount]int{
+	OpTextInsert:      8,
+	OpTextDelete:      4,
+	OpTextFormat:      3,
+	OpTextEmbed:       1,
+	OpArrayInsert:     6,
+	OpArrayInsertType: 2,
+	OpArrayDelete:     3,
+	OpMapSet:          5,
+	OpMapSetType:      2,
+	OpMapDelete:       2,
+	OpTextSet:         2,
+	OpTextReplace:     2,
+	OpArrayMove:       3,
+	OpDeliver:         6,
+	OpFlush:           1,
+	OpDisconnect:      1,
+	OpReconnect:       1,
+	OpPartition:       1,
+	OpHeal:            1,
+}
+
+var (
+	opTexts      = []string{"a", "bc", "def", "xyz", "ü", "😀", "\n"}
+	opKeys       = []string{"a", "b", "c", "d"}
+	opAttributes = []string{"bold", "italic", "color"}
+	opTypeNames  = []string{RootText, RootArray, RootMap}
+)
+
+// GenerateOps draws count random operations for a network of peers
+func GenerateOps(prng *rand.Rand, peers, count int) []Op {
+	total := 0
+	for _, w := range opWeights {
+		total += w
+	}
+
+	ops := make([]Op, 0, count)
+	for i := 0; i < count; i++ {
+		kind := OpKind(0)
+		for r := prng.Intn(total); r >= opWeights[kind]; kind++ {
+			r -= opWeights[kind]
+		}
+
+		op := Op{
+			Kind:   kind,
+			Peer:   prng.Intn(peers),
+			Target: prng.Intn(4),
+			Index:  prng.Intn(1 << 16),
+			Length: 1 + prng.Intn(4),
+			Key:    opKeys[prng.Intn(len(opKeys))],
+		}
+
+		switch kind {
+		case OpTextInsert, OpTextSet:
+			op.Value = opTexts[prng.Intn(len(opTexts))]
+		case OpTextReplace:
+			// An empty replacement deletes the matches
+			op.Value = ""
+			if prng.Intn(4) != 0 {
+				op.Value = opTexts[prng.Intn(len(opTexts))]
+			}
+		case OpTextFormat:
+			// A nil value removes the attribute
+			var value interface{}
+			switch prng.Intn(3) {
+			case 0:
+				value = true
+			case 1:
+				value = opKeys[prng.Intn(len(opKeys))]
+			}
+			op.Value = map[string]interface{}{opAttributes[prng.Intn(len(opAttributes))]: value}
+		case OpTextEmbed:
+			op.Value = map[string]interface{}{"image": fmt.Sprintf("%d.png", i)}
+		case OpArrayInsert:
+			if prng.Intn(2) == 0 {
+				op.Value = i
+			} else {
+				op.Value = fmt.Sprintf("v%d", i)
+			}
+		case OpMapSet:
+			switch prng.Intn(3) {
+			case 0:
+				op.Value = i
+			case 1:
+				op.Value = fmt.Sprintf("v%d", i)
+			default:
+				op.Value = prng.Intn(2) == 0
+			}
+		case OpArrayInsertType, OpMapSetType:
+			op.Value = opTypeNames[prng.Intn(len(opTypeNames))]
+		case OpArrayMove:
+			op.Value = prng.Intn(1 << 16)
+		case OpDeliver:
+			op.Length = 1 + prng.Intn(8)
+		case OpPartition:
+			op.Index = prng.Intn(1 << peers)
+		}
+
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// Apply applies the operation to the network
+func (n *Network) Apply(op Op) {
+	switch op.Kind {
+	case OpDeliver:
+		for i := 0; i < op.Length; i++ {
+			if !n.FlushNext() {
+				break
+			}
+		}
+		return
+	case OpFlush:
+		n.SyncAll()
+		return
+	case OpPartition:
+		var groups [2][]int
+		for i := range n.peers {
+			groups[op.Index>>i&1] = append(groups[op.Index>>i&1], i)
+		}
+		n.Partition(groups[0], groups[1])
+		return
+	case OpHeal:
+		n.Heal()
+		return
+	}
+
+	p := n.peers[op.Peer%len(n.peers)]
+	switch op.Kind {
+	case OpDisconnect:
+		p.Disconnect()
+		return
+	case OpReconnect:
+		p.Connect()
+		return
+	}
+
+	texts, arrays, maps := sharedTypes(p.doc)
+	switch op.Kind {
+	case OpTextInsert, OpTextDelete, OpTextFormat, OpTextEmbed, OpTextSet, OpTextReplace:
+		text := texts[op.Target%len(texts)]
+		length := text.GetLength()
+		lowSurrogates := textLowSurrogates(text)
+		switch op.Kind {
+		case OpTextSet:
+			// Replaces characters of the string of the text, which leaves out embeds
+			chars := []rune(text.ToString())
+			index := op.Index % (len(chars) + 1)
+			end := min(index+op.Length, len(chars))
+			text.SetFromString(string(chars[:index]) + op.Value.(string) + string(chars[end:]))
+		case OpTextReplace:
+			if _, err := text.ReplaceAll(op.Key, op.Value.(string), nil); err != nil {
+				panic(fmt.Sprintf("ycstest: %v", err))
+			}
+		case OpTextInsert:
+			text.Insert(charBoundary(lowSurrogates, op.Index%(length+1)), op.Value.(string))
+		case OpTextEmbed:
+			text.InsertEmbed(charBoundary(lowSurrogates, op.Index%(length+1)), op.Value)
+		case OpTextDelete, OpTextFormat:
+			if length == 0 {
+				break
+			}
+			index := charBoundary(lowSurrogates, op.Index%length)
+			end := min(index+op.Length, length)
+			if lowSurrogates[end] {
+				end++
+			}
+			if op.Kind == OpTextDelete {
+				text.Delete(index, end-index)
+			} else {
+				text.Format(index, end-index, op.Value.(map[string]interface{}))
+			}
+		}
+
+	case OpArrayInsert, OpArrayInsertType, OpArrayDelete, OpArrayMove:
+		array := arrays[op.Target%len(arrays)]
+		length := array.GetLength()
+		switch op.Kind {
+		case OpArrayInsert:
+			array.Insert(op.Index%(length+1), []interface{}{op.Value})
+		case OpArrayInsertType:
+			array.Insert(op.Index%(length+1), []interface{}{newSharedType(op.Value.(string))})
+		case OpArrayDelete:
+			if length > 0 {
+				index := op.Index % length
+				array.Delete(index, min(op.Length, length-index))
+			}
+		case OpArrayMove:
+			if length > 0 {
+				from := op.Index % length
+				count := min(op.Length, length-from)
+				array.MoveRange(from, count, op.Value.(int)%(length-count+1))
+			}
+		}
+
+	case OpMapSet:
+		maps[op.Target%len(maps)].Set(op.Key, op.Value)
+	case OpMapSetType:
+		maps[op.Target%len(maps)].Set(op.Key, newSharedType(op.Value.(string)))
+	case OpMapDelete:
+		maps[op.Target%len(maps)].Delete(op.Key)
+
+	default:
+		panic(fmt.Sprintf("ycstest: unknown operation %v", op.Kind))
+	}
+}
+
+// newSharedType creates an empty shared type by name
+func newSharedType(name string) contracts.IAbstractType {
+	switch name {
+	case RootText:
+		return core.NewYText("")
+	case RootArray:
+		return core.NewYArray(nil)
+	default:
+		return core.NewYMap(nil)
+	}
+}
+
+// sharedTypes returns the root types of the operations followed by the types
+// nested in them, in document order
+func sharedTypes(doc *core.YDoc) (texts []contracts.IYText, arrays []contracts.IYArray, maps []contracts.IYMap) {
+	var visit func(value interface{})
+	visit = func(value interface{}) {
+		switch v := value.(type) {
+		case contracts.IYText:
+			texts = append(texts, v)
+		case contracts.IYArray:
+			arrays = append(arrays, v)
+			for _, c := range v.ToArray() {
+				visit(c)
+			}
+		case contracts.IYMap:
+			maps = append(maps, v)
+			entries := v.GetEnumerator()
+			keys := make([]string, 0, len(entries))
+			for key := range entries {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				visit(entries[key])
+			}
+		}
+	}
+
+	visit(doc.GetText(RootText))
+	visit(doc.GetArray(RootArray))
+	visit(doc.GetMap(RootMap))
+	return texts, arrays, maps
+}
+
+// textLowSurrogates marks the UTF-16 positions of text that hold the second half
+// of a surrogate pair, with one more entry for the end of the text
+func textLowSurrogates(text contracts.IYText) []bool {
+	var low []bool
+	for _, d := range text.ToDelta(nil, nil, nil) {
+		str, ok := d.Insert.(string)
+		if !ok {
+			// Embeds have a length of 1
+			low = append(low, false)
+			continue
+		}
+		for _, r := range str {
+			low = append(low, false)
+			if utf16.RuneLen(r) == 2 {
+				low = append(low, true)
+			}
+		}
+	}
+	return append(low, false)
+}
+
+// charBoundary moves index off the middle of a surrogate pair. Indices that split
+// a pair are invalid: the split replaces both halves on the local peer only.
+func charBoundary(lowSurrogates []bool, index int) int {
+	if lowSurrogates[index] {
+		return index - 1
+	}
+	return index
+}
@@ -0,0 +1,135 @@
+package ycstest
+
+import (
+	"bytes"
+	"ycs/contracts"
+	"ycs/core"
+	"ycs/protocols"
+)
+
+// Peer is a document connected to a Network. Every local update of the document
+// is broadcast to the peers it can reach.
+type Peer struct {
+	network *Network
+	index   int
+	doc     *core.YDoc
+	online  bool
+
+	// receiving holds the messages in flight to this peer, one queue per sender index
+	receiving [][][]byte
+}
+
+// newPeer creates the peer at index with client ID index
+func newPeer(network *Network, index int, opts contracts.YDocOptions) *Peer {
+	p := &Peer{
+		network: network,
+		index:   index,
+		doc:     core.NewYDoc(opts),
+	}
+	p.doc.SetClientID(index)
+
+	// Setup observe on local model.
+	p.doc.OnUpdateV2(func(update []byte, origin interface{}, transaction contracts.ITransaction) {
+		if origin == network {
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := protocols.WriteUpdate(&buf, update); err != nil {
+			panic(err)
+		}
+		p.broadcast(buf.Bytes())
+	})
+
+	return p
+}
+
+// GetDoc returns the document of the peer
+func (p *Peer) GetDoc() *core.YDoc {
+	return p.doc
+}
+
+// GetIndex returns the index of the peer in the network
+func (p *Peer) GetIndex() int {
+	return p.index
+}
+
+// IsOnline returns whether the peer is connected
+func (p *Peer) IsOnline() bool {
+	return p.online
+}
+
+// Connect connects the peer and exchanges SyncStep1 messages with every peer it can reach
+func (p *Peer) Connect() {
+	if p.online {
+		return
+	}
+	p.online = true
+	p.handshake()
+}
+
+// Disconnect disconnects the peer. Messages in flight to it are lost.
+func (p *Peer) Disconnect() {
+	p.online = false
+	p.clearReceiving()
+}
+
+// Pending returns the number of messages in flight to the peer
+func (p *Peer) Pending() int {
+	n := 0
+	for _, messages := range p.receiving {
+		n += len(messages)
+	}
+	return n
+}
+
+// handshake publishes the state vector of the peer and queues the state
+// vectors of all reachable peers for it
+func (p *Peer) handshake() {
+	var buf bytes.Buffer
+	if err := protocols.WriteSyncStep1(&buf, p.doc); err != nil {
+		panic(err)
+	}
+	p.broadcast(buf.Bytes())
+
+	for _, remote := range p.network.peers {
+		if remote == p || !p.network.reachable(p, remote) {
+			continue
+		}
+
+		buf.Reset()
+		if err := protocols.WriteSyncStep1(&buf, remote.doc); err != nil {
+			panic(err)
+		}
+		p.receive(bytes.Clone(buf.Bytes()), remote)
+	}
+}
+
+// broadcast sends data to every peer reachable from this one
+func (p *Peer) broadcast(data []byte) {
+	if !p.online {
+		return
+	}
+
+	for _, remote := range p.network.peers {
+		if remote != p && p.network.reachable(p, remote) {
+			remote.receive(bytes.Clone(data), p)
+		}
+	}
+}
+
+// receive queues a message from sender, unless the network drops it
+func (p *Peer) receive(data []byte, sender *Peer) {
+	if p.network.shouldDrop() {
+		p.network.dropped++
+		return
+	}
+	p.receiving[sender.index] = append(p.receiving[sender.index], data)
+}
+
+// clearReceiving drops all messages in flight to the peer
+func (p *Peer) clearReceiving() {
+	for i := range p.receiving {
+		p.receiving[i] = nil
+	}
+}